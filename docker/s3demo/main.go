@@ -1,5 +1,5 @@
 // Package main, along with the various *.go.html files, demonstrates a very
-// simple (and ugly) asset server that reads all S3 assets in a given region
+// simple (and ugly) asset server that browses the S3 assets in a given region
 // and bucket, and serves up HTML pages which point to a IIIF server (RAIS, of
 // course) for thumbnails and full-image views.
 package main
@@ -8,11 +8,13 @@
 	"fmt"
 	"html/template"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
@@ -23,9 +25,18 @@ type asset struct {
 	Title  string
 }
 
-var emptyAsset asset
+// pageSize is how many objects are requested from S3 at a time, and roughly
+// how many assets are shown on each page of the index
+const pageSize = 60
 
-var s3assets []asset
+// listing is a single page of a bucket listing
+type listing struct {
+	Assets    []asset
+	Prefixes  []string
+	NextToken string
+}
+
+var svc *s3.S3
 var indexT, assetT, adminT *template.Template
 var s3url, zone, bucket string
 var keyID, secretKey string
@@ -54,12 +65,12 @@ func main() {
 		os.Exit(1)
 	}
 
-	readAssets()
+	connect()
 	preptemplates()
 	serve()
 }
 
-func readAssets() {
+func connect() {
 	var conf = &aws.Config{
 		Region:           &zone,
 		Endpoint:         aws.String(s3url),
@@ -70,21 +81,78 @@ func readAssets() {
 		log.Println("Error trying to instantiate a new AWS session: ", err)
 		os.Exit(1)
 	}
-	var svc = s3.New(sess)
+	svc = s3.New(sess)
+}
 
-	var out *s3.ListObjectsOutput
-	out, err = svc.ListObjects(&s3.ListObjectsInput{Bucket: &bucket})
-	if err != nil {
-		log.Println("Error trying to list objects: ", err)
-		os.Exit(1)
+func newAsset(key string) asset {
+	var id = url.PathEscape(fmt.Sprintf("s3://%s/%s", bucket, key))
+	return asset{Title: key, Key: key, IIIFID: id}
+}
+
+// listAssets returns a page of the objects under prefix, starting at the
+// given continuation token.  Without a search, objects in "subdirectories"
+// are grouped into prefixes, the same way the S3 console does it.  With a
+// search, everything under prefix is scanned for keys containing the search
+// term, one S3 page after another until at least a page of matches is found,
+// so a page of results may hold somewhat more than pageSize assets.
+func listAssets(prefix, search, token string) (*listing, error) {
+	var l = new(listing)
+	var input = &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int64(pageSize),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	if search == "" {
+		input.Delimiter = aws.String("/")
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
 	}
 
-	for _, obj := range out.Contents {
-		var key = *obj.Key
-		var id = url.PathEscape(fmt.Sprintf("s3://%s/%s", bucket, key))
-		s3assets = append(s3assets, asset{Title: key, Key: key, IIIFID: id})
+	var term = strings.ToLower(search)
+	for {
+		var out, err = svc.ListObjectsV2(input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range out.CommonPrefixes {
+			l.Prefixes = append(l.Prefixes, *p.Prefix)
+		}
+		for _, obj := range out.Contents {
+			var key = *obj.Key
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			if term == "" || strings.Contains(strings.ToLower(key), term) {
+				l.Assets = append(l.Assets, newAsset(key))
+			}
+		}
+
+		l.NextToken = ""
+		if !aws.BoolValue(out.IsTruncated) {
+			return l, nil
+		}
+		l.NextToken = aws.StringValue(out.NextContinuationToken)
+		if term == "" || len(l.Assets) >= pageSize {
+			return l, nil
+		}
+		input.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+// assetExists returns true if key is an object in the bucket
+func assetExists(key string) (bool, error) {
+	var _, err = svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err == nil {
+		return true, nil
+	}
+	if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotFound {
+		return false, nil
 	}
-	log.Printf("Indexed %d assets", len(s3assets))
+	return false, err
 }
 
 func preptemplates() {
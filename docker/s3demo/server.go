@@ -23,8 +23,19 @@ func serve() {
 }
 
 type indexData struct {
-	Bucket string
-	Assets []asset
+	Bucket  string
+	Prefix  string
+	Parent  string
+	Search  string
+	Token   string
+	Listing *listing
+}
+
+// parentPrefix returns the prefix one "directory" above p, e.g., "maps/" for
+// "maps/1901/"
+func parentPrefix(p string) string {
+	var i = strings.LastIndex(strings.TrimSuffix(p, "/"), "/")
+	return p[:i+1]
 }
 
 func renderIndex(w http.ResponseWriter, req *http.Request) {
@@ -33,8 +44,25 @@ func renderIndex(w http.ResponseWriter, req *http.Request) {
 		http.NotFound(w, req)
 		return
 	}
-	var data = indexData{Assets: s3assets, Bucket: bucket}
-	var err = indexT.Execute(w, data)
+
+	var q = req.URL.Query()
+	var data = indexData{
+		Bucket: bucket,
+		Prefix: q.Get("prefix"),
+		Search: strings.TrimSpace(q.Get("q")),
+		Token:  q.Get("token"),
+	}
+	data.Parent = parentPrefix(data.Prefix)
+
+	var err error
+	data.Listing, err = listAssets(data.Prefix, data.Search, data.Token)
+	if err != nil {
+		log.Printf("Unable to list objects: %s", err)
+		http.Error(w, "Unable to list bucket", http.StatusInternalServerError)
+		return
+	}
+
+	err = indexT.Execute(w, data)
 	if err != nil {
 		log.Printf("Unable to serve index: %s", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
@@ -43,27 +71,7 @@ func renderIndex(w http.ResponseWriter, req *http.Request) {
 }
 
 func findAssetKey(req *http.Request) string {
-	var p = req.URL.RawPath
-	if p == "" {
-		p = req.URL.Path
-	}
-	var parts = strings.Split(p, "/")
-	if len(parts) < 3 {
-		log.Printf("Invalid path parts %#v", parts)
-		return ""
-	}
-
-	return strings.Join(parts[2:], "/")
-}
-
-func findAsset(key string) asset {
-	for _, a2 := range s3assets {
-		if a2.Key == key {
-			return a2
-		}
-	}
-
-	return emptyAsset
+	return strings.TrimPrefix(req.URL.Path, "/asset/")
 }
 
 func renderAsset(w http.ResponseWriter, req *http.Request) {
@@ -73,14 +81,19 @@ func renderAsset(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var a = findAsset(key)
-	if a == emptyAsset {
+	var ok, err = assetExists(key)
+	if err != nil {
+		log.Printf("Unable to look up asset %q: %s", key, err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
 		log.Printf("Invalid asset key %q", key)
 		http.Error(w, fmt.Sprintf("Asset %q doesn't exist", key), http.StatusNotFound)
 		return
 	}
 
-	var err = assetT.Execute(w, map[string]interface{}{"Asset": a})
+	err = assetT.Execute(w, map[string]interface{}{"Asset": newAsset(key)})
 	if err != nil {
 		log.Printf("Unable to serve asset page: %s", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
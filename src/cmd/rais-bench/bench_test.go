@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestLayoutFromInfo(t *testing.T) {
+	var l = layoutFromInfo(&iiif.Info{Width: 3000, Height: 1000})
+	assert.Equal(512, l.TileWidth, "default tile width", t)
+	assert.Equal(512, l.TileHeight, "default tile height", t)
+	assert.Equal("[1 2 4 8]", fmt.Sprint(l.ScaleFactors), "zooms out until one tile holds the image", t)
+
+	l = layoutFromInfo(&iiif.Info{Width: 3000, Height: 1000, Tiles: []iiif.TileSize{{Width: 256, ScaleFactors: []int{1, 2}}}})
+	assert.Equal(256, l.TileHeight, "square tiles when only a width is given", t)
+	assert.Equal(2, len(l.ScaleFactors), "advertised scale factors are kept", t)
+}
+
+func TestDeepZoomSession(t *testing.T) {
+	var l = imageLayout{Width: 5000, Height: 3000, TileWidth: 512, TileHeight: 512, ScaleFactors: []int{1, 2, 4, 8}}
+	var paths = deepZoomSession("a/b.jp2", l, 1024, 768, rand.New(rand.NewSource(1)))
+	assert.Equal("a%2Fb.jp2/info.json", paths[0], "info.json first", t)
+	assert.Equal("a%2Fb.jp2/full/!200,200/0/default.jpg", paths[1], "then a thumbnail", t)
+	assert.True(len(paths) > 10, "tiles at every zoom level", t)
+
+	for _, p := range paths[2:] {
+		var u, err = iiif.NewURL(p)
+		assert.NilError(err, "valid tile request "+p, t)
+		assert.Equal(iiif.ID("a/b.jp2"), u.ID, "ID", t)
+		assert.Equal(0, int(u.Region.X)%512, "tiles are on the grid "+p, t)
+		assert.True(int(u.Region.X+u.Region.W) <= l.Width, "tiles stay on the image "+p, t)
+	}
+
+	var again = deepZoomSession("a/b.jp2", l, 1024, 768, rand.New(rand.NewSource(1)))
+	assert.Equal(strings.Join(paths, " "), strings.Join(again, " "), "sessions repeat with the same seed", t)
+}
+
+func TestReadAccessLog(t *testing.T) {
+	var log = strings.Join([]string{
+		`{"method":"GET","path":"/iiif/a/b.jp2/info.json","status":200}`,
+		`{"method":"GET","path":"/iiif/a/b.jp2/0,0,512,512/512,/0/default.jpg"}`,
+		`{"method":"HEAD","path":"/iiif/a.jp2/info.json"}`,
+		`{"method":"GET","path":"/admin/stats.json"}`,
+		`{"method":"GET","path":"/iiif/a.jp2/bogus/512,/0/default.jpg"}`,
+		`not json`,
+	}, "\n")
+	var paths, err = readAccessLog(strings.NewReader(log), "/iiif")
+	assert.NilError(err, "reading the log", t)
+	assert.Equal(2, len(paths), "only valid IIIF GETs are kept", t)
+	assert.Equal("a%2Fb.jp2/info.json", paths[0], "info request", t)
+	assert.Equal("a%2Fb.jp2/0,0,512,512/512,/0/default.jpg", paths[1], "IDs are escaped again", t)
+}
+
+func TestSummarize(t *testing.T) {
+	var results []result
+	for i := 1; i <= 100; i++ {
+		results = append(results, result{duration: time.Duration(i) * time.Millisecond, status: 200, bytes: 10})
+	}
+	results[0].status = 404
+
+	var s = summarize(results)
+	assert.Equal(100, s.count, "count", t)
+	assert.Equal(1, s.failures, "failures", t)
+	assert.Equal(int64(1000), s.bytes, "bytes", t)
+	assert.Equal(50*time.Millisecond, s.p50, "p50", t)
+	assert.Equal(99*time.Millisecond, s.p99, "p99", t)
+	assert.Equal(100*time.Millisecond, s.max, "max", t)
+	assert.Equal(time.Duration(0), summarize(nil).p50, "no results", t)
+}
+
+func TestHitRate(t *testing.T) {
+	assert.Equal("25.0% (1 of 4)", hitRate(cacheCounts{GetCount: 10, GetHits: 5}, cacheCounts{GetCount: 14, GetHits: 6}), "hits between snapshots", t)
+	assert.Equal("no lookups", hitRate(cacheCounts{}, cacheCounts{}), "no lookups", t)
+}
@@ -0,0 +1,175 @@
+// rais-bench measures how quickly RAIS serves a realistic load, so operators
+// can size hardware and compare settings.  It replays either synthetic
+// deep-zoom viewing sessions or a recorded RAIS access log, against a running
+// server or straight through the decode pipeline, and reports latency
+// percentiles, throughput, and (given the server's stats.json URL) cache hit
+// rates.
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"rais/src/iiif"
+	"sync"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var opts struct {
+	URL         string   `short:"u" long:"url" description:"base IIIF URL of a running server, e.g., http://localhost:12415/iiif"`
+	DirectPath  string   `short:"d" long:"direct" description:"decode JP2s under this directory in-process instead of using a server"`
+	IDs         []string `short:"i" long:"id" description:"image ID to view in synthetic deep-zoom sessions (may be repeated)"`
+	Log         string   `short:"l" long:"log" description:"RAIS access log to replay instead of synthetic sessions"`
+	LogPrefix   string   `long:"log-prefix" default:"/iiif" description:"IIIF path prefix used in the access log"`
+	Sessions    int      `short:"n" long:"sessions" default:"10" description:"number of synthetic sessions to run"`
+	Concurrency int      `short:"c" long:"concurrency" default:"4" description:"number of simultaneous viewers (or replay workers)"`
+	Viewport    string   `long:"viewport" default:"1280x800" description:"viewer size for synthetic sessions"`
+	Seed        int64    `long:"seed" default:"1" description:"random seed, so synthetic sessions can be repeated exactly"`
+	Stats       string   `long:"stats" description:"server's stats.json URL, e.g., http://localhost:12416/admin/stats.json, for cache hit rates"`
+}
+
+func main() {
+	var parser = flags.NewParser(&opts, flags.Default)
+	var _, err = parser.Parse()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	if (opts.URL == "") == (opts.DirectPath == "") {
+		usage(parser, "exactly one of --url or --direct is required")
+	}
+	if (opts.Log == "") == (len(opts.IDs) == 0) {
+		usage(parser, "either --log or at least one --id is required, but not both")
+	}
+	if opts.Concurrency < 1 || opts.Sessions < 1 {
+		usage(parser, "--concurrency and --sessions must be positive")
+	}
+
+	var t target
+	if opts.URL != "" {
+		t = newHTTPTarget(opts.URL)
+	} else {
+		t = newDirectTarget(opts.DirectPath)
+	}
+
+	var jobs [][]string
+	if opts.Log != "" {
+		jobs, err = replayJobs(opts.Log, opts.LogPrefix)
+	} else {
+		jobs, err = sessionJobs(t)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	var before, after *serverStats
+	if opts.Stats != "" {
+		before = mustFetchStats(opts.Stats)
+	}
+	var start = time.Now()
+	var results = run(t, jobs, opts.Concurrency)
+	var elapsed = time.Since(start)
+	if opts.Stats != "" {
+		after = mustFetchStats(opts.Stats)
+	}
+
+	report(os.Stdout, results, elapsed, before, after)
+}
+
+func usage(parser *flags.Parser, msg string) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n\n", msg)
+	parser.WriteHelp(os.Stderr)
+	os.Exit(1)
+}
+
+func mustFetchStats(url string) *serverStats {
+	var s, err = fetchStats(url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading stats: %s\n", err)
+		os.Exit(1)
+	}
+	return &s
+}
+
+// replayJobs reads the access log, making each request its own job so the
+// workers replay them roughly in order
+func replayJobs(fname, prefix string) ([][]string, error) {
+	var f, err = os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	paths, err = readAccessLog(f, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no IIIF requests under %q in %s", prefix, fname)
+	}
+
+	var jobs = make([][]string, len(paths))
+	for i, p := range paths {
+		jobs[i] = []string{p}
+	}
+	return jobs, nil
+}
+
+// sessionJobs generates the synthetic sessions, cycling through the IDs
+func sessionJobs(t target) ([][]string, error) {
+	var vw, vh int
+	var _, err = fmt.Sscanf(opts.Viewport, "%dx%d", &vw, &vh)
+	if err != nil || vw < 1 || vh < 1 {
+		return nil, fmt.Errorf("invalid viewport %q (must look like 1280x800)", opts.Viewport)
+	}
+
+	var layouts = make([]imageLayout, len(opts.IDs))
+	for i, id := range opts.IDs {
+		layouts[i], err = t.layout(iiif.ID(id))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rng = rand.New(rand.NewSource(opts.Seed))
+	var jobs [][]string
+	for i := 0; i < opts.Sessions; i++ {
+		var n = i % len(opts.IDs)
+		jobs = append(jobs, deepZoomSession(iiif.ID(opts.IDs[n]), layouts[n], vw, vh, rng))
+	}
+	return jobs, nil
+}
+
+// run sends each job's requests in order, running up to concurrency jobs at
+// once, and returns every request's result
+func run(t target, jobs [][]string, concurrency int) []result {
+	var queue = make(chan []string)
+	var m sync.Mutex
+	var results []result
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				for _, path := range job {
+					var r = t.get(path)
+					m.Lock()
+					results = append(results, r)
+					m.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"rais/src/iiif"
+	"strings"
+)
+
+// logLine is the part of a RAIS access log entry we need to replay it
+type logLine struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// readAccessLog returns the IIIF requests in a RAIS access log (one JSON
+// object per line), relative to the IIIF prefix.  The log holds unescaped
+// paths, so each ID is escaped again in case it contains slashes.  Lines
+// which aren't GETs of valid IIIF paths under prefix are skipped.
+func readAccessLog(r io.Reader, prefix string) ([]string, error) {
+	var paths []string
+	var s = bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	prefix = strings.TrimRight(prefix, "/") + "/"
+	for s.Scan() {
+		var l logLine
+		if json.Unmarshal(s.Bytes(), &l) != nil || l.Method != "GET" || !strings.HasPrefix(l.Path, prefix) {
+			continue
+		}
+
+		var u, err = iiif.NewURL(strings.TrimPrefix(l.Path, prefix))
+		if err != nil {
+			continue
+		}
+		var parts = strings.Split(u.Path, "/")
+		if u.Info {
+			paths = append(paths, u.ID.Escaped()+"/info.json")
+		} else {
+			paths = append(paths, u.ID.Escaped()+"/"+strings.Join(parts[len(parts)-4:], "/"))
+		}
+	}
+	return paths, s.Err()
+}
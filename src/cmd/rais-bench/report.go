@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// summary is the latency and throughput of a set of results
+type summary struct {
+	count    int
+	failures int
+	bytes    int64
+	p50      time.Duration
+	p90      time.Duration
+	p99      time.Duration
+	max      time.Duration
+}
+
+func summarize(results []result) summary {
+	var s = summary{count: len(results)}
+	var durations = make([]time.Duration, len(results))
+	for i, r := range results {
+		durations[i] = r.duration
+		s.bytes += r.bytes
+		if r.failed() {
+			s.failures++
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	s.p50 = percentile(durations, 50)
+	s.p90 = percentile(durations, 90)
+	s.p99 = percentile(durations, 99)
+	if len(durations) > 0 {
+		s.max = durations[len(durations)-1]
+	}
+	return s
+}
+
+// percentile returns the nearest-rank percentile p of the sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	var rank = (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// cacheCounts is the part of the server's stats.json we report on
+type cacheCounts struct {
+	GetCount uint64
+	GetHits  uint64
+}
+
+type serverStats struct {
+	InfoCache cacheCounts
+	TileCache cacheCounts
+}
+
+func fetchStats(url string) (serverStats, error) {
+	var s serverStats
+	var resp, err = http.Get(url)
+	if err != nil {
+		return s, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	return s, err
+}
+
+// hitRate describes the cache hits between two stats snapshots
+func hitRate(before, after cacheCounts) string {
+	var gets, hits = after.GetCount - before.GetCount, after.GetHits - before.GetHits
+	if gets == 0 {
+		return "no lookups"
+	}
+	return fmt.Sprintf("%.1f%% (%d of %d)", float64(hits)*100/float64(gets), hits, gets)
+}
+
+// report writes the results, and cache hit rates if stats were gathered
+func report(w io.Writer, results []result, elapsed time.Duration, before, after *serverStats) {
+	var info, images []result
+	for _, r := range results {
+		if r.info {
+			info = append(info, r)
+		} else {
+			images = append(images, r)
+		}
+	}
+
+	var all = summarize(results)
+	var secs = elapsed.Seconds()
+	fmt.Fprintf(w, "%d requests in %s: %.1f req/s, %.2f MB/s, %d failed\n\n",
+		all.count, elapsed.Round(time.Millisecond), float64(all.count)/secs, float64(all.bytes)/secs/1e6, all.failures)
+
+	fmt.Fprintf(w, "%-8s %8s %8s %10s %10s %10s %10s\n", "", "count", "failed", "p50", "p90", "p99", "max")
+	for _, row := range []struct {
+		name string
+		list []result
+	}{{"all", results}, {"info", info}, {"image", images}} {
+		var s = summarize(row.list)
+		fmt.Fprintf(w, "%-8s %8d %8d %10s %10s %10s %10s\n", row.name, s.count, s.failures,
+			round(s.p50), round(s.p90), round(s.p99), round(s.max))
+	}
+
+	if before != nil && after != nil {
+		fmt.Fprintf(w, "\ninfo cache hits: %s\n", hitRate(before.InfoCache, after.InfoCache))
+		fmt.Fprintf(w, "tile cache hits: %s\n", hitRate(before.TileCache, after.TileCache))
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(100 * time.Microsecond)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"rais/src/iiif"
+)
+
+// imageLayout is what a viewer knows about an image from its info.json: the
+// dimensions, and the tile grid at each scale factor
+type imageLayout struct {
+	Width, Height         int
+	TileWidth, TileHeight int
+	ScaleFactors          []int
+}
+
+// layoutFromInfo pulls the first advertised tile grid from info, falling back
+// to 512-pixel tiles, zooming out until one tile holds the whole image, if
+// info has none
+func layoutFromInfo(info *iiif.Info) imageLayout {
+	var l = imageLayout{Width: info.Width, Height: info.Height}
+	if len(info.Tiles) > 0 {
+		var ts = info.Tiles[0]
+		l.TileWidth, l.TileHeight, l.ScaleFactors = ts.Width, ts.Height, ts.ScaleFactors
+	}
+	if l.TileWidth == 0 {
+		l.TileWidth = 512
+	}
+	if l.TileHeight == 0 {
+		l.TileHeight = l.TileWidth
+	}
+	if len(l.ScaleFactors) == 0 {
+		for sf := 1; ; sf *= 2 {
+			l.ScaleFactors = append(l.ScaleFactors, sf)
+			if l.Width <= l.TileWidth*sf && l.Height <= l.TileHeight*sf {
+				break
+			}
+		}
+	}
+	return l
+}
+
+// deepZoomSession returns the requests a deep-zoom viewer of the given size
+// makes for one visitor: the info.json, a thumbnail, and then every tile in
+// view at each zoom level from the most zoomed-out to full resolution,
+// panning a random amount between levels
+func deepZoomSession(id iiif.ID, l imageLayout, viewW, viewH int, rng *rand.Rand) []string {
+	var base = id.Escaped()
+	var paths = []string{base + "/info.json", base + "/full/!200,200/0/default.jpg"}
+
+	var cx, cy = l.Width / 2, l.Height / 2
+	for i := len(l.ScaleFactors) - 1; i >= 0; i-- {
+		var sf = l.ScaleFactors[i]
+		if sf < 1 {
+			continue
+		}
+
+		// The viewport covers viewW x viewH screen pixels, so sf times that in
+		// image pixels; pan by up to half a viewport, staying on the image
+		var vw, vh = viewW * sf, viewH * sf
+		cx = clamp(cx+rng.Intn(vw+1)-vw/2, 0, l.Width-1)
+		cy = clamp(cy+rng.Intn(vh+1)-vh/2, 0, l.Height-1)
+
+		var tw, th = l.TileWidth * sf, l.TileHeight * sf
+		var x0, y0 = clamp(cx-vw/2, 0, l.Width-1) / tw, clamp(cy-vh/2, 0, l.Height-1) / th
+		var x1, y1 = clamp(cx+vw/2, 0, l.Width-1) / tw, clamp(cy+vh/2, 0, l.Height-1) / th
+		for ty := y0; ty <= y1; ty++ {
+			for tx := x0; tx <= x1; tx++ {
+				var x, y = tx * tw, ty * th
+				var w, h = min(tw, l.Width-x), min(th, l.Height-y)
+				paths = append(paths, fmt.Sprintf("%s/%d,%d,%d,%d/%d,/0/default.jpg", base, x, y, w, h, (w+sf-1)/sf))
+			}
+		}
+	}
+	return paths
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
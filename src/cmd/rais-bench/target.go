@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/openjpeg"
+	"strings"
+	"time"
+)
+
+// result is the outcome of a single request
+type result struct {
+	path     string
+	info     bool
+	status   int
+	bytes    int64
+	duration time.Duration
+	err      error
+}
+
+func (r result) failed() bool {
+	return r.err != nil || r.status >= 400
+}
+
+// target is what requests are sent to: a running server, or the decode
+// pipeline itself
+type target interface {
+	get(path string) result
+	layout(id iiif.ID) (imageLayout, error)
+}
+
+// httpTarget sends requests to a running RAIS server
+type httpTarget struct {
+	base   string
+	client *http.Client
+}
+
+func newHTTPTarget(base string) *httpTarget {
+	return &httpTarget{base: strings.TrimRight(base, "/"), client: &http.Client{Timeout: time.Minute}}
+}
+
+func (t *httpTarget) get(path string) result {
+	var r = result{path: path, info: strings.HasSuffix(path, "/info.json")}
+	var start = time.Now()
+	var resp, err = t.client.Get(t.base + "/" + path)
+	if err != nil {
+		r.err = err
+		r.duration = time.Since(start)
+		return r
+	}
+	r.bytes, r.err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	r.duration = time.Since(start)
+	r.status = resp.StatusCode
+	return r
+}
+
+func (t *httpTarget) layout(id iiif.ID) (imageLayout, error) {
+	var resp, err = t.client.Get(t.base + "/" + id.Escaped() + "/info.json")
+	if err != nil {
+		return imageLayout{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return imageLayout{}, fmt.Errorf("info.json for %q returned %s", id, resp.Status)
+	}
+
+	var info iiif.Info
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return imageLayout{}, fmt.Errorf("unable to read info.json for %q: %s", id, err)
+	}
+	return layoutFromInfo(&info), nil
+}
+
+// directTarget runs requests through the decode and encode pipeline in this
+// process, with no server, caching, or network in the way.  Only JP2s can be
+// read, since plugins aren't loaded.
+type directTarget struct {
+	root string
+}
+
+var unlimited = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
+
+func newDirectTarget(root string) *directTarget {
+	img.RegisterNamedDecoder(img.NamedDecoder{
+		Name:       "openjpeg",
+		Extensions: []string{".jp2"},
+		Decode:     func(path string) (img.Decoder, error) { return openjpeg.NewJP2Image(path) },
+	})
+	return &directTarget{root: root}
+}
+
+func (t *directTarget) resource(id iiif.ID) (*img.Resource, error) {
+	return img.NewResource(id, filepath.Join(t.root, string(id)))
+}
+
+func (t *directTarget) get(path string) result {
+	var r = result{path: path, status: http.StatusOK}
+	var start = time.Now()
+	r.info, r.bytes, r.err = t.render(path)
+	r.duration = time.Since(start)
+	return r
+}
+
+// render reads the image path refers to, and unless it's an info request,
+// decodes, transforms, and encodes it, returning the encoded size
+func (t *directTarget) render(path string) (info bool, n int64, err error) {
+	var u *iiif.URL
+	u, err = iiif.NewURL(path)
+	if err != nil {
+		return false, 0, err
+	}
+
+	var res *img.Resource
+	res, err = t.resource(u.ID)
+	if err != nil || u.Info {
+		return u.Info, 0, err
+	}
+
+	var i image.Image
+	i, err = res.Apply(u, unlimited)
+	if err != nil {
+		return false, 0, err
+	}
+	var w = &countingWriter{}
+	switch u.Format {
+	case iiif.FmtPNG:
+		err = png.Encode(w, i)
+	case iiif.FmtGIF:
+		err = gif.Encode(w, i, nil)
+	default:
+		err = jpeg.Encode(w, i, &jpeg.Options{Quality: 80})
+	}
+	return false, w.n, err
+}
+
+func (t *directTarget) layout(id iiif.ID) (imageLayout, error) {
+	var res, err = t.resource(id)
+	if err != nil {
+		return imageLayout{}, err
+	}
+
+	var d = res.Decoder
+	var info = &iiif.Info{Width: d.GetWidth(), Height: d.GetHeight()}
+	if d.GetTileWidth() > 0 && d.GetTileWidth() < d.GetWidth() {
+		var ts = iiif.TileSize{Width: d.GetTileWidth(), Height: d.GetTileHeight()}
+		for l := 0; l < d.GetLevels(); l++ {
+			ts.ScaleFactors = append(ts.ScaleFactors, 1<<uint(l))
+		}
+		info.Tiles = []iiif.TileSize{ts}
+	}
+	return layoutFromInfo(info), nil
+}
+
+// countingWriter throws away what's written, only counting the bytes
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"rais/src/cmd/rais-server/internal/statusrecorder"
+	"rais/src/iiif"
+	"rais/src/requestid"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// accessLog is the destination for structured access log lines.  It's nil
+// when access logging is disabled.
+var accessLog *accessLogger
+
+// accessLogger serializes log entries as one JSON object per line
+type accessLogger struct {
+	m   sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// accessLogEntry is a single request's access log data.  Some fields are
+// filled in by the middleware, while IIIF-specific fields are filled in by the
+// image handler if the request gets that far.
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Duration   float64   `json:"duration"`
+	ID         iiif.ID   `json:"iiif_id,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	Size       string    `json:"size,omitempty"`
+	Rotation   string    `json:"rotation,omitempty"`
+	Quality    string    `json:"quality,omitempty"`
+	Format     string    `json:"format,omitempty"`
+	Info       bool      `json:"info,omitempty"`
+	Cache      string    `json:"cache,omitempty"`
+
+	spans []*spanTiming
+	live  *liveRequest
+}
+
+type accessLogKey struct{}
+
+// setupAccessLog opens the access log destination: "-" means stdout, and
+// anything else is treated as a file path to append to
+func setupAccessLog(dest string) {
+	if dest == "" {
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if dest != "-" {
+		var f, err = os.OpenFile(dest, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			Logger.Fatalf("Unable to open access log %q: %s", dest, err)
+		}
+		w = f
+	}
+
+	accessLog = &accessLogger{w: w, enc: json.NewEncoder(w)}
+}
+
+func (al *accessLogger) write(e *accessLogEntry) {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	var err = al.enc.Encode(e)
+	if err != nil {
+		Logger.Errorf("Unable to write access log entry: %s", err)
+	}
+}
+
+// newRequestID generates a random identifier for requests which didn't
+// arrive with an X-Request-ID header
+func newRequestID() string {
+	var b = make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// accessLogMiddleware ensures every request has a request id, and writes an
+// access log entry once the request has been served.  The id is put in the
+// request's context so plugins and decoders can tag their log messages.  The
+// entry is also offered to the slow request sampler, even if access logging
+// is disabled.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Servers sharing an address get middleware applied twice; we only want
+		// the outermost layer doing anything
+		if logEntry(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var e = &accessLogEntry{
+			Time:       time.Now(),
+			RequestID:  r.Header.Get("X-Request-ID"),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+		}
+		if e.RequestID == "" {
+			e.RequestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", e.RequestID)
+
+		var sr = statusrecorder.New(w)
+		var done = inflight.add(e, sr)
+		var ctx = context.WithValue(r.Context(), accessLogKey{}, e)
+		next.ServeHTTP(sr, r.WithContext(requestid.NewContext(ctx, e.RequestID)))
+		done()
+
+		e.Status = sr.Status
+		e.Bytes = sr.Bytes
+		e.Duration = time.Since(e.Time).Seconds()
+		if accessLog != nil {
+			accessLog.write(e)
+		}
+		slowRequests.record(e)
+		usage.record(e)
+	})
+}
+
+// logEntry returns the request's access log entry, or nil if the request
+// didn't go through the access log middleware
+func logEntry(req *http.Request) *accessLogEntry {
+	var e, _ = req.Context().Value(accessLogKey{}).(*accessLogEntry)
+	return e
+}
+
+// requestID returns the request's id if it has one, or an empty string
+func requestID(req *http.Request) string {
+	var e = logEntry(req)
+	if e == nil {
+		return ""
+	}
+	return e.RequestID
+}
+
+// requestLogger returns a logger which tags messages with the request id in
+// ctx, if there is one
+func requestLogger(ctx context.Context) *logger.Logger {
+	return requestid.Logger(ctx, Logger)
+}
+
+// logIIIFRequest stores the parsed IIIF URL's data in the access log entry
+func logIIIFRequest(req *http.Request, u *iiif.URL) {
+	var e = logEntry(req)
+	if e == nil {
+		return
+	}
+
+	e.ID = u.ID
+	e.Info = u.Info
+	if u.Info {
+		return
+	}
+
+	// The raw URL segments are logged rather than the parsed values so that
+	// invalid requests can still be analyzed
+	var parts = strings.Split(u.Path, "/")
+	if len(parts) < 4 {
+		return
+	}
+	parts = parts[len(parts)-4:]
+	e.Region, e.Size, e.Rotation = parts[0], parts[1], parts[2]
+	e.Quality = string(u.Quality)
+	e.Format = string(u.Format)
+}
+
+// logCache records whether the request was served from cache
+func logCache(req *http.Request, hit bool) {
+	var e = logEntry(req)
+	if e == nil {
+		return
+	}
+
+	e.Cache = "miss"
+	if hit {
+		e.Cache = "hit"
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager gets and renews certificates for listeners using ACME, set up
+// at startup if ACMEDomains is set
+var acmeManager *autocert.Manager
+
+// newACMEManager returns a certificate manager for cfg's ACME settings.
+// Certificates are only requested for ACMEDomains, so a client can't make
+// RAIS ask for one by sending some other name, and they're stored in
+// ACMECacheDir so restarts don't count against the CA's rate limits.
+func newACMEManager(cfg *Config) *autocert.Manager {
+	var m = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+		Email:      cfg.ACMEEmail,
+	}
+	if cfg.ACMEDirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+	}
+	return m
+}
+
+// acmeTLSConfig returns the TLS setup for a listener whose certificates come
+// from m.  Challenges are answered with TLS-ALPN-01 on the listener itself,
+// so nothing needs to listen on port 80.  HTTP/2 isn't offered if it's
+// disabled.
+func acmeTLSConfig(m *autocert.Manager, http2 bool) *tls.Config {
+	var tc = m.TLSConfig()
+	if !http2 {
+		var protos []string
+		for _, p := range tc.NextProtos {
+			if p != "h2" {
+				protos = append(protos, p)
+			}
+		}
+		tc.NextProtos = protos
+	}
+	return tc
+}
+
+// parseACMEDomains splits a comma-separated list of host names
+func parseACMEDomains(val string) []string {
+	var list []string
+	for _, d := range strings.Split(val, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" {
+			list = append(list, d)
+		}
+	}
+	return list
+}
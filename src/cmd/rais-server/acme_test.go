@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestACMETLSConfig(t *testing.T) {
+	var m = newACMEManager(&Config{ACMEDomains: []string{"iiif.example.edu"}, ACMECacheDir: t.TempDir()})
+	var tc = acmeTLSConfig(m, true)
+	assert.True(tc.GetCertificate != nil, "certificates come from the manager", t)
+	assert.True(hasProto(tc.NextProtos, "h2"), "HTTP/2 is offered", t)
+	assert.True(hasProto(tc.NextProtos, "acme-tls/1"), "TLS-ALPN-01 challenges are answered", t)
+
+	tc = acmeTLSConfig(m, false)
+	assert.False(hasProto(tc.NextProtos, "h2"), "HTTP/2 can be disabled", t)
+	assert.True(hasProto(tc.NextProtos, "acme-tls/1"), "challenges are still answered without HTTP/2", t)
+
+	assert.True(m.HostPolicy(context.Background(), "iiif.example.edu") == nil, "configured domains are allowed", t)
+	assert.True(m.HostPolicy(context.Background(), "evil.example.com") != nil, "other names are refused", t)
+}
+
+func hasProto(protos []string, p string) bool {
+	for _, proto := range protos {
+		if proto == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// activityPageSize is how many activities are listed on each page of the
+// change discovery stream
+const activityPageSize = 100
+
+const discoveryContext = "http://iiif.io/api/discovery/1/context.json"
+
+// activityType is the Activity Streams type of a change to an image
+type activityType string
+
+// The changes the discovery stream reports
+const (
+	activityCreate activityType = "Create"
+	activityUpdate activityType = "Update"
+	activityDelete activityType = "Delete"
+)
+
+// activity records a single change to an image.  Seq numbers only increase,
+// so a page's contents never change once it's full.
+type activity struct {
+	Seq  int64        `json:"seq"`
+	Type activityType `json:"type"`
+	ID   iiif.ID      `json:"id"`
+	Time time.Time    `json:"time"`
+}
+
+// activityLog holds the most recent changes to images for the IIIF Change
+// Discovery API.  If file is set, every activity is appended to it as a line
+// of JSON so the stream survives restarts.
+type activityLog struct {
+	m     sync.RWMutex
+	max   int
+	items []*activity
+	next  int64
+	file  *os.File
+}
+
+// activities is nil unless change discovery is enabled
+var activities *activityLog
+
+func newActivityLog(max int) *activityLog {
+	return &activityLog{max: max}
+}
+
+// load reads previously recorded activities from fname and appends all
+// future activities to it.  A missing file isn't an error.
+func (al *activityLog) load(fname string) error {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	var f, err = os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var a = new(activity)
+		err = json.Unmarshal(scanner.Bytes(), a)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("invalid activity file %q: %s", fname, err)
+		}
+		al.append(a)
+	}
+	err = scanner.Err()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	al.file = f
+	return nil
+}
+
+// append adds a to the log, dropping the oldest activity if the log is full.
+// al.m must be locked.
+func (al *activityLog) append(a *activity) {
+	al.items = append(al.items, a)
+	if len(al.items) > al.max {
+		al.items = al.items[len(al.items)-al.max:]
+	}
+	al.next = a.Seq + 1
+}
+
+// add records a change to id
+func (al *activityLog) add(t activityType, id iiif.ID) {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	var a = &activity{Seq: al.next, Type: t, ID: id, Time: time.Now().UTC()}
+	al.append(a)
+	if al.file != nil {
+		var data, _ = json.Marshal(a)
+		var _, err = al.file.Write(append(data, '\n'))
+		if err != nil {
+			Logger.Errorf("Unable to save activity for %q: %s", id, err)
+		}
+	}
+}
+
+// page returns the activities on page n, and whether n is a page that
+// still exists
+func (al *activityLog) page(n int64) ([]*activity, bool) {
+	al.m.RLock()
+	defer al.m.RUnlock()
+
+	var first, last, ok = al.pageRange()
+	if !ok || n < first || n > last {
+		return nil, false
+	}
+
+	var list []*activity
+	for _, a := range al.items {
+		if a.Seq/activityPageSize == n {
+			list = append(list, a)
+		}
+	}
+	return list, true
+}
+
+// pageRange returns the first and last page numbers.  ok is false if
+// nothing has been recorded.  al.m must be locked.
+func (al *activityLog) pageRange() (first, last int64, ok bool) {
+	if len(al.items) == 0 {
+		return 0, 0, false
+	}
+	return al.items[0].Seq / activityPageSize, al.items[len(al.items)-1].Seq / activityPageSize, true
+}
+
+// recordActivity adds a change to id to the change discovery stream, if
+// change discovery is enabled
+func recordActivity(t activityType, id iiif.ID) {
+	if activities != nil {
+		activities.add(t, id)
+	}
+}
+
+// asRef is a reference to an Activity Streams object
+type asRef struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+type asCollection struct {
+	Context    string `json:"@context"`
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	TotalItems int    `json:"totalItems"`
+	First      *asRef `json:"first,omitempty"`
+	Last       *asRef `json:"last,omitempty"`
+}
+
+type asActivity struct {
+	Type    activityType `json:"type"`
+	Object  asRef        `json:"object"`
+	EndTime string       `json:"endTime"`
+}
+
+type asPage struct {
+	Context      string       `json:"@context"`
+	ID           string       `json:"id"`
+	Type         string       `json:"type"`
+	PartOf       asRef        `json:"partOf"`
+	Prev         *asRef       `json:"prev,omitempty"`
+	Next         *asRef       `json:"next,omitempty"`
+	OrderedItems []asActivity `json:"orderedItems"`
+}
+
+// changeDiscovery serves the IIIF Change Discovery API: an Activity Streams
+// collection at path, with pages at path + "/page-N".  When JWT auth is on,
+// pages leave out images the request isn't allowed to see.
+type changeDiscovery struct {
+	ih   *ImageHandler
+	path string
+	log  *activityLog
+}
+
+func (cd *changeDiscovery) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var base = cd.ih.BaseURL
+	if base == nil {
+		base = getRequestURL(req)
+	}
+	var root = base.Scheme + "://" + base.Host + base.Path
+	var collectionID = root + cd.path
+	var pageRef = func(n int64) *asRef {
+		return &asRef{ID: fmt.Sprintf("%s/page-%d", collectionID, n), Type: "OrderedCollectionPage"}
+	}
+
+	var data interface{}
+	if req.URL.Path == cd.path {
+		cd.log.m.RLock()
+		var c = asCollection{Context: discoveryContext, ID: collectionID, Type: "OrderedCollection", TotalItems: len(cd.log.items)}
+		if first, last, ok := cd.log.pageRange(); ok {
+			c.First, c.Last = pageRef(first), pageRef(last)
+		}
+		cd.log.m.RUnlock()
+		data = c
+	} else {
+		var n, err = strconv.ParseInt(strings.TrimPrefix(req.URL.Path, cd.path+"/page-"), 10, 64)
+		var list []*activity
+		var ok bool
+		if err == nil {
+			list, ok = cd.log.page(n)
+		}
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+
+		var p = asPage{
+			Context:      discoveryContext,
+			ID:           pageRef(n).ID,
+			Type:         "OrderedCollectionPage",
+			PartOf:       asRef{ID: collectionID, Type: "OrderedCollection"},
+			OrderedItems: []asActivity{},
+		}
+		if _, ok = cd.log.page(n - 1); ok {
+			p.Prev = pageRef(n - 1)
+		}
+		if _, ok = cd.log.page(n + 1); ok {
+			p.Next = pageRef(n + 1)
+		}
+		for _, a := range list {
+			if !visible(req.Context(), a.ID) {
+				continue
+			}
+			p.OrderedItems = append(p.OrderedItems, asActivity{
+				Type:    a.Type,
+				Object:  asRef{ID: root + cd.ih.WebPathPrefix + "/" + a.ID.Escaped(), Type: "ImageService2"},
+				EndTime: a.Time.Format(time.RFC3339),
+			})
+		}
+		data = p
+	}
+
+	w.Header().Set("Content-Type", "application/ld+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rais/src/jwt"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestActivityLogPages(t *testing.T) {
+	var al = newActivityLog(150)
+	for i := 0; i < 250; i++ {
+		al.add(activityUpdate, "a.jp2")
+	}
+
+	assert.Equal(150, len(al.items), "the log is trimmed to its max", t)
+	var first, last, ok = al.pageRange()
+	assert.True(ok, "pages exist", t)
+	assert.Equal(int64(1), first, "the first page is gone", t)
+	assert.Equal(int64(2), last, "last page", t)
+
+	var list []*activity
+	list, ok = al.page(1)
+	assert.True(ok, "page 1 exists", t)
+	assert.Equal(100, len(list), "page 1 is full", t)
+	list, ok = al.page(2)
+	assert.True(ok, "page 2 exists", t)
+	assert.Equal(50, len(list), "page 2 has the rest", t)
+	_, ok = al.page(0)
+	assert.False(ok, "trimmed pages don't exist", t)
+}
+
+func TestActivityLogPersistence(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-activity")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var fname = filepath.Join(dir, "activity.jsonl")
+
+	var al = newActivityLog(10)
+	assert.NilError(al.load(fname), "missing file is fine", t)
+	al.add(activityCreate, "a.jp2")
+	al.add(activityDelete, "b.jp2")
+	al.file.Close()
+
+	var al2 = newActivityLog(10)
+	assert.NilError(al2.load(fname), "reload", t)
+	defer al2.file.Close()
+	assert.Equal(2, len(al2.items), "activities persist", t)
+	assert.Equal(activityDelete, al2.items[1].Type, "type persists", t)
+	al2.add(activityUpdate, "a.jp2")
+	assert.Equal(int64(2), al2.items[2].Seq, "sequence numbers continue after a reload", t)
+}
+
+func TestChangeDiscoveryServeHTTP(t *testing.T) {
+	var al = newActivityLog(1000)
+	for i := 0; i < 101; i++ {
+		al.add(activityCreate, "maps/a.jp2")
+	}
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	var cd = &changeDiscovery{ih: ih, path: "/activity", log: al}
+
+	var w = httptest.NewRecorder()
+	cd.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/activity", nil))
+	var c asCollection
+	assert.NilError(json.Unmarshal(w.Body.Bytes(), &c), "collection is JSON", t)
+	assert.Equal("application/ld+json", w.Header().Get("Content-Type"), "content type", t)
+	assert.Equal(101, c.TotalItems, "total items", t)
+	assert.Equal("http://example.org/activity/page-0", c.First.ID, "first page", t)
+	assert.Equal("http://example.org/activity/page-1", c.Last.ID, "last page", t)
+
+	w = httptest.NewRecorder()
+	cd.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/activity/page-1", nil))
+	var p asPage
+	assert.NilError(json.Unmarshal(w.Body.Bytes(), &p), "page is JSON", t)
+	assert.Equal(1, len(p.OrderedItems), "page items", t)
+	assert.Equal("http://example.org/iiif/maps%2Fa.jp2", p.OrderedItems[0].Object.ID, "object is the image service", t)
+	assert.Equal("http://example.org/activity/page-0", p.Prev.ID, "prev page", t)
+	assert.True(p.Next == nil, "no next page", t)
+
+	for _, path := range []string{"/activity/page-2", "/activity/page-x"} {
+		w = httptest.NewRecorder()
+		cd.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org"+path, nil))
+		assert.Equal(http.StatusNotFound, w.Code, path+" is a 404", t)
+	}
+}
+
+func TestChangeDiscoveryAuth(t *testing.T) {
+	var al = newActivityLog(1000)
+	al.add(activityCreate, "public/a.jp2")
+	al.add(activityCreate, "secret/b.jp2")
+	var cd http.Handler = &changeDiscovery{ih: NewImageHandler("/var/local/images", "/iiif"), path: "/activity", log: al}
+	var keys = testKeys{key: mustKey(t)}
+	cd = newJWTAuth(&jwt.Validator{Keys: keys, Audience: "rais"}, []*jwtRule{
+		{Claim: "groups", Values: []string{"staff"}},
+		{Anonymous: true, Prefixes: []string{"public/"}},
+	}).wrap(newSignedURLs([]string{"secret"}, "").wrap(cd))
+
+	var expires = time.Now().Add(time.Minute).Unix()
+	var tok = token("secret", expires, "activity")
+	var get = func(path, bearer string) *httptest.ResponseRecorder {
+		var req = httptest.NewRequest("GET", "http://example.org"+path, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		var w = httptest.NewRecorder()
+		cd.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(http.StatusForbidden, get("/activity", "").Code, "the collection needs a signed token", t)
+	assert.Equal(http.StatusForbidden, get("/activity/page-0", "").Code, "pages need a signed token", t)
+	assert.Equal(http.StatusOK, get("/activity?token="+tok, "").Code, "signed collection", t)
+
+	var p asPage
+	var w = get("/activity/page-0?token="+tok, "")
+	assert.Equal(http.StatusOK, w.Code, "the collection's token covers its pages", t)
+	assert.NilError(json.Unmarshal(w.Body.Bytes(), &p), "page is JSON", t)
+	assert.Equal(1, len(p.OrderedItems), "anonymous requests only see public images", t)
+
+	var staff = keys.sign(jwt.Claims{"aud": "rais", "exp": expires, "groups": "staff"})
+	w = get("/activity/page-0?token="+tok, staff)
+	p = asPage{}
+	assert.NilError(json.Unmarshal(w.Body.Bytes(), &p), "page is JSON", t)
+	assert.Equal(2, len(p.OrderedItems), "staff see everything", t)
+}
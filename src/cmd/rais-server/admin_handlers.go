@@ -23,6 +23,7 @@ func adminPurgeCache(w http.ResponseWriter, req *http.Request) {
 	case "single":
 		var id = iiif.ID(req.PostFormValue("id"))
 		expireCachedImage(id)
+		recordActivity(activityUpdate, id)
 	case "all":
 		purgeCaches()
 	default:
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aliasMap maps public IDs, such as "ark:/12345/xyz", to the IDs or absolute
+// file paths they stand for, so IIIF URLs needn't expose how images are
+// stored.  Targets which aren't absolute paths are resolved just like any
+// other ID, so they may use routes and plugins.
+type aliasMap struct {
+	m       sync.RWMutex
+	path    string
+	modTime time.Time
+	size    int64
+	items   map[iiif.ID]iiif.ID
+}
+
+var aliases = &aliasMap{}
+
+// load reads aliases from a JSON object mapping public IDs to their targets:
+//
+//	{
+//	  "ark:/12345/xyz": "collections/maps/sanborn-1902.jp2",
+//	  "ark:/12345/abc": "/mnt/archive/reel-042.tif"
+//	}
+//
+// The file is remembered for later reloads.  If it can't be read, the current
+// aliases are kept.
+func (am *aliasMap) load(fname string) error {
+	var info, err = os.Stat(fname)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	data, err = ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+
+	var items map[iiif.ID]iiif.ID
+	err = json.Unmarshal(data, &items)
+	if err != nil {
+		return fmt.Errorf("invalid alias file %q: %s", fname, err)
+	}
+	for alias, target := range items {
+		if alias == "" || target == "" {
+			return fmt.Errorf("invalid alias file %q: aliases and targets must not be empty", fname)
+		}
+	}
+
+	am.m.Lock()
+	var old = am.items
+	am.path, am.modTime, am.size, am.items = fname, info.ModTime(), info.Size(), items
+	am.m.Unlock()
+
+	// Anything cached under an ID whose alias was added, changed, or removed is
+	// now wrong.  The initial load has nothing to compare against.
+	if old == nil {
+		return nil
+	}
+	for alias, target := range old {
+		if items[alias] != target {
+			expireCachedImage(alias)
+		}
+	}
+	for alias := range items {
+		if _, ok := old[alias]; !ok {
+			expireCachedImage(alias)
+		}
+	}
+	return nil
+}
+
+// changed returns true if the alias file's modification time or size differs
+// from when it was last loaded
+func (am *aliasMap) changed() bool {
+	am.m.RLock()
+	defer am.m.RUnlock()
+
+	var info, err = os.Stat(am.path)
+	if err != nil {
+		return false
+	}
+	return !info.ModTime().Equal(am.modTime) || info.Size() != am.size
+}
+
+// watch reloads the alias file every interval if it has changed
+func (am *aliasMap) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		am.reload()
+	}
+}
+
+// reload reads the alias file again if it's changed
+func (am *aliasMap) reload() {
+	defer catchPanic(context.Background(), "reloading aliases", nil)
+	if !am.changed() {
+		return
+	}
+	var err = am.load(am.path)
+	if err != nil {
+		Logger.Errorf("Unable to reload aliases, keeping the old list: %s", err)
+		return
+	}
+	Logger.Infof("Reloaded %d alias(es) from %q", am.len(), am.path)
+}
+
+func (am *aliasMap) len() int {
+	am.m.RLock()
+	defer am.m.RUnlock()
+	return len(am.items)
+}
+
+// resolve returns the target for id, or id itself if it isn't an alias.  A
+// page suffix on an alias is kept on its target.
+func (am *aliasMap) resolve(id iiif.ID) iiif.ID {
+	am.m.RLock()
+	defer am.m.RUnlock()
+
+	if target, ok := am.items[id]; ok {
+		return target
+	}
+	var base, page, ok = img.SplitPage(id)
+	if !ok {
+		return id
+	}
+	if target, ok := am.items[base]; ok {
+		return target + iiif.ID(img.PageSeparator+strconv.Itoa(page))
+	}
+	return id
+}
+
+// fileID returns the ID of the file id refers to: id without any page
+// suffix, and with aliases replaced by their targets.  Checks which apply to
+// a whole image, like takedowns, use it so that "id;0" can't get around them.
+func fileID(id iiif.ID) iiif.ID {
+	var base, _, _ = img.SplitPage(aliases.resolve(id))
+	return base
+}
+
+// isAliasPath returns true if an alias target is a file path rather than an ID
+func isAliasPath(target iiif.ID) bool {
+	return strings.HasPrefix(string(target), "/")
+}
+
+// pathDirs returns the directories of alias targets which are file paths,
+// without duplicates
+func (am *aliasMap) pathDirs() []string {
+	am.m.RLock()
+	defer am.m.RUnlock()
+
+	var seen = make(map[string]bool)
+	var dirs []string
+	for _, target := range am.items {
+		if !isAliasPath(target) {
+			continue
+		}
+		var dir = filepath.Dir(string(target))
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func writeAliases(t *testing.T, fname, data string) {
+	var err = ioutil.WriteFile(fname, []byte(data), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write alias file: %s", err)
+	}
+}
+
+func TestAliasLoadAndResolve(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-aliases")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var fname = filepath.Join(dir, "aliases.json")
+
+	var am = &aliasMap{}
+	assert.True(am.load(fname) != nil, "missing file is an error", t)
+	writeAliases(t, fname, `{"ark:/1/a": "maps/a.jp2", "ark:/1/b": ""}`)
+	assert.True(am.load(fname) != nil, "empty targets are an error", t)
+
+	writeAliases(t, fname, `{"ark:/1/a": "maps/a.jp2", "ark:/1/b": "/mnt/b.tif"}`)
+	assert.NilError(am.load(fname), "valid file", t)
+	assert.Equal(iiif.ID("maps/a.jp2"), am.resolve("ark:/1/a"), "alias to ID", t)
+	assert.Equal(iiif.ID("/mnt/b.tif"), am.resolve("ark:/1/b"), "alias to path", t)
+	assert.Equal(iiif.ID("maps/c.jp2"), am.resolve("maps/c.jp2"), "non-aliases are unchanged", t)
+	assert.Equal(iiif.ID("maps/a.jp2"+img.PageSeparator+"3"), am.resolve("ark:/1/a"+iiif.ID(img.PageSeparator)+"3"), "pages are kept", t)
+	assert.False(am.changed(), "file hasn't changed", t)
+
+	var expired []string
+	expireCachedImagePlugins = []func(iiif.ID){func(id iiif.ID) { expired = append(expired, string(id)) }}
+	defer func() { expireCachedImagePlugins = nil }()
+
+	writeAliases(t, fname, `{"ark:/1/a": "maps/a2.jp2", "ark:/1/c": "maps/c.jp2"}`)
+	var future = time.Now().Add(time.Minute)
+	os.Chtimes(fname, future, future)
+	assert.True(am.changed(), "file has changed", t)
+	assert.NilError(am.load(fname), "reload", t)
+	sort.Strings(expired)
+	assert.Equal("[ark:/1/a ark:/1/b ark:/1/c]", fmt.Sprint(expired), "changed, removed, and added aliases are expired", t)
+	assert.Equal(iiif.ID("maps/a2.jp2"), am.resolve("ark:/1/a"), "reloaded alias", t)
+
+	writeAliases(t, fname, `not json`)
+	assert.True(am.load(fname) != nil, "invalid JSON", t)
+	assert.Equal(iiif.ID("maps/a2.jp2"), am.resolve("ark:/1/a"), "failed reloads keep the old aliases", t)
+}
+
+func TestAliasedInfoRequest(t *testing.T) {
+	var old = aliases
+	defer func() { aliases = old }()
+	aliases = &aliasMap{items: map[iiif.ID]iiif.ID{
+		"ark:/1/world": "docker/images/testfile/test-world-link.jp2",
+		"ark:/1/path":  iiif.ID(rootDir() + "/docker/images/testfile/test-world-link.jp2"),
+	}}
+
+	for _, id := range []string{"ark:/1/world", "ark:/1/path"} {
+		var escaped = iiif.ID(id).Escaped()
+		var w = request(escaped+"/info.json", t)
+		assert.Equal(-1, w.StatusCode, id+": valid info request", t)
+		var data iiif.Info
+		json.Unmarshal(w.Output, &data)
+		assert.Equal(800, data.Width, id+": JSON-decoded width", t)
+		assert.Equal("http://example.com/foo/bar/"+escaped, data.ID, id+": info uses the public ID", t)
+	}
+
+	takedowns.add(&takedown{ID: "docker/images/testfile/test-world-link.jp2", Reason: "test", Created: time.Now()})
+	defer takedowns.remove("docker/images/testfile/test-world-link.jp2")
+	var w = request(iiif.ID("ark:/1/world").Escaped()+"/info.json", t)
+	assert.Equal(410, w.StatusCode, "taking down the target takes down the alias", t)
+
+	// Page suffixes mustn't get around a takedown, even on single-page files
+	for _, id := range []iiif.ID{"docker/images/testfile/test-world-link.jp2;0", "ark:/1/world;0"} {
+		w = request(id.Escaped()+"/info.json", t)
+		assert.Equal(410, w.StatusCode, string(id)+": info request with a page suffix", t)
+		w = request(id.Escaped()+"/full/max/0/default.jpg", t)
+		assert.Equal(410, w.StatusCode, string(id)+": image request with a page suffix", t)
+	}
+}
@@ -4,14 +4,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"rais/src/iiif"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru"
-	"github.com/spf13/viper"
 )
 
 var infoCache *lru.Cache
-var tileCache *lru.TwoQueueCache
+var tileCache tileStore
+var missingCache *negativeCache
 
 // setupCaches looks for config for caching and sets up the tile/info caches
 // appropriately.  If they exist, we put their cache expiration functions into
@@ -19,7 +25,7 @@
 // to plugins.
 func setupCaches() {
 	var err error
-	icl := viper.GetInt("InfoCacheLen")
+	icl := conf.InfoCacheLen
 	if icl > 0 {
 		infoCache, err = lru.New(icl)
 		if err != nil {
@@ -28,15 +34,38 @@ func setupCaches() {
 		stats.InfoCache.Enabled = true
 		purgeCachePlugins = append(purgeCachePlugins, infoCache.Purge)
 		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { infoCache.Remove(id) })
+		if conf.InfoCacheFile != "" {
+			setupInfoCacheFile(conf.InfoCacheFile, conf.InfoCacheSaveInterval)
+		}
 	}
 
-	tcl := viper.GetInt("TileCacheLen")
-	if tcl > 0 {
+	tcl := conf.TileCacheLen
+	if conf.TileCacheBytes > 0 {
+		Logger.Debugf("Creating a tile cache to hold up to %d bytes", conf.TileCacheBytes)
+		tileCache = newByteCache(conf.TileCacheBytes, tcl)
+	} else if tcl > 0 {
 		Logger.Debugf("Creating a tile cache to hold up to %d tiles", tcl)
 		tileCache, err = lru.New2Q(tcl)
 		if err != nil {
-			Logger.Fatalf("Unable to start info cache: %s", err)
+			Logger.Fatalf("Unable to start tile cache: %s", err)
+		}
+	}
+	if tileCache != nil {
+		tilePolicy = &tileCachePolicy{
+			formats:       conf.TileCacheFormats,
+			maxDimension:  conf.TileCacheMaxDimension,
+			maxEntryBytes: conf.TileCacheMaxEntryBytes,
+			stale:         conf.TileCacheStale,
+			key:           conf.TileCacheKey,
+		}
+		if tilePolicy.stale == "" {
+			tilePolicy.stale = staleRevalidate
+		}
+		if tilePolicy.key == "" {
+			tilePolicy.key = keyRequest
 		}
+	} else if conf.TilePrefetchWorkers > 0 {
+		Logger.Warnf("TilePrefetchWorkers is set, but tiles can't be prefetched without a tile cache")
 		stats.TileCache.Enabled = true
 		purgeCachePlugins = append(purgeCachePlugins, tileCache.Purge)
 		// Unfortunately, the tile cache is keyed by the entire IIIF request, not the
@@ -44,6 +73,60 @@ func setupCaches() {
 		// image, we have to purge the whole cache.
 		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { tileCache.Purge() })
 	}
+
+	if conf.MissingCacheTTL > 0 && conf.MissingCacheLen > 0 {
+		missingCache, err = newNegativeCache(conf.MissingCacheLen, conf.MissingCacheTTL)
+		if err != nil {
+			Logger.Fatalf("Unable to start missing image cache: %s", err)
+		}
+		stats.MissingCache.Enabled = true
+		purgeCachePlugins = append(purgeCachePlugins, missingCache.lru.Purge)
+		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { missingCache.lru.Remove(id) })
+	}
+}
+
+// negativeCache remembers IDs which turned out not to exist, so repeated
+// requests for dead links don't hit the filesystem or S3 every time.  Entries
+// expire after a short TTL, since an image may show up at any time.
+type negativeCache struct {
+	lru *lru.Cache
+	ttl time.Duration
+}
+
+func newNegativeCache(size int, ttl time.Duration) (*negativeCache, error) {
+	var c, err = lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &negativeCache{lru: c, ttl: ttl}, nil
+}
+
+// add records that id doesn't exist
+func (nc *negativeCache) add(id iiif.ID) {
+	if nc == nil {
+		return
+	}
+	stats.MissingCache.Set()
+	nc.lru.Add(id, time.Now().Add(nc.ttl))
+}
+
+// has returns true if id was recently found not to exist
+func (nc *negativeCache) has(id iiif.ID) bool {
+	if nc == nil {
+		return false
+	}
+
+	stats.MissingCache.Get()
+	var v, ok = nc.lru.Get(id)
+	if !ok {
+		return false
+	}
+	if time.Now().After(v.(time.Time)) {
+		nc.lru.Remove(id)
+		return false
+	}
+	stats.MissingCache.Hit()
+	return true
 }
 
 // purgeCaches removes all cached data
@@ -59,3 +142,79 @@ func expireCachedImage(id iiif.ID) {
 		plug(id)
 	}
 }
+
+// infoCacheEntry is a single cached image's info as it's stored on disk
+type infoCacheEntry struct {
+	ID   iiif.ID   `json:"id"`
+	Info ImageInfo `json:"info"`
+}
+
+// setupInfoCacheFile loads any previously saved info cache from fname, then
+// saves the cache back every interval (if nonzero) and at shutdown
+func setupInfoCacheFile(fname string, interval time.Duration) {
+	var n, err = loadInfoCache(infoCache, fname)
+	if err != nil {
+		Logger.Errorf("Unable to load info cache, starting with an empty cache: %s", err)
+	} else {
+		Logger.Infof("Loaded %d info cache entries from %q", n, fname)
+	}
+
+	var save = func() {
+		defer catchPanic(context.Background(), "saving the info cache", nil)
+		var err = saveInfoCache(infoCache, fname)
+		if err != nil {
+			Logger.Errorf("Unable to save info cache to %q: %s", fname, err)
+		}
+	}
+	teardownPlugins = append(teardownPlugins, save)
+	if interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				save()
+			}
+		}()
+	}
+}
+
+// loadInfoCache adds the entries saved in fname to c, returning how many were
+// read.  A missing file isn't an error, since the cache may never have been
+// saved.
+func loadInfoCache(c *lru.Cache, fname string) (int, error) {
+	var data, err = ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var list []infoCacheEntry
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return 0, fmt.Errorf("invalid info cache file %q: %s", fname, err)
+	}
+	for _, e := range list {
+		c.Add(e.ID, e.Info)
+	}
+	return len(list), nil
+}
+
+// saveInfoCache writes all of c's entries to fname, least recently used
+// first, so that loading them back restores the cache's eviction order
+func saveInfoCache(c *lru.Cache, fname string) error {
+	var keys = c.Keys()
+	var list = make([]infoCacheEntry, 0, len(keys))
+	for _, k := range keys {
+		// Peek rather than Get so that saving doesn't reorder the cache
+		var val, ok = c.Peek(k)
+		if ok {
+			list = append(list, infoCacheEntry{ID: k.(iiif.ID), Info: val.(ImageInfo)})
+		}
+	}
+
+	var data, err = json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fname, ".info-cache-", data)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ttl is a duration read from TOML which remembers whether it was set at all,
+// so scopes can tell "not specified" from an explicit zero
+type ttl struct {
+	time.Duration
+	set bool
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for TOML decoding
+func (t *ttl) UnmarshalText(text []byte) error {
+	var d, err = time.ParseDuration(string(text))
+	if err == nil && d < 0 {
+		err = fmt.Errorf("must not be negative")
+	}
+	t.Duration, t.set = d, err == nil
+	return err
+}
+
+// CachePolicy describes the caching headers sent for images matching Pattern
+// (in path.Match syntax).  A max age sets Cache-Control and Expires, while a
+// surrogate max age sets Surrogate-Control, which CDNs use instead of
+// Cache-Control; an explicit zero max age sends "no-cache".  Unset values are
+// inherited from the default policy.
+type CachePolicy struct {
+	Pattern              string
+	ImageMaxAge          ttl
+	InfoMaxAge           ttl
+	ImageSurrogateMaxAge ttl
+	InfoSurrogateMaxAge  ttl
+}
+
+// CachePolicies holds the default caching policy and any scoped overrides
+type CachePolicies struct {
+	Default       CachePolicy
+	Scope         []CachePolicy
+	SurrogateKeys bool
+}
+
+// loadCachePolicies reads caching policies from a TOML file:
+//
+//	SurrogateKeys = true
+//
+//	[Default]
+//	ImageMaxAge = "24h"
+//	InfoMaxAge = "1h"
+//
+//	[[Scope]]
+//	Pattern = "news/*"
+//	ImageMaxAge = "5m"
+func loadCachePolicies(fname string) (*CachePolicies, error) {
+	var cp = &CachePolicies{}
+	var _, err = toml.DecodeFile(fname, cp)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range cp.Scope {
+		_, err = path.Match(s.Pattern, "")
+		if s.Pattern == "" || err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", s.Pattern)
+		}
+		cp.Scope[i] = s.inherit(cp.Default)
+	}
+	return cp, nil
+}
+
+// inherit returns a copy of p with unset values taken from parent
+func (p CachePolicy) inherit(parent CachePolicy) CachePolicy {
+	for _, pair := range [][2]*ttl{
+		{&p.ImageMaxAge, &parent.ImageMaxAge},
+		{&p.InfoMaxAge, &parent.InfoMaxAge},
+		{&p.ImageSurrogateMaxAge, &parent.ImageSurrogateMaxAge},
+		{&p.InfoSurrogateMaxAge, &parent.InfoSurrogateMaxAge},
+	} {
+		if !pair[0].set {
+			*pair[0] = *pair[1]
+		}
+	}
+	return p
+}
+
+// policyFor returns the first scoped policy matching id, or the default
+func (cp *CachePolicies) policyFor(id iiif.ID) CachePolicy {
+	for _, s := range cp.Scope {
+		var ok, _ = path.Match(s.Pattern, string(id))
+		if ok {
+			return s
+		}
+	}
+	return cp.Default
+}
+
+// setHeaders adds caching headers for an image or info.json response for the
+// given ID.  It's safe to call on a nil CachePolicies, which does nothing
+// unless the request went through JWT auth: what those requests may see
+// depends on their token, so their responses are always marked private.
+func (cp *CachePolicies) setHeaders(w http.ResponseWriter, req *http.Request, id iiif.ID, info bool) {
+	var h = w.Header()
+	var private = grantFrom(req.Context()) != nil
+	if private {
+		h.Set("Cache-Control", "private")
+	}
+	if cp == nil {
+		return
+	}
+
+	var p = cp.policyFor(id)
+	var maxAge, surrogate, kind = p.ImageMaxAge, p.ImageSurrogateMaxAge, "rais-image"
+	if info {
+		maxAge, surrogate, kind = p.InfoMaxAge, p.InfoSurrogateMaxAge, "rais-info"
+	}
+
+	if maxAge.set {
+		var cc = "no-cache"
+		if maxAge.Duration > 0 {
+			cc = "public, max-age=" + seconds(maxAge.Duration)
+		}
+		if private {
+			cc = "private, " + strings.TrimPrefix(cc, "public, ")
+		}
+		h.Set("Cache-Control", cc)
+		h.Set("Expires", time.Now().Add(maxAge.Duration).UTC().Format(http.TimeFormat))
+	}
+	if surrogate.set && !private {
+		h.Set("Surrogate-Control", "max-age="+seconds(surrogate.Duration))
+	}
+	if cp.SurrogateKeys {
+		h.Set("Surrogate-Key", "rais "+kind+" "+id.SurrogateKey())
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
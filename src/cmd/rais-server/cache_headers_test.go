@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"rais/src/fakehttp"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestCachePolicies(t *testing.T) {
+	var f, err = ioutil.TempFile("", "rais-cache-policy")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+SurrogateKeys = true
+
+[Default]
+ImageMaxAge = "24h"
+InfoMaxAge = "1h"
+ImageSurrogateMaxAge = "720h"
+
+[[Scope]]
+Pattern = "news/*"
+ImageMaxAge = "5m"
+InfoMaxAge = "0s"
+`)
+	f.Close()
+
+	var cp *CachePolicies
+	cp, err = loadCachePolicies(f.Name())
+	assert.NilError(err, "loading policies", t)
+
+	var req = httptest.NewRequest("GET", "/iiif/x/info.json", nil)
+	var w = fakehttp.NewResponseWriter()
+	cp.setHeaders(w, req, "maps/foo.jp2", false)
+	assert.Equal("public, max-age=86400", w.Header().Get("Cache-Control"), "default image max age", t)
+	assert.Equal("max-age=2592000", w.Header().Get("Surrogate-Control"), "default image surrogate max age", t)
+	assert.True(strings.HasPrefix(w.Header().Get("Surrogate-Key"), "rais rais-image rais-id-"), "surrogate key", t)
+	assert.True(w.Header().Get("Expires") != "", "Expires is set", t)
+
+	w = fakehttp.NewResponseWriter()
+	cp.setHeaders(w, req, "news/foo.jp2", false)
+	assert.Equal("public, max-age=300", w.Header().Get("Cache-Control"), "scoped image max age", t)
+	assert.Equal("max-age=2592000", w.Header().Get("Surrogate-Control"), "scope inherits surrogate max age", t)
+
+	w = fakehttp.NewResponseWriter()
+	cp.setHeaders(w, req, "news/foo.jp2", true)
+	assert.Equal("no-cache", w.Header().Get("Cache-Control"), "explicit zero max age", t)
+	assert.Equal("", w.Header().Get("Surrogate-Control"), "no info surrogate max age", t)
+
+	// Responses to token-authorized requests mustn't go in shared caches
+	var authReq = req.WithContext(context.WithValue(req.Context(), authGrantKey{}, &authGrant{}))
+	w = fakehttp.NewResponseWriter()
+	cp.setHeaders(w, authReq, "maps/foo.jp2", false)
+	assert.Equal("private, max-age=86400", w.Header().Get("Cache-Control"), "authorized image max age", t)
+	assert.Equal("", w.Header().Get("Surrogate-Control"), "no surrogate caching for authorized requests", t)
+	w = fakehttp.NewResponseWriter()
+	cp.setHeaders(w, authReq, "news/foo.jp2", true)
+	assert.Equal("private, no-cache", w.Header().Get("Cache-Control"), "authorized zero max age", t)
+
+	cp = nil
+	w = fakehttp.NewResponseWriter()
+	cp.setHeaders(w, req, "news/foo.jp2", true)
+	assert.Equal("", w.Header().Get("Cache-Control"), "no headers without policies", t)
+	w = fakehttp.NewResponseWriter()
+	cp.setHeaders(w, authReq, "news/foo.jp2", true)
+	assert.Equal("private", w.Header().Get("Cache-Control"), "authorized requests are private without policies", t)
+}
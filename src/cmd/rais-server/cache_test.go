@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestInfoCachePersistence(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-info-cache")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var fname = filepath.Join(dir, "info-cache.json")
+
+	var c, _ = lru.New(3)
+	var n int
+	n, err = loadInfoCache(c, fname)
+	assert.NilError(err, "missing file is fine", t)
+	assert.Equal(0, n, "nothing loaded from a missing file", t)
+
+	c.Add(iiif.ID("a.jp2"), ImageInfo{Width: 100, Height: 200, Levels: 3})
+	c.Add(iiif.ID("b.jp2"), ImageInfo{Width: 300, Height: 400, TileWidth: 256, TileHeight: 256})
+	c.Add(iiif.ID("c.tif"), ImageInfo{Width: 500, Height: 600, Georeferenced: true})
+	c.Get(iiif.ID("a.jp2"))
+	assert.NilError(saveInfoCache(c, fname), "save", t)
+
+	var c2, _ = lru.New(3)
+	n, err = loadInfoCache(c2, fname)
+	assert.NilError(err, "reload", t)
+	assert.Equal(3, n, "all entries loaded", t)
+
+	var val, ok = c2.Peek(iiif.ID("b.jp2"))
+	assert.True(ok, "b.jp2 is cached", t)
+	assert.Equal(256, val.(ImageInfo).TileWidth, "b.jp2 tile width", t)
+	val, _ = c2.Peek(iiif.ID("c.tif"))
+	assert.True(val.(ImageInfo).Georeferenced, "c.tif is georeferenced", t)
+
+	// a.jp2 was used most recently before the save, so b.jp2 should be evicted
+	// first, even though a.jp2 was added first
+	c2.Add(iiif.ID("d.jp2"), ImageInfo{})
+	assert.True(c2.Contains(iiif.ID("a.jp2")), "a.jp2 survives eviction", t)
+	assert.False(c2.Contains(iiif.ID("b.jp2")), "b.jp2 is evicted", t)
+}
+
+func TestInfoCacheInvalidFile(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-info-cache")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var fname = filepath.Join(dir, "info-cache.json")
+	ioutil.WriteFile(fname, []byte("not json"), 0644)
+
+	var c, _ = lru.New(3)
+	_, err = loadInfoCache(c, fname)
+	assert.True(err != nil, "invalid file is an error", t)
+	assert.Equal(0, c.Len(), "nothing is cached", t)
+}
+
+func TestMissingCache(t *testing.T) {
+	var err error
+	missingCache, err = newNegativeCache(10, time.Hour)
+	assert.NilError(err, "creating the cache", t)
+	defer func() { missingCache = nil }()
+
+	var w = request("nope.jp2/info.json", t)
+	assert.Equal(404, w.StatusCode, "missing image is a 404", t)
+	assert.True(missingCache.has("nope.jp2"), "missing image is remembered", t)
+	w = request("nope.jp2/full/max/0/default.jpg", t)
+	assert.Equal(404, w.StatusCode, "cached miss is still a 404", t)
+
+	missingCache.add("gone.jp2")
+	missingCache.lru.Add(iiif.ID("gone.jp2"), time.Now().Add(-time.Second))
+	assert.False(missingCache.has("gone.jp2"), "expired entries are ignored", t)
+	assert.Equal(1, missingCache.lru.Len(), "expired entries are removed", t)
+
+	var disabled *negativeCache
+	disabled.add("nope.jp2")
+	assert.False(disabled.has("nope.jp2"), "disabled cache never has entries", t)
+}
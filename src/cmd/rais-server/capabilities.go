@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"rais/src/iiif"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FeatureScope applies a FeatureSet to all images whose IIIF ID matches
+// Pattern, which uses the same syntax as path.Match (e.g., "maps/*.jp2")
+type FeatureScope struct {
+	Pattern    string
+	FeatureSet *iiif.FeatureSet
+}
+
+// loadFeatureSet reads a capabilities file into a new FeatureSet
+func loadFeatureSet(fname string) (*iiif.FeatureSet, error) {
+	var fs = &iiif.FeatureSet{}
+	var _, err = toml.DecodeFile(fname, fs)
+	return fs, err
+}
+
+// parseFeatureList splits a comma-separated list of IIIF feature names,
+// e.g., "mirroring, sizeAboveFull"
+func parseFeatureList(val string) []string {
+	var list []string
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// applyFeatureToggles turns on the enabled features and turns off the
+// disabled ones.  Disabling wins if a feature is in both lists.
+func applyFeatureToggles(fs *iiif.FeatureSet, enabled, disabled []string) error {
+	for _, name := range enabled {
+		var err = fs.Set(name, true)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range disabled {
+		var err = fs.Set(name, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFeatureScopes reads a TOML file with a list of patterns and the
+// capabilities file to use for each:
+//
+//	[[Scope]]
+//	Pattern = "maps/oversized/*"
+//	CapabilitiesFile = "cap-level0.toml"
+//
+// Relative capabilities file paths are relative to the scopes file.
+func loadFeatureScopes(fname string) ([]FeatureScope, error) {
+	var data struct {
+		Scope []struct {
+			Pattern          string
+			CapabilitiesFile string
+		}
+	}
+	var _, err = toml.DecodeFile(fname, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	var scopes []FeatureScope
+	for _, s := range data.Scope {
+		_, err = path.Match(s.Pattern, "")
+		if s.Pattern == "" || err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", s.Pattern)
+		}
+
+		var capfile = s.CapabilitiesFile
+		if !filepath.IsAbs(capfile) {
+			capfile = filepath.Join(filepath.Dir(fname), capfile)
+		}
+		var fs, err = loadFeatureSet(capfile)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: capabilities file %q: %s", s.Pattern, capfile, err)
+		}
+		scopes = append(scopes, FeatureScope{Pattern: s.Pattern, FeatureSet: fs})
+	}
+
+	return scopes, nil
+}
+
+// featureSetFor returns the FeatureSet of the first scope matching id, or the
+// handler's global FeatureSet if no scopes match
+func (ih *ImageHandler) featureSetFor(id iiif.ID) *iiif.FeatureSet {
+	for _, scope := range ih.FeatureScopes {
+		var ok, _ = path.Match(scope.Pattern, string(id))
+		if ok {
+			return scope.FeatureSet
+		}
+	}
+	return ih.FeatureSet
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestFeatureScopes(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-scopes")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var scopes = `
+[[Scope]]
+Pattern = "maps/*"
+CapabilitiesFile = "` + filepath.Join(rootDir(), "cap-level0.toml") + `"
+`
+	var fname = filepath.Join(dir, "scopes.toml")
+	ioutil.WriteFile(fname, []byte(scopes), 0644)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.FeatureScopes, err = loadFeatureScopes(fname)
+	assert.NilError(err, "loading scopes", t)
+	assert.Equal(1, len(ih.FeatureScopes), "one scope loaded", t)
+
+	var u, _ = iiif.NewURL("maps%2Fbig.jp2/full/500,/0/default.jpg")
+	assert.False(ih.featureSetFor(u.ID).Supported(u), "level 0 scope rejects arbitrary sizes", t)
+	assert.Equal("http://iiif.io/api/image/2/level0.json", ih.buildInfo(u.ID, ImageInfo{Width: 100, Height: 100}).Profile.ConformanceURL,
+		"info profile reflects the scoped feature set", t)
+
+	u, _ = iiif.NewURL("photos%2Fbig.jp2/full/500,/0/default.jpg")
+	assert.True(ih.featureSetFor(u.ID).Supported(u), "unscoped IDs use the global feature set", t)
+}
+
+func TestApplyFeatureToggles(t *testing.T) {
+	var fs = iiif.FeatureSet1()
+	var err = applyFeatureToggles(fs, parseFeatureList("mirroring, gray,"), parseFeatureList("gray"))
+	assert.NilError(err, "known features", t)
+
+	var u, _ = iiif.NewURL("a.jp2/full/full/!0/default.jpg")
+	assert.True(fs.Supported(u), "enabled features are supported", t)
+	u, _ = iiif.NewURL("a.jp2/full/full/0/gray.jpg")
+	assert.False(fs.Supported(u), "disabling wins", t)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	ih.FeatureSet = fs
+	var info = ih.buildInfo("a.jp2", ImageInfo{Width: 100, Height: 100})
+	assert.Equal("http://iiif.io/api/image/2/level1.json", info.Profile.ConformanceURL, "info reports the level", t)
+
+	assert.True(applyFeatureToggles(fs, nil, []string{"flipping"}) != nil, "unknown features are an error", t)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/cmd/rais-server/internal/servers"
+	"rais/src/plugins"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// checkConfigOnly is set by --check-config: RAIS loads its configuration and
+// plugins as usual, verifies what it can, and exits instead of listening
+var checkConfigOnly bool
+
+// secretWords are parts of setting names whose values are never printed
+var secretWords = []string{"secret", "password", "token", "credential", "signedurlkeys"}
+
+// checkConfig prints the effective configuration and any problems found with
+// it, returning the exit code: 0 if everything checks out, 1 otherwise.  All
+// of the image handler's files and the plugins must already be loaded.
+func checkConfig(ih *ImageHandler) int {
+	writeEffectiveConfig(os.Stdout)
+
+	var problems = configProblems(ih)
+	if len(problems) == 0 {
+		fmt.Println("# Configuration OK")
+		return 0
+	}
+	for _, p := range problems {
+		fmt.Printf("ERROR: %s\n", p)
+	}
+	return 1
+}
+
+// writeEffectiveConfig writes every known setting's value, after defaults,
+// the config file, environment variables, and flags have been merged, as
+// TOML.  Settings nothing reads are left out, as are secrets' values.
+func writeEffectiveConfig(w io.Writer) {
+	var file = viper.ConfigFileUsed()
+	if file == "" {
+		file = "none"
+	}
+	fmt.Fprintf(w, "# Effective RAIS configuration (config file: %s)\n", file)
+
+	for _, name := range plugins.KnownKeys() {
+		var val = viper.Get(name)
+		if val == nil {
+			continue
+		}
+		if isSecretKey(name) && fmt.Sprint(val) != "" {
+			val = "(redacted)"
+		}
+		fmt.Fprintf(w, "%s = %s\n", name, formatSetting(val))
+	}
+}
+
+func formatSetting(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []string:
+		return fmt.Sprintf("%q", strings.Join(v, ","))
+	}
+	return fmt.Sprint(val)
+}
+
+// isSecretKey returns true if the named setting holds a password, key, or
+// other credential
+func isSecretKey(name string) bool {
+	var lower = strings.ToLower(name)
+	for _, word := range secretWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// configProblems verifies the things RAIS can't check until it's serving
+// requests: that image paths are readable, that the files RAIS saves state
+// to can be written, that TLS certificates and keys load, and that every
+// plugin loaded
+func configProblems(ih *ImageHandler) []string {
+	var problems []string
+	var add = func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, e := range pluginErrors {
+		add("plugin failed to load: %s", e)
+	}
+
+	var err = checkDir(ih.TilePath)
+	if err != nil {
+		add("TilePath is not readable: %s", err)
+	}
+	for _, r := range ih.Routes {
+		if r.TilePath == "" {
+			continue
+		}
+		err = checkDir(r.TilePath)
+		if err != nil {
+			add("tile path for route %q is not readable: %s", r.Prefix, err)
+		}
+	}
+	if ih.OverlayPath != "" {
+		err = checkDir(ih.OverlayPath)
+		if err != nil {
+			add("OverlayPath is not readable: %s", err)
+		}
+	}
+
+	var stateFiles = []struct{ setting, fname string }{
+		{"InfoCacheFile", conf.InfoCacheFile},
+		{"TakedownFile", conf.TakedownFile},
+		{"ChangeDiscoveryFile", conf.ChangeDiscoveryFile},
+	}
+	for _, sf := range stateFiles {
+		if sf.fname == "" {
+			continue
+		}
+		err = checkWritable(filepath.Dir(sf.fname))
+		if err != nil {
+			add("%s %q can't be saved: %s", sf.setting, sf.fname, err)
+		}
+	}
+
+	if len(conf.ACMEDomains) > 0 {
+		// The cache directory is created when the first certificate is saved
+		var dir = conf.ACMECacheDir
+		if _, err = os.Stat(dir); os.IsNotExist(err) {
+			dir = filepath.Dir(dir)
+		}
+		err = checkWritable(dir)
+		if err != nil {
+			add("ACME certificates can't be saved in %q: %s", conf.ACMECacheDir, err)
+		}
+	}
+
+	for _, msg := range listenerProblems() {
+		add("%s", msg)
+	}
+	if conf.JWTRulesFile != "" {
+		_, err = loadJWTRules(conf.JWTRulesFile)
+		if err != nil {
+			add("invalid JWT rules file %q: %s", conf.JWTRulesFile, err)
+		}
+	}
+
+	return problems
+}
+
+// listenerProblems loads the configured listeners without binding their
+// addresses, and checks their TLS certificates and unix socket directories
+func listenerProblems() []string {
+	var listeners = defaultListeners(conf)
+	if conf.ListenersFile != "" {
+		var err error
+		listeners, err = loadListeners(conf.ListenersFile)
+		if err != nil {
+			return []string{fmt.Sprintf("invalid listeners file %q: %s", conf.ListenersFile, err)}
+		}
+	}
+
+	var problems []string
+	for _, l := range listeners {
+		if l.TLSCert != "" {
+			var _, err = tls.LoadX509KeyPair(l.TLSCert, l.TLSKey)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("listener %q: unable to load TLS certificate: %s", l.Name, err))
+			}
+		}
+		if l.ACME && len(conf.ACMEDomains) == 0 {
+			problems = append(problems, fmt.Sprintf("listener %q: ACME requires ACMEDomains", l.Name))
+		}
+		if l.unixSocket() {
+			var dir = filepath.Dir(strings.TrimPrefix(l.Address, servers.UnixPrefix))
+			var err = checkWritable(dir)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("listener %q: can't create socket: %s", l.Name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// checkWritable returns an error if a file can't be created in dir.  A
+// temporary file is created and immediately removed to be sure.
+func checkWritable(dir string) error {
+	var f, err = ioutil.TempFile(dir, ".rais-check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
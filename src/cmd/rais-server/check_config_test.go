@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/plugins"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestWriteEffectiveConfig(t *testing.T) {
+	defer viper.Reset()
+	plugins.RegisterKeys("TilePath", "CachePeerSecret", "TestCheckPort")
+	viper.Set("TilePath", "/var/local/images")
+	viper.Set("CachePeerSecret", "hunter2")
+	viper.Set("TestCheckPort", 8080)
+
+	var buf bytes.Buffer
+	writeEffectiveConfig(&buf)
+	var out = buf.String()
+	assert.True(strings.Contains(out, `TilePath = "/var/local/images"`+"\n"), "strings are quoted", t)
+	assert.True(strings.Contains(out, `CachePeerSecret = "(redacted)"`+"\n"), "secrets are redacted", t)
+	assert.True(strings.Contains(out, "TestCheckPort = 8080\n"), "numbers aren't quoted", t)
+	assert.False(strings.Contains(out, "hunter2"), "secret values aren't printed", t)
+}
+
+func TestIsSecretKey(t *testing.T) {
+	assert.True(isSecretKey("CachePeerSecret"), "peer secret", t)
+	assert.True(isSecretKey("SignedURLKeys"), "signing keys", t)
+	assert.True(isSecretKey("CDNAPIToken"), "plugin token", t)
+	assert.False(isSecretKey("TLSKey"), "TLS key is a path", t)
+	assert.False(isSecretKey("RateLimitKeyHeader"), "header name", t)
+}
+
+func TestConfigProblems(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-check-config")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	defer func(c *Config) { conf = c }(conf)
+	conf = &Config{Address: ":0", TakedownFile: filepath.Join(dir, "takedowns.json")}
+
+	var ih = NewImageHandler(dir, "/iiif")
+	assert.Equal(0, len(configProblems(ih)), "valid paths", t)
+
+	conf.InfoCacheFile = filepath.Join(dir, "missing", "info.json")
+	conf.TLSCert = filepath.Join(dir, "cert.pem")
+	conf.TLSKey = filepath.Join(dir, "key.pem")
+	ih = NewImageHandler(filepath.Join(dir, "nope"), "/iiif")
+	var problems = configProblems(ih)
+	assert.Equal(3, len(problems), "tile path, info cache file, and TLS certificate", t)
+	assert.True(strings.HasPrefix(problems[0], "TilePath"), "tile path problem", t)
+	assert.True(strings.HasPrefix(problems[1], "InfoCacheFile"), "info cache file problem", t)
+	assert.True(strings.Contains(problems[2], "TLS certificate"), "TLS problem", t)
+
+	var entries, _ = ioutil.ReadDir(dir)
+	assert.Equal(0, len(entries), "writability checks leave nothing behind", t)
+}
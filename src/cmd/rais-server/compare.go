@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strconv"
+)
+
+// compareGap is the width of the blank column between images in side-by-side
+// comparisons
+const compareGap = 8
+
+// compareHandler renders an "old" and a freshly decoded version of a IIIF
+// request together, so re-scanned masters can be checked before caches are
+// purged.  Requests look like this:
+//
+//	/admin/compare?path=foo.jp2/full/512,/0/default.jpg&mode=overlay
+//
+// The old image is the tile cache's copy of the request, or, if "against" is
+// set to another IIIF ID, that ID rendered with the same parameters (e.g., a
+// copy of the old master kept under a different name).  The new image is
+// always decoded from the source file, bypassing RAIS's caches.
+//
+// "mode" may be "side" (the default) for a side-by-side image, old on the
+// left, or "overlay" to blend the new image over the old one; "opacity" sets
+// the new image's opacity for overlays, and defaults to 0.5.  The response is
+// always a PNG.
+type compareHandler struct {
+	ih *ImageHandler
+}
+
+func (ch *compareHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var q = req.URL.Query()
+	var u, err = iiif.NewURL(q.Get("path"))
+	if err == nil && (u.Info || !u.Valid()) {
+		err = fmt.Errorf("path must be a IIIF image request")
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid path %q: %s", q.Get("path"), err), http.StatusBadRequest)
+		return
+	}
+
+	var opacity = 0.5
+	if q.Get("opacity") != "" {
+		opacity, err = strconv.ParseFloat(q.Get("opacity"), 64)
+		if err != nil || opacity < 0 || opacity > 1 {
+			http.Error(w, "opacity must be a number from 0 to 1", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var mode = q.Get("mode")
+	if mode != "" && mode != "side" && mode != "overlay" {
+		http.Error(w, `mode must be "side" or "overlay"`, http.StatusBadRequest)
+		return
+	}
+
+	var oldImg image.Image
+	var e *HandlerError
+	var against = q.Get("against")
+	if against != "" {
+		oldImg, e = ch.ih.render(req.Context(), iiif.ID(against), u)
+	} else {
+		oldImg, e = ch.ih.cachedImage(req.Context(), u)
+	}
+	if e != nil {
+		http.Error(w, "Unable to load old image: "+e.Message, e.Code)
+		return
+	}
+
+	var newImg image.Image
+	newImg, e = ch.ih.render(req.Context(), u.ID, u)
+	if e != nil {
+		http.Error(w, "Unable to decode new image: "+e.Message, e.Code)
+		return
+	}
+
+	var out image.Image
+	if mode == "overlay" {
+		out = overlay(oldImg, newImg, opacity)
+	} else {
+		out = sideBySide(oldImg, newImg)
+	}
+
+	var buf = bytes.NewBuffer(nil)
+	err = png.Encode(buf, out)
+	if err != nil {
+		Logger.Errorf("Unable to encode comparison image: %s", err)
+		http.Error(w, "Unable to encode", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
+
+// cachedImage returns the tile cache's copy of the given IIIF request
+func (ih *ImageHandler) cachedImage(ctx context.Context, u *iiif.URL) (image.Image, *HandlerError) {
+	var key string
+	if fp, err := ih.resolveIIIFPath(ctx, u.ID); err == nil {
+		var info, _ = ih.getInfo(ctx, u.ID, fp)
+		key = ih.cacheKey(u, fp, info)
+	}
+	if key == "" {
+		return nil, NewError("request is not cacheable; use \"against\" to compare with another ID", 404)
+	}
+	var data, ok = tileCache.Peek(key)
+	if !ok {
+		return nil, NewError("request is not in the tile cache; use \"against\" to compare with another ID", 404)
+	}
+
+	var i, _, err = image.Decode(bytes.NewReader(data.(*tileEntry).data))
+	if err != nil {
+		return nil, NewError("unable to decode cached image: "+err.Error(), 500)
+	}
+	return i, nil
+}
+
+// render decodes the given ID's source image and applies u's transformations
+// to it, skipping all caches
+func (ih *ImageHandler) render(ctx context.Context, id iiif.ID, u *iiif.URL) (image.Image, *HandlerError) {
+	var u2 = *u
+	u2.ID = id
+	var fp, _ = ih.resolveIIIFPath(ctx, id)
+	var res, err = img.NewResourceContext(ctx, id, fp)
+	if err != nil {
+		return nil, newImageResError(err)
+	}
+	res.Filter = ih.Filter
+	res.Sharpen = ih.Sharpen
+	res.Upscale = ih.Upscale
+	res.Layers = ih.Layers
+	res.Background = ih.Background
+
+	var done = load.start()
+	defer done()
+	var i image.Image
+	i, err = res.Apply(&u2, ih.maximumsFor(id))
+	if err != nil {
+		return nil, newImageResError(err)
+	}
+	return i, nil
+}
+
+// sideBySide returns a new image with a on the left and b on the right
+func sideBySide(a, b image.Image) image.Image {
+	var ab, bb = a.Bounds(), b.Bounds()
+	var h = ab.Dy()
+	if bb.Dy() > h {
+		h = bb.Dy()
+	}
+	var out = image.NewRGBA(image.Rect(0, 0, ab.Dx()+compareGap+bb.Dx(), h))
+	draw.Draw(out, out.Bounds(), image.White, image.ZP, draw.Src)
+	draw.Draw(out, image.Rect(0, 0, ab.Dx(), ab.Dy()), a, ab.Min, draw.Src)
+	draw.Draw(out, image.Rect(ab.Dx()+compareGap, 0, out.Bounds().Dx(), bb.Dy()), b, bb.Min, draw.Src)
+	return out
+}
+
+// overlay returns a new image with b drawn over a at the given opacity.  The
+// output is the size of a; if b is larger, the excess is cropped.
+func overlay(a, b image.Image, opacity float64) image.Image {
+	var ab = a.Bounds()
+	var out = image.NewRGBA(image.Rect(0, 0, ab.Dx(), ab.Dy()))
+	draw.Draw(out, out.Bounds(), a, ab.Min, draw.Src)
+	var mask = image.NewUniform(color.Alpha{uint8(opacity*255 + 0.5)})
+	draw.DrawMask(out, out.Bounds(), b, b.Bounds().Min, mask, image.ZP, draw.Over)
+	return out
+}
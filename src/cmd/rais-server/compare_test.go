@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+	"rais/src/fakehttp"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func solid(w, h int, c color.Color) image.Image {
+	var i = image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			i.Set(x, y, c)
+		}
+	}
+	return i
+}
+
+func TestSideBySide(t *testing.T) {
+	var out = sideBySide(solid(10, 20, color.Black), solid(30, 5, color.Black))
+	assert.Equal(10+compareGap+30, out.Bounds().Dx(), "width includes both images and the gap", t)
+	assert.Equal(20, out.Bounds().Dy(), "height is the taller image's height", t)
+}
+
+func TestOverlay(t *testing.T) {
+	var out = overlay(solid(4, 4, color.Black), solid(8, 8, color.White), 0.5)
+	assert.Equal(4, out.Bounds().Dx(), "overlay is the size of the old image", t)
+	var r, _, _, _ = out.At(1, 1).RGBA()
+	assert.True(r > 0x7000 && r < 0x9000, "colors are blended", t)
+}
+
+func TestCompareInvalid(t *testing.T) {
+	var ch = &compareHandler{ih: NewImageHandler(rootDir(), "/iiif")}
+	for _, q := range []string{"path=foo.jp2/info.json", "path=foo.jp2/full/full/0/default.jpg&mode=blink"} {
+		var req, _ = http.NewRequest("GET", "/admin/compare?"+q, strings.NewReader(""))
+		var w = fakehttp.NewResponseWriter()
+		ch.ServeHTTP(w, req)
+		assert.Equal(400, w.StatusCode, "invalid request: "+q, t)
+	}
+}
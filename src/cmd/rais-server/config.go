@@ -2,15 +2,196 @@
 
 import (
 	"fmt"
+	"image/color"
 	"math"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/plugins"
+	"rais/src/roi"
+	"rais/src/transform"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/logger"
 )
 
+// Config holds the core RAIS settings.  It's populated once by parseConf after
+// defaults, the config file, the environment, and CLI flags have all been
+// merged, so the rest of the server reads typed, validated values rather than
+// asking viper for them.  Plugins read their settings via plugins.Config.
+type Config struct {
+	Address        string
+	AdminAddress   string
+	TLSCert        string
+	TLSKey         string
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	RequestTimeout time.Duration
+	MaxHeaderBytes int
+	HTTP2          bool
+
+	TilePath             string
+	OverlayPath          string
+	IIIFWebPath          string
+	IIIFBaseURL          *url.URL
+	CapabilitiesFile     string
+	CapabilityScopesFile string
+	CachePolicyFile      string
+	InfoExtensionsFile   string
+	TakedownFile         string
+	RoutesFile           string
+	DecoderExtensions    map[string]string
+	InfoCacheLen         int
+	TileCacheLen         int
+	ImageMaxArea         int64
+	ImageMaxWidth        int
+	ImageMaxHeight       int
+	SourceMax            img.SourceLimits
+	TileGrid             TileGrid
+	ResizeFilter         transform.Filter
+	Sharpen              transform.UnsharpMask
+	Upscale              img.UpscaleMode
+	BackgroundColor      color.Color
+	PageSeparator        string
+	GeoService           bool
+	MetadataService      bool
+	MetadataFields       []string
+	ROIService           bool
+	ROIMethod            roi.Method
+
+	LogLevel       logger.LogLevel
+	LogLevels      map[string]logger.LogLevel
+	LogOutput      string
+	LogMaxSize     int64
+	LogMaxBackups  int
+	AccessLog      string
+	HealthCanaryID string
+	LoadCapacity   int
+	DecodeThreads  int
+	DecodeMapFiles bool
+	DecodeWorkers  int
+	HeaderCacheLen int
+	EncodeWorkers  int
+	DecodeSlots    int
+	DecodeWeights  [numClasses]int
+	Plugins        string
+
+	ExternalPlugins string
+
+	SlowRequestCount    int
+	SlowRequestInterval time.Duration
+
+	InfoCacheFile         string
+	InfoCacheSaveInterval time.Duration
+
+	TrustedProxies []*net.IPNet
+
+	AliasFile           string
+	AliasReloadInterval time.Duration
+
+	RateLimit            float64
+	RateLimitBurst       int
+	RateLimitConcurrency int
+	RateLimitKeyHeader   string
+
+	SignedURLKeys []string
+
+	JWKSURL      string
+	JWTIssuer    string
+	JWTAudience  string
+	JWTRulesFile string
+
+	ListenersFile string
+	FastCGI       bool
+	SocketMode    os.FileMode
+
+	ACMEDomains      []string
+	ACMECacheDir     string
+	ACMEEmail        string
+	ACMEDirectoryURL string
+
+	UsagePrefixes  []string
+	UsageDelimiter string
+
+	TileCacheBytes         int64
+	TileCacheMaxEntryBytes int64
+	TileCacheMaxDimension  int
+	TileCacheFormats       map[iiif.Format]bool
+	TileCacheStale         string
+	TileCacheKey           string
+	TilePrefetchWorkers    int
+	TilePrefetchQueue      int
+
+	MissingCacheLen int
+	MissingCacheTTL time.Duration
+
+	QuarantineTTL      time.Duration
+	QuarantineTimeouts int
+
+	DecodeWorkerSandbox   bool
+	DecodeWorkerReadPaths []string
+
+	CachePeers      []string
+	CachePeerDNS    string
+	CachePeerSelf   string
+	CachePeerSecret string
+
+	SourceWatch        string
+	SourceScanInterval time.Duration
+
+	PNGCompression      string
+	PNGBitDepth         int
+	PNGPaletteMaxPixels int
+
+	QualityLayers        int
+	QualityLayersMaxSize int
+	DecodeBudget         time.Duration
+	DecodeBudgetRate     float64
+
+	AdjustmentParams bool
+
+	CanonicalRedirect bool
+
+	ComplianceLevel  string
+	EnabledFeatures  []string
+	DisabledFeatures []string
+
+	AllowUnescapedSlashes bool
+
+	ChangeDiscoveryPath string
+	ChangeDiscoveryLen  int
+	ChangeDiscoveryFile string
+
+	DemoViewer          bool
+	DemoViewerScriptURL string
+}
+
+// conf is the server's configuration, set up by parseConf
+var conf *Config
+
+// deprecatedKeys maps old setting names to their replacements.  Old names
+// still work when the new name isn't set, but a warning is logged.
+var deprecatedKeys = map[string]string{
+	"S3_Endpoint": "S3Endpoint",
+}
+
+// confWarnings holds non-fatal configuration problems found before the logger
+// exists; they're logged once it does
+var confWarnings []string
+
+// fileKeys holds all setting names found in the config file, if one was used
+var fileKeys []string
+
 // parseConf centralizes all config reading and validating for the core RAIS options
 func parseConf() {
 	// Default configuration values
@@ -19,13 +200,50 @@ func parseConf() {
 	var defaultInfoCacheLen = 10000
 	var defaultLogLevel = logger.Debug.String()
 	var defaultPlugins = "s3-images.so,json-tracer.so"
+	var defaultReadTimeout = "5s"
+	var defaultWriteTimeout = "30s"
+	var defaultIdleTimeout = "2m"
+	var defaultMaxHeaderBytes = 1 << 20
 
 	// Defaults
 	viper.SetDefault("Address", defaultAddress)
 	viper.SetDefault("AdminAddress", defaultAdminAddress)
 	viper.SetDefault("InfoCacheLen", defaultInfoCacheLen)
+	viper.SetDefault("InfoCacheSaveInterval", "5m")
+	viper.SetDefault("MissingCacheLen", 10000)
+	viper.SetDefault("MissingCacheTTL", "0s")
+	viper.SetDefault("QuarantineTTL", "0s")
+	viper.SetDefault("QuarantineTimeouts", 3)
+	viper.SetDefault("SourceScanInterval", "5m")
+	viper.SetDefault("AliasReloadInterval", "30s")
 	viper.SetDefault("LogLevel", defaultLogLevel)
+	viper.SetDefault("LogOutput", logStderr)
+	viper.SetDefault("LogMaxBackups", 5)
 	viper.SetDefault("Plugins", defaultPlugins)
+	viper.SetDefault("ReadTimeout", defaultReadTimeout)
+	viper.SetDefault("WriteTimeout", defaultWriteTimeout)
+	viper.SetDefault("IdleTimeout", defaultIdleTimeout)
+	viper.SetDefault("RequestTimeout", "0s")
+	viper.SetDefault("MaxHeaderBytes", defaultMaxHeaderBytes)
+	viper.SetDefault("HTTP2", true)
+	viper.SetDefault("SlowRequestCount", 10)
+	viper.SetDefault("SlowRequestInterval", "1m")
+	viper.SetDefault("DecodeThreads", 1)
+	viper.SetDefault("HeaderCacheLen", 1000)
+	viper.SetDefault("DecodeWeights", "tile:8, thumbnail:4, export:1")
+	viper.SetDefault("TileCacheMaxDimension", 1024)
+	viper.SetDefault("TileCacheFormats", "jpg")
+	viper.SetDefault("TileCacheStale", staleRevalidate)
+	viper.SetDefault("TileCacheKey", keyRequest)
+	viper.SetDefault("TilePrefetchQueue", 100)
+	viper.SetDefault("SharpenRadius", 1.0)
+	viper.SetDefault("PageSeparator", ";")
+	viper.SetDefault("QualityLayersMaxSize", 256)
+	viper.SetDefault("DecodeBudget", "0s")
+	viper.SetDefault("DecodeBudgetRate", 20)
+	viper.SetDefault("ComplianceLevel", "max")
+	viper.SetDefault("AllowUnescapedSlashes", true)
+	viper.SetDefault("ChangeDiscoveryLen", 10000)
 
 	// Allow all configuration to be in environment variables
 	viper.SetEnvPrefix("RAIS")
@@ -41,23 +259,53 @@ func parseConf() {
 			os.Exit(1)
 		}
 	}
+	readFileKeys()
 
 	// CLI flags
 	pflag.String("iiif-base-url", "", "Base URL for RAIS to report in info.json requests "+
 		"(defaults to the requests as they come in, so you probably don't want to set this)")
 	viper.BindPFlag("IIIFBaseURL", pflag.CommandLine.Lookup("iiif-base-url"))
+	pflag.String("trusted-proxies", "", "Comma-separated IPs and CIDR ranges of proxies whose "+
+		"forwarding headers are honored (defaults to trusting all clients)")
+	viper.BindPFlag("TrustedProxies", pflag.CommandLine.Lookup("trusted-proxies"))
 	pflag.String("iiif-web-path", "/iiif", `Base path for serving IIIF requests, e.g., "/iiif"`)
 	viper.BindPFlag("IIIFWebPath", pflag.CommandLine.Lookup("iiif-web-path"))
 	pflag.String("address", defaultAddress, "http service address")
 	viper.BindPFlag("Address", pflag.CommandLine.Lookup("address"))
 	pflag.String("admin-address", defaultAdminAddress, "http service for administrative endpoints")
 	viper.BindPFlag("AdminAddress", pflag.CommandLine.Lookup("admin-address"))
+	pflag.String("listeners-file", "", "Path to a TOML file defining listeners and the routes each serves; "+
+		"overrides --address, --admin-address, --tls-cert, and --tls-key")
+	viper.BindPFlag("ListenersFile", pflag.CommandLine.Lookup("listeners-file"))
+	pflag.Bool("fastcgi", false, "Speak FastCGI rather than HTTP on --address, for use behind Apache or nginx")
+	viper.BindPFlag("FastCGI", pflag.CommandLine.Lookup("fastcgi"))
+	pflag.String("socket-mode", "", `Permissions for unix sockets, in octal (e.g., "0660"); the umask applies if empty`)
+	viper.BindPFlag("SocketMode", pflag.CommandLine.Lookup("socket-mode"))
 	pflag.String("tile-path", "", "Base path for images")
 	viper.BindPFlag("TilePath", pflag.CommandLine.Lookup("tile-path"))
+	pflag.String("overlay-path", "", "Directory mirroring image IDs where info.json override files may be kept")
+	viper.BindPFlag("OverlayPath", pflag.CommandLine.Lookup("overlay-path"))
 	pflag.Int("iiif-info-cache-size", defaultInfoCacheLen, "Maximum cached image info entries (IIIF only)")
 	viper.BindPFlag("InfoCacheLen", pflag.CommandLine.Lookup("iiif-info-cache-size"))
+	pflag.String("iiif-info-cache-file", "", "JSON file the info cache is saved to so it survives restarts")
+	viper.BindPFlag("InfoCacheFile", pflag.CommandLine.Lookup("iiif-info-cache-file"))
 	pflag.String("capabilities-file", "", "TOML file describing capabilities, rather than everything RAIS supports")
 	viper.BindPFlag("CapabilitiesFile", pflag.CommandLine.Lookup("capabilities-file"))
+	pflag.String("capability-scopes-file", "", "TOML file assigning capabilities files to image ID patterns")
+	viper.BindPFlag("CapabilityScopesFile", pflag.CommandLine.Lookup("capability-scopes-file"))
+	pflag.String("cache-policy-file", "", "TOML file describing Cache-Control and surrogate headers to send")
+	viper.BindPFlag("CachePolicyFile", pflag.CommandLine.Lookup("cache-policy-file"))
+	pflag.String("info-extensions-file", "", "TOML file with static properties to add to info.json responses")
+	viper.BindPFlag("InfoExtensionsFile", pflag.CommandLine.Lookup("info-extensions-file"))
+	pflag.String("takedown-file", "", "JSON file where image takedowns are stored so they persist across restarts")
+	viper.BindPFlag("TakedownFile", pflag.CommandLine.Lookup("takedown-file"))
+	pflag.String("routes-file", "", "TOML file mapping IIIF ID prefixes to other tile paths")
+	viper.BindPFlag("RoutesFile", pflag.CommandLine.Lookup("routes-file"))
+	pflag.String("alias-file", "", "JSON file mapping public IIIF IDs to real IDs or file paths")
+	viper.BindPFlag("AliasFile", pflag.CommandLine.Lookup("alias-file"))
+	pflag.String("decoder-extensions", "", `Comma-separated extension-to-decoder mappings, e.g., `+
+		`".jpf:openjpeg,.jpx:openjpeg,.bmp:imagick"`)
+	viper.BindPFlag("DecoderExtensions", pflag.CommandLine.Lookup("decoder-extensions"))
 	pflag.String("log-level", defaultLogLevel, "Log level: the server will only log notifications at "+
 		"this level and above (must be DEBUG, INFO, WARN, ERROR, or CRIT)")
 	viper.BindPFlag("LogLevel", pflag.CommandLine.Lookup("log-level"))
@@ -67,27 +315,380 @@ func parseConf() {
 	viper.BindPFlag("ImageMaxWidth", pflag.CommandLine.Lookup("image-max-width"))
 	pflag.Int("image-max-height", math.MaxInt32, "Maximum height of images to be served")
 	viper.BindPFlag("ImageMaxHeight", pflag.CommandLine.Lookup("image-max-height"))
+	pflag.Int("source-max-width", 0, "Maximum width of source images RAIS will read (0 is unlimited)")
+	viper.BindPFlag("SourceMaxWidth", pflag.CommandLine.Lookup("source-max-width"))
+	pflag.Int("source-max-height", 0, "Maximum height of source images RAIS will read (0 is unlimited)")
+	viper.BindPFlag("SourceMaxHeight", pflag.CommandLine.Lookup("source-max-height"))
+	pflag.Int64("source-max-area", 0, "Maximum area (w x h) of source images RAIS will read (0 is unlimited)")
+	viper.BindPFlag("SourceMaxArea", pflag.CommandLine.Lookup("source-max-area"))
+	pflag.Int64("source-max-file-size", 0, "Maximum size, in bytes, of source image files RAIS will read (0 is unlimited)")
+	viper.BindPFlag("SourceMaxFileSize", pflag.CommandLine.Lookup("source-max-file-size"))
+	pflag.Int("tile-width", 0, "Tile width to advertise in info.json, regardless of images' native tiling")
+	viper.BindPFlag("TileWidth", pflag.CommandLine.Lookup("tile-width"))
+	pflag.Int("tile-height", 0, "Tile height to advertise in info.json (defaults to the tile width)")
+	viper.BindPFlag("TileHeight", pflag.CommandLine.Lookup("tile-height"))
+	pflag.String("tile-scale-factors", "", `Comma-separated scale factors to advertise with tiles, e.g., "1,2,4,8"`)
+	viper.BindPFlag("TileScaleFactors", pflag.CommandLine.Lookup("tile-scale-factors"))
+	pflag.String("resize-filter", "", "Resampling filter for scaling images: nearest, bilinear, "+
+		"catmull-rom, or lanczos3 (defaults to each decoder's own choice)")
+	viper.BindPFlag("ResizeFilter", pflag.CommandLine.Lookup("resize-filter"))
+	pflag.Bool("geo-service", false, "Serve georeferencing from GeoTIFFs and GeoJP2s at {id}/geo.json")
+	viper.BindPFlag("GeoService", pflag.CommandLine.Lookup("geo-service"))
+	pflag.Bool("metadata-service", false, "Serve embedded EXIF/XMP/IPTC metadata at {id}/metadata.json")
+	viper.BindPFlag("MetadataService", pflag.CommandLine.Lookup("metadata-service"))
+	pflag.String("metadata-fields", "", "Comma-separated allowlist of metadata fields, e.g., "+
+		"\"exif.Artist,xmp.dc:creator,iptc.*\" (defaults to dates, credits, and descriptions)")
+	viper.BindPFlag("MetadataFields", pflag.CommandLine.Lookup("metadata-fields"))
+	pflag.Bool("roi-service", false, "Serve content-aware crop suggestions at {id}/roi.json")
+	viper.BindPFlag("ROIService", pflag.CommandLine.Lookup("roi-service"))
+	pflag.String("roi-method", "entropy", "How roi.json finds interesting content: entropy or saliency")
+	viper.BindPFlag("ROIMethod", pflag.CommandLine.Lookup("roi-method"))
+	pflag.String("upscale", "allow", "How sizes larger than their region are handled without the "+
+		"\"^\" modifier: allow, clamp, or reject")
+	viper.BindPFlag("Upscale", pflag.CommandLine.Lookup("upscale"))
+	pflag.Float64("sharpen-amount", 0, "Strength of the unsharp mask applied to downscaled images, "+
+		"e.g., 0.5 (0 disables sharpening)")
+	viper.BindPFlag("SharpenAmount", pflag.CommandLine.Lookup("sharpen-amount"))
+	pflag.Float64("sharpen-radius", 1.0, "Blur radius, in pixels, of the unsharp mask")
+	viper.BindPFlag("SharpenRadius", pflag.CommandLine.Lookup("sharpen-radius"))
+	pflag.Int("sharpen-threshold", 0, "Minimum difference (0-255) the unsharp mask will sharpen")
+	viper.BindPFlag("SharpenThreshold", pflag.CommandLine.Lookup("sharpen-threshold"))
+	pflag.String("tls-cert", "", "Path to a TLS certificate file; when set along with --tls-key, "+
+		"RAIS serves HTTPS instead of HTTP")
+	viper.BindPFlag("TLSCert", pflag.CommandLine.Lookup("tls-cert"))
+	pflag.String("tls-key", "", "Path to the private key file for the TLS certificate")
+	viper.BindPFlag("TLSKey", pflag.CommandLine.Lookup("tls-key"))
+	pflag.String("acme-domains", "", "Comma-separated host names to get TLS certificates for "+
+		"automatically via ACME (e.g., Let's Encrypt); requires --acme-cache-dir")
+	viper.BindPFlag("ACMEDomains", pflag.CommandLine.Lookup("acme-domains"))
+	pflag.String("acme-cache-dir", "", "Directory to store ACME account keys and certificates in")
+	viper.BindPFlag("ACMECacheDir", pflag.CommandLine.Lookup("acme-cache-dir"))
+	pflag.String("read-timeout", defaultReadTimeout, "Maximum time to read an entire request, including the body")
+	viper.BindPFlag("ReadTimeout", pflag.CommandLine.Lookup("read-timeout"))
+	pflag.String("write-timeout", defaultWriteTimeout, "Maximum time to write a response")
+	viper.BindPFlag("WriteTimeout", pflag.CommandLine.Lookup("write-timeout"))
+	pflag.String("idle-timeout", defaultIdleTimeout, "Maximum time to keep an idle keep-alive connection open")
+	viper.BindPFlag("IdleTimeout", pflag.CommandLine.Lookup("idle-timeout"))
+	pflag.String("request-timeout", "0s", "Maximum time to spend on an image request before abandoning it (0s for no limit)")
+	viper.BindPFlag("RequestTimeout", pflag.CommandLine.Lookup("request-timeout"))
+	pflag.Int("max-header-bytes", defaultMaxHeaderBytes, "Maximum size of request headers, in bytes")
+	viper.BindPFlag("MaxHeaderBytes", pflag.CommandLine.Lookup("max-header-bytes"))
+	pflag.Bool("http2", true, "Allow HTTP/2 on TLS connections")
+	viper.BindPFlag("HTTP2", pflag.CommandLine.Lookup("http2"))
+	pflag.String("access-log", "", `Path to write JSON access logs, or "-" for stdout (disabled if empty)`)
+	viper.BindPFlag("AccessLog", pflag.CommandLine.Lookup("access-log"))
+	pflag.String("health-canary-id", "", "IIIF ID of an image to decode as part of readiness checks")
+	viper.BindPFlag("HealthCanaryID", pflag.CommandLine.Lookup("health-canary-id"))
+	pflag.Float64("rate-limit", 0, "Maximum IIIF requests per second from any one client (0 means no limit)")
+	viper.BindPFlag("RateLimit", pflag.CommandLine.Lookup("rate-limit"))
+	pflag.Int("rate-limit-burst", 0, "Number of requests a client may make in a burst before --rate-limit "+
+		"applies (defaults to the rate limit)")
+	viper.BindPFlag("RateLimitBurst", pflag.CommandLine.Lookup("rate-limit-burst"))
+	pflag.Int("rate-limit-concurrency", 0, "Maximum concurrent IIIF requests from any one client (0 means no limit)")
+	viper.BindPFlag("RateLimitConcurrency", pflag.CommandLine.Lookup("rate-limit-concurrency"))
+	pflag.String("rate-limit-key-header", "", `Request header identifying clients for rate limiting, `+
+		`e.g., "X-API-Key" (defaults to the client's IP address)`)
+	viper.BindPFlag("RateLimitKeyHeader", pflag.CommandLine.Lookup("rate-limit-key-header"))
+	pflag.String("jwks-url", "", "URL of the JWKS used to validate bearer tokens; when set, IIIF requests "+
+		"are authorized via JWTs")
+	viper.BindPFlag("JWKSURL", pflag.CommandLine.Lookup("jwks-url"))
+	pflag.String("jwt-issuer", "", "Required issuer (iss) of bearer tokens")
+	viper.BindPFlag("JWTIssuer", pflag.CommandLine.Lookup("jwt-issuer"))
+	pflag.String("jwt-audience", "", "Required audience (aud) of bearer tokens")
+	viper.BindPFlag("JWTAudience", pflag.CommandLine.Lookup("jwt-audience"))
+	pflag.String("jwt-rules-file", "", "TOML file mapping token claims to allowed ID prefixes and sizes")
+	viper.BindPFlag("JWTRulesFile", pflag.CommandLine.Lookup("jwt-rules-file"))
 	pflag.String("plugins", defaultPlugins, "comma-separated plugin pattern list, e.g., "+
 		`"s3-images.so,datadog.so,json-tracer.so,/opt/rais/plugins/*.so"`)
 	viper.BindPFlag("Plugins", pflag.CommandLine.Lookup("plugins"))
+	pflag.String("external-plugins", "", "comma-separated list of external plugin executables, e.g., "+
+		`"/opt/rais/bin/catalog-resolver"`)
+	viper.BindPFlag("ExternalPlugins", pflag.CommandLine.Lookup("external-plugins"))
+	pflag.BoolVar(&checkConfigOnly, "check-config", false, "Verify the configuration and print the effective "+
+		"settings, then exit without serving anything")
 
 	pflag.Parse()
 
-	// Make sure required values exist
-	if !viper.IsSet("TilePath") {
-		fmt.Println("ERROR: tile path is required")
+	applyDeprecations()
+
+	var errs []error
+	conf, errs = readConfig()
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Printf("ERROR: %s\n", err)
+		}
 		pflag.Usage()
 		os.Exit(1)
 	}
+}
+
+// readFileKeys stores the names of all settings in the config file so we can
+// warn about any nothing ends up using.  The global viper instance merges in
+// defaults and other sources, so we need a separate instance for this.
+func readFileKeys() {
+	var fname = viper.ConfigFileUsed()
+	if fname == "" {
+		return
+	}
 
-	var level = logger.LogLevelFromString(viper.GetString("LogLevel"))
-	if level == logger.Invalid {
-		fmt.Println("ERROR: Invalid log level (must be DEBUG, INFO, WARN, ERROR, or CRIT)")
-		pflag.Usage()
-		os.Exit(1)
+	var v = viper.New()
+	v.SetConfigFile(fname)
+	if v.ReadInConfig() == nil {
+		fileKeys = v.AllKeys()
+	}
+}
+
+// applyDeprecations copies values from deprecated setting names to their
+// replacements and queues up warnings about the old names
+func applyDeprecations() {
+	var oldKeys []string
+	for old := range deprecatedKeys {
+		oldKeys = append(oldKeys, old)
 	}
+	sort.Strings(oldKeys)
+
+	for _, old := range oldKeys {
+		if !viper.IsSet(old) {
+			continue
+		}
 
-	var baseIIIFURL = viper.GetString("IIIFBaseURL")
+		var key = deprecatedKeys[old]
+		if viper.IsSet(key) {
+			confWarnings = append(confWarnings, fmt.Sprintf("Deprecated setting %q is ignored because %q is also set", old, key))
+			continue
+		}
+		viper.Set(key, viper.Get(old))
+		confWarnings = append(confWarnings, fmt.Sprintf("Setting %q is deprecated; use %q instead", old, key))
+	}
+}
+
+// readConfig pulls all core settings into a Config, returning a list of
+// everything that's invalid
+func readConfig() (*Config, []error) {
+	var errs []error
+	var c = plugins.NewConfig("")
+	var cfg = &Config{
+		Address:              c.GetString("Address"),
+		AdminAddress:         c.GetString("AdminAddress"),
+		TLSCert:              c.GetString("TLSCert"),
+		TLSKey:               c.GetString("TLSKey"),
+		MaxHeaderBytes:       c.GetInt("MaxHeaderBytes"),
+		HTTP2:                c.GetBool("HTTP2"),
+		TilePath:             c.GetString("TilePath"),
+		OverlayPath:          c.GetString("OverlayPath"),
+		IIIFWebPath:          c.GetString("IIIFWebPath"),
+		CapabilitiesFile:     c.GetString("CapabilitiesFile"),
+		CapabilityScopesFile: c.GetString("CapabilityScopesFile"),
+		CachePolicyFile:      c.GetString("CachePolicyFile"),
+		InfoExtensionsFile:   c.GetString("InfoExtensionsFile"),
+		TakedownFile:         c.GetString("TakedownFile"),
+		RoutesFile:           c.GetString("RoutesFile"),
+		InfoCacheLen:         c.GetInt("InfoCacheLen"),
+		TileCacheLen:         c.GetInt("TileCacheLen"),
+		ImageMaxArea:         c.GetInt64("ImageMaxArea"),
+		ImageMaxWidth:        c.GetInt("ImageMaxWidth"),
+		ImageMaxHeight:       c.GetInt("ImageMaxHeight"),
+		LogLevel:             logger.LogLevelFromString(c.GetString("LogLevel")),
+		LogOutput:            c.GetString("LogOutput"),
+		LogMaxSize:           c.GetInt64("LogMaxSize"),
+		LogMaxBackups:        c.GetInt("LogMaxBackups"),
+		AccessLog:            c.GetString("AccessLog"),
+		HealthCanaryID:       c.GetString("HealthCanaryID"),
+		LoadCapacity:         c.GetInt("LoadCapacity"),
+		DecodeThreads:        c.GetInt("DecodeThreads"),
+		DecodeMapFiles:       c.GetBool("DecodeMapFiles"),
+		DecodeWorkers:        c.GetInt("DecodeWorkers"),
+		HeaderCacheLen:       c.GetInt("HeaderCacheLen"),
+		EncodeWorkers:        c.GetInt("EncodeWorkers"),
+		DecodeSlots:          c.GetInt("DecodeSlots"),
+		ExternalPlugins:      c.GetString("ExternalPlugins"),
+		GeoService:           c.GetBool("GeoService"),
+		MetadataService:      c.GetBool("MetadataService"),
+		ROIService:           c.GetBool("ROIService"),
+
+		SlowRequestCount: c.GetInt("SlowRequestCount"),
+
+		InfoCacheFile: c.GetString("InfoCacheFile"),
+
+		AliasFile: c.GetString("AliasFile"),
+
+		RateLimit:            c.GetFloat64("RateLimit"),
+		RateLimitBurst:       c.GetInt("RateLimitBurst"),
+		RateLimitConcurrency: c.GetInt("RateLimitConcurrency"),
+		RateLimitKeyHeader:   c.GetString("RateLimitKeyHeader"),
+
+		SignedURLKeys: parseSignedURLKeys(c.GetString("SignedURLKeys")),
+
+		JWKSURL:      c.GetString("JWKSURL"),
+		JWTIssuer:    c.GetString("JWTIssuer"),
+		JWTAudience:  c.GetString("JWTAudience"),
+		JWTRulesFile: c.GetString("JWTRulesFile"),
+
+		ListenersFile: c.GetString("ListenersFile"),
+		FastCGI:       c.GetBool("FastCGI"),
+
+		ACMEDomains:      parseACMEDomains(c.GetString("ACMEDomains")),
+		ACMECacheDir:     c.GetString("ACMECacheDir"),
+		ACMEEmail:        c.GetString("ACMEEmail"),
+		ACMEDirectoryURL: c.GetString("ACMEDirectoryURL"),
+
+		UsagePrefixes:  parseUsagePrefixes(c.GetString("UsagePrefixes")),
+		UsageDelimiter: c.GetString("UsageDelimiter"),
+
+		TileCacheBytes:         c.GetInt64("TileCacheBytes"),
+		TileCacheMaxEntryBytes: c.GetInt64("TileCacheMaxEntryBytes"),
+		TileCacheMaxDimension:  c.GetInt("TileCacheMaxDimension"),
+		TileCacheFormats:       parseTileCacheFormats(c.GetString("TileCacheFormats")),
+		TileCacheStale:         strings.ToLower(c.GetString("TileCacheStale")),
+		TileCacheKey:           strings.ToLower(c.GetString("TileCacheKey")),
+		TilePrefetchWorkers:    c.GetInt("TilePrefetchWorkers"),
+		TilePrefetchQueue:      c.GetInt("TilePrefetchQueue"),
+
+		MissingCacheLen: c.GetInt("MissingCacheLen"),
+
+		QuarantineTimeouts: c.GetInt("QuarantineTimeouts"),
+
+		DecodeWorkerSandbox:   c.GetBool("DecodeWorkerSandbox"),
+		DecodeWorkerReadPaths: parsePathList(c.GetString("DecodeWorkerReadPaths")),
+
+		CachePeers:      parsePeerList(c.GetString("CachePeers")),
+		CachePeerDNS:    c.GetString("CachePeerDNS"),
+		CachePeerSelf:   c.GetString("CachePeerSelf"),
+		CachePeerSecret: c.GetString("CachePeerSecret"),
+
+		SourceWatch: strings.ToLower(c.GetString("SourceWatch")),
+
+		PNGCompression:      c.GetString("PNGCompression"),
+		PNGBitDepth:         c.GetInt("PNGBitDepth"),
+		PNGPaletteMaxPixels: c.GetInt("PNGPaletteMaxPixels"),
+
+		QualityLayers:        c.GetInt("QualityLayers"),
+		QualityLayersMaxSize: c.GetInt("QualityLayersMaxSize"),
+		DecodeBudgetRate:     c.GetFloat64("DecodeBudgetRate"),
+
+		AdjustmentParams: c.GetBool("AdjustmentParams"),
+
+		CanonicalRedirect: c.GetBool("CanonicalRedirect"),
+
+		ComplianceLevel:  c.GetString("ComplianceLevel"),
+		EnabledFeatures:  parseFeatureList(c.GetString("EnabledFeatures")),
+		DisabledFeatures: parseFeatureList(c.GetString("DisabledFeatures")),
+
+		AllowUnescapedSlashes: c.GetBool("AllowUnescapedSlashes"),
+
+		ChangeDiscoveryPath: c.GetString("ChangeDiscoveryPath"),
+		ChangeDiscoveryLen:  c.GetInt("ChangeDiscoveryLen"),
+		ChangeDiscoveryFile: c.GetString("ChangeDiscoveryFile"),
+
+		DemoViewer:          c.GetBool("DemoViewer"),
+		DemoViewerScriptURL: c.GetString("DemoViewerScriptURL"),
+	}
+
+	// Don't let the default plugin list be used if we have an explicit value of ""
+	if c.IsSet("Plugins") {
+		cfg.Plugins = c.GetString("Plugins")
+	}
+
+	cfg.SourceMax = img.SourceLimits{
+		Width:    c.GetInt("SourceMaxWidth"),
+		Height:   c.GetInt("SourceMaxHeight"),
+		Area:     c.GetInt64("SourceMaxArea"),
+		FileSize: c.GetInt64("SourceMaxFileSize"),
+	}
+
+	if cfg.MetadataService {
+		cfg.MetadataFields = parseMetadataFields(c.GetString("MetadataFields"))
+	}
+
+	// Same for the page separator: "" disables page-aware IDs
+	if c.IsSet("PageSeparator") {
+		cfg.PageSeparator = c.GetString("PageSeparator")
+	}
+
+	if cfg.IIIFWebPath == "" {
+		cfg.IIIFWebPath = "/iiif"
+	}
+
+	var readDuration = func(key string, d *time.Duration) {
+		var val = c.GetString(key)
+		var err error
+		*d, err = time.ParseDuration(val)
+		if err == nil && *d < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s (%q): %s", key, val, err))
+		}
+	}
+	readDuration("ReadTimeout", &cfg.ReadTimeout)
+	readDuration("WriteTimeout", &cfg.WriteTimeout)
+	readDuration("IdleTimeout", &cfg.IdleTimeout)
+	readDuration("RequestTimeout", &cfg.RequestTimeout)
+	readDuration("SlowRequestInterval", &cfg.SlowRequestInterval)
+	readDuration("InfoCacheSaveInterval", &cfg.InfoCacheSaveInterval)
+	readDuration("MissingCacheTTL", &cfg.MissingCacheTTL)
+	readDuration("QuarantineTTL", &cfg.QuarantineTTL)
+	readDuration("AliasReloadInterval", &cfg.AliasReloadInterval)
+	readDuration("SourceScanInterval", &cfg.SourceScanInterval)
+	readDuration("DecodeBudget", &cfg.DecodeBudget)
+
+	var err error
+	cfg.DecoderExtensions, err = parseDecoderExtensions(c.GetString("DecoderExtensions"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid DecoderExtensions: %s", err))
+	}
+
+	cfg.Upscale, err = img.ParseUpscaleMode(c.GetString("Upscale"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid Upscale: %s", err))
+	}
+
+	if val := c.GetString("BackgroundColor"); val != "" {
+		cfg.BackgroundColor, err = img.ParseColor(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid BackgroundColor: %s", err))
+		}
+	}
+
+	var fs *iiif.FeatureSet
+	fs, err = iiif.FeatureSetForLevel(cfg.ComplianceLevel)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid ComplianceLevel: %s", err))
+	} else if err = applyFeatureToggles(fs, cfg.EnabledFeatures, cfg.DisabledFeatures); err != nil {
+		errs = append(errs, fmt.Errorf("invalid EnabledFeatures or DisabledFeatures: %s", err))
+	}
+	if c.IsSet("ComplianceLevel") && cfg.CapabilitiesFile != "" {
+		errs = append(errs, fmt.Errorf("ComplianceLevel and CapabilitiesFile can't both be set"))
+	}
+
+	if cfg.ROIService {
+		cfg.ROIMethod, err = roi.ParseMethod(c.GetString("ROIMethod"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid ROIMethod: %s", err))
+		}
+	}
+
+	if name := c.GetString("ResizeFilter"); name != "" {
+		cfg.ResizeFilter, err = transform.ParseFilter(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid ResizeFilter: %s", err))
+		}
+	}
+
+	cfg.Sharpen = transform.UnsharpMask{
+		Amount:    c.GetFloat64("SharpenAmount"),
+		Radius:    c.GetFloat64("SharpenRadius"),
+		Threshold: c.GetInt("SharpenThreshold"),
+	}
+
+	cfg.TileGrid.TileWidth = c.GetInt("TileWidth")
+	cfg.TileGrid.TileHeight = c.GetInt("TileHeight")
+	cfg.TileGrid.TileScaleFactors, err = parseScaleFactors(c.GetString("TileScaleFactors"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid TileScaleFactors: %s", err))
+	}
+
+	var baseIIIFURL = c.GetString("IIIFBaseURL")
 	if baseIIIFURL != "" {
 		var u, err = url.Parse(baseIIIFURL)
 		if err == nil && u.Scheme == "" {
@@ -96,13 +697,244 @@ func parseConf() {
 		if err == nil && u.Host == "" {
 			err = fmt.Errorf("empty host")
 		}
-		if err == nil && u.Path != "" {
-			err = fmt.Errorf("only scheme and hostname may be specified")
+		if err == nil && (u.RawQuery != "" || u.Fragment != "") {
+			err = fmt.Errorf("only scheme, hostname, and path may be specified")
 		}
 		if err != nil {
-			fmt.Printf("ERROR: invalid Base IIIF URL (%s) specified: %s\n", baseIIIFURL, err)
-			pflag.Usage()
-			os.Exit(1)
+			errs = append(errs, fmt.Errorf("invalid Base IIIF URL (%s) specified: %s", baseIIIFURL, err))
+		}
+		if err == nil {
+			u.Path = cleanPrefix(u.Path)
+		}
+		cfg.IIIFBaseURL = u
+	}
+
+	if mode := c.GetString("SocketMode"); mode != "" {
+		var m, err = strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0777 {
+			errs = append(errs, fmt.Errorf("invalid SocketMode %q: must be octal permissions like \"0660\"", mode))
+		}
+		cfg.SocketMode = os.FileMode(m)
+	}
+
+	cfg.TrustedProxies, err = parseTrustedProxies(c.GetString("TrustedProxies"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid TrustedProxies: %s", err))
+	}
+
+	cfg.DecodeWeights, err = parseDecodeWeights(c.GetString("DecodeWeights"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid DecodeWeights: %s", err))
+	}
+
+	cfg.LogLevels, err = parseLogLevels(c.GetString("LogLevels"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid LogLevels: %s", err))
+	}
+
+	return cfg, append(errs, cfg.validate()...)
+}
+
+// parseDecoderExtensions turns a list like ".jpf:openjpeg, .gif:" into a map
+// of extensions to decoder names.  An empty name is valid, and means the
+// extension shouldn't be mapped to any decoder.  Decoder names can't be
+// checked until plugins are loaded.
+func parseDecoderExtensions(val string) (map[string]string, error) {
+	var m = make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		var parts = strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%q must look like \".ext:decoder\"", pair)
+		}
+		m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return m, nil
+}
+
+// validate checks settings which don't need any parsing
+func (cfg *Config) validate() []error {
+	var errs []error
+	if cfg.TilePath == "" {
+		errs = append(errs, fmt.Errorf("tile path is required"))
+	}
+	if cfg.LogLevel == logger.Invalid {
+		errs = append(errs, fmt.Errorf("invalid log level (must be DEBUG, INFO, WARN, ERROR, or CRIT)"))
+	}
+	if cfg.LogMaxSize < 0 || cfg.LogMaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("LogMaxSize and LogMaxBackups must not be negative"))
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		errs = append(errs, fmt.Errorf("MaxHeaderBytes must be a positive number"))
+	}
+	if cfg.InfoCacheLen < 0 || cfg.TileCacheLen < 0 || cfg.MissingCacheLen < 0 || cfg.TileCacheBytes < 0 || cfg.TileCacheMaxEntryBytes < 0 {
+		errs = append(errs, fmt.Errorf("cache sizes must not be negative"))
+	}
+	if len(cfg.CachePeers) > 0 && cfg.CachePeerDNS != "" {
+		errs = append(errs, fmt.Errorf("CachePeers and CachePeerDNS can't both be set"))
+	}
+	// Peer requests skip token checks, so without a secret anyone who can
+	// reach the peer routes could get protected images
+	var peering = len(cfg.CachePeers) > 0 || cfg.CachePeerDNS != ""
+	var tokens = len(cfg.SignedURLKeys) > 0 || cfg.JWKSURL != ""
+	if peering && tokens && cfg.CachePeerSecret == "" {
+		errs = append(errs, fmt.Errorf("CachePeerSecret is required when cache peers are used with SignedURLKeys or JWKSURL"))
+	}
+	for _, p := range cfg.CachePeers {
+		if u, err := url.Parse(p); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid cache peer %q: must be a URL like \"http://10.0.0.5:12416\"", p))
+		}
+	}
+	if cfg.TileCacheMaxDimension < 0 {
+		errs = append(errs, fmt.Errorf("TileCacheMaxDimension must not be negative"))
+	}
+	if cfg.SourceWatch != "" && cfg.SourceWatch != watchNotify && cfg.SourceWatch != watchScan {
+		errs = append(errs, fmt.Errorf("SourceWatch must be one of %q", watchModes))
+	}
+	if cfg.SourceWatch == watchScan && cfg.SourceScanInterval <= 0 {
+		errs = append(errs, fmt.Errorf("SourceScanInterval must be positive"))
+	}
+	if cfg.TileCacheStale != "" && !validStaleMode(cfg.TileCacheStale) {
+		errs = append(errs, fmt.Errorf("TileCacheStale must be one of %q", staleModes))
+	}
+	if cfg.TilePrefetchWorkers < 0 || cfg.TilePrefetchQueue < 0 {
+		errs = append(errs, fmt.Errorf("TilePrefetchWorkers and TilePrefetchQueue must not be negative"))
+	}
+	if cfg.TileCacheKey != "" && !validKeyMode(cfg.TileCacheKey) {
+		errs = append(errs, fmt.Errorf("TileCacheKey must be one of %q", keyModes))
+	}
+	if cfg.SlowRequestCount > 0 && cfg.SlowRequestInterval == 0 {
+		errs = append(errs, fmt.Errorf("SlowRequestInterval must be positive"))
+	}
+	var sm = cfg.SourceMax
+	if sm.Width < 0 || sm.Height < 0 || sm.Area < 0 || sm.FileSize < 0 {
+		errs = append(errs, fmt.Errorf("SourceMax settings must not be negative"))
+	}
+	if cfg.LoadCapacity < 0 {
+		errs = append(errs, fmt.Errorf("LoadCapacity must not be negative"))
+	}
+	if cfg.Sharpen.Amount < 0 || cfg.Sharpen.Radius < 0 || cfg.Sharpen.Radius > 10 {
+		errs = append(errs, fmt.Errorf("SharpenAmount must not be negative, and SharpenRadius must be from 0 to 10"))
+	}
+	if cfg.Sharpen.Threshold < 0 || cfg.Sharpen.Threshold > 255 {
+		errs = append(errs, fmt.Errorf("SharpenThreshold must be from 0 to 255"))
+	}
+	if err := cfg.TileGrid.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.DecodeThreads < 0 {
+		errs = append(errs, fmt.Errorf("DecodeThreads must not be negative"))
+	}
+	if cfg.DecodeWorkers < 0 {
+		errs = append(errs, fmt.Errorf("DecodeWorkers must not be negative"))
+	}
+	if cfg.DecodeWorkerSandbox && cfg.DecodeWorkers == 0 {
+		errs = append(errs, fmt.Errorf("DecodeWorkerSandbox requires DecodeWorkers"))
+	}
+	if cfg.DecodeWorkerSandbox && runtime.GOOS != "linux" {
+		errs = append(errs, fmt.Errorf("DecodeWorkerSandbox is only supported on Linux"))
+	}
+	for _, p := range cfg.DecodeWorkerReadPaths {
+		if !filepath.IsAbs(p) || strings.Contains(p, ":") {
+			errs = append(errs, fmt.Errorf("DecodeWorkerReadPaths must be absolute and can't contain colons (%q)", p))
+		}
+	}
+	if cfg.EncodeWorkers < 0 {
+		errs = append(errs, fmt.Errorf("EncodeWorkers must not be negative"))
+	}
+	if cfg.HeaderCacheLen < 0 {
+		errs = append(errs, fmt.Errorf("HeaderCacheLen must not be negative"))
+	}
+	if cfg.DecodeSlots < 0 {
+		errs = append(errs, fmt.Errorf("DecodeSlots must not be negative"))
+	}
+	if _, err := parsePNGCompression(cfg.PNGCompression); err != nil {
+		errs = append(errs, fmt.Errorf("PNGCompression must be one of \"default\", \"none\", \"fast\", or \"best\""))
+	}
+	switch cfg.PNGBitDepth {
+	case 0, 1, 2, 4, 8:
+	default:
+		errs = append(errs, fmt.Errorf("PNGBitDepth must be 1, 2, 4, or 8"))
+	}
+	if cfg.PNGPaletteMaxPixels < 0 {
+		errs = append(errs, fmt.Errorf("PNGPaletteMaxPixels must not be negative"))
+	}
+	if cfg.QualityLayers < 0 || cfg.QualityLayersMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("QualityLayers and QualityLayersMaxSize must not be negative"))
+	}
+	if cfg.DecodeBudget > 0 && cfg.DecodeBudgetRate <= 0 {
+		errs = append(errs, fmt.Errorf("DecodeBudgetRate must be positive"))
+	}
+	if cfg.RateLimit < 0 || cfg.RateLimitBurst < 0 || cfg.RateLimitConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("rate limits must not be negative"))
+	}
+	if cfg.ChangeDiscoveryPath != "" {
+		if !strings.HasPrefix(cfg.ChangeDiscoveryPath, "/") || strings.HasSuffix(cfg.ChangeDiscoveryPath, "/") {
+			errs = append(errs, fmt.Errorf("ChangeDiscoveryPath must start with a slash and must not end with one"))
+		}
+		if cfg.ChangeDiscoveryLen <= 0 {
+			errs = append(errs, fmt.Errorf("ChangeDiscoveryLen must be positive"))
+		}
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, fmt.Errorf("TLS requires both a certificate and a key file"))
+	}
+	if cfg.FastCGI && cfg.TLSCert != "" {
+		errs = append(errs, fmt.Errorf("FastCGI can't be combined with TLS"))
+	}
+	for _, fname := range []string{cfg.TLSCert, cfg.TLSKey} {
+		if fname == "" {
+			continue
+		}
+		if _, err := os.Stat(fname); err != nil {
+			errs = append(errs, fmt.Errorf("unable to read TLS file %q: %s", fname, err))
+		}
+	}
+
+	if len(cfg.ACMEDomains) > 0 {
+		if cfg.ACMECacheDir == "" {
+			errs = append(errs, fmt.Errorf("ACMEDomains requires ACMECacheDir"))
+		}
+		if cfg.TLSCert != "" {
+			errs = append(errs, fmt.Errorf("ACMEDomains can't be combined with TLSCert and TLSKey"))
+		}
+		if cfg.FastCGI {
+			errs = append(errs, fmt.Errorf("FastCGI can't be combined with ACME"))
+		}
+	}
+
+	return errs
+}
+
+// logConfigWarnings reports deprecated settings as well as any settings in the
+// config file that neither RAIS nor any loaded plugin reads.  This must be
+// called after plugins are initialized, since plugins register their settings
+// as they read them.
+func logConfigWarnings() {
+	for _, w := range confWarnings {
+		Logger.Warnf("%s", w)
+	}
+
+	for _, key := range fileKeys {
+		if plugins.IsKnownKey(key) || isDeprecatedKey(key) {
+			continue
+		}
+		Logger.Warnf("Unknown setting %q in %s: it may be misspelled, or belong to a plugin "+
+			"which isn't loaded", key, viper.ConfigFileUsed())
+	}
+}
+
+// isDeprecatedKey returns true if key is an old setting name.  Keys read from
+// a file are lowercased, so the comparison is case-insensitive.
+func isDeprecatedKey(key string) bool {
+	for old := range deprecatedKeys {
+		if strings.EqualFold(old, key) {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+func TestApplyDeprecations(t *testing.T) {
+	defer viper.Reset()
+	confWarnings = nil
+	viper.Set("S3_Endpoint", "minio:9000")
+	applyDeprecations()
+	assert.Equal("minio:9000", viper.GetString("S3Endpoint"), "old value copied to new key", t)
+	assert.Equal(1, len(confWarnings), "one deprecation warning", t)
+	assert.True(isDeprecatedKey("s3_endpoint"), "file keys are matched case-insensitively", t)
+}
+
+func TestValidate(t *testing.T) {
+	var cfg = &Config{TilePath: "/var/local/images", LogLevel: logger.Info, MaxHeaderBytes: 1024}
+	assert.Equal(0, len(cfg.validate()), "valid config", t)
+
+	cfg.TilePath = ""
+	cfg.LogLevel = logger.Invalid
+	cfg.TLSCert = "/dev/null"
+	assert.Equal(3, len(cfg.validate()), "missing tile path, bad log level, and cert without key", t)
+}
+
+func TestValidateACME(t *testing.T) {
+	var cfg = &Config{TilePath: "/var/local/images", LogLevel: logger.Info, MaxHeaderBytes: 1024}
+	cfg.ACMEDomains = parseACMEDomains(" IIIF.example.edu, ,images.example.edu")
+	assert.Equal(2, len(cfg.ACMEDomains), "domains are parsed", t)
+	assert.Equal("iiif.example.edu", cfg.ACMEDomains[0], "domains are lowercased", t)
+	assert.Equal(1, len(cfg.validate()), "domains without a cache dir", t)
+
+	cfg.ACMECacheDir = "/var/local/rais-acme"
+	assert.Equal(0, len(cfg.validate()), "valid ACME setup", t)
+	cfg.FastCGI = true
+	cfg.TLSCert, cfg.TLSKey = "/dev/null", "/dev/null"
+	assert.Equal(3, len(cfg.validate()), "FastCGI with TLS, and ACME with FastCGI and a cert", t)
+}
+
+func TestValidateCachePeerSecret(t *testing.T) {
+	var cfg = &Config{TilePath: "/var/local/images", LogLevel: logger.Info, MaxHeaderBytes: 1024}
+	cfg.CachePeerDNS = "rais-peers:12416"
+	assert.Equal(0, len(cfg.validate()), "peers without token checks don't need a secret", t)
+
+	cfg.JWKSURL = "https://sso.example.edu/jwks.json"
+	assert.Equal(1, len(cfg.validate()), "peers with JWT auth need a secret", t)
+	cfg.JWKSURL, cfg.SignedURLKeys = "", []string{"key"}
+	assert.Equal(1, len(cfg.validate()), "peers with signed URLs need a secret", t)
+
+	cfg.CachePeerSecret = "shared"
+	assert.Equal(0, len(cfg.validate()), "secret is set", t)
+}
+
+func TestValidateDecodeWorkerSandbox(t *testing.T) {
+	var cfg = &Config{TilePath: "/var/local/images", LogLevel: logger.Info, MaxHeaderBytes: 1024}
+	cfg.DecodeWorkerSandbox = true
+	assert.Equal(1, len(cfg.validate()), "sandbox without workers", t)
+
+	cfg.DecodeWorkers = 2
+	cfg.DecodeWorkerReadPaths = parsePathList("/var/local/rais-s3, cache, /mnt/a:b")
+	assert.Equal(3, len(cfg.DecodeWorkerReadPaths), "paths are parsed", t)
+	assert.Equal(2, len(cfg.validate()), "relative path and path with a colon", t)
+}
+
+func TestParseDecoderExtensions(t *testing.T) {
+	var m, err = parseDecoderExtensions(" .jpf:openjpeg, .jpx:openjpeg,.gif:, ")
+	assert.NilError(err, "parsing valid list", t)
+	assert.Equal(3, len(m), "all pairs parsed", t)
+	assert.Equal("openjpeg", m[".jpx"], "decoder name", t)
+	assert.Equal("", m[".gif"], "empty decoder name is allowed", t)
+
+	_, err = parseDecoderExtensions(".jpf")
+	assert.True(err != nil, "pairs need a colon", t)
+}
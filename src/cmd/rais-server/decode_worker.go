@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"rais/src/cmd/rais-server/internal/decodeworker"
+	"rais/src/img"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// decodeWorkers runs decodes in separate processes when DecodeWorkers is set
+var decodeWorkers *decodeworker.Pool
+
+// decodeWorkerMode is true when this process is a decode worker rather than
+// the server
+var decodeWorkerMode bool
+
+// sandboxSystemPaths are the directories sandboxed decode workers may read
+// besides those RAIS is configured to use.  Workers sandbox themselves before
+// loading plugins, so they need the libraries plugins link against, and
+// those libraries' data and configuration files.
+var sandboxSystemPaths = []string{"/usr", "/lib", "/lib64", "/etc"}
+
+// startDecodeWorkers starts the worker pool and sends all image opens and
+// decodes to it
+func startDecodeWorkers(ih *ImageHandler) {
+	var sandbox = decodeWorkerSandbox(ih)
+	var p, err = decodeworker.Start(conf.DecodeWorkers, os.Args[1:], sandbox, logWorkerMessage)
+	if err != nil {
+		Logger.Fatalf("Unable to start decode workers: %s", err)
+	}
+	decodeWorkers = p
+	img.Opener = p.Open
+	Logger.Infof("Decoding images in %d worker processes", conf.DecodeWorkers)
+	if sandbox != nil {
+		Logger.Infof("Decode workers are sandboxed, and may only read %s", strings.Join(sandbox, ", "))
+	}
+}
+
+// decodeWorkerSandbox returns the paths sandboxed workers may read, or nil
+// if workers aren't sandboxed.  Images can live under TilePath, any route's
+// TilePath, or the directory of any alias which points at a file.  Aliases
+// are read as of startup; the sandbox can't grow once workers are running.
+func decodeWorkerSandbox(ih *ImageHandler) []string {
+	if !conf.DecodeWorkerSandbox {
+		return nil
+	}
+
+	var paths = []string{conf.TilePath}
+	for _, r := range ih.Routes {
+		if r.TilePath != "" {
+			paths = append(paths, r.TilePath)
+		}
+	}
+	paths = append(paths, aliases.pathDirs()...)
+	paths = append(paths, conf.DecodeWorkerReadPaths...)
+	var cfgFile = viper.ConfigFileUsed()
+	if cfgFile != "" {
+		paths = append(paths, cfgFile)
+	}
+	paths = append(paths, pluginFiles...)
+	paths = append(paths, sandboxSystemPaths...)
+
+	// Workers share the server's working directory, so relative paths would
+	// work, but absolute paths make the startup log clearer
+	for i, p := range paths {
+		var abs, err = filepath.Abs(p)
+		if err == nil {
+			paths[i] = abs
+		}
+	}
+	return paths
+}
+
+// parsePathList splits a comma-separated list of paths
+func parsePathList(val string) []string {
+	var list []string
+	for _, p := range strings.Split(val, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// logWorkerMessage logs a message a worker sent as if it came from this
+// process, so LogLevels and LogOutput apply the same way
+func logWorkerMessage(level logger.LogLevel, component, message string) {
+	componentLogger(component).Loggable.Log(level, message)
+}
+
+// workerSink writes log messages to stderr for the server to read
+type workerSink struct{}
+
+func (workerSink) write(level logger.LogLevel, component, message string) {
+	os.Stderr.WriteString(decodeworker.FormatLog(level, component, message))
+}
+
+// runDecodeWorker sets up decoders as the server does, then answers decode
+// calls from the server which started this process until it closes the
+// connection.  Signals meant for the server, like a Ctrl-C in the terminal,
+// are ignored so that in-flight decodes can finish during a graceful
+// shutdown.
+func runDecodeWorker() {
+	decodeWorkerMode = true
+	signal.Ignore(os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	logging.sink = workerSink{}
+	logging.level = conf.LogLevel
+	logging.levels = conf.LogLevels
+	Logger = componentLogger(serverComponent)
+	// An incomplete sandbox is reported to the server, which won't use this
+	// worker
+	var status, err = decodeworker.SandboxStatus()
+	if err == nil && status != "" {
+		Logger.Debugf("Decode worker sandboxed with %s", status)
+	}
+	setupDecoding()
+
+	img.RegisterNamedDecoder(jp2Decoder)
+	if conf.Plugins != "" && conf.Plugins != "-" {
+		LoadPlugins(Logger, strings.Split(conf.Plugins, ","))
+	}
+	mapDecoderExtensions()
+
+	err = decodeworker.Serve()
+	if err != nil {
+		Logger.Fatalf("Unable to run decode worker: %s", err)
+	}
+}
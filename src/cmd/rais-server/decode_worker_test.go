@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestDecodeWorkerSandbox(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-sandbox")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var fname = filepath.Join(dir, "aliases.json")
+	writeAliases(t, fname, `{"a": "maps/a.jp2", "b": "/mnt/reels/b.tif", "c": "/mnt/reels/c.tif", "d": "/srv/d.jp2"}`)
+
+	defer func(c *Config, am *aliasMap) { conf, aliases = c, am }(conf, aliases)
+	aliases = &aliasMap{}
+	assert.NilError(aliases.load(fname), "loading aliases", t)
+	conf = &Config{TilePath: "/var/local/images", DecodeWorkerReadPaths: []string{"/var/local/rais-s3"}}
+
+	var ih = NewImageHandler(conf.TilePath, "/iiif")
+	ih.Routes = []*Route{{Prefix: "gis/", TilePath: "/mnt/gis"}, {Prefix: "maps/"}}
+	assert.True(decodeWorkerSandbox(ih) == nil, "no sandbox unless requested", t)
+
+	conf.DecodeWorkerSandbox = true
+	var paths = make(map[string]bool)
+	for _, p := range decodeWorkerSandbox(ih) {
+		paths[p] = true
+	}
+	for _, p := range []string{"/var/local/images", "/mnt/gis", "/mnt/reels", "/srv", "/var/local/rais-s3", "/usr"} {
+		assert.True(paths[p], p+" is readable", t)
+	}
+	assert.Equal(len(sandboxSystemPaths)+5, len(paths), "nothing else is readable", t)
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"image"
 	"image/gif"
 	"image/jpeg"
-	"image/png"
 	"io"
+	"mime"
+	"net/http"
 	"rais/src/iiif"
+	"rais/src/img"
+	"runtime"
+	"strconv"
 
 	"golang.org/x/image/tiff"
 )
@@ -16,18 +21,162 @@
 // file format RAIS doesn't support
 var ErrInvalidEncodeFormat = errors.New("Unable to encode: unsupported format")
 
-// EncodeImage uses the built-in image libs to write an image to the browser
-func EncodeImage(w io.Writer, img image.Image, format iiif.Format) error {
+// EncodeImage writes an image to the browser using a plugin's encoder if one
+// is registered for the format, or else the built-in image libs
+func EncodeImage(w io.Writer, i image.Image, format iiif.Format) error {
+	var e, ok = img.EncoderFor(format)
+	if ok {
+		return e.Encode(w, i)
+	}
+
 	switch format {
 	case iiif.FmtJPG:
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
+		return jpeg.Encode(w, i, &jpeg.Options{Quality: 80})
 	case iiif.FmtPNG:
-		return png.Encode(w, img)
+		return pngSettings.encode(w, i)
 	case iiif.FmtGIF:
-		return gif.Encode(w, img, &gif.Options{NumColors: 256})
+		return gif.Encode(w, i, &gif.Options{NumColors: 256})
 	case iiif.FmtTIF:
-		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+		return tiff.Encode(w, i, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	case iiif.FmtPDF:
+		return encodePDF(w, i)
 	}
 
 	return ErrInvalidEncodeFormat
 }
+
+// encodeJob is a single image to be encoded by an encodePool worker
+type encodeJob struct {
+	ctx    context.Context
+	w      io.Writer
+	i      image.Image
+	format iiif.Format
+	done   chan error
+}
+
+// encodePool runs image encoding on a fixed number of worker goroutines, so
+// a burst of huge PNG or TIFF encodes can't tie up every CPU, and encode
+// concurrency can be tuned separately from decoding.  Requests wait their
+// turn, giving up if their context ends first.
+type encodePool struct {
+	jobs chan *encodeJob
+}
+
+// encoders is the server's encode pool, set up at startup.  When it's nil,
+// images are encoded on the request goroutine.
+var encoders *encodePool
+
+// newEncodePool starts the given number of workers, or one per CPU if
+// workers is zero
+func newEncodePool(workers int) *encodePool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var p = &encodePool{jobs: make(chan *encodeJob)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *encodePool) work() {
+	for j := range p.jobs {
+		// Skip jobs whose request ended while they were waiting
+		if err := j.ctx.Err(); err != nil {
+			j.done <- err
+			continue
+		}
+		j.done <- j.run()
+	}
+}
+
+// run encodes the job's image.  A panic in an encoder is returned as
+// errPanic rather than taking the whole server down with it.
+func (j *encodeJob) run() (err error) {
+	defer catchPanic(j.ctx, "encoding to "+string(j.format), &err)
+	return EncodeImage(j.w, j.i, j.format)
+}
+
+// encode writes i to w in the given format using one of the pool's workers.
+// If ctx ends before a worker is free, its error is returned and nothing is
+// encoded.  Once a worker has the job, encode waits for it to finish even if
+// ctx ends, since w may be the client's response writer.
+func (p *encodePool) encode(ctx context.Context, w io.Writer, i image.Image, format iiif.Format) error {
+	if p == nil {
+		return EncodeImage(w, i, format)
+	}
+
+	var j = &encodeJob{ctx: ctx, w: w, i: i, format: format, done: make(chan error, 1)}
+	var _, endQueue = startSpan(ctx, "image.encode_queue")
+	select {
+	case p.jobs <- j:
+		endQueue()
+	case <-ctx.Done():
+		endQueue()
+		return ctx.Err()
+	}
+
+	return <-j.done
+}
+
+// streamBufferSize is how much of a streamed image is held back so that small
+// images can still be sent with a Content-Length
+const streamBufferSize = 256 << 10
+
+// streamWriter sends an image to the client as it's encoded.  The first
+// streamBufferSize bytes are held back: if the whole image fits, it's sent
+// with a Content-Length header on Close, and if it doesn't, the response is
+// streamed without one.  Nothing is sent until the buffer fills or Close is
+// called, so an encode which fails early can still get a proper error
+// response.
+type streamWriter struct {
+	w         http.ResponseWriter
+	buf       []byte
+	streaming bool
+}
+
+func newStreamWriter(w http.ResponseWriter) *streamWriter {
+	return &streamWriter{w: w}
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if !sw.streaming && len(sw.buf)+len(p) <= streamBufferSize {
+		sw.buf = append(sw.buf, p...)
+		return len(p), nil
+	}
+
+	if !sw.streaming {
+		sw.streaming = true
+		var buf = sw.buf
+		sw.buf = nil
+		if _, err := sw.w.Write(buf); err != nil {
+			return 0, err
+		}
+	}
+	return sw.w.Write(p)
+}
+
+// Streaming returns true once any of the image has been sent to the client
+func (sw *streamWriter) Streaming() bool {
+	return sw.streaming
+}
+
+// Close sends the image if it was small enough to be held back entirely
+func (sw *streamWriter) Close() error {
+	if sw.streaming {
+		return nil
+	}
+	sw.w.Header().Set("Content-Length", strconv.Itoa(len(sw.buf)))
+	sw.streaming = true
+	var _, err = sw.w.Write(sw.buf)
+	return err
+}
+
+// contentType returns the MIME type to send for images in the given format
+func contentType(format iiif.Format) string {
+	var e, ok = img.EncoderFor(format)
+	if ok && e.ContentType != "" {
+		return e.ContentType
+	}
+	return mime.TypeByExtension("." + string(format))
+}
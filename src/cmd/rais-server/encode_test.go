@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/pdf"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestEncodeImagePlugin(t *testing.T) {
+	img.RegisterEncoder(img.Encoder{
+		Format:      "xyz",
+		ContentType: "image/x-xyz",
+		Encode: func(w io.Writer, i image.Image) error {
+			var _, err = w.Write([]byte("xyz"))
+			return err
+		},
+	})
+
+	var buf = new(bytes.Buffer)
+	var err = EncodeImage(buf, image.NewGray(image.Rect(0, 0, 1, 1)), "xyz")
+	assert.NilError(err, "encoding with a plugin encoder", t)
+	assert.Equal("xyz", buf.String(), "plugin encoder output", t)
+	assert.Equal("image/x-xyz", contentType("xyz"), "plugin content type", t)
+	assert.Equal("image/png", contentType(iiif.FmtPNG), "built-in content type", t)
+	assert.Equal(ErrInvalidEncodeFormat, EncodeImage(buf, nil, iiif.FmtWEBP), "unsupported format", t)
+}
+
+func TestEncodePDF(t *testing.T) {
+	var buf = new(bytes.Buffer)
+	var i = &pdfPage{Image: image.NewGray(image.Rect(0, 0, 10, 10)), info: pdf.Info{Title: "test title"}}
+	assert.NilError(EncodeImage(buf, i, iiif.FmtPDF), "PDF output", t)
+	assert.True(bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")), "PDF was written", t)
+	assert.True(bytes.Contains(buf.Bytes(), []byte("/Title (test title)")), "page info is embedded", t)
+	assert.Equal("application/pdf", contentType(iiif.FmtPDF), "PDF content type", t)
+}
+
+func TestEncodePool(t *testing.T) {
+	var i = image.NewGray(image.Rect(0, 0, 10, 10))
+	var p = newEncodePool(1)
+	var buf bytes.Buffer
+	var err = p.encode(context.Background(), &buf, i, iiif.FmtPNG)
+	assert.NilError(err, "encoding via the pool", t)
+	assert.True(buf.Len() > 0, "image was encoded", t)
+
+	// With the only worker busy, a canceled request gives up on waiting
+	var started, block = make(chan struct{}), make(chan struct{})
+	img.RegisterEncoder(img.Encoder{
+		Format: "block",
+		Encode: func(w io.Writer, i image.Image) error {
+			close(started)
+			<-block
+			return nil
+		},
+	})
+	go p.encode(context.Background(), ioutil.Discard, i, "block")
+	<-started
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = p.encode(ctx, ioutil.Discard, i, iiif.FmtPNG)
+	assert.Equal(context.DeadlineExceeded, err, "queued request times out", t)
+	close(block)
+
+	var nilPool *encodePool
+	buf.Reset()
+	assert.NilError(nilPool.encode(context.Background(), &buf, i, iiif.FmtPNG), "encoding without a pool", t)
+}
+
+func TestEncodePoolPanic(t *testing.T) {
+	img.RegisterEncoder(img.Encoder{
+		Format: "panic",
+		Encode: func(w io.Writer, i image.Image) error { panic("broken encoder") },
+	})
+
+	var p = newEncodePool(1)
+	var i = image.NewGray(image.Rect(0, 0, 10, 10))
+	var err = p.encode(context.Background(), ioutil.Discard, i, "panic")
+	assert.Equal(errPanic, err, "encoder panics are returned as errors", t)
+	assert.NilError(p.encode(context.Background(), ioutil.Discard, i, iiif.FmtPNG), "the worker survives", t)
+}
+
+func TestStreamWriter(t *testing.T) {
+	var w = httptest.NewRecorder()
+	var sw = newStreamWriter(w)
+	sw.Write([]byte("small"))
+	assert.False(sw.Streaming(), "small writes are held back", t)
+	assert.Equal(0, w.Body.Len(), "nothing sent before Close", t)
+	assert.NilError(sw.Close(), "closing", t)
+	assert.Equal("5", w.Header().Get("Content-Length"), "small images get a Content-Length", t)
+	assert.Equal("small", w.Body.String(), "small image is sent on Close", t)
+
+	w = httptest.NewRecorder()
+	sw = newStreamWriter(w)
+	var chunk = bytes.Repeat([]byte("x"), streamBufferSize/2+1)
+	sw.Write(chunk)
+	sw.Write(chunk)
+	assert.True(sw.Streaming(), "large images are streamed", t)
+	assert.Equal(len(chunk)*2, w.Body.Len(), "everything written so far was sent", t)
+	sw.Close()
+	assert.Equal("", w.Header().Get("Content-Length"), "streamed images have no Content-Length", t)
+}
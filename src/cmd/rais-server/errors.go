@@ -1,12 +1,38 @@
 package main
 
-// HandlerError represents an HTTP error message and status code
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HandlerError represents an HTTP error message and status code.  If Detail
+// is set, the error is sent as JSON, with the message in an "error" field and
+// Detail in a "detail" field, so clients can see exactly what went wrong.
 type HandlerError struct {
 	Message string
 	Code    int
+	Detail  interface{}
 }
 
 // NewError generates a new HandlerError with the given message and code
 func NewError(m string, c int) *HandlerError {
-	return &HandlerError{m, c}
+	return &HandlerError{Message: m, Code: c}
+}
+
+// write sends the error to the client
+func (e *HandlerError) write(w http.ResponseWriter) {
+	if e.Detail == nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+
+	var data, err = json.Marshal(map[string]interface{}{"error": e.Message, "detail": e.Detail})
+	if err != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Code)
+	w.Write(data)
 }
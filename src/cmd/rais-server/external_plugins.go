@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"rais/src/extplugin"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// externalPlugins holds every external plugin which started, so their health
+// can be checked
+var externalPlugins []*extplugin.Client
+
+// LoadExternalPlugins starts each external plugin executable and registers
+// its hooks alongside those of the native plugins
+func LoadExternalPlugins(l *logger.Logger, paths []string) {
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		l.Infof("Starting external plugin %q", path)
+		var err = loadExternalPlugin(path, l)
+		if err != nil {
+			l.Errorf("Unable to load external plugin %q: %s", path, err)
+			pluginErrors = append(pluginErrors, fmt.Sprintf("%s: %s", path, err))
+		}
+	}
+}
+
+func loadExternalPlugin(path string, l *logger.Logger) error {
+	var c, err = extplugin.Start(path, func(err error) {
+		l.Errorf("External plugin %q exited (%s); it will be restarted on its next call", path, err)
+	})
+	if err != nil {
+		return err
+	}
+	externalPlugins = append(externalPlugins, c)
+
+	var functions []string
+	if c.Has(plugins.CapIDToPath) {
+		idToPathPlugins = append(idToPathPlugins, c.IDToPathContext)
+	}
+	if c.Has(plugins.CapCachePurge) {
+		purgeCachePlugins = append(purgeCachePlugins, func() {
+			var err = c.PurgeCaches()
+			if err != nil {
+				l.Errorf("External plugin %q failed to purge caches: %s", path, err)
+			}
+		})
+		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) {
+			var err = c.ExpireCachedImage(id)
+			if err != nil {
+				l.Errorf("External plugin %q failed to expire %q: %s", path, id, err)
+			}
+		})
+	}
+	for _, capability := range c.Capabilities {
+		functions = append(functions, pluginCapabilities[capability]...)
+	}
+	teardownPlugins = append(teardownPlugins, func() { c.Close() })
+
+	stats.Plugins = append(stats.Plugins, plugStats{
+		Path:         path,
+		APIVersion:   c.APIVersion,
+		Capabilities: c.Capabilities,
+		Functions:    functions,
+	})
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to path via a temp file in the same directory,
+// so a crash mid-write can't leave a partial file behind.  prefix names the
+// temp file, which helps identify strays if cleanup somehow fails.
+func writeFileAtomic(path, prefix string, data []byte) error {
+	var f, err = ioutil.TempFile(filepath.Dir(path), prefix)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"rais/src/geo"
+	"rais/src/iiif"
+)
+
+// geoProfile identifies the geo.json service in info.json
+const geoProfile = "https://github.com/uoregon-libraries/rais-image-server/wiki/Georeferencing"
+
+// geoService is the info.json service entry for georeferenced images.  Its
+// ID can't be known until the request's base URL is, so it's filled in by
+// setGeoServiceID.
+type geoService struct {
+	Context string `json:"@context"`
+	ID      string `json:"@id"`
+	Profile string `json:"profile"`
+	Label   string `json:"label"`
+}
+
+func newGeoService() *geoService {
+	return &geoService{Context: geoProfile, Profile: geoProfile, Label: "Georeferencing"}
+}
+
+// setGeoServiceID points info's geo service, if it has one, at the image's
+// geo.json.  info.ID must already be set.
+func setGeoServiceID(info *iiif.Info) {
+	for _, s := range info.Service {
+		if gs, ok := s.(*geoService); ok {
+			gs.ID = info.ID + "/geo.json"
+		}
+	}
+}
+
+// Geo responds to a geo.json request with the image's georeferencing, or a
+// 404 if it has none
+func (ih *ImageHandler) Geo(w http.ResponseWriter, req *http.Request, id iiif.ID) {
+	// We need the image's real dimensions for the bounds
+	var fp, info, ok = ih.resolveSidecar(req, w, id)
+	if !ok {
+		return
+	}
+
+	var gi, err = geo.Read(fp)
+	if err == geo.ErrNotGeoreferenced {
+		http.Error(w, "Image has no georeferencing", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		Logger.Errorf("Unable to read georeferencing for %s (path %s): %s", id, fp, err)
+		http.Error(w, "Unable to read georeferencing", http.StatusInternalServerError)
+		return
+	}
+	gi.SetSize(info.Width, info.Height)
+
+	var data []byte
+	data, err = json.Marshal(gi)
+	if err != nil {
+		Logger.Errorf("Unable to marshal georeferencing for %s: %s", id, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	ih.cachePoliciesFor(id).setHeaders(w, req, id, true)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestGeoServiceInfo(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/foo/bar")
+	var info = ih.buildInfo("map.jp2", ImageInfo{Width: 100, Height: 100, Georeferenced: true})
+	info.ID = "http://example.com/foo/bar/map.jp2"
+	setGeoServiceID(info)
+
+	assert.Equal(1, len(info.Service), "georeferenced images get a service", t)
+	assert.Equal("http://example.com/foo/bar/map.jp2/geo.json", info.Service[0].(*geoService).ID, "service ID", t)
+
+	info = ih.buildInfo("photo.jp2", ImageInfo{Width: 100, Height: 100})
+	assert.Equal(0, len(info.Service), "other images don't", t)
+}
+
+func TestGeoNotGeoreferenced(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/foo/bar")
+	ih.FeatureSet = iiif.FeatureSet2()
+	var path = "/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/geo.json"
+	var req, _ = http.NewRequest("GET", path, strings.NewReader(""))
+
+	var w = fakehttp.NewResponseWriter()
+	ih.IIIFRoute(w, req)
+	assert.Equal(400, w.StatusCode, "geo.json is an invalid IIIF request when the service is off", t)
+
+	ih.GeoService = true
+	w = fakehttp.NewResponseWriter()
+	ih.IIIFRoute(w, req)
+	assert.Equal(404, w.StatusCode, "images without georeferencing have no geo.json", t)
+}
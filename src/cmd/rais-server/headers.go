@@ -1,21 +1,65 @@
 package main
 
 import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"rais/src/iiif"
+	"strings"
 	"time"
 )
 
-func sendHeaders(w http.ResponseWriter, req *http.Request, filepath string) error {
+// errNotModified is returned by sendHeaders when the client's cached copy is
+// still valid and a 304 has already been sent
+var errNotModified = errors.New("not modified")
+
+func sendHeaders(w http.ResponseWriter, req *http.Request, filepath string, u *iiif.URL) error {
 	info, err := os.Stat(filepath)
 	if err != nil {
 		http.Error(w, "Unable to access file", 404)
 		return err
 	}
 
-	// Set headers
-	w.Header().Set("Last-Modified", info.ModTime().Format(time.RFC1123))
+	return writeHeaders(w, req, info, u)
+}
+
+// sendCachedHeaders is sendHeaders for derivatives served from the tile
+// cache.  source is the fingerprint of the file the derivative was made from,
+// if known.  The derivative's validators are only sent if it was made from
+// the current file, since a stale tile must not be remembered by clients
+// under the new file's ETag.  A source file which can't be read (e.g., one a
+// plugin hasn't downloaded yet) isn't an error here; the response just has no
+// validators.
+func sendCachedHeaders(w http.ResponseWriter, req *http.Request, filepath string, u *iiif.URL, source string) error {
+	var info, err = os.Stat(filepath)
+	if err != nil || (source != "" && source != fileFingerprint(info)) {
+		info = nil
+	}
+	return writeHeaders(w, req, info, u)
+}
+
+// writeHeaders sets the headers every image response gets.  If info, the
+// source file's information, is nil, the Last-Modified and ETag headers are
+// left off and conditional requests aren't checked.
+func writeHeaders(w http.ResponseWriter, req *http.Request, info os.FileInfo, u *iiif.URL) error {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if info != nil {
+		var modTime = info.ModTime().UTC()
+		var hints = requestHints(req)
+		if limit, _ := grantLimit(req.Context(), u.ID); limit != unconstrained {
+			hints += fmt.Sprintf("|limit=%d,%d,%d", limit.Width, limit.Height, limit.Area)
+		}
+		var tag = etag(info, u, hints)
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		w.Header().Set("ETag", tag)
+
+		if notModified(req, tag, modTime) {
+			w.WriteHeader(http.StatusNotModified)
+			return errNotModified
+		}
+	}
 
 	// Check for forced download parameter
 	query := req.URL.Query()
@@ -25,3 +69,58 @@ func sendHeaders(w http.ResponseWriter, req *http.Request, filepath string) erro
 
 	return nil
 }
+
+// requestHints returns the query parameters which change how a derivative is
+// made without being part of its IIIF URL, for use in the entity tag
+func requestHints(req *http.Request) string {
+	var q = req.URL.Query()
+	var hints = strings.ToLower(q.Get("filter"))
+	for _, name := range []string{"layers", "brightness", "contrast", "gamma", "background"} {
+		if val := q.Get(name); val != "" {
+			hints += "|" + name + "=" + val
+		}
+	}
+	return hints
+}
+
+// etag returns a strong entity tag for the derivative u describes.  The
+// source file's modification time and size stand in for its contents, and the
+// parsed IIIF parameters are used rather than the raw URL so equivalent
+// requests (e.g., "90" and "90.0" rotation) share a tag.  Overrides like a
+// resize filter, and the size limit of a token grant, produce a different
+// derivative, so they're passed in as hints and made part of the tag.
+func etag(info os.FileInfo, u *iiif.URL, hints string) string {
+	var h = sha1.New()
+	fmt.Fprintf(h, "%d|%d|%s|%#v|%#v|%#v|%s|%s", info.ModTime().UnixNano(), info.Size(),
+		u.ID, u.Region, u.Size, u.Rotation, u.Quality, u.Format)
+	if hints != "" {
+		fmt.Fprintf(h, "|%s", hints)
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// notModified returns true if the request's conditional headers say the
+// client already has the current version of the response.  If-None-Match
+// takes precedence over If-Modified-Since when both are sent.
+func notModified(req *http.Request, tag string, modTime time.Time) bool {
+	var inm = req.Header.Get("If-None-Match")
+	if inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == tag || t == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	var ims = req.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	var t, err = http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(t)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestConditionalHeaders(t *testing.T) {
+	var f, err = ioutil.TempFile("", "rais-etag")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	var mtime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(f.Name(), mtime, mtime)
+
+	var u, _ = iiif.NewURL("foo.jp2/full/512,/90/default.jpg")
+	var u2, _ = iiif.NewURL("foo.jp2/full/512,/90.0/default.jpg")
+	var u3, _ = iiif.NewURL("foo.jp2/full/256,/90/default.jpg")
+
+	var req, _ = http.NewRequest("GET", "/iiif/foo.jp2/full/512,/90/default.jpg", strings.NewReader(""))
+	var w = fakehttp.NewResponseWriter()
+	assert.NilError(sendHeaders(w, req, f.Name(), u), "first request", t)
+	var tag = w.Header().Get("ETag")
+	assert.Equal("Thu, 02 Jan 2020 03:04:05 GMT", w.Header().Get("Last-Modified"), "Last-Modified", t)
+
+	w = fakehttp.NewResponseWriter()
+	sendHeaders(w, req, f.Name(), u2)
+	assert.Equal(tag, w.Header().Get("ETag"), "equivalent requests share an ETag", t)
+	w = fakehttp.NewResponseWriter()
+	sendHeaders(w, req, f.Name(), u3)
+	assert.True(tag != w.Header().Get("ETag"), "different sizes get different ETags", t)
+
+	req.Header.Set("If-None-Match", `"nope", `+tag)
+	w = fakehttp.NewResponseWriter()
+	assert.Equal(errNotModified, sendHeaders(w, req, f.Name(), u), "matching ETag", t)
+	assert.Equal(304, w.StatusCode, "matching ETag gets a 304", t)
+
+	req.Header.Set("If-None-Match", `"nope"`)
+	req.Header.Set("If-Modified-Since", "Thu, 02 Jan 2020 03:04:05 GMT")
+	w = fakehttp.NewResponseWriter()
+	assert.NilError(sendHeaders(w, req, f.Name(), u), "If-None-Match takes precedence", t)
+
+	req.Header.Del("If-None-Match")
+	w = fakehttp.NewResponseWriter()
+	assert.Equal(errNotModified, sendHeaders(w, req, f.Name(), u), "unmodified since", t)
+
+	req.Header.Set("If-Modified-Since", "Thu, 02 Jan 2020 03:04:04 GMT")
+	w = fakehttp.NewResponseWriter()
+	assert.NilError(sendHeaders(w, req, f.Name(), u), "modified since", t)
+}
+
+func TestCachedTileConditional(t *testing.T) {
+	var oldCache, oldPolicy = tileCache, tilePolicy
+	defer func() { tileCache, tilePolicy = oldCache, oldPolicy }()
+	tileCache = newByteCache(1000, 0)
+	tilePolicy = &tileCachePolicy{formats: map[iiif.Format]bool{iiif.FmtJPG: true}, key: keyRequest}
+
+	var fp = filepath.Join(rootDir(), "docker/images/testfile/test-world.jp2")
+	var reqPath = "/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/full/512,/0/default.jpg"
+	var get = func(inm string) *fakehttp.ResponseWriter {
+		var req, _ = http.NewRequest("GET", reqPath, nil)
+		req.RequestURI = reqPath
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		var w = fakehttp.NewResponseWriter()
+		var h = NewImageHandler(rootDir(), "/foo/bar")
+		h.BaseURL, _ = url.Parse("http://example.com")
+		h.IIIFRoute(w, req)
+		return w
+	}
+
+	var req, _ = http.NewRequest("GET", reqPath, nil)
+	var u, err = iiif.NewURL(strings.TrimPrefix(req.URL.Path, "/foo/bar/"))
+	assert.NilError(err, "parsing the request", t)
+	tileCache.Add(u.Path, &tileEntry{data: []byte("cached tile"), source: sourceFingerprint(fp)})
+
+	var w = get("")
+	assert.Equal("cached tile", string(w.Output), "cache hit", t)
+	var tag = w.Header().Get("ETag")
+	assert.True(tag != "", "cache hits get an ETag", t)
+	assert.True(w.Header().Get("Last-Modified") != "", "cache hits get a Last-Modified time", t)
+
+	w = get(tag)
+	assert.Equal(304, w.StatusCode, "matching ETag on a cache hit gets a 304", t)
+	assert.Equal(0, len(w.Output), "304 has no body", t)
+
+	tileCache.Add(u.Path, &tileEntry{data: []byte("stale tile"), source: "1-1"})
+	tilePolicy.stale = staleIgnore
+	w = get(tag)
+	assert.Equal("stale tile", string(w.Output), "stale tiles are sent in full", t)
+	assert.Equal("", w.Header().Get("ETag"), "stale tiles don't get the current file's ETag", t)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"rais/src/iiif"
+	"rais/src/img"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// healthHandler serves liveness and readiness checks for orchestration tools
+// like Kubernetes
+type healthHandler struct {
+	ih     *ImageHandler
+	canary iiif.ID
+}
+
+type checkResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func writeHealthJSON(w http.ResponseWriter, ok bool, data interface{}) {
+	var out, err = json.Marshal(data)
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(out)
+}
+
+// live reports basic process health.  If RAIS can respond at all, it's alive.
+func (h *healthHandler) live(w http.ResponseWriter, req *http.Request) {
+	writeHealthJSON(w, true, map[string]interface{}{
+		"status":     "ok",
+		"uptime":     time.Since(stats.ServerStart).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+	})
+}
+
+// ready reports whether RAIS is able to serve images: the tile path must be
+// readable, all plugins must have loaded, external plugins must still be
+// running, and if a canary image is configured, it must decode successfully
+func (h *healthHandler) ready(w http.ResponseWriter, req *http.Request) {
+	var checks = map[string]checkResult{
+		"tilePath": result(h.checkTilePath()),
+		"plugins":  result(h.checkPlugins()),
+		"draining": result(h.checkDraining()),
+	}
+	if h.canary != "" {
+		checks["canary"] = result(h.checkCanary())
+	}
+
+	var ok = true
+	for _, c := range checks {
+		ok = ok && c.OK
+	}
+
+	var status = "ok"
+	if !ok {
+		status = "fail"
+	}
+	writeHealthJSON(w, ok, map[string]interface{}{"status": status, "checks": checks})
+}
+
+func result(err error) checkResult {
+	if err != nil {
+		return checkResult{OK: false, Error: err.Error()}
+	}
+	return checkResult{OK: true}
+}
+
+// checkTilePath verifies the tile path and all routes' tile paths
+func (h *healthHandler) checkTilePath() error {
+	var err = checkDir(h.ih.TilePath)
+	for _, r := range h.ih.Routes {
+		if err != nil {
+			break
+		}
+		if r.TilePath != "" {
+			err = checkDir(r.TilePath)
+		}
+	}
+	return err
+}
+
+// checkDir returns an error if path isn't a readable directory
+func checkDir(path string) error {
+	var f, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var info os.FileInfo
+	info, err = f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", path)
+	}
+
+	_, err = f.Readdirnames(1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func (h *healthHandler) checkDraining() error {
+	if load.isDraining() {
+		return fmt.Errorf("server is draining")
+	}
+	return nil
+}
+
+func (h *healthHandler) checkPlugins() error {
+	if len(pluginErrors) > 0 {
+		return fmt.Errorf("failed to load: %s", strings.Join(pluginErrors, "; "))
+	}
+	for _, c := range externalPlugins {
+		var err = c.Err()
+		if err != nil {
+			return fmt.Errorf("%s: %s", c.Path, err)
+		}
+	}
+	return nil
+}
+
+// checkCanary runs a tiny decode of the canary image through the same path a
+// real request would take
+func (h *healthHandler) checkCanary() error {
+	var fp = h.ih.getIIIFPath(h.canary)
+	var res, err = img.NewResource(h.canary, fp)
+	if err != nil {
+		return fmt.Errorf("unable to read canary image %q: %s", h.canary, err)
+	}
+
+	var u *iiif.URL
+	u, err = iiif.NewURL(h.canary.Escaped() + "/full/64,/0/default.jpg")
+	if err != nil {
+		return fmt.Errorf("invalid canary image id %q: %s", h.canary, err)
+	}
+
+	var max = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
+	_, err = res.Apply(u, max)
+	if err != nil {
+		return fmt.Errorf("unable to decode canary image %q: %s", h.canary, err)
+	}
+	return nil
+}
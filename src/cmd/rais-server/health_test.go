@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"rais/src/fakehttp"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestReadyz(t *testing.T) {
+	var req, _ = http.NewRequest("GET", "/readyz", strings.NewReader(""))
+
+	var hh = &healthHandler{ih: NewImageHandler(rootDir(), "/iiif")}
+	var w = fakehttp.NewResponseWriter()
+	hh.ready(w, req)
+	assert.Equal(-1, w.StatusCode, "ready when tile path is valid", t)
+
+	hh = &healthHandler{ih: NewImageHandler(rootDir()+"/nope", "/iiif")}
+	w = fakehttp.NewResponseWriter()
+	hh.ready(w, req)
+	assert.Equal(503, w.StatusCode, "not ready when tile path is missing", t)
+	assert.True(strings.Contains(string(w.Output), `"tilePath":{"ok":false`), "tilePath check failed", t)
+}
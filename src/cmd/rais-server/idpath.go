@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"rais/src/iiif"
+	"strings"
+)
+
+// errInvalidID is returned when an ID could be used to reach files outside
+// the directory it's supposed to live in
+var errInvalidID = errors.New("invalid image ID")
+
+// validateID returns errInvalidID if id is absolute, has "." or ".."
+// segments, or contains a NUL byte.  IDs may otherwise contain slashes to
+// reach images in nested directories, e.g., "maps/usgs/1901.jp2".
+func validateID(id iiif.ID) error {
+	var s = string(id)
+	if strings.HasPrefix(s, "/") || strings.ContainsRune(s, 0) {
+		return errInvalidID
+	}
+	for _, seg := range strings.Split(s, "/") {
+		if seg == "." || seg == ".." {
+			return errInvalidID
+		}
+	}
+	return nil
+}
+
+// unescapedSlashes returns true if id's slashes weren't all escaped (as
+// "%2F") in the request's path
+func unescapedSlashes(req *http.Request, id iiif.ID) bool {
+	var p = strings.ToLower(req.URL.EscapedPath())
+	var escaped = strings.Count(p, "%2f") + strings.Count(p, "%252f")
+	return strings.Count(string(id), "/") > escaped
+}
+
+// checkIDSlashes writes a 400 to w and returns false if slashes in IDs have
+// to be escaped and id's weren't
+func (ih *ImageHandler) checkIDSlashes(w http.ResponseWriter, req *http.Request, id iiif.ID) bool {
+	if ih.AllowUnescapedSlashes || !unescapedSlashes(req, id) {
+		return true
+	}
+	http.Error(w, "Slashes in image IDs must be escaped as %2F", http.StatusBadRequest)
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestValidateID(t *testing.T) {
+	assert.NilError(validateID("maps/usgs/1901.jp2"), "nested IDs are fine", t)
+	assert.NilError(validateID("a..b.jp2"), "dots within a name are fine", t)
+	assert.NilError(validateID("https://example.com/a.jpg"), "empty segments are fine", t)
+	assert.Equal(errInvalidID, validateID("maps/../../etc/passwd"), "parent segments", t)
+	assert.Equal(errInvalidID, validateID("./a.jp2"), "dot segments", t)
+	assert.Equal(errInvalidID, validateID("/etc/passwd"), "absolute IDs", t)
+	assert.Equal(errInvalidID, validateID("a.jp2\x00.txt"), "NUL bytes", t)
+
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	assert.Equal("/var/local/images/maps/a.jp2", ih.getIIIFPath("maps/a.jp2"), "nested IDs map to nested directories", t)
+	assert.Equal("", ih.getIIIFPath("../a.jp2"), "traversal is rejected", t)
+}
+
+func TestUnescapedSlashes(t *testing.T) {
+	var req = httptest.NewRequest("GET", "/iiif/maps%2Fa.jp2/info.json", nil)
+	assert.False(unescapedSlashes(req, "maps/a.jp2"), "escaped slashes", t)
+	req = httptest.NewRequest("GET", "/iiif/maps/a.jp2/info.json", nil)
+	assert.True(unescapedSlashes(req, "maps/a.jp2"), "unescaped slashes", t)
+
+	var ih = NewImageHandler(rootDir(), "/iiif")
+	var w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/docker/images/testfile/test-world.jp2/info.json", nil))
+	assert.Equal(http.StatusOK, w.Code, "unescaped slashes are allowed by default", t)
+
+	ih.AllowUnescapedSlashes = false
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/docker/images/testfile/test-world.jp2/info.json", nil))
+	assert.Equal(http.StatusBadRequest, w.Code, "unescaped slashes can be refused", t)
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", nil))
+	assert.Equal(http.StatusOK, w.Code, "escaped slashes are still fine", t)
+
+	w = httptest.NewRecorder()
+	ih.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/..%2F..%2Fetc%2Fpasswd/info.json", nil))
+	assert.Equal(http.StatusBadRequest, w.Code, "traversal is refused", t)
+}
@@ -2,17 +2,22 @@
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"image/color"
 	"io"
 	"io/ioutil"
 	"math"
-	"mime"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"rais/src/geo"
 	"rais/src/iiif"
 	"rais/src/img"
 	"rais/src/plugins"
+	"rais/src/roi"
+	"rais/src/transform"
 	"strconv"
 	"strings"
 )
@@ -35,8 +40,46 @@ type ImageHandler struct {
 	BaseURL       *url.URL
 	WebPathPrefix string
 	FeatureSet    *iiif.FeatureSet
+	FeatureScopes []FeatureScope
+	CachePolicies *CachePolicies
+	Routes        []*Route
 	TilePath      string
+	OverlayPath   string
 	Maximums      img.Constraint
+	TileGrid      TileGrid
+	Filter        transform.Filter
+	Sharpen       transform.UnsharpMask
+	Upscale       img.UpscaleMode
+	Layers        img.LayerPolicy
+	Background    color.Color
+	GeoService    bool
+
+	// Budget is applied to IIIF image requests only, since anything cached or
+	// generated in the background should be full quality
+	Budget img.DecodeBudget
+
+	// Adjustments is true if the brightness, contrast, and gamma query
+	// parameters may be used to adjust images' tones
+	Adjustments bool
+
+	// MetadataFields is the allowlist of embedded metadata fields served by
+	// metadata.json; when nil, metadata.json isn't served
+	MetadataFields []string
+
+	// ROIMethod is how roi.json scores images for crop suggestions; when
+	// empty, roi.json isn't served
+	ROIMethod roi.Method
+
+	// InfoExtensions are static properties added to info.json responses
+	InfoExtensions []*InfoExtension
+
+	// AllowUnescapedSlashes is true if IDs may contain slashes which weren't
+	// escaped as "%2F" in the request
+	AllowUnescapedSlashes bool
+
+	// CanonicalRedirect is true if image requests which aren't in their
+	// canonical form get a permanent redirect to it
+	CanonicalRedirect bool
 }
 
 // NewImageHandler sets up a base ImageHandler with no features
@@ -46,38 +89,107 @@ func NewImageHandler(tilePath, basePath string) *ImageHandler {
 		TilePath:      tilePath,
 		Maximums:      img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64},
 		FeatureSet:    iiif.AllFeatures(),
+
+		AllowUnescapedSlashes: true,
 	}
 }
 
-// cacheKey returns a key for caching if a given IIIF URL is cacheable by our
-// current, somewhat restrictive, rules
-func cacheKey(u *iiif.URL) string {
-	if tileCache != nil && u.Format == iiif.FmtJPG && u.Size.W > 0 && u.Size.W <= 1024 && u.Size.H <= 1024 {
-		return u.Path
+// requestFilter returns the resize filter for the request: the "filter" query
+// parameter if one was given, otherwise the handler's default
+func (ih *ImageHandler) requestFilter(req *http.Request) (transform.Filter, error) {
+	var name = req.URL.Query().Get("filter")
+	if name == "" {
+		return ih.Filter, nil
 	}
-	return ""
+	return transform.ParseFilter(name)
+}
+
+// filterOverridden returns true if the request asks for a resize filter other
+// than the handler's default.  Such derivatives aren't cached, so the tile
+// cache only ever holds images made with the default filter.
+func (ih *ImageHandler) filterOverridden(req *http.Request) bool {
+	var f, err = ih.requestFilter(req)
+	return err != nil || f != ih.Filter
 }
 
-// getRequestURL determines the "real" request URL.  Proxies are supported by
-// checking headers.  This should not be considered definitive - if RAIS is
-// running standalone, users can fake these headers.  Fortunately, this is a
-// read-only application, so it shouldn't be risky to rely on these headers as
-// they only determine how to report the RAIS URLs in info.json requests.
-func getRequestURL(req *http.Request) *url.URL {
-	var host = req.Header.Get("X-Forwarded-Host")
-	var scheme = req.Header.Get("X-Forwarded-Proto")
-	if host != "" && scheme != "" {
-		return &url.URL{Host: host, Scheme: scheme}
+// requestLayers returns the quality layer policy for the request: the
+// "layers" query parameter if one was given, which applies at any size,
+// otherwise the handler's default.  Zero asks for every layer.
+func (ih *ImageHandler) requestLayers(req *http.Request) (img.LayerPolicy, error) {
+	var val = req.URL.Query().Get("layers")
+	if val == "" {
+		return ih.Layers, nil
 	}
+	var n, err = strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return ih.Layers, fmt.Errorf("invalid layers %q", val)
+	}
+	return img.LayerPolicy{Layers: n}, nil
+}
 
-	var u = &url.URL{
-		Host:   req.Host,
-		Scheme: "http",
+// requestAdjustment returns the tone adjustment requested by the
+// "brightness", "contrast", and "gamma" query parameters.  When adjustments
+// aren't enabled, the parameters are ignored.
+func (ih *ImageHandler) requestAdjustment(req *http.Request) (transform.Adjustment, error) {
+	var a transform.Adjustment
+	if !ih.Adjustments {
+		return a, nil
+	}
+
+	var q = req.URL.Query()
+	var err error
+	if val := q.Get("brightness"); val != "" {
+		a.Brightness, err = strconv.Atoi(val)
+		if err != nil || a.Brightness < -255 || a.Brightness > 255 {
+			return a, fmt.Errorf("invalid brightness %q (must be from -255 to 255)", val)
+		}
 	}
-	if req.TLS != nil {
-		u.Scheme = "https"
+	if val := q.Get("contrast"); val != "" {
+		a.Contrast, err = strconv.ParseFloat(val, 64)
+		if err != nil || a.Contrast <= 0 || a.Contrast > 10 {
+			return a, fmt.Errorf("invalid contrast %q (must be above 0, up to 10)", val)
+		}
+	}
+	if val := q.Get("gamma"); val != "" {
+		a.Gamma, err = strconv.ParseFloat(val, 64)
+		if err != nil || a.Gamma < 0.1 || a.Gamma > 10 {
+			return a, fmt.Errorf("invalid gamma %q (must be from 0.1 to 10)", val)
+		}
 	}
-	return u
+	return a, nil
+}
+
+// requestBackground returns the color transparent images are flattened onto
+// for the request: the "background" query parameter (RGB hex, e.g.,
+// "ffffff") if one was given, otherwise the handler's default
+func (ih *ImageHandler) requestBackground(req *http.Request) (color.Color, error) {
+	var val = req.URL.Query().Get("background")
+	if val == "" {
+		return ih.Background, nil
+	}
+	return img.ParseColor(val)
+}
+
+// hintsOverridden returns true if the request asks for a resize filter,
+// quality layer policy, or background other than the handler's default, or
+// adjusts the image's tones.  Like filter overrides, these derivatives
+// aren't cached.
+func (ih *ImageHandler) hintsOverridden(req *http.Request) bool {
+	if ih.filterOverridden(req) {
+		return true
+	}
+	var l, err = ih.requestLayers(req)
+	if err != nil || l != ih.Layers {
+		return true
+	}
+	var bg color.Color
+	bg, err = ih.requestBackground(req)
+	if err != nil || bg != ih.Background {
+		return true
+	}
+	var a transform.Adjustment
+	a, err = ih.requestAdjustment(req)
+	return err != nil || a.Enabled()
 }
 
 // IIIFRoute takes an HTTP request and parses it to see what (if any) IIIF
@@ -91,18 +203,18 @@ func (ih *ImageHandler) IIIFRoute(w http.ResponseWriter, req *http.Request) {
 	u.RawQuery = ""
 	u.Fragment = ""
 
-	// Figure out the hostname, scheme, port, etc. either from the request or the
-	// setting if it was explicitly set
-	if ih.BaseURL != nil {
-		u.Host = ih.BaseURL.Host
-		u.Scheme = ih.BaseURL.Scheme
+	// Figure out the hostname, scheme, port, and proxy path prefix either from
+	// the request or the setting if it was explicitly set
+	var base = ih.BaseURL
+	var redirBase string
+	if base == nil {
+		base = getRequestURL(req)
+		redirBase = redirectPrefix(req)
 	} else {
-		var u2 = getRequestURL(req)
-		if u2 != nil {
-			u.Host = u2.Host
-			u.Scheme = u2.Scheme
-		}
+		redirBase = base.Path
 	}
+	u.Host = base.Host
+	u.Scheme = base.Scheme
 
 	// Strip the IIIF web path off the beginning of the path to determine the
 	// actual request.  This should always work because a request shouldn't be
@@ -110,68 +222,184 @@ func (ih *ImageHandler) IIIFRoute(w http.ResponseWriter, req *http.Request) {
 	var prefix = ih.WebPathPrefix + "/"
 	u.Path = strings.Replace(u.Path, prefix, "", 1)
 
+	// geo.json, metadata.json, and roi.json aren't part of IIIF, so they have
+	// to be handled before the IIIF parser rejects them
+	if ih.GeoService && strings.HasSuffix(u.Path, "/geo.json") {
+		ih.Geo(w, req, iiif.URLToID(strings.TrimSuffix(u.Path, "/geo.json")))
+		return
+	}
+	if ih.MetadataFields != nil && strings.HasSuffix(u.Path, "/metadata.json") {
+		ih.Metadata(w, req, iiif.URLToID(strings.TrimSuffix(u.Path, "/metadata.json")))
+		return
+	}
+	if ih.ROIMethod != "" && strings.HasSuffix(u.Path, "/roi.json") {
+		ih.ROI(w, req, iiif.URLToID(strings.TrimSuffix(u.Path, "/roi.json")))
+		return
+	}
+
+	var ctx = req.Context()
+	var _, endParse = startSpan(ctx, "iiif.parse")
 	iiifURL, err := iiif.NewURL(u.Path)
+	endParse()
+	logIIIFRequest(req, iiifURL)
 	// If the iiifURL is invalid, it's possible this is a base URI request.
 	// Let's see if treating the path as an ID gives us any info.
 	if err != nil {
-		if ih.isValidBasePath(u.Path) {
-			http.Redirect(w, req, req.URL.String()+"/info.json", 303)
+		if ih.isValidBasePath(ctx, u.Path) {
+			// The query is kept so that signed URL tokens still work
+			var loc = redirBase + req.URL.EscapedPath() + "/info.json"
+			if req.URL.RawQuery != "" {
+				loc += "?" + req.URL.RawQuery
+			}
+			http.Redirect(w, req, loc, 303)
 		} else {
 			http.Error(w, fmt.Sprintf("Invalid IIIF request %q: %s", iiifURL.Path, err), 400)
 		}
 		return
 	}
 
+	if !ih.checkIDSlashes(w, req, iiifURL.ID) {
+		return
+	}
+
+	// Taken-down images are gone no matter what plugins or caches might have
+	if takedowns.get(iiifURL.ID) != nil {
+		http.Error(w, "This image has been removed", http.StatusGone)
+		return
+	}
+	var limit, ok = authorize(ctx, w, iiifURL.ID)
+	if !ok {
+		return
+	}
+	if missingCache.has(iiifURL.ID) {
+		logCache(req, true)
+		http.Error(w, "image resource does not exist", http.StatusNotFound)
+		return
+	}
+
 	// Handle info.json prior to reading the image, in case of cached info
-	fp := ih.getIIIFPath(iiifURL.ID)
-	info, e := ih.getInfo(iiifURL.ID, fp)
+	var _, endResolve = startSpan(ctx, "plugin.resolve_id")
+	fp, err := ih.resolveIIIFPath(ctx, iiifURL.ID)
+	endResolve()
+	if err == plugins.ErrForbidden {
+		http.Error(w, "Access to this image is forbidden", http.StatusForbidden)
+		return
+	}
+	if err == errInvalidID {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		return
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		e := newImageResError(ctxErr)
+		e.write(w)
+		return
+	}
+	if iiifURL.Info && infoCache != nil {
+		logCache(req, infoCache.Contains(iiifURL.ID))
+	}
+	var _, endInfo = startSpan(ctx, "info.load")
+	info, e := ih.getInfo(ctx, iiifURL.ID, fp)
+	endInfo()
+	if e != nil && e.Code == http.StatusNotFound {
+		missingCache.add(iiifURL.ID)
+	}
 	if e != nil {
-		if e.Code != 404 {
-			Logger.Errorf("Error getting IIIF info.json for resource %s (path %s): %s", iiifURL.ID, fp, e.Message)
+		if e.Code != 404 && e.Code != http.StatusServiceUnavailable {
+			requestLogger(ctx).Errorf("Error getting IIIF info.json for resource %s (path %s): %s", iiifURL.ID, fp, e.Message)
 		}
-		http.Error(w, e.Message, e.Code)
+		e.write(w)
 		return
 	}
+	constrainInfo(info, limit)
 
 	// Make sure the info JSON has the proper asset id, which, for some reason in
 	// the IIIF spec, requires the full URL to the asset, not just its identifier
 	infourl := &url.URL{
 		Scheme: u.Scheme,
 		Host:   u.Host,
-		Path:   ih.WebPathPrefix,
+		Path:   base.Path + ih.WebPathPrefix,
 	}
 
 	// Because of how Go's URL path magic works, we really do have to just
 	// concatenate these two things with a slash manually
 	info.ID = infourl.String() + "/" + iiifURL.ID.Escaped()
+	setGeoServiceID(info)
 
 	if iiifURL.Info {
+		ih.extendInfo(iiifURL.ID, info)
+		for _, decorate := range decorateInfoPlugins {
+			decorate(iiifURL.ID, info)
+		}
+		ih.cachePoliciesFor(iiifURL.ID).setHeaders(w, req, iiifURL.ID, true)
 		ih.Info(w, req, info)
 		return
 	}
 
-	// Check the cache before spending the cycles to read in the image.  For now
-	// the cache is very limited to ensure only relatively small requests are
-	// actually cached.
-	if key := cacheKey(iiifURL); key != "" {
+	// Requests for the same image should all end up at one URL so caches,
+	// ours and everybody else's, see them as the same thing.  Overridden
+	// info.json files without dimensions don't give us enough to go on.
+	if info.Width > 0 && info.Height > 0 {
+		var canonical = iiifURL.Canonical(info.Width, info.Height)
+		if ih.CanonicalRedirect && !iiifURL.IsCanonical(info.Width, info.Height) {
+			// The redirect is permanent, so caches will hold onto it.  It mustn't
+			// include anything a client can choose with forwarding headers; see
+			// redirectPrefix.
+			var loc = redirBase + ih.WebPathPrefix + "/" + iiifURL.ID.Escaped() + "/" + canonical
+			if req.URL.RawQuery != "" {
+				loc += "?" + req.URL.RawQuery
+			}
+			http.Redirect(w, req, loc, http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf("<%s/%s>;rel=\"canonical\"", info.ID, canonical))
+	}
+
+	// Check the cache, and then the peer which owns this tile if the cache is
+	// shared, before spending the cycles to read in the image.  Requests with
+	// size limits skip the cache, since it may hold tiles someone else was
+	// allowed to get.
+	if key := ih.cacheKey(iiifURL, fp, info); key != "" && !ih.hintsOverridden(req) && limit == unconstrained {
+		prefetcher.enqueue(iiifURL, fp, info)
 		stats.TileCache.Get()
-		data, ok := tileCache.Get(key)
+		var _, endCache = startSpan(ctx, "cache.get")
+		cached, ok := tileCache.Get(key)
+		endCache()
+		var data []byte
+		var source string
+		if ok {
+			var e = cached.(*tileEntry)
+			source = e.source
+			data, ok = ih.cachedTile(key, e, iiifURL, fp, info)
+		} else if peers != nil {
+			var _, endPeer = startSpan(ctx, "cache.peer")
+			data, ok = peers.fetchImage(ctx, key, iiifURL.Path)
+			endPeer()
+		}
+		logCache(req, ok)
 		if ok {
-			stats.TileCache.Hit()
-			w.Header().Set("Content-Type", mime.TypeByExtension("."+string(iiifURL.Format)))
-			w.Write(data.([]byte))
+			ih.cachePoliciesFor(iiifURL.ID).setHeaders(w, req, iiifURL.ID, false)
+			if sendCachedHeaders(w, req, fp, iiifURL, source) != nil {
+				return
+			}
+			w.Header().Set("Content-Type", contentType(iiifURL.Format))
+			w.Write(data)
 			return
 		}
 	}
 
 	// No info path should mean a full command path - start reading the image
-	res, err := img.NewResource(iiifURL.ID, fp)
+	var _, endRes = startSpan(ctx, "image.open")
+	res, err := img.NewResourceContext(ctx, iiifURL.ID, fp)
+	endRes()
+	if err == img.ErrDoesNotExist {
+		missingCache.add(iiifURL.ID)
+	}
 	if err != nil {
 		e := newImageResError(err)
-		if e.Code != 404 {
-			Logger.Errorf("Error initializing resource %s (path %s): %s", iiifURL.ID, fp, err)
+		if e.Code != 404 && e.Code != http.StatusServiceUnavailable {
+			requestLogger(ctx).Errorf("Error initializing resource %s (path %s): %s", iiifURL.ID, fp, err)
 		}
-		http.Error(w, e.Message, e.Code)
+		e.write(w)
 		return
 	}
 
@@ -182,36 +410,128 @@ func (ih *ImageHandler) IIIFRoute(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Attempt to run the command
-	ih.Command(w, req, iiifURL, res, info)
+	ih.Command(w, req, iiifURL, res, info, limit)
+}
+
+// cachedTile returns the data from a tile cache hit if it can be served.  A
+// tile whose source image has changed is stale: depending on the tile cache
+// policy, it's served while a fresh copy is made in the background, or it's
+// treated as a miss so the request regenerates it.
+func (ih *ImageHandler) cachedTile(key string, e *tileEntry, u *iiif.URL, fp string, info *iiif.Info) ([]byte, bool) {
+	if tilePolicy.fresh(e, fp) {
+		stats.TileCache.Hit()
+		return e.data, true
+	}
+
+	stats.TileCache.Stale()
+	if tilePolicy.stale == staleRefresh {
+		return nil, false
+	}
+
+	stats.TileCache.Hit()
+	revalidator.start(key, func() {
+		var err = ih.regenerateTile(key, u, fp, info)
+		if err != nil {
+			Logger.Warnf("Unable to regenerate stale tile %q: %s", key, err)
+		}
+	})
+	return e.data, true
 }
 
 // isValidBasePath returns true if the given path is simply missing /info.json
 // to function properly
-func (ih *ImageHandler) isValidBasePath(path string) bool {
+func (ih *ImageHandler) isValidBasePath(ctx context.Context, path string) bool {
 	var jsonPath = path + "/info.json"
 	var iiifURL, err = iiif.NewURL(jsonPath)
 	if err != nil {
 		return false
 	}
 
-	var fp = ih.getIIIFPath(iiifURL.ID)
+	var fp, _ = ih.resolveIIIFPath(ctx, iiifURL.ID)
 	var e *HandlerError
-	_, e = ih.getInfo(iiifURL.ID, fp)
+	_, e = ih.getInfo(ctx, iiifURL.ID, fp)
 	return e == nil
 }
 
+// getIIIFPath returns the file path for id, or an empty string if a plugin
+// forbids access to it
 func (ih *ImageHandler) getIIIFPath(id iiif.ID) string {
+	var fp, _ = ih.resolveIIIFPath(context.Background(), id)
+	return fp
+}
+
+// resolveIIIFPath returns the file path for id.  If a plugin forbids access
+// to id, the path is empty and plugins.ErrForbidden is returned; if id could
+// escape its directory, errInvalidID is returned.  Page
+// suffixes aren't part of the file's ID, so plugins and routes never see them.
+// Aliases are replaced by their targets, so plugins and routes only see the
+// real ID.  Plugins which take a context may give up early if ctx is done.
+func (ih *ImageHandler) resolveIIIFPath(ctx context.Context, id iiif.ID) (string, error) {
+	id, _, _ = img.SplitPage(id)
+	if err := validateID(id); err != nil {
+		return "", err
+	}
+	var target = aliases.resolve(id)
+	if isAliasPath(target) {
+		return string(target), nil
+	}
+	if err := validateID(target); err != nil {
+		return "", err
+	}
 	for _, idtopath := range idToPathPlugins {
-		fp, err := idtopath(id)
+		fp, err := idtopath(ctx, target)
 		if err == nil {
-			return fp
+			return fp, nil
 		}
 		if err == plugins.ErrSkipped {
 			continue
 		}
-		Logger.Warnf("Error trying to use plugin to translate iiif.ID: %s", err)
+		if err == plugins.ErrForbidden {
+			return "", err
+		}
+		requestLogger(ctx).Warnf("Error trying to use plugin to translate iiif.ID: %s", err)
+	}
+	if r := ih.tileRouteFor(id); r != nil {
+		return r.path(target), nil
+	}
+	return filepath.Join(ih.TilePath, string(target)), nil
+}
+
+// resolveSidecar runs the checks an image request would for requests which
+// describe an image rather than returning it, such as geo.json: takedowns,
+// plugin access rules, and the image's existence.  If any check fails, an
+// error is written to w and ok is false.
+func (ih *ImageHandler) resolveSidecar(req *http.Request, w http.ResponseWriter, id iiif.ID) (fp string, info *iiif.Info, ok bool) {
+	if !ih.checkIDSlashes(w, req, id) {
+		return "", nil, false
+	}
+	var ctx = req.Context()
+	if takedowns.get(id) != nil {
+		http.Error(w, "This image has been removed", http.StatusGone)
+		return "", nil, false
+	}
+	if _, ok = authorize(ctx, w, id); !ok {
+		return "", nil, false
+	}
+
+	var err error
+	fp, err = ih.resolveIIIFPath(ctx, id)
+	if err == plugins.ErrForbidden {
+		http.Error(w, "Access to this image is forbidden", http.StatusForbidden)
+		return "", nil, false
+	}
+	if err == errInvalidID {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	var e *HandlerError
+	info, e = ih.getInfo(ctx, id, fp)
+	if e != nil {
+		e.write(w)
+		return "", nil, false
 	}
-	return ih.TilePath + "/" + string(id)
+	return fp, info, true
 }
 
 func convertStrings(s1, s2, s3 string) (i1, i2, i3 int, err error) {
@@ -248,17 +568,25 @@ func (ih *ImageHandler) Info(w http.ResponseWriter, req *http.Request, info *iii
 }
 
 func newImageResError(err error) *HandlerError {
+	if st, ok := err.(*img.SourceTooLargeError); ok {
+		return &HandlerError{Message: st.Error(), Code: http.StatusForbidden, Detail: st}
+	}
+
 	switch err {
 	case img.ErrDimensionsExceedLimits:
 		return NewError(err.Error(), 501)
 	case img.ErrDoesNotExist:
 		return NewError("image resource does not exist", 404)
+	case img.ErrRegionOutOfBounds, img.ErrUpscaleNotAllowed:
+		return NewError(err.Error(), 400)
+	case context.Canceled, context.DeadlineExceeded:
+		return NewError("request canceled or timed out", http.StatusServiceUnavailable)
 	default:
 		return NewError(err.Error(), 500)
 	}
 }
 
-func (ih *ImageHandler) getInfo(id iiif.ID, fp string) (info *iiif.Info, err *HandlerError) {
+func (ih *ImageHandler) getInfo(ctx context.Context, id iiif.ID, fp string) (info *iiif.Info, err *HandlerError) {
 	// Check for cached image data first, and use that to create JSON
 	info = ih.loadInfoFromCache(id)
 
@@ -269,7 +597,7 @@ func (ih *ImageHandler) getInfo(id iiif.ID, fp string) (info *iiif.Info, err *Ha
 	}
 
 	if info == nil {
-		info, err = ih.loadInfoFromImageResource(id, fp)
+		info, err = ih.loadInfoFromImageResource(ctx, id, fp)
 	}
 
 	return info, err
@@ -290,10 +618,35 @@ func (ih *ImageHandler) loadInfoFromCache(id iiif.ID) *iiif.Info {
 	return ih.buildInfo(id, data.(ImageInfo))
 }
 
+// overlayFile returns the path to id's file named with the given suffix in
+// the overlay directory, or an empty string if there's no overlay directory.
+// The overlay mirrors the ID space, so for unrouted IDs it mirrors TilePath.
+func (ih *ImageHandler) overlayFile(id iiif.ID, suffix string) string {
+	if ih.OverlayPath == "" {
+		return ""
+	}
+	var rest = string(aliases.resolve(id))
+	return filepath.Join(ih.OverlayPath, filepath.Clean("/"+rest)) + suffix
+}
+
 func (ih *ImageHandler) loadInfoOverride(id iiif.ID, fp string) *iiif.Info {
-	// If an override file isn't found or has an error, just skip it
-	var infofile = fp + "-info.json"
-	data, err := ioutil.ReadFile(infofile)
+	// If an override file isn't found or has an error, just skip it.  The
+	// overlay directory, if there is one, is checked before the image's own
+	// directory.
+	var candidates = []string{fp + "-info.json"}
+	if ov := ih.overlayFile(id, "-info.json"); ov != "" {
+		candidates = append([]string{ov}, candidates...)
+	}
+
+	var infofile string
+	var data []byte
+	var err error
+	for _, infofile = range candidates {
+		data, err = ioutil.ReadFile(infofile)
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return nil
 	}
@@ -309,11 +662,32 @@ func (ih *ImageHandler) loadInfoOverride(id iiif.ID, fp string) *iiif.Info {
 	return info
 }
 
-func (ih *ImageHandler) loadInfoFromImageResource(id iiif.ID, fp string) (*iiif.Info, *HandlerError) {
-	Logger.Debugf("Loading image data from image resource (id: %s)", id)
-	res, err := img.NewResource(id, fp)
+func (ih *ImageHandler) loadInfoFromImageResource(ctx context.Context, id iiif.ID, fp string) (*iiif.Info, *HandlerError) {
+	var imageInfo, err = ih.readImageInfo(ctx, id, fp)
 	if err != nil {
-		return nil, newImageResError(err)
+		return nil, err
+	}
+	return ih.buildInfo(id, imageInfo), nil
+}
+
+// readImageInfo reads id's dimensions and tiling from the image itself, or
+// gets them from the image's owner if the cache is shared with peers, and
+// stores them in the info cache
+func (ih *ImageHandler) readImageInfo(ctx context.Context, id iiif.ID, fp string) (ImageInfo, *HandlerError) {
+	if imageInfo, ok, e := peers.fetchInfo(ctx, id); ok || e != nil {
+		if ok && infoCache != nil {
+			stats.InfoCache.Set()
+			infoCache.Add(id, imageInfo)
+		}
+		return imageInfo, e
+	}
+
+	// Creating a resource only reads the image's headers; nothing is decoded
+	// until Apply is called
+	requestLogger(ctx).Debugf("Loading image data from image resource (id: %s)", id)
+	res, err := img.NewResourceContext(ctx, id, fp)
+	if err != nil {
+		return ImageInfo{}, newImageResError(err)
 	}
 
 	d := res.Decoder
@@ -324,54 +698,68 @@ func (ih *ImageHandler) loadInfoFromImageResource(id iiif.ID, fp string) (*iiif.
 		TileHeight: d.GetTileHeight(),
 		Levels:     d.GetLevels(),
 	}
+	if ih.GeoService {
+		imageInfo.Georeferenced = geo.Has(fp)
+	}
 
 	if infoCache != nil {
 		stats.InfoCache.Set()
 		infoCache.Add(id, imageInfo)
 	}
-	return ih.buildInfo(id, imageInfo), nil
+	return imageInfo, nil
 }
 
 func (ih *ImageHandler) buildInfo(id iiif.ID, i ImageInfo) *iiif.Info {
-	info := ih.FeatureSet.Info()
+	info := ih.featureSetFor(id).Info()
 	info.Width = i.Width
 	info.Height = i.Height
 
-	if ih.Maximums.SmallerThanAny(i.Width, i.Height) {
-		info.Profile.MaxArea = ih.Maximums.Area
-		info.Profile.MaxWidth = ih.Maximums.Width
-		info.Profile.MaxHeight = ih.Maximums.Height
+	var max = ih.maximumsFor(id)
+	if max.SmallerThanAny(i.Width, i.Height) {
+		info.Profile.MaxArea = max.Area
+		info.Profile.MaxWidth = max.Width
+		info.Profile.MaxHeight = max.Height
 	}
 
+	info.Sizes = levelSizes(i, max)
+
 	// Set up tile sizes
-	if i.TileWidth > 0 {
-		var sf []int
-		scale := 1
-		for x := 0; x < i.Levels; x++ {
-			// For sanity's sake, let's not tell viewers they can get at absurdly
-			// small sizes
-			if info.Width/scale < 16 {
-				break
-			}
-			if info.Height/scale < 16 {
-				break
-			}
-			sf = append(sf, scale)
-			scale <<= 1
-		}
-		info.Tiles = make([]iiif.TileSize, 1)
-		info.Tiles[0] = iiif.TileSize{
-			Width:        i.TileWidth,
-			ScaleFactors: sf,
-		}
-		if i.TileHeight > 0 {
-			info.Tiles[0].Height = i.TileHeight
-		}
+	var ts = ih.tileGridFor(id).tileSize(i)
+	if ts != nil {
+		info.Tiles = []iiif.TileSize{*ts}
+	}
+
+	if i.Georeferenced {
+		info.Service = append(info.Service, newGeoService())
 	}
 
 	return info
 }
 
+// levelSizes returns the dimensions of each of the image's resolution levels,
+// smallest first, so viewers can request sizes the decoder can produce
+// without resampling.  Levels smaller than tiles would be allowed to get, or
+// larger than the maximums allow, are skipped.  Single-resolution images have
+// no sizes worth listing.
+func levelSizes(i ImageInfo, max img.Constraint) []iiif.InfoSize {
+	if i.Levels < 2 {
+		return nil
+	}
+
+	var sizes []iiif.InfoSize
+	for x := i.Levels - 1; x >= 0; x-- {
+		// Resolution levels round up, the same as JP2 reductions do
+		var scale = 1 << uint(x)
+		var w, h = (i.Width + scale - 1) / scale, (i.Height + scale - 1) / scale
+		if w < 16 || h < 16 || max.SmallerThanAny(w, h) {
+			continue
+		}
+		sizes = append(sizes, iiif.InfoSize{Width: w, Height: h})
+	}
+
+	return sizes
+}
+
 func marshalInfo(info *iiif.Info) ([]byte, *HandlerError) {
 	json, err := json.Marshal(info)
 	if err != nil {
@@ -382,63 +770,170 @@ func marshalInfo(info *iiif.Info) ([]byte, *HandlerError) {
 	return json, nil
 }
 
-// Command handles image processing operations
-func (ih *ImageHandler) Command(w http.ResponseWriter, req *http.Request, u *iiif.URL, res *img.Resource, info *iiif.Info) {
-	// Send last modified time
-	if err := sendHeaders(w, req, res.FilePath); err != nil {
-		return
-	}
+// degradedHeader is sent with images decoded at a lower resolution than
+// requested to stay within the decode budget
+const degradedHeader = "X-RAIS-Degraded"
 
+// Command handles image processing operations.  limit is the size limit the
+// request's authorization grant imposes, if any; limited derivatives aren't
+// cached, since the cache may serve them to requests without that limit.
+func (ih *ImageHandler) Command(w http.ResponseWriter, req *http.Request, u *iiif.URL, res *img.Resource, info *iiif.Info, limit img.Constraint) {
 	// Do we support this request?  If not, return a 501
-	if !ih.FeatureSet.Supported(u) {
+	if !ih.featureSetFor(u.ID).Supported(u) {
 		http.Error(w, "Feature not supported", 501)
 		return
 	}
 
-	var max = ih.Maximums
+	// Send caching headers, last modified time, and ETag, and skip all the
+	// work if the client's copy is current
+	ih.cachePoliciesFor(u.ID).setHeaders(w, req, u.ID, false)
+	if err := sendHeaders(w, req, res.FilePath, u); err != nil {
+		return
+	}
 
-	// If we have an info, we can make use of it for the constraints rather than
-	// using the global constraints; this is useful for overridden info.json files
-	if info != nil {
-		max = img.Constraint{
-			Width:  info.Profile.MaxWidth,
-			Height: info.Profile.MaxHeight,
-			Area:   info.Profile.MaxArea,
-		}
-		if max.Width == 0 {
-			max.Width = math.MaxInt32
-		}
-		if max.Height == 0 {
-			max.Height = math.MaxInt32
-		}
-		if max.Area == 0 {
-			max.Area = math.MaxInt64
-		}
+	var filter, err = ih.requestFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	img, err := res.Apply(u, max)
+	res.Layers, err = ih.requestLayers(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res.Adjust, err = ih.requestAdjustment(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res.Background, err = ih.requestBackground(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res.Filter = filter
+	res.Sharpen = ih.Sharpen
+	res.Upscale = ih.Upscale
+	res.Budget = ih.Budget
+
+	var max = ih.constraintsFor(u.ID, info)
+	var source = sourceFingerprint(res.FilePath)
+	var ctx = req.Context()
+	if q := quarantine.get(u.ID); q != nil {
+		q.write(w)
+		return
+	}
+	var release, qerr = scheduler.acquire(ctx, classify(u, info))
+	if qerr != nil {
+		NewError("timed out waiting to decode image", http.StatusServiceUnavailable).write(w)
+		return
+	}
+	defer release()
+
+	var done = load.start()
+	defer done()
+
+	var _, endDecode = startSpan(ctx, "image.decode")
+	img, err := decode(ctx, res, u, max)
+	endDecode()
 	if err != nil {
 		e := newImageResError(err)
-		Logger.Errorf("Error applying transorm: %s", err)
-		http.Error(w, e.Message, e.Code)
+		if e.Code != http.StatusServiceUnavailable {
+			requestLogger(ctx).Errorf("Error applying transorm: %s", err)
+		}
+		e.write(w)
 		return
 	}
+	if u.Format == iiif.FmtPDF {
+		img = &pdfPage{Image: img, info: ih.pdfInfo(u.ID, res.FilePath)}
+	}
+
+	w.Header().Set("Content-Type", contentType(u.Format))
+
+	// Degraded images must not be cached anywhere, or the blurry copy would
+	// outlive the load that caused it
+	var degraded = res.Reduced > 0
+	if degraded {
+		w.Header().Set(degradedHeader, fmt.Sprintf("reduced-resolution; levels=%d", res.Reduced))
+		w.Header().Set("Access-Control-Expose-Headers", degradedHeader)
+		w.Header().Set("Cache-Control", "no-store")
+	}
 
-	w.Header().Set("Content-Type", mime.TypeByExtension("."+string(u.Format)))
+	// Images we won't cache are encoded straight to the client rather than
+	// into a buffer, so huge exports don't need memory for both the decoded
+	// and encoded image
+	var key = ih.cacheKey(u, res.FilePath, info)
+	if key == "" || degraded || limit != unconstrained || ih.hintsOverridden(req) {
+		var sw = newStreamWriter(w)
+		var _, endEncode = startSpan(ctx, "image.encode")
+		err = encoders.encode(ctx, sw, img, u.Format)
+		if err == nil {
+			err = sw.Close()
+		}
+		endEncode()
+		if err != nil && !sw.Streaming() {
+			ih.encodeError(w, u, err)
+		} else if err != nil {
+			requestLogger(ctx).Errorf("Unable to encode to %s: %s", u.Format, err)
+		}
+		return
+	}
 
 	cacheBuf := bytes.NewBuffer(nil)
-	if err := EncodeImage(cacheBuf, img, u.Format); err != nil {
-		http.Error(w, "Unable to encode", 500)
-		Logger.Errorf("Unable to encode to %s: %s", u.Format, err)
+	var _, endEncode = startSpan(ctx, "image.encode")
+	err = encoders.encode(ctx, cacheBuf, img, u.Format)
+	endEncode()
+	if err != nil {
+		ih.encodeError(w, u, err)
 		return
 	}
 
-	if key := cacheKey(u); key != "" {
+	if tilePolicy.fits(cacheBuf.Len()) {
 		stats.TileCache.Set()
-		tileCache.Add(key, cacheBuf.Bytes())
+		var _, endCache = startSpan(ctx, "cache.set")
+		tileCache.Add(key, &tileEntry{data: cacheBuf.Bytes(), source: source})
+		endCache()
 	}
 
+	w.Header().Set("Content-Length", strconv.Itoa(cacheBuf.Len()))
 	if _, err := io.Copy(w, cacheBuf); err != nil {
-		Logger.Errorf("Unable to encode to %s: %s", u.Format, err)
+		requestLogger(ctx).Errorf("Unable to encode to %s: %s", u.Format, err)
+		return
+	}
+}
+
+// constraintsFor returns the size limits for an image.  If we have an info,
+// we can make use of it for the constraints rather than using the global
+// constraints; this is useful for overridden info.json files.
+func (ih *ImageHandler) constraintsFor(id iiif.ID, info *iiif.Info) img.Constraint {
+	if info == nil {
+		return ih.maximumsFor(id)
+	}
+
+	var max = img.Constraint{
+		Width:  info.Profile.MaxWidth,
+		Height: info.Profile.MaxHeight,
+		Area:   info.Profile.MaxArea,
+	}
+	if max.Width == 0 {
+		max.Width = math.MaxInt32
+	}
+	if max.Height == 0 {
+		max.Height = math.MaxInt32
+	}
+	if max.Area == 0 {
+		max.Area = math.MaxInt64
+	}
+	return max
+}
+
+// encodeError reports a failed encode, as long as nothing has been sent to
+// the client yet
+func (ih *ImageHandler) encodeError(w http.ResponseWriter, u *iiif.URL, err error) {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		newImageResError(err).write(w)
 		return
 	}
+	http.Error(w, "Unable to encode", 500)
+	Logger.Errorf("Unable to encode to %s: %s", u.Format, err)
 }
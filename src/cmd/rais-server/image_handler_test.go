@@ -4,14 +4,18 @@
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image/color"
+	"io/ioutil"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"rais/src/fakehttp"
 	"rais/src/iiif"
 	"rais/src/img"
+	"rais/src/transform"
 	"strings"
 	"testing"
 
@@ -99,6 +103,29 @@ func TestInfoHandlerJSONOverride(t *testing.T) {
 	assert.Equal("application/json", w.Headers["Content-Type"][0], "Proper content type", t)
 }
 
+func TestInfoOverrideOverlay(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-overlay")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var h = NewImageHandler("/var/local/images", "/iiif")
+	assert.True(h.loadInfoOverride("maps/a.jp2", "/var/local/images/maps/a.jp2") == nil, "no override anywhere", t)
+
+	h.OverlayPath = dir
+	os.MkdirAll(filepath.Join(dir, "maps"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "maps", "a.jp2-info.json"), []byte(`{"width": 123, "height": 45}`), 0644)
+	var info = h.loadInfoOverride("maps/a.jp2", "/var/local/images/maps/a.jp2")
+	assert.True(info != nil, "override is found in the overlay", t)
+	assert.Equal(123, info.Width, "overlay override width", t)
+	assert.Equal(filepath.Join(dir, "etc/passwd-info.json"), h.overlayFile("../../etc/passwd", "-info.json"), "IDs can't escape the overlay", t)
+
+	var fp = filepath.Join(rootDir(), "docker/images/testfile/test-world.jp2")
+	info = h.loadInfoOverride("docker/images/testfile/test-world.jp2", fp)
+	assert.Equal(800, info.Width, "overrides next to the image still work", t)
+}
+
 func TestInfoHandlerBuiltJSON(t *testing.T) {
 	// We don't want to test the JSON override this time, so we use the symlink
 	w := request("docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", t)
@@ -117,6 +144,38 @@ func TestInfoHandlerBuiltJSON(t *testing.T) {
 	assert.Equal("application/json", w.Headers["Content-Type"][0], "Proper content type", t)
 }
 
+func TestInfoHandlerSourceTooLarge(t *testing.T) {
+	img.SourceMax = img.SourceLimits{Width: 500}
+	defer func() { img.SourceMax = img.SourceLimits{} }()
+
+	w := request("docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", t)
+	assert.Equal(403, w.StatusCode, "Oversized sources are forbidden", t)
+	assert.Equal("application/json", w.Headers["Content-Type"][0], "Error is JSON", t)
+	var data struct {
+		Error  string
+		Detail img.SourceTooLargeError
+	}
+	json.Unmarshal(w.Output, &data)
+	assert.Equal(800, data.Detail.Width, "Error reports the source width", t)
+	assert.Equal(500, data.Detail.Limits.Width, "Error reports the width limit", t)
+	assert.Equal("width 800 exceeds 500", data.Detail.Problems[0], "Error explains the problem", t)
+}
+
+func TestInfoHandlerDecorated(t *testing.T) {
+	decorateInfoPlugins = []func(iiif.ID, *iiif.Info){func(id iiif.ID, info *iiif.Info) {
+		info.Attribution = "Provided by " + string(id)
+		info.Service = append(info.Service, map[string]string{"profile": "http://iiif.io/api/auth/1/login"})
+	}}
+	defer func() { decorateInfoPlugins = nil }()
+
+	w := request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", t)
+	var data iiif.Info
+	json.Unmarshal(w.Output, &data)
+	assert.Equal("Provided by docker/images/testfile/test-world.jp2", data.Attribution, "decorated attribution", t)
+	assert.Equal(1, len(data.Service), "decorated service", t)
+	assert.Equal(800, data.Width, "undecorated values are unchanged", t)
+}
+
 func TestInfoHandlerLD(t *testing.T) {
 	w := requestLD("docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", t)
 	assert.Equal(-1, w.StatusCode, "Valid info request doesn't explicitly set status code", t)
@@ -243,3 +302,158 @@ func BenchmarkRouting(b *testing.B) {
 		}
 	}
 }
+
+func TestLevelSizes(t *testing.T) {
+	var max = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
+	var sizes = levelSizes(ImageInfo{Width: 1001, Height: 600, Levels: 4}, max)
+	assert.Equal(4, len(sizes), "one size per level", t)
+	assert.Equal(iiif.InfoSize{Width: 126, Height: 75}, sizes[0], "smallest level rounds up", t)
+	assert.Equal(iiif.InfoSize{Width: 1001, Height: 600}, sizes[3], "full size is last", t)
+
+	max.Width = 600
+	sizes = levelSizes(ImageInfo{Width: 1001, Height: 600, Levels: 4}, max)
+	assert.Equal(3, len(sizes), "sizes over the maximums are skipped", t)
+
+	sizes = levelSizes(ImageInfo{Width: 100, Height: 100, Levels: 6}, img.Constraint{Width: 100, Height: 100, Area: 10000})
+	assert.Equal(3, len(sizes), "tiny sizes are skipped", t)
+
+	assert.Equal(0, len(levelSizes(ImageInfo{Width: 100, Height: 100, Levels: 1}, max)), "single-resolution images", t)
+}
+
+func TestRequestFilter(t *testing.T) {
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	ih.Filter = transform.FilterBilinear
+
+	var req, _ = http.NewRequest("GET", "/iiif/a.jp2/full/100,/0/default.jpg", nil)
+	var f, err = ih.requestFilter(req)
+	assert.NilError(err, "no filter parameter", t)
+	assert.Equal(transform.FilterBilinear, f, "default filter", t)
+	assert.False(ih.filterOverridden(req), "default filter isn't an override", t)
+
+	req, _ = http.NewRequest("GET", "/iiif/a.jp2/full/100,/0/default.jpg?filter=lanczos3", nil)
+	f, err = ih.requestFilter(req)
+	assert.NilError(err, "valid filter parameter", t)
+	assert.Equal(transform.FilterLanczos3, f, "requested filter", t)
+	assert.True(ih.filterOverridden(req), "requested filter is an override", t)
+
+	req, _ = http.NewRequest("GET", "/iiif/a.jp2/full/100,/0/default.jpg?filter=blurry", nil)
+	_, err = ih.requestFilter(req)
+	assert.True(err != nil, "invalid filter parameter", t)
+}
+
+func TestRequestAdjustment(t *testing.T) {
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	var req, _ = http.NewRequest("GET", "/iiif/a.jp2/full/100,/0/default.jpg?gamma=1.4&brightness=10", nil)
+	var a, err = ih.requestAdjustment(req)
+	assert.NilError(err, "adjustments disabled", t)
+	assert.False(a.Enabled(), "parameters are ignored when adjustments are disabled", t)
+	assert.False(ih.hintsOverridden(req), "ignored parameters aren't an override", t)
+
+	ih.Adjustments = true
+	a, err = ih.requestAdjustment(req)
+	assert.NilError(err, "valid parameters", t)
+	assert.Equal(transform.Adjustment{Brightness: 10, Gamma: 1.4}, a, "adjustment", t)
+	assert.True(ih.hintsOverridden(req), "adjusted images aren't cached", t)
+
+	for _, q := range []string{"brightness=300", "contrast=0", "gamma=bright"} {
+		req, _ = http.NewRequest("GET", "/iiif/a.jp2/full/100,/0/default.jpg?"+q, nil)
+		_, err = ih.requestAdjustment(req)
+		assert.True(err != nil, "invalid parameter "+q, t)
+	}
+}
+
+func TestRequestBackground(t *testing.T) {
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	var req, _ = http.NewRequest("GET", "/iiif/a.png/full/100,/0/default.jpg", nil)
+	var bg, err = ih.requestBackground(req)
+	assert.NilError(err, "no background parameter", t)
+	assert.True(bg == nil, "default background is unset", t)
+	assert.False(ih.hintsOverridden(req), "default background isn't an override", t)
+
+	req, _ = http.NewRequest("GET", "/iiif/a.png/full/100,/0/default.jpg?background=fff", nil)
+	bg, err = ih.requestBackground(req)
+	assert.NilError(err, "valid background parameter", t)
+	assert.Equal(color.RGBA{255, 255, 255, 255}, bg, "requested background", t)
+	assert.True(ih.hintsOverridden(req), "requested background is an override", t)
+
+	ih.Background = color.RGBA{255, 255, 255, 255}
+	assert.False(ih.hintsOverridden(req), "requesting the default background isn't an override", t)
+
+	req, _ = http.NewRequest("GET", "/iiif/a.png/full/100,/0/default.jpg?background=white", nil)
+	_, err = ih.requestBackground(req)
+	assert.True(err != nil, "invalid background parameter", t)
+}
+
+func TestRequestLayers(t *testing.T) {
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	ih.Layers = img.LayerPolicy{Layers: 2, MaxSize: 256}
+
+	var req, _ = http.NewRequest("GET", "/iiif/a.jp2/full/100,/0/default.jpg", nil)
+	var l, err = ih.requestLayers(req)
+	assert.NilError(err, "no layers parameter", t)
+	assert.Equal(ih.Layers, l, "default policy", t)
+	assert.False(ih.hintsOverridden(req), "default policy isn't an override", t)
+
+	req, _ = http.NewRequest("GET", "/iiif/a.jp2/full/100,/0/default.jpg?layers=0", nil)
+	l, err = ih.requestLayers(req)
+	assert.NilError(err, "valid layers parameter", t)
+	assert.Equal(img.LayerPolicy{}, l, "all layers", t)
+	assert.True(ih.hintsOverridden(req), "requested layers are an override", t)
+
+	req, _ = http.NewRequest("GET", "/iiif/a.jp2/full/100,/0/default.jpg?layers=-1", nil)
+	_, err = ih.requestLayers(req)
+	assert.True(err != nil, "negative layers", t)
+	assert.True(ih.hintsOverridden(req), "invalid layers are never cached", t)
+}
+
+func TestCanonicalLink(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.FeatureSet = iiif.FeatureSet2()
+	h.BaseURL, _ = url.Parse("http://example.com")
+	var base = "http://example.com/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+	var path = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/pct:50/0/native.jpg"
+
+	var w = httptest.NewRecorder()
+	h.IIIFRoute(w, httptest.NewRequest("GET", path, nil))
+	assert.Equal(`<`+base+`/full/400,/0/default.jpg>;rel="canonical"`, w.Header().Get("Link"), "image responses link to the canonical URI", t)
+
+	h.CanonicalRedirect = true
+	w = httptest.NewRecorder()
+	h.IIIFRoute(w, httptest.NewRequest("GET", path+"?filter=lanczos", nil))
+	assert.Equal(http.StatusMovedPermanently, w.Code, "non-canonical requests are redirected", t)
+	assert.Equal("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/400,/0/default.jpg?filter=lanczos",
+		w.Header().Get("Location"), "redirect keeps the query", t)
+
+	// Forwarding headers can't send clients to another host
+	h.BaseURL = nil
+	var req = httptest.NewRequest("GET", path, nil)
+	req.Header.Set("X-Forwarded-Host", "evil.example.com")
+	w = httptest.NewRecorder()
+	h.IIIFRoute(w, req)
+	assert.Equal(http.StatusMovedPermanently, w.Code, "redirected without a base URL", t)
+	assert.Equal("/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/400,/0/default.jpg",
+		w.Header().Get("Location"), "redirects are host-relative", t)
+
+	// Nor can they choose the path unless they're an explicitly trusted proxy
+	defer func() { trustedProxies = nil }()
+	var canonical = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/400,/0/default.jpg"
+	var redirect = func(prefix string) string {
+		var req = httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "10.0.0.5:34567"
+		req.Header.Set("X-Forwarded-Prefix", prefix)
+		var w = httptest.NewRecorder()
+		h.IIIFRoute(w, req)
+		return w.Header().Get("Location")
+	}
+	assert.Equal(canonical, redirect("/images"), "forwarded prefixes are ignored without TrustedProxies", t)
+	trustedProxies, _ = parseTrustedProxies("10.0.0.0/24")
+	assert.Equal("/images"+canonical, redirect("/images"), "trusted proxies' prefixes are used", t)
+	assert.Equal(canonical, redirect(`\evil.com`), "backslashes are rejected", t)
+	assert.Equal(canonical, redirect("//evil.com"), "leading double slashes are rejected", t)
+	trustedProxies = nil
+	h.BaseURL, _ = url.Parse("http://example.com")
+
+	w = httptest.NewRecorder()
+	h.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/400,/0/default.jpg", nil))
+	assert.True(w.Code != http.StatusMovedPermanently, "canonical requests aren't redirected", t)
+}
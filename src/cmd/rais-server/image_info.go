@@ -6,4 +6,5 @@ type ImageInfo struct {
 	Width, Height         int
 	TileWidth, TileHeight int
 	Levels                int
+	Georeferenced         bool
 }
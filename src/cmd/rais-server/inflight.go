@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"rais/src/cmd/rais-server/internal/statusrecorder"
+	"sort"
+	"sync"
+	"time"
+)
+
+// liveRequest is the state of a request which is still being served.  Only
+// the access log entry's fields which are set before the request is handed
+// off are read, since the rest are written by the handler.
+type liveRequest struct {
+	entry *accessLogEntry
+	rec   *statusrecorder.StatusRecorder
+
+	m      sync.Mutex
+	stages []string
+}
+
+// enter marks the start of a step in serving the request
+func (lr *liveRequest) enter(stage string) {
+	if lr == nil {
+		return
+	}
+	lr.m.Lock()
+	lr.stages = append(lr.stages, stage)
+	lr.m.Unlock()
+}
+
+// leave marks the end of a step.  Steps usually finish in the reverse order
+// they started, but we don't count on it.
+func (lr *liveRequest) leave(stage string) {
+	if lr == nil {
+		return
+	}
+	lr.m.Lock()
+	defer lr.m.Unlock()
+	for i := len(lr.stages) - 1; i >= 0; i-- {
+		if lr.stages[i] == stage {
+			lr.stages = append(lr.stages[:i], lr.stages[i+1:]...)
+			return
+		}
+	}
+}
+
+// stage returns the innermost step the request is in, if any
+func (lr *liveRequest) stage() string {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+	if len(lr.stages) == 0 {
+		return ""
+	}
+	return lr.stages[len(lr.stages)-1]
+}
+
+// liveRequests tracks every request currently being served
+type liveRequests struct {
+	m    sync.Mutex
+	reqs map[*liveRequest]bool
+}
+
+var inflight = &liveRequests{reqs: make(map[*liveRequest]bool)}
+
+// add starts tracking the request for e, returning a function to call when
+// the request is finished
+func (lr *liveRequests) add(e *accessLogEntry, rec *statusrecorder.StatusRecorder) func() {
+	var r = &liveRequest{entry: e, rec: rec}
+	e.live = r
+
+	lr.m.Lock()
+	lr.reqs[r] = true
+	lr.m.Unlock()
+
+	return func() {
+		lr.m.Lock()
+		delete(lr.reqs, r)
+		lr.m.Unlock()
+	}
+}
+
+// inflightRequest is the JSON structure for a single request in the admin
+// endpoint's list
+type inflightRequest struct {
+	RequestID  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Start      time.Time `json:"start"`
+	Elapsed    float64   `json:"elapsed"`
+	Stage      string    `json:"stage"`
+	Bytes      int64     `json:"bytes"`
+}
+
+// list returns the requests in flight, longest-running first
+func (lr *liveRequests) list() []*inflightRequest {
+	lr.m.Lock()
+	var reqs = make([]*liveRequest, 0, len(lr.reqs))
+	for r := range lr.reqs {
+		reqs = append(reqs, r)
+	}
+	lr.m.Unlock()
+
+	var now = time.Now()
+	var list = make([]*inflightRequest, len(reqs))
+	for i, r := range reqs {
+		var e = r.entry
+		list[i] = &inflightRequest{
+			RequestID:  e.RequestID,
+			RemoteAddr: e.RemoteAddr,
+			Method:     e.Method,
+			Path:       e.Path,
+			Start:      e.Time,
+			Elapsed:    now.Sub(e.Time).Seconds(),
+			Stage:      r.stage(),
+			Bytes:      r.rec.Written(),
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list
+}
+
+func (lr *liveRequests) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var data, err = json.Marshal(lr.list())
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
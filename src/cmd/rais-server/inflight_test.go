@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func findInflight(id string) *inflightRequest {
+	for _, r := range inflight.list() {
+		if r.RequestID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func TestInflight(t *testing.T) {
+	var seen *inflightRequest
+	var h = accessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var _, endResolve = startSpan(req.Context(), "plugin.resolve_id")
+		var _, endDecode = startSpan(req.Context(), "image.decode")
+		endDecode()
+		w.Write([]byte("partial"))
+		seen = findInflight(requestID(req))
+		endResolve()
+	}))
+
+	var req = httptest.NewRequest("GET", "/iiif/foo.jp2/full/max/0/default.jpg", nil)
+	req.Header.Set("X-Request-ID", "inflight-test")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(seen != nil, "request is listed while it's being served", t)
+	assert.Equal("/iiif/foo.jp2/full/max/0/default.jpg", seen.Path, "path", t)
+	assert.Equal("plugin.resolve_id", seen.Stage, "finished stages are removed", t)
+	assert.Equal(int64(7), seen.Bytes, "bytes written so far", t)
+	assert.True(findInflight("inflight-test") == nil, "finished requests are removed", t)
+}
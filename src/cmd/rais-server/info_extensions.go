@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"rais/src/iiif"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// infoProperties holds the info.json properties an InfoExtension may set
+type infoProperties struct {
+	Attribution string        `json:"attribution"`
+	License     string        `json:"license"`
+	Logo        interface{}   `json:"logo"`
+	SeeAlso     []interface{} `json:"seeAlso"`
+	Service     []interface{} `json:"service"`
+}
+
+// InfoExtension is a static block of descriptive properties, such as rights
+// and attribution, added to the info.json of every image whose ID starts with
+// Prefix.  An empty prefix matches all images.
+type InfoExtension struct {
+	Prefix string
+	props  infoProperties
+}
+
+// loadInfoExtensions reads a TOML file with a list of prefixes and the JSON
+// each prefix's images get in their info.json:
+//
+//	[[Extension]]
+//	Prefix = "maps:"
+//	JSON = '''{"attribution": "Provided by the Map Library"}'''
+//
+// Only the attribution, license, logo, seeAlso, and service properties may
+// be set.  Extensions are sorted with the shortest prefixes first so that
+// applying them in order lets more specific extensions win.
+func loadInfoExtensions(fname string) ([]*InfoExtension, error) {
+	var data struct {
+		Extension []struct {
+			Prefix string
+			JSON   string
+		}
+	}
+	var _, err = toml.DecodeFile(fname, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	var exts []*InfoExtension
+	for _, e := range data.Extension {
+		var ext = &InfoExtension{Prefix: e.Prefix}
+		var dec = json.NewDecoder(strings.NewReader(e.JSON))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&ext.props)
+		if err != nil {
+			return nil, fmt.Errorf("prefix %q: invalid JSON: %s", e.Prefix, err)
+		}
+		exts = append(exts, ext)
+	}
+	sort.SliceStable(exts, func(i, j int) bool { return len(exts[i].Prefix) < len(exts[j].Prefix) })
+
+	return exts, nil
+}
+
+// extendInfo adds the properties from all extensions matching id to info.
+// Attribution, license, and logo come from the most specific extension
+// which sets them, but an info.json override file's own values are kept.
+// seeAlso and service entries from every matching extension are added.
+func (ih *ImageHandler) extendInfo(id iiif.ID, info *iiif.Info) {
+	id = aliases.resolve(id)
+	var p infoProperties
+	for _, ext := range ih.InfoExtensions {
+		if !strings.HasPrefix(string(id), ext.Prefix) {
+			continue
+		}
+		if ext.props.Attribution != "" {
+			p.Attribution = ext.props.Attribution
+		}
+		if ext.props.License != "" {
+			p.License = ext.props.License
+		}
+		if ext.props.Logo != nil {
+			p.Logo = ext.props.Logo
+		}
+		p.SeeAlso = append(p.SeeAlso, ext.props.SeeAlso...)
+		p.Service = append(p.Service, ext.props.Service...)
+	}
+
+	if info.Attribution == "" {
+		info.Attribution = p.Attribution
+	}
+	if info.License == "" {
+		info.License = p.License
+	}
+	if info.Logo == nil {
+		info.Logo = p.Logo
+	}
+	info.SeeAlso = append(info.SeeAlso, p.SeeAlso...)
+	info.Service = append(info.Service, p.Service...)
+}
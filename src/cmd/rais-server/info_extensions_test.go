@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestInfoExtensions(t *testing.T) {
+	var f, err = ioutil.TempFile("", "rais-info-extensions")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[[Extension]]
+Prefix = "maps:"
+JSON = '{"license": "http://example.com/pd", "seeAlso": ["http://example.com/maps.json"]}'
+
+[[Extension]]
+Prefix = ""
+JSON = '{"attribution": "Provided by us", "license": "http://example.com/inc", "seeAlso": ["http://example.com/all.json"]}'
+`)
+	f.Close()
+
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	ih.InfoExtensions, err = loadInfoExtensions(f.Name())
+	assert.NilError(err, "loading extensions", t)
+
+	var info = &iiif.Info{}
+	ih.extendInfo("maps:a.jp2", info)
+	assert.Equal("Provided by us", info.Attribution, "global attribution", t)
+	assert.Equal("http://example.com/pd", info.License, "the longest prefix wins", t)
+	assert.Equal(2, len(info.SeeAlso), "seeAlso from all matching extensions", t)
+
+	info = &iiif.Info{License: "http://example.com/override"}
+	ih.extendInfo("other.jp2", info)
+	assert.Equal("http://example.com/override", info.License, "existing values are kept", t)
+	assert.Equal(1, len(info.SeeAlso), "only matching extensions apply", t)
+
+	f, _ = ioutil.TempFile("", "rais-info-extensions")
+	defer os.Remove(f.Name())
+	f.WriteString("[[Extension]]\nJSON = '{\"width\": 5}'\n")
+	f.Close()
+	_, err = loadInfoExtensions(f.Name())
+	assert.True(err != nil, "only descriptive properties may be set", t)
+}
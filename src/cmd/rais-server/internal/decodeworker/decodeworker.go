@@ -0,0 +1,173 @@
+// Package decodeworker runs image decodes in separate worker processes, so a
+// crash in a C library, like a segfault in openjpeg or ImageMagick on a
+// damaged file, kills one worker rather than the whole server.
+//
+// Workers are the RAIS binary itself, started with EnvWorker set.  Each
+// worker gets one end of a socket pair as file descriptor 3, and answers
+// net/rpc calls (gob-encoded) on it.  A worker handles a single call at a
+// time, so when one dies, the call it was running is the only one affected,
+// and the image responsible is known.  Workers write log messages to stderr
+// in the format FormatLog produces, and the pool passes them on.
+package decodeworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"rais/src/img"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// EnvWorker is set in a worker's environment to tell RAIS to run as a decode
+// worker rather than a server
+const EnvWorker = "RAIS_DECODE_WORKER"
+
+// EnvSandbox is set in a worker's environment to have it sandbox itself as
+// it starts.  Its value lists the paths the worker may read, separated by
+// colons.
+const EnvSandbox = "RAIS_DECODE_WORKER_SANDBOX"
+
+// workerFD is the descriptor a worker finds its end of the socket pair on
+const workerFD = 3
+
+// ErrCrashed is returned when a worker dies or has to be killed while
+// handling a call
+var ErrCrashed = errors.New("decode worker crashed")
+
+// OpenArgs asks a worker to read an image's headers
+type OpenArgs struct {
+	ID   string
+	Path string
+}
+
+// OpenReply describes an image, along with the optional features its decoder
+// supports
+type OpenReply struct {
+	Width, Height         int
+	TileWidth, TileHeight int
+	Levels                int
+	Reduces               bool
+}
+
+// DecodeArgs asks a worker to decode an image.  Everything the decoder needs
+// is sent with each call, so workers keep no state between calls.  Deadline
+// is zero if the request has no deadline.
+type DecodeArgs struct {
+	OpenArgs
+	Crop      image.Rectangle
+	Width     int
+	Height    int
+	Filter    string
+	Layers    int
+	Reduction int
+	Deadline  time.Time
+	RequestID string
+}
+
+// Image carries decoded pixels.  Kind names the image type, and the other
+// fields are copied from it.
+type Image struct {
+	Kind   string
+	Rect   image.Rectangle
+	Stride int
+	Pix    []byte
+}
+
+// newImage copies i's pixels for sending to the server.  Image types without
+// a simple pixel buffer are converted to RGBA.
+func newImage(i image.Image) *Image {
+	switch i := i.(type) {
+	case *image.Gray:
+		return &Image{Kind: "gray", Rect: i.Rect, Stride: i.Stride, Pix: i.Pix}
+	case *image.Gray16:
+		return &Image{Kind: "gray16", Rect: i.Rect, Stride: i.Stride, Pix: i.Pix}
+	case *image.RGBA:
+		return &Image{Kind: "rgba", Rect: i.Rect, Stride: i.Stride, Pix: i.Pix}
+	case *image.RGBA64:
+		return &Image{Kind: "rgba64", Rect: i.Rect, Stride: i.Stride, Pix: i.Pix}
+	case *image.NRGBA:
+		return &Image{Kind: "nrgba", Rect: i.Rect, Stride: i.Stride, Pix: i.Pix}
+	case *image.NRGBA64:
+		return &Image{Kind: "nrgba64", Rect: i.Rect, Stride: i.Stride, Pix: i.Pix}
+	case *image.CMYK:
+		return &Image{Kind: "cmyk", Rect: i.Rect, Stride: i.Stride, Pix: i.Pix}
+	}
+
+	var b = i.Bounds()
+	var rgba = image.NewRGBA(b)
+	draw.Draw(rgba, b, i, b.Min, draw.Src)
+	return newImage(rgba)
+}
+
+// image returns the decoded image
+func (i *Image) image() (image.Image, error) {
+	switch i.Kind {
+	case "gray":
+		return &image.Gray{Pix: i.Pix, Stride: i.Stride, Rect: i.Rect}, nil
+	case "gray16":
+		return &image.Gray16{Pix: i.Pix, Stride: i.Stride, Rect: i.Rect}, nil
+	case "rgba":
+		return &image.RGBA{Pix: i.Pix, Stride: i.Stride, Rect: i.Rect}, nil
+	case "rgba64":
+		return &image.RGBA64{Pix: i.Pix, Stride: i.Stride, Rect: i.Rect}, nil
+	case "nrgba":
+		return &image.NRGBA{Pix: i.Pix, Stride: i.Stride, Rect: i.Rect}, nil
+	case "nrgba64":
+		return &image.NRGBA64{Pix: i.Pix, Stride: i.Stride, Rect: i.Rect}, nil
+	case "cmyk":
+		return &image.CMYK{Pix: i.Pix, Stride: i.Stride, Rect: i.Rect}, nil
+	}
+	return nil, fmt.Errorf("unknown image type %q", i.Kind)
+}
+
+// knownErrors are the errors a worker may return which callers check for.
+// RPC errors are only strings, so these are matched by their text.
+var knownErrors = []error{
+	context.Canceled,
+	context.DeadlineExceeded,
+	img.ErrDoesNotExist,
+	img.ErrInvalidFiletype,
+	img.ErrNotHandled,
+}
+
+// remoteError turns an error message from a worker back into the error the
+// worker returned, if it's one callers check for
+func remoteError(msg string) error {
+	for _, err := range knownErrors {
+		if msg == err.Error() {
+			return err
+		}
+	}
+	return errors.New(msg)
+}
+
+// FormatLog returns a worker's log message as a single line for the pool to
+// read from the worker's stderr
+func FormatLog(level logger.LogLevel, component, message string) string {
+	return fmt.Sprintf("%d\t%s\t%s\n", level, component, strconv.Quote(message))
+}
+
+// parseLog reads a line written by FormatLog.  Anything else a worker writes
+// to stderr, such as a C library's complaints, isn't in the right format, and
+// ok is false.
+func parseLog(line string) (level logger.LogLevel, component, message string, ok bool) {
+	var parts = strings.SplitN(line, "\t", 3)
+	if len(parts) != 3 {
+		return 0, "", "", false
+	}
+	var n, err = strconv.Atoi(parts[0])
+	if err != nil || logger.LogLevel(n).String() == "" {
+		return 0, "", "", false
+	}
+	message, err = strconv.Unquote(parts[2])
+	if err != nil {
+		return 0, "", "", false
+	}
+	return logger.LogLevel(n), parts[1], message, true
+}
@@ -0,0 +1,322 @@
+package decodeworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"net"
+	"os"
+	"path/filepath"
+	"rais/src/img"
+	"rais/src/requestid"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// fakeDecoder is a 100x100 gray image which behaves according to its file
+// name: "crash" kills the worker, "hang" ignores its deadline, "slow" takes
+// a second unless its context is canceled, "sandbox" reports what the
+// sandbox allows, and anything else decodes to the resize dimensions, with
+// every pixel set to the reduction level
+type fakeDecoder struct {
+	path      string
+	name      string
+	w, h      int
+	reduction int
+	ctx       context.Context
+}
+
+func fakeDecode(path string) (img.Decoder, error) {
+	if filepath.Ext(path) != ".fake" {
+		return nil, img.ErrNotHandled
+	}
+	var name = strings.TrimSuffix(filepath.Base(path), ".fake")
+	return &fakeDecoder{path: path, name: name, ctx: context.Background()}, nil
+}
+
+func (d *fakeDecoder) DecodeImage() (image.Image, error) {
+	os.Stderr.WriteString(FormatLog(logger.Info, "fake", requestid.FromContext(d.ctx)+" decoding "+d.name))
+	switch d.name {
+	case "crash":
+		os.Exit(2)
+	case "hang":
+		time.Sleep(time.Minute)
+	case "slow":
+		select {
+		case <-time.After(time.Second):
+		case <-d.ctx.Done():
+			return nil, d.ctx.Err()
+		}
+	case "missing":
+		return nil, img.ErrDoesNotExist
+	case "sandbox":
+		return nil, errors.New(sandboxReport(d.path))
+	}
+
+	var i = image.NewGray(image.Rect(0, 0, d.w, d.h))
+	for n := range i.Pix {
+		i.Pix[n] = uint8(d.reduction)
+	}
+	return i, nil
+}
+
+// sandboxReport tries everything a sandboxed worker should and shouldn't be
+// able to do, and describes the results
+func sandboxReport(path string) string {
+	var status, err = SandboxStatus()
+	var report = []string{fmt.Sprintf("status: %s (%v)", status, err)}
+	var try = func(what string, err error) {
+		report = append(report, fmt.Sprintf("%s: %v", what, err))
+	}
+
+	var dir = filepath.Dir(path)
+	_, err = os.ReadFile(filepath.Join(dir, "allowed"))
+	try("read allowed", err)
+	_, err = os.ReadFile(os.Getenv("DECODEWORKER_TEST_SECRET"))
+	try("read secret", err)
+	try("write", os.WriteFile(filepath.Join(dir, "new"), []byte("x"), 0644))
+	try("remove", os.Remove(filepath.Join(dir, "allowed")))
+	try("exec", syscall.Exec("/bin/true", nil, nil))
+	var conn net.Conn
+	conn, err = net.Dial("tcp", "127.0.0.1:1")
+	if conn != nil {
+		conn.Close()
+	}
+	try("dial", err)
+	return strings.Join(report, "\n")
+}
+
+func (d *fakeDecoder) GetWidth() int                  { return 100 }
+func (d *fakeDecoder) GetHeight() int                 { return 100 }
+func (d *fakeDecoder) GetTileWidth() int              { return 0 }
+func (d *fakeDecoder) GetTileHeight() int             { return 0 }
+func (d *fakeDecoder) GetLevels() int                 { return 3 }
+func (d *fakeDecoder) SetCrop(image.Rectangle)        {}
+func (d *fakeDecoder) SetResizeWH(w, h int)           { d.w, d.h = w, h }
+func (d *fakeDecoder) SetReduction(level int)         { d.reduction = level }
+func (d *fakeDecoder) SetContext(ctx context.Context) { d.ctx = ctx }
+
+// TestMain runs the test binary as a worker when the pool starts it
+func TestMain(m *testing.M) {
+	img.RegisterDecoder(fakeDecode)
+	if os.Getenv(EnvWorker) != "" {
+		var err = Serve()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testLog collects the messages workers send
+type testLog struct {
+	m        sync.Mutex
+	messages []string
+}
+
+func (tl *testLog) log(level logger.LogLevel, component, message string) {
+	tl.m.Lock()
+	defer tl.m.Unlock()
+	tl.messages = append(tl.messages, level.String()+" "+component+" "+message)
+}
+
+func (tl *testLog) has(s string) bool {
+	tl.m.Lock()
+	defer tl.m.Unlock()
+	for _, msg := range tl.messages {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func startPool(t *testing.T, n int) (*Pool, *testLog) {
+	var tl = &testLog{}
+	var p, err = Start(n, nil, nil, tl.log)
+	if err != nil {
+		t.Fatalf("Unable to start pool: %s", err)
+	}
+	return p, tl
+}
+
+func decodeFile(p *Pool, ctx context.Context, name string) (image.Image, error) {
+	var d, err = p.Open(ctx, "id", "/images/"+name+".fake")
+	if err != nil {
+		return nil, err
+	}
+	d.SetResizeWH(10, 5)
+	return d.DecodeImage()
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	var pal = image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	pal.SetColorIndex(1, 1, 1)
+
+	var i, err = newImage(pal).image()
+	assert.NilError(err, "converting image", t)
+	var rgba, ok = i.(*image.RGBA)
+	assert.True(ok, "paletted images become RGBA", t)
+	assert.Equal(color.RGBA{255, 255, 255, 255}, rgba.RGBAAt(1, 1), "white pixel", t)
+	assert.Equal(color.RGBA{0, 0, 0, 255}, rgba.RGBAAt(0, 1), "black pixel", t)
+
+	var gray = image.NewGray(image.Rect(5, 5, 8, 9))
+	i, err = newImage(gray).image()
+	assert.NilError(err, "converting image", t)
+	assert.Equal(gray.Rect, i.Bounds(), "bounds are kept", t)
+
+	_, err = (&Image{Kind: "bogus"}).image()
+	assert.True(err != nil, "unknown kinds are an error", t)
+}
+
+func TestParseLog(t *testing.T) {
+	var line = FormatLog(logger.Warn, "openjpeg", "two\nlines\twith a tab")
+	assert.Equal(1, strings.Count(line, "\n"), "one line", t)
+
+	var level, component, message, ok = parseLog(strings.TrimSuffix(line, "\n"))
+	assert.True(ok, "parsed", t)
+	assert.Equal(logger.Warn, level, "level", t)
+	assert.Equal("openjpeg", component, "component", t)
+	assert.Equal("two\nlines\twith a tab", message, "message", t)
+
+	_, _, _, ok = parseLog("[ERROR] libjpeg is unhappy")
+	assert.False(ok, "other output isn't a log message", t)
+	_, _, _, ok = parseLog("99\tserver\t\"bad level\"")
+	assert.False(ok, "unknown levels aren't a log message", t)
+}
+
+func TestDecode(t *testing.T) {
+	var p, tl = startPool(t, 2)
+	defer p.Close()
+
+	var ctx = requestid.NewContext(context.Background(), "req-1")
+	var d, err = p.Open(ctx, "id", "/images/ok.fake")
+	assert.NilError(err, "opening image", t)
+	assert.Equal(100, d.GetWidth(), "width", t)
+	assert.Equal(3, d.GetLevels(), "levels", t)
+	var rs, ok = d.(img.ReductionSetter)
+	assert.True(ok, "decoder reduces since the worker's does", t)
+	rs.SetReduction(2)
+	d.SetResizeWH(10, 5)
+
+	var i image.Image
+	i, err = d.DecodeImage()
+	assert.NilError(err, "decoding image", t)
+	assert.Equal(image.Rect(0, 0, 10, 5), i.Bounds(), "size", t)
+	assert.Equal(color.Gray{2}, i.(*image.Gray).GrayAt(3, 3), "reduction was sent", t)
+
+	_, err = decodeFile(p, context.Background(), "missing")
+	assert.Equal(img.ErrDoesNotExist, err, "known errors come back as themselves", t)
+	_, err = p.Open(context.Background(), "id", "/images/ok.tif")
+	assert.True(err != nil && strings.Contains(err.Error(), "no such file"), "open errors come back too", t)
+
+	for i := 0; i < 50 && !tl.has("req-1 decoding ok"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(tl.has("INFO fake req-1 decoding ok"), "worker logs are passed on with the request id", t)
+}
+
+func TestCrash(t *testing.T) {
+	var p, tl = startPool(t, 1)
+	defer p.Close()
+
+	var _, err = decodeFile(p, context.Background(), "crash")
+	assert.True(errors.Is(err, ErrCrashed), "crash is reported", t)
+	assert.True(tl.has("exited (exit status 2)"), "exit is logged", t)
+
+	_, err = decodeFile(p, context.Background(), "ok")
+	assert.NilError(err, "a new worker replaces the dead one", t)
+}
+
+func TestDeadline(t *testing.T) {
+	var p, _ = startPool(t, 1)
+	defer p.Close()
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var start = time.Now()
+	var _, err = decodeFile(p, ctx, "slow")
+	assert.Equal(context.DeadlineExceeded, err, "deadline is reported", t)
+	assert.True(time.Since(start) < 500*time.Millisecond, "decode gives up at the deadline", t)
+
+	_, err = decodeFile(p, context.Background(), "ok")
+	assert.NilError(err, "worker is reused", t)
+}
+
+func TestKillStuckWorker(t *testing.T) {
+	defer func(d time.Duration) { killAfter = d }(killAfter)
+	killAfter = 100 * time.Millisecond
+
+	var p, tl = startPool(t, 1)
+	defer p.Close()
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var _, err = decodeFile(p, ctx, "hang")
+	assert.Equal(context.DeadlineExceeded, err, "deadline is reported", t)
+
+	_, err = decodeFile(p, context.Background(), "ok")
+	assert.NilError(err, "a new worker replaces the stuck one", t)
+	assert.True(tl.has("Killing worker"), "kill is logged", t)
+}
+
+func TestSandbox(t *testing.T) {
+	var allowed, secret = t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(allowed, "allowed"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(secret, "secret"), []byte("x"), 0644)
+	os.Setenv("DECODEWORKER_TEST_SECRET", filepath.Join(secret, "secret"))
+	defer os.Unsetenv("DECODEWORKER_TEST_SECRET")
+
+	var tl = &testLog{}
+	var p, err = Start(1, nil, []string{allowed}, tl.log)
+	if err != nil && strings.Contains(err.Error(), "landlock unavailable") {
+		t.Skipf("Landlock isn't available: %s", err)
+	}
+	assert.NilError(err, "starting pool", t)
+	defer p.Close()
+
+	var d img.Decoder
+	d, err = p.Open(context.Background(), "id", filepath.Join(allowed, "sandbox.fake"))
+	assert.NilError(err, "opening image", t)
+	_, err = d.DecodeImage()
+	var report = err.Error()
+	if !strings.Contains(report, "landlock ABI") {
+		t.Skipf("Landlock isn't available: %s", report)
+	}
+
+	var results = make(map[string]string)
+	for _, line := range strings.Split(report, "\n") {
+		var parts = strings.SplitN(line, ": ", 2)
+		results[parts[0]] = parts[1]
+	}
+	assert.True(strings.HasPrefix(results["status"], "landlock ABI"), "status: "+results["status"], t)
+	assert.True(strings.Contains(results["status"], "seccomp (<nil>)"), "status: "+results["status"], t)
+	assert.Equal("<nil>", results["read allowed"], "allowed paths can be read", t)
+	assert.True(strings.Contains(results["read secret"], "permission denied"), "read secret: "+results["read secret"], t)
+	assert.True(strings.Contains(results["write"], "permission denied"), "write: "+results["write"], t)
+	assert.True(strings.Contains(results["remove"], "operation not permitted"), "remove: "+results["remove"], t)
+	assert.True(strings.Contains(results["exec"], "operation not permitted"), "exec: "+results["exec"], t)
+	assert.True(strings.Contains(results["dial"], "operation not permitted"), "dial: "+results["dial"], t)
+}
+
+func TestSandboxIncomplete(t *testing.T) {
+	// Paths which can't be opened, other than missing ones, leave the sandbox
+	// incomplete
+	var tl = &testLog{}
+	var p, err = Start(1, nil, []string{"/etc/passwd/nope"}, tl.log)
+	if p != nil {
+		p.Close()
+	}
+	assert.True(err != nil, "workers with an incomplete sandbox aren't used", t)
+	assert.True(strings.Contains(err.Error(), "unable to sandbox"), "error: "+err.Error(), t)
+}
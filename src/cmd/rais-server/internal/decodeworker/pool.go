@@ -0,0 +1,372 @@
+package decodeworker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/requestid"
+	"rais/src/transform"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// killAfter is how long a worker may keep working on a call after the
+// request it was for has ended.  Workers stop decoding when a request's
+// deadline passes, so one which is still busy this long after is assumed to
+// be stuck in C code, and is killed.
+var killAfter = 10 * time.Second
+
+// restartDelay is how long the pool waits before trying again when a
+// replacement worker fails to start
+var restartDelay = time.Second
+
+// errClosed is returned when a worker is started after the pool is closed
+var errClosed = errors.New("decode worker pool is closed")
+
+// LogFunc receives the log messages workers send, and anything else they
+// write to stderr, which is logged as a warning from the "decode-worker"
+// component
+type LogFunc func(level logger.LogLevel, component, message string)
+
+// Pool is a set of worker processes.  Workers which die are replaced.
+type Pool struct {
+	args    []string
+	sandbox []string
+	log     LogFunc
+	idle    chan *worker
+
+	m       sync.Mutex
+	closed  bool
+	workers map[*worker]bool
+}
+
+type worker struct {
+	cmd    *exec.Cmd
+	client *rpc.Client
+	done   chan struct{}
+	err    error
+}
+
+// Start runs n workers.  Each is the current executable, run with args and
+// EnvWorker set.  Workers are started with the same arguments as the server
+// so that they read the same configuration and load the same decoders.
+//
+// If sandbox isn't nil, workers are sandboxed as they start: they can only
+// read the paths it lists, and can't write files, run programs, or use the
+// network.  A worker whose sandbox couldn't be fully applied is killed
+// rather than used, so Start fails if the sandbox isn't supported here.  See
+// SandboxStatus.
+func Start(n int, args []string, sandbox []string, log LogFunc) (*Pool, error) {
+	var p = &Pool{
+		args:    args,
+		sandbox: sandbox,
+		log:     log,
+		idle:    make(chan *worker, n),
+		workers: make(map[*worker]bool),
+	}
+	for i := 0; i < n; i++ {
+		var w, err = p.start()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.idle <- w
+	}
+	return p, nil
+}
+
+// start runs a new worker
+func (p *Pool) start() (*worker, error) {
+	var exe, err = os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	var local, remote *os.File
+	local, remote, err = socketPair()
+	if err != nil {
+		return nil, err
+	}
+	defer remote.Close()
+
+	var cmd = exec.Command(exe, p.args...)
+	cmd.Env = append(os.Environ(), EnvWorker+"=1")
+	if p.sandbox != nil {
+		cmd.Env = append(cmd.Env, EnvSandbox+"="+strings.Join(p.sandbox, ":"))
+	}
+	cmd.ExtraFiles = []*os.File{remote}
+	var stderr io.ReadCloser
+	stderr, err = cmd.StderrPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		local.Close()
+		return nil, err
+	}
+
+	var conn net.Conn
+	conn, err = net.FileConn(local)
+	local.Close()
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	var w = &worker{cmd: cmd, client: rpc.NewClient(conn), done: make(chan struct{})}
+	go func() {
+		p.readLog(stderr, cmd.Process.Pid)
+		w.err = cmd.Wait()
+		w.client.Close()
+		close(w.done)
+	}()
+
+	if p.sandbox != nil {
+		var status string
+		err = w.client.Call("Worker.Sandbox", 0, &status)
+		if err != nil {
+			w.cmd.Process.Kill()
+			<-w.done
+			return nil, fmt.Errorf("unable to sandbox worker %d: %s", cmd.Process.Pid, err)
+		}
+	}
+
+	// A replacement may finish starting after the pool is closed
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.closed {
+		w.client.Close()
+		return nil, errClosed
+	}
+	p.workers[w] = true
+	return w, nil
+}
+
+// readLog passes along everything the worker writes to stderr until it exits
+func (p *Pool) readLog(r io.Reader, pid int) {
+	var s = bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		var level, component, message, ok = parseLog(s.Text())
+		if !ok {
+			level, component, message = logger.Warn, "decode-worker", fmt.Sprintf("worker %d: %s", pid, s.Text())
+		}
+		p.log(level, component, message)
+	}
+}
+
+// call runs method on an idle worker.  If ctx is done first, ctx's error is
+// returned right away, and the worker is returned to the pool once it
+// finishes, or killed if it takes too long.
+func (p *Pool) call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	var w, err = p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	var c = w.client.Go("Worker."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-c.Done:
+		return p.release(w, c.Error)
+	case <-ctx.Done():
+	}
+
+	go func() {
+		select {
+		case <-c.Done:
+			p.release(w, c.Error)
+		case <-time.After(killAfter):
+			p.log(logger.Warn, "decode-worker", fmt.Sprintf("Killing worker %d: still busy %s after its request ended",
+				w.cmd.Process.Pid, killAfter))
+			w.cmd.Process.Kill()
+			<-c.Done
+			p.release(w, c.Error)
+		}
+	}()
+	return ctx.Err()
+}
+
+// acquire waits for an idle worker.  Workers which died while idle are
+// replaced rather than handed out.
+func (p *Pool) acquire(ctx context.Context) (*worker, error) {
+	for {
+		select {
+		case w := <-p.idle:
+			select {
+			case <-w.done:
+				p.replace(w)
+				continue
+			default:
+			}
+			return w, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release returns w to the pool after a call, translating the call's error.
+// Errors the worker returned are passed on; anything else means the worker
+// died or its connection broke, so it's replaced and ErrCrashed is returned.
+func (p *Pool) release(w *worker, err error) error {
+	if err == nil {
+		p.idle <- w
+		return nil
+	}
+	if se, ok := err.(rpc.ServerError); ok {
+		p.idle <- w
+		return remoteError(string(se))
+	}
+
+	p.replace(w)
+	return ErrCrashed
+}
+
+// replace makes sure w is gone, and starts a new worker in its place
+func (p *Pool) replace(w *worker) {
+	w.cmd.Process.Kill()
+	<-w.done
+
+	p.m.Lock()
+	delete(p.workers, w)
+	var closed = p.closed
+	p.m.Unlock()
+	if closed {
+		return
+	}
+
+	p.log(logger.Err, "decode-worker", fmt.Sprintf("Worker %d exited (%v); starting a new one", w.cmd.Process.Pid, w.err))
+	go func() {
+		for {
+			var nw, err = p.start()
+			if err == nil {
+				p.idle <- nw
+				return
+			}
+			if err == errClosed {
+				return
+			}
+			p.log(logger.Err, "decode-worker", fmt.Sprintf("Unable to start a decode worker: %s", err))
+			time.Sleep(restartDelay)
+		}
+	}()
+}
+
+// Close shuts down all workers.  Workers exit when their connection closes;
+// any still running after a few seconds are killed.
+func (p *Pool) Close() {
+	p.m.Lock()
+	p.closed = true
+	var workers []*worker
+	for w := range p.workers {
+		workers = append(workers, w)
+	}
+	p.m.Unlock()
+
+	for _, w := range workers {
+		w.client.Close()
+		select {
+		case <-w.done:
+		case <-time.After(5 * time.Second):
+			w.cmd.Process.Kill()
+			<-w.done
+		}
+	}
+}
+
+// Open has a worker read the image's headers, and returns a decoder which
+// sends the decode to a worker, too.  It's meant to be used as img.Opener.
+func (p *Pool) Open(ctx context.Context, id iiif.ID, path string) (img.Decoder, error) {
+	var args = OpenArgs{ID: string(id), Path: path}
+	var info OpenReply
+	var err = p.call(ctx, "Open", args, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	var d = &decoder{p: p, ctx: ctx, info: info, args: DecodeArgs{OpenArgs: args}}
+	if info.Reduces {
+		return &reducingDecoder{d}, nil
+	}
+	return d, nil
+}
+
+// decoder stands in for an image's real decoder: it records the settings
+// img.Resource makes, and sends them all to a worker to decode the image
+type decoder struct {
+	p    *Pool
+	ctx  context.Context
+	info OpenReply
+	args DecodeArgs
+}
+
+// DecodeImage implements img.Decoder
+func (d *decoder) DecodeImage() (image.Image, error) {
+	var args = d.args
+	if dl, ok := d.ctx.Deadline(); ok {
+		args.Deadline = dl
+	}
+	args.RequestID = requestid.FromContext(d.ctx)
+
+	var reply Image
+	var err = d.p.call(d.ctx, "Decode", args, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.image()
+}
+
+// GetWidth implements img.Decoder
+func (d *decoder) GetWidth() int { return d.info.Width }
+
+// GetHeight implements img.Decoder
+func (d *decoder) GetHeight() int { return d.info.Height }
+
+// GetTileWidth implements img.Decoder
+func (d *decoder) GetTileWidth() int { return d.info.TileWidth }
+
+// GetTileHeight implements img.Decoder
+func (d *decoder) GetTileHeight() int { return d.info.TileHeight }
+
+// GetLevels implements img.Decoder
+func (d *decoder) GetLevels() int { return d.info.Levels }
+
+// SetCrop implements img.Decoder
+func (d *decoder) SetCrop(r image.Rectangle) { d.args.Crop = r }
+
+// SetResizeWH implements img.Decoder
+func (d *decoder) SetResizeWH(w, h int) { d.args.Width, d.args.Height = w, h }
+
+// SetFilter implements img.FilterSetter; decoders which don't scale in Go
+// ignore it
+func (d *decoder) SetFilter(f transform.Filter) { d.args.Filter = string(f) }
+
+// SetLayers implements img.LayerSetter; decoders without quality layers
+// ignore it
+func (d *decoder) SetLayers(n int) { d.args.Layers = n }
+
+// SetContext implements img.ContextSetter
+func (d *decoder) SetContext(ctx context.Context) { d.ctx = ctx }
+
+// reducingDecoder is a decoder for images whose real decoder can decode a
+// lower resolution level than a request needs.  It's a separate type since
+// img.Resource decides whether to reduce based on the interfaces the decoder
+// implements.
+type reducingDecoder struct {
+	*decoder
+}
+
+// SetReduction implements img.ReductionSetter
+func (d *reducingDecoder) SetReduction(level int) { d.args.Reduction = level }
@@ -0,0 +1,418 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package decodeworker
+
+/*
+#cgo CFLAGS: -D_GNU_SOURCE
+#include <errno.h>
+#include <fcntl.h>
+#include <linux/audit.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
+#include <sched.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/prctl.h>
+#include <sys/stat.h>
+#include <sys/syscall.h>
+#include <unistd.h>
+
+// Landlock's definitions are copied here, as older kernel headers lack them
+#ifndef __NR_landlock_create_ruleset
+#define __NR_landlock_create_ruleset 444
+#define __NR_landlock_add_rule 445
+#define __NR_landlock_restrict_self 446
+#endif
+#define RAIS_LANDLOCK_CREATE_RULESET_VERSION (1U << 0)
+#define RAIS_LANDLOCK_RULE_PATH_BENEATH 1
+#define RAIS_LANDLOCK_ACCESS_FS_READ_FILE (1ULL << 2)
+#define RAIS_LANDLOCK_ACCESS_FS_READ_DIR (1ULL << 3)
+#define RAIS_LANDLOCK_ACCESS_NET_ALL ((1ULL << 2) - 1)
+#define RAIS_LANDLOCK_SCOPE_ALL ((1ULL << 2) - 1)
+
+struct rais_landlock_ruleset_attr {
+	uint64_t handled_access_fs;
+	uint64_t handled_access_net;
+	uint64_t scoped;
+};
+
+struct rais_landlock_path_beneath_attr {
+	uint64_t allowed_access;
+	int32_t parent_fd;
+} __attribute__((packed));
+
+#ifndef SECCOMP_RET_KILL_PROCESS
+#define SECCOMP_RET_KILL_PROCESS 0x80000000U
+#endif
+
+#if defined(__x86_64__)
+#define RAIS_AUDIT_ARCH AUDIT_ARCH_X86_64
+#elif defined(__aarch64__)
+#define RAIS_AUDIT_ARCH AUDIT_ARCH_AARCH64
+#endif
+
+// What the constructor did, for SandboxStatus to report
+int rais_sandbox_ran;
+int rais_landlock_abi;
+int rais_seccomp_applied;
+char rais_sandbox_errors[1024];
+
+static void rais_sandbox_error(const char *what, int err) {
+	size_t n = strlen(rais_sandbox_errors);
+	snprintf(rais_sandbox_errors + n, sizeof(rais_sandbox_errors) - n, "%s%s: %s",
+		n ? "; " : "", what, strerror(err));
+}
+
+// rais_landlock_fs_rights returns every filesystem right the given Landlock
+// ABI version knows about, so all of them are denied except where a rule
+// allows them
+static uint64_t rais_landlock_fs_rights(int abi) {
+	uint64_t rights = (1ULL << 13) - 1;
+	if (abi >= 2) rights |= 1ULL << 13;
+	if (abi >= 3) rights |= 1ULL << 14;
+	if (abi >= 5) rights |= 1ULL << 15;
+	return rights;
+}
+
+// rais_landlock limits the process to reading the paths in list, which is
+// separated by colons.  Writes, execs, and TCP are denied everywhere, as are
+// signals and abstract unix sockets leaving the process where the kernel
+// supports that.
+static void rais_landlock(char *list) {
+	int abi = syscall(__NR_landlock_create_ruleset, NULL, 0, RAIS_LANDLOCK_CREATE_RULESET_VERSION);
+	if (abi < 0) {
+		rais_sandbox_error("landlock unavailable", errno);
+		return;
+	}
+
+	struct rais_landlock_ruleset_attr attr = {0};
+	size_t size = sizeof(attr.handled_access_fs);
+	attr.handled_access_fs = rais_landlock_fs_rights(abi);
+	if (abi >= 4) {
+		attr.handled_access_net = RAIS_LANDLOCK_ACCESS_NET_ALL;
+		size += sizeof(attr.handled_access_net);
+	}
+	if (abi >= 6) {
+		attr.scoped = RAIS_LANDLOCK_SCOPE_ALL;
+		size += sizeof(attr.scoped);
+	}
+	int ruleset = syscall(__NR_landlock_create_ruleset, &attr, size, 0);
+	if (ruleset < 0) {
+		rais_sandbox_error("landlock_create_ruleset", errno);
+		return;
+	}
+
+	for (char *path = strtok(list, ":"); path != NULL; path = strtok(NULL, ":")) {
+		int fd = open(path, O_PATH | O_CLOEXEC);
+		if (fd < 0) {
+			if (errno != ENOENT) {
+				rais_sandbox_error(path, errno);
+			}
+			continue;
+		}
+
+		struct stat st;
+		struct rais_landlock_path_beneath_attr rule = {0};
+		rule.parent_fd = fd;
+		rule.allowed_access = RAIS_LANDLOCK_ACCESS_FS_READ_FILE;
+		if (fstat(fd, &st) == 0 && S_ISDIR(st.st_mode)) {
+			rule.allowed_access |= RAIS_LANDLOCK_ACCESS_FS_READ_DIR;
+		}
+		if (syscall(__NR_landlock_add_rule, ruleset, RAIS_LANDLOCK_RULE_PATH_BENEATH, &rule, 0) != 0) {
+			rais_sandbox_error(path, errno);
+		}
+		close(fd);
+	}
+
+	if (syscall(__NR_landlock_restrict_self, ruleset, 0) != 0) {
+		rais_sandbox_error("landlock_restrict_self", errno);
+	} else {
+		rais_landlock_abi = abi;
+	}
+	close(ruleset);
+}
+
+#ifdef RAIS_AUDIT_ARCH
+
+// Jump targets in the seccomp filter, which are resolved once it's built
+enum { RAIS_NEXT = -1, L_ALLOW, L_EPERM, L_EACCES, L_ENOSYS, L_KILL, L_CLONE, L_OPEN, L_OPENAT, L_COUNT };
+
+#define RAIS_MAX_FILTER 256
+static struct sock_filter rais_filter[RAIS_MAX_FILTER];
+static int rais_jt[RAIS_MAX_FILTER], rais_jf[RAIS_MAX_FILTER];
+static int rais_labels[L_COUNT];
+static int rais_len;
+
+static void rais_emit(unsigned short code, unsigned int k, int jt, int jf) {
+	rais_filter[rais_len] = (struct sock_filter)BPF_STMT(code, k);
+	rais_jt[rais_len] = jt;
+	rais_jf[rais_len] = jf;
+	rais_len++;
+}
+
+static void rais_deny(int nr, int label) {
+	rais_emit(BPF_JMP | BPF_JEQ | BPF_K, nr, label, RAIS_NEXT);
+}
+
+// rais_arg loads the low 32 bits of a syscall argument; both supported
+// architectures are little-endian
+static void rais_arg(int n) {
+	rais_emit(BPF_LD | BPF_W | BPF_ABS, offsetof(struct seccomp_data, args) + 8 * n, RAIS_NEXT, RAIS_NEXT);
+}
+
+// rais_seccomp blocks syscalls a decoder has no use for: running programs,
+// networking, debugging other processes, changing the filesystem, and
+// administering the system.  Files can't be opened for writing, and only
+// threads, not processes, can be created.  Blocked calls fail with EPERM or
+// EACCES rather than killing the worker, so a decoder which tries one gets an
+// error it can report.
+static void rais_seccomp(void) {
+	#define DENY(name) rais_deny(__NR_##name, L_EPERM)
+	rais_emit(BPF_LD | BPF_W | BPF_ABS, offsetof(struct seccomp_data, arch), RAIS_NEXT, RAIS_NEXT);
+	rais_emit(BPF_JMP | BPF_JEQ | BPF_K, RAIS_AUDIT_ARCH, RAIS_NEXT, L_KILL);
+	rais_emit(BPF_LD | BPF_W | BPF_ABS, offsetof(struct seccomp_data, nr), RAIS_NEXT, RAIS_NEXT);
+#ifdef __X32_SYSCALL_BIT
+	rais_emit(BPF_JMP | BPF_JGE | BPF_K, __X32_SYSCALL_BIT, L_EPERM, RAIS_NEXT);
+#endif
+
+	// Programs, processes, and namespaces
+	DENY(execve);
+	DENY(execveat);
+	DENY(ptrace);
+	DENY(process_vm_readv);
+	DENY(process_vm_writev);
+	DENY(kcmp);
+	DENY(unshare);
+	DENY(setns);
+#ifdef __NR_fork
+	DENY(fork);
+	DENY(vfork);
+#endif
+#ifdef __NR_pidfd_getfd
+	DENY(pidfd_getfd);
+#endif
+
+	// Networking
+	DENY(socket);
+	DENY(socketpair);
+	DENY(connect);
+	DENY(bind);
+	DENY(listen);
+	DENY(accept);
+	DENY(accept4);
+
+	// Filesystem changes
+	DENY(unlinkat);
+	DENY(mkdirat);
+	DENY(linkat);
+	DENY(symlinkat);
+	DENY(mknodat);
+	DENY(fchmod);
+	DENY(fchmodat);
+	DENY(fchown);
+	DENY(fchownat);
+	DENY(truncate);
+	DENY(utimensat);
+	DENY(setxattr);
+	DENY(lsetxattr);
+	DENY(fsetxattr);
+	DENY(removexattr);
+	DENY(lremovexattr);
+	DENY(fremovexattr);
+	DENY(memfd_create);
+	DENY(name_to_handle_at);
+	DENY(open_by_handle_at);
+#ifdef __NR_renameat
+	DENY(renameat);
+#endif
+#ifdef __NR_renameat2
+	DENY(renameat2);
+#endif
+#ifdef __NR_unlink
+	DENY(creat);
+	DENY(unlink);
+	DENY(rename);
+	DENY(mkdir);
+	DENY(rmdir);
+	DENY(link);
+	DENY(symlink);
+	DENY(mknod);
+	DENY(chmod);
+	DENY(chown);
+	DENY(lchown);
+	DENY(utime);
+	DENY(utimes);
+	DENY(futimesat);
+#endif
+
+	// System administration
+	DENY(mount);
+	DENY(umount2);
+	DENY(pivot_root);
+	DENY(chroot);
+	DENY(swapon);
+	DENY(swapoff);
+	DENY(reboot);
+	DENY(kexec_load);
+	DENY(init_module);
+	DENY(finit_module);
+	DENY(delete_module);
+	DENY(acct);
+	DENY(syslog);
+	DENY(settimeofday);
+	DENY(clock_settime);
+	DENY(clock_adjtime);
+	DENY(adjtimex);
+	DENY(quotactl);
+	DENY(bpf);
+	DENY(perf_event_open);
+	DENY(userfaultfd);
+	DENY(keyctl);
+	DENY(add_key);
+	DENY(request_key);
+	DENY(vhangup);
+#ifdef __NR_kexec_file_load
+	DENY(kexec_file_load);
+#endif
+#ifdef __NR_io_uring_setup
+	DENY(io_uring_setup);
+	DENY(io_uring_enter);
+	DENY(io_uring_register);
+#endif
+#ifdef __NR_open_tree
+	DENY(open_tree);
+	DENY(move_mount);
+	DENY(fsopen);
+	DENY(fsconfig);
+	DENY(fsmount);
+	DENY(fspick);
+#endif
+#ifdef __NR_iopl
+	DENY(iopl);
+	DENY(ioperm);
+	DENY(modify_ldt);
+	DENY(uselib);
+#endif
+
+	// Calls whose arguments are checked.  clone3 and openat2 pass theirs in
+	// a struct the filter can't see, so they're reported as unsupported, and
+	// libc falls back to clone and openat.
+	rais_deny(__NR_clone3, L_ENOSYS);
+#ifdef __NR_openat2
+	rais_deny(__NR_openat2, L_ENOSYS);
+#endif
+	rais_deny(__NR_clone, L_CLONE);
+	rais_deny(__NR_openat, L_OPENAT);
+#ifdef __NR_open
+	rais_deny(__NR_open, L_OPEN);
+#endif
+	rais_emit(BPF_RET | BPF_K, SECCOMP_RET_ALLOW, RAIS_NEXT, RAIS_NEXT);
+
+	#define WRITE_FLAGS (O_WRONLY | O_RDWR | O_CREAT | O_TRUNC)
+	rais_labels[L_CLONE] = rais_len;
+	rais_arg(0);
+	rais_emit(BPF_JMP | BPF_JSET | BPF_K, CLONE_THREAD, L_ALLOW, L_EPERM);
+	rais_labels[L_OPENAT] = rais_len;
+	rais_arg(2);
+	rais_emit(BPF_JMP | BPF_JSET | BPF_K, WRITE_FLAGS, L_EACCES, L_ALLOW);
+	rais_labels[L_OPEN] = rais_len;
+	rais_arg(1);
+	rais_emit(BPF_JMP | BPF_JSET | BPF_K, WRITE_FLAGS, L_EACCES, L_ALLOW);
+
+	rais_labels[L_ALLOW] = rais_len;
+	rais_emit(BPF_RET | BPF_K, SECCOMP_RET_ALLOW, RAIS_NEXT, RAIS_NEXT);
+	rais_labels[L_EPERM] = rais_len;
+	rais_emit(BPF_RET | BPF_K, SECCOMP_RET_ERRNO | EPERM, RAIS_NEXT, RAIS_NEXT);
+	rais_labels[L_EACCES] = rais_len;
+	rais_emit(BPF_RET | BPF_K, SECCOMP_RET_ERRNO | EACCES, RAIS_NEXT, RAIS_NEXT);
+	rais_labels[L_ENOSYS] = rais_len;
+	rais_emit(BPF_RET | BPF_K, SECCOMP_RET_ERRNO | ENOSYS, RAIS_NEXT, RAIS_NEXT);
+	rais_labels[L_KILL] = rais_len;
+	rais_emit(BPF_RET | BPF_K, SECCOMP_RET_KILL_PROCESS, RAIS_NEXT, RAIS_NEXT);
+
+	for (int i = 0; i < rais_len; i++) {
+		if (BPF_CLASS(rais_filter[i].code) != BPF_JMP) {
+			continue;
+		}
+		rais_filter[i].jt = rais_jt[i] == RAIS_NEXT ? 0 : rais_labels[rais_jt[i]] - i - 1;
+		rais_filter[i].jf = rais_jf[i] == RAIS_NEXT ? 0 : rais_labels[rais_jf[i]] - i - 1;
+	}
+
+	struct sock_fprog prog = { .len = rais_len, .filter = rais_filter };
+	if (prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog) != 0) {
+		rais_sandbox_error("seccomp", errno);
+		return;
+	}
+	rais_seccomp_applied = 1;
+}
+
+#else
+
+static void rais_seccomp(void) {
+	rais_sandbox_error("seccomp", ENOTSUP);
+}
+
+#endif
+
+// rais_sandbox runs before the Go runtime starts, while the process has a
+// single thread: Landlock only restricts the thread which enables it and any
+// threads it starts later, so this is the only point where it can cover the
+// whole process.
+__attribute__((constructor)) static void rais_sandbox(void) {
+	if (getenv("RAIS_DECODE_WORKER") == NULL) {
+		return;
+	}
+	char *paths = getenv("RAIS_DECODE_WORKER_SANDBOX");
+	if (paths == NULL) {
+		return;
+	}
+
+	rais_sandbox_ran = 1;
+	if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) != 0) {
+		rais_sandbox_error("no_new_privs", errno);
+		return;
+	}
+	paths = strdup(paths);
+	rais_landlock(paths);
+	free(paths);
+	rais_seccomp();
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SandboxStatus describes the restrictions applied to this worker when it
+// started.  It returns an empty string if no sandbox was requested, and an
+// error if any part of one couldn't be applied.
+func SandboxStatus() (string, error) {
+	if _, ok := os.LookupEnv(EnvSandbox); !ok {
+		return "", nil
+	}
+	if C.rais_sandbox_ran == 0 {
+		return "", errors.New("sandbox setup didn't run (the binary must be linked externally)")
+	}
+
+	var parts []string
+	if C.rais_landlock_abi > 0 {
+		parts = append(parts, fmt.Sprintf("landlock ABI %d", C.rais_landlock_abi))
+	}
+	if C.rais_seccomp_applied != 0 {
+		parts = append(parts, "seccomp")
+	}
+	var status = strings.Join(parts, ", ")
+	var msg = C.GoString(&C.rais_sandbox_errors[0])
+	if msg != "" {
+		return status, errors.New(msg)
+	}
+	return status, nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux || !cgo
+// +build !linux !cgo
+
+package decodeworker
+
+import (
+	"errors"
+	"os"
+)
+
+// SandboxStatus always reports an error if a sandbox was requested, as
+// workers can only be sandboxed on Linux
+func SandboxStatus() (string, error) {
+	if _, ok := os.LookupEnv(EnvSandbox); !ok {
+		return "", nil
+	}
+	return "", errors.New("sandboxing is only supported on Linux")
+}
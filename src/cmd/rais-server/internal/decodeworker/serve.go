@@ -0,0 +1,95 @@
+package decodeworker
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"os"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/requestid"
+	"rais/src/transform"
+)
+
+// service answers the server's calls using the decoders registered in this
+// worker
+type service struct{}
+
+// Open reads an image's headers
+func (s *service) Open(args OpenArgs, reply *OpenReply) error {
+	var d, err = img.OpenDecoder(iiif.ID(args.ID), args.Path)
+	if err != nil {
+		return err
+	}
+
+	reply.Width, reply.Height = d.GetWidth(), d.GetHeight()
+	reply.TileWidth, reply.TileHeight = d.GetTileWidth(), d.GetTileHeight()
+	reply.Levels = d.GetLevels()
+	_, reply.Reduces = d.(img.ReductionSetter)
+	return nil
+}
+
+// Sandbox reports the worker's SandboxStatus, so the server can refuse to
+// use a worker whose sandbox is incomplete
+func (s *service) Sandbox(_ int, status *string) error {
+	var err error
+	*status, err = SandboxStatus()
+	return err
+}
+
+// Decode sets up a decoder exactly as the server's img.Resource set up its
+// stand-in, and decodes the image
+func (s *service) Decode(args DecodeArgs, reply *Image) error {
+	var d, err = img.OpenDecoder(iiif.ID(args.ID), args.Path)
+	if err != nil {
+		return err
+	}
+
+	d.SetCrop(args.Crop)
+	d.SetResizeWH(args.Width, args.Height)
+	if fs, ok := d.(img.FilterSetter); ok {
+		fs.SetFilter(transform.Filter(args.Filter))
+	}
+	if ls, ok := d.(img.LayerSetter); ok {
+		ls.SetLayers(args.Layers)
+	}
+	if rs, ok := d.(img.ReductionSetter); ok && args.Reduction > 0 {
+		rs.SetReduction(args.Reduction)
+	}
+	if cs, ok := d.(img.ContextSetter); ok {
+		var ctx = requestid.NewContext(context.Background(), args.RequestID)
+		if !args.Deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, args.Deadline)
+			defer cancel()
+		}
+		cs.SetContext(ctx)
+	}
+
+	var i, decodeErr = d.DecodeImage()
+	if decodeErr != nil {
+		return decodeErr
+	}
+	*reply = *newImage(i)
+	return nil
+}
+
+// Serve answers the server's calls until it closes the connection, which it
+// does when it shuts down.  Panics aren't recovered: the worker dies, and
+// the server sees the call fail with ErrCrashed.
+func Serve() error {
+	var f = os.NewFile(workerFD, "decode worker socket")
+	var conn, err = net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	var server = rpc.NewServer()
+	err = server.RegisterName("Worker", &service{})
+	if err != nil {
+		return err
+	}
+	server.ServeConn(conn)
+	return nil
+}
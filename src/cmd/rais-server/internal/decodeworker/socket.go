@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package decodeworker
+
+import (
+	"os"
+	"syscall"
+)
+
+// socketPair returns both ends of a connected unix socket.  Both are made
+// close-on-exec under syscall.ForkLock, so no other child process can inherit
+// them; exec.Cmd's ExtraFiles hands the worker its end.
+func socketPair() (local, remote *os.File, err error) {
+	syscall.ForkLock.RLock()
+	var fds [2]int
+	fds, err = syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	return os.NewFile(uintptr(fds[0]), "decode worker"), os.NewFile(uintptr(fds[1]), "decode worker socket"), nil
+}
@@ -0,0 +1,10 @@
+package decodeworker
+
+import (
+	"errors"
+	"os"
+)
+
+func socketPair() (local, remote *os.File, err error) {
+	return nil, nil, errors.New("decode workers are not supported on Windows")
+}
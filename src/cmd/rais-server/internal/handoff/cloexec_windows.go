@@ -0,0 +1,5 @@
+package handoff
+
+// closeOnExec is a no-op: Windows can't pass extra files to a new process,
+// so there's never anything inherited to protect
+func closeOnExec(fd int) {}
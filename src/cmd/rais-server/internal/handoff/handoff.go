@@ -0,0 +1,140 @@
+// Package handoff lets a running RAIS start a new copy of its binary and
+// pass it the listening sockets, so a new version can take over without
+// refusing connections.  The old process keeps serving until the new one
+// says it's ready, then finishes its in-flight requests and exits.
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envFDs tells the new process how many listening sockets it was given.
+// They start at file descriptor 3, and are followed by the pipe used to
+// report readiness.
+const envFDs = "RAIS_HANDOFF_FDS"
+
+// firstFD is the first file descriptor passed to the new process; it's a
+// variable only so tests can hand over descriptors of their own
+var firstFD = 3
+
+// ready is the pipe to the old process, if there is one
+var ready *os.File
+
+// Listeners returns the sockets passed by the old process if this process
+// was started by Upgrade
+func Listeners() ([]net.Listener, error) {
+	var n, err = strconv.Atoi(os.Getenv(envFDs))
+	os.Unsetenv(envFDs)
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	var listeners []net.Listener
+	for fd := firstFD; fd < firstFD+n; fd++ {
+		var f = os.NewFile(uintptr(fd), fmt.Sprintf("inherited socket %d", fd))
+		var ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("file descriptor %d is not a listening socket: %s", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	// Unlike the sockets, which net.FileListener duplicates, the pipe's
+	// descriptor stays open, and mustn't leak into external plugins
+	closeOnExec(firstFD + n)
+	ready = os.NewFile(uintptr(firstFD+n), "handoff readiness pipe")
+	return listeners, nil
+}
+
+// Ready tells the old process that this one is serving requests, so it can
+// stop.  If this process wasn't started by Upgrade, it does nothing.
+func Ready() error {
+	if ready == nil {
+		return nil
+	}
+	var _, err = ready.Write([]byte{1})
+	ready.Close()
+	ready = nil
+	return err
+}
+
+// Upgrade starts a new copy of the running binary with the same arguments,
+// passing it the given listening sockets.  It waits up to timeout for the
+// new process to call Ready, returning its pid.  If the new process fails
+// to start, exits, or isn't ready in time, it's killed and an error is
+// returned; the caller should simply keep serving.
+func Upgrade(files []*os.File, timeout time.Duration) (int, error) {
+	var exe, err = os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	var r, w *os.File
+	r, w, err = os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	var cmd = exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = childEnv(os.Environ(), len(files))
+	cmd.ExtraFiles = append(append([]*os.File(nil), files...), w)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	go cmd.Wait()
+
+	// The new process closes its end of the pipe whether it exits or becomes
+	// ready, so a read tells us which happened
+	var result = make(chan error, 1)
+	go func() {
+		var buf = make([]byte, 1)
+		var _, err = r.Read(buf)
+		if err == io.EOF {
+			err = errors.New("new process exited before it was ready")
+		}
+		result <- err
+	}()
+
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process wasn't ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return 0, err
+	}
+	return cmd.Process.Pid, nil
+}
+
+// childEnv returns env for the new process: the sockets it's given are
+// described, and systemd's socket and watchdog variables, which refer to
+// this process, are dropped
+func childEnv(env []string, n int) []string {
+	var out []string
+	for _, kv := range env {
+		var name = strings.SplitN(kv, "=", 2)[0]
+		switch name {
+		case envFDs, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", "WATCHDOG_PID":
+			continue
+		}
+		out = append(out, kv)
+	}
+	return append(out, fmt.Sprintf("%s=%d", envFDs, n))
+}
@@ -0,0 +1,63 @@
+//go:build linux
+// +build linux
+
+package handoff
+
+import (
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestChildEnv(t *testing.T) {
+	var env = childEnv([]string{"PATH=/bin", "WATCHDOG_PID=10", "WATCHDOG_USEC=30000000", "LISTEN_FDS=2", envFDs + "=9"}, 2)
+	assert.Equal("PATH=/bin WATCHDOG_USEC=30000000 "+envFDs+"=2", strings.Join(env, " "), "environment", t)
+}
+
+// handTo moves f to the given descriptor, which no os.File will own, as if
+// it had been inherited
+func handTo(f *os.File, fd int, t *testing.T) {
+	var err = syscall.Dup3(int(f.Fd()), fd, 0)
+	f.Close()
+	assert.NilError(err, "duplicating descriptor", t)
+}
+
+func TestListenersAndReady(t *testing.T) {
+	var ln, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(err, "listening", t)
+	defer ln.Close()
+
+	// The listener and readiness pipe have to be consecutive descriptors, so
+	// they're moved somewhere high enough to be out of the way
+	var lf, _ = ln.(*net.TCPListener).File()
+	var r, w *os.File
+	r, w, err = os.Pipe()
+	assert.NilError(err, "creating pipe", t)
+	defer r.Close()
+	handTo(lf, 200, t)
+	handTo(w, 201, t)
+
+	defer func(n int) { firstFD = n }(firstFD)
+	firstFD = 200
+	os.Setenv(envFDs, "1")
+
+	var lns []net.Listener
+	lns, err = Listeners()
+	assert.NilError(err, "reading listeners", t)
+	assert.Equal(1, len(lns), "one listener", t)
+	assert.Equal(ln.Addr().String(), lns[0].Addr().String(), "listener address", t)
+	assert.Equal("", os.Getenv(envFDs), "environment is cleared", t)
+	lns[0].Close()
+
+	assert.NilError(Ready(), "signaling readiness", t)
+	var buf = make([]byte, 2)
+	var n int
+	n, err = r.Read(buf)
+	assert.NilError(err, "reading readiness", t)
+	assert.Equal(1, n, "one byte is written", t)
+	assert.NilError(Ready(), "signaling readiness again is a no-op", t)
+}
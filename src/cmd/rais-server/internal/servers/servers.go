@@ -2,7 +2,14 @@
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/fcgi"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +19,10 @@
 var servers = make(map[string]*Server)
 var running sync.WaitGroup
 
+// UnixPrefix marks an address as the path to a unix socket rather than a TCP
+// address, e.g., "unix:/run/rais/rais.sock"
+const UnixPrefix = "unix:"
+
 // Server wraps an http.Server with some helpers for running in the background,
 // setting up sane defaults (no global ServeMux), and shutdown of all
 // registered servers
@@ -20,6 +31,19 @@ type Server struct {
 	Name       string
 	Mux        *mux.Router
 	middleware []func(http.Handler) http.Handler
+	certFile   string
+	keyFile    string
+	fastCGI    bool
+
+	// SocketMode sets the permissions of the socket file when the server
+	// listens on a unix socket.  If it's zero, the umask decides.
+	SocketMode os.FileMode
+
+	m         sync.Mutex
+	listener  net.Listener
+	inherited net.Listener
+	closed    bool
+	fresh     map[net.Conn]bool
 }
 
 // NewServer registers a named server at the given bind address.  If the
@@ -44,10 +68,59 @@ func New(name, addr string) *Server {
 		},
 	}
 
+	s.ConnState = s.trackFresh
 	servers[addr] = s
 	return s
 }
 
+// trackFresh keeps a list of connections which haven't yet sent a request
+func (s *Server) trackFresh(c net.Conn, state http.ConnState) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if state == http.StateNew {
+		if s.fresh == nil {
+			s.fresh = make(map[net.Conn]bool)
+		}
+		s.fresh[c] = true
+		return
+	}
+	delete(s.fresh, c)
+}
+
+// freshWait is the longest waitFresh will wait.  It's how long http.Server
+// lets a connection sit without a request before treating it as idle, and
+// keeps clients which open connections speculatively, like browsers, from
+// holding up shutdown.
+const freshWait = 5 * time.Second
+
+// waitFresh waits until every connection the server has accepted has sent
+// its first request, or for at most freshWait or the read timeout, or until
+// ctx is done
+func (s *Server) waitFresh(ctx context.Context) {
+	var timeout = freshWait
+	if s.ReadTimeout > 0 && s.ReadTimeout < timeout {
+		timeout = s.ReadTimeout
+	}
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		s.m.Lock()
+		var n = len(s.fresh)
+		s.m.Unlock()
+		if n == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
 // AddMiddleware appends to the list of middleware handlers - these wrap *all*
 // handlers in the given middleware
 //
@@ -64,6 +137,44 @@ func (s *Server) wrapMiddleware(handler http.Handler) http.Handler {
 	return handler
 }
 
+// SetTLS tells the server to listen for HTTPS connections using the given
+// certificate and key files rather than serving plain HTTP
+func (s *Server) SetTLS(certFile, keyFile string) {
+	s.certFile = certFile
+	s.keyFile = keyFile
+}
+
+// SetTLSConfig tells the server to listen for HTTPS connections using cfg,
+// which must provide certificates itself, e.g., via GetCertificate.  This is
+// for certificates which aren't in files, such as those obtained via ACME.
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.Server.TLSConfig = cfg
+}
+
+// DisableHTTP2 prevents the server from negotiating HTTP/2 on TLS
+// connections.  Plain HTTP listeners never use HTTP/2, so this only matters
+// when SetTLS has been called.
+func (s *Server) DisableHTTP2() {
+	s.Server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+}
+
+// TLS returns true if the server has been configured to serve HTTPS
+func (s *Server) TLS() bool {
+	return s.certFile != "" && s.keyFile != "" || s.Server.TLSConfig != nil
+}
+
+// SetFastCGI tells the server to speak FastCGI rather than HTTP, for use
+// behind a web server like Apache or nginx.  The server's timeouts don't apply
+// to FastCGI connections.
+func (s *Server) SetFastCGI() {
+	s.fastCGI = true
+}
+
+// FastCGI returns true if the server has been configured to speak FastCGI
+func (s *Server) FastCGI() bool {
+	return s.fastCGI
+}
+
 // HandleExact sets up a gorilla/mux handler that response only to the exact
 // path given
 func (s *Server) HandleExact(pth string, handler http.Handler) {
@@ -76,26 +187,173 @@ func (s *Server) HandlePrefix(prefix string, handler http.Handler) {
 	s.Mux.PathPrefix(prefix).Handler(s.wrapMiddleware(handler))
 }
 
-// run wraps http.Server's ListenAndServe in a background-friendly way, sending
-// any errors to the "done" callback when the server closes
-func (s *Server) run(done func(*Server, error)) {
-	var err = s.Server.ListenAndServe()
-	if err == http.ErrServerClosed {
+// Adopt hands an already-open listener, such as one from systemd socket
+// activation, to the registered server whose address it matches.  That
+// server uses it instead of opening its address.  If no server's address
+// matches, nil is returned.
+func Adopt(ln net.Listener) *Server {
+	for _, s := range servers {
+		if s.inherited == nil && addrMatches(s.Addr, ln.Addr()) {
+			s.inherited = ln
+			return s
+		}
+	}
+	return nil
+}
+
+// addrMatches returns true if a listener on a would satisfy a server
+// configured with addr.  TCP ports must be the same, and unless addr leaves
+// the host empty, so must the IP.
+func addrMatches(addr string, a net.Addr) bool {
+	if strings.HasPrefix(addr, UnixPrefix) {
+		return a.Network() == "unix" && a.String() == strings.TrimPrefix(addr, UnixPrefix)
+	}
+
+	var tcp, ok = a.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	var want, err = net.ResolveTCPAddr("tcp", addr)
+	if err != nil || want.Port != tcp.Port {
+		return false
+	}
+	return want.IP == nil || want.IP.Equal(tcp.IP)
+}
+
+// listen opens the server's TCP address or unix socket, unless it has
+// adopted a listener.  A stale socket file from a previous run is removed
+// first, since it would otherwise prevent listening.
+func (s *Server) listen() (net.Listener, error) {
+	if s.inherited != nil {
+		return s.inherited, nil
+	}
+	if !strings.HasPrefix(s.Addr, UnixPrefix) {
+		var addr = s.Addr
+		if addr == "" {
+			addr = ":http"
+			if s.TLS() {
+				addr = ":https"
+			}
+		}
+		return net.Listen("tcp", addr)
+	}
+
+	var pth = strings.TrimPrefix(s.Addr, UnixPrefix)
+	var info, err = os.Stat(pth)
+	if err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(pth)
+	}
+
+	var ln net.Listener
+	ln, err = net.Listen("unix", pth)
+	if err == nil && s.SocketMode != 0 {
+		err = os.Chmod(pth, s.SocketMode)
+		if err != nil {
+			ln.Close()
+		}
+	}
+	return ln, err
+}
+
+// run wraps http.Server's Serve (or ServeTLS if TLS is set up, or FastCGI's
+// Serve) in a background-friendly way, sending any errors to the "done"
+// callback when the server closes
+func (s *Server) run(ln net.Listener, done func(*Server, error)) {
+	var err error
+	s.m.Lock()
+	s.listener = ln
+	var closed = s.closed
+	s.m.Unlock()
+	if closed {
+		ln.Close()
+		done(s, nil)
+		return
+	}
+
+	switch {
+	case s.fastCGI:
+		err = fcgi.Serve(ln, s.Handler)
+	case s.TLS():
+		err = s.Server.ServeTLS(ln, s.certFile, s.keyFile)
+	default:
+		err = s.Server.Serve(ln)
+	}
+	s.m.Lock()
+	if err == http.ErrServerClosed || s.closed && errors.Is(err, net.ErrClosed) {
 		err = nil
 	}
+	s.m.Unlock()
 	done(s, err)
 }
 
+// stop shuts the server down.  The listener is closed first, and requests
+// on connections it already accepted are given a chance to arrive, since
+// http.Server drops any request which arrives once it's shutting down.
+// This matters when the listening socket lives on in another process after
+// an upgrade: clients see no difference between the two.  FastCGI servers
+// aren't managed by the http.Server, so closing the listener is all there
+// is to do.
+func (s *Server) stop(ctx context.Context) {
+	s.m.Lock()
+	s.closed = true
+	var ln = s.listener
+	s.m.Unlock()
+
+	if ln != nil {
+		ln.Close()
+	}
+	if s.fastCGI {
+		return
+	}
+	s.waitFresh(ctx)
+	s.Shutdown(ctx)
+}
+
+// Files returns a duplicate of each running server's listening socket so
+// they can be passed to another process.  Unix socket files are left in
+// place when these servers shut down, since the other process is still
+// listening on them.
+func Files() ([]*os.File, error) {
+	var files []*os.File
+	for _, s := range servers {
+		s.m.Lock()
+		var ln = s.listener
+		s.m.Unlock()
+
+		var f *os.File
+		var err error
+		switch l := ln.(type) {
+		case *net.TCPListener:
+			f, err = l.File()
+		case *net.UnixListener:
+			l.SetUnlinkOnClose(false)
+			f, err = l.File()
+		default:
+			err = fmt.Errorf("%q isn't listening on a socket which can be passed on", s.Name)
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
 // Shutdown stops all registered servers
 func Shutdown(ctx context.Context) {
 	for _, s := range servers {
-		s.Shutdown(ctx)
+		s.stop(ctx)
 	}
 }
 
 // ListenAndServe runs all servers and waits for them to shut down, running onErr
-// when a server returns an error (other than http.ErrServerClosed) occurs
-func ListenAndServe(onErr func(*Server, error)) {
+// when a server returns an error (other than http.ErrServerClosed) occurs.
+// Every server's listener is opened before any starts serving, and if all of
+// them open successfully, onReady (if it isn't nil) is called.
+func ListenAndServe(onErr func(*Server, error), onReady func()) {
 	var done = func(s *Server, err error) {
 		running.Done()
 		if err != nil {
@@ -103,9 +361,22 @@ func ListenAndServe(onErr func(*Server, error)) {
 		}
 	}
 
+	var listeners = make(map[*Server]net.Listener)
 	for _, s := range servers {
+		var ln, err = s.listen()
+		if err != nil {
+			onErr(s, err)
+			continue
+		}
+		listeners[s] = ln
+	}
+
+	for s, ln := range listeners {
 		running.Add(1)
-		go s.run(done)
+		go s.run(ln, done)
+	}
+	if onReady != nil && len(listeners) == len(servers) {
+		onReady()
 	}
 
 	running.Wait()
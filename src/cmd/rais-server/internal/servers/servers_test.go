@@ -0,0 +1,160 @@
+package servers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestUnixSocket(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-servers")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var sock = filepath.Join(dir, "rais.sock")
+
+	// Leave a stale socket behind as if RAIS had crashed
+	var stale, _ = net.Listen("unix", sock)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	var srv = New("test", UnixPrefix+sock)
+	srv.SocketMode = 0600
+	srv.HandleExact("/ping", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	var fcgi = New("fcgi", UnixPrefix+filepath.Join(dir, "fcgi.sock"))
+	fcgi.SetFastCGI()
+
+	var stopped = make(chan struct{})
+	go func() {
+		ListenAndServe(func(s *Server, err error) { t.Errorf("%s: %s", s.Name, err) }, nil)
+		close(stopped)
+	}()
+	defer func() {
+		Shutdown(context.Background())
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Errorf("servers didn't shut down")
+		}
+	}()
+
+	var client = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		resp, err = client.Get("http://rais/ping")
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NilError(err, "requesting over the socket", t)
+	var body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal("pong", string(body), "response body", t)
+
+	var info, _ = os.Stat(sock)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm(), "socket mode is applied", t)
+}
+
+func TestAddrMatches(t *testing.T) {
+	var tcp = &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12415}
+	assert.True(addrMatches(":12415", tcp), "any host", t)
+	assert.True(addrMatches("127.0.0.1:12415", tcp), "same host and port", t)
+	assert.False(addrMatches("10.0.0.1:12415", tcp), "different host", t)
+	assert.False(addrMatches(":12416", tcp), "different port", t)
+	assert.False(addrMatches("unix:/run/rais.sock", tcp), "unix socket vs. TCP", t)
+
+	var unix = &net.UnixAddr{Name: "/run/rais.sock", Net: "unix"}
+	assert.True(addrMatches("unix:/run/rais.sock", unix), "same socket path", t)
+	assert.False(addrMatches("unix:/run/other.sock", unix), "different socket path", t)
+	assert.False(addrMatches(":12415", unix), "TCP vs. unix socket", t)
+}
+
+func TestAdopt(t *testing.T) {
+	var ln, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(err, "listening", t)
+	defer ln.Close()
+
+	var port = ln.Addr().(*net.TCPAddr).Port
+	var srv = New("adopted", fmt.Sprintf(":%d", port))
+	defer delete(servers, srv.Addr)
+	assert.True(Adopt(ln) == srv, "listener is adopted by the server on its port", t)
+
+	var got net.Listener
+	got, err = srv.listen()
+	assert.NilError(err, "listening with an adopted listener", t)
+	assert.True(got == ln, "adopted listener is used", t)
+
+	var other, _ = net.Listen("tcp", "127.0.0.1:0")
+	defer other.Close()
+	assert.True(Adopt(other) == nil, "listener on an unknown port isn't adopted", t)
+}
+
+func TestShutdownServesAcceptedConnections(t *testing.T) {
+	defer func(old map[string]*Server) { servers = old }(servers)
+	servers = make(map[string]*Server)
+
+	var ln, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(err, "listening", t)
+	var srv = New("drain", ln.Addr().String())
+	Adopt(ln)
+	srv.HandleExact("/ping", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+
+	var stopped = make(chan struct{})
+	go func() {
+		ListenAndServe(func(s *Server, err error) { t.Errorf("%s: %s", s.Name, err) }, nil)
+		close(stopped)
+	}()
+
+	// Connect, but don't send a request until shutdown has begun
+	var conn net.Conn
+	conn, err = net.Dial("tcp", ln.Addr().String())
+	assert.NilError(err, "connecting", t)
+	defer conn.Close()
+	for i := 0; i < 50; i++ {
+		srv.m.Lock()
+		var n = len(srv.fresh)
+		srv.m.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	go Shutdown(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	_, err = net.Dial("tcp", ln.Addr().String())
+	assert.True(err != nil, "new connections are refused once shutdown begins", t)
+
+	fmt.Fprintf(conn, "GET /ping HTTP/1.1\r\nHost: rais\r\n\r\n")
+	var resp *http.Response
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.NilError(err, "reading response", t)
+	var body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal("pong", string(body), "request on an accepted connection is served", t)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("server didn't shut down")
+	}
+}
@@ -1,10 +1,17 @@
 package statusrecorder
 
-import "net/http"
+import (
+	"net/http"
+	"sync/atomic"
+)
 
 // StatusRecorder wraps an http.ResponseWriter.  It intercepts WriteHeader
-// calls so we can record the status code for logging purposes.
+// and Write calls so we can record the status code and response size for
+// logging purposes.
 type StatusRecorder struct {
+	// Bytes is first so it's 64-bit aligned for atomic access on 32-bit
+	// platforms
+	Bytes int64
 	http.ResponseWriter
 	Status int
 }
@@ -13,7 +20,7 @@ type StatusRecorder struct {
 // explicitly written, the http library will default to 200 but we won't have
 // captured it if we don't also default it
 func New(w http.ResponseWriter) *StatusRecorder {
-	return &StatusRecorder{w, http.StatusOK}
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
 }
 
 // WriteHeader stores and then passes the code down to the real writer
@@ -21,3 +28,16 @@ func (rec *StatusRecorder) WriteHeader(code int) {
 	rec.Status = code
 	rec.ResponseWriter.WriteHeader(code)
 }
+
+// Write counts the bytes written, then passes the data to the real writer
+func (rec *StatusRecorder) Write(b []byte) (int, error) {
+	var n, err = rec.ResponseWriter.Write(b)
+	atomic.AddInt64(&rec.Bytes, int64(n))
+	return n, err
+}
+
+// Written returns the bytes written so far.  Unlike reading Bytes directly,
+// this is safe while the response is still being written.
+func (rec *StatusRecorder) Written() int64 {
+	return atomic.LoadInt64(&rec.Bytes)
+}
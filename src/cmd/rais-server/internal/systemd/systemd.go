@@ -0,0 +1,141 @@
+// Package systemd implements the small parts of systemd's protocols RAIS
+// uses: inheriting listening sockets (socket activation), sending readiness
+// and watchdog notifications, and writing structured entries to journald.
+// Other than the journal, everything here is a no-op when RAIS isn't run by
+// systemd.
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor systemd passes; it's a variable
+// only so tests can hand over sockets of their own
+var listenFDsStart = 3
+
+// Listeners returns the sockets systemd opened on RAIS's behalf, in the order
+// they're listed in the socket unit.  The environment variables describing
+// them are cleared so child processes, like external plugins, don't try to
+// claim them too.
+func Listeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	var pid, err = strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	var n int
+	n, err = strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	var listeners []net.Listener
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		var f = os.NewFile(uintptr(fd), fmt.Sprintf("systemd socket %d", fd))
+		var ln, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("file descriptor %d is not a listening stream socket: %s", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// Notify sends a state change, such as "READY=1" or "WATCHDOG=1", to
+// systemd.  Multiple assignments may be sent at once, separated by newlines.
+// If systemd isn't waiting for notifications, nothing is sent.
+func Notify(state string) error {
+	var sock = os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return nil
+	}
+
+	// Go's "@" prefix for abstract socket names matches systemd's
+	var conn, err = net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns how often systemd expects a "WATCHDOG=1"
+// notification before it considers RAIS hung, or zero if the watchdog isn't
+// enabled.  systemd recommends notifying at half this interval.
+func WatchdogInterval() time.Duration {
+	var pid = os.Getenv("WATCHDOG_PID")
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	var usec, err = strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// journalSocket is where journald listens for structured log entries
+var journalSocket = "/run/systemd/journal/socket"
+
+// Journal is a connection to journald for sending structured log entries
+type Journal struct {
+	conn *net.UnixConn
+}
+
+// OpenJournal connects to journald
+func OpenJournal() (*Journal, error) {
+	var conn, err = net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{conn: conn}, nil
+}
+
+// Send writes an entry with the given fields, such as MESSAGE and PRIORITY.
+// Field names must be uppercase letters, digits, and underscores.
+func (j *Journal) Send(fields map[string]string) error {
+	var names = make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		var val = fields[name]
+		if !strings.Contains(val, "\n") {
+			fmt.Fprintf(&buf, "%s=%s\n", name, val)
+			continue
+		}
+
+		// Values with newlines are sent as the name, then the value's length as
+		// a little-endian 64-bit number, then the value
+		buf.WriteString(name + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(val)))
+		buf.WriteString(val + "\n")
+	}
+
+	var _, err = j.conn.Write(buf.Bytes())
+	return err
+}
+
+// Close disconnects from journald
+func (j *Journal) Close() error {
+	return j.conn.Close()
+}
@@ -0,0 +1,117 @@
+//go:build linux
+// +build linux
+
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestListeners(t *testing.T) {
+	var ln, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(err, "listening", t)
+	defer ln.Close()
+
+	// Pass a duplicate of our listener's descriptor as if systemd had opened
+	// it.  Listeners takes ownership of the descriptor, so it can't belong to
+	// an os.File which would close it again.
+	var f *os.File
+	f, err = ln.(*net.TCPListener).File()
+	assert.NilError(err, "getting listener's file", t)
+	var fd int
+	fd, err = syscall.Dup(int(f.Fd()))
+	f.Close()
+	assert.NilError(err, "duplicating listener's descriptor", t)
+	defer func(n int) { listenFDsStart = n }(listenFDsStart)
+	listenFDsStart = fd
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	var lns []net.Listener
+	lns, err = Listeners()
+	assert.NilError(err, "reading listeners", t)
+	assert.Equal(1, len(lns), "one listener", t)
+	assert.Equal(ln.Addr().String(), lns[0].Addr().String(), "listener address", t)
+	assert.Equal("", os.Getenv("LISTEN_FDS"), "environment is cleared", t)
+	lns[0].Close()
+
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	lns, err = Listeners()
+	assert.NilError(err, "listeners for another process", t)
+	assert.Equal(0, len(lns), "sockets meant for another process are ignored", t)
+}
+
+func TestNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	assert.NilError(Notify("READY=1"), "no-op without a notify socket", t)
+
+	var dir, err = ioutil.TempDir("", "rais-systemd")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	var sock = filepath.Join(dir, "notify.sock")
+	var conn *net.UnixConn
+	conn, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	assert.NilError(err, "listening for notifications", t)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", sock)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	assert.NilError(Notify("READY=1"), "sending notification", t)
+
+	var buf = make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var n int
+	n, err = conn.Read(buf)
+	assert.NilError(err, "reading notification", t)
+	assert.Equal("READY=1", string(buf[:n]), "notification", t)
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	assert.Equal(time.Duration(0), WatchdogInterval(), "disabled", t)
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	assert.Equal(30*time.Second, WatchdogInterval(), "enabled", t)
+
+	os.Setenv("WATCHDOG_PID", "1")
+	assert.Equal(time.Duration(0), WatchdogInterval(), "meant for another process", t)
+}
+
+func TestJournal(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-systemd")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	defer func(s string) { journalSocket = s }(journalSocket)
+	journalSocket = filepath.Join(dir, "journal.sock")
+
+	var conn *net.UnixConn
+	conn, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	assert.NilError(err, "listening for entries", t)
+	defer conn.Close()
+
+	var j *Journal
+	j, err = OpenJournal()
+	assert.NilError(err, "opening journal", t)
+	defer j.Close()
+	assert.NilError(j.Send(map[string]string{"PRIORITY": "6", "MESSAGE": "two\nlines"}), "sending entry", t)
+
+	var buf = make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var n int
+	n, err = conn.Read(buf)
+	assert.NilError(err, "reading entry", t)
+	assert.Equal("MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nPRIORITY=6\n", string(buf[:n]), "entry", t)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/jwt"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// jwtLeeway allows for clock skew between RAIS and the token issuer
+const jwtLeeway = time.Minute
+
+// unconstrained is the constraint used when a request's grant doesn't limit
+// derivative sizes
+var unconstrained = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
+
+// jwtRule grants access to images whose IDs start with one of Prefixes (or
+// all images if there are no prefixes) for requests it matches.  A rule
+// matches requests whose token has the named Claim with one of Values, or
+// with any value if Values is empty.  Rules without a Claim match any valid
+// token, and Anonymous rules match requests with no token at all.  Nonzero
+// maximums limit the size of derivatives the rule allows.
+type jwtRule struct {
+	Claim          string
+	Values         []string
+	Anonymous      bool
+	Prefixes       []string
+	ImageMaxWidth  int
+	ImageMaxHeight int
+	ImageMaxArea   int64
+}
+
+// loadJWTRules reads rules from a TOML file:
+//
+//	[[Rule]]
+//	Claim = "groups"
+//	Values = ["library-staff"]
+//
+//	[[Rule]]
+//	Anonymous = true
+//	Prefixes = ["public/"]
+//	ImageMaxWidth = 1024
+//	ImageMaxHeight = 1024
+func loadJWTRules(fname string) ([]*jwtRule, error) {
+	var data struct {
+		Rule []*jwtRule
+	}
+	var _, err = toml.DecodeFile(fname, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range data.Rule {
+		if r.Anonymous && (r.Claim != "" || len(r.Values) > 0) {
+			return nil, fmt.Errorf("rule %d: anonymous rules can't require claims", i+1)
+		}
+		if r.Claim == "" && len(r.Values) > 0 {
+			return nil, fmt.Errorf("rule %d: values require a claim", i+1)
+		}
+		if r.ImageMaxWidth < 0 || r.ImageMaxHeight < 0 || r.ImageMaxArea < 0 {
+			return nil, fmt.Errorf("rule %d: maximums must not be negative", i+1)
+		}
+	}
+	return data.Rule, nil
+}
+
+// matches returns true if r applies to a request with the given claims, which
+// are nil for anonymous requests
+func (r *jwtRule) matches(claims jwt.Claims) bool {
+	if claims == nil || r.Anonymous {
+		return claims == nil && r.Anonymous
+	}
+	if r.Claim == "" {
+		return true
+	}
+
+	var have = claims.Strings(r.Claim)
+	if len(r.Values) == 0 {
+		return len(have) > 0
+	}
+	for _, v := range r.Values {
+		for _, h := range have {
+			if v == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// covers returns true if r grants access to id
+func (r *jwtRule) covers(id iiif.ID) bool {
+	if len(r.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range r.Prefixes {
+		if strings.HasPrefix(string(id), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// jwtAuth validates bearer tokens on IIIF requests, and works out which
+// rules apply to each request.  Without any rules, a valid token grants
+// access to everything and requests without one are refused.
+type jwtAuth struct {
+	validator *jwt.Validator
+	rules     []*jwtRule
+}
+
+func newJWTAuth(v *jwt.Validator, rules []*jwtRule) *jwtAuth {
+	if len(rules) == 0 {
+		rules = []*jwtRule{{}}
+	}
+	return &jwtAuth{validator: v, rules: rules}
+}
+
+// authGrant is the set of rules which matched a request
+type authGrant struct {
+	anonymous bool
+	rules     []*jwtRule
+}
+
+type authGrantKey struct{}
+
+// bearerToken returns the token from req's Authorization header, if any
+func bearerToken(req *http.Request) string {
+	var h = req.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// wrap validates the request's token, if it has one, and stores its grant in
+// the request context for authorize.  Invalid tokens are refused outright
+// rather than treated as anonymous, so clients know to get a new one.
+func (ja *jwtAuth) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		var claims jwt.Claims
+		var token = bearerToken(req)
+		if token != "" {
+			var err error
+			claims, err = ja.validator.Validate(token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		var g = &authGrant{anonymous: claims == nil}
+		for _, r := range ja.rules {
+			if r.matches(claims) {
+				g.rules = append(g.rules, r)
+			}
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), authGrantKey{}, g)))
+	})
+}
+
+// authorize returns the largest derivative the request in ctx may get of id.
+// If the request may not see id at all, an error is written to w and ok is
+// false.  Requests which didn't go through jwtAuth, such as cache warming,
+// are unconstrained.
+func authorize(ctx context.Context, w http.ResponseWriter, id iiif.ID) (max img.Constraint, ok bool) {
+	max, ok = grantLimit(ctx, id)
+	if ok {
+		return max, true
+	}
+
+	var g = grantFrom(ctx)
+	if g.anonymous {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Authentication is required for this image", http.StatusUnauthorized)
+	} else {
+		http.Error(w, "Access to this image is forbidden", http.StatusForbidden)
+	}
+	return max, false
+}
+
+// grantFrom returns the grant jwtAuth stored in ctx, if any
+func grantFrom(ctx context.Context) *authGrant {
+	var g, _ = ctx.Value(authGrantKey{}).(*authGrant)
+	return g
+}
+
+// grantLimit is authorize without the error response: it returns the largest
+// derivative the request in ctx may get of id, and false if it may not see
+// id at all
+func grantLimit(ctx context.Context, id iiif.ID) (max img.Constraint, ok bool) {
+	var g = grantFrom(ctx)
+	if g == nil {
+		return unconstrained, true
+	}
+
+	// The most generous matching rule wins
+	for _, r := range g.rules {
+		if !r.covers(id) {
+			continue
+		}
+		ok = true
+		var c = r.constraint()
+		if c.Width > max.Width {
+			max.Width = c.Width
+		}
+		if c.Height > max.Height {
+			max.Height = c.Height
+		}
+		if c.Area > max.Area {
+			max.Area = c.Area
+		}
+	}
+	return max, ok
+}
+
+// visible returns true if the request in ctx may see id at any size.  Unlike
+// authorize, it doesn't write an error, so listings can just leave id out.
+func visible(ctx context.Context, id iiif.ID) bool {
+	var _, ok = grantLimit(ctx, id)
+	return ok
+}
+
+// constraint returns r's maximums, with zeroes meaning no limit
+func (r *jwtRule) constraint() img.Constraint {
+	var c = unconstrained
+	if r.ImageMaxWidth > 0 {
+		c.Width = r.ImageMaxWidth
+	}
+	if r.ImageMaxHeight > 0 {
+		c.Height = r.ImageMaxHeight
+	}
+	if r.ImageMaxArea > 0 {
+		c.Area = r.ImageMaxArea
+	}
+	return c
+}
+
+// constrainInfo applies max on top of any maximums info already has, so that
+// both the info response and the image request it's used for respect it
+func constrainInfo(info *iiif.Info, max img.Constraint) {
+	if max == unconstrained {
+		return
+	}
+
+	var p = &info.Profile
+	if p.MaxWidth > 0 && p.MaxWidth < max.Width {
+		max.Width = p.MaxWidth
+	}
+	if p.MaxHeight > 0 && p.MaxHeight < max.Height {
+		max.Height = p.MaxHeight
+	}
+	if p.MaxArea > 0 && p.MaxArea < max.Area {
+		max.Area = p.MaxArea
+	}
+	if !max.SmallerThanAny(info.Width, info.Height) {
+		return
+	}
+
+	p.MaxWidth, p.MaxHeight, p.MaxArea = max.Width, max.Height, max.Area
+	var sizes []iiif.InfoSize
+	for _, s := range info.Sizes {
+		if !max.SmallerThanAny(s.Width, s.Height) {
+			sizes = append(sizes, s)
+		}
+	}
+	info.Sizes = sizes
+}
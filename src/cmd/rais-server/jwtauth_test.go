@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/jwt"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+type testKeys struct{ key *ecdsa.PrivateKey }
+
+func (tk testKeys) Key(kid string) (crypto.PublicKey, error) {
+	return &tk.key.PublicKey, nil
+}
+
+// sign returns an ES256 token for claims
+func (tk testKeys) sign(claims jwt.Claims) string {
+	var b64 = base64.RawURLEncoding
+	var c, _ = json.Marshal(claims)
+	var signed = b64.EncodeToString([]byte(`{"alg":"ES256"}`)) + "." + b64.EncodeToString(c)
+	var digest = sha256.Sum256([]byte(signed))
+	var r, s, _ = ecdsa.Sign(rand.Reader, tk.key, digest[:])
+	var sig = make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestJWTRuleMatches(t *testing.T) {
+	var staff = &jwtRule{Claim: "groups", Values: []string{"staff"}}
+	var anyGroup = &jwtRule{Claim: "groups"}
+	var anyToken = &jwtRule{}
+	var anon = &jwtRule{Anonymous: true}
+
+	var claims = jwt.Claims{"groups": []interface{}{"students", "staff"}}
+	assert.True(staff.matches(claims), "value in list", t)
+	assert.True(anyGroup.matches(claims), "claim with any value", t)
+	assert.True(anyToken.matches(claims), "any token", t)
+	assert.False(anon.matches(claims), "anonymous rules don't match tokens", t)
+
+	claims = jwt.Claims{"groups": "students"}
+	assert.False(staff.matches(claims), "wrong value", t)
+	assert.False(anyGroup.matches(jwt.Claims{}), "missing claim", t)
+
+	assert.True(anon.matches(nil), "anonymous rule", t)
+	assert.False(anyToken.matches(nil), "token rules don't match anonymous requests", t)
+}
+
+func TestJWTAuthorize(t *testing.T) {
+	var keys = testKeys{key: mustKey(t)}
+	var ja = newJWTAuth(&jwt.Validator{Keys: keys, Audience: "rais"}, []*jwtRule{
+		{Claim: "groups", Values: []string{"staff"}},
+		{Prefixes: []string{"restricted/"}, ImageMaxWidth: 2000, ImageMaxHeight: 2000},
+		{Anonymous: true, Prefixes: []string{"public/"}, ImageMaxWidth: 500},
+	})
+
+	var limit, code int
+	var h = ja.wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var max, ok = authorize(req.Context(), w, iiif.ID(req.URL.Query().Get("id")))
+		if ok {
+			limit = max.Width
+		}
+	}))
+	var try = func(id, token string) {
+		var req = httptest.NewRequest("GET", "/iiif/x?id="+url.QueryEscape(id), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		var w = httptest.NewRecorder()
+		limit = -1
+		h.ServeHTTP(w, req)
+		code = w.Code
+	}
+
+	var exp = time.Now().Add(time.Hour).Unix()
+	var staff = keys.sign(jwt.Claims{"aud": "rais", "exp": exp, "groups": []string{"staff"}})
+	var student = keys.sign(jwt.Claims{"aud": "rais", "exp": exp, "groups": "students"})
+
+	try("public/a.jp2", "")
+	assert.Equal(500, limit, "anonymous requests get anonymous rules", t)
+	try("restricted/a.jp2", "")
+	assert.Equal(http.StatusUnauthorized, code, "anonymous requests can't see restricted images", t)
+	try("restricted/a.jp2", student)
+	assert.Equal(2000, limit, "any token can see restricted images at reduced size", t)
+	try("secret/a.jp2", student)
+	assert.Equal(http.StatusForbidden, code, "tokens without a matching rule are forbidden", t)
+	try("restricted/a.jp2", staff)
+	assert.Equal(unconstrained.Width, limit, "the most generous rule wins", t)
+	try("public/a.jp2", keys.sign(jwt.Claims{"aud": "other", "exp": exp}))
+	assert.Equal(http.StatusUnauthorized, code, "invalid tokens are refused", t)
+	try("public/a.jp2", keys.sign(jwt.Claims{"aud": "rais", "exp": time.Now().Add(-time.Hour).Unix()}))
+	assert.Equal(http.StatusUnauthorized, code, "expired tokens are refused", t)
+}
+
+func TestJWTConstrainedInfo(t *testing.T) {
+	var keys = testKeys{key: mustKey(t)}
+	var ja = newJWTAuth(&jwt.Validator{Keys: keys}, []*jwtRule{
+		{Anonymous: true, Prefixes: []string{"docker/"}, ImageMaxWidth: 400, ImageMaxHeight: 400},
+	})
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.FeatureSet = iiif.FeatureSet2()
+	h.BaseURL, _ = url.Parse("http://example.com")
+	var handler = ja.wrap(http.HandlerFunc(h.IIIFRoute))
+
+	var w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/info.json", nil))
+	assert.Equal(http.StatusOK, w.Code, "info request is allowed", t)
+	var data iiif.Info
+	json.Unmarshal(w.Body.Bytes(), &data)
+	assert.Equal(800, data.Width, "info has the real width", t)
+	assert.Equal(400, data.Profile.MaxWidth, "info has the rule's max width", t)
+	assert.Equal("Authorization", w.Header().Get("Vary"), "responses vary by token", t)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/800,/0/default.jpg", nil))
+	assert.Equal(http.StatusNotImplemented, w.Code, "images larger than the rule allows are refused like any oversized request", t)
+}
+
+// grayDecoder is a blank image of any size, given by its file name
+// ("200x100.gray"), which decodes to whatever size it's asked for
+type grayDecoder struct {
+	w, h, rw, rh int
+	crop         image.Rectangle
+}
+
+func decodeGray(path string) (img.Decoder, error) {
+	var d = &grayDecoder{}
+	fmt.Sscanf(filepath.Base(path), "%dx%d.gray", &d.w, &d.h)
+	return d, nil
+}
+
+func (d *grayDecoder) DecodeImage() (image.Image, error) {
+	var w, h = d.rw, d.rh
+	if w == 0 || h == 0 {
+		w, h = d.crop.Dx(), d.crop.Dy()
+	}
+	return image.NewGray(image.Rect(0, 0, w, h)), nil
+}
+func (d *grayDecoder) GetWidth() int             { return d.w }
+func (d *grayDecoder) GetHeight() int            { return d.h }
+func (d *grayDecoder) GetTileWidth() int         { return 0 }
+func (d *grayDecoder) GetTileHeight() int        { return 0 }
+func (d *grayDecoder) GetLevels() int            { return 1 }
+func (d *grayDecoder) SetCrop(r image.Rectangle) { d.crop = r }
+func (d *grayDecoder) SetResizeWH(w, h int)      { d.rw, d.rh = w, h }
+
+func TestJWTLimitedNotCached(t *testing.T) {
+	var dir = t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "200x100.gray"), nil, 0644)
+	img.RegisterNamedDecoder(img.NamedDecoder{Name: "gray", Extensions: []string{".gray"}, Decode: decodeGray})
+
+	var oldCache, oldPolicy = tileCache, tilePolicy
+	defer func() { tileCache, tilePolicy = oldCache, oldPolicy }()
+	tileCache = newByteCache(1<<20, 0)
+	tilePolicy = &tileCachePolicy{formats: map[iiif.Format]bool{iiif.FmtJPG: true}, key: keyRequest}
+
+	var ja = newJWTAuth(&jwt.Validator{Keys: testKeys{key: mustKey(t)}}, []*jwtRule{
+		{Anonymous: true, ImageMaxWidth: 50},
+	})
+	var h = NewImageHandler(dir, "/iiif")
+	h.FeatureSet = iiif.FeatureSet2()
+	h.BaseURL, _ = url.Parse("http://example.com")
+
+	var w = httptest.NewRecorder()
+	ja.wrap(http.HandlerFunc(h.IIIFRoute)).ServeHTTP(w, httptest.NewRequest("GET", "/iiif/200x100.gray/full/max/0/default.jpg", nil))
+	assert.Equal(http.StatusOK, w.Code, "limited request succeeds", t)
+	assert.Equal(0, tileCache.Len(), "limited derivatives aren't cached", t)
+	assert.Equal("private", w.Header().Get("Cache-Control"), "limited derivatives are private", t)
+	var limitedTag = w.Header().Get("ETag")
+
+	w = httptest.NewRecorder()
+	h.IIIFRoute(w, httptest.NewRequest("GET", "/iiif/200x100.gray/full/max/0/default.jpg", nil))
+	assert.Equal(http.StatusOK, w.Code, "unlimited request succeeds", t)
+	assert.Equal(1, tileCache.Len(), "unlimited derivatives are cached", t)
+	assert.Equal("", w.Header().Get("Cache-Control"), "unlimited derivatives aren't private", t)
+	var fullTag = w.Header().Get("ETag")
+	assert.True(limitedTag != "" && limitedTag != fullTag, "the limit is part of the ETag", t)
+
+	var req = httptest.NewRequest("GET", "/iiif/200x100.gray/full/max/0/default.jpg", nil)
+	req.Header.Set("If-None-Match", fullTag)
+	var cw = httptest.NewRecorder()
+	ja.wrap(http.HandlerFunc(h.IIIFRoute)).ServeHTTP(cw, req)
+	assert.Equal(http.StatusOK, cw.Code, "the full image's ETag doesn't validate a limited one", t)
+	var i, _, err = image.Decode(w.Body)
+	assert.NilError(err, "decoding the response", t)
+	assert.Equal(200, i.Bounds().Dx(), "unlimited requests get the full size", t)
+}
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	var k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+	return k
+}
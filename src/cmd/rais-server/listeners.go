@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"rais/src/cmd/rais-server/internal/servers"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Route groups a listener can serve
+const (
+	routesIIIF   = "iiif"
+	routesAdmin  = "admin"
+	routesHealth = "health"
+	routesPeer   = "peer"
+)
+
+var routeGroups = []string{routesIIIF, routesAdmin, routesHealth, routesPeer}
+
+// Listener is an HTTP server RAIS runs and the groups of routes it serves:
+// "iiif" for image requests, "admin" for the /admin endpoints, "health" for
+// /healthz and /readyz, and "peer" for other RAIS instances sharing a cache.
+// Each listener may have its own TLS setup.  Addresses starting with "unix:"
+// are unix socket paths, and FastCGI listeners speak FastCGI to a local web
+// server instead of HTTP.  ACME listeners serve HTTPS with certificates for
+// ACMEDomains, which RAIS gets and renews itself.
+type Listener struct {
+	Name    string
+	Address string
+	TLSCert string
+	TLSKey  string
+	ACME    bool
+	FastCGI bool
+	Routes  []string
+}
+
+// defaultListeners returns the listeners described by the Address,
+// AdminAddress, TLSCert, TLSKey, and ACMEDomains settings
+func defaultListeners(cfg *Config) []*Listener {
+	return []*Listener{
+		{Name: "RAIS", Address: cfg.Address, TLSCert: cfg.TLSCert, TLSKey: cfg.TLSKey, ACME: len(cfg.ACMEDomains) > 0,
+			FastCGI: cfg.FastCGI, Routes: []string{routesIIIF}},
+		{Name: "RAIS Admin", Address: cfg.AdminAddress, Routes: []string{routesAdmin, routesHealth, routesPeer}},
+	}
+}
+
+// loadListeners reads listeners from a TOML file:
+//
+//	[[Listener]]
+//	Name = "Public"
+//	Address = ":443"
+//	TLSCert = "/etc/ssl/certs/rais.pem"
+//	TLSKey = "/etc/ssl/private/rais.key"
+//	Routes = ["iiif"]
+//
+//	[[Listener]]
+//	Name = "Public ACME"
+//	Address = ":8443"
+//	ACME = true
+//	Routes = ["iiif"]
+//
+//	[[Listener]]
+//	Name = "Internal"
+//	Address = "unix:/run/rais/admin.sock"
+//	Routes = ["admin", "health"]
+func loadListeners(fname string) ([]*Listener, error) {
+	var data struct {
+		Listener []*Listener
+	}
+	var _, err = toml.DecodeFile(fname, &data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Listener) == 0 {
+		return nil, fmt.Errorf("no listeners defined")
+	}
+
+	var seen = make(map[string]bool)
+	for i, l := range data.Listener {
+		if l.Name == "" {
+			l.Name = fmt.Sprintf("Listener %d", i+1)
+		}
+		err = l.validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", l.Name, err)
+		}
+		if seen[l.Address] {
+			return nil, fmt.Errorf("%s: address %q is used by another listener", l.Name, l.Address)
+		}
+		seen[l.Address] = true
+	}
+	return data.Listener, nil
+}
+
+func (l *Listener) validate() error {
+	if l.Address == "" || l.Address == servers.UnixPrefix {
+		return fmt.Errorf("address is required")
+	}
+	if len(l.Routes) == 0 {
+		return fmt.Errorf("at least one route group is required")
+	}
+	for _, r := range l.Routes {
+		if !validRouteGroup(r) {
+			return fmt.Errorf("unknown route group %q (must be one of %q)", r, routeGroups)
+		}
+	}
+	if (l.TLSCert == "") != (l.TLSKey == "") {
+		return fmt.Errorf("TLS requires both a certificate and a key file")
+	}
+	if l.FastCGI && (l.TLSCert != "" || l.ACME) {
+		return fmt.Errorf("FastCGI listeners can't use TLS")
+	}
+	if l.ACME && l.TLSCert != "" {
+		return fmt.Errorf("ACME listeners can't also use TLSCert and TLSKey")
+	}
+	for _, fname := range []string{l.TLSCert, l.TLSKey} {
+		if fname == "" {
+			continue
+		}
+		if _, err := os.Stat(fname); err != nil {
+			return fmt.Errorf("unable to read TLS file %q: %s", fname, err)
+		}
+	}
+	return nil
+}
+
+// unixSocket returns true if l listens on a unix socket
+func (l *Listener) unixSocket() bool {
+	return strings.HasPrefix(l.Address, servers.UnixPrefix)
+}
+
+func validRouteGroup(name string) bool {
+	for _, g := range routeGroups {
+		if g == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serves returns true if l serves the given route group
+func (l *Listener) serves(group string) bool {
+	for _, r := range l.Routes {
+		if r == group {
+			return true
+		}
+	}
+	return false
+}
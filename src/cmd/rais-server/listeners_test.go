@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func writeListeners(t *testing.T, data string) string {
+	var dir, err = ioutil.TempDir("", "rais-listeners")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	var fname = filepath.Join(dir, "listeners.toml")
+	err = ioutil.WriteFile(fname, []byte(data), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write listeners file: %s", err)
+	}
+	return fname
+}
+
+func TestLoadListeners(t *testing.T) {
+	var ls, err = loadListeners(writeListeners(t, `
+[[Listener]]
+Address = ":8080"
+Routes = ["iiif"]
+
+[[Listener]]
+Name = "Internal"
+Address = "127.0.0.1:8081"
+Routes = ["admin", "health"]
+`))
+	assert.NilError(err, "loading valid listeners", t)
+	assert.Equal(2, len(ls), "both listeners loaded", t)
+	assert.Equal("Listener 1", ls[0].Name, "unnamed listeners get a name", t)
+	assert.True(ls[0].serves(routesIIIF), "public listener serves IIIF", t)
+	assert.False(ls[0].serves(routesAdmin), "public listener doesn't serve admin", t)
+	assert.True(ls[1].serves(routesHealth), "internal listener serves health checks", t)
+
+	var bad = map[string]string{
+		"no listeners":      ``,
+		"missing address":   "[[Listener]]\nRoutes = [\"iiif\"]",
+		"no routes":         "[[Listener]]\nAddress = \":8080\"",
+		"unknown group":     "[[Listener]]\nAddress = \":8080\"\nRoutes = [\"metrics\"]",
+		"cert without key":  "[[Listener]]\nAddress = \":8080\"\nRoutes = [\"iiif\"]\nTLSCert = \"/dev/null\"",
+		"unreadable cert":   "[[Listener]]\nAddress = \":8080\"\nRoutes = [\"iiif\"]\nTLSCert = \"/nope\"\nTLSKey = \"/dev/null\"",
+		"FastCGI with TLS":  "[[Listener]]\nAddress = \":8080\"\nRoutes = [\"iiif\"]\nFastCGI = true\nTLSCert = \"/dev/null\"\nTLSKey = \"/dev/null\"",
+		"FastCGI with ACME": "[[Listener]]\nAddress = \":8080\"\nRoutes = [\"iiif\"]\nFastCGI = true\nACME = true",
+		"ACME with a cert":  "[[Listener]]\nAddress = \":8080\"\nRoutes = [\"iiif\"]\nACME = true\nTLSCert = \"/dev/null\"\nTLSKey = \"/dev/null\"",
+		"empty socket path": "[[Listener]]\nAddress = \"unix:\"\nRoutes = [\"iiif\"]",
+		"duplicate address": "[[Listener]]\nAddress = \":8080\"\nRoutes = [\"iiif\"]\n[[Listener]]\nAddress = \":8080\"\nRoutes = [\"admin\"]",
+	}
+	for name, data := range bad {
+		_, err = loadListeners(writeListeners(t, data))
+		assert.True(err != nil, name+" is an error", t)
+	}
+}
+
+func TestDefaultListeners(t *testing.T) {
+	var ls = defaultListeners(&Config{Address: ":1", AdminAddress: ":2", TLSCert: "c", TLSKey: "k"})
+	assert.Equal(2, len(ls), "public and admin listeners", t)
+	assert.Equal("c", ls[0].TLSCert, "TLS applies to the public listener", t)
+	assert.Equal("", ls[1].TLSCert, "TLS doesn't apply to the admin listener", t)
+	assert.True(ls[1].serves(routesAdmin) && ls[1].serves(routesHealth), "admin listener serves admin and health routes", t)
+
+	ls = defaultListeners(&Config{Address: ":1", AdminAddress: ":2", ACMEDomains: []string{"iiif.example.edu"}})
+	assert.True(ls[0].ACME, "ACME applies to the public listener", t)
+	assert.False(ls[1].ACME, "ACME doesn't apply to the admin listener", t)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+)
+
+// loadTracker keeps track of how busy this RAIS instance is so external load
+// balancers can weight traffic or stop sending it altogether
+type loadTracker struct {
+	inFlight int64
+	draining int32
+	capacity int
+}
+
+var load = &loadTracker{capacity: runtime.NumCPU()}
+
+// loadReport is the data sent to clients asking for load information
+type loadReport struct {
+	InFlight int64   `json:"inFlight"`
+	Capacity int     `json:"capacity"`
+	Load     float64 `json:"load"`
+	Weight   int     `json:"weight"`
+	Draining bool    `json:"draining"`
+}
+
+// start records that an image operation has begun; the returned function must
+// be called when it's done
+func (lt *loadTracker) start() func() {
+	atomic.AddInt64(&lt.inFlight, 1)
+	return func() { atomic.AddInt64(&lt.inFlight, -1) }
+}
+
+func (lt *loadTracker) isDraining() bool {
+	return atomic.LoadInt32(&lt.draining) == 1
+}
+
+func (lt *loadTracker) setDraining(d bool) {
+	var v int32
+	if d {
+		v = 1
+	}
+	atomic.StoreInt32(&lt.draining, v)
+}
+
+// report computes the current load and a 0-100 weight suitable for load
+// balancers: 100 when idle, approaching 1 as we near capacity, and 0 only
+// when draining
+func (lt *loadTracker) report() loadReport {
+	var r = loadReport{
+		InFlight: atomic.LoadInt64(&lt.inFlight),
+		Capacity: lt.capacity,
+		Draining: lt.isDraining(),
+	}
+	r.Load = float64(r.InFlight) / float64(r.Capacity)
+
+	switch {
+	case r.Draining:
+		r.Weight = 0
+	case r.Load >= 1:
+		r.Weight = 1
+	default:
+		r.Weight = 100 - int(r.Load*100)
+	}
+
+	return r
+}
+
+// ServeHTTP reports load as JSON, or in HAProxy's agent-check format if the
+// "format" query parameter is "haproxy"
+func (lt *loadTracker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var r = lt.report()
+	w.Header().Set("Cache-Control", "no-store")
+
+	if req.URL.Query().Get("format") == "haproxy" {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.Draining {
+			fmt.Fprintln(w, "drain")
+			return
+		}
+		fmt.Fprintf(w, "up %d%%\n", r.Weight)
+		return
+	}
+
+	var out, err = json.Marshal(r)
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// adminDrain turns drain mode on or off.  Like cache purging, this must be a
+// POST since it changes server behavior.
+func adminDrain(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch req.PostFormValue("drain") {
+	case "true":
+		load.setDraining(true)
+		Logger.Infof("Drain mode enabled")
+	case "false":
+		load.setDraining(false)
+		Logger.Infof("Drain mode disabled")
+	default:
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"rais/src/cmd/rais-server/internal/systemd"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// serverComponent is the name of RAIS's own messages in LogLevels
+const serverComponent = "server"
+
+// Valid LogOutput values; anything else is a file path
+const (
+	logStderr   = "stderr"
+	logStdout   = "stdout"
+	logSyslog   = "syslog"
+	logJournald = "journald"
+)
+
+// logSink is a destination for log messages from every component
+type logSink interface {
+	write(level logger.LogLevel, component, message string)
+}
+
+// componentLog is a logger.Loggable which drops a component's messages below
+// its level and sends the rest to the configured sink
+type componentLog struct {
+	name  string
+	level logger.LogLevel
+	sink  logSink
+}
+
+// Log implements logger.Loggable
+func (cl *componentLog) Log(level logger.LogLevel, message string) {
+	if level >= cl.level {
+		cl.sink.write(level, cl.name, message)
+	}
+}
+
+// logging holds the sink and levels used by componentLogger
+var logging struct {
+	sink   logSink
+	level  logger.LogLevel
+	levels map[string]logger.LogLevel
+}
+
+// setupLogging opens the configured log output and sets up the server's
+// logger.  Errors are fatal, but there's no logger to report them with yet,
+// so they're printed like config errors.
+func setupLogging() {
+	var sink, err = newLogSink(conf.LogOutput, conf.LogMaxSize, conf.LogMaxBackups)
+	if err != nil {
+		fmt.Printf("ERROR: unable to set up LogOutput %q: %s\n", conf.LogOutput, err)
+		os.Exit(1)
+	}
+	logging.sink = sink
+	logging.level = conf.LogLevel
+	logging.levels = conf.LogLevels
+	Logger = componentLogger(serverComponent)
+}
+
+// componentLogger returns a logger for the named component, such as
+// "openjpeg" or a plugin's name, using the component's level from LogLevels,
+// or LogLevel if it doesn't have one
+func componentLogger(name string) *logger.Logger {
+	var level, ok = logging.levels[name]
+	if !ok {
+		level = logging.level
+	}
+	var sink = logging.sink
+	if sink == nil {
+		sink = &writerSink{w: os.Stderr}
+	}
+	return &logger.Logger{Loggable: &componentLog{name: name, level: level, sink: sink}}
+}
+
+// parseLogLevels reads a list of "component:LEVEL" pairs
+func parseLogLevels(val string) (map[string]logger.LogLevel, error) {
+	var m = make(map[string]logger.LogLevel)
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		var parts = strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%q must look like \"component:LEVEL\"", pair)
+		}
+
+		var name = strings.TrimSpace(parts[0])
+		var level = logger.LogLevelFromString(strings.ToUpper(strings.TrimSpace(parts[1])))
+		if level == logger.Invalid {
+			return nil, fmt.Errorf("invalid level for %q (must be DEBUG, INFO, WARN, ERROR, or CRIT)", name)
+		}
+		m[name] = level
+	}
+	return m, nil
+}
+
+func newLogSink(output string, maxSize int64, backups int) (logSink, error) {
+	switch output {
+	case "", logStderr:
+		return &writerSink{w: os.Stderr}, nil
+	case logStdout:
+		return &writerSink{w: os.Stdout}, nil
+	case logSyslog:
+		return newSyslogSink()
+	case logJournald:
+		var j, err = systemd.OpenJournal()
+		if err != nil {
+			return nil, err
+		}
+		return &journalSink{j: j}, nil
+	}
+
+	var f, err = openRotatingFile(output, maxSize, backups)
+	if err != nil {
+		return nil, err
+	}
+	return &writerSink{w: f}, nil
+}
+
+// writerSink writes messages as lines of text in the same format RAIS has
+// always used.  RAIS's own messages are labeled with the binary's name, and
+// other components' with their names.
+type writerSink struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+var appName = filepath.Base(os.Args[0])
+
+func (ws *writerSink) write(level logger.LogLevel, component, message string) {
+	var name = component
+	if name == serverComponent {
+		name = appName
+	}
+	var line = fmt.Sprintf("%s - %s - %s - %s\n", time.Now().Format(logger.TimeFormat), name, level, message)
+
+	ws.m.Lock()
+	io.WriteString(ws.w, line)
+	ws.m.Unlock()
+}
+
+// syslogPriorities maps log levels to syslog's (and journald's) priorities
+var syslogPriorities = map[logger.LogLevel]int{
+	logger.Debug: 7,
+	logger.Info:  6,
+	logger.Warn:  4,
+	logger.Err:   3,
+	logger.Crit:  2,
+}
+
+// journalSink sends messages to journald with their priority, and the
+// component in the RAIS_COMPONENT field, so they can be filtered with, e.g.,
+// "journalctl RAIS_COMPONENT=openjpeg"
+type journalSink struct {
+	j *systemd.Journal
+}
+
+func (js *journalSink) write(level logger.LogLevel, component, message string) {
+	js.j.Send(map[string]string{
+		"MESSAGE":           message,
+		"PRIORITY":          strconv.Itoa(syslogPriorities[level]),
+		"SYSLOG_IDENTIFIER": appName,
+		"RAIS_COMPONENT":    component,
+	})
+}
+
+// rotatingFile appends to a file, renaming it and starting a new one when it
+// would grow past maxSize.  Up to backups old files are kept, named with
+// ".1" for the newest, ".2" for the next, and so on.  A zero maxSize means
+// the file is never rotated.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	var rf = &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	return rf, rf.open()
+}
+
+func (rf *rotatingFile) open() error {
+	var f, err = os.OpenFile(rf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	var info os.FileInfo
+	info, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+// Write implements io.Writer.  Callers must not write concurrently.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		var err = rf.rotate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to rotate log file %q: %s\n", rf.path, err)
+		}
+	}
+	var n, err = rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	for i := rf.backups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	var err error
+	if rf.backups > 0 {
+		err = os.Rename(rf.path, rf.path+".1")
+	} else {
+		err = os.Remove(rf.path)
+	}
+
+	// Whether or not the old file could be moved, we need something to write
+	// to: at worst, the log keeps growing
+	var openErr = rf.open()
+	if err == nil {
+		err = openErr
+	}
+	return err
+}
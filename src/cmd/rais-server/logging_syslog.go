@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log/syslog"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// syslogSink sends messages to the local syslog daemon, prefixed with the
+// component's name
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink() (logSink, error) {
+	var w, err = syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, appName)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (ss *syslogSink) write(level logger.LogLevel, component, message string) {
+	message = component + ": " + message
+	switch level {
+	case logger.Debug:
+		ss.w.Debug(message)
+	case logger.Info:
+		ss.w.Info(message)
+	case logger.Warn:
+		ss.w.Warning(message)
+	case logger.Err:
+		ss.w.Err(message)
+	default:
+		ss.w.Crit(message)
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import "errors"
+
+func newSyslogSink() (logSink, error) {
+	return nil, errors.New("syslog isn't available on Windows")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+type fakeSink struct {
+	messages []string
+}
+
+func (fs *fakeSink) write(level logger.LogLevel, component, message string) {
+	fs.messages = append(fs.messages, component+" "+level.String()+" "+message)
+}
+
+func TestParseLogLevels(t *testing.T) {
+	var levels, err = parseLogLevels(" openjpeg:warn, s3-images:DEBUG ,")
+	assert.NilError(err, "valid levels", t)
+	assert.Equal(2, len(levels), "two components", t)
+	assert.Equal(logger.Warn, levels["openjpeg"], "openjpeg level", t)
+	assert.Equal(logger.Debug, levels["s3-images"], "s3-images level", t)
+
+	_, err = parseLogLevels("openjpeg")
+	assert.True(err != nil, "missing level is an error", t)
+	_, err = parseLogLevels("openjpeg:LOUD")
+	assert.True(err != nil, "unknown level is an error", t)
+}
+
+func TestComponentLogger(t *testing.T) {
+	defer func(sink logSink, level logger.LogLevel, levels map[string]logger.LogLevel) {
+		logging.sink, logging.level, logging.levels = sink, level, levels
+	}(logging.sink, logging.level, logging.levels)
+
+	var sink = &fakeSink{}
+	logging.sink = sink
+	logging.level = logger.Info
+	logging.levels = map[string]logger.LogLevel{"openjpeg": logger.Err}
+
+	var l = componentLogger(serverComponent)
+	l.Debugf("hidden")
+	l.Infof("shown")
+	l = componentLogger("openjpeg")
+	l.Warnf("hidden")
+	l.Errorf("shown")
+
+	assert.Equal(2, len(sink.messages), "messages below each component's level are dropped", t)
+	assert.Equal("server INFO shown", sink.messages[0], "server message", t)
+	assert.Equal("openjpeg ERROR shown", sink.messages[1], "openjpeg message", t)
+}
+
+func TestRotatingFile(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-logging-")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var path = filepath.Join(dir, "rais.log")
+	var rf *rotatingFile
+	rf, err = openRotatingFile(path, 10, 2)
+	assert.NilError(err, "opening log", t)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = rf.Write([]byte(line))
+		assert.NilError(err, "writing "+line, t)
+	}
+	rf.f.Close()
+
+	var expected = map[string]string{"rais.log": "fourth\n", "rais.log.1": "third\n", "rais.log.2": "second\n"}
+	for name, content := range expected {
+		var data, err = ioutil.ReadFile(filepath.Join(dir, name))
+		assert.NilError(err, "reading "+name, t)
+		assert.Equal(content, string(data), name, t)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(os.IsNotExist(err), "only two backups are kept", t)
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"net/http"
-	"net/url"
+	"os"
+	"rais/src/cmd/rais-server/internal/decodeworker"
 	"rais/src/cmd/rais-server/internal/servers"
+	"rais/src/cmd/rais-server/internal/systemd"
 	"rais/src/iiif"
 	"rais/src/img"
+	"rais/src/jwt"
 	"rais/src/openjpeg"
 	"rais/src/plugins"
 	"rais/src/version"
@@ -13,8 +17,6 @@
 	"sync"
 	"time"
 
-	"github.com/BurntSushi/toml"
-	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/interrupts"
 	"github.com/uoregon-libraries/gopkg/logger"
 )
@@ -30,58 +32,200 @@
 
 func main() {
 	parseConf()
-	Logger = logger.New(logger.LogLevelFromString(viper.GetString("LogLevel")))
-	openjpeg.Logger = Logger
+	plugins.DryRun = checkConfigOnly
+	if os.Getenv(decodeworker.EnvWorker) != "" {
+		runDecodeWorker()
+		return
+	}
+	setupLogging()
+	setupDecoding()
+	var inherited = inheritedListeners()
+	if !openjpeg.SupportsRegionDecode() {
+		Logger.Warnf("openjpeg %s decodes entire tiles even for small regions; untiled JP2s "+
+			"will be slow to serve (upgrade to openjpeg 2.3 or later)", openjpeg.Version())
+	}
 
 	setupCaches()
-
-	var pluginList string
-
-	// Don't let the default plugin list be used if we have an explicit value of ""
-	if viper.IsSet("Plugins") {
-		pluginList = viper.GetString("Plugins")
+	setupQuarantine()
+	setupAccessLog(conf.AccessLog)
+	slowRequests.max = conf.SlowRequestCount
+	slowRequests.interval = conf.SlowRequestInterval
+	if len(conf.UsagePrefixes) > 0 || conf.UsageDelimiter != "" {
+		usage = newUsageAccounting(conf.UsagePrefixes, conf.UsageDelimiter)
+	}
+	if conf.LoadCapacity > 0 {
+		load.capacity = conf.LoadCapacity
+	}
+	encoders = newEncodePool(conf.EncodeWorkers)
+	if conf.DecodeSlots > 0 {
+		scheduler = newDecodeScheduler(conf.DecodeSlots, conf.DecodeWeights)
+	}
+	var pngCompression, _ = parsePNGCompression(conf.PNGCompression)
+	pngSettings = &pngOptions{
+		compression:      pngCompression,
+		bitDepth:         conf.PNGBitDepth,
+		paletteMaxPixels: conf.PNGPaletteMaxPixels,
 	}
+	setupPeers()
 
+	// Register our JP2 decoder before plugins are loaded so that a plugin can
+	// replace it by registering its own "openjpeg" decoder, or claim the .jp2
+	// extension for something else
+	img.RegisterNamedDecoder(jp2Decoder)
+
+	var pluginList = conf.Plugins
 	if pluginList == "" || pluginList == "-" {
 		Logger.Infof("No plugins will attempt to be loaded")
 	} else {
 		LoadPlugins(Logger, strings.Split(pluginList, ","))
 	}
+	if conf.ExternalPlugins != "" {
+		LoadExternalPlugins(Logger, strings.Split(conf.ExternalPlugins, ","))
+	}
+	logConfigWarnings()
+	mapDecoderExtensions()
 
-	// Register our JP2 decoder after plugins have been loaded to allow plugins
-	// to handle images - for instance, we might want a pyramidal tiff plugin or
-	// something one day
-	img.RegisterDecoder(decodeJP2)
+	ih := NewImageHandler(conf.TilePath, conf.IIIFWebPath)
+	ih.OverlayPath = conf.OverlayPath
+	ih.AllowUnescapedSlashes = conf.AllowUnescapedSlashes
+	ih.FeatureSet, _ = iiif.FeatureSetForLevel(conf.ComplianceLevel)
 
-	tilePath := viper.GetString("TilePath")
-	webPath := viper.GetString("IIIFWebPath")
-	if webPath == "" {
-		webPath = "/iiif"
+	// Formats beyond IIIF's basics are part of "everything RAIS supports", but
+	// a declared level means only that level's formats
+	if conf.ComplianceLevel == "max" {
+		ih.FeatureSet.AddFormat(iiif.FmtPDF)
+		for _, e := range img.Encoders() {
+			Logger.Infof("Enabling %q output via plugin encoder", e.Format)
+			ih.FeatureSet.AddFormat(e.Format)
+		}
+	}
+	ih.Maximums.Area = conf.ImageMaxArea
+	ih.Maximums.Width = conf.ImageMaxWidth
+	ih.Maximums.Height = conf.ImageMaxHeight
+	ih.TileGrid = conf.TileGrid
+	ih.Filter = conf.ResizeFilter
+	ih.Sharpen = conf.Sharpen
+	ih.Upscale = conf.Upscale
+	ih.Background = conf.BackgroundColor
+	ih.Layers = img.LayerPolicy{Layers: conf.QualityLayers, MaxSize: conf.QualityLayersMaxSize}
+	ih.Budget = img.DecodeBudget{Limit: conf.DecodeBudget, Rate: conf.DecodeBudgetRate * 1e6}
+	ih.GeoService = conf.GeoService
+	ih.Adjustments = conf.AdjustmentParams
+	ih.CanonicalRedirect = conf.CanonicalRedirect
+	if conf.MetadataService {
+		Logger.Infof("Serving embedded metadata fields %s", strings.Join(conf.MetadataFields, ", "))
+		ih.MetadataFields = conf.MetadataFields
+	}
+	if conf.ROIService {
+		Logger.Infof("Serving %s crop suggestions at roi.json", conf.ROIMethod)
+		setupROICache()
+		ih.ROIMethod = conf.ROIMethod
 	}
-	address := viper.GetString("Address")
-	adminAddress := viper.GetString("AdminAddress")
 
-	ih := NewImageHandler(tilePath, webPath)
-	ih.Maximums.Area = viper.GetInt64("ImageMaxArea")
-	ih.Maximums.Width = viper.GetInt("ImageMaxWidth")
-	ih.Maximums.Height = viper.GetInt("ImageMaxHeight")
+	if tileCache != nil && conf.TilePrefetchWorkers > 0 {
+		Logger.Infof("Prefetching neighboring tiles with %d workers", conf.TilePrefetchWorkers)
+		prefetcher = newTilePrefetcher(ih, conf.TilePrefetchWorkers, conf.TilePrefetchQueue)
+	}
 
-	iiifBaseURL := viper.GetString("IIIFBaseURL")
-	if iiifBaseURL != "" {
-		baseURL, _ := url.Parse(iiifBaseURL)
-		Logger.Infof("Explicitly setting IIIF base URL to %q", baseURL)
-		ih.BaseURL = baseURL
+	if conf.IIIFBaseURL != nil {
+		Logger.Infof("Explicitly setting IIIF base URL to %q", conf.IIIFBaseURL)
+		ih.BaseURL = conf.IIIFBaseURL
 	}
+	trustedProxies = conf.TrustedProxies
 
-	capfile := viper.GetString("CapabilitiesFile")
+	capfile := conf.CapabilitiesFile
 	if capfile != "" {
-		ih.FeatureSet = &iiif.FeatureSet{}
-		_, err := toml.DecodeFile(capfile, &ih.FeatureSet)
+		var err error
+		ih.FeatureSet, err = loadFeatureSet(capfile)
 		if err != nil {
 			Logger.Fatalf("Invalid file or formatting in capabilities file '%s'", capfile)
 		}
 		Logger.Debugf("Setting IIIF capabilities from file '%s'", capfile)
 	}
+	applyFeatureToggles(ih.FeatureSet, conf.EnabledFeatures, conf.DisabledFeatures)
+
+	if conf.CapabilityScopesFile != "" {
+		var err error
+		ih.FeatureScopes, err = loadFeatureScopes(conf.CapabilityScopesFile)
+		if err != nil {
+			Logger.Fatalf("Invalid capability scopes file %q: %s", conf.CapabilityScopesFile, err)
+		}
+		Logger.Debugf("Loaded %d IIIF capability scope(s) from %q", len(ih.FeatureScopes), conf.CapabilityScopesFile)
+	}
+
+	if conf.CachePolicyFile != "" {
+		var err error
+		ih.CachePolicies, err = loadCachePolicies(conf.CachePolicyFile)
+		if err != nil {
+			Logger.Fatalf("Invalid cache policy file %q: %s", conf.CachePolicyFile, err)
+		}
+		Logger.Debugf("Loaded caching header policies from %q", conf.CachePolicyFile)
+	}
+
+	if conf.InfoExtensionsFile != "" {
+		var err error
+		ih.InfoExtensions, err = loadInfoExtensions(conf.InfoExtensionsFile)
+		if err != nil {
+			Logger.Fatalf("Invalid info extensions file %q: %s", conf.InfoExtensionsFile, err)
+		}
+		Logger.Debugf("Loaded %d info.json extension(s) from %q", len(ih.InfoExtensions), conf.InfoExtensionsFile)
+	}
+
+	if conf.RoutesFile != "" {
+		var err error
+		ih.Routes, err = loadRoutes(conf.RoutesFile, ih.CachePolicies)
+		if err != nil {
+			Logger.Fatalf("Invalid routes file %q: %s", conf.RoutesFile, err)
+		}
+		for _, r := range ih.Routes {
+			if r.TilePath == "" {
+				Logger.Infof("Overriding settings for IDs starting with %q", r.Prefix)
+			} else {
+				Logger.Infof("Routing IDs starting with %q to %q", r.Prefix, r.TilePath)
+			}
+		}
+	}
+
+	setupSourceWatch(ih)
+
+	if conf.AliasFile != "" {
+		var err = aliases.load(conf.AliasFile)
+		if err != nil {
+			Logger.Fatalf("Unable to load alias file %q: %s", conf.AliasFile, err)
+		}
+		Logger.Infof("Loaded %d alias(es) from %q", aliases.len(), conf.AliasFile)
+		if conf.AliasReloadInterval > 0 {
+			go aliases.watch(conf.AliasReloadInterval)
+		}
+	}
+
+	if conf.TakedownFile == "" {
+		Logger.Warnf("TakedownFile is not set; image takedowns will be lost when RAIS restarts")
+	} else {
+		var err = takedowns.load(conf.TakedownFile)
+		if err != nil {
+			Logger.Fatalf("Unable to load takedowns: %s", err)
+		}
+	}
+
+	if conf.ChangeDiscoveryPath != "" {
+		activities = newActivityLog(conf.ChangeDiscoveryLen)
+		if conf.ChangeDiscoveryFile != "" {
+			var err = activities.load(conf.ChangeDiscoveryFile)
+			if err != nil {
+				Logger.Fatalf("Unable to load change discovery activities: %s", err)
+			}
+		}
+	}
+
+	// Nothing is listening yet, so this is as far as a dry run can go
+	if checkConfigOnly {
+		os.Exit(checkConfig(ih))
+	}
+
+	if conf.DecodeWorkers > 0 {
+		startDecodeWorkers(ih)
+	}
 
 	// Setup server info in our stats structure
 	stats.ServerStart = time.Now()
@@ -89,26 +233,251 @@ func main() {
 	stats.RAISBuild = version.Build
 
 	// Set up handlers / listeners
-	var pubSrv = servers.New("RAIS", address)
-	pubSrv.AddMiddleware(logMiddleware)
-	handle(pubSrv, ih.WebPathPrefix+"/", http.HandlerFunc(ih.IIIFRoute))
-	handle(pubSrv, "/", http.NotFoundHandler())
+	var iiifHandler http.Handler = http.HandlerFunc(ih.IIIFRoute)
+	if conf.RateLimit > 0 || conf.RateLimitConcurrency > 0 {
+		Logger.Infof("Limiting clients to %g requests per second and %d concurrent requests (0 is unlimited)",
+			conf.RateLimit, conf.RateLimitConcurrency)
+		var rl = newRateLimiter(conf.RateLimit, conf.RateLimitBurst, conf.RateLimitConcurrency, conf.RateLimitKeyHeader)
+		iiifHandler = rl.wrap(iiifHandler)
+	}
+	if conf.RequestTimeout > 0 {
+		iiifHandler = timeoutMiddleware(iiifHandler, conf.RequestTimeout)
+	}
+	if len(conf.SignedURLKeys) > 0 {
+		Logger.Infof("Requiring signed tokens on all IIIF requests (%d key(s) configured)", len(conf.SignedURLKeys))
+		iiifHandler = newSignedURLs(conf.SignedURLKeys, ih.WebPathPrefix).wrap(iiifHandler)
+	}
+	var ja *jwtAuth
+	if conf.JWKSURL != "" {
+		ja = setupJWTAuth()
+		iiifHandler = ja.wrap(iiifHandler)
+	}
+	iiifHandler = recoverMiddleware(iiifHandler)
 
-	var admSrv = servers.New("RAIS Admin", adminAddress)
-	admSrv.AddMiddleware(logMiddleware)
-	admSrv.HandleExact("/admin/stats.json", stats)
-	admSrv.HandlePrefix("/admin/cache/purge", http.HandlerFunc(adminPurgeCache))
+	var hh = &healthHandler{ih: ih, canary: iiif.ID(conf.HealthCanaryID)}
+	var routes = map[string]func(*servers.Server){
+		routesIIIF: func(srv *servers.Server) {
+			// These have to be registered first in case the IIIF prefix is "/"
+			if conf.DemoViewer {
+				srv.HandlePrefix("/view/", &demoViewer{ih: ih, scriptURL: conf.DemoViewerScriptURL})
+			}
+			if activities != nil {
+				// The stream lists image IDs, so it needs the same protection as
+				// the images themselves
+				var cd http.Handler = &changeDiscovery{ih: ih, path: conf.ChangeDiscoveryPath, log: activities}
+				if len(conf.SignedURLKeys) > 0 {
+					cd = newSignedURLs(conf.SignedURLKeys, "").wrap(cd)
+				}
+				if ja != nil {
+					cd = ja.wrap(cd)
+				}
+				srv.HandleExact(conf.ChangeDiscoveryPath, cd)
+				srv.HandlePrefix(conf.ChangeDiscoveryPath+"/page-", cd)
+			}
+			handle(srv, ih.WebPathPrefix+"/", iiifHandler)
+		},
+		routesAdmin: func(srv *servers.Server) {
+			srv.HandleExact("/admin/stats.json", stats)
+			srv.HandlePrefix("/admin/cache/purge", http.HandlerFunc(adminPurgeCache))
+			srv.HandleExact("/admin/cache/warm", &cacheWarmer{ih: ih})
+			srv.HandleExact("/admin/load", load)
+			srv.HandleExact("/admin/load/drain", http.HandlerFunc(adminDrain))
+			srv.HandleExact("/admin/compare", &compareHandler{ih: ih})
+			srv.HandleExact("/admin/slow", slowRequests)
+			srv.HandleExact("/admin/inflight", inflight)
+			if usage != nil {
+				srv.HandleExact("/admin/usage", usage)
+			}
+			srv.HandleExact("/admin/takedowns", http.HandlerFunc(adminTakedowns))
+			srv.HandleExact("/admin/takedowns/restore", http.HandlerFunc(adminRestore))
+			srv.HandleExact("/admin/quarantine", http.HandlerFunc(adminQuarantine))
+			srv.HandleExact("/admin/quarantine/release", http.HandlerFunc(adminRelease))
+		},
+		routesHealth: func(srv *servers.Server) {
+			srv.HandleExact("/healthz", http.HandlerFunc(hh.live))
+			srv.HandleExact("/readyz", http.HandlerFunc(hh.ready))
+		},
+		routesPeer: func(srv *servers.Server) {
+			if peers != nil {
+				var ph = &peerHandler{ih: ih}
+				srv.HandleExact("/peer/info", http.HandlerFunc(ph.serveInfo))
+				srv.HandleExact("/peer/image", http.HandlerFunc(ph.serveImage))
+			}
+		},
+	}
+	setupListeners(routes)
+	adoptListeners(inherited)
 
-	interrupts.TrapIntTerm(shutdown)
+	interrupts.TrapIntTerm(func() {
+		systemd.Notify("STOPPING=1")
+		shutdown()
+	})
+	trapUpgrade()
 
 	Logger.Infof("RAIS v%s starting...", version.Version)
 	servers.ListenAndServe(func(srv *servers.Server, err error) {
 		Logger.Errorf("Error running %q server: %s", srv.Name, err)
 		shutdown()
-	})
+	}, func() { notifyReady(hh) })
 	wait.Wait()
 }
 
+// setupListeners starts a server for each configured listener and registers
+// the route groups it serves.  Listeners sharing an address share a server,
+// as they always have when Address and AdminAddress are the same.
+func setupListeners(routes map[string]func(*servers.Server)) {
+	var listeners = defaultListeners(conf)
+	if conf.ListenersFile != "" {
+		var err error
+		listeners, err = loadListeners(conf.ListenersFile)
+		if err != nil {
+			Logger.Fatalf("Invalid listeners file %q: %s", conf.ListenersFile, err)
+		}
+		Logger.Infof("Loaded %d listener(s) from %q; Address, AdminAddress, TLSCert, and TLSKey are ignored",
+			len(listeners), conf.ListenersFile)
+	}
+	if len(conf.ACMEDomains) > 0 {
+		acmeManager = newACMEManager(conf)
+	}
+
+	var configured = make(map[*servers.Server]bool)
+	var catchAll []*servers.Server
+	for _, l := range listeners {
+		var srv = servers.New(l.Name, l.Address)
+		if !configured[srv] {
+			configured[srv] = true
+			if l.TLSCert != "" {
+				Logger.Infof("Serving HTTPS on %q using certificate %q", l.Address, l.TLSCert)
+				srv.SetTLS(l.TLSCert, l.TLSKey)
+			}
+			if l.ACME {
+				if acmeManager == nil {
+					Logger.Fatalf("Listener %q uses ACME, but ACMEDomains isn't set", l.Name)
+				}
+				Logger.Infof("Serving HTTPS on %q using ACME certificates for %s", l.Address, strings.Join(conf.ACMEDomains, ", "))
+				srv.SetTLSConfig(acmeTLSConfig(acmeManager, conf.HTTP2))
+			}
+			if l.FastCGI {
+				Logger.Infof("Serving FastCGI on %q", l.Address)
+				srv.SetFastCGI()
+			}
+			if l.unixSocket() {
+				srv.SocketMode = conf.SocketMode
+			}
+			srv.AddMiddleware(logMiddleware)
+			srv.AddMiddleware(accessLogMiddleware)
+			configureServer(srv)
+		}
+		for _, r := range l.Routes {
+			routes[r](srv)
+		}
+		if l.serves(routesIIIF) {
+			catchAll = append(catchAll, srv)
+		}
+	}
+
+	// The catch-all has to come last, or it would hide other listeners' routes
+	// on a shared server
+	for _, srv := range catchAll {
+		handle(srv, "/", http.NotFoundHandler())
+	}
+}
+
+// setupPeers starts sharing cache entries with other RAIS instances if a peer
+// list or DNS name is configured
+func setupPeers() {
+	if len(conf.CachePeers) == 0 && conf.CachePeerDNS == "" {
+		return
+	}
+
+	peers = newPeerCache(conf.CachePeerSelf, conf.CachePeerSecret)
+	if conf.CachePeerDNS != "" {
+		Logger.Infof("Discovering cache peers via DNS name %q", conf.CachePeerDNS)
+		go peers.discover(conf.CachePeerDNS)
+	} else {
+		Logger.Infof("Sharing cache entries with peers %s", strings.Join(conf.CachePeers, ", "))
+		peers.setPeers(conf.CachePeers)
+		if peers.self == "" {
+			Logger.Warnf("Unable to tell which cache peer is this server; set CachePeerSelf to avoid " +
+				"sending requests to ourselves over HTTP")
+		}
+	}
+}
+
+// setupSourceWatch starts watching source images for changes if configured
+func setupSourceWatch(ih *ImageHandler) {
+	var sw = newSourceWatcher(sourceRoots(ih))
+	switch conf.SourceWatch {
+	case watchNotify:
+		var err = sw.notify()
+		if err != nil {
+			Logger.Fatalf("Unable to watch source images for changes: %s", err)
+		}
+		Logger.Infof("Watching source images for changes")
+	case watchScan:
+		Logger.Infof("Scanning source images for changes every %s", conf.SourceScanInterval)
+		go sw.scan(conf.SourceScanInterval)
+	}
+}
+
+// setupJWTAuth builds the JWT validator and rules from the config
+func setupJWTAuth() *jwtAuth {
+	var v = &jwt.Validator{
+		Keys:     jwt.NewKeySet(conf.JWKSURL),
+		Issuer:   conf.JWTIssuer,
+		Audience: conf.JWTAudience,
+		Leeway:   jwtLeeway,
+	}
+	if conf.JWTIssuer == "" || conf.JWTAudience == "" {
+		Logger.Warnf("JWTIssuer and JWTAudience should both be set, or tokens meant for other services will be accepted")
+	}
+
+	var rules []*jwtRule
+	if conf.JWTRulesFile != "" {
+		var err error
+		rules, err = loadJWTRules(conf.JWTRulesFile)
+		if err != nil {
+			Logger.Fatalf("Invalid JWT rules file %q: %s", conf.JWTRulesFile, err)
+		}
+	}
+	Logger.Infof("Authorizing IIIF requests with JWTs from %q (%d rule(s))", conf.JWKSURL, len(rules))
+	return newJWTAuth(v, rules)
+}
+
+// configureServer applies the configured timeouts, header limits, and HTTP/2
+// setting to the given server
+func configureServer(srv *servers.Server) {
+	srv.ReadTimeout = conf.ReadTimeout
+	srv.WriteTimeout = conf.WriteTimeout
+	srv.IdleTimeout = conf.IdleTimeout
+	srv.MaxHeaderBytes = conf.MaxHeaderBytes
+	if !conf.HTTP2 {
+		srv.DisableHTTP2()
+	}
+}
+
+// setupDecoding applies the decoder settings, which the server and decode
+// workers share
+func setupDecoding() {
+	openjpeg.Logger = componentLogger("openjpeg")
+	openjpeg.DecodeThreads = conf.DecodeThreads
+	openjpeg.MapFiles = conf.DecodeMapFiles
+	openjpeg.CacheHeaders(conf.HeaderCacheLen)
+	img.PageSeparator = conf.PageSeparator
+	img.SourceMax = conf.SourceMax
+}
+
+// mapDecoderExtensions applies DecoderExtensions once all decoders are
+// registered
+func mapDecoderExtensions() {
+	for ext, name := range conf.DecoderExtensions {
+		var err = img.MapExtension(ext, name)
+		if err != nil {
+			Logger.Fatalf("Invalid DecoderExtensions setting: %s", err)
+		}
+	}
+}
+
 // handle sends the pattern and raw handler to plugins, and sets up routing on
 // whatever is returned (if anything).  All plugins which wrap handlers are
 // allowed to run, but the behavior could definitely get weird depending on
@@ -129,7 +498,18 @@ func handle(srv *servers.Server, pattern string, handler http.Handler) {
 func shutdown() {
 	wait.Add(1)
 	Logger.Infof("Stopping RAIS...")
-	servers.Shutdown(nil)
+
+	// In-flight requests get as long to finish as they'd have had anyway
+	var ctx = context.Background()
+	if conf.WriteTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.WriteTimeout)
+		defer cancel()
+	}
+	servers.Shutdown(ctx)
+	if decodeWorkers != nil {
+		decodeWorkers.Close()
+	}
 
 	if len(teardownPlugins) > 0 {
 		Logger.Infof("Tearing down plugins")
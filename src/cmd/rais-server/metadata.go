@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/metadata"
+	"strings"
+)
+
+// Metadata responds to a metadata.json request with the image's embedded
+// EXIF, XMP, and IPTC fields, limited to those in ih.MetadataFields.  Images
+// without any allowed metadata get an empty object rather than a 404, since
+// the image itself does exist.
+func (ih *ImageHandler) Metadata(w http.ResponseWriter, req *http.Request, id iiif.ID) {
+	var fp, _, ok = ih.resolveSidecar(req, w, id)
+	if !ok {
+		return
+	}
+
+	var m, err = metadata.Read(fp)
+	if err != nil {
+		Logger.Errorf("Unable to read metadata for %s (path %s): %s", id, fp, err)
+		http.Error(w, "Unable to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	var data []byte
+	data, err = json.Marshal(m.Filter(ih.MetadataFields))
+	if err != nil {
+		Logger.Errorf("Unable to marshal metadata for %s: %s", id, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	ih.cachePoliciesFor(id).setHeaders(w, req, id, true)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
+
+// parseMetadataFields turns a comma-separated allowlist into a slice,
+// falling back to metadata.DefaultFields if the list is empty
+func parseMetadataFields(val string) []string {
+	var list []string
+	for _, f := range strings.Split(val, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			list = append(list, f)
+		}
+	}
+	if len(list) == 0 {
+		return metadata.DefaultFields
+	}
+	return list
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"rais/src/metadata"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestMetadataRequest(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/foo/bar")
+	ih.FeatureSet = iiif.FeatureSet2()
+	var path = "/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/metadata.json"
+	var req, _ = http.NewRequest("GET", path, strings.NewReader(""))
+
+	var w = fakehttp.NewResponseWriter()
+	ih.IIIFRoute(w, req)
+	assert.Equal(400, w.StatusCode, "metadata.json is an invalid IIIF request when the service is off", t)
+
+	ih.MetadataFields = metadata.DefaultFields
+	w = fakehttp.NewResponseWriter()
+	ih.IIIFRoute(w, req)
+	assert.Equal(-1, w.StatusCode, "metadata.json is served", t)
+	assert.Equal("{}", string(w.Output), "images without metadata get an empty object", t)
+
+	req, _ = http.NewRequest("GET", "/foo/bar/nope.jp2/metadata.json", strings.NewReader(""))
+	w = fakehttp.NewResponseWriter()
+	ih.IIIFRoute(w, req)
+	assert.Equal(404, w.StatusCode, "missing images are a 404", t)
+}
+
+func TestParseMetadataFields(t *testing.T) {
+	assert.Equal(len(metadata.DefaultFields), len(parseMetadataFields(" ")), "empty list means defaults", t)
+	var list = parseMetadataFields("exif.Artist, iptc.*,")
+	assert.Equal(2, len(list), "two fields", t)
+	assert.Equal("iptc.*", list[1], "fields are trimmed", t)
+}
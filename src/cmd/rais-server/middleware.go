@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"rais/src/cmd/rais-server/internal/statusrecorder"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
 )
 
 func logMiddleware(next http.Handler) http.Handler {
@@ -17,3 +22,71 @@ func logMiddleware(next http.Handler) http.Handler {
 		Logger.Infof("Request: [%s] %s - %d", ip, r.URL, sr.Status)
 	})
 }
+
+// timeoutMiddleware cancels each request's context after d, so plugins and
+// decoders abandon work for requests which are taking too long
+func timeoutMiddleware(next http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ctx, cancel = context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recoverMiddleware turns a panic while serving a request into a 500
+// response, logging the panic, its stack trace, and the request's URL.
+// net/http would otherwise recover the panic itself, but only by dropping
+// the connection and logging the stack to stderr, so the client gets no
+// response and the panic never shows up in RAIS's logs or stats.  If part of
+// the response has already been sent, the connection is aborted instead, so
+// the client doesn't mistake a truncated image for a whole one.
+//
+// Only panics on the request's own goroutine are caught here.  Work done on
+// other goroutines, like the encode pool and background tile regeneration,
+// has to use catchPanic, since a panic there would end the process.  And
+// only Go panics can be recovered: a crash inside C code, like a segfault in
+// a decoder library, still ends the process.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sr = statusrecorder.New(w)
+		defer func() {
+			var p = recover()
+			if p == nil {
+				return
+			}
+			// Handlers panic with ErrAbortHandler on purpose to drop a connection
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			atomic.AddUint64(&stats.Panics, 1)
+			requestLogger(r.Context()).Errorf("Panic serving %q: %v\n%s", r.URL, p, debug.Stack())
+			if sr.Written() > 0 {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(sr, r)
+	})
+}
+
+// errPanic is returned in place of work which panicked on a goroutine
+// outside net/http's control
+var errPanic = errors.New("internal error")
+
+// catchPanic recovers a panic in a goroutine net/http doesn't manage, where
+// it would otherwise end the process.  The panic is logged with its stack
+// trace, and, if err isn't nil, *err is set to errPanic.  It has to be
+// deferred directly, as recover only works there.
+func catchPanic(ctx context.Context, what string, err *error) {
+	var p = recover()
+	if p == nil {
+		return
+	}
+
+	atomic.AddUint64(&stats.Panics, 1)
+	requestLogger(ctx).Errorf("Panic %s: %v\n%s", what, p, debug.Stack())
+	if err != nil {
+		*err = errPanic
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"rais/src/fakehttp"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	var h = recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/iiif/ok.jp2/info.json" {
+			w.Write([]byte("{}"))
+			return
+		}
+		panic("decoder exploded")
+	}))
+	var before = atomic.LoadUint64(&stats.Panics)
+
+	var req, _ = http.NewRequest("GET", "/iiif/ok.jp2/info.json", strings.NewReader(""))
+	var w = fakehttp.NewResponseWriter()
+	h.ServeHTTP(w, req)
+	assert.Equal("{}", string(w.Output), "normal requests are untouched", t)
+
+	req, _ = http.NewRequest("GET", "/iiif/bad.jp2/full/max/0/default.jpg", strings.NewReader(""))
+	w = fakehttp.NewResponseWriter()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusInternalServerError, w.StatusCode, "a panic is a 500", t)
+	assert.Equal(before+1, atomic.LoadUint64(&stats.Panics), "panic is counted", t)
+}
+
+func TestRecoverMiddlewarePartialResponse(t *testing.T) {
+	var h = recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("encoder exploded")
+	}))
+
+	var req, _ = http.NewRequest("GET", "/iiif/bad.jp2/full/max/0/default.jpg", strings.NewReader(""))
+	var w = fakehttp.NewResponseWriter()
+	var p interface{}
+	func() {
+		defer func() { p = recover() }()
+		h.ServeHTTP(w, req)
+	}()
+	assert.True(p == http.ErrAbortHandler, "connection is aborted once the response has started", t)
+	assert.Equal(-1, w.StatusCode, "no error status is sent", t)
+}
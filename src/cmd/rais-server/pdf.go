@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"rais/src/iiif"
+	"rais/src/metadata"
+	"rais/src/pdf"
+	"rais/src/version"
+	"strings"
+)
+
+// pdfPage is an image headed for PDF output, along with the document info
+// the PDF should carry
+type pdfPage struct {
+	image.Image
+	info pdf.Info
+}
+
+// encodePDF writes i as a JPEG wrapped in a single-page PDF.  If i is a
+// pdfPage, its info is embedded in the document.
+func encodePDF(w io.Writer, i image.Image) error {
+	var info = pdf.Info{Producer: "RAIS " + version.Version}
+	if p, ok := i.(*pdfPage); ok {
+		i = p.Image
+		info = p.info
+	}
+
+	var buf bytes.Buffer
+	var err = jpeg.Encode(&buf, i, &jpeg.Options{Quality: 80})
+	if err != nil {
+		return err
+	}
+	return pdf.Write(w, buf.Bytes(), info)
+}
+
+// pdfInfo returns the document info for a PDF of id.  The title is the ID
+// unless the metadata service is enabled, in which case the title, author,
+// and description are taken from the image's embedded metadata, as long as
+// they're fields the service is allowed to share.
+func (ih *ImageHandler) pdfInfo(id iiif.ID, fp string) pdf.Info {
+	var info = pdf.Info{Title: string(id), Producer: "RAIS " + version.Version}
+	if len(ih.MetadataFields) == 0 {
+		return info
+	}
+
+	var m, err = metadata.Read(fp)
+	if err != nil {
+		Logger.Warnf("Unable to read metadata for PDF of %s (path %s): %s", id, fp, err)
+		return info
+	}
+	m = m.Filter(ih.MetadataFields)
+
+	var first = func(fields ...string) string {
+		for _, f := range fields {
+			var parts = strings.SplitN(f, ".", 2)
+			if v := m[parts[0]][parts[1]]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	if t := first("xmp.dc:title", "iptc.ObjectName"); t != "" {
+		info.Title = t
+	}
+	info.Author = first("xmp.dc:creator", "exif.Artist", "iptc.By-line")
+	info.Subject = first("xmp.dc:description", "exif.ImageDescription", "iptc.Caption-Abstract")
+	return info
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ringReplicas is how many points each peer gets on the hash ring; more
+// points spread keys more evenly
+const ringReplicas = 50
+
+// peerDNSInterval is how often peers are looked up when using DNS discovery
+const peerDNSInterval = 30 * time.Second
+
+// peerKeyHeader carries the shared secret on requests between peers
+const peerKeyHeader = "X-RAIS-Peer-Key"
+
+// hashRing maps keys to peers with consistent hashing, so adding or removing
+// a peer only moves the keys that peer owned
+type hashRing struct {
+	hashes []uint32
+	peers  map[uint32]string
+}
+
+func newHashRing(peers []string) *hashRing {
+	var r = &hashRing{peers: make(map[uint32]string)}
+	for _, p := range peers {
+		for i := 0; i < ringReplicas; i++ {
+			var h = crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + p))
+			r.hashes = append(r.hashes, h)
+			r.peers[h] = p
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// owner returns the peer responsible for key
+func (r *hashRing) owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	var h = crc32.ChecksumIEEE([]byte(key))
+	var i = sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.peers[r.hashes[i]]
+}
+
+// peerCache shards tile and info cache entries among a cluster of RAIS
+// instances.  Each key is owned by one peer: other peers ask the owner for
+// it rather than decoding the image themselves, and the owner decodes and
+// caches it just once for the whole cluster.  If the owner can't be reached,
+// the image is decoded locally as usual.
+type peerCache struct {
+	self   string
+	secret string
+	client *http.Client
+
+	m     sync.RWMutex
+	list  []string
+	ring  *hashRing
+	local map[string]bool
+}
+
+// peers is nil unless a peer list or DNS name is configured
+var peers *peerCache
+
+type peerRequestKey struct{}
+
+func newPeerCache(self, secret string) *peerCache {
+	return &peerCache{
+		self:   strings.TrimRight(self, "/"),
+		secret: secret,
+		client: &http.Client{Timeout: time.Minute},
+		ring:   newHashRing(nil),
+		local:  localAddrs(),
+	}
+}
+
+// parsePeerList splits a comma-separated list of peer URLs
+func parsePeerList(val string) []string {
+	var list []string
+	for _, p := range strings.Split(val, ",") {
+		p = strings.TrimRight(strings.TrimSpace(p), "/")
+		if p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// localAddrs returns the IPs of this host's network interfaces, used to work
+// out which peer is us when CachePeerSelf isn't set
+func localAddrs() map[string]bool {
+	var m = make(map[string]bool)
+	var addrs, _ = net.InterfaceAddrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			m[n.IP.String()] = true
+		}
+	}
+	return m
+}
+
+// setPeers replaces the list of peers
+func (pc *peerCache) setPeers(list []string) {
+	list = append([]string{}, list...)
+	sort.Strings(list)
+
+	pc.m.Lock()
+	defer pc.m.Unlock()
+	pc.list = list
+	pc.ring = newHashRing(list)
+	if pc.self != "" {
+		return
+	}
+	for _, p := range list {
+		var u, err = url.Parse(p)
+		if err == nil && pc.local[u.Hostname()] {
+			pc.self = p
+			Logger.Infof("Identified this server as cache peer %q", p)
+		}
+	}
+}
+
+// discover looks up name, a "host:port" pair, every peerDNSInterval, using
+// each address it resolves to as a peer
+func (pc *peerCache) discover(name string) {
+	var host, port, err = net.SplitHostPort(name)
+	if err != nil {
+		Logger.Errorf("Invalid peer DNS name %q: %s", name, err)
+		return
+	}
+
+	var lookup = func() {
+		defer catchPanic(context.Background(), "looking up cache peers", nil)
+		var addrs, err = net.LookupHost(host)
+		if err != nil {
+			Logger.Warnf("Unable to look up cache peers at %q: %s", host, err)
+			return
+		}
+		var list []string
+		for _, a := range addrs {
+			list = append(list, "http://"+net.JoinHostPort(a, port))
+		}
+		sort.Strings(list)
+		pc.m.RLock()
+		var changed = strings.Join(list, ",") != strings.Join(pc.list, ",")
+		pc.m.RUnlock()
+		if changed {
+			Logger.Infof("Cache peers are now %s", strings.Join(list, ", "))
+			pc.setPeers(list)
+		}
+	}
+
+	lookup()
+	for range time.Tick(peerDNSInterval) {
+		lookup()
+	}
+}
+
+// ownerFor returns the URL of the peer which owns key, or an empty string if
+// we own it, peer caching is off, or the request came from a peer (which
+// means the peer already decided we're the owner)
+func (pc *peerCache) ownerFor(ctx context.Context, key string) string {
+	if pc == nil || ctx.Value(peerRequestKey{}) != nil {
+		return ""
+	}
+
+	pc.m.RLock()
+	defer pc.m.RUnlock()
+	var owner = pc.ring.owner(key)
+	if owner == pc.self {
+		return ""
+	}
+	return owner
+}
+
+// get requests the given path and query from peer, returning the body if
+// the peer answered with a 200.  Other statuses are returned with a nil body.
+func (pc *peerCache) get(ctx context.Context, peer, path string, q url.Values) ([]byte, int, error) {
+	var req, err = http.NewRequest("GET", peer+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	if pc.secret != "" {
+		req.Header.Set(peerKeyHeader, pc.secret)
+	}
+
+	var resp *http.Response
+	resp, err = pc.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, nil
+	}
+
+	var data []byte
+	data, err = ioutil.ReadAll(resp.Body)
+	return data, resp.StatusCode, err
+}
+
+// fetchInfo asks id's owner for its image info.  ok is false if we own id or
+// the owner couldn't help, in which case the info should be read locally.  A
+// 404 from the owner is returned as an error so we don't look again.
+func (pc *peerCache) fetchInfo(ctx context.Context, id iiif.ID) (info ImageInfo, ok bool, e *HandlerError) {
+	var owner = pc.ownerFor(ctx, "info:"+string(id))
+	if owner == "" {
+		return info, false, nil
+	}
+
+	var data, status, err = pc.get(ctx, owner, "/peer/info", url.Values{"id": {string(id)}})
+	if status == http.StatusNotFound {
+		return info, false, NewError("image resource does not exist", http.StatusNotFound)
+	}
+	if err == nil && status == http.StatusOK {
+		err = json.Unmarshal(data, &info)
+	}
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status %d", status)
+	}
+	if err != nil {
+		Logger.Warnf("Unable to get info for %q from cache peer %q: %s", id, owner, err)
+		return info, false, nil
+	}
+	return info, true, nil
+}
+
+// fetchImage asks the owner of the tile cache key for the encoded image at
+// the given IIIF path.  ok is false if we own the key or the owner couldn't
+// help.
+func (pc *peerCache) fetchImage(ctx context.Context, key, path string) (data []byte, ok bool) {
+	var owner = pc.ownerFor(ctx, key)
+	if owner == "" {
+		return nil, false
+	}
+
+	var status int
+	var err error
+	data, status, err = pc.get(ctx, owner, "/peer/image", url.Values{"path": {path}})
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status %d", status)
+	}
+	if err != nil {
+		Logger.Warnf("Unable to get %q from cache peer %q: %s", key, owner, err)
+		return nil, false
+	}
+	return data, true
+}
+
+// peerHandler serves cache requests from other peers.  These requests skip
+// JWT and signed URL checks, so the peer routes belong on an internal
+// listener.
+type peerHandler struct {
+	ih *ImageHandler
+}
+
+// allowed returns true if req has the shared peer secret, or none is needed,
+// and writes an error if not
+func (ph *peerHandler) allowed(w http.ResponseWriter, req *http.Request) bool {
+	var key = req.Header.Get(peerKeyHeader)
+	if peers.secret == "" || subtle.ConstantTimeCompare([]byte(key), []byte(peers.secret)) == 1 {
+		return true
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	return false
+}
+
+func peerContext(req *http.Request) context.Context {
+	return context.WithValue(req.Context(), peerRequestKey{}, true)
+}
+
+// serveInfo sends an image's raw info as JSON, reading the image if the info
+// isn't cached
+func (ph *peerHandler) serveInfo(w http.ResponseWriter, req *http.Request) {
+	if !ph.allowed(w, req) {
+		return
+	}
+
+	var ctx = peerContext(req)
+	var id = iiif.ID(req.URL.Query().Get("id"))
+	var info ImageInfo
+	var ok bool
+	if infoCache != nil {
+		stats.InfoCache.Get()
+		var data interface{}
+		data, ok = infoCache.Get(id)
+		if ok {
+			stats.InfoCache.Hit()
+			info = data.(ImageInfo)
+		}
+	}
+
+	if !ok {
+		var fp, err = ph.ih.resolveIIIFPath(ctx, id)
+		if err == plugins.ErrForbidden {
+			http.Error(w, "Access to this image is forbidden", http.StatusForbidden)
+			return
+		}
+		if err == errInvalidID {
+			http.Error(w, "Invalid image ID", http.StatusBadRequest)
+			return
+		}
+		var e *HandlerError
+		info, e = ph.ih.readImageInfo(ctx, id, fp)
+		if e != nil {
+			e.write(w)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// serveImage runs a IIIF image request as if it had come to us directly,
+// except that it won't be passed along to yet another peer
+func (ph *peerHandler) serveImage(w http.ResponseWriter, req *http.Request) {
+	if !ph.allowed(w, req) {
+		return
+	}
+
+	var u = *req.URL
+	u.Path = ph.ih.WebPathPrefix + "/" + req.URL.Query().Get("path")
+	u.RawPath = ""
+	u.RawQuery = ""
+	var r = req.WithContext(peerContext(req))
+	r.URL = &u
+	ph.ih.IIIFRoute(w, r)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestHashRing(t *testing.T) {
+	var r = newHashRing([]string{"http://a", "http://b", "http://c"})
+	var owners = make(map[string]int)
+	var before = make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		var key = fmt.Sprintf("id%d/full/512,/0/default.jpg", i)
+		before[key] = r.owner(key)
+		owners[before[key]]++
+	}
+	assert.Equal(3, len(owners), "every peer owns keys", t)
+	for p, n := range owners {
+		assert.True(n > 200, fmt.Sprintf("%s owns a fair share (%d)", p, n), t)
+	}
+
+	// Removing a peer only moves the keys it owned
+	r = newHashRing([]string{"http://a", "http://b"})
+	for key, owner := range before {
+		if owner != "http://c" && r.owner(key) != owner {
+			t.Errorf("key %q moved from %q to %q", key, owner, r.owner(key))
+			break
+		}
+	}
+	assert.Equal("", newHashRing(nil).owner("x"), "empty ring", t)
+}
+
+func TestPeerCache(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/iiif")
+	var owner = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ph = &peerHandler{ih: h}
+		switch req.URL.Path {
+		case "/peer/info":
+			ph.serveInfo(w, req)
+		case "/peer/image":
+			ph.serveImage(w, req)
+		}
+	}))
+	defer owner.Close()
+
+	peers = newPeerCache("http://self", "secret")
+	peers.setPeers([]string{owner.URL})
+	defer func() { peers = nil }()
+
+	var ctx = context.Background()
+	var info, ok, e = peers.fetchInfo(ctx, "docker/images/testfile/test-world-link.jp2")
+	assert.True(e == nil && ok, "info fetched from the owner", t)
+	assert.Equal(800, info.Width, "owner's info", t)
+
+	_, ok, e = peers.fetchInfo(ctx, "nope.jp2")
+	assert.False(ok, "missing image", t)
+	assert.Equal(http.StatusNotFound, e.Code, "owner's 404 is passed along", t)
+
+	assert.Equal("", peers.ownerFor(context.WithValue(ctx, peerRequestKey{}, true), "x"), "peer requests are never forwarded", t)
+
+	var data []byte
+	var path = "docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/max/0/default.jpg"
+	data, ok = peers.fetchImage(ctx, path, path)
+	assert.False(ok, "the owner's errors aren't passed along", t)
+	assert.Equal(0, len(data), "no data on errors", t)
+
+	var down = httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	peers.setPeers([]string{down.URL})
+	_, ok, e = peers.fetchInfo(ctx, "docker/images/testfile/test-world-link.jp2")
+	assert.True(!ok && e == nil, "unreachable owners mean falling back to local reads", t)
+
+	peers.setPeers([]string{owner.URL, "http://self"})
+	var local int
+	for i := 0; i < 100; i++ {
+		if peers.ownerFor(ctx, fmt.Sprintf("key%d", i)) == "" {
+			local++
+		}
+	}
+	assert.True(local > 0 && local < 100, "keys we own aren't forwarded", t)
+}
+
+func TestPeerHandlerSecret(t *testing.T) {
+	peers = newPeerCache("http://self", "secret")
+	defer func() { peers = nil }()
+	var ph = &peerHandler{ih: NewImageHandler(rootDir(), "/iiif")}
+
+	var w = httptest.NewRecorder()
+	ph.serveInfo(w, httptest.NewRequest("GET", "/peer/info?id=x", nil))
+	assert.Equal(http.StatusForbidden, w.Code, "requests without the secret are refused", t)
+
+	w = httptest.NewRecorder()
+	var req = httptest.NewRequest("GET", "/peer/info?id="+string(iiif.ID("docker/images/testfile/test-world-link.jp2")), nil)
+	req.Header.Set(peerKeyHeader, "secret")
+	ph.serveInfo(w, req)
+	assert.Equal(http.StatusOK, w.Code, "requests with the secret are served", t)
+	var info ImageInfo
+	json.Unmarshal(w.Body.Bytes(), &info)
+	assert.Equal(400, info.Height, "image info", t)
+}
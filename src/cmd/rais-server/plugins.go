@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,6 +10,7 @@
 	"plugin"
 	"rais/src/iiif"
 	"rais/src/img"
+	"rais/src/plugins"
 	"reflect"
 	"sort"
 	"strings"
@@ -16,11 +18,21 @@
 	"github.com/uoregon-libraries/gopkg/logger"
 )
 
-var idToPathPlugins []func(iiif.ID) (string, error)
+var idToPathPlugins []func(context.Context, iiif.ID) (string, error)
 var wrapHandlerPlugins []func(string, http.Handler) (http.Handler, error)
 var teardownPlugins []func()
 var purgeCachePlugins []func()
 var expireCachedImagePlugins []func(iiif.ID)
+var startSpanPlugins []func(context.Context, string) (context.Context, func())
+var decorateInfoPlugins []func(iiif.ID, *iiif.Info)
+
+// pluginFiles lists every plugin file LoadPlugins found, so sandboxed decode
+// workers can be allowed to load them
+var pluginFiles []string
+
+// pluginErrors holds a description of every plugin which failed to load so
+// that readiness checks can report the failure
+var pluginErrors []string
 
 // pluginsFor returns a list of all plugin files which matched the given
 // pattern.  Files are sorted by name.
@@ -67,21 +79,39 @@ func LoadPlugins(l *logger.Logger, patterns []string) {
 
 		plugFiles = append(plugFiles, matches...)
 	}
+	pluginFiles = append(pluginFiles, plugFiles...)
 
 	for _, file := range plugFiles {
 		l.Infof("Loading plugin %q", file)
 		var err = loadPlugin(file, l)
 		if err != nil {
 			l.Errorf("Unable to load %q: %s", file, err)
+			pluginErrors = append(pluginErrors, fmt.Sprintf("%s: %s", file, err))
 		}
 	}
 }
 
+// pluginCapabilities maps each capability a plugin may declare to the
+// functions which provide it.  A plugin declaring a capability must export at
+// least one of its functions, and may not export any of them without
+// declaring it.
+var pluginCapabilities = map[string][]string{
+	plugins.CapIDToPath:      {"IDToPath", "IDToPathContext"},
+	plugins.CapDecoder:       {"ImageDecoders", "NamedImageDecoders"},
+	plugins.CapCachePurge:    {"PurgeCaches", "ExpireCachedImage"},
+	plugins.CapWrapHandler:   {"WrapHandler"},
+	plugins.CapTracer:        {"StartSpan"},
+	plugins.CapInfoDecorator: {"DecorateInfo"},
+	plugins.CapEncoder:       {"ImageEncoders"},
+}
+
 type pluginWrapper struct {
 	*plugin.Plugin
-	path      string
-	functions []string
-	errors    []string
+	path         string
+	functions    []string
+	errors       []string
+	version      int
+	capabilities []string
 }
 
 func newPluginWrapper(path string) (*pluginWrapper, error) {
@@ -114,6 +144,90 @@ func (pw *pluginWrapper) loadPluginFn(name string, obj interface{}) {
 	pw.functions = append(pw.functions, name)
 }
 
+// readDeclarations reads the plugin's API version and capabilities.  Plugins
+// which don't declare a version are version 1 plugins.
+func (pw *pluginWrapper) readDeclarations() error {
+	pw.version = 1
+	var sym, err = pw.Lookup("PluginAPIVersion")
+	if err == nil {
+		var v, ok = sym.(*int)
+		if !ok {
+			return fmt.Errorf("PluginAPIVersion must be an int")
+		}
+		pw.version = *v
+	}
+	if pw.version < 1 || pw.version > plugins.APIVersion {
+		return fmt.Errorf("plugin was written for plugin API version %d, but this version of RAIS "+
+			"supports versions 1 through %d; the plugin must be rebuilt against a compatible RAIS",
+			pw.version, plugins.APIVersion)
+	}
+
+	sym, err = pw.Lookup("PluginCapabilities")
+	if err != nil {
+		if pw.version >= 2 {
+			return fmt.Errorf("version %d plugins must export PluginCapabilities", pw.version)
+		}
+		return nil
+	}
+	var caps, ok = sym.(*[]string)
+	if !ok {
+		return fmt.Errorf("PluginCapabilities must be a []string")
+	}
+	pw.capabilities = *caps
+	return nil
+}
+
+// checkCapabilities verifies that the functions a plugin exports match the
+// capabilities it declared
+func (pw *pluginWrapper) checkCapabilities() error {
+	if pw.version < 2 {
+		return nil
+	}
+
+	var exported = make(map[string]bool)
+	for _, fn := range pw.functions {
+		exported[fn] = true
+	}
+
+	var declared = make(map[string]bool)
+	for _, c := range pw.capabilities {
+		var fns, ok = pluginCapabilities[c]
+		if !ok {
+			return fmt.Errorf("unknown capability %q (known capabilities: %s)", c, knownCapabilities())
+		}
+		declared[c] = true
+
+		var found bool
+		for _, fn := range fns {
+			found = found || exported[fn]
+		}
+		if !found {
+			return fmt.Errorf("capability %q is declared, but none of its functions (%s) are exported",
+				c, strings.Join(fns, ", "))
+		}
+	}
+
+	for c, fns := range pluginCapabilities {
+		for _, fn := range fns {
+			if exported[fn] && !declared[c] {
+				return fmt.Errorf("%s is exported, but capability %q isn't declared", fn, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// knownCapabilities returns a sorted, comma-separated list of capabilities
+func knownCapabilities() string {
+	var list []string
+	for c := range pluginCapabilities {
+		list = append(list, c)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
 // loadPlugin attempts to read the given plugin file and extract known symbols.
 // If a plugin exposes Initialize or SetLogger, they're called here once we're
 // sure the plugin is valid.  IDToPath functions are indexed globally for use
@@ -130,20 +244,30 @@ func loadPlugin(fullpath string, l *logger.Logger) error {
 
 	// Simply initialize those functions we only want indexed if they exist
 	var idToPath func(iiif.ID) (string, error)
+	var idToPathContext func(context.Context, iiif.ID) (string, error)
 	var teardown func()
 	var wrapHandler func(string, http.Handler) (http.Handler, error)
 	var prgCache func()
 	var expCachedImg func(iiif.ID)
 	var imageDecoders func() []img.DecodeFn
+	var namedImageDecoders func() []img.NamedDecoder
+	var imageEncoders func() []img.Encoder
+	var startSpan func(context.Context, string) (context.Context, func())
+	var decorateInfo func(iiif.ID, *iiif.Info)
 
 	pw.loadPluginFn("SetLogger", &log)
 	pw.loadPluginFn("IDToPath", &idToPath)
+	pw.loadPluginFn("IDToPathContext", &idToPathContext)
 	pw.loadPluginFn("Initialize", &initialize)
 	pw.loadPluginFn("Teardown", &teardown)
 	pw.loadPluginFn("WrapHandler", &wrapHandler)
 	pw.loadPluginFn("PurgeCaches", &prgCache)
 	pw.loadPluginFn("ExpireCachedImage", &expCachedImg)
 	pw.loadPluginFn("ImageDecoders", &imageDecoders)
+	pw.loadPluginFn("NamedImageDecoders", &namedImageDecoders)
+	pw.loadPluginFn("ImageEncoders", &imageEncoders)
+	pw.loadPluginFn("StartSpan", &startSpan)
+	pw.loadPluginFn("DecorateInfo", &decorateInfo)
 
 	if len(pw.errors) != 0 {
 		return errors.New(strings.Join(pw.errors, ", "))
@@ -152,9 +276,29 @@ func loadPlugin(fullpath string, l *logger.Logger) error {
 		return fmt.Errorf("no known functions exposed")
 	}
 
+	err = pw.readDeclarations()
+	if err == nil {
+		err = pw.checkCapabilities()
+	}
+	if err != nil {
+		return err
+	}
+	if pw.version < plugins.APIVersion {
+		l.Warnf("%q uses plugin API version %d; hooks are found by name only, so "+
+			"misnamed or outdated functions are silently ignored", fullpath, pw.version)
+	}
+
+	// Decode workers only need decoders, and mustn't initialize anything else,
+	// since a plugin's Initialize may start background work meant to run once
+	if decodeWorkerMode && imageDecoders == nil && namedImageDecoders == nil {
+		return nil
+	}
+
 	// We need to call SetLogger and Initialize immediately, as they're never
-	// called a second time and they tell us if the plugin is going to be used
-	log(l)
+	// called a second time and they tell us if the plugin is going to be used.
+	// The plugin's logger is named for its file ("s3-images" for
+	// s3-images.so), which is how LogLevels refers to it.
+	log(componentLogger(strings.TrimSuffix(filepath.Base(fullpath), ".so")))
 	initialize()
 
 	// After initialization, we check if the plugin explicitly set itself to Disabled
@@ -178,10 +322,26 @@ func loadPlugin(fullpath string, l *logger.Logger) error {
 			img.RegisterDecoder(fn)
 		}
 	}
+	if namedImageDecoders != nil {
+		for _, nd := range namedImageDecoders() {
+			img.RegisterNamedDecoder(nd)
+		}
+	}
+
+	// Register image encoder(s) the same way
+	if imageEncoders != nil {
+		for _, e := range imageEncoders() {
+			img.RegisterEncoder(e)
+		}
+	}
 
 	// Index remaining functions
-	if idToPath != nil {
-		idToPathPlugins = append(idToPathPlugins, idToPath)
+	// IDToPathContext lets a plugin abandon slow work, like downloads, for
+	// canceled requests, so it's preferred when a plugin exports both
+	if idToPathContext != nil {
+		idToPathPlugins = append(idToPathPlugins, idToPathContext)
+	} else if idToPath != nil {
+		idToPathPlugins = append(idToPathPlugins, ignoreContext(idToPath))
 	}
 	if teardown != nil {
 		teardownPlugins = append(teardownPlugins, teardown)
@@ -195,12 +355,27 @@ func loadPlugin(fullpath string, l *logger.Logger) error {
 	if expCachedImg != nil {
 		expireCachedImagePlugins = append(expireCachedImagePlugins, expCachedImg)
 	}
+	if startSpan != nil {
+		startSpanPlugins = append(startSpanPlugins, startSpan)
+	}
+	if decorateInfo != nil {
+		decorateInfoPlugins = append(decorateInfoPlugins, decorateInfo)
+	}
 
 	// Add info to stats
 	stats.Plugins = append(stats.Plugins, plugStats{
-		Path:      fullpath,
-		Functions: pw.functions,
+		Path:         fullpath,
+		APIVersion:   pw.version,
+		Capabilities: pw.capabilities,
+		Functions:    pw.functions,
 	})
 
 	return nil
 }
+
+// ignoreContext adapts an IDToPath function which doesn't take a context
+func ignoreContext(fn func(iiif.ID) (string, error)) func(context.Context, iiif.ID) (string, error) {
+	return func(_ context.Context, id iiif.ID) (string, error) {
+		return fn(id)
+	}
+}
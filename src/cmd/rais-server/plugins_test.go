@@ -0,0 +1,30 @@
+package main
+
+import (
+	"rais/src/plugins"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestCheckCapabilities(t *testing.T) {
+	var pw = &pluginWrapper{
+		version:      2,
+		functions:    []string{"SetLogger", "Initialize", "IDToPath", "PurgeCaches"},
+		capabilities: []string{plugins.CapIDToPath, plugins.CapCachePurge},
+	}
+	assert.NilError(pw.checkCapabilities(), "matching capabilities", t)
+
+	pw.capabilities = []string{plugins.CapIDToPath}
+	assert.True(pw.checkCapabilities() != nil, "PurgeCaches exported without CachePurge", t)
+
+	pw.capabilities = []string{plugins.CapIDToPath, plugins.CapCachePurge, plugins.CapTracer}
+	assert.True(pw.checkCapabilities() != nil, "Tracer declared without StartSpan", t)
+
+	pw.capabilities = []string{plugins.CapIDToPath, plugins.CapCachePurge, "Teleport"}
+	assert.True(pw.checkCapabilities() != nil, "unknown capability", t)
+
+	pw.version = 1
+	pw.capabilities = nil
+	assert.NilError(pw.checkCapabilities(), "version 1 plugins aren't checked", t)
+}
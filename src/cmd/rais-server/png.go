@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// pngCompressionLevels maps the PNGCompression setting to Go's levels
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// pngOptions decides how PNGs are encoded.  The zero value encodes images
+// exactly as Go's PNG library would on its own.
+type pngOptions struct {
+	// compression is the zlib compression level
+	compression png.CompressionLevel
+
+	// bitDepth is 8 to reduce 16-bit images to 8 bits, or 1, 2, or 4 to reduce
+	// grayscale images to 2, 4, or 16 shades.  Zero keeps the decoded depth.
+	bitDepth int
+
+	// paletteMaxPixels is the largest image, in pixels, which is checked for
+	// having few enough colors to be stored as a palette.  Zero disables the
+	// check.
+	paletteMaxPixels int
+}
+
+// pngSettings is the server's PNG encoding setup
+var pngSettings = &pngOptions{}
+
+// parsePNGCompression returns the compression level for the given setting
+func parsePNGCompression(val string) (png.CompressionLevel, error) {
+	var level, ok = pngCompressionLevels[strings.ToLower(val)]
+	if !ok && val != "" {
+		return 0, fmt.Errorf("unknown PNG compression %q", val)
+	}
+	return level, nil
+}
+
+// encode writes i as a PNG
+func (o *pngOptions) encode(w io.Writer, i image.Image) error {
+	var enc = &png.Encoder{CompressionLevel: o.compression}
+	return enc.Encode(w, o.prepare(i))
+}
+
+// prepare converts i to the smallest representation the options allow.  Go's
+// encoder picks the PNG type and bit depth from the image's type, so this is
+// where bit depth and palette decisions are made.
+func (o *pngOptions) prepare(i image.Image) image.Image {
+	switch o.bitDepth {
+	case 1, 2, 4:
+		if g := grayLevels(i, 1<<uint(o.bitDepth)); g != nil {
+			return g
+		}
+	case 8:
+		i = to8Bit(i)
+	}
+
+	// Palettes hold 8-bit colors, so a 16-bit image can't become one without
+	// losing information
+	if o.paletteMaxPixels > 0 && !is16Bit(i) {
+		var b = i.Bounds()
+		if b.Dx()*b.Dy() <= o.paletteMaxPixels {
+			if p := toPaletted(i); p != nil {
+				return p
+			}
+		}
+	}
+
+	return i
+}
+
+func is16Bit(i image.Image) bool {
+	switch i.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		return true
+	}
+	return false
+}
+
+// to8Bit returns an 8-bit copy of i if it's a 16-bit image, or i otherwise
+func to8Bit(i image.Image) image.Image {
+	if !is16Bit(i) {
+		return i
+	}
+
+	var b = i.Bounds()
+	if i.ColorModel() == color.Gray16Model {
+		var g = image.NewGray(b)
+		draw.Draw(g, b, i, b.Min, draw.Src)
+		return g
+	}
+	var rgba = image.NewNRGBA(b)
+	draw.Draw(rgba, b, i, b.Min, draw.Src)
+	return rgba
+}
+
+// grayLevels returns a paletted copy of i with the given number of evenly
+// spaced shades of gray, or nil if i isn't grayscale.  With two levels, this
+// makes a one-bit PNG, ideal for bitonal line art.
+func grayLevels(i image.Image, levels int) *image.Paletted {
+	var model = i.ColorModel()
+	if model != color.GrayModel && model != color.Gray16Model {
+		return nil
+	}
+
+	var pal = make(color.Palette, levels)
+	for n := range pal {
+		pal[n] = color.Gray{uint8(n * 255 / (levels - 1))}
+	}
+
+	var b = i.Bounds()
+	var p = image.NewPaletted(b, pal)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var v = int(color.GrayModel.Convert(i.At(x, y)).(color.Gray).Y)
+			p.SetColorIndex(x, y, uint8((v*(levels-1)+127)/255))
+		}
+	}
+	return p
+}
+
+// toPaletted returns a paletted copy of i if it has no more than 256
+// distinct colors, or nil if it has more.  Line art, maps, and text often
+// qualify, and the palette form is lossless and several times smaller.
+func toPaletted(i image.Image) *image.Paletted {
+	var b = i.Bounds()
+	var index = make(map[color.NRGBA]uint8)
+	var pal color.Palette
+	var p = image.NewPaletted(b, nil)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var c = color.NRGBAModel.Convert(i.At(x, y)).(color.NRGBA)
+			var n, ok = index[c]
+			if !ok {
+				if len(pal) == 256 {
+					return nil
+				}
+				n = uint8(len(pal))
+				index[c] = n
+				pal = append(pal, c)
+			}
+			p.SetColorIndex(x, y, n)
+		}
+	}
+	p.Palette = pal
+	return p
+}
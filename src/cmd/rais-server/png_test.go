@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// pngHeader encodes i with o and returns the PNG's bit depth and color type
+func pngHeader(o *pngOptions, i image.Image, t *testing.T) (depth, colorType byte) {
+	var buf bytes.Buffer
+	assert.NilError(o.encode(&buf, i), "encoding PNG", t)
+	var _, err = png.Decode(bytes.NewReader(buf.Bytes()))
+	assert.NilError(err, "decoding PNG", t)
+	// The IHDR chunk's data starts 16 bytes in: 4-byte width and height, then
+	// bit depth and color type
+	return buf.Bytes()[24], buf.Bytes()[25]
+}
+
+func TestPNGBitDepth(t *testing.T) {
+	var g = image.NewGray(image.Rect(0, 0, 4, 4))
+	g.SetGray(0, 0, color.Gray{200})
+	g.SetGray(1, 0, color.Gray{40})
+
+	var depth, ctype = pngHeader(&pngOptions{}, g, t)
+	assert.Equal(byte(8), depth, "default depth", t)
+	assert.Equal(byte(0), ctype, "default gray", t)
+
+	depth, ctype = pngHeader(&pngOptions{bitDepth: 1}, g, t)
+	assert.Equal(byte(1), depth, "one-bit output", t)
+	assert.Equal(byte(3), ctype, "gray levels are a palette", t)
+
+	var p = grayLevels(g, 2)
+	assert.Equal(uint8(1), p.ColorIndexAt(0, 0), "light pixels become white", t)
+	assert.Equal(uint8(0), p.ColorIndexAt(1, 0), "dark pixels become black", t)
+	assert.True(grayLevels(image.NewRGBA(g.Bounds()), 2) == nil, "color images aren't reduced", t)
+
+	var g16 = image.NewGray16(image.Rect(0, 0, 4, 4))
+	depth, _ = pngHeader(&pngOptions{}, g16, t)
+	assert.Equal(byte(16), depth, "16-bit images are kept by default", t)
+	depth, _ = pngHeader(&pngOptions{bitDepth: 8}, g16, t)
+	assert.Equal(byte(8), depth, "16-bit images reduced to 8 bits", t)
+	depth, _ = pngHeader(&pngOptions{paletteMaxPixels: 100}, g16, t)
+	assert.Equal(byte(16), depth, "16-bit images are never made into palettes", t)
+}
+
+func TestPNGPalette(t *testing.T) {
+	var i = image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for x := 0; x < 20; x++ {
+		i.Set(x, 0, color.RGBA{uint8(x), 0, 0, 255})
+	}
+
+	var o = &pngOptions{paletteMaxPixels: 400}
+	var _, ctype = pngHeader(o, i, t)
+	assert.Equal(byte(3), ctype, "few colors make a palette image", t)
+
+	o.paletteMaxPixels = 399
+	_, ctype = pngHeader(o, i, t)
+	assert.Equal(byte(6), ctype, "images over the size limit aren't checked", t)
+
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			i.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	assert.True(toPaletted(i) == nil, "images with over 256 colors can't use a palette", t)
+}
+
+func TestParsePNGCompression(t *testing.T) {
+	var level, err = parsePNGCompression("Best")
+	assert.NilError(err, "valid level", t)
+	assert.Equal(png.BestCompression, level, "best", t)
+	level, err = parsePNGCompression("")
+	assert.NilError(err, "empty level", t)
+	assert.Equal(png.DefaultCompression, level, "empty means default", t)
+	_, err = parsePNGCompression("max")
+	assert.True(err != nil, "invalid level", t)
+}
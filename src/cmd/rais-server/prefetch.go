@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"rais/src/iiif"
+	"strings"
+	"sync"
+)
+
+// prefetchJob is a neighboring tile to render into the tile cache
+type prefetchJob struct {
+	u    *iiif.URL
+	fp   string
+	info *iiif.Info
+}
+
+// tilePrefetcher renders the tiles around each requested tile into the tile
+// cache in the background, since a viewer panning a deep-zoom image is very
+// likely to ask for them within seconds.  The queue is bounded and jobs are
+// dropped when it's full, and prefetching pauses while the server is at
+// capacity, so real requests always come first.
+type tilePrefetcher struct {
+	ih      *ImageHandler
+	queue   chan prefetchJob
+	m       sync.Mutex
+	pending map[string]bool
+}
+
+// prefetcher is nil unless tile prefetching is enabled
+var prefetcher *tilePrefetcher
+
+// newTilePrefetcher starts workers goroutines to render queued tiles
+func newTilePrefetcher(ih *ImageHandler, workers, queueLen int) *tilePrefetcher {
+	var tp = &tilePrefetcher{ih: ih, queue: make(chan prefetchJob, queueLen), pending: make(map[string]bool)}
+	for i := 0; i < workers; i++ {
+		go tp.work()
+	}
+	return tp
+}
+
+// enqueue queues the tiles next to u, whose key is already known to be
+// cacheable, skipping any the queue has no room for
+func (tp *tilePrefetcher) enqueue(u *iiif.URL, fp string, info *iiif.Info) {
+	if tp == nil {
+		return
+	}
+
+	for _, n := range neighborTiles(u, info) {
+		var key = tp.ih.cacheKey(n, fp, info)
+		if key == "" {
+			continue
+		}
+
+		tp.m.Lock()
+		if tp.pending[key] {
+			tp.m.Unlock()
+			continue
+		}
+		select {
+		case tp.queue <- prefetchJob{u: n, fp: fp, info: info}:
+			tp.pending[key] = true
+		default:
+		}
+		tp.m.Unlock()
+	}
+}
+
+func (tp *tilePrefetcher) work() {
+	for job := range tp.queue {
+		tp.prefetch(job)
+	}
+}
+
+// prefetch renders a single tile unless it's already cached, another peer
+// owns it, or the server is too busy to spare the cycles
+func (tp *tilePrefetcher) prefetch(job prefetchJob) {
+	defer catchPanic(context.Background(), fmt.Sprintf("prefetching %q", job.u.Path), nil)
+	var key = tp.ih.cacheKey(job.u, job.fp, job.info)
+	defer func() {
+		tp.m.Lock()
+		delete(tp.pending, key)
+		tp.m.Unlock()
+	}()
+
+	if key == "" || load.report().Load >= 1 {
+		return
+	}
+	if _, ok := tileCache.Peek(key); ok {
+		return
+	}
+	if peers != nil && peers.ownerFor(context.Background(), key) != "" {
+		return
+	}
+
+	var err = tp.ih.regenerateTile(key, job.u, job.fp, job.info)
+	if err != nil {
+		Logger.Debugf("Unable to prefetch %q: %s", job.u.Path, err)
+	}
+}
+
+// neighborTiles returns the tiles directly above, below, left, and right of
+// u at the same zoom level, if u is one of the tiles advertised in info.json.
+// Edge tiles are clipped to the image, and sized the way viewers ask for
+// them, so prefetched tiles have the same cache keys as real requests.
+func neighborTiles(u *iiif.URL, info *iiif.Info) []*iiif.URL {
+	if u.Region.Type != iiif.RTPixel || info == nil {
+		return nil
+	}
+
+	var x, y = int(u.Region.X), int(u.Region.Y)
+	var tw, th, sf = tileScale(u, info)
+	if sf == 0 {
+		return nil
+	}
+
+	// The region, size, rotation, and quality are the last four parts of the
+	// path; everything before them is the ID as it was requested
+	var parts = strings.Split(u.Path, "/")
+	if len(parts) < 5 {
+		return nil
+	}
+	var prefix = strings.Join(parts[:len(parts)-4], "/")
+	var suffix = strings.Join(parts[len(parts)-2:], "/")
+
+	var list []*iiif.URL
+	for _, d := range [][2]int{{0, -1}, {-1, 0}, {1, 0}, {0, 1}} {
+		var nx, ny = x + d[0]*tw*sf, y + d[1]*th*sf
+		if nx < 0 || ny < 0 || nx >= info.Width || ny >= info.Height {
+			continue
+		}
+		var rw, rh = min(tw*sf, info.Width-nx), min(th*sf, info.Height-ny)
+		var size = fmt.Sprintf("%d,", ceilDiv(rw, sf))
+		if u.Size.Type == iiif.STExact {
+			size += fmt.Sprintf("%d", ceilDiv(rh, sf))
+		}
+		var path = fmt.Sprintf("%s/%d,%d,%d,%d/%s/%s", prefix, nx, ny, rw, rh, size, suffix)
+		var n, err = iiif.NewURL(path)
+		if err == nil && n.Valid() {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// tileScale returns the tile size and scale factor of u if it's one of the
+// tiles advertised in info, or a zero scale factor if it isn't
+func tileScale(u *iiif.URL, info *iiif.Info) (tw, th, sf int) {
+	if u.Size.Type != iiif.STScaleToWidth && u.Size.Type != iiif.STExact {
+		return 0, 0, 0
+	}
+
+	var x, y = int(u.Region.X), int(u.Region.Y)
+	var rw = int(u.Region.W)
+	for _, ts := range info.Tiles {
+		tw, th = ts.Width, ts.Height
+		if th == 0 {
+			th = tw
+		}
+		for _, s := range ts.ScaleFactors {
+			if s > 0 && x%(tw*s) == 0 && y%(th*s) == 0 && ceilDiv(rw, s) == u.Size.W && rw <= tw*s {
+				return tw, th, s
+			}
+		}
+	}
+	return 0, 0, 0
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestNeighborTiles(t *testing.T) {
+	var info = &iiif.Info{Width: 1000, Height: 600, Tiles: []iiif.TileSize{{Width: 256, ScaleFactors: []int{1, 2, 4}}}}
+	var paths = func(path string) []string {
+		var u, err = iiif.NewURL(path)
+		if err != nil {
+			t.Fatalf("Unable to parse %q: %s", path, err)
+		}
+		var list []string
+		for _, n := range neighborTiles(u, info) {
+			list = append(list, n.Path)
+		}
+		return list
+	}
+
+	var got = paths("a%2Fb.jp2/512,512,488,88/244,/0/default.jpg")
+	assert.Equal(2, len(got), "the corner tile at scale 2 only has neighbors above and to the left", t)
+	assert.IncludesString("a%2Fb.jp2/512,0,488,512/244,/0/default.jpg", got, "above", t)
+	assert.IncludesString("a%2Fb.jp2/0,512,512,88/256,/0/default.jpg", got, "left", t)
+
+	got = paths("a.jp2/256,0,256,256/256,256/90/gray.png")
+	assert.IncludesString("a.jp2/512,0,256,256/256,256/90/gray.png", got, "exact sizes and other parameters are kept", t)
+	assert.IncludesString("a.jp2/256,256,256,256/256,256/90/gray.png", got, "below", t)
+	assert.Equal(3, len(got), "nothing above the top row", t)
+
+	assert.Equal(0, len(paths("a.jp2/100,0,256,256/256,/0/default.jpg")), "regions off the grid aren't tiles", t)
+	assert.Equal(0, len(paths("a.jp2/full/256,/0/default.jpg")), "full images aren't tiles", t)
+	assert.Equal(0, len(paths("a.jp2/0,0,512,512/300,/0/default.jpg")), "sizes off the grid aren't tiles", t)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// trustedProxies holds the networks whose forwarding headers are honored.
+// When it's empty, all clients are trusted, which is only safe when RAIS
+// can't be reached except through a proxy.
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies reads a comma-separated list of IPs and CIDR ranges
+func parseTrustedProxies(val string) ([]*net.IPNet, error) {
+	var list []*net.IPNet
+	for _, s := range strings.Split(val, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		// Bare IPs are turned into single-address ranges
+		if !strings.Contains(s, "/") {
+			var ip = net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", s)
+			}
+			var bits = 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		var _, n, err = net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", s)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// fromTrustedProxy returns true if req's forwarding headers may be used
+func fromTrustedProxy(req *http.Request) bool {
+	if len(trustedProxies) == 0 {
+		return true
+	}
+
+	// Unix socket peers are always on this host
+	if _, ok := req.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		return true
+	}
+
+	var host, _, err = net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	var ip = net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedValues returns the host and proto from the first element of an
+// RFC 7239 Forwarded header, which describes the proxy closest to the client
+func forwardedValues(header string) (host, proto string) {
+	var first = strings.SplitN(header, ",", 2)[0]
+	for _, pair := range strings.Split(first, ";") {
+		var kv = strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		var val = strings.Trim(kv[1], `"`)
+		switch strings.ToLower(kv[0]) {
+		case "host":
+			host = val
+		case "proto":
+			proto = val
+		}
+	}
+	return host, proto
+}
+
+// firstValue returns the first of a header's comma-separated values
+func firstValue(req *http.Request, key string) string {
+	return strings.TrimSpace(strings.SplitN(req.Header.Get(key), ",", 2)[0])
+}
+
+// cleanPrefix normalizes a path prefix to either "" or a clean path with a
+// leading slash and no trailing slash.  Prefixes which browsers could read as
+// the start of a URL with a host, i.e., those with a backslash or a leading
+// "//", are dropped.
+func cleanPrefix(p string) string {
+	if p == "" || strings.Contains(p, `\`) || strings.HasPrefix(p, "//") {
+		return ""
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return ""
+	}
+	return p
+}
+
+// getRequestURL determines the "real" base URL of the request: its scheme,
+// host, and the path prefix, if any, a reverse proxy mounted RAIS under.
+// Trusted proxies may set these with a Forwarded header (host and proto),
+// X-Forwarded-Host and X-Forwarded-Proto, and X-Forwarded-Prefix, with
+// Forwarded taking precedence.
+//
+// Without TrustedProxies, anybody reaching RAIS directly can fake these
+// headers.  Since they only determine how RAIS reports its URLs in info.json
+// responses, that's not much of a risk, but it's better avoided.
+func getRequestURL(req *http.Request) *url.URL {
+	var u = &url.URL{
+		Host:   req.Host,
+		Scheme: "http",
+	}
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+	if !fromTrustedProxy(req) {
+		return u
+	}
+
+	var host, proto = forwardedValues(req.Header.Get("Forwarded"))
+	if host == "" {
+		host = firstValue(req, "X-Forwarded-Host")
+	}
+	if proto == "" {
+		proto = firstValue(req, "X-Forwarded-Proto")
+	}
+	if host != "" {
+		u.Host = host
+	}
+	if proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+	u.Path = cleanPrefix(firstValue(req, "X-Forwarded-Prefix"))
+
+	return u
+}
+
+// redirectPrefix returns the proxy path prefix for the Location of redirects.
+// Redirects may be cached and served to other clients, so unlike
+// getRequestURL, this only honors X-Forwarded-Prefix from proxies explicitly
+// listed in TrustedProxies.
+func redirectPrefix(req *http.Request) string {
+	if len(trustedProxies) == 0 || !fromTrustedProxy(req) {
+		return ""
+	}
+	return cleanPrefix(firstValue(req, "X-Forwarded-Prefix"))
+}
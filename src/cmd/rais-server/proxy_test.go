@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func proxyRequest(headers map[string]string) *http.Request {
+	var req, _ = http.NewRequest("GET", "/foo/bar/x/info.json", nil)
+	req.Host = "internal:12415"
+	req.RemoteAddr = "10.0.0.5:34567"
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	var list, err = parseTrustedProxies(" 10.0.0.5, 192.168.1.0/24,::1 ,")
+	assert.NilError(err, "parsing a valid list", t)
+	assert.Equal(3, len(list), "blank entries are skipped", t)
+	assert.Equal("10.0.0.5/32", list[0].String(), "bare IPv4 addresses are single-address ranges", t)
+	assert.Equal("::1/128", list[2].String(), "bare IPv6 addresses are single-address ranges", t)
+
+	_, err = parseTrustedProxies("10.0.0.5, nope")
+	assert.True(err != nil, "invalid IPs are rejected", t)
+	_, err = parseTrustedProxies("10.0.0.0/99")
+	assert.True(err != nil, "invalid ranges are rejected", t)
+}
+
+func TestGetRequestURL(t *testing.T) {
+	var u = getRequestURL(proxyRequest(nil))
+	assert.Equal("http://internal:12415", u.String(), "no headers", t)
+
+	u = getRequestURL(proxyRequest(map[string]string{
+		"X-Forwarded-Host":   "pub.example.com, internal",
+		"X-Forwarded-Proto":  "https",
+		"X-Forwarded-Prefix": "/images/",
+	}))
+	assert.Equal("https://pub.example.com/images", u.String(), "X-Forwarded-* headers", t)
+
+	u = getRequestURL(proxyRequest(map[string]string{
+		"Forwarded":         `for=1.2.3.4;host="fwd.example.com";proto=https, for=10.0.0.5`,
+		"X-Forwarded-Host":  "pub.example.com",
+		"X-Forwarded-Proto": "http",
+	}))
+	assert.Equal("https://fwd.example.com", u.String(), "Forwarded takes precedence", t)
+
+	u = getRequestURL(proxyRequest(map[string]string{"X-Forwarded-Proto": "gopher"}))
+	assert.Equal("http", u.Scheme, "unknown schemes are ignored", t)
+
+	u = getRequestURL(proxyRequest(map[string]string{"X-Forwarded-Prefix": "/"}))
+	assert.Equal("", u.Path, "a root prefix is no prefix", t)
+	u = getRequestURL(proxyRequest(map[string]string{"X-Forwarded-Prefix": `\evil.com`}))
+	assert.Equal("", u.Path, "prefixes with backslashes are dropped", t)
+	u = getRequestURL(proxyRequest(map[string]string{"X-Forwarded-Prefix": "//evil.com/x"}))
+	assert.Equal("", u.Path, "prefixes starting with // are dropped", t)
+}
+
+func TestGetRequestURLTrustedProxies(t *testing.T) {
+	var headers = map[string]string{"X-Forwarded-Host": "pub.example.com", "X-Forwarded-Prefix": "/images"}
+	defer func() { trustedProxies = nil }()
+
+	trustedProxies, _ = parseTrustedProxies("10.0.0.0/24")
+	var u = getRequestURL(proxyRequest(headers))
+	assert.Equal("http://pub.example.com/images", u.String(), "trusted proxy's headers are used", t)
+
+	trustedProxies, _ = parseTrustedProxies("192.168.1.1")
+	u = getRequestURL(proxyRequest(headers))
+	assert.Equal("http://internal:12415", u.String(), "untrusted client's headers are ignored", t)
+
+	var req = proxyRequest(headers)
+	req.RemoteAddr = "@"
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/rais.sock", Net: "unix"}))
+	u = getRequestURL(req)
+	assert.Equal("http://pub.example.com/images", u.String(), "unix socket peers are trusted", t)
+}
+
+func TestInfoIDWithProxyPrefix(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/foo/bar")
+	h.FeatureSet = iiif.FeatureSet1()
+	h.Maximums = unlimited
+	var path = "/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2"
+
+	var req = proxyRequest(map[string]string{"X-Forwarded-Host": "pub.example.com", "X-Forwarded-Prefix": "/images"})
+	req.URL, _ = url.Parse(path + "/info.json")
+	var w = fakehttp.NewResponseWriter()
+	h.IIIFRoute(w, req)
+	var data iiif.Info
+	json.Unmarshal(w.Output, &data)
+	assert.Equal("http://pub.example.com/images"+path, data.ID, "ID from forwarded prefix", t)
+
+	h.BaseURL, _ = url.Parse("https://canonical.example.com/rais")
+	w = fakehttp.NewResponseWriter()
+	h.IIIFRoute(w, req)
+	json.Unmarshal(w.Output, &data)
+	assert.Equal("https://canonical.example.com/rais"+path, data.ID, "ID from base URL's path", t)
+
+	req.URL, _ = url.Parse(path)
+	w = fakehttp.NewResponseWriter()
+	h.IIIFRoute(w, req)
+	assert.Equal(303, w.StatusCode, "base URI redirects", t)
+	assert.Equal("/rais"+path+"/info.json", w.Headers["Location"][0], "redirect includes the prefix", t)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"rais/src/cmd/rais-server/internal/decodeworker"
+	"rais/src/iiif"
+	"rais/src/img"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errDecoderPanic is returned in place of a decode which panicked
+var errDecoderPanic = errors.New("the image decoder crashed")
+
+// quarantine holds images whose decodes crash or time out.  It's nil when
+// QuarantineTTL isn't set.
+var quarantine *quarantineList
+
+// quarantined records an image RAIS won't try to decode until Expires
+type quarantined struct {
+	ID      iiif.ID   `json:"id"`
+	Reason  string    `json:"reason"`
+	Expires time.Time `json:"expires"`
+}
+
+// strikes counts recent decode timeouts for an image which isn't yet
+// quarantined.  The count starts over once expires passes.
+type strikes struct {
+	count   int
+	expires time.Time
+}
+
+// quarantineList protects the server from images which break the decoder:
+// a single panic or worker crash quarantines an image right away, while
+// timeouts, which can also just mean a busy server, only quarantine an image
+// after several within the TTL.  Quarantined images aren't decoded until the
+// TTL passes; requests for them get a 502.  Entries are kept by file ID (see
+// fileID), so a page suffix or alias can't get a quarantined file decoded
+// again.
+type quarantineList struct {
+	m        sync.Mutex
+	ttl      time.Duration
+	timeouts int
+	items    map[iiif.ID]*quarantined
+	strikes  map[iiif.ID]*strikes
+	now      func() time.Time
+}
+
+func newQuarantineList(ttl time.Duration, timeouts int) *quarantineList {
+	return &quarantineList{
+		ttl:      ttl,
+		timeouts: timeouts,
+		items:    make(map[iiif.ID]*quarantined),
+		strikes:  make(map[iiif.ID]*strikes),
+		now:      time.Now,
+	}
+}
+
+func setupQuarantine() {
+	if conf.QuarantineTTL <= 0 {
+		return
+	}
+	quarantine = newQuarantineList(conf.QuarantineTTL, conf.QuarantineTimeouts)
+
+	// A replaced image deserves a fresh start
+	purgeCachePlugins = append(purgeCachePlugins, quarantine.clear)
+	expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { quarantine.remove(id) })
+}
+
+// get returns the quarantine for id, or nil if it isn't quarantined
+func (ql *quarantineList) get(id iiif.ID) *quarantined {
+	if ql == nil {
+		return nil
+	}
+
+	id = fileID(id)
+	ql.m.Lock()
+	defer ql.m.Unlock()
+	var q = ql.items[id]
+	if q != nil && !ql.now().Before(q.Expires) {
+		delete(ql.items, id)
+		return nil
+	}
+	return q
+}
+
+// add quarantines id for the TTL.  ql.m must be locked.
+func (ql *quarantineList) add(id iiif.ID, reason string) {
+	var q = &quarantined{ID: id, Reason: reason, Expires: ql.now().Add(ql.ttl)}
+	ql.items[id] = q
+	delete(ql.strikes, id)
+	Logger.Warnf("Quarantining %q until %s: %s", id, q.Expires.Format(time.RFC3339), reason)
+}
+
+// crashed quarantines id immediately
+func (ql *quarantineList) crashed(id iiif.ID) {
+	if ql == nil {
+		return
+	}
+
+	ql.m.Lock()
+	defer ql.m.Unlock()
+	ql.add(fileID(id), errDecoderPanic.Error())
+}
+
+// timedOut records a decode timeout for id, quarantining it if it's timed
+// out too often.  Timeouts are ignored if the limit is zero.
+func (ql *quarantineList) timedOut(id iiif.ID) {
+	if ql == nil || ql.timeouts <= 0 {
+		return
+	}
+
+	id = fileID(id)
+	ql.m.Lock()
+	defer ql.m.Unlock()
+	var now = ql.now()
+	var s = ql.strikes[id]
+	if s == nil || !now.Before(s.expires) {
+		s = &strikes{expires: now.Add(ql.ttl)}
+		ql.strikes[id] = s
+	}
+	s.count++
+	if s.count >= ql.timeouts {
+		ql.add(id, fmt.Sprintf("decoding timed out %d times", s.count))
+	}
+}
+
+// remove releases id, returning false if it wasn't quarantined
+func (ql *quarantineList) remove(id iiif.ID) bool {
+	id = fileID(id)
+	ql.m.Lock()
+	defer ql.m.Unlock()
+	var _, ok = ql.items[id]
+	delete(ql.items, id)
+	delete(ql.strikes, id)
+	return ok
+}
+
+// clear releases everything
+func (ql *quarantineList) clear() {
+	ql.m.Lock()
+	defer ql.m.Unlock()
+	ql.items = make(map[iiif.ID]*quarantined)
+	ql.strikes = make(map[iiif.ID]*strikes)
+}
+
+// list returns all current quarantines ordered by ID
+func (ql *quarantineList) list() []*quarantined {
+	ql.m.Lock()
+	defer ql.m.Unlock()
+	var now = ql.now()
+	var list = []*quarantined{}
+	for _, q := range ql.items {
+		if now.Before(q.Expires) {
+			list = append(list, q)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// write sends a 502 explaining why the image can't be served, and when it
+// can be tried again
+func (q *quarantined) write(w http.ResponseWriter) {
+	var secs = int(math.Ceil(time.Until(q.Expires).Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, q.message(), http.StatusBadGateway)
+}
+
+// message explains the quarantine to clients
+func (q *quarantined) message() string {
+	return fmt.Sprintf("This image is temporarily quarantined because decoding it failed (%s); it will be retried after %s",
+		q.Reason, q.Expires.UTC().Format(time.RFC3339))
+}
+
+// decode runs res.Apply, quarantining the image if the decoder panics, its
+// worker process crashes, or it runs out of time.  A panic is logged with its
+// stack trace and returned as errDecoderPanic, so one broken image can't take
+// down the server, even when it's decoded in the background.
+func decode(ctx context.Context, res *img.Resource, u *iiif.URL, max img.Constraint) (i image.Image, err error) {
+	defer func() {
+		var p = recover()
+		if p == nil {
+			return
+		}
+		requestLogger(ctx).Errorf("Decoder panic on %q (path %s): %v\n%s", u.Path, res.FilePath, p, debug.Stack())
+		quarantine.crashed(u.ID)
+		i, err = nil, errDecoderPanic
+	}()
+
+	i, err = res.Apply(u, max)
+	if errors.Is(err, decodeworker.ErrCrashed) {
+		requestLogger(ctx).Errorf("Decode worker crashed on %q (path %s)", u.Path, res.FilePath)
+		quarantine.crashed(u.ID)
+	} else if err != nil && ctx.Err() == context.DeadlineExceeded {
+		quarantine.timedOut(u.ID)
+	}
+	return i, err
+}
+
+// adminQuarantine lists all quarantined images
+func adminQuarantine(w http.ResponseWriter, req *http.Request) {
+	var list = []*quarantined{}
+	if quarantine != nil {
+		list = quarantine.list()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// adminRelease lifts an image's quarantine; it must be a POST with "id" set
+func adminRelease(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var id = iiif.ID(req.PostFormValue("id"))
+	if quarantine == nil || !quarantine.remove(id) {
+		http.Error(w, "id is not quarantined", http.StatusNotFound)
+		return
+	}
+	Logger.Infof("Released %q from quarantine", id)
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"net/http"
+	"rais/src/cmd/rais-server/internal/decodeworker"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// panicDecoder is a 100x100 image which blows up when it's decoded
+type panicDecoder struct{}
+
+func (panicDecoder) DecodeImage() (image.Image, error) { panic("corrupt codestream") }
+func (panicDecoder) GetWidth() int                     { return 100 }
+func (panicDecoder) GetHeight() int                    { return 100 }
+func (panicDecoder) GetTileWidth() int                 { return 0 }
+func (panicDecoder) GetTileHeight() int                { return 0 }
+func (panicDecoder) GetLevels() int                    { return 1 }
+func (panicDecoder) SetCrop(image.Rectangle)           {}
+func (panicDecoder) SetResizeWH(int, int)              {}
+
+// crashDecoder is a panicDecoder whose worker process dies instead
+type crashDecoder struct{ panicDecoder }
+
+func (crashDecoder) DecodeImage() (image.Image, error) { return nil, decodeworker.ErrCrashed }
+
+func TestQuarantineTimeouts(t *testing.T) {
+	var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ql = newQuarantineList(time.Minute, 2)
+	ql.now = func() time.Time { return now }
+
+	ql.timedOut("slow.jp2")
+	assert.True(ql.get("slow.jp2") == nil, "one timeout isn't enough", t)
+	now = now.Add(2 * time.Minute)
+	ql.timedOut("slow.jp2")
+	assert.True(ql.get("slow.jp2") == nil, "old timeouts are forgotten", t)
+	ql.timedOut("slow.jp2")
+	var q = ql.get("slow.jp2")
+	assert.True(q != nil, "two timeouts within the TTL quarantine the image", t)
+	assert.Equal("decoding timed out 2 times", q.Reason, "reason", t)
+	assert.Equal(1, len(ql.list()), "image is listed", t)
+
+	now = now.Add(time.Minute)
+	assert.True(ql.get("slow.jp2") == nil, "quarantine expires", t)
+	assert.Equal(0, len(ql.list()), "expired quarantines aren't listed", t)
+
+	ql = newQuarantineList(time.Minute, 0)
+	ql.timedOut("slow.jp2")
+	assert.True(ql.get("slow.jp2") == nil, "timeouts can be ignored", t)
+}
+
+func TestQuarantineRemove(t *testing.T) {
+	var ql = newQuarantineList(time.Minute, 3)
+	ql.crashed("bad.jp2")
+	assert.True(ql.get("bad.jp2") != nil, "a panic quarantines the image immediately", t)
+	assert.True(ql.remove("bad.jp2"), "quarantined image is released", t)
+	assert.True(ql.get("bad.jp2") == nil, "released image isn't quarantined", t)
+	assert.False(ql.remove("bad.jp2"), "releasing it again does nothing", t)
+
+	var nilList *quarantineList
+	nilList.crashed("bad.jp2")
+	assert.True(nilList.get("bad.jp2") == nil, "a disabled quarantine never quarantines", t)
+}
+
+func TestQuarantinePages(t *testing.T) {
+	var ql = newQuarantineList(time.Minute, 3)
+	ql.crashed("reel.tif;2")
+	assert.True(ql.get("reel.tif") != nil, "a crash on one page quarantines the file", t)
+	assert.True(ql.get("reel.tif;0") != nil, "page suffixes don't get around a quarantine", t)
+	assert.Equal(iiif.ID("reel.tif"), ql.list()[0].ID, "the file is listed", t)
+	assert.True(ql.remove("reel.tif;1"), "any page's ID releases the file", t)
+	assert.True(ql.get("reel.tif") == nil, "released file isn't quarantined", t)
+}
+
+func TestQuarantineWrite(t *testing.T) {
+	var q = &quarantined{ID: "bad.jp2", Reason: "the image decoder crashed", Expires: time.Now().Add(90 * time.Second)}
+	var w = fakehttp.NewResponseWriter()
+	q.write(w)
+	assert.Equal(http.StatusBadGateway, w.StatusCode, "quarantined images are a 502", t)
+	assert.Equal("90", w.Header().Get("Retry-After"), "Retry-After is the time remaining", t)
+	assert.True(strings.Contains(string(w.Output), "the image decoder crashed"), "body explains why", t)
+}
+
+func TestDecodePanic(t *testing.T) {
+	defer func(ql *quarantineList) { quarantine = ql }(quarantine)
+	quarantine = newQuarantineList(time.Minute, 3)
+
+	var u, err = iiif.NewURL("bad.jp2/full/max/0/default.jpg")
+	assert.NilError(err, "parsing URL", t)
+	var res = &img.Resource{ID: u.ID, Decoder: panicDecoder{}, FilePath: "/var/images/bad.jp2"}
+	var i image.Image
+	i, err = decode(context.Background(), res, u, unlimited)
+	assert.True(i == nil, "no image", t)
+	assert.Equal(errDecoderPanic, err, "panic becomes an error", t)
+	assert.True(quarantine.get("bad.jp2") != nil, "image is quarantined", t)
+}
+
+func TestDecodeWorkerCrash(t *testing.T) {
+	defer func(ql *quarantineList) { quarantine = ql }(quarantine)
+	quarantine = newQuarantineList(time.Minute, 3)
+
+	var u, err = iiif.NewURL("bad.jp2/full/max/0/default.jpg")
+	assert.NilError(err, "parsing URL", t)
+	var res = &img.Resource{ID: u.ID, Decoder: crashDecoder{}, FilePath: "/var/images/bad.jp2"}
+	_, err = decode(context.Background(), res, u, unlimited)
+	assert.True(errors.Is(err, decodeworker.ErrCrashed), "crash is returned", t)
+	assert.True(quarantine.get("bad.jp2") != nil, "image is quarantined", t)
+}
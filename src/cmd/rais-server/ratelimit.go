@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter caps how fast, and how many requests at once, any single client
+// may hit the IIIF endpoints.  Clients are identified by IP address unless
+// keyHeader is set and present in a request (e.g., "X-API-Key", or
+// "X-Real-IP" when RAIS is behind a proxy).
+type rateLimiter struct {
+	m             sync.Mutex
+	rate          float64
+	burst         float64
+	maxConcurrent int
+	keyHeader     string
+	clients       map[string]*client
+	lastSweep     time.Time
+	now           func() time.Time
+}
+
+// client holds the token bucket and in-flight count for a single client
+type client struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// sweepInterval is how often we forget clients who have gone quiet
+const sweepInterval = time.Minute
+
+func newRateLimiter(rate float64, burst int, maxConcurrent int, keyHeader string) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{
+		rate:          rate,
+		burst:         float64(burst),
+		maxConcurrent: maxConcurrent,
+		keyHeader:     keyHeader,
+		clients:       make(map[string]*client),
+		now:           time.Now,
+	}
+}
+
+// clientKey returns the string identifying the client making the request
+func (rl *rateLimiter) clientKey(req *http.Request) string {
+	if rl.keyHeader != "" {
+		var k = req.Header.Get(rl.keyHeader)
+		if k != "" {
+			return k
+		}
+	}
+
+	var host, _, err = net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// acquire tries to reserve a slot for the client.  On success, ok is true and
+// the caller must call release when the request is done.  Otherwise retry is
+// how long the client should wait before trying again.
+func (rl *rateLimiter) acquire(key string) (ok bool, remaining int, retry time.Duration) {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	var now = rl.now()
+	rl.sweep(now)
+
+	var c = rl.clients[key]
+	if c == nil {
+		c = &client{tokens: rl.burst, last: now}
+		rl.clients[key] = c
+	}
+
+	if rl.rate > 0 {
+		c.tokens = math.Min(rl.burst, c.tokens+now.Sub(c.last).Seconds()*rl.rate)
+		c.last = now
+		if c.tokens < 1 {
+			var wait = (1 - c.tokens) / rl.rate
+			return false, 0, time.Duration(wait * float64(time.Second))
+		}
+	}
+
+	if rl.maxConcurrent > 0 && c.inFlight >= rl.maxConcurrent {
+		return false, int(c.tokens), time.Second
+	}
+
+	if rl.rate > 0 {
+		c.tokens--
+	}
+	c.inFlight++
+	return true, int(c.tokens), 0
+}
+
+// release frees the client's concurrency slot
+func (rl *rateLimiter) release(key string) {
+	rl.m.Lock()
+	var c = rl.clients[key]
+	if c != nil {
+		c.inFlight--
+	}
+	rl.m.Unlock()
+}
+
+// sweep removes clients with nothing in flight whose buckets have refilled,
+// since they're indistinguishable from new clients.  rl.m must be locked.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < sweepInterval {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, c := range rl.clients {
+		if c.inFlight > 0 {
+			continue
+		}
+		if rl.rate > 0 && c.tokens+now.Sub(c.last).Seconds()*rl.rate < rl.burst {
+			continue
+		}
+		delete(rl.clients, key)
+	}
+}
+
+// wrap returns a handler which rejects requests over the client's limits with
+// a 429, and otherwise passes them on to next
+func (rl *rateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var key = rl.clientKey(req)
+		var ok, remaining, retry = rl.acquire(key)
+		if rl.rate > 0 {
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatFloat(rl.rate, 'f', -1, 64))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		}
+		if !ok {
+			atomic.AddUint64(&stats.RateLimited, 1)
+			var secs = int(math.Ceil(retry.Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		defer rl.release(key)
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"rais/src/fakehttp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestRateLimiterTokens(t *testing.T) {
+	var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var rl = newRateLimiter(2, 2, 0, "")
+	rl.now = func() time.Time { return now }
+
+	var ok, _, _ = rl.acquire("a")
+	assert.True(ok, "first request allowed", t)
+	ok, _, _ = rl.acquire("a")
+	assert.True(ok, "second request allowed by burst", t)
+	ok, _, retry := rl.acquire("a")
+	assert.False(ok, "third request is limited", t)
+	assert.Equal(500*time.Millisecond, retry, "retry after one token refills", t)
+
+	ok, _, _ = rl.acquire("b")
+	assert.True(ok, "other clients are unaffected", t)
+
+	now = now.Add(time.Second)
+	ok, _, _ = rl.acquire("a")
+	assert.True(ok, "tokens refill over time", t)
+}
+
+func TestRateLimiterConcurrency(t *testing.T) {
+	var rl = newRateLimiter(0, 0, 1, "X-API-Key")
+	var block = make(chan struct{})
+	var started = make(chan struct{})
+	var h = rl.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-block
+	}))
+
+	var req, _ = http.NewRequest("GET", "/iiif/foo/info.json", strings.NewReader(""))
+	req.Header.Set("X-API-Key", "abc")
+	go h.ServeHTTP(fakehttp.NewResponseWriter(), req)
+	<-started
+
+	var w = fakehttp.NewResponseWriter()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusTooManyRequests, w.StatusCode, "second concurrent request is rejected", t)
+	assert.Equal("1", w.Header().Get("Retry-After"), "Retry-After is set", t)
+	close(block)
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
-	"path/filepath"
 	"rais/src/img"
 	"rais/src/openjpeg"
 )
 
+// jp2Decoder is our built-in JPEG 2000 decoder.  JPX files (.jpf, .jpx) are
+// often JP2-compatible and start with the same signature, but they aren't
+// mapped by default since openjpeg can't read all of them; they can be added
+// via DecoderExtensions.
+var jp2Decoder = img.NamedDecoder{
+	Name:       "openjpeg",
+	Extensions: []string{".jp2"},
+	Magic:      [][]byte{{0x00, 0x00, 0x00, 0x0C, 'j', 'P', ' ', ' ', 0x0D, 0x0A, 0x87, 0x0A}},
+	Decode:     decodeJP2,
+}
+
 func decodeJP2(path string) (img.Decoder, error) {
-	if filepath.Ext(path) == ".jp2" {
-		return openjpeg.NewJP2Image(path)
-	}
-	return nil, img.ErrNotHandled
+	return openjpeg.NewJP2Image(path)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/roi"
+	"strconv"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// roiAnalysisSize is the largest width or height of the copy of an image
+// roi.json analyzes.  Suggestions only need to be roughly right, so there's
+// no point scoring millions of pixels.
+const roiAnalysisSize = 256
+
+// roiCacheLen is how many roi.json results are remembered.  Each is tiny,
+// but computing one means decoding the image.
+const roiCacheLen = 10000
+
+// roiCache holds computed suggestions, keyed by the image's ID, its source
+// file's fingerprint, and the request's parameters, so a changed file never
+// gets a stale answer.  It's nil until the ROI service is enabled.
+var roiCache *lru.Cache
+
+// roiResult is the JSON served by roi.json.  Region is ready to drop into a
+// IIIF URL; the rest is the same region broken out for clients that want to
+// adjust it, in the full image's pixel coordinates.
+type roiResult struct {
+	Region string     `json:"region"`
+	X      int        `json:"x"`
+	Y      int        `json:"y"`
+	Width  int        `json:"width"`
+	Height int        `json:"height"`
+	Method roi.Method `json:"method"`
+}
+
+// setupROICache creates the roi.json cache and hooks it into cache purges
+func setupROICache() {
+	var err error
+	roiCache, err = lru.New(roiCacheLen)
+	if err != nil {
+		Logger.Fatalf("Unable to start ROI cache: %s", err)
+	}
+	purgeCachePlugins = append(purgeCachePlugins, roiCache.Purge)
+}
+
+// getCachedROI returns the cached suggestion for key, if there is one
+func getCachedROI(key string) (roiResult, bool) {
+	if roiCache == nil {
+		return roiResult{}, false
+	}
+	var v, ok = roiCache.Get(key)
+	if !ok {
+		return roiResult{}, false
+	}
+	return v.(roiResult), true
+}
+
+// parseAspect reads an aspect ratio given as "w:h" (e.g., "16:9") or a
+// single number (e.g., "1.5").  An empty value means a square.
+func parseAspect(val string) (float64, error) {
+	if val == "" {
+		return 1, nil
+	}
+
+	var a float64
+	var err error
+	var parts = strings.SplitN(val, ":", 2)
+	if len(parts) == 1 {
+		a, err = strconv.ParseFloat(val, 64)
+	} else {
+		var w, h float64
+		w, err = strconv.ParseFloat(parts[0], 64)
+		if err == nil {
+			h, err = strconv.ParseFloat(parts[1], 64)
+		}
+		a = w / h
+	}
+	if err != nil || a <= 0 || math.IsInf(a, 0) || math.IsNaN(a) {
+		return 0, fmt.Errorf("invalid aspect %q", val)
+	}
+	return a, nil
+}
+
+// ROI responds to a roi.json request with a suggested crop of the image for
+// thumbnails, optionally of the shape given by the "aspect" query parameter
+func (ih *ImageHandler) ROI(w http.ResponseWriter, req *http.Request, id iiif.ID) {
+	var aspect, err = parseAspect(req.URL.Query().Get("aspect"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var fp, info, ok = ih.resolveSidecar(req, w, id)
+	if !ok {
+		return
+	}
+
+	var key = fmt.Sprintf("%s|%s|%g|%s", id, sourceFingerprint(fp), aspect, ih.ROIMethod)
+	var result, cached = getCachedROI(key)
+	if !cached {
+		var e *HandlerError
+		result, e = ih.suggestROI(req, id, fp, info, aspect)
+		if e != nil {
+			e.write(w)
+			return
+		}
+		if roiCache != nil {
+			roiCache.Add(key, result)
+		}
+	}
+
+	var data []byte
+	data, err = json.Marshal(result)
+	if err != nil {
+		Logger.Errorf("Unable to marshal ROI for %s: %s", id, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	ih.cachePoliciesFor(id).setHeaders(w, req, id, true)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
+
+// suggestROI decodes a small copy of the image through the usual decode
+// pipeline, so plugins, routes, and resolution levels all work as they do
+// for a thumbnail request, and scales the suggested crop back up to the full
+// image's size
+func (ih *ImageHandler) suggestROI(req *http.Request, id iiif.ID, fp string, info *iiif.Info, aspect float64) (roiResult, *HandlerError) {
+	var ctx = req.Context()
+	var res, err = img.NewResourceContext(ctx, id, fp)
+	if err != nil {
+		return roiResult{}, newImageResError(err)
+	}
+	res.Filter = ih.Filter
+	res.Layers = ih.Layers
+
+	var size = fmt.Sprintf("!%d,%d", roiAnalysisSize, roiAnalysisSize)
+	if info.Width <= roiAnalysisSize && info.Height <= roiAnalysisSize {
+		size = "max"
+	}
+	var u *iiif.URL
+	u, err = iiif.NewURL(id.Escaped() + "/full/" + size + "/0/default.png")
+	if err != nil {
+		return roiResult{}, NewError(err.Error(), http.StatusBadRequest)
+	}
+
+	if q := quarantine.get(id); q != nil {
+		return roiResult{}, NewError(q.message(), http.StatusBadGateway)
+	}
+	var release func()
+	release, err = scheduler.acquire(ctx, classThumbnail)
+	if err != nil {
+		return roiResult{}, NewError("timed out waiting to decode image", http.StatusServiceUnavailable)
+	}
+	var done = load.start()
+	var _, endDecode = startSpan(ctx, "image.decode")
+	var i image.Image
+	i, err = decode(ctx, res, u, img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64})
+	endDecode()
+	done()
+	release()
+	if err != nil {
+		return roiResult{}, newImageResError(err)
+	}
+
+	var r = roi.Suggest(i, aspect, ih.ROIMethod)
+	var b = i.Bounds()
+	var sx, sy = float64(info.Width) / float64(b.Dx()), float64(info.Height) / float64(b.Dy())
+	var x1, y1 = int(math.Round(float64(r.Min.X-b.Min.X) * sx)), int(math.Round(float64(r.Min.Y-b.Min.Y) * sy))
+	var x2, y2 = int(math.Round(float64(r.Max.X-b.Min.X) * sx)), int(math.Round(float64(r.Max.Y-b.Min.Y) * sy))
+	if x2 > info.Width {
+		x2 = info.Width
+	}
+	if y2 > info.Height {
+		y2 = info.Height
+	}
+
+	return roiResult{
+		Region: fmt.Sprintf("%d,%d,%d,%d", x1, y1, x2-x1, y2-y1),
+		X:      x1,
+		Y:      y1,
+		Width:  x2 - x1,
+		Height: y2 - y1,
+		Method: ih.ROIMethod,
+	}, nil
+}
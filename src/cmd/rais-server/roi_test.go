@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"rais/src/roi"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestParseAspect(t *testing.T) {
+	var a, err = parseAspect("")
+	assert.NilError(err, "empty aspect", t)
+	assert.Equal(1.0, a, "empty aspect is a square", t)
+
+	a, err = parseAspect("16:9")
+	assert.NilError(err, "w:h aspect", t)
+	assert.Equal(16.0/9.0, a, "w:h aspect", t)
+
+	a, err = parseAspect("0.75")
+	assert.NilError(err, "numeric aspect", t)
+	assert.Equal(0.75, a, "numeric aspect", t)
+
+	for _, val := range []string{"wide", "4:0", "0", "-1", "3:"} {
+		_, err = parseAspect(val)
+		assert.True(err != nil, "invalid aspect "+val, t)
+	}
+}
+
+func TestROIRequest(t *testing.T) {
+	var ih = NewImageHandler(rootDir(), "/foo/bar")
+	ih.FeatureSet = iiif.FeatureSet2()
+	var path = "/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/roi.json?aspect=sideways"
+	var req, _ = http.NewRequest("GET", path, strings.NewReader(""))
+
+	var w = fakehttp.NewResponseWriter()
+	ih.IIIFRoute(w, req)
+	assert.Equal(400, w.StatusCode, "roi.json is an invalid IIIF request when the service is off", t)
+
+	ih.ROIMethod = roi.Entropy
+	w = fakehttp.NewResponseWriter()
+	ih.IIIFRoute(w, req)
+	assert.Equal(400, w.StatusCode, "invalid aspect", t)
+	assert.True(strings.Contains(string(w.Output), "invalid aspect"), "invalid aspect message", t)
+
+	req, _ = http.NewRequest("GET", "/foo/bar/nope.jp2/roi.json", strings.NewReader(""))
+	w = fakehttp.NewResponseWriter()
+	ih.IIIFRoute(w, req)
+	assert.Equal(404, w.StatusCode, "missing images are a 404", t)
+}
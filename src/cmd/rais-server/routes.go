@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Route maps IIIF IDs starting with Prefix to images under TilePath, so that
+// multiple collections can be served without a symlink farm.  The prefix is
+// stripped, so with a prefix of "maps:" and a tile path of "/mnt/gis", the ID
+// "maps:usgs/1901.jp2" is read from "/mnt/gis/usgs/1901.jp2".
+//
+// Nonzero maximums override the global image maximums for the route's images,
+// nonzero tile settings override the global tile grid, and CachePolicy, if
+// set, replaces the default caching policy for them.
+//
+// A route without a TilePath only overrides settings: its images are found
+// just as they would be without it, so collections served by plugins, or
+// living under another route's tile path, can still have their own limits.
+type Route struct {
+	Prefix           string
+	TilePath         string
+	ImageMaxArea     int64
+	ImageMaxWidth    int
+	ImageMaxHeight   int
+	TileWidth        int
+	TileHeight       int
+	TileScaleFactors []int
+	CachePolicy      *CachePolicy
+
+	cache *CachePolicies
+}
+
+// loadRoutes reads routes from a TOML file:
+//
+//	[[Route]]
+//	Prefix = "maps:"
+//	TilePath = "/mnt/gis"
+//	ImageMaxWidth = 4096
+//
+//	[Route.CachePolicy]
+//	ImageMaxAge = "720h"
+//
+// Route cache policies inherit anything they don't set from the default
+// policy in base, which may be nil.
+func loadRoutes(fname string, base *CachePolicies) ([]*Route, error) {
+	var data struct {
+		Route []*Route
+	}
+	var _, err = toml.DecodeFile(fname, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	var seen = make(map[string]bool)
+	for _, r := range data.Route {
+		if r.Prefix == "" {
+			return nil, fmt.Errorf("routes must have a prefix")
+		}
+		if seen[r.Prefix] {
+			return nil, fmt.Errorf("duplicate route prefix %q", r.Prefix)
+		}
+		seen[r.Prefix] = true
+
+		var grid = TileGrid{TileWidth: r.TileWidth, TileHeight: r.TileHeight, TileScaleFactors: r.TileScaleFactors}
+		err = grid.validate()
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %s", r.Prefix, err)
+		}
+
+		if r.CachePolicy != nil {
+			r.cache = &CachePolicies{Default: *r.CachePolicy}
+			if base != nil {
+				r.cache.Default = r.CachePolicy.inherit(base.Default)
+				r.cache.Scope = base.Scope
+				r.cache.SurrogateKeys = base.SurrogateKeys
+			}
+		}
+	}
+
+	return data.Route, nil
+}
+
+// routeFor returns the route with the longest prefix matching id, or nil if
+// there are none.  If id is an alias, its target is matched instead.
+func (ih *ImageHandler) routeFor(id iiif.ID) *Route {
+	return ih.matchRoute(id, false)
+}
+
+// tileRouteFor is like routeFor, but ignores routes which don't have a tile
+// path, for finding where id's file lives
+func (ih *ImageHandler) tileRouteFor(id iiif.ID) *Route {
+	return ih.matchRoute(id, true)
+}
+
+func (ih *ImageHandler) matchRoute(id iiif.ID, needTilePath bool) *Route {
+	id = aliases.resolve(id)
+	var best *Route
+	for _, r := range ih.Routes {
+		if needTilePath && r.TilePath == "" {
+			continue
+		}
+		if strings.HasPrefix(string(id), r.Prefix) && (best == nil || len(r.Prefix) > len(best.Prefix)) {
+			best = r
+		}
+	}
+	return best
+}
+
+// path returns the file path for id, which must start with the route's
+// prefix.  The remainder of the ID is cleaned so it can't escape TilePath.
+func (r *Route) path(id iiif.ID) string {
+	var rest = strings.TrimPrefix(string(id), r.Prefix)
+	return filepath.Join(r.TilePath, filepath.Clean("/"+rest))
+}
+
+// maximumsFor returns the size constraints for id, taking its route's
+// overrides into account
+func (ih *ImageHandler) maximumsFor(id iiif.ID) img.Constraint {
+	var max = ih.Maximums
+	var r = ih.routeFor(id)
+	if r == nil {
+		return max
+	}
+
+	if r.ImageMaxArea > 0 {
+		max.Area = r.ImageMaxArea
+	}
+	if r.ImageMaxWidth > 0 {
+		max.Width = r.ImageMaxWidth
+	}
+	if r.ImageMaxHeight > 0 {
+		max.Height = r.ImageMaxHeight
+	}
+	return max
+}
+
+// cachePoliciesFor returns the caching policies for id's route, or the
+// global policies if its route doesn't have its own
+func (ih *ImageHandler) cachePoliciesFor(id iiif.ID) *CachePolicies {
+	var r = ih.routeFor(id)
+	if r != nil && r.cache != nil {
+		return r.cache
+	}
+	return ih.CachePolicies
+}
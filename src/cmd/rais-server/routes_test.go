@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"rais/src/fakehttp"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestRoutes(t *testing.T) {
+	var f, err = ioutil.TempFile("", "rais-routes")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[[Route]]
+Prefix = "maps:"
+TilePath = "/mnt/gis"
+ImageMaxWidth = 4096
+
+[Route.CachePolicy]
+ImageMaxAge = "720h"
+
+[[Route]]
+Prefix = "maps:big:"
+TilePath = "/mnt/gis-big"
+
+[[Route]]
+Prefix = "maps:public/"
+ImageMaxWidth = 400
+`)
+	f.Close()
+
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	ih.Routes, err = loadRoutes(f.Name(), nil)
+	assert.NilError(err, "loading routes", t)
+
+	assert.Equal("/mnt/gis/usgs/1901.jp2", ih.getIIIFPath("maps:usgs/1901.jp2"), "prefix is routed", t)
+	assert.Equal("/mnt/gis-big/a.jp2", ih.getIIIFPath("maps:big:a.jp2"), "longest prefix wins", t)
+	assert.Equal("", ih.getIIIFPath("maps:../../etc/passwd"), "paths can't escape the route", t)
+	assert.Equal("/var/local/images/other.jp2", ih.getIIIFPath("other.jp2"), "unrouted IDs use TilePath", t)
+
+	assert.Equal(4096, ih.maximumsFor("maps:a.jp2").Width, "route maximum", t)
+	assert.Equal(ih.Maximums.Height, ih.maximumsFor("maps:a.jp2").Height, "unset maximums are global", t)
+
+	assert.Equal("/mnt/gis/public/a.jp2", ih.getIIIFPath("maps:public/a.jp2"), "settings-only routes don't change the path", t)
+	assert.Equal(400, ih.maximumsFor("maps:public/a.jp2").Width, "settings-only route maximum", t)
+
+	var w = fakehttp.NewResponseWriter()
+	ih.cachePoliciesFor("maps:a.jp2").setHeaders(w, httptest.NewRequest("GET", "/", nil), "maps:a.jp2", false)
+	assert.Equal("public, max-age=2592000", w.Header().Get("Cache-Control"), "route cache policy", t)
+	assert.True(ih.cachePoliciesFor("other.jp2") == nil, "no global policy", t)
+}
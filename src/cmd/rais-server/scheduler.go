@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// requestClass groups image requests by how expensive they are and how
+// impatient the client asking for them is likely to be
+type requestClass int
+
+// All request classes, from cheapest to most expensive
+const (
+	classTile requestClass = iota
+	classThumbnail
+	classExport
+	numClasses
+)
+
+var classNames = [numClasses]string{"tile", "thumbnail", "export"}
+
+func (c requestClass) String() string {
+	return classNames[c]
+}
+
+// interactiveMaxArea is the largest output, in pixels, which is still
+// considered a tile or thumbnail rather than an export
+const interactiveMaxArea = 1024 * 1024
+
+// classify returns the class of the request u for the image described by
+// info.  Tiles are the tiles advertised in info.json, or any other small crop
+// of the image; thumbnails are small renderings of the whole image; anything
+// bigger is an export.
+func classify(u *iiif.URL, info *iiif.Info) requestClass {
+	if info == nil {
+		return classExport
+	}
+	if _, _, sf := tileScale(u, info); sf != 0 && u.Region.Type == iiif.RTPixel {
+		return classTile
+	}
+
+	var crop = u.Region.GetCrop(info.Width, info.Height)
+	var out = u.Size.GetResize(crop)
+	if out.Dx()*out.Dy() > interactiveMaxArea {
+		return classExport
+	}
+	if crop == image.Rect(0, 0, info.Width, info.Height) || u.Region.Type == iiif.RTSquare {
+		return classThumbnail
+	}
+	return classTile
+}
+
+// decodeScheduler limits how many images are decoded at once.  When every
+// slot is taken, waiting requests get slots in weighted round-robin order by
+// class, so a few multi-second exports can't starve the tiles a viewer needs
+// right now, while exports still make progress under a steady tile load.
+type decodeScheduler struct {
+	m       sync.Mutex
+	slots   int
+	inUse   int
+	weights [numClasses]int
+	current [numClasses]int
+	waiting [numClasses][]chan struct{}
+}
+
+// scheduler is nil unless DecodeSlots is set, in which case decodes aren't
+// limited
+var scheduler *decodeScheduler
+
+func newDecodeScheduler(slots int, weights [numClasses]int) *decodeScheduler {
+	return &decodeScheduler{slots: slots, weights: weights}
+}
+
+// acquire waits for a decode slot for a request of the given class.  The
+// returned function must be called to give the slot back.  If ctx ends before
+// a slot is free, its error is returned instead.
+func (ds *decodeScheduler) acquire(ctx context.Context, class requestClass) (func(), error) {
+	if ds == nil {
+		return func() {}, nil
+	}
+
+	ds.m.Lock()
+	if ds.inUse < ds.slots && ds.queued() == 0 {
+		ds.inUse++
+		ds.m.Unlock()
+		return ds.release, nil
+	}
+	var ready = make(chan struct{})
+	ds.waiting[class] = append(ds.waiting[class], ready)
+	ds.m.Unlock()
+
+	var _, endQueue = startSpan(ctx, "image.decode_queue")
+	defer endQueue()
+	select {
+	case <-ready:
+		return ds.release, nil
+	case <-ctx.Done():
+	}
+
+	ds.m.Lock()
+	defer ds.m.Unlock()
+	for i, ch := range ds.waiting[class] {
+		if ch == ready {
+			ds.waiting[class] = append(ds.waiting[class][:i], ds.waiting[class][i+1:]...)
+			return nil, ctx.Err()
+		}
+	}
+
+	// We were handed a slot just as ctx ended, so it has to be passed on
+	ds.inUse--
+	ds.dispatch()
+	return nil, ctx.Err()
+}
+
+// release gives back a slot and hands it to the next waiting request, if any
+func (ds *decodeScheduler) release() {
+	ds.m.Lock()
+	ds.inUse--
+	ds.dispatch()
+	ds.m.Unlock()
+}
+
+// queued returns how many requests are waiting.  ds.m must be held.
+func (ds *decodeScheduler) queued() int {
+	var n int
+	for _, q := range ds.waiting {
+		n += len(q)
+	}
+	return n
+}
+
+// dispatch fills free slots from the waiting requests.  Classes are picked
+// with smooth weighted round-robin, the way nginx balances upstreams: every
+// class with waiting requests earns its weight, the richest class is served
+// and pays back the total, so a class with weight 4 gets four slots for
+// every one a class with weight 1 gets, interleaved rather than in bursts.
+// ds.m must be held.
+func (ds *decodeScheduler) dispatch() {
+	for ds.inUse < ds.slots {
+		var best, total = -1, 0
+		for c := range ds.waiting {
+			if len(ds.waiting[c]) == 0 {
+				continue
+			}
+			ds.current[c] += ds.weights[c]
+			total += ds.weights[c]
+			if best == -1 || ds.current[c] > ds.current[best] {
+				best = c
+			}
+		}
+		if best == -1 {
+			return
+		}
+		ds.current[best] -= total
+
+		var ready = ds.waiting[best][0]
+		ds.waiting[best] = ds.waiting[best][1:]
+		ds.inUse++
+		close(ready)
+	}
+}
+
+// parseDecodeWeights turns a list like "tile:8, thumbnail:4, export:1" into
+// per-class weights.  Classes which aren't listed get a weight of 1.
+func parseDecodeWeights(val string) ([numClasses]int, error) {
+	var weights = [numClasses]int{1, 1, 1}
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		var parts = strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return weights, fmt.Errorf("%q must look like \"class:weight\"", pair)
+		}
+
+		var name = strings.TrimSpace(parts[0])
+		var class = requestClass(-1)
+		for c, n := range classNames {
+			if n == name {
+				class = requestClass(c)
+			}
+		}
+		if class < 0 {
+			return weights, fmt.Errorf("%q is not a request class (must be tile, thumbnail, or export)", name)
+		}
+
+		var w, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || w < 1 {
+			return weights, fmt.Errorf("weight for %q must be a positive number", name)
+		}
+		weights[class] = w
+	}
+	return weights, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestClassify(t *testing.T) {
+	var info = &iiif.Info{Width: 4000, Height: 3000, Tiles: []iiif.TileSize{{Width: 512, ScaleFactors: []int{1, 2, 4, 8}}}}
+	var class = func(path string) requestClass {
+		var u, err = iiif.NewURL(path)
+		if err != nil {
+			t.Fatalf("Unable to parse %q: %s", path, err)
+		}
+		return classify(u, info)
+	}
+
+	assert.Equal(classTile, class("a.jp2/1024,0,1024,1024/512,/0/default.jpg"), "advertised tile", t)
+	assert.Equal(classTile, class("a.jp2/10,10,300,300/full/0/default.jpg"), "small crop", t)
+	assert.Equal(classThumbnail, class("a.jp2/full/!300,300/0/default.jpg"), "small full image", t)
+	assert.Equal(classThumbnail, class("a.jp2/square/200,/0/default.jpg"), "square thumbnail", t)
+	assert.Equal(classExport, class("a.jp2/full/max/0/default.jpg"), "full size", t)
+	assert.Equal(classExport, class("a.jp2/0,0,2000,2000/full/0/default.jpg"), "large crop", t)
+}
+
+func TestDecodeSchedulerWeights(t *testing.T) {
+	var ds = newDecodeScheduler(1, [numClasses]int{3, 1, 1})
+	var ctx = context.Background()
+	var hold, err = ds.acquire(ctx, classExport)
+	assert.NilError(err, "the first request gets the free slot", t)
+
+	// Queue up alternating exports and tiles, each recording the order it got
+	// its slot in
+	var order = make(chan requestClass, 8)
+	var queue = func(c requestClass) {
+		ds.m.Lock()
+		var before = len(ds.waiting[c])
+		ds.m.Unlock()
+		go func() {
+			var release, _ = ds.acquire(ctx, c)
+			order <- c
+			release()
+		}()
+		for {
+			ds.m.Lock()
+			var n = len(ds.waiting[c])
+			ds.m.Unlock()
+			if n > before {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		queue(classExport)
+		queue(classTile)
+	}
+	hold()
+
+	var got string
+	for i := 0; i < 8; i++ {
+		got += (<-order).String()[:1]
+	}
+	assert.Equal("ttetteee", got, "tiles get three slots per export until they run out", t)
+}
+
+func TestDecodeSchedulerCancel(t *testing.T) {
+	var ds = newDecodeScheduler(1, [numClasses]int{1, 1, 1})
+	var hold, _ = ds.acquire(context.Background(), classExport)
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	var _, err = ds.acquire(ctx, classTile)
+	assert.True(err != nil, "waiting ends with the context", t)
+	assert.Equal(0, ds.queued(), "canceled requests leave the queue", t)
+
+	hold()
+	var release func()
+	release, err = ds.acquire(context.Background(), classTile)
+	assert.NilError(err, "the slot is free again", t)
+	release()
+	assert.Equal(0, ds.inUse, "no slots in use", t)
+}
+
+func TestParseDecodeWeights(t *testing.T) {
+	var w, err = parseDecodeWeights("tile:8, export:2")
+	assert.NilError(err, "valid weights", t)
+	assert.Equal(8, w[classTile], "tile weight", t)
+	assert.Equal(1, w[classThumbnail], "unlisted classes default to 1", t)
+	assert.Equal(2, w[classExport], "export weight", t)
+
+	_, err = parseDecodeWeights("tiles:8")
+	assert.True(err != nil, "unknown classes are rejected", t)
+	_, err = parseDecodeWeights("tile:0")
+	assert.True(err != nil, "weights must be positive", t)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signedURLs requires each IIIF request to carry a "token" query parameter
+// signed with one of keys, for sites which want to stop hotlinking without
+// running a separate proxy.  Tokens look like "<expires>.<signature>", where
+// expires is a Unix timestamp and signature is the hex HMAC-SHA256 of
+// "<expires>:<path>".  path is the unescaped request path after the IIIF web
+// path, without its leading slash, e.g., "maps/1902.jp2/full/max/0/default.jpg".
+//
+// A signature may also cover any leading part of the path which ends at a
+// slash, so signing just an image's ID ("maps/1902.jp2") allows every request
+// for that image, including info.json and tiles.  More than one key may be
+// configured so keys can be rotated without breaking existing links.
+type signedURLs struct {
+	keys   [][]byte
+	prefix string
+	now    func() time.Time
+}
+
+func newSignedURLs(keys []string, prefix string) *signedURLs {
+	var su = &signedURLs{prefix: prefix + "/", now: time.Now}
+	for _, k := range keys {
+		su.keys = append(su.keys, []byte(k))
+	}
+	return su
+}
+
+// parseSignedURLKeys turns a comma-separated key list into a slice
+func parseSignedURLKeys(val string) []string {
+	var list []string
+	for _, k := range strings.Split(val, ",") {
+		k = strings.TrimSpace(k)
+		if k != "" {
+			list = append(list, k)
+		}
+	}
+	return list
+}
+
+// sign returns the signature for path expiring at expires, using key
+func sign(key []byte, expires int64, path string) []byte {
+	var mac = hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d:%s", expires, path)
+	return mac.Sum(nil)
+}
+
+// verify returns nil if token is a valid, unexpired signature for path or one
+// of its leading parts
+func (su *signedURLs) verify(path, token string) error {
+	if token == "" {
+		return fmt.Errorf("missing token")
+	}
+	var parts = strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed token")
+	}
+	var expires, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed token expiration")
+	}
+	var sig []byte
+	sig, err = hex.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed token signature")
+	}
+	if su.now().Unix() >= expires {
+		return fmt.Errorf("token has expired")
+	}
+
+	for _, key := range su.keys {
+		for p := path; p != ""; {
+			if hmac.Equal(sig, sign(key, expires, p)) {
+				return nil
+			}
+			var i = strings.LastIndex(p, "/")
+			if i < 0 {
+				break
+			}
+			p = p[:i]
+		}
+	}
+	return fmt.Errorf("invalid token signature")
+}
+
+// wrap rejects requests without a valid token before next sees them
+func (su *signedURLs) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var path = strings.TrimPrefix(req.URL.Path, su.prefix)
+		var err = su.verify(path, req.URL.Query().Get("token"))
+		if err != nil {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func token(key string, expires int64, path string) string {
+	return fmt.Sprintf("%d.%s", expires, hex.EncodeToString(sign([]byte(key), expires, path)))
+}
+
+func TestSignedURLVerify(t *testing.T) {
+	var now = time.Unix(1500000000, 0)
+	var su = newSignedURLs([]string{"old", "new"}, "/iiif")
+	su.now = func() time.Time { return now }
+	var later = now.Add(time.Hour).Unix()
+	var path = "maps/1902.jp2/full/max/0/default.jpg"
+
+	assert.NilError(su.verify(path, token("new", later, path)), "full path signature", t)
+	assert.NilError(su.verify(path, token("old", later, path)), "older keys still work", t)
+	assert.NilError(su.verify(path, token("new", later, "maps/1902.jp2")), "ID signature", t)
+	assert.NilError(su.verify(path, token("new", later, "maps")), "leading path signature", t)
+
+	var tests = map[string]string{
+		"missing":        "",
+		"malformed":      "nope",
+		"bad expiration": "soon." + hex.EncodeToString(sign([]byte("new"), later, path)),
+		"bad signature":  fmt.Sprintf("%d.zz", later),
+		"wrong key":      token("other", later, path),
+		"wrong path":     token("new", later, "maps/1903.jp2"),
+		"partial name":   token("new", later, "maps/19"),
+		"expired":        token("new", now.Unix(), path),
+		"tampered":       fmt.Sprintf("%d.%s", later+1, hex.EncodeToString(sign([]byte("new"), later, path))),
+	}
+	for name, tok := range tests {
+		assert.True(su.verify(path, tok) != nil, name+" token is rejected", t)
+	}
+}
+
+func TestSignedURLWrap(t *testing.T) {
+	var su = newSignedURLs([]string{"secret"}, "/iiif")
+	var h = su.wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	var expires = time.Now().Add(time.Minute).Unix()
+
+	var w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/iiif/maps%2F1902.jp2/info.json", nil))
+	assert.Equal(http.StatusForbidden, w.Code, "requests need a token", t)
+
+	w = httptest.NewRecorder()
+	var tok = token("secret", expires, "maps/1902.jp2")
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/iiif/maps%2F1902.jp2/info.json?token="+tok, nil))
+	assert.Equal(http.StatusOK, w.Code, "escaped IDs are signed unescaped", t)
+}
+
+func TestInfoRedirectKeepsQuery(t *testing.T) {
+	w := request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2?token=abc", t)
+	assert.Equal(303, w.StatusCode, "Base URL redirects to info request", t)
+	assert.Equal("/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json?token=abc",
+		w.Headers["Location"][0], "Location header keeps the query", t)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// spanTiming records how long one step of a request took.  Times are in
+// seconds, with Start relative to the beginning of the request.
+type spanTiming struct {
+	Name     string  `json:"name"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+}
+
+// recordSpan adds a timing for the named step to the request's access log
+// entry and marks it as the request's current stage, returning a function
+// which marks the step complete
+func recordSpan(ctx context.Context, name string) func() {
+	var e, _ = ctx.Value(accessLogKey{}).(*accessLogEntry)
+	if e == nil {
+		return func() {}
+	}
+
+	var start = time.Now()
+	var s = &spanTiming{Name: name, Start: start.Sub(e.Time).Seconds()}
+	e.spans = append(e.spans, s)
+	e.live.enter(name)
+	return func() {
+		s.Duration = time.Since(start).Seconds()
+		e.live.leave(name)
+	}
+}
+
+// slowRequest is the diagnostic data captured for a slow request
+type slowRequest struct {
+	accessLogEntry
+	Spans []*spanTiming `json:"spans"`
+}
+
+// slowSampler keeps the slowest requests seen in the current interval, and
+// the slowest from the previous interval, so intermittent slowness can be
+// investigated after the fact
+type slowSampler struct {
+	m          sync.Mutex
+	max        int
+	interval   time.Duration
+	start      time.Time
+	current    []*slowRequest
+	previous   []*slowRequest
+	prevStart  time.Time
+	prevFinish time.Time
+}
+
+var slowRequests = &slowSampler{max: 10, interval: time.Minute, start: time.Now()}
+
+// rotate moves to a new interval if the current one is over.  s.m must be
+// locked.
+func (s *slowSampler) rotate(now time.Time) {
+	if now.Sub(s.start) < s.interval {
+		return
+	}
+
+	s.previous, s.prevStart, s.prevFinish = s.current, s.start, s.start.Add(s.interval)
+	// If nothing happened for a full interval, the "previous" interval is
+	// actually empty
+	if now.Sub(s.start) >= s.interval*2 {
+		s.previous, s.prevStart, s.prevFinish = nil, now.Add(-s.interval), now
+	}
+	s.current, s.start = nil, now
+}
+
+// record considers a finished request for inclusion in the slowest list
+func (s *slowSampler) record(e *accessLogEntry) {
+	if s.max <= 0 {
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.rotate(time.Now())
+	var n = len(s.current)
+	if n >= s.max && s.current[n-1].Duration >= e.Duration {
+		return
+	}
+
+	var sr = &slowRequest{accessLogEntry: *e, Spans: e.spans}
+	var i = sort.Search(n, func(i int) bool { return s.current[i].Duration < e.Duration })
+	s.current = append(s.current, nil)
+	copy(s.current[i+1:], s.current[i:])
+	s.current[i] = sr
+	if len(s.current) > s.max {
+		s.current = s.current[:s.max]
+	}
+}
+
+// slowReport is the JSON structure served by the admin endpoint
+type slowReport struct {
+	Current  slowInterval `json:"current"`
+	Previous slowInterval `json:"previous"`
+}
+
+type slowInterval struct {
+	Start    time.Time      `json:"start"`
+	Finish   time.Time      `json:"finish"`
+	Requests []*slowRequest `json:"requests"`
+}
+
+func (s *slowSampler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.m.Lock()
+	var now = time.Now()
+	s.rotate(now)
+	var r = slowReport{
+		Current:  slowInterval{Start: s.start, Finish: now, Requests: append([]*slowRequest{}, s.current...)},
+		Previous: slowInterval{Start: s.prevStart, Finish: s.prevFinish, Requests: append([]*slowRequest{}, s.previous...)},
+	}
+	s.m.Unlock()
+
+	var data, err = json.Marshal(r)
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestSlowSampler(t *testing.T) {
+	var s = &slowSampler{max: 3, interval: time.Hour, start: time.Now()}
+	for _, d := range []float64{0.5, 0.1, 2, 0.05, 1} {
+		s.record(&accessLogEntry{Duration: d})
+	}
+
+	assert.Equal(3, len(s.current), "only the slowest requests are kept", t)
+	assert.Equal(2.0, s.current[0].Duration, "slowest first", t)
+	assert.Equal(1.0, s.current[1].Duration, "second slowest", t)
+	assert.Equal(0.5, s.current[2].Duration, "third slowest", t)
+
+	s.start = time.Now().Add(-time.Hour - time.Second)
+	s.rotate(time.Now())
+	assert.Equal(0, len(s.current), "new interval starts empty", t)
+	assert.Equal(3, len(s.previous), "previous interval is kept", t)
+}
+
+func TestRecordSpan(t *testing.T) {
+	var e = &accessLogEntry{Time: time.Now()}
+	var ctx = context.WithValue(context.Background(), accessLogKey{}, e)
+	var _, end = startSpan(ctx, "image.decode")
+	end()
+
+	assert.Equal(1, len(e.spans), "span recorded", t)
+	assert.Equal("image.decode", e.spans[0].Name, "span name", t)
+	assert.True(e.spans[0].Duration > 0, "span duration recorded", t)
+}
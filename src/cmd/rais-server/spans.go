@@ -0,0 +1,26 @@
+package main
+
+import "context"
+
+// startSpan notifies all tracing plugins that an operation is starting, and
+// records its timing for the slow request sampler.  The returned context
+// should be used for any nested operations, and the returned function must be
+// called when the operation completes.
+func startSpan(ctx context.Context, name string) (context.Context, func()) {
+	var endTiming = recordSpan(ctx, name)
+	if len(startSpanPlugins) == 0 {
+		return ctx, endTiming
+	}
+
+	var ends = make([]func(), len(startSpanPlugins))
+	for i, plug := range startSpanPlugins {
+		ctx, ends[i] = plug(ctx, name)
+	}
+
+	return ctx, func() {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i]()
+		}
+		endTiming()
+	}
+}
@@ -8,8 +8,10 @@
 )
 
 type plugStats struct {
-	Path      string
-	Functions []string
+	Path         string
+	APIVersion   int
+	Capabilities []string
+	Functions    []string
 }
 
 type cacheStats struct {
@@ -20,6 +22,8 @@ type cacheStats struct {
 	HitPercent float64
 	SetCount   uint64
 	Length     int
+	Bytes      int64  `json:",omitempty"`
+	StaleCount uint64 `json:",omitempty"`
 }
 
 func (cs *cacheStats) setHitPercent() {
@@ -42,6 +46,11 @@ func (cs *cacheStats) Hit() {
 	atomic.AddUint64(&cs.GetHits, 1)
 }
 
+// Stale increments StaleCount safely
+func (cs *cacheStats) Stale() {
+	atomic.AddUint64(&cs.StaleCount, 1)
+}
+
 // Set increments SetCount safely
 func (cs *cacheStats) Set() {
 	atomic.AddUint64(&cs.SetCount, 1)
@@ -52,14 +61,18 @@ func (cs *cacheStats) Set() {
 // know only one thread can possibly exist!  (e.g., when first setting up the
 // object)
 type serverStats struct {
-	m           sync.Mutex
-	InfoCache   cacheStats
-	TileCache   cacheStats
-	Plugins     []plugStats
-	RAISVersion string
-	RAISBuild   string
-	ServerStart time.Time
-	Uptime      string
+	m            sync.Mutex
+	InfoCache    cacheStats
+	TileCache    cacheStats
+	MissingCache cacheStats
+	RateLimited  uint64
+	Panics       uint64
+	Usage        map[string]usageCount `json:",omitempty"`
+	Plugins      []plugStats
+	RAISVersion  string
+	RAISBuild    string
+	ServerStart  time.Time
+	Uptime       string
 }
 
 // Serialize writes the stats data to w in JSON format
@@ -81,6 +94,16 @@ func (s *serverStats) calculateDerivedStats() {
 	if tileCache != nil {
 		s.TileCache.setHitPercent()
 		s.TileCache.Length = tileCache.Len()
+		if bc, ok := tileCache.(*byteCache); ok {
+			s.TileCache.Bytes = bc.Bytes()
+		}
+	}
+	if missingCache != nil {
+		s.MissingCache.setHitPercent()
+		s.MissingCache.Length = missingCache.lru.Len()
+	}
+	if usage != nil {
+		s.Usage = usage.report(false).Groups
 	}
 
 	s.m.Unlock()
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"rais/src/cmd/rais-server/internal/handoff"
+	"rais/src/cmd/rais-server/internal/systemd"
+	"rais/src/version"
+	"time"
+)
+
+// notifyReady tells systemd, and the old RAIS process if this one is an
+// upgrade, that RAIS is serving requests, and starts sending watchdog
+// notifications if systemd wants them
+func notifyReady(hh *healthHandler) {
+	var err = handoff.Ready()
+	if err != nil {
+		Logger.Errorf("Unable to tell the old RAIS process that this one is ready: %s", err)
+	}
+
+	err = systemd.Notify(fmt.Sprintf("READY=1\nSTATUS=RAIS v%s serving requests", version.Version))
+	if err != nil {
+		Logger.Warnf("Unable to notify systemd that RAIS is ready: %s", err)
+	}
+
+	var interval = systemd.WatchdogInterval()
+	if interval > 0 {
+		Logger.Infof("Sending systemd watchdog notifications every %s", interval/2)
+		go watchdog(hh, interval/2)
+	}
+}
+
+// watchdog tells systemd RAIS is alive every interval.  The tile path is
+// read before each notification, so a RAIS stuck on a hung filesystem stops
+// notifying and gets restarted.  Errors reading it don't stop notifications:
+// readiness checks report those, and restarting won't fix them.
+func watchdog(hh *healthHandler, interval time.Duration) {
+	for range time.Tick(interval) {
+		hh.checkTilePath()
+		var err = systemd.Notify("WATCHDOG=1")
+		if err != nil {
+			Logger.Warnf("Unable to send systemd watchdog notification: %s", err)
+		}
+	}
+}
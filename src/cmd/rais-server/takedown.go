@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"rais/src/iiif"
+	"rais/src/img"
+	"sort"
+	"sync"
+	"time"
+)
+
+// takedown records an image which must not be served, and why
+type takedown struct {
+	ID      iiif.ID    `json:"id"`
+	Reason  string     `json:"reason"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (td *takedown) expired(now time.Time) bool {
+	return td.Expires != nil && !now.Before(*td.Expires)
+}
+
+// takedownList is the set of images which are taken down.  If path is set,
+// every change is written to disk so takedowns survive restarts.
+type takedownList struct {
+	m     sync.RWMutex
+	path  string
+	items map[iiif.ID]*takedown
+}
+
+var takedowns = &takedownList{items: make(map[iiif.ID]*takedown)}
+
+// load reads takedowns from fname and uses it for all future saves.  A
+// missing file isn't an error, since nothing may have been taken down yet.
+func (tl *takedownList) load(fname string) error {
+	tl.m.Lock()
+	defer tl.m.Unlock()
+
+	tl.path = fname
+	var data, err = ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var list []*takedown
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return fmt.Errorf("invalid takedown file %q: %s", fname, err)
+	}
+	for _, td := range list {
+		tl.items[td.ID] = td
+	}
+	return nil
+}
+
+// save writes all takedowns to disk via a temp file so a crash can't leave a
+// partial list behind.  tl.m must be locked.
+func (tl *takedownList) save() error {
+	if tl.path == "" {
+		return nil
+	}
+
+	var data, err = json.MarshalIndent(tl.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(tl.path, ".takedowns-", data)
+}
+
+// sorted returns all takedowns ordered by ID.  tl.m must be locked.
+func (tl *takedownList) sorted() []*takedown {
+	var list = make([]*takedown, 0, len(tl.items))
+	for _, td := range tl.items {
+		list = append(list, td)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// get returns the takedown for id, or nil if id isn't taken down.  Taking
+// down an alias's target takes down the alias, too, and taking down a file
+// takes down all its pages.  Expired takedowns are ignored, and cleaned up
+// the next time the list is saved.
+func (tl *takedownList) get(id iiif.ID) *takedown {
+	tl.m.RLock()
+	defer tl.m.RUnlock()
+
+	var base, _, _ = img.SplitPage(id)
+	for _, key := range []iiif.ID{id, aliases.resolve(id), base, fileID(id)} {
+		var td = tl.items[key]
+		if td != nil && !td.expired(time.Now()) {
+			return td
+		}
+	}
+	return nil
+}
+
+// add takes down an image, replacing any existing takedown for it
+func (tl *takedownList) add(td *takedown) error {
+	tl.m.Lock()
+	defer tl.m.Unlock()
+
+	tl.items[td.ID] = td
+	tl.removeExpired()
+	return tl.save()
+}
+
+// remove restores an image, returning false if it wasn't taken down
+func (tl *takedownList) remove(id iiif.ID) (bool, error) {
+	tl.m.Lock()
+	defer tl.m.Unlock()
+
+	var _, ok = tl.items[id]
+	delete(tl.items, id)
+	tl.removeExpired()
+	return ok, tl.save()
+}
+
+// removeExpired drops takedowns which no longer apply.  tl.m must be locked.
+func (tl *takedownList) removeExpired() {
+	var now = time.Now()
+	for id, td := range tl.items {
+		if td.expired(now) {
+			delete(tl.items, id)
+		}
+	}
+}
+
+// adminTakedowns lists all active takedowns on GET, and takes down an image
+// on POST.  POSTs require "id" and "reason", and may set "expires" to either
+// a duration ("720h") or an RFC 3339 timestamp.  Taking down an image purges
+// it from all caches.
+func adminTakedowns(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		takedowns.m.RLock()
+		var list = []*takedown{}
+		var now = time.Now()
+		for _, td := range takedowns.sorted() {
+			if !td.expired(now) {
+				list = append(list, td)
+			}
+		}
+		takedowns.m.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	var td = &takedown{
+		ID:      iiif.ID(req.PostFormValue("id")),
+		Reason:  req.PostFormValue("reason"),
+		Created: time.Now(),
+	}
+	if td.ID == "" || td.Reason == "" {
+		http.Error(w, "id and reason are required", http.StatusBadRequest)
+		return
+	}
+
+	var exp = req.PostFormValue("expires")
+	if exp != "" {
+		var t, err = parseExpiry(exp, td.Created)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		td.Expires = &t
+	}
+
+	var err = takedowns.add(td)
+	if err != nil {
+		Logger.Errorf("Unable to save takedowns: %s", err)
+		http.Error(w, "Unable to save takedown", http.StatusInternalServerError)
+		return
+	}
+	Logger.Infof("Took down %q: %s", td.ID, td.Reason)
+	expireCachedImage(td.ID)
+	recordActivity(activityDelete, td.ID)
+
+	w.Write([]byte("OK"))
+}
+
+// adminRestore removes a takedown; it must be a POST with "id" set
+func adminRestore(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var id = iiif.ID(req.PostFormValue("id"))
+	var ok, err = takedowns.remove(id)
+	if err != nil {
+		Logger.Errorf("Unable to save takedowns: %s", err)
+		http.Error(w, "Unable to save takedowns", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "id is not taken down", http.StatusNotFound)
+		return
+	}
+	Logger.Infof("Restored %q", id)
+	recordActivity(activityCreate, id)
+
+	w.Write([]byte("OK"))
+}
+
+// parseExpiry reads a duration relative to now or an absolute RFC 3339 time
+func parseExpiry(val string, now time.Time) (time.Time, error) {
+	var d, err = time.ParseDuration(val)
+	if err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+
+	var t time.Time
+	t, err = time.Parse(time.RFC3339, val)
+	if err != nil || !t.After(now) {
+		return t, fmt.Errorf("expires must be a positive duration or a future RFC 3339 time")
+	}
+	return t, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestTakedownPersistence(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-takedowns")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	var fname = filepath.Join(dir, "takedowns.json")
+
+	var tl = &takedownList{items: make(map[iiif.ID]*takedown)}
+	assert.NilError(tl.load(fname), "missing file is fine", t)
+
+	var past = time.Now().Add(-time.Hour)
+	assert.NilError(tl.add(&takedown{ID: "a.jp2", Reason: "copyright", Created: time.Now()}), "add", t)
+	assert.NilError(tl.add(&takedown{ID: "b.jp2", Reason: "temporary", Created: time.Now(), Expires: &past}), "add expired", t)
+	assert.True(tl.get("a.jp2") != nil, "a.jp2 is taken down", t)
+	assert.True(tl.get("b.jp2") == nil, "expired takedowns don't apply", t)
+
+	var tl2 = &takedownList{items: make(map[iiif.ID]*takedown)}
+	assert.NilError(tl2.load(fname), "reload", t)
+	assert.Equal("copyright", tl2.get("a.jp2").Reason, "takedowns persist", t)
+	assert.Equal(1, len(tl2.items), "expired takedowns aren't saved", t)
+
+	var ok bool
+	ok, err = tl2.remove("a.jp2")
+	assert.True(ok, "a.jp2 was removed", t)
+	assert.NilError(err, "remove", t)
+	assert.True(tl2.get("a.jp2") == nil, "a.jp2 is restored", t)
+}
+
+func TestTakedownPages(t *testing.T) {
+	var tl = &takedownList{items: make(map[iiif.ID]*takedown)}
+	tl.items["reel.tif"] = &takedown{ID: "reel.tif", Created: time.Now()}
+	tl.items["other.tif;1"] = &takedown{ID: "other.tif;1", Created: time.Now()}
+
+	assert.True(tl.get("reel.tif;0") != nil, "taking down a file takes down its pages", t)
+	assert.True(tl.get("other.tif;1") != nil, "single pages can be taken down", t)
+	assert.True(tl.get("other.tif;0") == nil, "other pages are still available", t)
+}
+
+func TestParseExpiry(t *testing.T) {
+	var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var exp, err = parseExpiry("48h", now)
+	assert.NilError(err, "duration", t)
+	assert.Equal(now.Add(48*time.Hour), exp, "duration is relative to now", t)
+
+	exp, err = parseExpiry("2020-02-01T00:00:00Z", now)
+	assert.NilError(err, "timestamp", t)
+	assert.Equal(2, int(exp.Month()), "timestamp is parsed", t)
+
+	_, err = parseExpiry("2019-02-01T00:00:00Z", now)
+	assert.True(err != nil, "past timestamps are rejected", t)
+}
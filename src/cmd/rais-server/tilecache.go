@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// tileStore is what the tile cache needs from its backing cache.  It's
+// satisfied by a 2Q cache when the cache is limited by entry count, and by a
+// byteCache when it's limited by size.
+type tileStore interface {
+	Get(key interface{}) (interface{}, bool)
+	Peek(key interface{}) (interface{}, bool)
+	Add(key, value interface{})
+	Len() int
+	Purge()
+}
+
+// tileEntry is a cached encoded image along with the fingerprint of the
+// source file it was made from
+type tileEntry struct {
+	data   []byte
+	source string
+}
+
+// sourceFingerprint identifies the current version of the file at path by
+// its modification time and size.  It returns an empty string if the file
+// can't be read, in which case cached tiles are never considered stale.
+func sourceFingerprint(path string) string {
+	var info, err = os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fileFingerprint(info)
+}
+
+func fileFingerprint(info os.FileInfo) string {
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// Ways to key cached tiles
+const (
+	// keyRequest keys tiles by their IIIF request path, so every ID has its
+	// own tiles
+	keyRequest = "request"
+
+	// keyContent keys tiles by the source file's size and modification time
+	// plus the request's canonical parameters, so aliases and renamed copies
+	// of a master share tiles, and a replaced master never matches its old
+	// tiles
+	keyContent = "content"
+
+	// keyHash is like keyContent, but uses a SHA-256 hash of the source file
+	// instead of its size and modification time.  Hashes are remembered until
+	// the file's size or modification time changes.
+	keyHash = "hash"
+)
+
+var keyModes = []string{keyRequest, keyContent, keyHash}
+
+func validKeyMode(mode string) bool {
+	for _, m := range keyModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// contentHashes remembers source files' hashes, keyed by path, along with
+// the fingerprint each hash was computed for
+var contentHashes, _ = lru.New(10000)
+
+type contentHash struct {
+	fingerprint string
+	sum         string
+}
+
+// sourceHash returns the SHA-256 hash of the file at path, or an empty string
+// if it can't be read.  The file is only read again if its size or
+// modification time has changed.
+func sourceHash(path string) string {
+	var fingerprint = sourceFingerprint(path)
+	if fingerprint == "" {
+		return ""
+	}
+	if v, ok := contentHashes.Get(path); ok && v.(contentHash).fingerprint == fingerprint {
+		return v.(contentHash).sum
+	}
+
+	var f, err = os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var h = sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		Logger.Warnf("Unable to hash %q for the tile cache: %s", path, err)
+		return ""
+	}
+
+	var sum = hex.EncodeToString(h.Sum(nil))
+	contentHashes.Add(path, contentHash{fingerprint: fingerprint, sum: sum})
+	return sum
+}
+
+// cacheKey returns the tile cache key for u, whose source is the file at fp,
+// or an empty string if the tile cache's admission policy won't take it.
+// Content keys need the source's dimensions to normalize u, and the source
+// itself; until the source is available (e.g., before a plugin has
+// downloaded it), they aren't cacheable.
+func (ih *ImageHandler) cacheKey(u *iiif.URL, fp string, info *iiif.Info) string {
+	if tileCache == nil || !tilePolicy.admits(u) {
+		return ""
+	}
+	if tilePolicy.key == "" || tilePolicy.key == keyRequest {
+		return u.Path
+	}
+	if info == nil || info.Width <= 0 || info.Height <= 0 {
+		return ""
+	}
+
+	var source string
+	if tilePolicy.key == keyHash {
+		source = sourceHash(fp)
+	} else {
+		source = sourceFingerprint(fp)
+	}
+	if source == "" {
+		return ""
+	}
+
+	// "max" depends on the image's size limits, which can vary by ID
+	var key = source + "/" + u.Canonical(info.Width, info.Height)
+	if u.Size.Type == iiif.STMax {
+		var max = ih.constraintsFor(u.ID, info)
+		key += fmt.Sprintf("/%d,%d,%d", max.Width, max.Height, max.Area)
+	}
+	return key
+}
+
+// Ways to handle a cached tile whose source image has changed
+const (
+	// staleRevalidate serves the stale tile and regenerates it in the
+	// background
+	staleRevalidate = "revalidate"
+
+	// staleRefresh regenerates the tile before responding, as if it weren't
+	// cached
+	staleRefresh = "refresh"
+
+	// staleIgnore serves cached tiles until they're evicted, without checking
+	// the source image at all
+	staleIgnore = "ignore"
+)
+
+var staleModes = []string{staleRevalidate, staleRefresh, staleIgnore}
+
+func validStaleMode(mode string) bool {
+	for _, m := range staleModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// tileCachePolicy decides which derivatives are worth caching
+type tileCachePolicy struct {
+	// formats lists the formats which may be cached
+	formats map[iiif.Format]bool
+
+	// maxDimension is the largest width or height which may be cached, or
+	// zero to allow any size, including "full" and "max" requests
+	maxDimension int
+
+	// maxEntryBytes is the largest encoded image which may be cached, or zero
+	// for no limit
+	maxEntryBytes int64
+
+	// stale is what to do when a cached tile's source image has changed
+	stale string
+
+	// key is how tiles are keyed: keyRequest, keyContent, or keyHash
+	key string
+}
+
+// tilePolicy is the tile cache's admission policy, set up with the cache.
+// The default matches RAIS's long-standing rules: JPEGs with an explicit
+// width, no more than 1024 pixels on a side.
+var tilePolicy = &tileCachePolicy{formats: map[iiif.Format]bool{iiif.FmtJPG: true}, maxDimension: 1024, stale: staleRevalidate, key: keyRequest}
+
+// parseTileCacheFormats turns a list like "jpg, png" into a set of formats
+func parseTileCacheFormats(val string) map[iiif.Format]bool {
+	var m = make(map[iiif.Format]bool)
+	for _, f := range strings.Split(val, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			m[iiif.Format(f)] = true
+		}
+	}
+	return m
+}
+
+// admits returns true if u's derivative may be cached
+func (p *tileCachePolicy) admits(u *iiif.URL) bool {
+	if !p.formats[u.Format] {
+		return false
+	}
+	if p.maxDimension == 0 {
+		return true
+	}
+	return u.Size.W > 0 && u.Size.W <= p.maxDimension && u.Size.H <= p.maxDimension
+}
+
+// fits returns true if an encoded image of the given size may be cached
+func (p *tileCachePolicy) fits(size int) bool {
+	return p.maxEntryBytes == 0 || int64(size) <= p.maxEntryBytes
+}
+
+// fresh returns true if e was made from the current version of the file at
+// fp, or if staleness isn't being checked
+func (p *tileCachePolicy) fresh(e *tileEntry, fp string) bool {
+	if p.stale == staleIgnore || e.source == "" {
+		return true
+	}
+	return sourceFingerprint(fp) == e.source
+}
+
+// tileRevalidator tracks stale tiles being regenerated in the background, so
+// a popular tile whose source changed is only regenerated once no matter how
+// many requests come in for it while that's happening
+type tileRevalidator struct {
+	m       sync.Mutex
+	pending map[string]bool
+}
+
+var revalidator = &tileRevalidator{pending: make(map[string]bool)}
+
+// start runs fn in a new goroutine unless key is already being regenerated
+func (tr *tileRevalidator) start(key string, fn func()) {
+	tr.m.Lock()
+	defer tr.m.Unlock()
+	if tr.pending[key] {
+		return
+	}
+	tr.pending[key] = true
+
+	go func() {
+		defer func() {
+			tr.m.Lock()
+			delete(tr.pending, key)
+			tr.m.Unlock()
+		}()
+		defer catchPanic(context.Background(), fmt.Sprintf("regenerating tile %q", key), nil)
+		fn()
+	}()
+}
+
+// regenerateTile reads fp again and replaces the cached tile for u.  It runs
+// in the background after the stale tile has been sent, so it has no request
+// context and always uses the default filter, just like anything cached.
+func (ih *ImageHandler) regenerateTile(key string, u *iiif.URL, fp string, info *iiif.Info) error {
+	var ctx = context.Background()
+	var source = sourceFingerprint(fp)
+	var res, err = img.NewResourceContext(ctx, u.ID, fp)
+	if err != nil {
+		return err
+	}
+	res.Filter = ih.Filter
+	res.Sharpen = ih.Sharpen
+	res.Upscale = ih.Upscale
+	res.Layers = ih.Layers
+	res.Background = ih.Background
+
+	var release func()
+	release, err = scheduler.acquire(ctx, classify(u, info))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var done = load.start()
+	defer done()
+	i, err := decode(ctx, res, u, ih.constraintsFor(u.ID, info))
+	if err != nil {
+		return err
+	}
+	var buf = bytes.NewBuffer(nil)
+	err = encoders.encode(ctx, buf, i, u.Format)
+	if err != nil {
+		return err
+	}
+
+	if tilePolicy.fits(buf.Len()) {
+		stats.TileCache.Set()
+		tileCache.Add(key, &tileEntry{data: buf.Bytes(), source: source})
+	}
+	return nil
+}
+
+// byteCache is an LRU cache of encoded images which evicts based on the total
+// size of its entries rather than how many there are, since a cached
+// thumbnail and a cached full-page derivative can differ in size a thousand
+// times over.  A nonzero maxEntries also caps the entry count.
+type byteCache struct {
+	m        sync.Mutex
+	lru      *simplelru.LRU
+	bytes    int64
+	maxBytes int64
+}
+
+func newByteCache(maxBytes int64, maxEntries int) *byteCache {
+	if maxEntries <= 0 {
+		maxEntries = math.MaxInt32
+	}
+	var c = &byteCache{maxBytes: maxBytes}
+	c.lru, _ = simplelru.NewLRU(maxEntries, func(_, value interface{}) {
+		c.bytes -= int64(len(value.(*tileEntry).data))
+	})
+	return c
+}
+
+// Add stores value, which must be a *tileEntry, evicting the least recently used
+// entries until the cache is within its budget.  Values larger than the whole
+// budget aren't stored.
+func (c *byteCache) Add(key, value interface{}) {
+	var size = int64(len(value.(*tileEntry).data))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.lru.Remove(key)
+	c.lru.Add(key, value)
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.lru.RemoveOldest()
+	}
+}
+
+func (c *byteCache) Get(key interface{}) (interface{}, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.lru.Get(key)
+}
+
+func (c *byteCache) Peek(key interface{}) (interface{}, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.lru.Peek(key)
+}
+
+func (c *byteCache) Len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.lru.Len()
+}
+
+// Bytes returns the total size of all cached entries
+func (c *byteCache) Bytes() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.bytes
+}
+
+func (c *byteCache) Purge() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.lru.Purge()
+}
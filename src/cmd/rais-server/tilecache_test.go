@@ -0,0 +1,206 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestTileCachePolicy(t *testing.T) {
+	var parse = func(path string) *iiif.URL {
+		var u, err = iiif.NewURL(path)
+		if err != nil {
+			t.Fatalf("Unable to parse %q: %s", path, err)
+		}
+		return u
+	}
+
+	var p = &tileCachePolicy{formats: parseTileCacheFormats("jpg, png"), maxDimension: 1024}
+	assert.True(p.admits(parse("id/full/512,/0/default.jpg")), "small jpg", t)
+	assert.True(p.admits(parse("id/full/512,/0/default.png")), "small png", t)
+	assert.False(p.admits(parse("id/full/512,/0/default.gif")), "format not allowed", t)
+	assert.False(p.admits(parse("id/full/2048,/0/default.jpg")), "too wide", t)
+	assert.False(p.admits(parse("id/full/max/0/default.jpg")), "max size", t)
+
+	p.maxDimension = 0
+	assert.True(p.admits(parse("id/full/max/0/default.jpg")), "any size allowed", t)
+
+	p.maxEntryBytes = 10
+	assert.True(p.fits(10), "entry at the limit", t)
+	assert.False(p.fits(11), "entry over the limit", t)
+}
+
+func entry(data string) *tileEntry {
+	return &tileEntry{data: []byte(data)}
+}
+
+func TestByteCache(t *testing.T) {
+	var c = newByteCache(10, 0)
+	c.Add("a", entry("1234"))
+	c.Add("b", entry("1234"))
+	assert.Equal(int64(8), c.Bytes(), "bytes are totaled", t)
+
+	c.Get("a")
+	c.Add("c", entry("1234"))
+	assert.Equal(2, c.Len(), "oldest entry evicted to stay within budget", t)
+	var _, ok = c.Peek("b")
+	assert.False(ok, "least recently used entry is the one evicted", t)
+	assert.Equal(int64(8), c.Bytes(), "evictions are subtracted", t)
+
+	c.Add("a", entry("12"))
+	assert.Equal(int64(6), c.Bytes(), "replacing an entry updates the total", t)
+	c.Add("big", entry("12345678901"))
+	_, ok = c.Peek("big")
+	assert.False(ok, "entries larger than the budget aren't stored", t)
+
+	c.Purge()
+	assert.Equal(int64(0), c.Bytes(), "purge resets the total", t)
+
+	c = newByteCache(100, 2)
+	c.Add("a", entry("1"))
+	c.Add("b", entry("1"))
+	c.Add("c", entry("1"))
+	assert.Equal(2, c.Len(), "entry count limit applies too", t)
+	assert.Equal(int64(2), c.Bytes(), "count-based evictions are subtracted", t)
+}
+
+func TestTileFreshness(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-stale")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var fp = filepath.Join(dir, "source.jp2")
+	assert.NilError(ioutil.WriteFile(fp, []byte("old"), 0644), "writing source", t)
+	var e = &tileEntry{data: []byte("tile"), source: sourceFingerprint(fp)}
+	assert.True(e.source != "", "readable files have a fingerprint", t)
+	assert.Equal("", sourceFingerprint(filepath.Join(dir, "nope")), "missing files have no fingerprint", t)
+
+	var p = &tileCachePolicy{stale: staleRevalidate}
+	assert.True(p.fresh(e, fp), "unchanged source", t)
+	assert.True(p.fresh(&tileEntry{}, fp), "entries without a fingerprint are never stale", t)
+
+	assert.NilError(ioutil.WriteFile(fp, []byte("newer"), 0644), "replacing source", t)
+	assert.NilError(os.Chtimes(fp, time.Now(), time.Now().Add(time.Hour)), "touching source", t)
+	assert.False(p.fresh(e, fp), "changed source", t)
+
+	p.stale = staleIgnore
+	assert.True(p.fresh(e, fp), "staleness isn't checked when ignored", t)
+}
+
+func TestCachedTileRefresh(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-stale")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	var fp = filepath.Join(dir, "source.jp2")
+	assert.NilError(ioutil.WriteFile(fp, []byte("old"), 0644), "writing source", t)
+
+	var oldPolicy = tilePolicy
+	defer func() { tilePolicy = oldPolicy }()
+	tilePolicy = &tileCachePolicy{stale: staleRefresh}
+
+	var ih = &ImageHandler{}
+	var e = &tileEntry{data: []byte("tile"), source: sourceFingerprint(fp)}
+	var data, ok = ih.cachedTile("key", e, nil, fp, nil)
+	assert.True(ok, "fresh tiles are served", t)
+	assert.Equal("tile", string(data), "cached data", t)
+
+	e.source = "something else"
+	_, ok = ih.cachedTile("key", e, nil, fp, nil)
+	assert.False(ok, "stale tiles are a miss when refreshing", t)
+}
+
+func TestTileRevalidator(t *testing.T) {
+	var tr = &tileRevalidator{pending: make(map[string]bool)}
+	var release = make(chan struct{})
+	var wg sync.WaitGroup
+	var runs int
+
+	wg.Add(1)
+	tr.start("a", func() {
+		<-release
+		runs++
+		wg.Done()
+	})
+	for i := 0; i < 5; i++ {
+		tr.start("a", func() { runs++ })
+	}
+	close(release)
+	wg.Wait()
+
+	tr.m.Lock()
+	var pending = len(tr.pending)
+	tr.m.Unlock()
+	for pending > 0 {
+		time.Sleep(time.Millisecond)
+		tr.m.Lock()
+		pending = len(tr.pending)
+		tr.m.Unlock()
+	}
+	assert.Equal(1, runs, "only one regeneration per key at a time", t)
+
+	wg.Add(1)
+	tr.start("a", func() { runs++; wg.Done() })
+	wg.Wait()
+	assert.Equal(2, runs, "keys can be regenerated again once finished", t)
+
+	// A panicking regeneration is logged, not fatal, and doesn't leave the key
+	// stuck as pending
+	wg.Add(1)
+	tr.start("b", func() { defer wg.Done(); panic("broken decoder") })
+	wg.Wait()
+	for pending := 1; pending > 0; {
+		time.Sleep(time.Millisecond)
+		tr.m.Lock()
+		pending = len(tr.pending)
+		tr.m.Unlock()
+	}
+	wg.Add(1)
+	tr.start("b", func() { runs++; wg.Done() })
+	wg.Wait()
+	assert.Equal(3, runs, "keys can be regenerated again after a panic", t)
+}
+
+func TestContentCacheKey(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-cachekey")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	var fp = filepath.Join(dir, "source.jp2")
+	var copyFP = filepath.Join(dir, "copy.jp2")
+	var mtime = time.Now().Add(-time.Hour)
+	for _, f := range []string{fp, copyFP} {
+		assert.NilError(ioutil.WriteFile(f, []byte("master"), 0644), "writing "+f, t)
+		assert.NilError(os.Chtimes(f, mtime, mtime), "touching "+f, t)
+	}
+
+	var oldCache, oldPolicy = tileCache, tilePolicy
+	defer func() { tileCache, tilePolicy = oldCache, oldPolicy }()
+	tileCache = newByteCache(1000, 0)
+	tilePolicy = &tileCachePolicy{formats: map[iiif.Format]bool{iiif.FmtJPG: true}, key: keyContent}
+
+	var ih = NewImageHandler(dir, "/iiif")
+	var info = &iiif.Info{Width: 1000, Height: 500}
+	var a, _ = iiif.NewURL("source.jp2/full/pct:50/0/default.jpg")
+	var b, _ = iiif.NewURL("copy.jp2/0,0,1000,500/500,/0/native.jpg")
+	var key = ih.cacheKey(a, fp, info)
+	assert.True(key != "", "content keys are made for readable files", t)
+	assert.Equal(key, ih.cacheKey(b, copyFP, info), "identical copies and equivalent requests share a key", t)
+	assert.Equal("", ih.cacheKey(a, filepath.Join(dir, "nope"), info), "missing sources aren't cacheable", t)
+	assert.Equal("", ih.cacheKey(a, fp, &iiif.Info{}), "sources without dimensions aren't cacheable", t)
+
+	tilePolicy.key = keyHash
+	var hashKey = ih.cacheKey(a, fp, info)
+	assert.True(hashKey != key, "hash keys differ from stat keys", t)
+	assert.Equal(hashKey, ih.cacheKey(b, copyFP, info), "identical files hash the same", t)
+
+	assert.NilError(ioutil.WriteFile(copyFP, []byte("replaced"), 0644), "replacing the copy", t)
+	assert.True(hashKey != ih.cacheKey(b, copyFP, info), "replaced files get new keys", t)
+
+	tilePolicy.key = keyRequest
+	assert.Equal(a.Path, ih.cacheKey(a, fp, info), "request keys are the IIIF path", t)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+)
+
+// TileGrid overrides the tile size and scale factors advertised in info.json.
+// Zero values mean the image's native tiling is advertised.  RAIS decodes
+// arbitrary regions, so it can serve any grid, not just the file's own; a
+// viewer may do better with 512px or 1024px tiles than a JP2's native 256.
+type TileGrid struct {
+	TileWidth        int
+	TileHeight       int
+	TileScaleFactors []int
+}
+
+// tileGridFor returns the tile grid for id, taking its route's overrides
+// into account
+func (ih *ImageHandler) tileGridFor(id iiif.ID) TileGrid {
+	var grid = ih.TileGrid
+	var r = ih.routeFor(id)
+	if r == nil {
+		return grid
+	}
+
+	if r.TileWidth > 0 {
+		grid.TileWidth = r.TileWidth
+		grid.TileHeight = r.TileHeight
+	}
+	if len(r.TileScaleFactors) > 0 {
+		grid.TileScaleFactors = r.TileScaleFactors
+	}
+	return grid
+}
+
+// tileSize returns the tile size to advertise for the image, or nil if it
+// isn't tiled and no grid is configured.  Scale factors default to one per
+// resolution level, stopping before tiles would cover absurdly small sizes.
+func (grid TileGrid) tileSize(i ImageInfo) *iiif.TileSize {
+	var w, h = i.TileWidth, i.TileHeight
+	if grid.TileWidth > 0 {
+		w, h = grid.TileWidth, grid.TileHeight
+	}
+	if w <= 0 {
+		return nil
+	}
+
+	var sf = grid.TileScaleFactors
+	if len(sf) == 0 {
+		var scale = 1
+		var levels = i.Levels
+		if levels < 1 {
+			levels = 1
+		}
+		for x := 0; x < levels; x++ {
+			// For sanity's sake, let's not tell viewers they can get at absurdly
+			// small sizes
+			if i.Width/scale < 16 || i.Height/scale < 16 {
+				break
+			}
+			sf = append(sf, scale)
+			scale <<= 1
+		}
+	}
+
+	var ts = &iiif.TileSize{Width: w, ScaleFactors: sf}
+	if h > 0 {
+		ts.Height = h
+	}
+	return ts
+}
+
+// validate returns an error if the grid's values don't make sense
+func (grid TileGrid) validate() error {
+	if grid.TileWidth < 0 || grid.TileHeight < 0 {
+		return fmt.Errorf("tile sizes must not be negative")
+	}
+	if grid.TileHeight > 0 && grid.TileWidth == 0 {
+		return fmt.Errorf("a tile height requires a tile width")
+	}
+	for _, sf := range grid.TileScaleFactors {
+		if sf < 1 {
+			return fmt.Errorf("scale factors must be positive")
+		}
+	}
+	return nil
+}
+
+// parseScaleFactors turns a list like "1, 2, 4, 8" into a slice of ints
+func parseScaleFactors(val string) ([]int, error) {
+	var list []int
+	for _, s := range strings.Split(val, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		var n, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scale factor %q", s)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
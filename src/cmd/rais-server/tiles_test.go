@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestTileGrid(t *testing.T) {
+	var ih = NewImageHandler("/var/local/images", "/iiif")
+	var native = ImageInfo{Width: 4000, Height: 3000, TileWidth: 256, TileHeight: 256, Levels: 6}
+
+	var ts = ih.tileGridFor("a.jp2").tileSize(native)
+	assert.Equal(256, ts.Width, "native tile width", t)
+	assert.Equal(6, len(ts.ScaleFactors), "one scale factor per level", t)
+
+	ih.TileGrid = TileGrid{TileWidth: 512}
+	ts = ih.tileGridFor("a.jp2").tileSize(native)
+	assert.Equal(512, ts.Width, "configured tile width", t)
+	assert.Equal(0, ts.Height, "square tiles", t)
+
+	ih.Routes = []*Route{{Prefix: "maps:", TileWidth: 1024, TileHeight: 768, TileScaleFactors: []int{1, 2}}}
+	ts = ih.tileGridFor("maps:a.jp2").tileSize(native)
+	assert.Equal(1024, ts.Width, "route tile width", t)
+	assert.Equal(768, ts.Height, "route tile height", t)
+	assert.Equal(2, len(ts.ScaleFactors), "route scale factors", t)
+
+	ts = ih.tileGridFor("maps:a.jp2").tileSize(ImageInfo{Width: 4000, Height: 3000, Levels: 1})
+	assert.Equal(1024, ts.Width, "untiled images get the configured grid", t)
+
+	assert.True(ih.tileGridFor("a.jp2").tileSize(ImageInfo{Width: 4000, Height: 3000}) != nil, "global grid applies to untiled images", t)
+	ih.TileGrid = TileGrid{}
+	assert.True(ih.tileGridFor("a.jp2").tileSize(ImageInfo{Width: 4000, Height: 3000}) == nil, "untiled images have no tiles by default", t)
+}
+
+func TestTileGridValidate(t *testing.T) {
+	assert.NilError(TileGrid{TileWidth: 512, TileScaleFactors: []int{1, 2}}.validate(), "valid grid", t)
+	assert.True(TileGrid{TileHeight: 512}.validate() != nil, "height without width", t)
+	assert.True(TileGrid{TileScaleFactors: []int{0}}.validate() != nil, "zero scale factor", t)
+
+	var sf, err = parseScaleFactors(" 1, 2,4 ")
+	assert.NilError(err, "parsing scale factors", t)
+	assert.Equal(3, len(sf), "all scale factors parsed", t)
+	_, err = parseScaleFactors("1,two")
+	assert.True(err != nil, "invalid scale factor", t)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"rais/src/cmd/rais-server/internal/handoff"
+	"rais/src/cmd/rais-server/internal/servers"
+	"rais/src/cmd/rais-server/internal/systemd"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// upgradeTimeout is how long a new RAIS process has to start serving
+// before an upgrade is abandoned
+const upgradeTimeout = time.Minute
+
+// upgrading prevents a second upgrade from starting while one is underway
+var upgrading sync.Mutex
+
+// inheritedListeners returns the sockets passed to RAIS by systemd or, when
+// this process is an upgrade, by the old RAIS process.  This has to be
+// called before anything starts a child process, such as an external
+// plugin, so the inherited descriptors don't leak into it.
+func inheritedListeners() []net.Listener {
+	var listeners, err = systemd.Listeners()
+	if err != nil {
+		Logger.Fatalf("Unable to use sockets passed by systemd: %s", err)
+	}
+
+	var old []net.Listener
+	old, err = handoff.Listeners()
+	if err != nil {
+		Logger.Fatalf("Unable to use sockets passed by the old RAIS process: %s", err)
+	}
+	if len(old) > 0 {
+		Logger.Infof("Taking over %d socket(s) from the old RAIS process", len(old))
+	}
+	return append(listeners, old...)
+}
+
+// adoptListeners hands inherited sockets to the servers configured with the
+// same addresses.  Those servers use them rather than binding their
+// addresses.
+func adoptListeners(listeners []net.Listener) {
+	for _, ln := range listeners {
+		var srv = servers.Adopt(ln)
+		if srv == nil {
+			Logger.Warnf("Ignoring inherited socket %s: no listener is configured for that address", ln.Addr())
+			ln.Close()
+			continue
+		}
+		Logger.Infof("Serving %q on inherited socket %s", srv.Name, ln.Addr())
+	}
+}
+
+// trapUpgrade starts an upgrade whenever RAIS gets a SIGHUP
+func trapUpgrade() {
+	var sig = make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			upgrade()
+		}
+	}()
+}
+
+// upgrade starts the RAIS binary again, which may since have been replaced
+// with a new version, and passes it our sockets.  Once the new process is
+// serving, this one stops accepting connections, finishes the requests it's
+// working on, and exits.  Connections are never refused, since the sockets
+// stay open throughout.  If the new process fails, this one keeps serving.
+func upgrade() {
+	if !upgrading.TryLock() {
+		Logger.Warnf("Ignoring upgrade request: an upgrade is already underway")
+		return
+	}
+	defer upgrading.Unlock()
+
+	Logger.Infof("Upgrade requested; starting a new RAIS process")
+	var files, err = servers.Files()
+	if err != nil {
+		Logger.Errorf("Unable to upgrade: %s", err)
+		return
+	}
+	var pid int
+	pid, err = handoff.Upgrade(files, upgradeTimeout)
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		Logger.Errorf("Unable to upgrade, continuing to serve requests: %s", err)
+		return
+	}
+
+	Logger.Infof("New RAIS process (pid %d) is serving requests; stopping this one", pid)
+	systemd.Notify(fmt.Sprintf("MAINPID=%d", pid))
+	shutdown()
+}
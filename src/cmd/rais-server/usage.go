@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"rais/src/iiif"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUsageGroups caps how many groups delimiter-based accounting tracks, so a
+// crawler requesting random IDs can't grow the map without bound.  Requests
+// which would need a new group past the cap are counted under usageOther.
+const maxUsageGroups = 1000
+
+// usageOther is the group for IDs which don't belong to any collection
+const usageOther = "(other)"
+
+// usageCount is the traffic for a single group of IDs
+type usageCount struct {
+	Requests uint64
+	Bytes    uint64
+}
+
+// usageAccounting tracks requests and bytes served per collection, for
+// chargeback and for spotting hot collections.  A collection is the longest
+// of the configured prefixes an ID starts with.  If a delimiter is set, IDs
+// which don't match a prefix are grouped by everything up to and including
+// the delimiter's first occurrence.
+type usageAccounting struct {
+	m         sync.Mutex
+	prefixes  []string
+	delimiter string
+	since     time.Time
+	counts    map[string]*usageCount
+}
+
+// usage is nil unless accounting has been configured
+var usage *usageAccounting
+
+func newUsageAccounting(prefixes []string, delimiter string) *usageAccounting {
+	var sorted = append([]string{}, prefixes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	return &usageAccounting{
+		prefixes:  sorted,
+		delimiter: delimiter,
+		since:     time.Now(),
+		counts:    make(map[string]*usageCount),
+	}
+}
+
+// parseUsagePrefixes splits a comma-separated list of collection prefixes
+func parseUsagePrefixes(val string) []string {
+	var list []string
+	for _, p := range strings.Split(val, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// group returns the name of the collection id is counted under
+func (u *usageAccounting) group(id iiif.ID) string {
+	var s = string(id)
+	for _, p := range u.prefixes {
+		if strings.HasPrefix(s, p) {
+			return p
+		}
+	}
+	if u.delimiter != "" {
+		var i = strings.Index(s, u.delimiter)
+		if i > 0 {
+			return s[:i+len(u.delimiter)]
+		}
+	}
+	return usageOther
+}
+
+// record counts a finished IIIF request.  Requests which never got as far as
+// parsing an ID aren't counted.
+func (u *usageAccounting) record(e *accessLogEntry) {
+	if u == nil || e.ID == "" {
+		return
+	}
+
+	var g = u.group(e.ID)
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	var c = u.counts[g]
+	if c == nil {
+		if len(u.counts) >= maxUsageGroups {
+			g = usageOther
+			c = u.counts[g]
+		}
+		if c == nil {
+			c = &usageCount{}
+			u.counts[g] = c
+		}
+	}
+	c.Requests++
+	if e.Bytes > 0 {
+		c.Bytes += uint64(e.Bytes)
+	}
+}
+
+// usageReport is the JSON structure served by the admin endpoint
+type usageReport struct {
+	Since  time.Time
+	Until  time.Time
+	Groups map[string]usageCount
+}
+
+// report returns the current counts, optionally resetting them so the next
+// report starts a new period
+func (u *usageAccounting) report(reset bool) usageReport {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	var r = usageReport{Since: u.since, Until: time.Now(), Groups: make(map[string]usageCount)}
+	for g, c := range u.counts {
+		r.Groups[g] = *c
+	}
+	if reset {
+		u.counts = make(map[string]*usageCount)
+		u.since = r.Until
+	}
+	return r
+}
+
+// ServeHTTP reports usage since the last reset.  POST requests also reset
+// the counts, so a billing job can collect each period's usage exactly once.
+func (u *usageAccounting) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var data, err = json.Marshal(u.report(req.Method == http.MethodPost))
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestUsageGroup(t *testing.T) {
+	var u = newUsageAccounting([]string{"maps:", "maps:usgs/"}, "/")
+	assert.Equal("maps:usgs/", u.group("maps:usgs/1901.jp2"), "longest prefix wins", t)
+	assert.Equal("maps:", u.group("maps:city/1901.jp2"), "shorter prefix", t)
+	assert.Equal("yearbooks/", u.group("yearbooks/1952/p1.jp2"), "delimiter grouping", t)
+	assert.Equal(usageOther, u.group("loose.jp2"), "no prefix or delimiter", t)
+	assert.Equal(usageOther, u.group("/rooted.jp2"), "empty groups are other", t)
+
+	u = newUsageAccounting(nil, "")
+	assert.Equal(usageOther, u.group("yearbooks/1952/p1.jp2"), "no delimiter", t)
+}
+
+func TestUsageRecord(t *testing.T) {
+	var u = newUsageAccounting([]string{"maps:"}, "/")
+	u.record(&accessLogEntry{ID: "maps:a.jp2", Bytes: 100})
+	u.record(&accessLogEntry{ID: "maps:b.jp2", Bytes: 50})
+	u.record(&accessLogEntry{ID: "news/a.jp2", Bytes: 10})
+	u.record(&accessLogEntry{Path: "/favicon.ico", Bytes: 10})
+
+	var r = u.report(false)
+	assert.Equal(2, len(r.Groups), "requests without IDs aren't counted", t)
+	assert.Equal(uint64(2), r.Groups["maps:"].Requests, "request count", t)
+	assert.Equal(uint64(150), r.Groups["maps:"].Bytes, "byte count", t)
+
+	for i := 0; i < maxUsageGroups+5; i++ {
+		u.record(&accessLogEntry{ID: iiif.ID(fmt.Sprintf("c%d/x.jp2", i)), Bytes: 1})
+	}
+	r = u.report(false)
+	assert.Equal(maxUsageGroups+1, len(r.Groups), "groups are capped, plus the other group", t)
+	assert.True(r.Groups[usageOther].Requests > 0, "overflow goes to other", t)
+
+	var w = httptest.NewRecorder()
+	u.ServeHTTP(w, httptest.NewRequest("POST", "/admin/usage", nil))
+	assert.Equal(http.StatusOK, w.Code, "usage endpoint status", t)
+	var posted usageReport
+	json.Unmarshal(w.Body.Bytes(), &posted)
+	assert.Equal(uint64(150), posted.Groups["maps:"].Bytes, "POST reports counts", t)
+	assert.Equal(0, len(u.report(false).Groups), "POST resets counts", t)
+
+	// Disabled accounting is a nil pointer, which must be safe to record to
+	var disabled *usageAccounting
+	disabled.record(&accessLogEntry{ID: "maps:a.jp2"})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"rais/src/iiif"
+	"strings"
+)
+
+// defaultViewerScriptURL is where the demo viewer loads OpenSeadragon from
+// when DemoViewerScriptURL isn't set
+const defaultViewerScriptURL = "https://cdn.jsdelivr.net/npm/openseadragon@4.1/build/openseadragon/openseadragon.min.js"
+
+var viewerTemplate = template.Must(template.New("viewer").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <title>{{.ID}} - RAIS Image Server</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta http-equiv="Content-Type" content="text/html;charset=utf-8">
+    <style>
+      html, body { margin: 0; height: 100%; background: #222; color: #eee; font-family: sans-serif; }
+      h1 { margin: 0; padding: 0.5em; font-size: 1em; font-weight: normal; }
+      #viewer { position: absolute; top: 2.5em; bottom: 0; left: 0; right: 0; }
+    </style>
+  </head>
+
+  <body>
+    <h1><a href="{{.InfoURL}}" style="color: inherit">{{.ID}}</a></h1>
+    <div id="viewer"></div>
+    <script src="{{.ScriptURL}}"></script>
+    <script type="text/javascript">
+      OpenSeadragon({
+        id: "viewer",
+        prefixUrl: {{.ImagesURL}},
+        tileSources: {{.InfoURL}},
+        showNavigator: true,
+      });
+    </script>
+  </body>
+</html>
+`))
+
+// demoViewer serves a minimal OpenSeadragon page at "/view/{id}" so an
+// install can be checked, and images browsed, without a separate front-end
+type demoViewer struct {
+	ih        *ImageHandler
+	scriptURL string
+}
+
+func (dv *demoViewer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var id = iiif.ID(strings.TrimPrefix(req.URL.Path, "/view/"))
+	if id == "" {
+		http.NotFound(w, req)
+		return
+	}
+	if validateID(id) != nil {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		return
+	}
+
+	var base = dv.ih.BaseURL
+	if base == nil {
+		base = getRequestURL(req)
+	}
+	var scriptURL = dv.scriptURL
+	if scriptURL == "" {
+		scriptURL = defaultViewerScriptURL
+	}
+
+	var data = map[string]string{
+		"ID":        string(id),
+		"InfoURL":   base.Scheme + "://" + base.Host + base.Path + dv.ih.WebPathPrefix + "/" + id.Escaped() + "/info.json",
+		"ScriptURL": scriptURL,
+		"ImagesURL": scriptURL[:strings.LastIndex(scriptURL, "/")+1] + "images/",
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var err = viewerTemplate.Execute(w, data)
+	if err != nil {
+		Logger.Errorf("Unable to render viewer for %q: %s", id, err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestDemoViewer(t *testing.T) {
+	var dv = &demoViewer{ih: NewImageHandler("/var/local/images", "/iiif"), scriptURL: "/osd/openseadragon.min.js"}
+
+	var w = httptest.NewRecorder()
+	dv.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/view/maps%2F1901.jp2", nil))
+	var body = w.Body.String()
+	assert.Equal(http.StatusOK, w.Code, "status", t)
+	assert.True(strings.Contains(body, `"http://example.org/iiif/maps%2F1901.jp2/info.json"`), "tile source is the info.json URL", t)
+	assert.True(strings.Contains(body, `"/osd/images/"`), "images are next to the script", t)
+
+	w = httptest.NewRecorder()
+	dv.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/view/../secret.jp2", nil))
+	assert.True(w.Code != http.StatusOK, "traversal is rejected", t)
+
+	w = httptest.NewRecorder()
+	dv.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/view/", nil))
+	assert.Equal(http.StatusNotFound, w.Code, "no ID is a 404", t)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on warm requests: the list can't be huge, and concurrency shouldn't
+// be high enough to starve real traffic
+const (
+	maxWarmBodySize    = 10 << 20
+	defaultWarmWorkers = 4
+	maxWarmWorkers     = 64
+	maxWarmFailures    = 100
+)
+
+// warmFailure records an ID which couldn't be warmed, and why
+type warmFailure struct {
+	ID    iiif.ID `json:"id"`
+	Error string  `json:"error"`
+}
+
+// warmJob is the progress of a single cache warming run.  Only the first
+// maxWarmFailures failures are kept, though all are counted.
+type warmJob struct {
+	m        sync.Mutex
+	Total    int           `json:"total"`
+	Done     int           `json:"done"`
+	Cached   int           `json:"already_cached"`
+	Loaded   int           `json:"loaded"`
+	Failed   int           `json:"failed"`
+	Failures []warmFailure `json:"failures,omitempty"`
+	Workers  int           `json:"workers"`
+	Started  time.Time     `json:"started"`
+	Finished *time.Time    `json:"finished,omitempty"`
+}
+
+func (j *warmJob) record(id iiif.ID, cached bool, err error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	j.Done++
+	switch {
+	case err != nil:
+		j.Failed++
+		if len(j.Failures) < maxWarmFailures {
+			j.Failures = append(j.Failures, warmFailure{ID: id, Error: err.Error()})
+		}
+	case cached:
+		j.Cached++
+	default:
+		j.Loaded++
+	}
+}
+
+func (j *warmJob) finish() {
+	j.m.Lock()
+	defer j.m.Unlock()
+	var now = time.Now()
+	j.Finished = &now
+}
+
+func (j *warmJob) running() bool {
+	j.m.Lock()
+	defer j.m.Unlock()
+	return j.Finished == nil
+}
+
+func (j *warmJob) serialize() ([]byte, error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+	return json.Marshal(j)
+}
+
+// cacheWarmer fills the info cache ahead of traffic, e.g., before a large
+// batch of newly published images is announced.  A POST to its endpoint
+// starts a job from a list of IDs in the request body, either as a JSON
+// array or one ID per line:
+//
+//	curl --data-binary @ids.txt 'localhost:12416/admin/cache/warm?workers=8'
+//
+// Each ID is resolved just as a real request would be, including plugins and
+// takedowns, and its headers are read if it isn't already cached.  Only one
+// job runs at a time; a GET reports the current or most recent job's
+// progress.
+type cacheWarmer struct {
+	ih  *ImageHandler
+	m   sync.Mutex
+	job *warmJob
+}
+
+func (cw *cacheWarmer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		cw.status(w)
+	case http.MethodPost:
+		cw.start(w, req)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (cw *cacheWarmer) status(w http.ResponseWriter) {
+	cw.m.Lock()
+	var job = cw.job
+	cw.m.Unlock()
+
+	if job == nil {
+		http.Error(w, "No cache warming has been requested", http.StatusNotFound)
+		return
+	}
+	cw.writeJob(w, job, http.StatusOK)
+}
+
+func (cw *cacheWarmer) writeJob(w http.ResponseWriter, job *warmJob, code int) {
+	var data, err = job.serialize()
+	if err != nil {
+		http.Error(w, "error generating json: "+err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+func (cw *cacheWarmer) start(w http.ResponseWriter, req *http.Request) {
+	if infoCache == nil {
+		http.Error(w, "The info cache is disabled", http.StatusConflict)
+		return
+	}
+
+	var workers = defaultWarmWorkers
+	if val := req.URL.Query().Get("workers"); val != "" {
+		var err error
+		workers, err = strconv.Atoi(val)
+		if err != nil || workers < 1 || workers > maxWarmWorkers {
+			http.Error(w, fmt.Sprintf("workers must be a number from 1 to %d", maxWarmWorkers), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var body, err = ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxWarmBodySize))
+	if err != nil {
+		http.Error(w, "Unable to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ids []iiif.ID
+	ids, err = parseWarmIDs(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cw.m.Lock()
+	if cw.job != nil && cw.job.running() {
+		cw.m.Unlock()
+		http.Error(w, "A cache warming job is already running", http.StatusConflict)
+		return
+	}
+	var job = &warmJob{Total: len(ids), Workers: workers, Started: time.Now()}
+	cw.job = job
+	cw.m.Unlock()
+
+	Logger.Infof("Warming info cache for %d IDs with %d workers", len(ids), workers)
+	go cw.run(job, ids)
+	cw.writeJob(w, job, http.StatusAccepted)
+}
+
+// run warms the cache for each ID, returning when all are done
+func (cw *cacheWarmer) run(job *warmJob, ids []iiif.ID) {
+	var queue = make(chan iiif.ID)
+	var wg sync.WaitGroup
+	for i := 0; i < job.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			for id := range queue {
+				cw.warm(job, id)
+			}
+			wg.Done()
+		}()
+	}
+
+	for _, id := range ids {
+		queue <- id
+	}
+	close(queue)
+	wg.Wait()
+	job.finish()
+
+	Logger.Infof("Info cache warming complete: %d loaded, %d already cached, %d failed",
+		job.Loaded, job.Cached, job.Failed)
+}
+
+// warm loads id's info and records the result in job.  A panic is logged and
+// counted as a failure, so one bad image can't end the process.
+func (cw *cacheWarmer) warm(job *warmJob, id iiif.ID) {
+	var cached bool
+	var err error
+	defer func() { job.record(id, cached, err) }()
+	defer catchPanic(context.Background(), fmt.Sprintf("warming %q", id), &err)
+	cached, err = cw.ih.warmInfo(id)
+}
+
+// warmInfo reads id's info into the info cache unless it's already there,
+// returning true if it was
+func (ih *ImageHandler) warmInfo(id iiif.ID) (bool, error) {
+	if takedowns.get(id) != nil {
+		return false, errors.New("image has been removed")
+	}
+	if infoCache.Contains(id) {
+		return true, nil
+	}
+
+	// Warming outlives the request that started it, so it can't be canceled
+	var ctx = context.Background()
+	var fp, err = ih.resolveIIIFPath(ctx, id)
+	if err == plugins.ErrForbidden {
+		return false, errors.New("access to this image is forbidden")
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var _, e = ih.getInfo(ctx, id, fp)
+	if e != nil {
+		return false, errors.New(e.Message)
+	}
+	return false, nil
+}
+
+// parseWarmIDs reads a JSON array of IDs or a newline-separated list.  Blank
+// lines and duplicates are skipped.
+func parseWarmIDs(body []byte) ([]iiif.ID, error) {
+	var list []string
+	var trimmed = bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var err = json.Unmarshal(trimmed, &list)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON ID list: %s", err)
+		}
+	} else {
+		list = strings.Split(string(trimmed), "\n")
+	}
+
+	var ids []iiif.ID
+	var seen = make(map[iiif.ID]bool)
+	for _, s := range list {
+		var id = iiif.ID(strings.TrimSpace(s))
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no IDs were given")
+	}
+	return ids, nil
+}
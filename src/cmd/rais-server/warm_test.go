@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestParseWarmIDs(t *testing.T) {
+	var ids, err = parseWarmIDs([]byte("a.jp2\n\n  b.jp2 \r\na.jp2\n"))
+	assert.NilError(err, "newline list", t)
+	assert.Equal("[a.jp2 b.jp2]", fmt.Sprint(ids), "blank lines and dupes are skipped", t)
+
+	ids, err = parseWarmIDs([]byte(` ["a.jp2", "dir/b.jp2"]`))
+	assert.NilError(err, "JSON list", t)
+	assert.Equal("[a.jp2 dir/b.jp2]", fmt.Sprint(ids), "JSON IDs", t)
+
+	_, err = parseWarmIDs([]byte(`["a.jp2"`))
+	assert.True(err != nil, "invalid JSON", t)
+	_, err = parseWarmIDs([]byte("\n \n"))
+	assert.True(err != nil, "empty list", t)
+}
+
+func TestCacheWarmer(t *testing.T) {
+	var oldCache = infoCache
+	infoCache, _ = lru.New(10)
+	defer func() { infoCache = oldCache }()
+
+	var cached = iiif.ID("cached.jp2")
+	infoCache.Add(cached, ImageInfo{Width: 1, Height: 1})
+
+	var good = iiif.ID("docker/images/testfile/test-world-link.jp2")
+	var cw = &cacheWarmer{ih: NewImageHandler(rootDir(), "/iiif")}
+	var job = &warmJob{Total: 3, Workers: 2, Started: time.Now()}
+	cw.run(job, []iiif.ID{good, cached, "missing.jp2"})
+
+	assert.False(job.running(), "job is finished", t)
+	assert.Equal(3, job.Done, "all done", t)
+	assert.Equal(1, job.Loaded, "loaded", t)
+	assert.Equal(1, job.Cached, "already cached", t)
+	assert.Equal(1, job.Failed, "failed", t)
+	assert.Equal(iiif.ID("missing.jp2"), job.Failures[0].ID, "failure ID", t)
+	assert.True(infoCache.Contains(good), "good ID is now cached", t)
+}
+
+func TestCacheWarmerPanic(t *testing.T) {
+	var oldCache, oldPlugins = infoCache, idToPathPlugins
+	infoCache, _ = lru.New(10)
+	defer func() { infoCache, idToPathPlugins = oldCache, oldPlugins }()
+	idToPathPlugins = append(idToPathPlugins, func(ctx context.Context, id iiif.ID) (string, error) {
+		if id == "boom.jp2" {
+			panic("plugin bug")
+		}
+		return "", plugins.ErrSkipped
+	})
+
+	var good = iiif.ID("docker/images/testfile/test-world-link.jp2")
+	var cw = &cacheWarmer{ih: NewImageHandler(rootDir(), "/iiif")}
+	var job = &warmJob{Total: 2, Workers: 1, Started: time.Now()}
+	cw.run(job, []iiif.ID{"boom.jp2", good})
+
+	assert.Equal(2, job.Done, "warming continues after a panic", t)
+	assert.Equal(1, job.Failed, "the panic is a failure", t)
+	assert.Equal(errPanic.Error(), job.Failures[0].Error, "failure reason", t)
+	assert.True(infoCache.Contains(good), "good ID is cached", t)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Ways to watch source images for changes
+const (
+	watchNotify = "notify"
+	watchScan   = "scan"
+)
+
+var watchModes = []string{watchNotify, watchScan}
+
+// sourceSettleTime is how long a changed file has to sit untouched before
+// its cached data is expired, so a slow upload is only handled once it's done
+const sourceSettleTime = 2 * time.Second
+
+// watchRoot is a directory of source images and the prefix which turns a
+// file's path under the directory into its IIIF ID
+type watchRoot struct {
+	dir    string
+	prefix string
+}
+
+// id returns the IIIF ID for the file at path, which must be under the root
+func (r watchRoot) id(path string) (iiif.ID, bool) {
+	var rel, err = filepath.Rel(r.dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if r.prefix == "" || strings.HasSuffix(r.prefix, "/") {
+		return iiif.ID(r.prefix + rel), true
+	}
+	return iiif.ID(r.prefix + "/" + rel), true
+}
+
+// sourceWatcher expires cached info and tiles for images whose files are
+// replaced or removed, so corrected scans show up without a restart.  New
+// files are handled too, in case a request for them has been cached as
+// missing.  Every change is also recorded for change discovery.
+type sourceWatcher struct {
+	roots []watchRoot
+
+	m       sync.Mutex
+	changed map[string]time.Time
+	created map[string]bool
+	expire  func(iiif.ID)
+	record  func(activityType, iiif.ID)
+}
+
+func newSourceWatcher(roots []watchRoot) *sourceWatcher {
+	return &sourceWatcher{
+		roots:   roots,
+		changed: make(map[string]time.Time),
+		created: make(map[string]bool),
+		expire:  expireCachedImage,
+		record:  recordActivity,
+	}
+}
+
+// sourceRoots returns the main tile path and any routes' tile paths
+func sourceRoots(ih *ImageHandler) []watchRoot {
+	var roots = []watchRoot{{dir: ih.TilePath}}
+	for _, r := range ih.Routes {
+		if r.TilePath != "" {
+			roots = append(roots, watchRoot{dir: r.TilePath, prefix: r.Prefix})
+		}
+	}
+	return roots
+}
+
+// ignored returns true for files which aren't images, such as the hidden
+// temp files rsync and many editors write before renaming them into place
+func ignored(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// touch records that the file at path has changed
+func (sw *sourceWatcher) touch(path string) {
+	if ignored(path) {
+		return
+	}
+	sw.m.Lock()
+	sw.changed[path] = time.Now()
+	sw.m.Unlock()
+}
+
+// touchNew records that the file at path has been created
+func (sw *sourceWatcher) touchNew(path string) {
+	if ignored(path) {
+		return
+	}
+	sw.m.Lock()
+	sw.changed[path] = time.Now()
+	sw.created[path] = true
+	sw.m.Unlock()
+}
+
+// changeType returns the kind of change made to the file at path
+func (sw *sourceWatcher) changeType(path string, created bool) activityType {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return activityDelete
+	}
+	if created {
+		return activityCreate
+	}
+	return activityUpdate
+}
+
+// flush expires cached data for files which haven't changed in the last
+// sourceSettleTime
+func (sw *sourceWatcher) flush(now time.Time) {
+	var ready = make(map[string]bool)
+	sw.m.Lock()
+	for path, t := range sw.changed {
+		if now.Sub(t) >= sourceSettleTime {
+			ready[path] = sw.created[path]
+			delete(sw.changed, path)
+			delete(sw.created, path)
+		}
+	}
+	sw.m.Unlock()
+
+	for path, created := range ready {
+		sw.apply(path, created)
+	}
+}
+
+// apply expires cached data for the IDs path serves, and records the change
+func (sw *sourceWatcher) apply(path string, created bool) {
+	defer catchPanic(context.Background(), fmt.Sprintf("handling a change to %q", path), nil)
+	var kind = sw.changeType(path, created)
+	for _, r := range sw.roots {
+		if id, ok := r.id(path); ok {
+			Logger.Infof("Source file %q changed; expiring cached data for %q", path, id)
+			sw.expire(id)
+			sw.record(kind, id)
+		}
+	}
+}
+
+// notify watches every directory under the roots with fsnotify, expiring
+// cached data as files change.  Most filesystems limit how many directories
+// can be watched, and network filesystems usually don't send events at all;
+// scan works in both cases.
+func (sw *sourceWatcher) notify() error {
+	var w, err = fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, r := range sw.roots {
+		err = watchTree(w, r.dir)
+		if err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	go func() {
+		var tick = time.NewTicker(sourceSettleTime / 2)
+		for {
+			select {
+			case ev := <-w.Events:
+				if ev.Op&fsnotify.Create != 0 {
+					if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+						watchTree(w, ev.Name)
+						continue
+					}
+				}
+				if ev.Op&fsnotify.Create != 0 {
+					sw.touchNew(ev.Name)
+				} else if ev.Op&(fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 {
+					sw.touch(ev.Name)
+				}
+			case err := <-w.Errors:
+				Logger.Errorf("Error watching source images: %s", err)
+			case now := <-tick.C:
+				sw.flush(now)
+			}
+		}
+	}()
+	return nil
+}
+
+// watchTree adds dir and all its subdirectories to w
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return w.Add(path)
+		}
+		return nil
+	})
+}
+
+// snapshot returns the fingerprint of every file under the roots
+func (sw *sourceWatcher) snapshot() map[string]string {
+	var files = make(map[string]string)
+	for _, r := range sw.roots {
+		var err = filepath.Walk(r.dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				Logger.Warnf("Unable to scan %q for source changes: %s", path, err)
+				return nil
+			}
+			if !fi.IsDir() && !ignored(path) {
+				files[path] = fileFingerprint(fi)
+			}
+			return nil
+		})
+		if err != nil {
+			Logger.Warnf("Unable to scan %q for source changes: %s", r.dir, err)
+		}
+	}
+	return files
+}
+
+// compare marks files which differ between two snapshots as changed
+func (sw *sourceWatcher) compare(old, cur map[string]string) {
+	for path, fp := range cur {
+		if _, ok := old[path]; !ok {
+			sw.touchNew(path)
+		} else if old[path] != fp {
+			sw.touch(path)
+		}
+	}
+	for path := range old {
+		if _, ok := cur[path]; !ok {
+			sw.touch(path)
+		}
+	}
+}
+
+// scan walks the roots every interval, comparing each file's modification
+// time and size to the previous walk.  This is slower to notice changes than
+// notify, and each walk stats every file, so the interval shouldn't be much
+// shorter than a walk takes.
+func (sw *sourceWatcher) scan(interval time.Duration) {
+	var files = sw.snapshot()
+	for range time.Tick(interval) {
+		var cur = sw.snapshot()
+		sw.compare(files, cur)
+		files = cur
+
+		// A scan is slow enough that anything it found has settled, unless it
+		// was still being written when we looked, in which case the next scan
+		// will see it again
+		sw.flush(time.Now().Add(sourceSettleTime))
+	}
+}
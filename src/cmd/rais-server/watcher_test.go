@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestWatchRootID(t *testing.T) {
+	var r = watchRoot{dir: "/var/images"}
+	var id, ok = r.id("/var/images/maps/a.jp2")
+	assert.True(ok, "files under the root have an ID", t)
+	assert.Equal(iiif.ID("maps/a.jp2"), id, "ID is the relative path", t)
+
+	_, ok = r.id("/var/other/a.jp2")
+	assert.False(ok, "files outside the root have no ID", t)
+	_, ok = r.id("/var/images")
+	assert.False(ok, "the root itself has no ID", t)
+
+	r = watchRoot{dir: "/mnt/maps", prefix: "maps/"}
+	id, _ = r.id("/mnt/maps/a.jp2")
+	assert.Equal(iiif.ID("maps/a.jp2"), id, "route prefix is prepended", t)
+
+	r = watchRoot{dir: "/mnt/maps", prefix: "maps"}
+	id, _ = r.id("/mnt/maps/a.jp2")
+	assert.Equal(iiif.ID("maps/a.jp2"), id, "route prefix without a trailing slash", t)
+}
+
+func TestSourceWatcherScan(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-watch")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var write = func(name, data string) {
+		var fname = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(fname), 0755)
+		assert.NilError(ioutil.WriteFile(fname, []byte(data), 0644), "writing "+name, t)
+	}
+	write("a.jp2", "a")
+	write("sub/b.jp2", "b")
+	write("c.jp2", "c")
+
+	var expired []string
+	var changes = make(map[iiif.ID]activityType)
+	var sw = newSourceWatcher([]watchRoot{{dir: dir}})
+	sw.expire = func(id iiif.ID) { expired = append(expired, string(id)) }
+	sw.record = func(t activityType, id iiif.ID) { changes[id] = t }
+
+	var before = sw.snapshot()
+	assert.Equal(3, len(before), "all files found", t)
+
+	write("sub/b.jp2", "bigger b")
+	write("d.jp2", "d")
+	write(".d.jp2.tmp", "d")
+	os.Remove(filepath.Join(dir, "c.jp2"))
+	sw.compare(before, sw.snapshot())
+
+	sw.flush(time.Now())
+	assert.Equal(0, len(expired), "changes aren't handled until they've settled", t)
+
+	sw.flush(time.Now().Add(sourceSettleTime))
+	sort.Strings(expired)
+	assert.Equal("[c.jp2 d.jp2 sub/b.jp2]", fmt.Sprint(expired), "changed, added, and removed files are expired", t)
+	assert.Equal(activityUpdate, changes["sub/b.jp2"], "changed files are updates", t)
+	assert.Equal(activityCreate, changes["d.jp2"], "added files are creates", t)
+	assert.Equal(activityDelete, changes["c.jp2"], "removed files are deletes", t)
+
+	expired = nil
+	sw.flush(time.Now().Add(sourceSettleTime))
+	assert.Equal(0, len(expired), "files are only expired once per change", t)
+}
@@ -0,0 +1,226 @@
+// rais-validate checks that RAIS can serve the images under a tile path (or
+// a list of IDs): each image's header is read and a small copy of it is
+// decoded, and any image which is corrupt, unsupported, or missing is
+// reported.  Results are written as one JSON object per line.  Checksums can
+// be recorded to a manifest and checked against it later, so silent
+// corruption or unexpected replacement of source files is caught.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var opts struct {
+	TilePath  string `short:"t" long:"tile-path" required:"true" description:"directory holding the images, as in the server's TilePath"`
+	IDs       string `short:"i" long:"ids" description:"file listing IDs to check, one per line, instead of every file under the tile path"`
+	Checksums bool   `short:"c" long:"checksums" description:"include each image's SHA-256 in the output"`
+	Manifest  string `short:"m" long:"manifest" description:"compare checksums against this manifest (implies --checksums)"`
+	Record    string `short:"r" long:"record" description:"write a checksum manifest of the valid images (implies --checksums)"`
+	Workers   int    `short:"w" long:"workers" description:"number of images to check at once (default: one per CPU)"`
+	Quiet     bool   `short:"q" long:"quiet" description:"only output images with problems"`
+}
+
+func main() {
+	var parser = flags.NewParser(&opts, flags.Default)
+	var _, err = parser.Parse()
+	if err != nil {
+		os.Exit(1)
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	var withChecksum = opts.Checksums || opts.Manifest != "" || opts.Record != ""
+
+	var m manifest
+	if opts.Manifest != "" {
+		m, err = loadManifest(opts.Manifest)
+		if err != nil {
+			fail("unable to read manifest %q: %s", opts.Manifest, err)
+		}
+	}
+
+	var ids []iiif.ID
+	if opts.IDs != "" {
+		ids, err = readIDs(opts.IDs)
+	} else {
+		ids, err = walk(opts.TilePath)
+	}
+	if err != nil {
+		fail("%s", err)
+	}
+
+	registerDecoders()
+	var checks = run(ids, withChecksum)
+
+	// When the whole tile path was walked, anything in the manifest that
+	// wasn't found has gone missing
+	if m != nil {
+		var seen = make(map[iiif.ID]bool)
+		for i := range checks {
+			m.compare(&checks[i])
+			seen[checks[i].ID] = true
+		}
+		if opts.IDs == "" {
+			for id := range m {
+				if !seen[iiif.ID(id)] {
+					checks = append(checks, check{ID: iiif.ID(id), Path: filepath.Join(opts.TilePath, id), Status: statusMissing})
+				}
+			}
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].ID < checks[j].ID })
+
+	var problems = output(checks)
+	if opts.Record != "" {
+		err = recordManifest(opts.Record, checks)
+		if err != nil {
+			fail("unable to write manifest %q: %s", opts.Record, err)
+		}
+	}
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+// walk returns the IDs of every regular file under root, skipping hidden
+// files and directories
+func walk(root string) ([]iiif.ID, error) {
+	var ids []iiif.ID
+	var err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		var rel, _ = filepath.Rel(root, path)
+		ids = append(ids, iiif.ID(filepath.ToSlash(rel)))
+		return nil
+	})
+	return ids, err
+}
+
+// readIDs reads a list of IDs, one per line, ignoring blank lines
+func readIDs(fname string) ([]iiif.ID, error) {
+	var f, err = os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ids []iiif.ID
+	var s = bufio.NewScanner(f)
+	for s.Scan() {
+		var line = strings.TrimSpace(s.Text())
+		if line != "" {
+			ids = append(ids, iiif.ID(line))
+		}
+	}
+	return ids, s.Err()
+}
+
+// run validates the images using opts.Workers goroutines
+func run(ids []iiif.ID, withChecksum bool) []check {
+	var queue = make(chan iiif.ID)
+	var checks = make([]check, 0, len(ids))
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				var c = validate(id, filepath.Join(opts.TilePath, filepath.FromSlash(string(id))), withChecksum)
+				m.Lock()
+				checks = append(checks, c)
+				m.Unlock()
+			}
+		}()
+	}
+
+	for _, id := range ids {
+		queue <- id
+	}
+	close(queue)
+	wg.Wait()
+	return checks
+}
+
+// output writes the checks as JSON lines, and a summary to stderr, returning
+// the number of images with problems
+func output(checks []check) int {
+	var enc = json.NewEncoder(os.Stdout)
+	var counts = make(map[string]int)
+	var problems int
+	for _, c := range checks {
+		counts[c.Status]++
+		if !c.ok() {
+			problems++
+		}
+		if c.ok() && opts.Quiet {
+			continue
+		}
+		enc.Encode(c)
+	}
+
+	var parts []string
+	for _, s := range []string{statusOK, statusUnsupported, statusCorrupt, statusMissing, statusChanged} {
+		if counts[s] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d images checked: %s\n", len(checks), strings.Join(parts, ", "))
+	return problems
+}
+
+func loadManifest(fname string) (manifest, error) {
+	var f, err = os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readManifest(f)
+}
+
+// recordManifest writes the checksums of the valid images to fname
+func recordManifest(fname string, checks []check) error {
+	var m = make(manifest)
+	for _, c := range checks {
+		if c.ok() {
+			m[string(c.ID)] = c.Checksum
+		}
+	}
+
+	var f, err = os.Create(fname)
+	if err != nil {
+		return err
+	}
+	err = m.write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
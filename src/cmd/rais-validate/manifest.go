@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// manifest maps IDs to file checksums.  It's read and written in the same
+// format as sha256sum, so it can also be checked with standard tools from
+// the tile path.
+type manifest map[string]string
+
+func readManifest(r io.Reader) (manifest, error) {
+	var m = make(manifest)
+	var s = bufio.NewScanner(r)
+	var n int
+	for s.Scan() {
+		n++
+		var line = strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var parts = strings.SplitN(line, " ", 2)
+		if len(parts) != 2 || len(parts[0]) != 64 {
+			return nil, fmt.Errorf("line %d: expected a SHA-256 checksum and a file name", n)
+		}
+		// sha256sum puts a "*" before the name in binary mode
+		m[strings.TrimPrefix(strings.TrimLeft(parts[1], " "), "*")] = parts[0]
+	}
+	return m, s.Err()
+}
+
+func (m manifest) write(w io.Writer) error {
+	var ids = make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		var _, err = fmt.Fprintf(w, "%s  %s\n", m[id], id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compare checks c's checksum against the manifest, marking it changed if
+// they differ.  Images which aren't in the manifest are left alone.
+func (m manifest) compare(c *check) {
+	var want, ok = m[string(c.ID)]
+	if !ok || c.Checksum == "" || c.Checksum == want {
+		return
+	}
+	c.Status = statusChanged
+	c.Expected = want
+	c.Error = "checksum doesn't match the manifest"
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/openjpeg"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// Validation statuses
+const (
+	statusOK          = "ok"
+	statusUnsupported = "unsupported"
+	statusCorrupt     = "corrupt"
+	statusMissing     = "missing"
+	statusChanged     = "changed"
+)
+
+// check is the validation result for one image, written as a line of JSON
+type check struct {
+	ID       iiif.ID `json:"id"`
+	Path     string  `json:"path"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Checksum string  `json:"sha256,omitempty"`
+	Expected string  `json:"expected_sha256,omitempty"`
+}
+
+func (c check) ok() bool {
+	return c.Status == statusOK
+}
+
+// decodeSize is the size of the test decode: small enough to be fast on
+// multi-resolution images, while still reading every part of the codestream
+// a thumbnail would need
+const decodeSize = 64
+
+// registerDecoders sets up the built-in JP2 decoder.  Plugins aren't loaded,
+// so other formats are reported as unsupported.
+func registerDecoders() {
+	openjpeg.Logger = logger.New(logger.Warn)
+	img.RegisterNamedDecoder(img.NamedDecoder{
+		Name:       "openjpeg",
+		Extensions: []string{".jp2"},
+		Magic:      [][]byte{{0x00, 0x00, 0x00, 0x0C, 'j', 'P', ' ', ' ', 0x0D, 0x0A, 0x87, 0x0A}},
+		Decode:     func(path string) (img.Decoder, error) { return openjpeg.NewJP2Image(path) },
+	})
+}
+
+// validate reads the image's header and decodes a small copy of it, and if
+// withChecksum is true, computes the file's SHA-256
+func validate(id iiif.ID, path string, withChecksum bool) check {
+	var c = check{ID: id, Path: path, Status: statusOK}
+	var res, err = img.NewResource(id, path)
+	if err != nil {
+		c.Status, c.Error = classify(err), err.Error()
+		return c
+	}
+	c.Width, c.Height = res.Decoder.GetWidth(), res.Decoder.GetHeight()
+
+	var u *iiif.URL
+	u, err = iiif.NewURL(id.Escaped() + fmt.Sprintf("/full/!%d,%d/0/default.png", decodeSize, decodeSize))
+	if err == nil {
+		_, err = res.Apply(u, img.Constraint{Width: decodeSize, Height: decodeSize, Area: decodeSize * decodeSize})
+	}
+	if err != nil {
+		c.Status, c.Error = statusCorrupt, err.Error()
+		return c
+	}
+
+	if withChecksum {
+		c.Checksum, err = checksum(path)
+		if err != nil {
+			c.Status, c.Error = statusCorrupt, err.Error()
+		}
+	}
+	return c
+}
+
+// classify turns a resource error into a status
+func classify(err error) string {
+	switch err {
+	case img.ErrInvalidFiletype:
+		return statusUnsupported
+	case img.ErrDoesNotExist:
+		return statusMissing
+	}
+	return statusCorrupt
+}
+
+func checksum(path string) (string, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var h = sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func init() {
+	registerDecoders()
+}
+
+func tempTree(t *testing.T, files map[string][]byte) string {
+	var dir, err = ioutil.TempDir("", "rais-validate")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, data := range files {
+		var fname = filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(fname), 0755)
+		err = ioutil.WriteFile(fname, data, 0644)
+		if err != nil {
+			t.Fatalf("Unable to write %q: %s", fname, err)
+		}
+	}
+	return dir
+}
+
+func TestValidateProblems(t *testing.T) {
+	var jp2, err = ioutil.ReadFile("../../../docker/images/testfile/test-world.jp2")
+	assert.NilError(err, "reading the test JP2", t)
+	var dir = tempTree(t, map[string][]byte{
+		"notes.txt":     []byte("not an image"),
+		"a/broken.jp2":  jp2[:40],
+		".hidden/x.jp2": jp2,
+	})
+
+	var ids []string
+	var list, _ = walk(dir)
+	for _, id := range list {
+		ids = append(ids, string(id))
+	}
+	assert.Equal("a/broken.jp2 notes.txt", strings.Join(ids, " "), "hidden directories are skipped", t)
+
+	var c = validate("notes.txt", filepath.Join(dir, "notes.txt"), false)
+	assert.Equal(statusUnsupported, c.Status, "text files aren't images", t)
+	c = validate("a/broken.jp2", filepath.Join(dir, "a", "broken.jp2"), false)
+	assert.Equal(statusCorrupt, c.Status, "truncated JP2s are corrupt", t)
+	assert.True(c.Error != "", "corruption is explained", t)
+	c = validate("gone.jp2", filepath.Join(dir, "gone.jp2"), false)
+	assert.Equal(statusMissing, c.Status, "missing files", t)
+}
+
+func TestManifest(t *testing.T) {
+	var sum = strings.Repeat("ab", 32)
+	var other = strings.Repeat("cd", 32)
+	var m, err = readManifest(strings.NewReader("# comment\n" + sum + "  a/b.jp2\n" + other + " *c.jp2\n"))
+	assert.NilError(err, "reading a manifest", t)
+	assert.Equal(sum, m["a/b.jp2"], "text mode entry", t)
+	assert.Equal(other, m["c.jp2"], "binary mode entry", t)
+
+	var buf bytes.Buffer
+	assert.NilError(m.write(&buf), "writing the manifest", t)
+	assert.Equal(sum+"  a/b.jp2\n"+other+"  c.jp2\n", buf.String(), "manifests are written sorted, in sha256sum format", t)
+
+	var c = check{ID: "a/b.jp2", Status: statusOK, Checksum: sum}
+	m.compare(&c)
+	assert.Equal(statusOK, c.Status, "matching checksums", t)
+	c.Checksum = other
+	m.compare(&c)
+	assert.Equal(statusChanged, c.Status, "changed files are caught", t)
+	assert.Equal(sum, c.Expected, "the expected checksum is reported", t)
+
+	_, err = readManifest(strings.NewReader("abc file\n"))
+	assert.True(err != nil, "invalid checksums are rejected", t)
+}
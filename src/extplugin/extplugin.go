@@ -0,0 +1,481 @@
+// Package extplugin runs RAIS plugins as separate processes.  Unlike native
+// Go plugins, an external plugin doesn't have to be built with the same Go
+// toolchain as RAIS, or written in Go at all.
+//
+// RAIS starts each external plugin as a child process, and the two talk
+// gRPC, much as with HashiCorp's go-plugin.  Once the plugin is listening,
+// it writes a single line to stdout telling RAIS where to connect:
+//
+//	1|unix|/tmp/plugin123/plugin.sock
+//
+// The fields are the protocol version (always 1), the network ("unix" or
+// "tcp"), and the address.  A tcp address must be on the loopback
+// interface.  Anything else the plugin writes to stdout, as well as all it
+// writes to stderr, is passed through to RAIS's stderr for logging.  When
+// RAIS shuts down it closes the plugin's stdin, and the plugin should exit.
+// If the plugin exits on its own, calls fail until RAIS restarts it; see
+// Client.
+//
+// The plugin must serve the Plugin service defined in pluginpb/plugin.proto:
+//
+//   - Handshake: called once per run.  The plugin replies with the API
+//     version it speaks and the capabilities it provides.
+//   - IDToPath: called for the IDToPath capability.  The request's
+//     request_id holds the id of the request being served, so the plugin can
+//     include it in its log messages.
+//   - PurgeCaches and ExpireCachedImage: called for the CachePurge
+//     capability.
+//
+// Only the IDToPath and CachePurge capabilities are supported for external
+// plugins.  Go plugins can use Serve to handle the protocol.
+package extplugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"rais/src/extplugin/pluginpb"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"rais/src/requestid"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// MinAPIVersion is the oldest plugin API version external plugins may use;
+// there was no external plugin support before version 2
+const MinAPIVersion = 2
+
+// Capabilities lists the capabilities external plugins may declare
+var Capabilities = []string{plugins.CapIDToPath, plugins.CapCachePurge}
+
+// ProtocolVersion is the first field of the line a plugin writes to tell
+// RAIS where to connect
+const ProtocolVersion = 1
+
+// RestartDelay is the least time between attempts to start a plugin, so a
+// plugin which dies right away isn't restarted on every call
+var RestartDelay = 5 * time.Second
+
+// ErrClosed is returned by calls made after Close
+var ErrClosed = errors.New("plugin is closed")
+
+// Client is RAIS's connection to a running external plugin.  If the plugin
+// exits, calls fail until it's been restarted, which happens on the first
+// call at least RestartDelay after it last started.
+type Client struct {
+	Path         string
+	APIVersion   int
+	Capabilities []string
+
+	// Timeout is the longest a call may take before it fails, and the longest
+	// RAIS waits for a newly started plugin to say where to connect
+	Timeout time.Duration
+
+	onExit func(error)
+	spawn  func(pc *conn) (string, error)
+
+	m       sync.Mutex
+	conn    *conn
+	closed  bool
+	started time.Time
+}
+
+// conn is a single run of the plugin
+type conn struct {
+	cmd   *exec.Cmd
+	stdin io.Closer
+	grpc  *grpc.ClientConn
+	rpc   pluginpb.PluginClient
+	dead  chan struct{}
+
+	m        sync.Mutex
+	err      error
+	stopping bool
+	onExit   func(error)
+}
+
+func newConn(onExit func(error)) *conn {
+	return &conn{dead: make(chan struct{}), onExit: onExit}
+}
+
+// addressWriter receives the plugin's stdout.  The first line is sent on
+// addr, and everything after it is passed through to stderr.
+type addressWriter struct {
+	buf  []byte
+	addr chan string
+}
+
+func (w *addressWriter) Write(p []byte) (int, error) {
+	if w.addr == nil {
+		return os.Stderr.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	var i = bytes.IndexByte(w.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	w.addr <- string(w.buf[:i])
+	w.addr = nil
+	os.Stderr.Write(w.buf[i+1:])
+	w.buf = nil
+	return len(p), nil
+}
+
+// start runs cmd and returns the address line the plugin writes to stdout.
+// When the plugin exits, pc dies with the reason.
+func (pc *conn) start(cmd *exec.Cmd, timeout time.Duration) (string, error) {
+	var w = &addressWriter{addr: make(chan string, 1)}
+	var lines = w.addr
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	var stdin, err = cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return "", err
+	}
+
+	pc.cmd, pc.stdin = cmd, stdin
+	go func() {
+		var err = cmd.Wait()
+		if err == nil {
+			err = errors.New("exit status 0")
+		}
+		pc.die(err)
+	}()
+
+	var timer = time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case line := <-lines:
+		return line, nil
+	case <-pc.dead:
+		return "", pc.exitErr()
+	case <-timer.C:
+		return "", fmt.Errorf("plugin didn't report its address within %s", timeout)
+	}
+}
+
+// dial parses the plugin's address line and sets up the gRPC client.  The
+// connection itself is made on the first call.
+func (pc *conn) dial(line string) error {
+	var parts = strings.Split(strings.TrimSpace(line), "|")
+	if len(parts) != 3 || parts[0] != strconv.Itoa(ProtocolVersion) {
+		return fmt.Errorf("plugin wrote %q instead of its address", line)
+	}
+
+	var network, address = parts[1], parts[2]
+	switch network {
+	case "unix":
+	case "tcp":
+		var host, _, err = net.SplitHostPort(address)
+		var ip = net.ParseIP(host)
+		if err != nil || ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("plugin address %q isn't on the loopback interface", address)
+		}
+	default:
+		return fmt.Errorf("plugin network %q isn't supported", network)
+	}
+
+	var dialer = func(ctx context.Context, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+	var cc, err = grpc.NewClient("passthrough:///localhost",
+		grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(dialer))
+	if err != nil {
+		return err
+	}
+	pc.grpc, pc.rpc = cc, pluginpb.NewPluginClient(cc)
+	return nil
+}
+
+// die records why the plugin exited.  Unless RAIS stopped it, onExit is told
+// about it.
+func (c *conn) die(err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.dead)
+	if !c.stopping && c.onExit != nil {
+		go c.onExit(err)
+	}
+}
+
+// exitErr returns a description of why the plugin died, or nil if it
+// hasn't
+func (c *conn) exitErr() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.err == nil {
+		return nil
+	}
+	return fmt.Errorf("plugin exited (%s)", c.err)
+}
+
+// stop closes the connection and the plugin's stdin, which tells the plugin
+// to exit.  If the plugin process hasn't exited within a few seconds, it's
+// killed.
+func (c *conn) stop() error {
+	c.m.Lock()
+	c.stopping = true
+	c.m.Unlock()
+
+	var err error
+	if c.grpc != nil {
+		err = c.grpc.Close()
+	}
+	if c.stdin != nil {
+		c.stdin.Close()
+	}
+	if c.cmd == nil {
+		return err
+	}
+
+	select {
+	case <-c.dead:
+	case <-time.After(5 * time.Second):
+		c.cmd.Process.Kill()
+		<-c.dead
+	}
+	return err
+}
+
+// Start runs the plugin executable at path, connects to it, and performs
+// the handshake.  If the plugin later exits, onExit (if it isn't nil) is
+// called with the reason.
+func Start(path string, onExit func(error)) (*Client, error) {
+	var c = &Client{Path: path, Timeout: 30 * time.Second, onExit: onExit}
+	c.spawn = func(pc *conn) (string, error) { return pc.start(exec.Command(path), c.Timeout) }
+	var err = c.connect()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect starts the plugin and performs the handshake.  Restarted plugins
+// must declare the same version and capabilities they did the first time.
+func (c *Client) connect() error {
+	c.started = time.Now()
+	var pc = newConn(c.onExit)
+	var line, err = c.spawn(pc)
+	if err == nil {
+		err = pc.dial(line)
+	}
+
+	var version int
+	var caps []string
+	if err == nil {
+		version, caps, err = c.handshake(pc)
+	}
+	if err == nil && c.APIVersion != 0 && (version != c.APIVersion || strings.Join(caps, ",") != strings.Join(c.Capabilities, ",")) {
+		err = errors.New("plugin declared different capabilities after restarting")
+	}
+	if err != nil {
+		pc.stop()
+		return err
+	}
+
+	c.APIVersion, c.Capabilities = version, caps
+	c.conn = pc
+	return nil
+}
+
+// handshake asks the plugin for its version and capabilities, and verifies
+// that RAIS supports them
+func (c *Client) handshake(pc *conn) (version int, caps []string, err error) {
+	var reply *pluginpb.HandshakeReply
+	err = c.invoke(context.Background(), pc, "Handshake", func(ctx context.Context, rpc pluginpb.PluginClient) (err error) {
+		reply, err = rpc.Handshake(ctx, &pluginpb.HandshakeRequest{ApiVersion: plugins.APIVersion})
+		return err
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("handshake failed: %s", err)
+	}
+
+	version = int(reply.GetApiVersion())
+	if version < MinAPIVersion || version > plugins.APIVersion {
+		return 0, nil, fmt.Errorf("plugin speaks plugin API version %d, but external plugins must use "+
+			"versions %d through %d", version, MinAPIVersion, plugins.APIVersion)
+	}
+	caps = reply.GetCapabilities()
+	if len(caps) == 0 {
+		return 0, nil, errors.New("plugin declared no capabilities")
+	}
+	for _, name := range caps {
+		if !contains(Capabilities, name) {
+			return 0, nil, fmt.Errorf("capability %q isn't supported for external plugins", name)
+		}
+	}
+	return version, caps, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Has returns true if the plugin declared the given capability
+func (c *Client) Has(capability string) bool {
+	return contains(c.Capabilities, capability)
+}
+
+// Err returns nil if the plugin is running, or why it isn't
+func (c *Client) Err() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.conn.exitErr()
+}
+
+// current returns the plugin's connection, first restarting the plugin if
+// it has exited and RestartDelay has passed since it was last started
+func (c *Client) current() (*conn, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	var err = c.conn.exitErr()
+	if err == nil {
+		return c.conn, nil
+	}
+	if c.spawn == nil || time.Since(c.started) < RestartDelay {
+		return nil, err
+	}
+
+	c.conn.stop()
+	var rerr = c.connect()
+	if rerr != nil {
+		return nil, fmt.Errorf("%s; unable to restart it: %s", err, rerr)
+	}
+	return c.conn, nil
+}
+
+// rpcFunc makes a single call to the plugin
+type rpcFunc func(ctx context.Context, rpc pluginpb.PluginClient) error
+
+// call runs fn on the current connection, giving up when ctx is done or
+// after c.Timeout
+func (c *Client) call(ctx context.Context, method string, fn rpcFunc) error {
+	var pc, err = c.current()
+	if err != nil {
+		return err
+	}
+	return c.invoke(ctx, pc, method, fn)
+}
+
+// invoke runs fn on pc.  A call which fails because the plugin died returns
+// the reason it died, and other gRPC errors are reduced to their message.
+func (c *Client) invoke(ctx context.Context, pc *conn, method string, fn rpcFunc) error {
+	var callCtx, cancel = context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	var err = fn(callCtx, pc.rpc)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var s = status.Convert(err)
+	switch s.Code() {
+	case codes.DeadlineExceeded:
+		return fmt.Errorf("%s timed out after %s", method, c.Timeout)
+	case codes.Unavailable:
+		// If the plugin just exited, its process may not have been reaped yet
+		select {
+		case <-pc.dead:
+			return pc.exitErr()
+		case <-time.After(time.Second):
+		}
+	}
+	return errors.New(s.Message())
+}
+
+// IDToPath asks the plugin for the local path to the given ID's image,
+// translating skipped and forbidden replies into plugins.ErrSkipped and
+// plugins.ErrForbidden
+func (c *Client) IDToPath(id iiif.ID) (string, error) {
+	return c.IDToPathContext(context.Background(), id)
+}
+
+// IDToPathContext is IDToPath, but also sends the plugin the request id
+// stored in ctx
+func (c *Client) IDToPathContext(ctx context.Context, id iiif.ID) (string, error) {
+	var reply *pluginpb.PathReply
+	var req = &pluginpb.IDRequest{Id: string(id), RequestId: requestid.FromContext(ctx)}
+	var err = c.call(ctx, "IDToPath", func(ctx context.Context, rpc pluginpb.PluginClient) (err error) {
+		reply, err = rpc.IDToPath(ctx, req)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if reply.GetSkipped() {
+		return "", plugins.ErrSkipped
+	}
+	if reply.GetForbidden() {
+		return "", plugins.ErrForbidden
+	}
+	if reply.GetPath() == "" {
+		return "", errors.New("plugin returned an empty path")
+	}
+	return reply.GetPath(), nil
+}
+
+// PurgeCaches tells the plugin to purge all its caches
+func (c *Client) PurgeCaches() error {
+	return c.call(context.Background(), "PurgeCaches", func(ctx context.Context, rpc pluginpb.PluginClient) error {
+		var _, err = rpc.PurgeCaches(ctx, &pluginpb.Empty{})
+		return err
+	})
+}
+
+// ExpireCachedImage tells the plugin to remove any cached data for the id
+func (c *Client) ExpireCachedImage(id iiif.ID) error {
+	return c.call(context.Background(), "ExpireCachedImage", func(ctx context.Context, rpc pluginpb.PluginClient) error {
+		var _, err = rpc.ExpireCachedImage(ctx, &pluginpb.IDRequest{Id: string(id)})
+		return err
+	})
+}
+
+// Close shuts down the connection, which tells the plugin to exit.  If the
+// plugin process hasn't exited within a few seconds, it's killed.
+func (c *Client) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.stop()
+}
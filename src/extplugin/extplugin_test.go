@@ -0,0 +1,240 @@
+package extplugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"rais/src/requestid"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+type fakePlugin struct {
+	caps    []string
+	expired []iiif.ID
+	purged  bool
+}
+
+func (f *fakePlugin) Capabilities() []string {
+	return f.caps
+}
+
+func (f *fakePlugin) IDToPath(id iiif.ID) (string, error) {
+	switch id {
+	case "skip":
+		return "", plugins.ErrSkipped
+	case "secret":
+		return "", plugins.ErrForbidden
+	case "broken":
+		return "", errors.New("disk on fire")
+	}
+	return "/var/images/" + string(id), nil
+}
+
+func (f *fakePlugin) PurgeCaches() {
+	f.purged = true
+}
+
+func (f *fakePlugin) ExpireCachedImage(id iiif.ID) {
+	f.expired = append(f.expired, id)
+}
+
+// contextPlugin records the request ids it's given
+type contextPlugin struct {
+	fakePlugin
+	requestIDs []string
+}
+
+// slowPlugin doesn't answer IDToPath until release is closed
+type slowPlugin struct {
+	fakePlugin
+	release chan struct{}
+}
+
+func (s *slowPlugin) IDToPath(id iiif.ID) (string, error) {
+	<-s.release
+	return s.fakePlugin.IDToPath(id)
+}
+
+func (c *contextPlugin) IDToPathContext(ctx context.Context, id iiif.ID) (string, error) {
+	c.requestIDs = append(c.requestIDs, requestid.FromContext(ctx))
+	return c.fakePlugin.IDToPath(id)
+}
+
+// TestMain runs the test binary as a plugin when RAIS_TEST_PLUGIN is set,
+// so tests can start a real plugin process
+func TestMain(m *testing.M) {
+	if os.Getenv("RAIS_TEST_PLUGIN") != "" {
+		Serve(&fakePlugin{caps: []string{plugins.CapIDToPath}})
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// pipeClient returns a client which "starts" p by serving it in-process on
+// a socket in dir.  The client's stdin pipe stops the server when closed, as
+// closing a plugin's stdin would.
+func pipeClient(p Plugin, dir string) *Client {
+	var c = &Client{Path: "fake", Timeout: 30 * time.Second}
+	c.spawn = func(pc *conn) (string, error) {
+		var ln, err = listen(dir)
+		if err != nil {
+			return "", err
+		}
+		var r, w = io.Pipe()
+		go serve(p, ln, r)
+		pc.stdin = w
+		return addressLine(ln.Addr()), nil
+	}
+	return c
+}
+
+// processClient returns a client which starts the test binary as a plugin
+func processClient(onExit func(error)) *Client {
+	var c = &Client{Path: os.Args[0], Timeout: 30 * time.Second, onExit: onExit}
+	c.spawn = func(pc *conn) (string, error) {
+		var cmd = exec.Command(os.Args[0])
+		cmd.Env = append(os.Environ(), "RAIS_TEST_PLUGIN=1")
+		return pc.start(cmd, c.Timeout)
+	}
+	return c
+}
+
+func connect(t *testing.T, p Plugin) (*Client, error) {
+	var c = pipeClient(p, t.TempDir())
+	var err = c.connect()
+	if err == nil {
+		t.Cleanup(func() { c.Close() })
+	}
+	return c, err
+}
+
+func TestClient(t *testing.T) {
+	var p = &fakePlugin{caps: []string{plugins.CapIDToPath, plugins.CapCachePurge}}
+	var c, err = connect(t, p)
+	assert.NilError(err, "handshake", t)
+	assert.Equal(plugins.APIVersion, c.APIVersion, "API version", t)
+	assert.True(c.Has(plugins.CapCachePurge), "CachePurge declared", t)
+
+	var path string
+	path, err = c.IDToPath("foo.jp2")
+	assert.NilError(err, "IDToPath", t)
+	assert.Equal("/var/images/foo.jp2", path, "path", t)
+
+	_, err = c.IDToPath("skip")
+	assert.Equal(plugins.ErrSkipped, err, "skipped", t)
+	_, err = c.IDToPath("secret")
+	assert.Equal(plugins.ErrForbidden, err, "forbidden", t)
+	_, err = c.IDToPath("broken")
+	assert.Equal("disk on fire", err.Error(), "other errors pass through", t)
+
+	assert.NilError(c.PurgeCaches(), "PurgeCaches", t)
+	assert.True(p.purged, "caches purged", t)
+	assert.NilError(c.ExpireCachedImage("foo.jp2"), "ExpireCachedImage", t)
+	assert.Equal(1, len(p.expired), "one image expired", t)
+}
+
+func TestHandshakeUnsupportedCapability(t *testing.T) {
+	var _, err = connect(t, &fakePlugin{caps: []string{plugins.CapIDToPath, plugins.CapTracer}})
+	assert.True(err != nil, "Tracer isn't supported for external plugins", t)
+
+	_, err = connect(t, &fakePlugin{})
+	assert.True(err != nil, "plugins must declare capabilities", t)
+}
+
+func TestClientRequestID(t *testing.T) {
+	var p = &contextPlugin{fakePlugin: fakePlugin{caps: []string{plugins.CapIDToPath}}}
+	var c, err = connect(t, p)
+	assert.NilError(err, "handshake", t)
+
+	var path string
+	path, err = c.IDToPathContext(requestid.NewContext(context.Background(), "abc123"), "foo.jp2")
+	assert.NilError(err, "IDToPathContext", t)
+	assert.Equal("/var/images/foo.jp2", path, "path", t)
+	_, err = c.IDToPath("bar.jp2")
+	assert.NilError(err, "IDToPath", t)
+
+	assert.Equal(2, len(p.requestIDs), "IDToPathContext is preferred", t)
+	assert.Equal("abc123", p.requestIDs[0], "request id is passed to the plugin", t)
+	assert.Equal("", p.requestIDs[1], "no request id", t)
+}
+
+func TestClientContext(t *testing.T) {
+	var p = &slowPlugin{fakePlugin: fakePlugin{caps: []string{plugins.CapIDToPath}}, release: make(chan struct{})}
+	defer close(p.release)
+	var c, err = connect(t, p)
+	assert.NilError(err, "handshake", t)
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.IDToPathContext(ctx, "foo.jp2")
+	assert.Equal(context.DeadlineExceeded, err, "calls give up when the context is done", t)
+}
+
+func TestDial(t *testing.T) {
+	var tests = map[string]bool{
+		"1|unix|/tmp/plugin/plugin.sock": true,
+		"1|tcp|127.0.0.1:1234":           true,
+		"1|tcp|[::1]:1234":               true,
+		"1|tcp|10.0.0.1:1234":            false,
+		"1|tcp|example.com:1234":         false,
+		"1|udp|127.0.0.1:1234":           false,
+		"2|unix|/tmp/plugin/plugin.sock": false,
+		"Starting up...":                 false,
+	}
+	for line, valid := range tests {
+		var pc = newConn(nil)
+		var err = pc.dial(line)
+		if pc.grpc != nil {
+			pc.grpc.Close()
+		}
+		assert.Equal(valid, err == nil, line, t)
+	}
+}
+
+func TestClientRestart(t *testing.T) {
+	var oldDelay = RestartDelay
+	defer func() { RestartDelay = oldDelay }()
+	RestartDelay = time.Hour
+
+	var exits = make(chan error, 2)
+	var c = processClient(func(err error) { exits <- err })
+	assert.NilError(c.connect(), "handshake", t)
+	defer c.Close()
+	assert.NilError(c.Err(), "plugin is healthy", t)
+	var path, err = c.IDToPath("foo.jp2")
+	assert.NilError(err, "IDToPath", t)
+	assert.Equal("/var/images/foo.jp2", path, "plugin process answers calls", t)
+
+	c.conn.cmd.Process.Kill()
+	select {
+	case <-exits:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onExit wasn't called when the plugin exited")
+	}
+	assert.True(c.Err() != nil, "plugin is unhealthy", t)
+	_, err = c.IDToPath("foo.jp2")
+	assert.True(err != nil, "calls fail until the plugin restarts", t)
+
+	RestartDelay = 0
+	path, err = c.IDToPath("foo.jp2")
+	assert.NilError(err, "plugin is restarted", t)
+	assert.Equal("/var/images/foo.jp2", path, "restarted plugin answers calls", t)
+	assert.NilError(c.Err(), "plugin is healthy again", t)
+
+	var cmd = c.conn.cmd
+	c.Close()
+	assert.Equal(ErrClosed, c.Err(), "closed plugin", t)
+	assert.True(cmd.ProcessState != nil && cmd.ProcessState.Success(), "plugin exits cleanly when its stdin closes", t)
+	select {
+	case err = <-exits:
+		t.Fatalf("onExit was called after Close: %s", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
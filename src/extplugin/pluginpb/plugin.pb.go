@@ -0,0 +1,364 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: src/extplugin/pluginpb/plugin.proto
+
+package pluginpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// HandshakeRequest tells the plugin which API version RAIS speaks
+type HandshakeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ApiVersion    int32                  `protobuf:"varint,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HandshakeRequest) Reset() {
+	*x = HandshakeRequest{}
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HandshakeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HandshakeRequest) ProtoMessage() {}
+
+func (x *HandshakeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HandshakeRequest.ProtoReflect.Descriptor instead.
+func (*HandshakeRequest) Descriptor() ([]byte, []int) {
+	return file_src_extplugin_pluginpb_plugin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *HandshakeRequest) GetApiVersion() int32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+// HandshakeReply tells RAIS which API version the plugin speaks and which
+// capabilities it provides
+type HandshakeReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ApiVersion    int32                  `protobuf:"varint,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	Capabilities  []string               `protobuf:"bytes,2,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HandshakeReply) Reset() {
+	*x = HandshakeReply{}
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HandshakeReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HandshakeReply) ProtoMessage() {}
+
+func (x *HandshakeReply) ProtoReflect() protoreflect.Message {
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HandshakeReply.ProtoReflect.Descriptor instead.
+func (*HandshakeReply) Descriptor() ([]byte, []int) {
+	return file_src_extplugin_pluginpb_plugin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *HandshakeReply) GetApiVersion() int32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *HandshakeReply) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+// IDRequest holds the IIIF ID for the IDToPath and ExpireCachedImage calls.
+// request_id is only set for IDToPath, and may be empty; it's the id of the
+// request being served, so the plugin can include it in its log messages.
+type IDRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	RequestId     string                 `protobuf:"bytes,2,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IDRequest) Reset() {
+	*x = IDRequest{}
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IDRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IDRequest) ProtoMessage() {}
+
+func (x *IDRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IDRequest.ProtoReflect.Descriptor instead.
+func (*IDRequest) Descriptor() ([]byte, []int) {
+	return file_src_extplugin_pluginpb_plugin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *IDRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *IDRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+// PathReply is the plugin's response to IDToPath.  skipped tells RAIS the
+// plugin doesn't handle the ID, and forbidden tells RAIS the plugin handles
+// the ID but it mustn't be served.  Otherwise path must be a local file RAIS
+// can read.
+type PathReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Skipped       bool                   `protobuf:"varint,2,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	Forbidden     bool                   `protobuf:"varint,3,opt,name=forbidden,proto3" json:"forbidden,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PathReply) Reset() {
+	*x = PathReply{}
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PathReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PathReply) ProtoMessage() {}
+
+func (x *PathReply) ProtoReflect() protoreflect.Message {
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PathReply.ProtoReflect.Descriptor instead.
+func (*PathReply) Descriptor() ([]byte, []int) {
+	return file_src_extplugin_pluginpb_plugin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PathReply) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *PathReply) GetSkipped() bool {
+	if x != nil {
+		return x.Skipped
+	}
+	return false
+}
+
+func (x *PathReply) GetForbidden() bool {
+	if x != nil {
+		return x.Forbidden
+	}
+	return false
+}
+
+// Empty is the request and reply type for calls which need neither
+type Empty struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Empty) Reset() {
+	*x = Empty{}
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Empty) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Empty) ProtoMessage() {}
+
+func (x *Empty) ProtoReflect() protoreflect.Message {
+	mi := &file_src_extplugin_pluginpb_plugin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
+func (*Empty) Descriptor() ([]byte, []int) {
+	return file_src_extplugin_pluginpb_plugin_proto_rawDescGZIP(), []int{4}
+}
+
+var File_src_extplugin_pluginpb_plugin_proto protoreflect.FileDescriptor
+
+const file_src_extplugin_pluginpb_plugin_proto_rawDesc = "" +
+	"\n" +
+	"#src/extplugin/pluginpb/plugin.proto\x12\vrais.plugin\"3\n" +
+	"\x10HandshakeRequest\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\x05R\n" +
+	"apiVersion\"U\n" +
+	"\x0eHandshakeReply\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\x05R\n" +
+	"apiVersion\x12\"\n" +
+	"\fcapabilities\x18\x02 \x03(\tR\fcapabilities\":\n" +
+	"\tIDRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x02 \x01(\tR\trequestId\"W\n" +
+	"\tPathReply\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x18\n" +
+	"\askipped\x18\x02 \x01(\bR\askipped\x12\x1c\n" +
+	"\tforbidden\x18\x03 \x01(\bR\tforbidden\"\a\n" +
+	"\x05Empty2\x85\x02\n" +
+	"\x06Plugin\x12G\n" +
+	"\tHandshake\x12\x1d.rais.plugin.HandshakeRequest\x1a\x1b.rais.plugin.HandshakeReply\x12:\n" +
+	"\bIDToPath\x12\x16.rais.plugin.IDRequest\x1a\x16.rais.plugin.PathReply\x125\n" +
+	"\vPurgeCaches\x12\x12.rais.plugin.Empty\x1a\x12.rais.plugin.Empty\x12?\n" +
+	"\x11ExpireCachedImage\x12\x16.rais.plugin.IDRequest\x1a\x12.rais.plugin.EmptyB\x1dZ\x1brais/src/extplugin/pluginpbb\x06proto3"
+
+var (
+	file_src_extplugin_pluginpb_plugin_proto_rawDescOnce sync.Once
+	file_src_extplugin_pluginpb_plugin_proto_rawDescData []byte
+)
+
+func file_src_extplugin_pluginpb_plugin_proto_rawDescGZIP() []byte {
+	file_src_extplugin_pluginpb_plugin_proto_rawDescOnce.Do(func() {
+		file_src_extplugin_pluginpb_plugin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_src_extplugin_pluginpb_plugin_proto_rawDesc), len(file_src_extplugin_pluginpb_plugin_proto_rawDesc)))
+	})
+	return file_src_extplugin_pluginpb_plugin_proto_rawDescData
+}
+
+var file_src_extplugin_pluginpb_plugin_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_src_extplugin_pluginpb_plugin_proto_goTypes = []any{
+	(*HandshakeRequest)(nil), // 0: rais.plugin.HandshakeRequest
+	(*HandshakeReply)(nil),   // 1: rais.plugin.HandshakeReply
+	(*IDRequest)(nil),        // 2: rais.plugin.IDRequest
+	(*PathReply)(nil),        // 3: rais.plugin.PathReply
+	(*Empty)(nil),            // 4: rais.plugin.Empty
+}
+var file_src_extplugin_pluginpb_plugin_proto_depIdxs = []int32{
+	0, // 0: rais.plugin.Plugin.Handshake:input_type -> rais.plugin.HandshakeRequest
+	2, // 1: rais.plugin.Plugin.IDToPath:input_type -> rais.plugin.IDRequest
+	4, // 2: rais.plugin.Plugin.PurgeCaches:input_type -> rais.plugin.Empty
+	2, // 3: rais.plugin.Plugin.ExpireCachedImage:input_type -> rais.plugin.IDRequest
+	1, // 4: rais.plugin.Plugin.Handshake:output_type -> rais.plugin.HandshakeReply
+	3, // 5: rais.plugin.Plugin.IDToPath:output_type -> rais.plugin.PathReply
+	4, // 6: rais.plugin.Plugin.PurgeCaches:output_type -> rais.plugin.Empty
+	4, // 7: rais.plugin.Plugin.ExpireCachedImage:output_type -> rais.plugin.Empty
+	4, // [4:8] is the sub-list for method output_type
+	0, // [0:4] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_src_extplugin_pluginpb_plugin_proto_init() }
+func file_src_extplugin_pluginpb_plugin_proto_init() {
+	if File_src_extplugin_pluginpb_plugin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_src_extplugin_pluginpb_plugin_proto_rawDesc), len(file_src_extplugin_pluginpb_plugin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_src_extplugin_pluginpb_plugin_proto_goTypes,
+		DependencyIndexes: file_src_extplugin_pluginpb_plugin_proto_depIdxs,
+		MessageInfos:      file_src_extplugin_pluginpb_plugin_proto_msgTypes,
+	}.Build()
+	File_src_extplugin_pluginpb_plugin_proto = out.File
+	file_src_extplugin_pluginpb_plugin_proto_goTypes = nil
+	file_src_extplugin_pluginpb_plugin_proto_depIdxs = nil
+}
@@ -0,0 +1,147 @@
+package extplugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"rais/src/extplugin/pluginpb"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"rais/src/requestid"
+
+	"google.golang.org/grpc"
+)
+
+// Plugin is implemented by Go external plugins.  A plugin also implements
+// IDToPather and/or CachePurger, and declares the matching capabilities.
+type Plugin interface {
+	Capabilities() []string
+}
+
+// IDToPather is implemented by plugins with the IDToPath capability.  As
+// with native plugins, IDToPath returns plugins.ErrSkipped for IDs it doesn't
+// handle and plugins.ErrForbidden for IDs which mustn't be served.
+type IDToPather interface {
+	IDToPath(id iiif.ID) (string, error)
+}
+
+// ContextIDToPather is implemented by plugins with the IDToPath capability
+// which want the id of the request being served, e.g., to tag log messages.
+// The context carries the id, which requestid.FromContext returns; it's
+// canceled if RAIS stops waiting for the reply.  If a plugin implements both
+// interfaces, IDToPathContext is used.
+type ContextIDToPather interface {
+	IDToPathContext(ctx context.Context, id iiif.ID) (string, error)
+}
+
+// CachePurger is implemented by plugins with the CachePurge capability
+type CachePurger interface {
+	PurgeCaches()
+	ExpireCachedImage(id iiif.ID)
+}
+
+// service adapts a Plugin to the gRPC service
+type service struct {
+	pluginpb.UnimplementedPluginServer
+	p Plugin
+}
+
+// Handshake reports the plugin's API version and capabilities
+func (s *service) Handshake(ctx context.Context, req *pluginpb.HandshakeRequest) (*pluginpb.HandshakeReply, error) {
+	return &pluginpb.HandshakeReply{ApiVersion: plugins.APIVersion, Capabilities: s.p.Capabilities()}, nil
+}
+
+// IDToPath calls the plugin's IDToPathContext or IDToPath, if it has one
+func (s *service) IDToPath(ctx context.Context, req *pluginpb.IDRequest) (*pluginpb.PathReply, error) {
+	var reply = &pluginpb.PathReply{}
+	var path string
+	var err error
+	switch p := s.p.(type) {
+	case ContextIDToPather:
+		path, err = p.IDToPathContext(requestid.NewContext(ctx, req.GetRequestId()), iiif.ID(req.GetId()))
+	case IDToPather:
+		path, err = p.IDToPath(iiif.ID(req.GetId()))
+	default:
+		reply.Skipped = true
+		return reply, nil
+	}
+
+	switch err {
+	case nil:
+		reply.Path = path
+	case plugins.ErrSkipped:
+		reply.Skipped = true
+	case plugins.ErrForbidden:
+		reply.Forbidden = true
+	default:
+		return nil, err
+	}
+	return reply, nil
+}
+
+// PurgeCaches calls the plugin's PurgeCaches, if it has one
+func (s *service) PurgeCaches(ctx context.Context, req *pluginpb.Empty) (*pluginpb.Empty, error) {
+	var p, ok = s.p.(CachePurger)
+	if ok {
+		p.PurgeCaches()
+	}
+	return &pluginpb.Empty{}, nil
+}
+
+// ExpireCachedImage calls the plugin's ExpireCachedImage, if it has one
+func (s *service) ExpireCachedImage(ctx context.Context, req *pluginpb.IDRequest) (*pluginpb.Empty, error) {
+	var p, ok = s.p.(CachePurger)
+	if ok {
+		p.ExpireCachedImage(iiif.ID(req.GetId()))
+	}
+	return &pluginpb.Empty{}, nil
+}
+
+// Serve listens on a unix socket in a new temporary directory, tells RAIS
+// where to connect, and answers RAIS's calls until RAIS closes stdin.
+// Plugins must not write anything to stdout before Serve does.
+func Serve(p Plugin) error {
+	var dir, err = os.MkdirTemp("", "rais-plugin-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var ln net.Listener
+	ln, err = listen(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Println(addressLine(ln.Addr()))
+	return serve(p, ln, os.Stdin)
+}
+
+// listen opens a unix socket in dir, falling back to a loopback TCP port on
+// systems without unix sockets
+func listen(dir string) (net.Listener, error) {
+	var ln, err = net.Listen("unix", filepath.Join(dir, "plugin.sock"))
+	if err != nil {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	return ln, err
+}
+
+// addressLine returns the line telling RAIS where to connect
+func addressLine(addr net.Addr) string {
+	return fmt.Sprintf("%d|%s|%s", ProtocolVersion, addr.Network(), addr.String())
+}
+
+// serve answers calls on ln until stdin is closed, then waits for any calls
+// in progress to finish
+func serve(p Plugin, ln net.Listener, stdin io.Reader) error {
+	var server = grpc.NewServer()
+	pluginpb.RegisterPluginServer(server, &service{p: p})
+	go func() {
+		io.Copy(io.Discard, stdin)
+		server.GracefulStop()
+	}()
+	return server.Serve(ln)
+}
@@ -0,0 +1,168 @@
+// Package geo extracts georeferencing from GeoTIFFs and GeoJP2s so it can be
+// handed to clients as JSON, saving map projects from running GDAL just to
+// find out where an image sits on the globe.
+package geo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// ErrNotGeoreferenced is returned when a file has no georeferencing we can
+// read
+var ErrNotGeoreferenced = errors.New("image has no georeferencing")
+
+// Sources of georeferencing data
+const (
+	SourceGeoTIFF = "GeoTIFF"
+	SourceGeoJP2  = "GeoJP2"
+)
+
+// GeoKey IDs we interpret rather than just passing along
+const (
+	keyRasterType     = 1025
+	keyGeographicType = 2048
+	keyProjectedType  = 3072
+)
+
+// rasterPixelIsPoint is the GTRasterTypeGeoKey value for rasters whose
+// tiepoints refer to pixel centers rather than pixel corners
+const rasterPixelIsPoint = 2
+
+// userDefined is the GeoKey value meaning a CRS isn't a registered EPSG code
+const userDefined = 32767
+
+// GeoKey is a single entry from a GeoTIFF key directory.  Value is an int, a
+// list of floats, or a string, depending on the key.
+type GeoKey struct {
+	ID    int         `json:"id"`
+	Value interface{} `json:"value"`
+}
+
+// Info holds an image's georeferencing.  PixelScale, Tiepoints, and
+// Transformation are the raw GeoTIFF tags, if present; CRS is the EPSG code
+// of the coordinate system, when the keys name one; and Bounds is the
+// image's extent in CRS units, [minX, minY, maxX, maxY], when it can be
+// computed without GDAL's help (i.e., the image isn't warped via multiple
+// control points).
+type Info struct {
+	Source         string      `json:"source"`
+	Width          int         `json:"width,omitempty"`
+	Height         int         `json:"height,omitempty"`
+	CRS            string      `json:"crs,omitempty"`
+	Bounds         []float64   `json:"bounds,omitempty"`
+	PixelScale     []float64   `json:"pixelScale,omitempty"`
+	Tiepoints      [][]float64 `json:"tiepoints,omitempty"`
+	Transformation []float64   `json:"transformation,omitempty"`
+	GeoKeys        []GeoKey    `json:"geoKeys,omitempty"`
+}
+
+// Read returns the georeferencing embedded in the file at path: GeoTIFF tags
+// in a TIFF's first image, or a GeoJP2 UUID box in a JP2.  If the file isn't
+// one of these, or has no georeferencing, ErrNotGeoreferenced is returned.
+func Read(path string) (*Info, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var magic = make([]byte, 12)
+	_, err = io.ReadFull(f, magic)
+	if err != nil {
+		return nil, ErrNotGeoreferenced
+	}
+
+	switch {
+	case isTIFF(magic):
+		return readTIFF(f, SourceGeoTIFF)
+	case bytes.Equal(magic, jp2Signature):
+		return readJP2(f)
+	}
+	return nil, ErrNotGeoreferenced
+}
+
+// Has returns true if the file at path has georeferencing Read can extract
+func Has(path string) bool {
+	var _, err = Read(path)
+	return err == nil
+}
+
+// SetSize records the image's dimensions and computes its bounds.  GeoJP2s
+// embed a dummy 1x1 TIFF, so the real dimensions have to come from the image
+// itself.
+func (i *Info) SetSize(w, h int) {
+	i.Width, i.Height = w, h
+	i.Bounds = nil
+
+	var t, ok = i.affine()
+	if !ok {
+		return
+	}
+
+	// Tiepoints for pixel-is-point rasters refer to pixel centers, so the
+	// image's edges are half a pixel further out
+	var off float64
+	if i.rasterType() == rasterPixelIsPoint {
+		off = -0.5
+	}
+
+	var minX, minY = math.Inf(1), math.Inf(1)
+	var maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, c := range [][2]float64{{0, 0}, {float64(w), 0}, {0, float64(h)}, {float64(w), float64(h)}} {
+		var px, py = c[0] + off, c[1] + off
+		var x = t[0]*px + t[1]*py + t[2]
+		var y = t[3]*px + t[4]*py + t[5]
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	i.Bounds = []float64{minX, minY, maxX, maxY}
+}
+
+// affine returns the raster-to-model transform as [a, b, c, d, e, f], where
+// x = a*px + b*py + c and y = d*px + e*py + f.  ok is false if the
+// georeferencing can't be expressed this way.
+func (i *Info) affine() (t [6]float64, ok bool) {
+	var m = i.Transformation
+	if len(m) == 16 {
+		return [6]float64{m[0], m[1], m[3], m[4], m[5], m[7]}, true
+	}
+
+	if len(i.Tiepoints) != 1 || len(i.PixelScale) < 2 {
+		return t, false
+	}
+	var tp, s = i.Tiepoints[0], i.PixelScale
+	return [6]float64{s[0], 0, tp[3] - tp[0]*s[0], 0, -s[1], tp[4] + tp[1]*s[1]}, true
+}
+
+// key returns the value of the given GeoKey if it's a plain integer
+func (i *Info) key(id int) (int, bool) {
+	for _, k := range i.GeoKeys {
+		if k.ID == id {
+			var v, ok = k.Value.(int)
+			return v, ok
+		}
+	}
+	return 0, false
+}
+
+func (i *Info) rasterType() int {
+	var v, _ = i.key(keyRasterType)
+	return v
+}
+
+// setCRS names the EPSG coordinate system from the projected or geographic
+// type keys, if one is set and isn't user-defined
+func (i *Info) setCRS() {
+	for _, id := range []int{keyProjectedType, keyGeographicType} {
+		var v, ok = i.key(id)
+		if ok && v > 0 && v != userDefined {
+			i.CRS = "EPSG:" + strconv.Itoa(v)
+			return
+		}
+	}
+}
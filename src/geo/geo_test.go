@@ -0,0 +1,156 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+type testTag struct {
+	tag  uint16
+	typ  uint16
+	data interface{}
+}
+
+// buildTIFF returns a little-endian TIFF header and IFD holding just the
+// given tags, with all values stored after the IFD
+func buildTIFF(tags []testTag) []byte {
+	var le = binary.LittleEndian
+	var head, values bytes.Buffer
+	var valueOff = 8 + 2 + 12*len(tags) + 4
+
+	head.WriteString("II*\x00")
+	binary.Write(&head, le, uint32(8))
+	binary.Write(&head, le, uint16(len(tags)))
+	for _, t := range tags {
+		var v bytes.Buffer
+		var count int
+		switch d := t.data.(type) {
+		case []float64:
+			binary.Write(&v, le, d)
+			count = len(d)
+		case []uint16:
+			binary.Write(&v, le, d)
+			count = len(d)
+		case string:
+			v.WriteString(d)
+			count = len(d)
+		}
+
+		binary.Write(&head, le, t.tag)
+		binary.Write(&head, le, t.typ)
+		binary.Write(&head, le, uint32(count))
+		binary.Write(&head, le, uint32(valueOff+values.Len()))
+		values.Write(v.Bytes())
+	}
+	binary.Write(&head, le, uint32(0))
+
+	return append(head.Bytes(), values.Bytes()...)
+}
+
+// utmTIFF is a GeoTIFF in UTM zone 10N with 2-meter pixels
+var utmTIFF = buildTIFF([]testTag{
+	{tagModelPixelScale, typeDouble, []float64{2, 2, 0}},
+	{tagModelTiepoint, typeDouble, []float64{0, 0, 0, 500000, 4900000, 0}},
+	{tagGeoKeyDirectory, typeShort, []uint16{
+		1, 1, 0, 4,
+		1024, 0, 1, 1,
+		1025, 0, 1, 1,
+		3072, 0, 1, 32610,
+		3073, tagGeoASCIIParams, 15, 0,
+	}},
+	{tagGeoASCIIParams, typeASCII, "WGS 84 / UTM10|\x00"},
+})
+
+func writeTemp(t *testing.T, data []byte) string {
+	var dir, err = ioutil.TempDir("", "rais-geo")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	var fname = filepath.Join(dir, "img")
+	err = ioutil.WriteFile(fname, data, 0644)
+	if err != nil {
+		t.Fatalf("Unable to write temp file: %s", err)
+	}
+	return fname
+}
+
+func TestReadGeoTIFF(t *testing.T) {
+	var fname = writeTemp(t, utmTIFF)
+	defer os.RemoveAll(filepath.Dir(fname))
+
+	var i, err = Read(fname)
+	assert.NilError(err, "reading GeoTIFF", t)
+	assert.Equal(SourceGeoTIFF, i.Source, "source", t)
+	assert.Equal("EPSG:32610", i.CRS, "CRS", t)
+	assert.Equal(1, len(i.Tiepoints), "tiepoint count", t)
+	assert.Equal(4, len(i.GeoKeys), "key count", t)
+	assert.Equal("WGS 84 / UTM10", i.GeoKeys[3].Value, "ASCII key value", t)
+
+	i.SetSize(1000, 500)
+	assert.Equal(fmt.Sprint([]float64{500000, 4899000, 502000, 4900000}), fmt.Sprint(i.Bounds), "bounds", t)
+}
+
+func TestReadGeoJP2(t *testing.T) {
+	var jp2 bytes.Buffer
+	jp2.Write(jp2Signature)
+	binary.Write(&jp2, binary.BigEndian, uint32(8+16+len(utmTIFF)))
+	jp2.WriteString("uuid")
+	jp2.Write(geoJP2UUID)
+	jp2.Write(utmTIFF)
+	binary.Write(&jp2, binary.BigEndian, uint32(0))
+	jp2.WriteString("jp2c")
+	jp2.WriteString("codestream goes here")
+
+	var fname = writeTemp(t, jp2.Bytes())
+	defer os.RemoveAll(filepath.Dir(fname))
+
+	var i, err = Read(fname)
+	assert.NilError(err, "reading GeoJP2", t)
+	assert.Equal(SourceGeoJP2, i.Source, "source", t)
+	assert.Equal("EPSG:32610", i.CRS, "CRS", t)
+}
+
+func TestReadNotGeoreferenced(t *testing.T) {
+	var fname = writeTemp(t, buildTIFF([]testTag{{tagGeoASCIIParams, typeASCII, "nothing useful|"}}))
+	defer os.RemoveAll(filepath.Dir(fname))
+	var _, err = Read(fname)
+	assert.Equal(ErrNotGeoreferenced, err, "TIFF without geo tags", t)
+	assert.False(Has(fname), "Has agrees", t)
+
+	ioutil.WriteFile(fname, []byte("this isn't an image at all"), 0644)
+	_, err = Read(fname)
+	assert.Equal(ErrNotGeoreferenced, err, "not an image", t)
+}
+
+func TestBoundsPixelIsPoint(t *testing.T) {
+	var i = &Info{
+		PixelScale: []float64{1, 1, 0},
+		Tiepoints:  [][]float64{{0, 0, 0, 10, 20, 0}},
+		GeoKeys:    []GeoKey{{ID: keyRasterType, Value: rasterPixelIsPoint}},
+	}
+	i.SetSize(4, 2)
+	assert.Equal(fmt.Sprint([]float64{9.5, 18.5, 13.5, 20.5}), fmt.Sprint(i.Bounds), "edges are half a pixel out", t)
+}
+
+func TestBoundsTransformation(t *testing.T) {
+	// A 90-degree rotation: x follows rows and y follows columns
+	var i = &Info{Transformation: []float64{
+		0, 1, 0, 100,
+		1, 0, 0, 200,
+		0, 0, 0, 0,
+		0, 0, 0, 1,
+	}}
+	i.SetSize(10, 20)
+	assert.Equal(fmt.Sprint([]float64{100, 200, 120, 210}), fmt.Sprint(i.Bounds), "bounds", t)
+
+	i = &Info{Tiepoints: [][]float64{{0, 0, 0, 1, 1, 0}, {9, 9, 0, 2, 2, 0}}}
+	i.SetSize(10, 10)
+	assert.True(i.Bounds == nil, "control points can't be turned into bounds", t)
+}
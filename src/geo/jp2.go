@@ -0,0 +1,63 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// jp2Signature is the twelve-byte signature box every JP2 begins with
+var jp2Signature = []byte{0x00, 0x00, 0x00, 0x0c, 'j', 'P', ' ', ' ', 0x0d, 0x0a, 0x87, 0x0a}
+
+// geoJP2UUID identifies the UUID box holding a GeoJP2's degenerate GeoTIFF
+var geoJP2UUID = []byte{
+	0xb1, 0x4b, 0xf8, 0xbd, 0x08, 0x3d, 0x4b, 0x43,
+	0xa5, 0xae, 0x8c, 0xd7, 0xd5, 0xa6, 0xce, 0x03,
+}
+
+// readJP2 walks the JP2's top-level boxes looking for a GeoJP2 UUID box, and
+// reads the GeoTIFF inside it
+func readJP2(r io.ReaderAt) (*Info, error) {
+	var off = int64(len(jp2Signature))
+	var header = make([]byte, 16)
+	for {
+		var _, err = r.ReadAt(header[:8], off)
+		if err != nil {
+			return nil, ErrNotGeoreferenced
+		}
+
+		var length = int64(binary.BigEndian.Uint32(header))
+		var typ = string(header[4:8])
+		var hlen = int64(8)
+		switch length {
+		case 0:
+			// The box runs to the end of the file; that's only ever the codestream
+			// in practice, and nothing we care about can follow it
+			if typ != "uuid" {
+				return nil, ErrNotGeoreferenced
+			}
+			length = maxFieldLen + hlen
+		case 1:
+			_, err = r.ReadAt(header[8:16], off+8)
+			if err != nil {
+				return nil, ErrNotGeoreferenced
+			}
+			length = int64(binary.BigEndian.Uint64(header[8:16]))
+			hlen = 16
+		}
+		if length < hlen {
+			return nil, ErrNotGeoreferenced
+		}
+
+		if typ == "uuid" && length-hlen > int64(len(geoJP2UUID)) && length-hlen <= maxFieldLen {
+			var data = make([]byte, length-hlen)
+			var n, _ = r.ReadAt(data, off+hlen)
+			data = data[:n]
+			if bytes.HasPrefix(data, geoJP2UUID) {
+				return readTIFF(bytes.NewReader(data[len(geoJP2UUID):]), SourceGeoJP2)
+			}
+		}
+
+		off += length
+	}
+}
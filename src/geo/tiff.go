@@ -0,0 +1,214 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+)
+
+// GeoTIFF tags
+const (
+	tagModelPixelScale     = 33550
+	tagModelTiepoint       = 33922
+	tagModelTransformation = 34264
+	tagGeoKeyDirectory     = 34735
+	tagGeoDoubleParams     = 34736
+	tagGeoASCIIParams      = 34737
+)
+
+// TIFF field types we need to read
+const (
+	typeASCII  = 2
+	typeShort  = 3
+	typeDouble = 12
+)
+
+// maxFieldLen caps how many bytes we'll read for a single tag, so a corrupt
+// count can't make us allocate gigabytes
+const maxFieldLen = 1 << 20
+
+var typeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+func isTIFF(magic []byte) bool {
+	return bytes.HasPrefix(magic, []byte("II*\x00")) || bytes.HasPrefix(magic, []byte("MM\x00*"))
+}
+
+// tiffField is the raw data for a single tag
+type tiffField struct {
+	typ  uint16
+	data []byte
+}
+
+// tiffReader reads tags from a TIFF's first IFD
+type tiffReader struct {
+	r      io.ReaderAt
+	order  binary.ByteOrder
+	fields map[uint16]tiffField
+}
+
+// readTIFF returns the georeferencing in the TIFF read from r
+func readTIFF(r io.ReaderAt, source string) (*Info, error) {
+	var t = &tiffReader{r: r, fields: make(map[uint16]tiffField)}
+	var err = t.readIFD()
+	if err != nil {
+		return nil, ErrNotGeoreferenced
+	}
+
+	var i = &Info{
+		Source:         source,
+		PixelScale:     t.doubles(tagModelPixelScale),
+		Transformation: t.doubles(tagModelTransformation),
+		GeoKeys:        t.geoKeys(),
+	}
+	var tp = t.doubles(tagModelTiepoint)
+	for len(tp) >= 6 {
+		i.Tiepoints = append(i.Tiepoints, tp[:6])
+		tp = tp[6:]
+	}
+
+	if len(i.Tiepoints) == 0 && len(i.Transformation) != 16 {
+		return nil, ErrNotGeoreferenced
+	}
+	i.setCRS()
+	return i, nil
+}
+
+// readIFD reads the header and all fields of the first IFD.  BigTIFFs aren't
+// supported.
+func (t *tiffReader) readIFD() error {
+	var header = make([]byte, 8)
+	var _, err = t.r.ReadAt(header, 0)
+	if err != nil {
+		return err
+	}
+
+	t.order = binary.LittleEndian
+	if header[0] == 'M' {
+		t.order = binary.BigEndian
+	}
+	if t.order.Uint16(header[2:]) != 42 {
+		return ErrNotGeoreferenced
+	}
+
+	var off = int64(t.order.Uint32(header[4:]))
+	var countBytes = make([]byte, 2)
+	_, err = t.r.ReadAt(countBytes, off)
+	if err != nil {
+		return err
+	}
+
+	var entries = make([]byte, 12*int(t.order.Uint16(countBytes)))
+	_, err = t.r.ReadAt(entries, off+2)
+	if err != nil {
+		return err
+	}
+
+	for len(entries) >= 12 {
+		var e = entries[:12]
+		entries = entries[12:]
+
+		var tag, typ = t.order.Uint16(e), t.order.Uint16(e[2:])
+		if !isGeoTag(tag) {
+			continue
+		}
+		var size = typeSizes[typ] * t.order.Uint32(e[4:])
+		if size == 0 || size > maxFieldLen {
+			continue
+		}
+
+		var data = make([]byte, size)
+		if size <= 4 {
+			copy(data, e[8:])
+		} else {
+			_, err = t.r.ReadAt(data, int64(t.order.Uint32(e[8:])))
+			if err != nil {
+				return err
+			}
+		}
+		t.fields[tag] = tiffField{typ: typ, data: data}
+	}
+
+	return nil
+}
+
+func isGeoTag(tag uint16) bool {
+	switch tag {
+	case tagModelPixelScale, tagModelTiepoint, tagModelTransformation,
+		tagGeoKeyDirectory, tagGeoDoubleParams, tagGeoASCIIParams:
+		return true
+	}
+	return false
+}
+
+func (t *tiffReader) doubles(tag uint16) []float64 {
+	var f, ok = t.fields[tag]
+	if !ok || f.typ != typeDouble {
+		return nil
+	}
+	var list = make([]float64, len(f.data)/8)
+	for i := range list {
+		list[i] = math.Float64frombits(t.order.Uint64(f.data[i*8:]))
+	}
+	return list
+}
+
+func (t *tiffReader) shorts(tag uint16) []int {
+	var f, ok = t.fields[tag]
+	if !ok || f.typ != typeShort {
+		return nil
+	}
+	var list = make([]int, len(f.data)/2)
+	for i := range list {
+		list[i] = int(t.order.Uint16(f.data[i*2:]))
+	}
+	return list
+}
+
+func (t *tiffReader) ascii(tag uint16) string {
+	var f, ok = t.fields[tag]
+	if !ok || f.typ != typeASCII {
+		return ""
+	}
+	return string(f.data)
+}
+
+// geoKeys decodes the key directory, pulling values out of the double and
+// ASCII params tags as needed
+func (t *tiffReader) geoKeys() []GeoKey {
+	var dir = t.shorts(tagGeoKeyDirectory)
+	if len(dir) < 4 {
+		return nil
+	}
+	var doubles = t.doubles(tagGeoDoubleParams)
+	var ascii = t.ascii(tagGeoASCIIParams)
+
+	var keys []GeoKey
+	var n = dir[3]
+	for i := 0; i < n && len(dir) >= 8+i*4; i++ {
+		var k = dir[4+i*4 : 8+i*4]
+		var id, loc, count, val = k[0], k[1], k[2], k[3]
+		var key = GeoKey{ID: id}
+		switch loc {
+		case 0:
+			key.Value = val
+		case tagGeoDoubleParams:
+			if val+count > len(doubles) {
+				continue
+			}
+			key.Value = doubles[val : val+count]
+		case tagGeoASCIIParams:
+			if val+count > len(ascii) {
+				continue
+			}
+			// ASCII params are separated with pipes, and the last byte of each
+			// value is always a pipe or NUL
+			key.Value = strings.TrimRight(ascii[val:val+count], "|\x00")
+		default:
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
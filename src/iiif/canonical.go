@@ -0,0 +1,81 @@
+package iiif
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// Canonical returns the canonical form of the URL's region, size, rotation,
+// quality, and format for an image of the given dimensions, e.g.,
+// "0,0,512,512/256,/0/default.jpg".  Requests which produce the same image
+// get the same canonical form, so sizes of "pct:50" and "500," for a full
+// region of a 1000-pixel-wide image are both "500,".
+//
+// Following the IIIF 2.1 rules, regions covering the whole image are "full"
+// and all others are pixel coordinates; sizes are "full" when the region
+// isn't scaled, "w," when the aspect ratio is kept, and "w,h" otherwise; and
+// "native" is "default".  "max" is left alone, since only the server knows
+// how big that is.
+func (u *URL) Canonical(w, h int) string {
+	var full = image.Rect(0, 0, w, h)
+	var crop = u.Region.GetCrop(w, h).Intersect(full)
+
+	var region = "full"
+	if crop != full {
+		region = fmt.Sprintf("%d,%d,%d,%d", crop.Min.X, crop.Min.Y, crop.Dx(), crop.Dy())
+	}
+
+	var q = u.Quality
+	if q == QNative {
+		q = QDefault
+	}
+
+	return strings.Join([]string{
+		region,
+		canonicalSize(u.Size, crop),
+		canonicalRotation(u.Rotation),
+		string(q) + "." + string(u.Format),
+	}, "/")
+}
+
+// IsCanonical returns true if the URL's path already ends in its canonical
+// form for an image of the given dimensions
+func (u *URL) IsCanonical(w, h int) bool {
+	return strings.HasSuffix(u.Path, "/"+u.Canonical(w, h))
+}
+
+func canonicalSize(s Size, crop image.Rectangle) string {
+	if s.Type == STMax {
+		if s.Upscale {
+			return "^max"
+		}
+		return "max"
+	}
+
+	var r = s.GetResize(crop)
+	if r.Dx() == crop.Dx() && r.Dy() == crop.Dy() {
+		return "full"
+	}
+
+	var prefix string
+	if s.Upscale && s.Upscales(crop) {
+		prefix = "^"
+	}
+
+	// A width alone is only canonical if it scales to the same height
+	var byWidth = Size{Type: STScaleToWidth, W: r.Dx()}.GetResize(crop)
+	if byWidth.Dy() == r.Dy() {
+		return fmt.Sprintf("%s%d,", prefix, r.Dx())
+	}
+	return fmt.Sprintf("%s%d,%d", prefix, r.Dx(), r.Dy())
+}
+
+func canonicalRotation(r Rotation) string {
+	var s = strconv.FormatFloat(r.Degrees, 'f', -1, 64)
+	if r.Mirror {
+		return "!" + s
+	}
+	return s
+}
@@ -0,0 +1,34 @@
+package iiif
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestCanonical(t *testing.T) {
+	var tests = map[string]string{
+		"full/full/0/default.jpg":            "full/full/0/default.jpg",
+		"full/max/0/default.jpg":             "full/max/0/default.jpg",
+		"full/pct:50/0/default.jpg":          "full/500,/0/default.jpg",
+		"full/,400/0/default.jpg":            "full/500,/0/default.jpg",
+		"full/500,400/0/default.jpg":         "full/500,/0/default.jpg",
+		"full/500,500/0/default.jpg":         "full/500,500/0/default.jpg",
+		"full/!500,500/0/default.jpg":        "full/500,/0/default.jpg",
+		"full/1000,/0/default.jpg":           "full/full/0/default.jpg",
+		"full/^2000,/0/default.jpg":          "full/^2000,/0/default.jpg",
+		"0,0,1000,800/full/0/native.png":     "full/full/0/default.png",
+		"pct:0,0,50,50/full/0/gray.jpg":      "0,0,500,400/full/0/gray.jpg",
+		"square/256,256/0/default.jpg":       "100,0,800,800/256,/0/default.jpg",
+		"900,700,500,500/full/0/default.jpg": "900,700,100,100/full/0/default.jpg",
+		"full/full/90.0/default.jpg":         "full/full/90/default.jpg",
+		"full/full/!180/default.jpg":         "full/full/!180/default.jpg",
+	}
+
+	for path, expected := range tests {
+		var u, err = NewURL("id/" + path)
+		assert.NilError(err, path, t)
+		assert.Equal(expected, u.Canonical(1000, 800), path, t)
+		assert.Equal(path == expected, u.IsCanonical(1000, 800), path+" is canonical", t)
+	}
+}
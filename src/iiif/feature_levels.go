@@ -1,5 +1,7 @@
 package iiif
 
+import "fmt"
+
 // FeatureSet0 returns a copy of the feature set required for a
 // level-0-compliant IIIF server
 func FeatureSet0() *FeatureSet {
@@ -88,3 +90,19 @@ func AllFeatures() *FeatureSet {
 		JsonldMediaType: true,
 	}
 }
+
+// FeatureSetForLevel returns a copy of the feature set for a compliance
+// level: "0", "1", "2", or "max" for everything RAIS supports
+func FeatureSetForLevel(level string) (*FeatureSet, error) {
+	switch level {
+	case "0":
+		return FeatureSet0(), nil
+	case "1":
+		return FeatureSet1(), nil
+	case "2":
+		return FeatureSet2(), nil
+	case "max":
+		return AllFeatures(), nil
+	}
+	return nil, fmt.Errorf("unknown compliance level %q (must be 0, 1, 2, or max)", level)
+}
@@ -35,8 +35,14 @@ func (fs *FeatureSet) SupportsRegion(r Region) bool {
 	}
 }
 
-// SupportsSize just verifies a given size type is supported
+// SupportsSize just verifies a given size type is supported.  Sizes which
+// explicitly ask for upscaling, via "^" or a percent above 100, also require
+// SizeAboveFull.
 func (fs *FeatureSet) SupportsSize(s Size) bool {
+	if (s.Upscale || s.Type == STScalePercent && s.Percent > 100) && !fs.SizeAboveFull {
+		return false
+	}
+
 	switch s.Type {
 	case STScaleToWidth:
 		return fs.SizeByW
@@ -104,6 +110,6 @@ func (fs *FeatureSet) SupportsFormat(f Format) bool {
 	case FmtWEBP:
 		return fs.Webp
 	default:
-		return false
+		return fs.hasExtraFormat(f)
 	}
 }
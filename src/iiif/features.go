@@ -1,5 +1,7 @@
 package iiif
 
+import "fmt"
+
 // FeaturesMap is a simple map for boolean features, used for comparing
 // featuresets and reporting features beyond the reported level
 type FeaturesMap map[string]bool
@@ -64,48 +66,106 @@ type FeatureSet struct {
 	ProfileLinkHeader   bool
 	CanonicalLinkHeader bool
 
+	// ExtraFormats lists supported formats which IIIF doesn't define, such as
+	// those provided by encoder plugins
+	ExtraFormats []Format
+
 	// Non-boolean feature support
 	TileSizes []TileSize
 }
 
+// AddFormat marks the given format as supported
+func (fs *FeatureSet) AddFormat(f Format) {
+	switch f {
+	case FmtJPG:
+		fs.Jpg = true
+	case FmtTIF:
+		fs.Tif = true
+	case FmtPNG:
+		fs.Png = true
+	case FmtGIF:
+		fs.Gif = true
+	case FmtJP2:
+		fs.Jp2 = true
+	case FmtPDF:
+		fs.Pdf = true
+	case FmtWEBP:
+		fs.Webp = true
+	default:
+		if !fs.hasExtraFormat(f) {
+			fs.ExtraFormats = append(fs.ExtraFormats, f)
+		}
+	}
+}
+
+func (fs *FeatureSet) hasExtraFormat(f Format) bool {
+	for _, extra := range fs.ExtraFormats {
+		if extra == f {
+			return true
+		}
+	}
+	return false
+}
+
+// flags returns a pointer to each of the FeatureSet's boolean support
+// values, keyed by the name IIIF uses for the feature
+func (fs *FeatureSet) flags() map[string]*bool {
+	return map[string]*bool{
+		"regionByPx":          &fs.RegionByPx,
+		"regionByPct":         &fs.RegionByPct,
+		"regionSquare":        &fs.RegionSquare,
+		"sizeByWhListed":      &fs.SizeByWhListed,
+		"sizeByW":             &fs.SizeByW,
+		"sizeByH":             &fs.SizeByH,
+		"sizeByPct":           &fs.SizeByPct,
+		"sizeByForcedWh":      &fs.SizeByForcedWh,
+		"sizeByWh":            &fs.SizeByWh,
+		"sizeByConfinedWh":    &fs.SizeByConfinedWh,
+		"sizeByDistortedWh":   &fs.SizeByDistortedWh,
+		"sizeAboveFull":       &fs.SizeAboveFull,
+		"rotationBy90s":       &fs.RotationBy90s,
+		"rotationArbitrary":   &fs.RotationArbitrary,
+		"mirroring":           &fs.Mirroring,
+		"default":             &fs.Default,
+		"color":               &fs.Color,
+		"gray":                &fs.Gray,
+		"bitonal":             &fs.Bitonal,
+		"jpg":                 &fs.Jpg,
+		"png":                 &fs.Png,
+		"tif":                 &fs.Tif,
+		"gif":                 &fs.Gif,
+		"jp2":                 &fs.Jp2,
+		"pdf":                 &fs.Pdf,
+		"webp":                &fs.Webp,
+		"baseUriRedirect":     &fs.BaseURIRedirect,
+		"cors":                &fs.Cors,
+		"jsonldMediaType":     &fs.JsonldMediaType,
+		"profileLinkHeader":   &fs.ProfileLinkHeader,
+		"canonicalLinkHeader": &fs.CanonicalLinkHeader,
+	}
+}
+
 // toMap converts a FeatureSet's boolean support values into a map suitable for
 // use in comparison to other feature sets.  The strings used are lowercased so
 // they can be used as-is within "formats", "qualities", and/or "supports"
 // arrays.
 func (fs *FeatureSet) toMap() FeaturesMap {
-	return FeaturesMap{
-		"regionByPx":          fs.RegionByPx,
-		"regionByPct":         fs.RegionByPct,
-		"regionSquare":        fs.RegionSquare,
-		"sizeByWhListed":      fs.SizeByWhListed,
-		"sizeByW":             fs.SizeByW,
-		"sizeByH":             fs.SizeByH,
-		"sizeByPct":           fs.SizeByPct,
-		"sizeByForcedWh":      fs.SizeByForcedWh,
-		"sizeByWh":            fs.SizeByWh,
-		"sizeByConfinedWh":    fs.SizeByConfinedWh,
-		"sizeByDistortedWh":   fs.SizeByDistortedWh,
-		"sizeAboveFull":       fs.SizeAboveFull,
-		"rotationBy90s":       fs.RotationBy90s,
-		"rotationArbitrary":   fs.RotationArbitrary,
-		"mirroring":           fs.Mirroring,
-		"default":             fs.Default,
-		"color":               fs.Color,
-		"gray":                fs.Gray,
-		"bitonal":             fs.Bitonal,
-		"jpg":                 fs.Jpg,
-		"png":                 fs.Png,
-		"tif":                 fs.Tif,
-		"gif":                 fs.Gif,
-		"jp2":                 fs.Jp2,
-		"pdf":                 fs.Pdf,
-		"webp":                fs.Webp,
-		"baseUriRedirect":     fs.BaseURIRedirect,
-		"cors":                fs.Cors,
-		"jsonldMediaType":     fs.JsonldMediaType,
-		"profileLinkHeader":   fs.ProfileLinkHeader,
-		"canonicalLinkHeader": fs.CanonicalLinkHeader,
+	var m = make(FeaturesMap)
+	for name, flag := range fs.flags() {
+		m[name] = *flag
+	}
+	return m
+}
+
+// Set turns the named feature on or off.  Names are as IIIF reports them,
+// e.g., "mirroring" or "sizeAboveFull"; an unknown name is an error.
+func (fs *FeatureSet) Set(name string, supported bool) error {
+	var flag, ok = fs.flags()[name]
+	if !ok {
+		return fmt.Errorf("unknown feature %q", name)
 	}
+	*flag = supported
+	return nil
 }
 
 // FeatureCompare returns which features are in common between two FeatureSets,
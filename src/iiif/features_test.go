@@ -152,3 +152,36 @@ func TestInclusion(t *testing.T) {
 	assert.False(FeaturesLevel0.includes(FeaturesLevel1), "FeaturesLevel0.includes(FeaturesLevel1)", t)
 	assert.True(FeaturesLevel0.includes(FeaturesLevel0), "FeaturesLevel0.includes(FeaturesLevel0)", t)
 }
+
+func TestSupportsSizeAboveFull(t *testing.T) {
+	s := StringToSize("pct:150")
+	assert.False(FeaturesLevel2.SupportsSize(s), "pct above 100 requires sizeAboveFull", t)
+	assert.True(AllFeatures().SupportsSize(s), "pct above 100 works with sizeAboveFull", t)
+	s = StringToSize("^300,")
+	assert.False(FeaturesLevel2.SupportsSize(s), "^ requires sizeAboveFull", t)
+	assert.True(AllFeatures().SupportsSize(s), "^ works with sizeAboveFull", t)
+	assert.True(FeaturesLevel2.SupportsSize(StringToSize("pct:100")), "pct:100 isn't upscaling", t)
+}
+
+func TestSetFeature(t *testing.T) {
+	var fs = AllFeatures()
+	assert.NilError(fs.Set("mirroring", false), "mirroring is a known feature", t)
+	assert.False(fs.SupportsRotation(StringToRotation("!90")), "mirroring can be turned off", t)
+	assert.True(fs.Set("mirrors", false) != nil, "unknown features are an error", t)
+
+	fs = FeatureSet1()
+	assert.NilError(fs.Set("regionByPct", true), "regionByPct is a known feature", t)
+	assert.True(fs.SupportsRegion(StringToRegion("pct:10,10,50,50")), "features can be added to a level", t)
+	assert.Equal("http://iiif.io/api/image/2/level1.json", fs.Profile().ConformanceURL, "the level doesn't change", t)
+}
+
+func TestFeatureSetForLevel(t *testing.T) {
+	var fs, err = FeatureSetForLevel("1")
+	assert.NilError(err, "level 1", t)
+	assert.True(fs.includes(FeaturesLevel1) && FeaturesLevel1.includes(fs), "level 1 is level 1", t)
+	fs, err = FeatureSetForLevel("max")
+	assert.NilError(err, "max", t)
+	assert.True(fs.Mirroring, "max includes everything RAIS does", t)
+	_, err = FeatureSetForLevel("3")
+	assert.True(err != nil, "unknown levels are an error", t)
+}
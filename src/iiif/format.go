@@ -18,6 +18,16 @@
 // Formats is the definitive list of all possible Format constants
 var Formats = []Format{FmtJPG, FmtTIF, FmtPNG, FmtGIF, FmtJP2, FmtPDF, FmtWEBP}
 
+// RegisterFormat adds a format beyond those IIIF defines, such as "jxl", so
+// URLs requesting it are considered valid.  This isn't safe to call once URLs
+// are being parsed.
+func RegisterFormat(f Format) {
+	if f == FmtUnknown || f.Valid() {
+		return
+	}
+	Formats = append(Formats, f)
+}
+
 func StringToFormat(val string) Format {
 	f := Format(val)
 	if f.Valid() {
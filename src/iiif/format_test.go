@@ -12,3 +12,9 @@ func TestFormatValidity(t *testing.T) {
 		assert.True(Format(f).Valid(), f+" is a valid format", t)
 	}
 }
+
+func TestRegisterFormat(t *testing.T) {
+	assert.False(Format("heic").Valid(), "heic isn't valid before it's registered", t)
+	RegisterFormat("heic")
+	assert.Equal(Format("heic"), StringToFormat("heic"), "heic is valid once registered", t)
+}
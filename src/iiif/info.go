@@ -65,6 +65,13 @@ func (p *ProfileWrapper) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// InfoSize is an entry in info.json's "sizes" list: dimensions a server can
+// deliver efficiently, such as an image's internal resolution levels
+type InfoSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
 // Info represents the simplest possible data to provide a valid IIIF
 // information JSON response
 type Info struct {
@@ -73,8 +80,18 @@ type Info struct {
 	Protocol string         `json:"protocol"`
 	Width    int            `json:"width"`
 	Height   int            `json:"height"`
+	Sizes    []InfoSize     `json:"sizes,omitempty"`
 	Tiles    []TileSize     `json:"tiles,omitempty"`
 	Profile  ProfileWrapper `json:"profile"`
+
+	// Optional descriptive and service properties.  RAIS doesn't set these on
+	// its own, but they may come from an info override file, a plugin, or an
+	// info extensions file.  Logo may be a URL or an object with a service.
+	Attribution string        `json:"attribution,omitempty"`
+	License     string        `json:"license,omitempty"`
+	Logo        interface{}   `json:"logo,omitempty"`
+	SeeAlso     []interface{} `json:"seeAlso,omitempty"`
+	Service     []interface{} `json:"service,omitempty"`
 }
 
 // NewInfo returns the static *Info data that's the same for any info response
@@ -122,6 +139,15 @@ func (fs *FeatureSet) Profile() ProfileWrapper {
 		p.profileElement2 = extraProfileFromFeaturesMap(extraFeatures)
 	}
 
+	// Formats IIIF doesn't define are never part of a level, so they're always
+	// listed when supported
+	if len(fs.ExtraFormats) > 0 {
+		for _, f := range fs.ExtraFormats {
+			p.Formats = append(p.Formats, string(f))
+		}
+		sort.Strings(p.Formats)
+	}
+
 	return p
 }
 
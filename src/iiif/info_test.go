@@ -69,3 +69,19 @@ func TestAllFeaturesEnabled(t *testing.T) {
 	assert.IncludesString("mirroring", extra.Supports, "Custom FS support", t)
 	assert.IncludesString("tif", extra.Formats, "Custom FS support", t)
 }
+
+func TestExtraFormatsProfile(t *testing.T) {
+	RegisterFormat("jxl")
+	fs := FeatureSet1()
+	fs.AddFormat("jxl")
+	fs.AddFormat(FmtPNG)
+	i := fs.Info()
+	assert.Equal("http://iiif.io/api/image/2/level1.json", i.Profile.ConformanceURL, "Extra formats don't change the level", t)
+
+	extra := i.Profile.profileElement2
+	assert.Equal(2, len(extra.Formats), "There are 2 extra formats", t)
+	assert.IncludesString("jxl", extra.Formats, "Plugin format is listed", t)
+	assert.IncludesString("png", extra.Formats, "IIIF format is listed", t)
+	assert.True(fs.SupportsFormat("jxl"), "jxl is supported", t)
+	assert.False(FeatureSet1().SupportsFormat("jxl"), "jxl isn't supported by default", t)
+}
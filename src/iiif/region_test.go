@@ -1,6 +1,7 @@
 package iiif
 
 import (
+	"image"
 	"testing"
 
 	"github.com/uoregon-libraries/gopkg/assert"
@@ -43,3 +44,10 @@ func TestRegionTypeSquare(t *testing.T) {
 	r := StringToRegion("square")
 	assert.True(r.Type == RTSquare, "r.Type == RTSquare", t)
 }
+
+func TestRegionSquareCrop(t *testing.T) {
+	r := StringToRegion("square")
+	assert.Equal(image.Rect(0, 25, 101, 126), r.GetCrop(101, 151), "tall images are centered vertically", t)
+	assert.Equal(image.Rect(25, 0, 126, 101), r.GetCrop(151, 101), "wide images are centered horizontally", t)
+	assert.Equal(image.Rect(0, 0, 64, 64), r.GetCrop(64, 64), "square images are untouched", t)
+}
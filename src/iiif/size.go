@@ -35,11 +35,14 @@
 )
 
 // Size represents the type of scaling as well as the parameters for scaling
-// for a IIIF 2.0 server
+// for a IIIF 2.0 server.  Upscale is set when the size used IIIF 3's "^"
+// modifier, explicitly asking for an image which may be larger than the
+// region it's taken from.
 type Size struct {
 	Type    SizeType
 	Percent float64
 	W, H    int
+	Upscale bool
 }
 
 // StringToSize creates a Size from a string as seen in a IIIF URL.
@@ -48,6 +51,16 @@ func StringToSize(p string) Size {
 		return Size{}
 	}
 
+	// IIIF 3 has no "full" size, so "^full" isn't meaningful
+	if p[0] == '^' {
+		var s = StringToSize(p[1:])
+		if s.Type == STFull {
+			return Size{Type: STNone}
+		}
+		s.Upscale = true
+		return s
+	}
+
 	if p == "full" {
 		return Size{Type: STFull}
 	}
@@ -125,13 +138,35 @@ func (s Size) GetResize(region image.Rectangle) image.Rectangle {
 	case STBestFit:
 		w, h = s.getBestFit(w, h)
 	case STScalePercent:
-		w = int(float64(w) * s.Percent / 100.0)
-		h = int(float64(h) * s.Percent / 100.0)
+		w = scalePercent(w, s.Percent)
+		h = scalePercent(h, s.Percent)
 	}
 
 	return image.Rect(0, 0, w, h)
 }
 
+// scalePercent returns n scaled by pct percent, rounded to the nearest pixel,
+// but never less than one pixel so tiny percentages of small regions don't
+// produce an empty image
+func scalePercent(n int, pct float64) int {
+	var v = int(math.Round(float64(n) * pct / 100.0))
+	if v < 1 {
+		return 1
+	}
+	return v
+}
+
+// Upscales returns true if the size would make the given region larger in
+// either dimension.  STMax is always false, as only the server knows how big
+// "max" is.
+func (s Size) Upscales(region image.Rectangle) bool {
+	if s.Type == STMax {
+		return false
+	}
+	var r = s.GetResize(region)
+	return r.Dx() > region.Dx() || r.Dy() > region.Dy()
+}
+
 // getBestFit preserves the aspect ratio while determining the proper scaling
 // factor to get width and height adjusted to fit within the width and height
 // of the desired size operation
@@ -108,3 +108,39 @@ func TestGetResize(t *testing.T) {
 	assert.Equal(scale.Dx(), 50, "scale-to-pct Dx", t)
 	assert.Equal(scale.Dy(), 100, "scale-to-pct Dy", t)
 }
+
+func TestSizeUpscale(t *testing.T) {
+	s := StringToSize("^pct:150")
+	assert.True(s.Valid(), "^pct:150 is valid", t)
+	assert.True(s.Upscale, "^ sets Upscale", t)
+	assert.Equal(STScalePercent, s.Type, "^pct: is a percent", t)
+
+	s = StringToSize("^max")
+	assert.True(s.Upscale && s.Type == STMax, "^max", t)
+
+	s = StringToSize("^!200,100")
+	assert.True(s.Upscale && s.Type == STBestFit, "^!w,h", t)
+	assert.Equal(200, s.W, "^!w,h width", t)
+
+	s = StringToSize("^full")
+	assert.False(s.Valid(), "^full isn't a IIIF size", t)
+	s = StringToSize("^")
+	assert.False(s.Valid(), "^ alone isn't a IIIF size", t)
+
+	s = StringToSize("120,")
+	assert.False(s.Upscale, "no ^, no Upscale", t)
+	var region = image.Rect(0, 0, 100, 50)
+	assert.True(s.Upscales(region), "120, upscales a 100px region", t)
+	assert.False(StringToSize("100,").Upscales(region), "100, doesn't", t)
+	assert.False(StringToSize("max").Upscales(region), "max never does", t)
+}
+
+func TestGetResizePercentRounding(t *testing.T) {
+	var region = image.Rect(0, 0, 3, 7)
+	var scale = StringToSize("pct:50").GetResize(region)
+	assert.Equal(image.Rect(0, 0, 2, 4), scale, "percents round to the nearest pixel", t)
+	scale = StringToSize("pct:0.1").GetResize(region)
+	assert.Equal(image.Rect(0, 0, 1, 1), scale, "percents never produce an empty image", t)
+	scale = StringToSize("pct:250").GetResize(region)
+	assert.Equal(image.Rect(0, 0, 8, 18), scale, "percents above 100 upscale", t)
+}
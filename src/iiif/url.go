@@ -1,8 +1,11 @@
 package iiif
 
 import (
+	"crypto/sha1"
 	"errors"
+	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -21,6 +24,37 @@ func (id ID) Escaped() string {
 	return url.QueryEscape(string(id))
 }
 
+// SurrogateKey returns a key identifying everything derived from this ID,
+// for use in CDN surrogate keys and cache tags.  IDs can contain spaces and
+// other characters those can't, so the key is a hash of the ID.
+func (id ID) SurrogateKey() string {
+	return fmt.Sprintf("rais-id-%x", sha1.Sum([]byte(id)))
+}
+
+// SplitPage separates a page suffix, such as the ";3" in "reel.tif;3", from
+// the ID.  It returns the ID of the file itself and the zero-based page
+// number.  ok is false, and the ID is returned unchanged, if sep is empty or
+// the ID doesn't end in sep followed by a page number.
+func (id ID) SplitPage(sep string) (base ID, page int, ok bool) {
+	if sep == "" {
+		return id, 0, false
+	}
+	var s = string(id)
+	var i = strings.LastIndex(s, sep)
+	if i < 1 {
+		return id, 0, false
+	}
+	var num = s[i+len(sep):]
+	if num == "" || strings.Trim(num, "0123456789") != "" {
+		return id, 0, false
+	}
+	var n, err = strconv.Atoi(num)
+	if err != nil {
+		return id, 0, false
+	}
+	return ID(s[:i]), n, true
+}
+
 // URL represents the different options composed into a IIIF URL request
 type URL struct {
 	Path            string
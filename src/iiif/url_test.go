@@ -57,3 +57,20 @@ func TestInfoBaseRedirect(t *testing.T) {
 	assert.Equal("empty id, invalid region, invalid size, invalid quality", err.Error(), "base redirects are error cases the caller must handle", t)
 	assert.Equal("", string(i.ID), "identifier", t)
 }
+
+func TestSplitPage(t *testing.T) {
+	var base, page, ok = ID("reels/042.tif;3").SplitPage(";")
+	assert.True(ok, "page suffix is found", t)
+	assert.Equal(ID("reels/042.tif"), base, "base ID", t)
+	assert.Equal(3, page, "page", t)
+
+	for _, id := range []ID{"042.tif", "042.tif;", "042.tif;x3", "042.tif;-1", ";3"} {
+		base, page, ok = id.SplitPage(";")
+		assert.False(ok, fmt.Sprintf("%q has no page", id), t)
+		assert.Equal(id, base, fmt.Sprintf("%q is unchanged", id), t)
+		assert.Equal(0, page, fmt.Sprintf("%q is page 0", id), t)
+	}
+
+	_, _, ok = ID("042.tif;3").SplitPage("")
+	assert.False(ok, "an empty separator disables pages", t)
+}
@@ -0,0 +1,58 @@
+package img
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+)
+
+// opaqueFormats are the output formats which can't store transparency
+var opaqueFormats = map[iiif.Format]bool{
+	iiif.FmtJPG: true,
+	iiif.FmtPDF: true,
+}
+
+// ParseColor reads a hex RGB color, with or without a leading "#", in
+// either six-digit ("ffffff") or three-digit ("fff") form
+func ParseColor(val string) (color.Color, error) {
+	var hex = strings.TrimPrefix(val, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q (must be RGB hex, e.g., \"ffffff\")", val)
+	}
+	var n, err = strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q (must be RGB hex, e.g., \"ffffff\")", val)
+	}
+	return color.RGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 255}, nil
+}
+
+// needsFlattening returns true if transparency has to be removed before an
+// image can be written as u asks: either the format can't store it, or the
+// quality conversion would discard it
+func needsFlattening(u *iiif.URL) bool {
+	return opaqueFormats[u.Format] || u.Quality == iiif.QGray || u.Quality == iiif.QBitonal
+}
+
+// flatten draws img over a solid background of color bg, or black if bg is
+// nil.  Images which are already opaque are returned as-is.
+func flatten(img image.Image, bg color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	if bg == nil {
+		bg = color.Black
+	}
+
+	var b = img.Bounds()
+	var dst = image.NewRGBA(b)
+	draw.Draw(dst, b, image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,50 @@
+package img
+
+import (
+	"image"
+	"image/color"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestParseColor(t *testing.T) {
+	var c, err = ParseColor("#ffcc00")
+	assert.NilError(err, "six digits with #", t)
+	assert.Equal(color.RGBA{255, 204, 0, 255}, c, "six digits with #", t)
+
+	c, err = ParseColor("fc0")
+	assert.NilError(err, "three digits", t)
+	assert.Equal(color.RGBA{255, 204, 0, 255}, c, "three digits", t)
+
+	for _, val := range []string{"", "white", "#12345", "gggggg"} {
+		_, err = ParseColor(val)
+		assert.True(err != nil, "invalid color "+val, t)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	var src = image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{255, 0, 0, 128})
+
+	var out = flatten(src, color.White).(*image.RGBA)
+	assert.Equal(color.RGBA{255, 0, 0, 255}, out.RGBAAt(0, 0), "opaque pixels are unchanged", t)
+	assert.Equal(color.RGBA{255, 127, 127, 255}, out.RGBAAt(1, 0), "translucent pixels are blended", t)
+
+	out = flatten(src, nil).(*image.RGBA)
+	assert.Equal(color.RGBA{128, 0, 0, 255}, out.RGBAAt(1, 0), "the default background is black", t)
+
+	var gray = image.NewGray(image.Rect(0, 0, 2, 2))
+	assert.Equal(image.Image(gray), flatten(gray, color.White), "opaque images are returned as-is", t)
+}
+
+func TestNeedsFlattening(t *testing.T) {
+	var u, _ = iiif.NewURL("identifier/full/full/0/default.png")
+	assert.False(needsFlattening(u), "PNGs can be transparent", t)
+	u, _ = iiif.NewURL("identifier/full/full/0/default.jpg")
+	assert.True(needsFlattening(u), "JPEGs can't be transparent", t)
+	u, _ = iiif.NewURL("identifier/full/full/0/gray.png")
+	assert.True(needsFlattening(u), "gray conversion drops transparency", t)
+}
@@ -0,0 +1,48 @@
+package img
+
+import (
+	"image"
+	"time"
+)
+
+// DecodeBudget caps how long a single decode should take.  Decode time is
+// estimated from the number of pixels the decoder has to produce at the
+// resolution level it would naturally use; when that's over the limit, a
+// lower-resolution level is decoded instead and scaled up to the requested
+// size.  A zero Limit or Rate disables the budget.
+type DecodeBudget struct {
+	Limit time.Duration
+	Rate  float64 // Decoded pixels per second
+}
+
+// ReductionSetter is implemented by decoders which can decode a lower
+// resolution level than a request needs, such as JP2, and scale the result
+// back up
+type ReductionSetter interface {
+	SetReduction(level int)
+}
+
+// estimate returns how long decoding the crop at the given resolution level
+// should take
+func (b DecodeBudget) estimate(crop image.Rectangle, level int) time.Duration {
+	var w, h = crop.Dx() >> uint(level), crop.Dy() >> uint(level)
+	return time.Duration(float64(w) * float64(h) / b.Rate * float64(time.Second))
+}
+
+// levels returns the resolution level a decoder would pick to scale crop to
+// scale, and the level needed to stay within the budget, neither going past
+// the image's lowest resolution level
+func (b DecodeBudget) levels(crop, scale image.Rectangle, max int) (natural, level int) {
+	for natural < max && crop.Dx()>>uint(natural+1) >= scale.Dx() && crop.Dy()>>uint(natural+1) >= scale.Dy() {
+		natural++
+	}
+
+	level = natural
+	if b.Limit <= 0 || b.Rate <= 0 {
+		return natural, level
+	}
+	for level < max && b.estimate(crop, level) > b.Limit {
+		level++
+	}
+	return natural, level
+}
@@ -1,7 +1,9 @@
 package img
 
 import (
+	"context"
 	"image"
+	"rais/src/transform"
 )
 
 // Decoder defines an interface for reading images in a generic way.  It's
@@ -18,6 +20,33 @@ type Decoder interface {
 	SetResizeWH(int, int)
 }
 
+// FilterSetter is implemented by decoders which scale images in Go, and can
+// therefore use any of the transform package's resampling filters
+type FilterSetter interface {
+	SetFilter(transform.Filter)
+}
+
+// ContextSetter is implemented by decoders which can abandon a decode when
+// the request it's for is canceled or times out
+type ContextSetter interface {
+	SetContext(context.Context)
+}
+
+// LayerSetter is implemented by decoders for formats which store quality
+// layers, such as JP2.  Each layer refines the image, so decoding only the
+// first n is faster but blurrier.  Zero decodes every layer.
+type LayerSetter interface {
+	SetLayers(int)
+}
+
+// PagedDecoder is implemented by decoders for files which can hold more than
+// one image, such as multi-page TIFFs.  Until SetPage is called, the decoder
+// works with the first page.
+type PagedDecoder interface {
+	PageCount() int
+	SetPage(int) error
+}
+
 // DecodeFn is a function which takes a file path and returns a Decoder and
 // optionally an error.  If the error is ErrNotHandled, the decode function is
 // stating that the filetype (or some other data inferred from the id) can't be
@@ -32,7 +61,8 @@ type Decoder interface {
 
 // RegisterDecoder adds a decoder to the internal list of registered decoders.
 // Images we want to decode will be run through each DecodeFn until one returns
-// a Decoder and nil error.
+// a Decoder and nil error, unless a named decoder is mapped to the image's
+// extension (see RegisterNamedDecoder).
 func RegisterDecoder(fn DecodeFn) {
 	fns = append(fns, fn)
 }
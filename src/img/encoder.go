@@ -0,0 +1,48 @@
+package img
+
+import (
+	"image"
+	"io"
+	"rais/src/iiif"
+)
+
+// Encoder writes images in a single output format.  ContentType is sent to
+// clients; if it's empty, the type is guessed from the format's extension.
+type Encoder struct {
+	Format      iiif.Format
+	ContentType string
+	Encode      func(io.Writer, image.Image) error
+}
+
+// encoders holds registered encoders, keyed by format
+var encoders = make(map[iiif.Format]Encoder)
+
+// encoderOrder preserves registration order for Encoders()
+var encoderOrder []iiif.Format
+
+// RegisterEncoder adds an encoder for its format, replacing any encoder
+// previously registered for the same format.  The format is registered with
+// the iiif package so URLs requesting it are considered valid.  This isn't
+// safe to call once the server is handling requests.
+func RegisterEncoder(e Encoder) {
+	if _, ok := encoders[e.Format]; !ok {
+		encoderOrder = append(encoderOrder, e.Format)
+	}
+	encoders[e.Format] = e
+	iiif.RegisterFormat(e.Format)
+}
+
+// EncoderFor returns the registered encoder for the given format, if any
+func EncoderFor(f iiif.Format) (Encoder, bool) {
+	var e, ok = encoders[f]
+	return e, ok
+}
+
+// Encoders returns all registered encoders in the order they were registered
+func Encoders() []Encoder {
+	var list []Encoder
+	for _, f := range encoderOrder {
+		list = append(list, encoders[f])
+	}
+	return list
+}
@@ -13,4 +13,6 @@ func (re imgError) Error() string {
 	ErrInvalidFiletype        imgError = "invalid or unknown file type"
 	ErrDimensionsExceedLimits imgError = "requested image size exceeds server maximums"
 	ErrNotHandled             imgError = "image not handled by this decoder"
+	ErrRegionOutOfBounds      imgError = "requested region is outside the image"
+	ErrUpscaleNotAllowed      imgError = "requested size is larger than the region; use the \"^\" modifier to upscale"
 )
@@ -0,0 +1,138 @@
+package img
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NamedDecoder is a decode function with a name configuration can refer to.
+// Unlike functions registered via RegisterDecoder, a named decoder isn't
+// asked whether it handles a file: it's chosen by the file's extension, or,
+// failing that, by the file's leading bytes.  Extensions and Magic are the
+// defaults, and extensions can be remapped with MapExtension.
+type NamedDecoder struct {
+	Name       string
+	Extensions []string
+	Magic      [][]byte
+	Decode     DecodeFn
+}
+
+// sniffLen is the number of bytes read from a file to check its signature
+const sniffLen = 32
+
+var formatMutex sync.RWMutex
+
+// named holds registered named decoders, keyed by name
+var named = make(map[string]*NamedDecoder)
+
+// namedOrder preserves registration order so signature checks are predictable
+var namedOrder []*NamedDecoder
+
+// extensions maps lowercased file extensions, including the leading dot, to
+// decoder names
+var extensions = make(map[string]string)
+
+// RegisterNamedDecoder adds a named decoder and maps its default extensions
+// to it.  Registering a name a second time replaces the earlier decoder.
+func RegisterNamedDecoder(nd NamedDecoder) {
+	formatMutex.Lock()
+	defer formatMutex.Unlock()
+
+	if named[nd.Name] == nil {
+		namedOrder = append(namedOrder, &nd)
+	} else {
+		for i, existing := range namedOrder {
+			if existing.Name == nd.Name {
+				namedOrder[i] = &nd
+			}
+		}
+	}
+	named[nd.Name] = &nd
+	for _, ext := range nd.Extensions {
+		extensions[normalizeExt(ext)] = nd.Name
+	}
+}
+
+// MapExtension sets the named decoder for files with the given extension.
+// An empty name removes the mapping, so files with that extension are only
+// decoded if a decoder's signature matches them.
+func MapExtension(ext, name string) error {
+	formatMutex.Lock()
+	defer formatMutex.Unlock()
+
+	ext = normalizeExt(ext)
+	if ext == "." {
+		return fmt.Errorf("empty extension")
+	}
+	if name == "" {
+		delete(extensions, ext)
+		return nil
+	}
+	if named[name] == nil {
+		return fmt.Errorf("unknown decoder %q for extension %q (known decoders: %s)", name, ext, decoderNames())
+	}
+	extensions[ext] = name
+	return nil
+}
+
+// decoderNames returns a sorted, comma-separated list of registered names
+func decoderNames() string {
+	var names []string
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// normalizeExt lowercases ext and makes sure it has a leading dot
+func normalizeExt(ext string) string {
+	return "." + strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+// decoderForExt returns the named decoder mapped to path's extension, if any
+func decoderForExt(path string) *NamedDecoder {
+	formatMutex.RLock()
+	defer formatMutex.RUnlock()
+
+	var ext = filepath.Ext(path)
+	if ext == "" {
+		return nil
+	}
+	return named[extensions[normalizeExt(ext)]]
+}
+
+// sniffDecoder returns the first named decoder with a signature matching the
+// start of the file at path
+func sniffDecoder(path string) (*NamedDecoder, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var head = make([]byte, sniffLen)
+	var n int
+	n, err = io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	formatMutex.RLock()
+	defer formatMutex.RUnlock()
+	for _, nd := range namedOrder {
+		for _, magic := range nd.Magic {
+			if bytes.HasPrefix(head, magic) {
+				return nd, nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,58 @@
+package img
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestNamedDecoders(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-formats")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var decoded string
+	var fake = func(name string) DecodeFn {
+		return func(path string) (Decoder, error) {
+			decoded = name
+			return &fakeDecoder{}, nil
+		}
+	}
+	RegisterNamedDecoder(NamedDecoder{Name: "a", Extensions: []string{".aaa"}, Magic: [][]byte{[]byte("AAA!")}, Decode: fake("a")})
+	RegisterNamedDecoder(NamedDecoder{Name: "b", Extensions: []string{"bbb"}, Decode: fake("b")})
+
+	var write = func(name, data string) string {
+		var fname = filepath.Join(dir, name)
+		assert.NilError(ioutil.WriteFile(fname, []byte(data), 0644), "writing "+name, t)
+		return fname
+	}
+
+	var _, e = NewResource("x", write("one.AAA", "whatever"))
+	assert.NilError(e, "decoding by extension", t)
+	assert.Equal("a", decoded, "extensions are case-insensitive", t)
+
+	_, e = NewResource("x", write("two.bbb", "AAA!"))
+	assert.NilError(e, "decoding by extension", t)
+	assert.Equal("b", decoded, "extension wins over signature", t)
+
+	_, e = NewResource("x", write("three", "AAA! and more"))
+	assert.NilError(e, "decoding by signature", t)
+	assert.Equal("a", decoded, "extensionless file is sniffed", t)
+
+	_, e = NewResource("x", write("four", "nope"))
+	assert.Equal(ErrInvalidFiletype, e, "unrecognized file", t)
+
+	assert.NilError(MapExtension(".aaa", "b"), "remapping extension", t)
+	_, e = NewResource("x", write("five.aaa", "whatever"))
+	assert.NilError(e, "decoding remapped extension", t)
+	assert.Equal("b", decoded, "remapped extension uses new decoder", t)
+
+	assert.NilError(MapExtension("bbb", ""), "unmapping extension", t)
+	_, e = NewResource("x", write("six.bbb", "nope"))
+	assert.Equal(ErrInvalidFiletype, e, "unmapped extension", t)
+
+	assert.True(MapExtension(".ccc", "c") != nil, "unknown decoders can't be mapped", t)
+}
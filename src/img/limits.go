@@ -0,0 +1,65 @@
+package img
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourceLimits caps the size of images RAIS will read at all.  Unlike a
+// Constraint, which limits what's sent to clients, these protect the server
+// from masters so large that decoding them could exhaust memory.  Zero values
+// are unlimited.
+type SourceLimits struct {
+	Width    int   `json:"width,omitempty"`
+	Height   int   `json:"height,omitempty"`
+	Area     int64 `json:"area,omitempty"`
+	FileSize int64 `json:"file_size,omitempty"`
+}
+
+// SourceMax is checked by NewResource: the file size before anything is
+// read, and the dimensions as soon as the image's headers have been read
+var SourceMax SourceLimits
+
+// SourceTooLargeError is returned when an image exceeds SourceMax.  Width and
+// Height are zero if the file was rejected before its headers were read.
+type SourceTooLargeError struct {
+	Width    int          `json:"width,omitempty"`
+	Height   int          `json:"height,omitempty"`
+	FileSize int64        `json:"file_size"`
+	Limits   SourceLimits `json:"limits"`
+	Problems []string     `json:"problems"`
+}
+
+func (e *SourceTooLargeError) Error() string {
+	return "source image exceeds server limits: " + strings.Join(e.Problems, "; ")
+}
+
+// checkFile returns a *SourceTooLargeError if size is over the limit
+func (l SourceLimits) checkFile(size int64) error {
+	if l.FileSize > 0 && size > l.FileSize {
+		return &SourceTooLargeError{FileSize: size, Limits: l, Problems: []string{
+			fmt.Sprintf("file size %d bytes exceeds %d", size, l.FileSize),
+		}}
+	}
+	return nil
+}
+
+// checkDimensions returns a *SourceTooLargeError describing every dimension
+// which is over its limit
+func (l SourceLimits) checkDimensions(w, h int, size int64) error {
+	var problems []string
+	if l.Width > 0 && w > l.Width {
+		problems = append(problems, fmt.Sprintf("width %d exceeds %d", w, l.Width))
+	}
+	if l.Height > 0 && h > l.Height {
+		problems = append(problems, fmt.Sprintf("height %d exceeds %d", h, l.Height))
+	}
+	if area := int64(w) * int64(h); l.Area > 0 && area > l.Area {
+		problems = append(problems, fmt.Sprintf("area %d pixels exceeds %d", area, l.Area))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &SourceTooLargeError{Width: w, Height: h, FileSize: size, Limits: l, Problems: problems}
+}
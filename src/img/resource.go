@@ -1,7 +1,8 @@
 package img
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
@@ -11,32 +12,192 @@
 	"rais/src/transform"
 )
 
-// Resource wraps a decoder, IIIF ID, and the path to the image
+// UpscaleMode says how a request for an image larger than its region is
+// handled when the size doesn't use IIIF 3's "^" modifier
+type UpscaleMode int
+
+// All upscale modes
+const (
+	// UpscaleAllow scales as requested, as IIIF 2 servers supporting
+	// sizeAboveFull do
+	UpscaleAllow UpscaleMode = iota
+	// UpscaleClamp shrinks the size to fit within the region, preserving the
+	// requested aspect ratio
+	UpscaleClamp
+	// UpscaleReject fails the request with ErrUpscaleNotAllowed
+	UpscaleReject
+)
+
+// ParseUpscaleMode returns the mode named "allow", "clamp", or "reject"
+func ParseUpscaleMode(name string) (UpscaleMode, error) {
+	switch name {
+	case "allow", "":
+		return UpscaleAllow, nil
+	case "clamp":
+		return UpscaleClamp, nil
+	case "reject":
+		return UpscaleReject, nil
+	}
+	return UpscaleAllow, fmt.Errorf("unknown upscale mode %q (must be allow, clamp, or reject)", name)
+}
+
+// LayerPolicy decides how many quality layers are decoded for a request, for
+// decoders which implement LayerSetter.  The zero value decodes all layers.
+type LayerPolicy struct {
+	// Layers is the number of layers to decode; zero means all of them
+	Layers int
+
+	// MaxSize limits the policy to requests whose output is no larger than
+	// this in either dimension, so thumbnails can be fast without degrading
+	// larger views.  Zero applies the policy to every request.
+	MaxSize int
+}
+
+// layersFor returns the number of layers to decode for an image of the given
+// output size, or zero if all layers should be decoded
+func (p LayerPolicy) layersFor(w, h int) int {
+	if p.MaxSize > 0 && (w > p.MaxSize || h > p.MaxSize) {
+		return 0
+	}
+	return p.Layers
+}
+
+// Resource wraps a decoder, IIIF ID, and the path to the image.  Filter is
+// the resampling filter used for scaling, if the decoder supports it; when
+// empty, transform.DefaultFilter is used.  Sharpen is applied to images which
+// were scaled down, and does nothing unless enabled.  Upscale governs sizes
+// larger than their region, and Layers limits the quality layers decoded.
+// Adjust changes the decoded image's tones before it's rotated or converted
+// to gray or bitonal.  Background is the color transparent images are
+// flattened onto when the output can't be transparent; nil means black.
 type Resource struct {
-	Decoder  Decoder
-	ID       iiif.ID
-	FilePath string
+	Decoder    Decoder
+	ID         iiif.ID
+	FilePath   string
+	Filter     transform.Filter
+	Sharpen    transform.UnsharpMask
+	Upscale    UpscaleMode
+	Layers     LayerPolicy
+	Adjust     transform.Adjustment
+	Background color.Color
+	Budget     DecodeBudget
+
+	// Reduced is set by Apply to the number of resolution levels below what
+	// the request needed that were decoded to stay within Budget
+	Reduced int
+
+	ctx context.Context
+}
+
+// PageSeparator sets off the page suffix of IDs referring to a single page of
+// a multi-page file, e.g., "reel.tif;2" is the reel's third page.  An empty
+// separator disables page-aware IDs.
+var PageSeparator = ";"
+
+// SplitPage returns the ID of the file id refers to, and the zero-based page
+// requested, if any
+func SplitPage(id iiif.ID) (base iiif.ID, page int, ok bool) {
+	return id.SplitPage(PageSeparator)
 }
 
 // NewResource initializes and returns an Resource for the given id
 // and path.  If the path doesn't resolve to a valid file, or resolves to a
-// file type that isn't supported, an error is returned.  File type is
-// determined by extension, so images will need standard extensions in order to
-// work.
+// file type that isn't supported, an error is returned.  If id has a page
+// suffix, that page is selected, and ErrDoesNotExist is returned if the file
+// has no such page.  Files or images larger than SourceMax return a
+// *SourceTooLargeError.  File type is determined by extension when a named
+// decoder is mapped to it; otherwise each unnamed decoder is given a chance,
+// and finally the file's leading bytes are checked against the named
+// decoders' signatures.
 func NewResource(id iiif.ID, filepath string) (*Resource, error) {
-	var err error
+	return NewResourceContext(context.Background(), id, filepath)
+}
+
+// NewResourceContext is NewResource, but ties the resource to ctx: once ctx
+// is done, reading or decoding the image stops as soon as it can, and ctx's
+// error is returned.  Decoders which implement ContextSetter can stop partway
+// through a decode; others are only checked before and after decoding.
+func NewResourceContext(ctx context.Context, id iiif.ID, filepath string) (*Resource, error) {
+	var err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
 
-	// First, does the file exist?
-	if _, err = os.Stat(filepath); err != nil {
+	// First, does the file exist, and is it small enough to bother with?
+	var info os.FileInfo
+	if info, err = os.Stat(filepath); err != nil {
 		return nil, ErrDoesNotExist
 	}
+	err = SourceMax.checkFile(info.Size())
+	if err != nil {
+		return nil, err
+	}
 
 	// File exists - is a decoder registered for it?
 	var d Decoder
+	if Opener != nil {
+		d, err = Opener(ctx, id, filepath)
+	} else {
+		d, err = OpenDecoder(id, filepath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = SourceMax.checkDimensions(d.GetWidth(), d.GetHeight(), info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if cs, ok := d.(ContextSetter); ok {
+		cs.SetContext(ctx)
+	}
+
+	img := &Resource{ID: id, Decoder: d, FilePath: filepath, ctx: ctx}
+	return img, nil
+}
+
+// Opener, when set, replaces OpenDecoder for every resource.  RAIS uses it to
+// hand decoding to worker processes, which call OpenDecoder themselves.
+var Opener func(ctx context.Context, id iiif.ID, path string) (Decoder, error)
+
+// OpenDecoder returns the registered decoder for the file at path, with the
+// page id asks for selected.  ErrInvalidFiletype is returned if no decoder
+// handles the file.
+func OpenDecoder(id iiif.ID, path string) (Decoder, error) {
+	var d, err = decode(path)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrInvalidFiletype
+	}
+
+	err = selectPage(d, id)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// canceled returns the error from the resource's context, if it's done
+func (res *Resource) canceled() error {
+	if res.ctx == nil {
+		return nil
+	}
+	return res.ctx.Err()
+}
+
+// decode finds the decoder for the file at path and runs it, returning a nil
+// Decoder if nothing handles the file
+func decode(path string) (Decoder, error) {
+	var nd = decoderForExt(path)
+	if nd != nil {
+		return nd.Decode(path)
+	}
+
 	for _, decodeFn := range fns {
-		d, err = decodeFn(filepath)
+		var d, err = decodeFn(path)
 		if err == nil && d != nil {
-			break
+			return d, nil
 		}
 		if err == ErrNotHandled {
 			continue
@@ -44,27 +205,59 @@ func NewResource(id iiif.ID, filepath string) (*Resource, error) {
 		return nil, err
 	}
 
-	if d == nil {
-		return nil, ErrInvalidFiletype
+	var err error
+	nd, err = sniffDecoder(path)
+	if err != nil || nd == nil {
+		return nil, err
 	}
+	return nd.Decode(path)
+}
 
-	img := &Resource{ID: id, Decoder: d, FilePath: filepath}
-	return img, nil
+// selectPage points d at the page id requests.  Decoders which don't support
+// pages only have a page 0.  IDs without a page suffix get the first page
+// explicitly, so a multi-page file is never handed to the transforms as a
+// whole, where what gets decoded would be up to the decoder.
+func selectPage(d Decoder, id iiif.ID) error {
+	var _, page, ok = SplitPage(id)
+	var pd, paged = d.(PagedDecoder)
+	if !ok {
+		if paged && pd.PageCount() > 1 {
+			return pd.SetPage(0)
+		}
+		return nil
+	}
+
+	if !paged {
+		if page == 0 {
+			return nil
+		}
+		return ErrDoesNotExist
+	}
+	if page >= pd.PageCount() {
+		return ErrDoesNotExist
+	}
+	return pd.SetPage(page)
 }
 
 // getResizeWithConstraints returns a scaled rectangle, computing the best fit
-// for the given dimensions combined with our local constraints
-func getResizeWithConstraints(crop image.Rectangle, max Constraint) image.Rectangle {
+// for the given dimensions combined with our local constraints.  Unless
+// upscale is true ("^max"), the result is never larger than crop.
+func getResizeWithConstraints(crop image.Rectangle, max Constraint, upscale bool) image.Rectangle {
 	// First figure out the ideal width and height within our max width and height
 	cx := crop.Dx()
 	cy := crop.Dy()
 
-	// Sanity - we don't actually want any upscaling
-	if max.Width > cx {
-		max.Width = cx
-	}
-	if max.Height > cy {
-		max.Height = cy
+	// Sanity - we don't actually want any upscaling unless it was explicitly
+	// requested, and even then, a server with no maximums has no "max" beyond
+	// the full region
+	var unlimited = max.Width == math.MaxInt32 && max.Height == math.MaxInt32 && max.Area == math.MaxInt64
+	if !upscale || unlimited {
+		if max.Width > cx {
+			max.Width = cx
+		}
+		if max.Height > cy {
+			max.Height = cy
+		}
 	}
 
 	s := iiif.Size{Type: iiif.STBestFit, W: max.Width, H: max.Height}
@@ -89,16 +282,28 @@ func getResizeWithConstraints(crop image.Rectangle, max Constraint) image.Rectan
 // returns an image.Image ready for encoding to the client
 func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
 	// Crop and resize have to be prepared before we can decode
+	// Regions extending past the image are cropped to it, per the IIIF spec
 	w, h := res.Decoder.GetWidth(), res.Decoder.GetHeight()
-	crop := u.Region.GetCrop(w, h)
+	crop := u.Region.GetCrop(w, h).Intersect(image.Rect(0, 0, w, h))
+	if crop.Empty() {
+		return nil, ErrRegionOutOfBounds
+	}
 
 	// If size is "max", we actually want the "best fit" size type, but with our
 	// constraints used instead of a user-supplied value.
 	var scale image.Rectangle
 	if u.Size.Type == iiif.STMax {
-		scale = getResizeWithConstraints(crop, max)
+		scale = getResizeWithConstraints(crop, max, u.Size.Upscale)
 	} else {
 		scale = u.Size.GetResize(crop)
+		if !u.Size.Upscale && u.Size.Upscales(crop) {
+			switch res.Upscale {
+			case UpscaleClamp:
+				scale = fitWithin(scale, crop)
+			case UpscaleReject:
+				return nil, ErrUpscaleNotAllowed
+			}
+		}
 	}
 
 	// Determine the final image output dimensions to test size constraints
@@ -112,11 +317,39 @@ func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
 
 	res.Decoder.SetCrop(crop)
 	res.Decoder.SetResizeWH(scale.Dx(), scale.Dy())
+	if fs, ok := res.Decoder.(FilterSetter); ok {
+		fs.SetFilter(res.Filter)
+	}
+	if ls, ok := res.Decoder.(LayerSetter); ok {
+		ls.SetLayers(res.Layers.layersFor(scale.Dx(), scale.Dy()))
+	}
+	res.Reduced = 0
+	if rs, ok := res.Decoder.(ReductionSetter); ok {
+		var natural, level = res.Budget.levels(crop, scale, res.Decoder.GetLevels())
+		if level > natural {
+			rs.SetReduction(level)
+			res.Reduced = level - natural
+		}
+	}
 
+	if err := res.canceled(); err != nil {
+		return nil, err
+	}
 	img, err := res.Decoder.DecodeImage()
+	if cerr := res.canceled(); cerr != nil {
+		return nil, cerr
+	}
 	if err != nil {
-		return nil, errors.New("unable to decode image: " + err.Error())
+		return nil, fmt.Errorf("unable to decode image: %w", err)
+	}
+
+	if scale.Dx() < crop.Dx() || scale.Dy() < crop.Dy() {
+		img = res.Sharpen.Apply(img)
+	}
+	if needsFlattening(u) {
+		img = flatten(img, res.Background)
 	}
+	img = res.Adjust.Apply(img)
 
 	if u.Rotation.Mirror || u.Rotation.Degrees != 0 {
 		img = rotate(img, u.Rotation)
@@ -135,6 +368,24 @@ func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
 	return img, nil
 }
 
+// fitWithin shrinks scale, preserving its aspect ratio, so it's no larger
+// than region in either dimension
+func fitWithin(scale, region image.Rectangle) image.Rectangle {
+	var sw, sh = float64(scale.Dx()), float64(scale.Dy())
+	var f = math.Min(float64(region.Dx())/sw, float64(region.Dy())/sh)
+	if f >= 1 {
+		return scale
+	}
+	var w, h = int(math.Round(sw * f)), int(math.Round(sh * f))
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return image.Rect(0, 0, w, h)
+}
+
 func rotate(img image.Image, rot iiif.Rotation) image.Image {
 	var r transform.Rotator
 	switch img0 := img.(type) {
@@ -142,6 +393,11 @@ func rotate(img image.Image, rot iiif.Rotation) image.Image {
 		r = &transform.GrayRotator{Img: img0}
 	case *image.RGBA:
 		r = &transform.RGBARotator{Img: img0}
+	default:
+		var b = img.Bounds()
+		var rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+		r = &transform.RGBARotator{Img: rgba}
 	}
 
 	if rot.Mirror {
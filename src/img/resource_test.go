@@ -1,10 +1,12 @@
 package img
 
 import (
+	"context"
 	"image"
 	"math"
 	"rais/src/iiif"
 	"testing"
+	"time"
 
 	"github.com/uoregon-libraries/gopkg/assert"
 )
@@ -23,14 +25,17 @@ type fakeDecoder struct {
 	resizeH int
 }
 
-func (d *fakeDecoder) DecodeImage() (image.Image, error) { return nil, nil }
-func (d *fakeDecoder) GetWidth() int                     { return d.w }
-func (d *fakeDecoder) GetHeight() int                    { return d.h }
-func (d *fakeDecoder) GetTileWidth() int                 { return d.tw }
-func (d *fakeDecoder) GetTileHeight() int                { return d.th }
-func (d *fakeDecoder) GetLevels() int                    { return d.l }
-func (d *fakeDecoder) SetCrop(rect image.Rectangle)      { d.crop = rect }
-func (d *fakeDecoder) SetResizeWH(w, h int)              { d.resizeW, d.resizeH = w, h }
+func (d *fakeDecoder) GetWidth() int                { return d.w }
+func (d *fakeDecoder) GetHeight() int               { return d.h }
+func (d *fakeDecoder) GetTileWidth() int            { return d.tw }
+func (d *fakeDecoder) GetTileHeight() int           { return d.th }
+func (d *fakeDecoder) GetLevels() int               { return d.l }
+func (d *fakeDecoder) SetCrop(rect image.Rectangle) { d.crop = rect }
+func (d *fakeDecoder) SetResizeWH(w, h int)         { d.resizeW, d.resizeH = w, h }
+
+func (d *fakeDecoder) DecodeImage() (image.Image, error) {
+	return image.NewGray(image.Rect(0, 0, d.resizeW, d.resizeH)), nil
+}
 
 func TestSquareRegionTall(t *testing.T) {
 	var d = &fakeDecoder{w: 400, h: 950, tw: 64, th: 64, l: 1}
@@ -115,3 +120,209 @@ func TestMaxSizeConstrainArea(t *testing.T) {
 	assert.Equal(500, d.resizeW, "resize width", t)
 	assert.Equal(75, d.resizeH, "resize height", t)
 }
+
+func TestMaxSizeUpscale(t *testing.T) {
+	var d = &fakeDecoder{w: 400, h: 100}
+	var img = &Resource{Decoder: d}
+	var url, _ = iiif.NewURL("identifier/full/^max/0/default.jpg")
+	var c = unlimited
+	c.Width = 1000
+	var _, err = img.Apply(url, c)
+	assert.NilError(err, "img.Apply should not have errors", t)
+	assert.Equal(1000, d.resizeW, "^max upscales to the max width", t)
+	assert.Equal(250, d.resizeH, "^max preserves aspect ratio", t)
+
+	_, err = img.Apply(url, unlimited)
+	assert.NilError(err, "img.Apply should not have errors", t)
+	assert.Equal(400, d.resizeW, "^max without maximums is the full size", t)
+}
+
+func TestUpscaleModes(t *testing.T) {
+	var d = &fakeDecoder{w: 400, h: 100}
+	var res = &Resource{Decoder: d}
+	var url, _ = iiif.NewURL("identifier/full/800,100/0/default.jpg")
+
+	var _, err = res.Apply(url, unlimited)
+	assert.NilError(err, "allow mode", t)
+	assert.Equal(800, d.resizeW, "allow mode scales as requested", t)
+
+	res.Upscale = UpscaleClamp
+	_, err = res.Apply(url, unlimited)
+	assert.NilError(err, "clamp mode", t)
+	assert.Equal(400, d.resizeW, "clamp mode fits the region's width", t)
+	assert.Equal(50, d.resizeH, "clamp mode preserves the requested aspect ratio", t)
+
+	res.Upscale = UpscaleReject
+	_, err = res.Apply(url, unlimited)
+	assert.Equal(ErrUpscaleNotAllowed, err, "reject mode", t)
+
+	url, _ = iiif.NewURL("identifier/full/^800,100/0/default.jpg")
+	_, err = res.Apply(url, unlimited)
+	assert.NilError(err, "^ is always allowed", t)
+	assert.Equal(800, d.resizeW, "^ scales as requested", t)
+}
+
+func TestRegionOutOfBounds(t *testing.T) {
+	var d = &fakeDecoder{w: 400, h: 100}
+	var res = &Resource{Decoder: d}
+	var url, _ = iiif.NewURL("identifier/300,50,500,500/full/0/default.jpg")
+	var _, err = res.Apply(url, unlimited)
+	assert.NilError(err, "partially outside", t)
+	assert.Equal(image.Rect(300, 50, 400, 100), d.crop, "region is cropped to the image", t)
+	assert.Equal(100, d.resizeW, "full size of the cropped region", t)
+
+	url, _ = iiif.NewURL("identifier/400,0,10,10/full/0/default.jpg")
+	_, err = res.Apply(url, unlimited)
+	assert.Equal(ErrRegionOutOfBounds, err, "entirely outside", t)
+}
+
+func TestCanceledContext(t *testing.T) {
+	var ctx, cancel = context.WithCancel(context.Background())
+	var d = &fakeDecoder{w: 400, h: 100}
+	var res = &Resource{Decoder: d, ctx: ctx}
+	var url, _ = iiif.NewURL("identifier/full/full/0/default.jpg")
+	var _, err = res.Apply(url, unlimited)
+	assert.NilError(err, "live context", t)
+
+	cancel()
+	_, err = res.Apply(url, unlimited)
+	assert.Equal(context.Canceled, err, "canceled context", t)
+
+	_, err = NewResourceContext(ctx, "identifier", "/nonexistent")
+	assert.Equal(context.Canceled, err, "canceled before the file is opened", t)
+}
+
+func TestParseUpscaleMode(t *testing.T) {
+	var m, err = ParseUpscaleMode("clamp")
+	assert.NilError(err, "clamp", t)
+	assert.Equal(UpscaleClamp, m, "clamp", t)
+	_, err = ParseUpscaleMode("shrink")
+	assert.True(err != nil, "unknown modes are an error", t)
+}
+
+type fakeLayeredDecoder struct {
+	fakeDecoder
+	layers int
+}
+
+func (d *fakeLayeredDecoder) SetLayers(n int) { d.layers = n }
+
+func TestLayerPolicy(t *testing.T) {
+	var d = &fakeLayeredDecoder{fakeDecoder: fakeDecoder{w: 4000, h: 2000}}
+	var res = &Resource{Decoder: d}
+	var thumb, _ = iiif.NewURL("identifier/full/200,/0/default.jpg")
+	var big, _ = iiif.NewURL("identifier/full/1000,/0/default.jpg")
+
+	var _, err = res.Apply(thumb, unlimited)
+	assert.NilError(err, "default policy", t)
+	assert.Equal(0, d.layers, "the zero policy decodes all layers", t)
+
+	res.Layers = LayerPolicy{Layers: 2, MaxSize: 256}
+	_, err = res.Apply(thumb, unlimited)
+	assert.NilError(err, "thumbnail", t)
+	assert.Equal(2, d.layers, "thumbnails decode fewer layers", t)
+	_, err = res.Apply(big, unlimited)
+	assert.NilError(err, "large image", t)
+	assert.Equal(0, d.layers, "larger images decode all layers", t)
+
+	res.Layers = LayerPolicy{Layers: 1}
+	_, err = res.Apply(big, unlimited)
+	assert.NilError(err, "unlimited size", t)
+	assert.Equal(1, d.layers, "no MaxSize applies to every request", t)
+}
+
+type fakePagedDecoder struct {
+	fakeDecoder
+	pages int
+	page  int
+}
+
+func (d *fakePagedDecoder) PageCount() int { return d.pages }
+func (d *fakePagedDecoder) SetPage(n int) error {
+	d.page = n
+	return nil
+}
+
+func TestSelectPage(t *testing.T) {
+	var d = &fakePagedDecoder{pages: 3}
+	assert.NilError(selectPage(d, "reel.tif"), "IDs without a page are fine", t)
+	assert.Equal(0, d.page, "no page means the first page", t)
+	d.page = 2
+	assert.NilError(selectPage(d, "reel.tif"), "IDs without a page are fine", t)
+	assert.Equal(0, d.page, "the first page is selected explicitly", t)
+	assert.NilError(selectPage(d, "reel.tif;2"), "last page exists", t)
+	assert.Equal(2, d.page, "page is selected", t)
+	assert.Equal(ErrDoesNotExist, selectPage(d, "reel.tif;3"), "pages past the end don't exist", t)
+
+	var single = &fakeDecoder{}
+	assert.NilError(selectPage(single, "photo.jp2;0"), "single-image files have a page 0", t)
+	assert.Equal(ErrDoesNotExist, selectPage(single, "photo.jp2;1"), "single-image files have no page 1", t)
+}
+
+func TestSourceLimits(t *testing.T) {
+	var l = SourceLimits{Width: 1000, Area: 500000, FileSize: 1 << 20}
+	assert.NilError(l.checkFile(1<<20), "file size at the limit", t)
+	assert.NilError(l.checkDimensions(1000, 500, 0), "dimensions at the limit", t)
+
+	var err = l.checkFile(1<<20 + 1)
+	var st, ok = err.(*SourceTooLargeError)
+	assert.True(ok, "file size error is a SourceTooLargeError", t)
+	assert.Equal(0, st.Width, "no dimensions before headers are read", t)
+
+	err = l.checkDimensions(2000, 5000, 100)
+	st, ok = err.(*SourceTooLargeError)
+	assert.True(ok, "dimension error is a SourceTooLargeError", t)
+	assert.Equal(2, len(st.Problems), "width and area are over, height is unlimited", t)
+	assert.Equal("width 2000 exceeds 1000", st.Problems[0], "problem describes the width", t)
+
+	assert.NilError(SourceLimits{}.checkDimensions(1<<20, 1<<20, 1<<40), "zero values are unlimited", t)
+}
+
+type fakeReducingDecoder struct {
+	fakeDecoder
+	reduction int
+}
+
+func (d *fakeReducingDecoder) SetReduction(n int) { d.reduction = n }
+
+func TestDecodeBudget(t *testing.T) {
+	var d = &fakeReducingDecoder{fakeDecoder: fakeDecoder{w: 8000, h: 8000, l: 6}}
+	var res = &Resource{Decoder: d}
+	var full, _ = iiif.NewURL("identifier/full/full/0/default.jpg")
+	var half, _ = iiif.NewURL("identifier/full/4000,/0/default.jpg")
+	var tile, _ = iiif.NewURL("identifier/0,0,512,512/512,/0/default.jpg")
+
+	var _, err = res.Apply(full, unlimited)
+	assert.NilError(err, "no budget", t)
+	assert.Equal(0, res.Reduced, "no budget means no reduction", t)
+
+	// 64 megapixels at 16 megapixels per second takes four seconds
+	res.Budget = DecodeBudget{Limit: time.Second, Rate: 16e6}
+	_, err = res.Apply(full, unlimited)
+	assert.NilError(err, "full size", t)
+	assert.Equal(1, d.reduction, "one level down is a quarter the pixels", t)
+	assert.Equal(1, res.Reduced, "full size is reduced one level", t)
+	assert.Equal(8000, d.resizeW, "the requested size is unchanged", t)
+
+	d.reduction = 0
+	_, err = res.Apply(half, unlimited)
+	assert.NilError(err, "half size", t)
+	assert.Equal(0, res.Reduced, "half size already decodes the level that fits", t)
+	assert.Equal(0, d.reduction, "no reduction is forced", t)
+
+	res.Budget.Limit = 100 * time.Millisecond
+	_, err = res.Apply(half, unlimited)
+	assert.NilError(err, "small budget", t)
+	assert.Equal(3, d.reduction, "levels drop until the estimate fits", t)
+	assert.Equal(2, res.Reduced, "reduction is counted from the level the request needed", t)
+
+	d.reduction = 0
+	_, err = res.Apply(tile, unlimited)
+	assert.NilError(err, "tile", t)
+	assert.Equal(0, res.Reduced, "small tiles fit easily", t)
+
+	res.Budget.Limit = time.Nanosecond
+	_, err = res.Apply(full, unlimited)
+	assert.NilError(err, "impossible budget", t)
+	assert.Equal(6, d.reduction, "reduction stops at the lowest level", t)
+}
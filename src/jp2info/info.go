@@ -53,6 +53,12 @@ func (i *Info) TileWidth() uint32 {
 	return i.XTSiz - i.XTOSiz
 }
 
+// QualityLayers returns the number of quality layers in the codestream,
+// which is stored in the middle two bytes of SGCod
+func (i *Info) QualityLayers() uint16 {
+	return uint16(i.SGCod >> 8)
+}
+
 // TileHeight computes height of tiles
 func (i *Info) TileHeight() uint32 {
 	return i.YTSiz - i.YTOSiz
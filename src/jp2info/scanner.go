@@ -38,6 +38,7 @@ func (s *Scanner) Scan(filename string) (*Info, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
 	s.readInfo(f)
 	return s.i, s.e
@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults for how often a KeySet re-reads its JWKS URL
+const (
+	DefaultRefreshInterval = time.Hour
+	DefaultMinRefresh      = time.Minute
+)
+
+// KeySet is a KeyFinder reading keys from a JWKS URL.  Keys are re-read
+// every RefreshInterval, and also when a token names a key we haven't seen,
+// so keys can be rotated without restarting RAIS.  Unknown keys can't trigger
+// more than one fetch per MinRefresh, so bogus tokens can't be used to hammer
+// the identity provider.
+type KeySet struct {
+	URL             string
+	Client          *http.Client
+	RefreshInterval time.Duration
+	MinRefresh      time.Duration
+
+	m       sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewKeySet returns a KeySet for url with the default refresh settings
+func NewKeySet(url string) *KeySet {
+	return &KeySet{
+		URL:             url,
+		Client:          &http.Client{Timeout: 10 * time.Second},
+		RefreshInterval: DefaultRefreshInterval,
+		MinRefresh:      DefaultMinRefresh,
+	}
+}
+
+// Key implements KeyFinder.  If kid is empty and the set has exactly one
+// key, that key is returned.
+func (ks *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	var age = time.Since(ks.fetched)
+	var key, ok = ks.find(kid)
+	if ks.keys == nil || age > ks.RefreshInterval || (!ok && age > ks.MinRefresh) {
+		var err = ks.fetch()
+		if err != nil && ks.keys == nil {
+			return nil, err
+		}
+		key, ok = ks.find(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// find returns the key with the given ID.  ks.m must be locked.
+func (ks *KeySet) find(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true
+		}
+	}
+	var k, ok = ks.keys[kid]
+	return k, ok
+}
+
+// fetch reads the JWKS, replacing all known keys.  Keys we can't use are
+// skipped.  Failed fetches leave the old keys in place.  ks.m must be locked.
+func (ks *KeySet) fetch() error {
+	ks.fetched = time.Now()
+
+	var resp, err = ks.Client.Get(ks.URL)
+	if err != nil {
+		return fmt.Errorf("unable to read JWKS: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to read JWKS: %s", resp.Status)
+	}
+
+	var keys map[string]crypto.PublicKey
+	keys, err = ParseJWKS(resp.Body)
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	return nil
+}
+
+// jwk is the subset of a JSON Web Key we need to build RSA and EC keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// ParseJWKS reads a JSON Web Key Set, returning its signing keys by ID
+func ParseJWKS(r io.Reader) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	var err = json.NewDecoder(r).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %s", err)
+	}
+
+	var keys = make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key = k.publicKey()
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// publicKey returns the key described by k, or nil if it's invalid or a type
+// we don't support
+func (k jwk) publicKey() crypto.PublicKey {
+	var decode = func(s string) *big.Int {
+		var b, err = base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch k.Kty {
+	case "RSA":
+		var n, e = decode(k.N), decode(k.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return nil
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case "EC":
+		var curve = curves[k.Crv]
+		var x, y = decode(k.X), decode(k.Y)
+		if curve == nil || x == nil || y == nil || !curve.IsOnCurve(x, y) {
+			return nil
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Package jwt validates the JSON Web Tokens campus single sign-on systems
+// hand to viewers, so RAIS can decide who may see what without a separate
+// auth proxy.  Only asymmetric signatures (RS256/384/512, PS256/384/512, and
+// ES256/384/512) are supported, with keys read from a JWKS URL; shared-secret
+// algorithms and "none" are always rejected.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	// Registers the hashes used by the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Errors returned for tokens which can't be trusted
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrSignature = errors.New("invalid token signature")
+	ErrExpired   = errors.New("token has expired")
+	ErrNotYet    = errors.New("token is not valid yet")
+)
+
+// Claims holds a token's payload
+type Claims map[string]interface{}
+
+// Strings returns the named claim as a list of strings.  Claims holding a
+// single string are returned as a one-element list; any other type of value
+// is ignored.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// time returns the named NumericDate claim, and whether it was present
+func (c Claims) time(name string) (time.Time, bool) {
+	var v, ok = c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// KeyFinder looks up the public key a token was signed with by its key ID
+type KeyFinder interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// Validator checks tokens' signatures and standard claims.  Issuer and
+// Audience are only checked if they're set.  Leeway allows for clock skew
+// between RAIS and the token's issuer.
+type Validator struct {
+	Keys     KeyFinder
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+	Now      func() time.Time
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Validate returns token's claims if it's properly signed, current, and
+// meant for us
+func (v *Validator) Validate(token string) (Claims, error) {
+	var parts = strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	var err = decodeSegment(parts[0], &h)
+	if err != nil {
+		return nil, err
+	}
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+	var sig []byte
+	sig, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var key crypto.PublicKey
+	key, err = v.Keys.Key(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	err = verify(h.Alg, key, parts[0]+"."+parts[1], sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, v.checkClaims(claims)
+}
+
+func (v *Validator) checkClaims(claims Claims) error {
+	var now = time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	if exp, ok := claims.time("exp"); ok && !now.Before(exp.Add(v.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return ErrNotYet
+	}
+	if v.Issuer != "" {
+		var iss, _ = claims["iss"].(string)
+		if iss != v.Issuer {
+			return fmt.Errorf("token issuer %q is not %q", iss, v.Issuer)
+		}
+	}
+	if v.Audience != "" && !contains(claims.Strings("aud"), v.Audience) {
+		return fmt.Errorf("token audience does not include %q", v.Audience)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	var data, err = base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	if json.Unmarshal(data, v) != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// hashes maps each algorithm's size suffix to its hash
+var hashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// verify checks sig against the signed data using key and the algorithm
+// named by alg, which must match the key's type
+func verify(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var hash, ok = hashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var h = hash.New()
+	h.Write([]byte(signed))
+	var digest = h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		var k, ok = key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %q doesn't match key type", alg)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		} else {
+			err = rsa.VerifyPSS(k, hash, digest, sig, nil)
+		}
+		if err != nil {
+			return ErrSignature
+		}
+		return nil
+
+	case "ES":
+		var k, ok = key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %q doesn't match key type", alg)
+		}
+		var size = (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrSignature
+		}
+		var r = new(big.Int).SetBytes(sig[:size])
+		var s = new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrSignature
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
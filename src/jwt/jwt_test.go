@@ -0,0 +1,156 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+var b64 = base64.RawURLEncoding
+
+// makeToken returns a token with the given header and claims, signed by key
+func makeToken(t *testing.T, alg, kid string, key crypto.Signer, claims Claims) string {
+	var h, _ = json.Marshal(header{Alg: alg, Kid: kid})
+	var c, _ = json.Marshal(claims)
+	var signed = b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	var digest = sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	if err != nil {
+		t.Fatalf("Unable to sign token: %s", err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+type staticKeys map[string]crypto.PublicKey
+
+func (sk staticKeys) Key(kid string) (crypto.PublicKey, error) {
+	var k, ok = sk[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return k, nil
+}
+
+func TestValidate(t *testing.T) {
+	var rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	var ecKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var now = time.Unix(1500000000, 0)
+	var v = &Validator{
+		Keys:     staticKeys{"r": &rsaKey.PublicKey, "e": &ecKey.PublicKey},
+		Issuer:   "https://sso.example.edu",
+		Audience: "rais",
+		Leeway:   time.Minute,
+		Now:      func() time.Time { return now },
+	}
+	var claims = func(extra Claims) Claims {
+		var c = Claims{"iss": "https://sso.example.edu", "aud": []string{"rais", "other"}, "exp": now.Unix() + 60, "sub": "jdoe"}
+		for k, val := range extra {
+			c[k] = val
+		}
+		return c
+	}
+
+	var c, err = v.Validate(makeToken(t, "RS256", "r", rsaKey, claims(nil)))
+	assert.NilError(err, "valid RSA token", t)
+	assert.Equal("jdoe", c["sub"], "claims are returned", t)
+	_, err = v.Validate(makeToken(t, "ES256", "e", ecKey, claims(nil)))
+	assert.NilError(err, "valid EC token", t)
+	_, err = v.Validate(makeToken(t, "RS256", "r", rsaKey, claims(Claims{"exp": now.Unix() - 30})))
+	assert.NilError(err, "recently expired token is within leeway", t)
+
+	var bad = map[string]string{
+		"expired":          makeToken(t, "RS256", "r", rsaKey, claims(Claims{"exp": now.Unix() - 120})),
+		"not yet valid":    makeToken(t, "RS256", "r", rsaKey, claims(Claims{"nbf": now.Unix() + 120})),
+		"wrong issuer":     makeToken(t, "RS256", "r", rsaKey, claims(Claims{"iss": "https://evil.example.com"})),
+		"wrong audience":   makeToken(t, "RS256", "r", rsaKey, claims(Claims{"aud": "other"})),
+		"unknown key":      makeToken(t, "RS256", "x", rsaKey, claims(nil)),
+		"key type":         makeToken(t, "ES256", "r", ecKey, claims(nil)),
+		"wrong signer":     makeToken(t, "ES256", "e", mustECKey(), claims(nil)),
+		"unsupported alg":  makeToken(t, "HS256", "r", rsaKey, claims(nil)),
+		"malformed":        "not.a.jwt",
+		"too few segments": "abc.def",
+	}
+	for name, tok := range bad {
+		_, err = v.Validate(tok)
+		assert.True(err != nil, name+" token is rejected", t)
+	}
+
+	var tok = strings.Split(makeToken(t, "RS256", "r", rsaKey, claims(nil)), ".")
+	var tampered = strings.Split(makeToken(t, "RS256", "r", rsaKey, claims(Claims{"sub": "admin"})), ".")
+	_, err = v.Validate(tampered[0] + "." + tampered[1] + "." + tok[2])
+	assert.True(err != nil, "signature from another token is rejected", t)
+}
+
+func mustECKey() *ecdsa.PrivateKey {
+	var k, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return k
+}
+
+func TestClaimsStrings(t *testing.T) {
+	var c Claims
+	json.Unmarshal([]byte(`{"a": "x", "b": ["x", 1, "y"], "c": 5}`), &c)
+	assert.Equal("[x]", fmt.Sprint(c.Strings("a")), "single string", t)
+	assert.Equal("[x y]", fmt.Sprint(c.Strings("b")), "list skips non-strings", t)
+	assert.Equal(0, len(c.Strings("c")), "numbers are ignored", t)
+	assert.Equal(0, len(c.Strings("d")), "missing claims are empty", t)
+}
+
+func TestKeySet(t *testing.T) {
+	var k1, k2 = mustECKey(), mustECKey()
+	var jwkFor = func(kid string, k *ecdsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "EC", "kid": kid, "crv": "P-256", "use": "sig",
+			"x": b64.EncodeToString(k.X.Bytes()), "y": b64.EncodeToString(k.Y.Bytes()),
+		}
+	}
+	var published = []map[string]string{jwkFor("one", k1), {"kty": "oct", "kid": "secret", "k": "c2VjcmV0"}}
+	var fetches int
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": published})
+	}))
+	defer srv.Close()
+
+	var ks = NewKeySet(srv.URL)
+	var v = &Validator{Keys: ks}
+	var _, err = v.Validate(makeToken(t, "ES256", "one", k1, Claims{}))
+	assert.NilError(err, "key from JWKS", t)
+	_, err = ks.Key("secret")
+	assert.True(err != nil, "symmetric keys are ignored", t)
+	assert.Equal(1, fetches, "keys are only fetched once", t)
+
+	// A rotated key isn't seen until MinRefresh has passed
+	published = append(published, jwkFor("two", k2))
+	_, err = v.Validate(makeToken(t, "ES256", "two", k2, Claims{}))
+	assert.True(err != nil, "new key isn't fetched too soon", t)
+	ks.MinRefresh = 0
+	_, err = v.Validate(makeToken(t, "ES256", "two", k2, Claims{}))
+	assert.NilError(err, "new key is fetched once MinRefresh passes", t)
+	assert.Equal(2, fetches, "keys are refetched for the unknown key", t)
+}
@@ -0,0 +1,136 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// JPEG markers and APP segment headers
+const (
+	markerSOS   = 0xda
+	markerEOI   = 0xd9
+	markerAPP1  = 0xe1
+	markerAPP13 = 0xed
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// readJPEG reads the APP segments preceding the image data
+func readJPEG(r io.ReaderAt, m Metadata) {
+	var off int64 = 2
+	var header = make([]byte, 4)
+	for {
+		var _, err = r.ReadAt(header, off)
+		if err != nil || header[0] != 0xff {
+			return
+		}
+		var marker = header[1]
+		if marker == markerSOS || marker == markerEOI {
+			return
+		}
+
+		// Segment lengths include the length bytes themselves
+		var size = int64(binary.BigEndian.Uint16(header[2:])) - 2
+		if size < 0 {
+			return
+		}
+		if marker == markerAPP1 || marker == markerAPP13 {
+			var data = make([]byte, size)
+			_, err = r.ReadAt(data, off+4)
+			if err != nil {
+				return
+			}
+			readAPP(marker, data, m)
+		}
+		off += 4 + size
+	}
+}
+
+func readAPP(marker byte, data []byte, m Metadata) {
+	switch {
+	case marker == markerAPP1 && bytes.HasPrefix(data, exifHeader):
+		readTIFF(bytes.NewReader(data[len(exifHeader):]), m)
+	case marker == markerAPP1 && bytes.HasPrefix(data, xmpHeader):
+		readXMP(data[len(xmpHeader):], m)
+	case marker == markerAPP13 && bytes.HasPrefix(data, photoshopHeader):
+		readPhotoshop(data[len(photoshopHeader):], m)
+	}
+}
+
+// jp2Signature is the twelve-byte signature box every JP2 begins with
+var jp2Signature = []byte{0x00, 0x00, 0x00, 0x0c, 'j', 'P', ' ', ' ', 0x0d, 0x0a, 0x87, 0x0a}
+
+// UUIDs of the JP2 boxes metadata is stored in
+var (
+	jp2XMPUUID  = []byte{0xbe, 0x7a, 0xcf, 0xcb, 0x97, 0xa9, 0x42, 0xe8, 0x9c, 0x71, 0x99, 0x94, 0x91, 0xe3, 0xaf, 0xac}
+	jp2EXIFUUID = []byte("JpgTiffExif->JP2")
+	jp2IPTCUUID = []byte{0x33, 0xc7, 0xa4, 0xd2, 0xb8, 0x1d, 0x47, 0x23, 0xa0, 0xba, 0xf1, 0xa3, 0xe0, 0x97, 0xad, 0x38}
+)
+
+// readJP2 reads metadata from the JP2's top-level UUID and XML boxes
+func readJP2(r io.ReaderAt, m Metadata) {
+	var off = int64(len(jp2Signature))
+	var header = make([]byte, 16)
+	for {
+		var _, err = r.ReadAt(header[:8], off)
+		if err != nil {
+			return
+		}
+
+		var length = int64(binary.BigEndian.Uint32(header))
+		var typ = string(header[4:8])
+		var hlen = int64(8)
+		switch length {
+		case 0:
+			// The box runs to the end of the file, which in practice is only ever
+			// the codestream
+			return
+		case 1:
+			_, err = r.ReadAt(header[8:16], off+8)
+			if err != nil {
+				return
+			}
+			length = int64(binary.BigEndian.Uint64(header[8:16]))
+			hlen = 16
+		}
+		if length < hlen {
+			return
+		}
+
+		if (typ == "uuid" || typ == "xml ") && length-hlen <= maxSegmentLen {
+			var data = make([]byte, length-hlen)
+			_, err = r.ReadAt(data, off+hlen)
+			if err != nil {
+				return
+			}
+			readJP2Box(typ, data, m)
+		}
+		off += length
+	}
+}
+
+func readJP2Box(typ string, data []byte, m Metadata) {
+	if typ == "xml " {
+		readXMP(data, m)
+		return
+	}
+	if len(data) < 16 {
+		return
+	}
+
+	var uuid, body = data[:16], data[16:]
+	switch {
+	case bytes.Equal(uuid, jp2XMPUUID):
+		readXMP(body, m)
+	case bytes.Equal(uuid, jp2EXIFUUID):
+		// Some writers include the JPEG APP1 header, some don't
+		body = bytes.TrimPrefix(body, exifHeader)
+		readTIFF(bytes.NewReader(body), m)
+	case bytes.Equal(uuid, jp2IPTCUUID):
+		readIPTC(body, m)
+	}
+}
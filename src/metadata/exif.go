@@ -0,0 +1,199 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Tags pointing at other IFDs or holding other metadata formats
+const (
+	tagExifIFD = 34665
+	tagGPSIFD  = 34853
+	tagXMP     = 700
+	tagIPTC    = 33723
+)
+
+// exifNames maps the TIFF and EXIF tags we report to their names
+var exifNames = map[uint16]string{
+	270:   "ImageDescription",
+	271:   "Make",
+	272:   "Model",
+	274:   "Orientation",
+	305:   "Software",
+	306:   "DateTime",
+	315:   "Artist",
+	33432: "Copyright",
+	33434: "ExposureTime",
+	33437: "FNumber",
+	34855: "ISOSpeedRatings",
+	36867: "DateTimeOriginal",
+	36868: "DateTimeDigitized",
+	37386: "FocalLength",
+	37510: "UserComment",
+	42016: "ImageUniqueID",
+	42032: "CameraOwnerName",
+	42033: "BodySerialNumber",
+	42036: "LensModel",
+}
+
+// gpsNames maps GPS IFD tags to their names
+var gpsNames = map[uint16]string{
+	1: "GPSLatitudeRef",
+	2: "GPSLatitude",
+	3: "GPSLongitudeRef",
+	4: "GPSLongitude",
+	5: "GPSAltitudeRef",
+	6: "GPSAltitude",
+}
+
+// TIFF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+var typeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiffReader reads IFD entries from TIFF-structured data, which is what EXIF
+// is, whether it's a TIFF file or a blob embedded in a JPEG or JP2
+type tiffReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+	m     Metadata
+}
+
+// readTIFF reads EXIF, XMP, and IPTC from the TIFF data in r.  Only the
+// first IFD and its EXIF and GPS sub-IFDs are read.
+func readTIFF(r io.ReaderAt, m Metadata) {
+	var t = &tiffReader{r: r, m: m}
+	var header = make([]byte, 8)
+	var _, err = r.ReadAt(header, 0)
+	if err != nil {
+		return
+	}
+
+	t.order = binary.LittleEndian
+	if header[0] == 'M' {
+		t.order = binary.BigEndian
+	}
+	if t.order.Uint16(header[2:]) != 42 {
+		return
+	}
+
+	var sub = t.readIFD(int64(t.order.Uint32(header[4:])), exifNames)
+	if off, ok := sub[tagExifIFD]; ok {
+		t.readIFD(off, exifNames)
+	}
+	if off, ok := sub[tagGPSIFD]; ok {
+		t.readIFD(off, gpsNames)
+	}
+}
+
+// readIFD stores the named tags from the IFD at off, returning the offsets
+// of any sub-IFDs, and handing embedded XMP and IPTC to their parsers
+func (t *tiffReader) readIFD(off int64, names map[uint16]string) map[uint16]int64 {
+	var sub = make(map[uint16]int64)
+	var countBytes = make([]byte, 2)
+	var _, err = t.r.ReadAt(countBytes, off)
+	if err != nil {
+		return sub
+	}
+
+	var entries = make([]byte, 12*int(t.order.Uint16(countBytes)))
+	var n, _ = t.r.ReadAt(entries, off+2)
+	entries = entries[:n]
+
+	for len(entries) >= 12 {
+		var e = entries[:12]
+		entries = entries[12:]
+
+		var tag, typ, count = t.order.Uint16(e), t.order.Uint16(e[2:]), t.order.Uint32(e[4:])
+		if tag == tagExifIFD || tag == tagGPSIFD {
+			sub[tag] = int64(t.order.Uint32(e[8:]))
+			continue
+		}
+
+		var name, named = names[tag]
+		if !named && tag != tagXMP && tag != tagIPTC {
+			continue
+		}
+
+		var size = typeSizes[typ] * count
+		if size == 0 || size > maxSegmentLen {
+			continue
+		}
+		var data = make([]byte, size)
+		if size <= 4 {
+			copy(data, e[8:])
+		} else {
+			var n, _ = t.r.ReadAt(data, int64(t.order.Uint32(e[8:])))
+			if uint32(n) != size {
+				continue
+			}
+		}
+
+		switch tag {
+		case tagXMP:
+			readXMP(data, t.m)
+		case tagIPTC:
+			readIPTC(data, t.m)
+		default:
+			t.m.set(GroupEXIF, name, t.format(typ, data))
+		}
+	}
+
+	return sub
+}
+
+// format turns a field's raw data into a string
+func (t *tiffReader) format(typ uint16, data []byte) string {
+	var vals []string
+	switch typ {
+	case typeASCII:
+		return strings.TrimRight(string(data), "\x00")
+	case typeUndefined:
+		// EXIF's "undefined" text fields start with an 8-byte charset code; we
+		// only read ASCII
+		if bytes.HasPrefix(data, []byte("ASCII\x00\x00\x00")) {
+			return strings.TrimRight(string(data[8:]), "\x00 ")
+		}
+		return ""
+	case typeByte:
+		for _, b := range data {
+			vals = append(vals, strconv.Itoa(int(b)))
+		}
+	case typeShort:
+		for i := 0; i+2 <= len(data); i += 2 {
+			vals = append(vals, strconv.Itoa(int(t.order.Uint16(data[i:]))))
+		}
+	case typeLong:
+		for i := 0; i+4 <= len(data); i += 4 {
+			vals = append(vals, strconv.FormatUint(uint64(t.order.Uint32(data[i:])), 10))
+		}
+	case typeSLong:
+		for i := 0; i+4 <= len(data); i += 4 {
+			vals = append(vals, strconv.Itoa(int(int32(t.order.Uint32(data[i:])))))
+		}
+	case typeRational, typeSRational:
+		for i := 0; i+8 <= len(data); i += 8 {
+			var n, d = float64(t.order.Uint32(data[i:])), float64(t.order.Uint32(data[i+4:]))
+			if typ == typeSRational {
+				n, d = float64(int32(t.order.Uint32(data[i:]))), float64(int32(t.order.Uint32(data[i+4:])))
+			}
+			if d == 0 {
+				continue
+			}
+			vals = append(vals, strconv.FormatFloat(n/d, 'f', -1, 64))
+		}
+	}
+	return strings.Join(vals, " ")
+}
@@ -0,0 +1,120 @@
+package metadata
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// GIF block introducers and extension labels
+const (
+	gifImage       = 0x2c
+	gifExtension   = 0x21
+	gifTrailer     = 0x3b
+	gifApplication = 0xff
+)
+
+// readGIF walks a GIF's blocks, skipping the image data, to count its frames
+// and find how many times an animation loops.  A LoopCount of 0 means the
+// animation loops forever; GIFs without a loop count play once.
+func readGIF(r io.Reader, m Metadata) {
+	var br = bufio.NewReader(r)
+
+	// Header and logical screen descriptor, followed by the global color table
+	// if there is one
+	var header = make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return
+	}
+	if header[10]&0x80 != 0 {
+		if _, err := br.Discard(3 << (header[10]&0x07 + 1)); err != nil {
+			return
+		}
+	}
+
+	// Frames seen before any corruption still count
+	var frames int
+	defer func() {
+		if frames > 0 {
+			m.set(GroupGIF, "FrameCount", strconv.Itoa(frames))
+		}
+	}()
+
+	for {
+		var b, err = br.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch b {
+		case gifImage:
+			var desc = make([]byte, 9)
+			if _, err = io.ReadFull(br, desc); err != nil {
+				return
+			}
+			if desc[8]&0x80 != 0 {
+				if _, err = br.Discard(3 << (desc[8]&0x07 + 1)); err != nil {
+					return
+				}
+			}
+			// LZW minimum code size, then the image data
+			if _, err = br.ReadByte(); err != nil {
+				return
+			}
+			if _, err = gifSubBlocks(br, false); err != nil {
+				return
+			}
+			frames++
+
+		case gifExtension:
+			var label byte
+			if label, err = br.ReadByte(); err != nil {
+				return
+			}
+			var blocks [][]byte
+			if blocks, err = gifSubBlocks(br, label == gifApplication); err != nil {
+				return
+			}
+			if len(blocks) > 1 && string(blocks[0]) == "NETSCAPE2.0" {
+				var loop = blocks[1]
+				if len(loop) == 3 && loop[0] == 1 {
+					m.set(GroupGIF, "LoopCount", strconv.Itoa(int(loop[1])|int(loop[2])<<8))
+				}
+			}
+
+		case gifTrailer:
+			return
+
+		default:
+			// Anything else means the file is corrupt
+			return
+		}
+	}
+}
+
+// gifSubBlocks reads a chain of data sub-blocks up to its terminator,
+// returning the blocks if keep is true and skipping over them otherwise
+func gifSubBlocks(br *bufio.Reader, keep bool) ([][]byte, error) {
+	var blocks [][]byte
+	for {
+		var size, err = br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return blocks, nil
+		}
+		if !keep {
+			if _, err = br.Discard(int(size)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var data = make([]byte, size)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, data)
+	}
+}
@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// iptcNames maps IIM record 2 (application record) datasets to their names
+var iptcNames = map[byte]string{
+	5:   "ObjectName",
+	25:  "Keywords",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	80:  "By-line",
+	85:  "By-lineTitle",
+	90:  "City",
+	101: "Country",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// readIPTC stores the named datasets from raw IPTC IIM data.  Text is
+// assumed to be UTF-8, which is true of nearly everything written this
+// century.
+func readIPTC(data []byte, m Metadata) {
+	for len(data) >= 5 && data[0] == 0x1c {
+		var record, dataset = data[1], data[2]
+		var size = int(binary.BigEndian.Uint16(data[3:]))
+
+		// Extended datasets (high bit set) are only used for huge binary
+		// values we don't care about, and we can't safely skip past them
+		if size&0x8000 != 0 || 5+size > len(data) {
+			return
+		}
+
+		var value = data[5 : 5+size]
+		data = data[5+size:]
+		if name, ok := iptcNames[dataset]; ok && record == 2 {
+			m.set(GroupIPTC, name, string(value))
+		}
+	}
+}
+
+// photoshopHeader begins a JPEG's APP13 segment
+var photoshopHeader = []byte("Photoshop 3.0\x00")
+
+// iptcResourceID is the Photoshop image resource holding IPTC data
+const iptcResourceID = 0x0404
+
+// readPhotoshop finds IPTC data in a series of Photoshop image resources
+func readPhotoshop(data []byte, m Metadata) {
+	for len(data) >= 12 && bytes.HasPrefix(data, []byte("8BIM")) {
+		var id = binary.BigEndian.Uint16(data[4:])
+
+		// The resource name is a Pascal string padded to an even length
+		var nameLen = int(data[6]) + 1
+		nameLen += nameLen % 2
+		if 6+nameLen+4 > len(data) {
+			return
+		}
+		data = data[6+nameLen:]
+
+		var size = int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if size > len(data) {
+			return
+		}
+		if id == iptcResourceID {
+			readIPTC(data[:size], m)
+		}
+
+		size += size % 2
+		if size > len(data) {
+			return
+		}
+		data = data[size:]
+	}
+}
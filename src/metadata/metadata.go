@@ -0,0 +1,126 @@
+// Package metadata extracts the descriptive metadata embedded in image
+// files - EXIF, XMP, and IPTC - from JPEGs, TIFFs, and JP2s, as well as the
+// frame counts of animated GIFs.  Only headers are read; pixel data is never
+// decoded.
+package metadata
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Metadata groups extracted values by their source ("exif", "xmp", "iptc",
+// "gif"), then by field name.  Repeated fields are joined with "; ".
+type Metadata map[string]map[string]string
+
+// Metadata groups
+const (
+	GroupEXIF = "exif"
+	GroupXMP  = "xmp"
+	GroupIPTC = "iptc"
+	GroupGIF  = "gif"
+)
+
+// DefaultFields is the allowlist used when none is configured: capture dates,
+// credits, descriptions, and animation frame counts, but nothing like GPS
+// coordinates or camera serial numbers that could leak more than a front-end
+// should show
+var DefaultFields = []string{
+	"exif.DateTimeOriginal", "exif.DateTime", "exif.Artist", "exif.Copyright", "exif.ImageDescription",
+	"xmp.dc:creator", "xmp.dc:rights", "xmp.dc:title", "xmp.dc:description",
+	"xmp.photoshop:Credit", "xmp.photoshop:DateCreated", "xmp.xmp:CreateDate",
+	"iptc.ObjectName", "iptc.DateCreated", "iptc.By-line", "iptc.Credit",
+	"iptc.CopyrightNotice", "iptc.Caption-Abstract",
+	"gif.FrameCount", "gif.LoopCount",
+}
+
+// maxSegmentLen caps how much of a file we'll read for a single piece of
+// metadata, so a corrupt length can't make us allocate gigabytes
+const maxSegmentLen = 1 << 22
+
+// Read returns all the metadata embedded in the file at path.  Files of
+// unknown types, and files without metadata, return an empty Metadata.
+func Read(path string) (Metadata, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m = make(Metadata)
+	var magic = make([]byte, 12)
+	_, err = io.ReadFull(f, magic)
+	if err != nil {
+		return m, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0xff, 0xd8}):
+		readJPEG(f, m)
+	case bytes.HasPrefix(magic, []byte("II*\x00")) || bytes.HasPrefix(magic, []byte("MM\x00*")):
+		readTIFF(f, m)
+	case bytes.HasPrefix(magic, []byte("GIF87a")) || bytes.HasPrefix(magic, []byte("GIF89a")):
+		f.Seek(0, io.SeekStart)
+		readGIF(f, m)
+	case bytes.Equal(magic, jp2Signature):
+		readJP2(f, m)
+	}
+	return m, nil
+}
+
+// set adds a value to the metadata, appending it to any existing value
+func (m Metadata) set(group, name, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if m[group] == nil {
+		m[group] = make(map[string]string)
+	}
+	if old := m[group][name]; old != "" {
+		value = old + "; " + value
+	}
+	m[group][name] = value
+}
+
+// Filter returns only the fields in the allowlist.  Each entry is a group and
+// field name, like "exif.Artist"; "group.*" allows all of a group's fields,
+// and "*" allows everything.
+func (m Metadata) Filter(allowed []string) Metadata {
+	var out = make(Metadata)
+	for group, fields := range m {
+		for name, value := range fields {
+			if isAllowed(group, name, allowed) {
+				if out[group] == nil {
+					out[group] = make(map[string]string)
+				}
+				out[group][name] = value
+			}
+		}
+	}
+	return out
+}
+
+func isAllowed(group, name string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == "*" || a == group+".*" || a == group+"."+name {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields returns the sorted list of "group.name" fields present
+func (m Metadata) Fields() []string {
+	var list []string
+	for group, fields := range m {
+		for name := range fields {
+			list = append(list, group+"."+name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,206 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// exifBlob returns big-endian EXIF data with an artist in IFD0, a capture
+// date in the EXIF IFD, and a latitude in the GPS IFD
+func exifBlob() []byte {
+	var be = binary.BigEndian
+	var b bytes.Buffer
+	var w = func(vals ...interface{}) {
+		for _, v := range vals {
+			binary.Write(&b, be, v)
+		}
+	}
+
+	// Header, then IFD0 at 8: three entries (12 bytes each) plus count and
+	// next-IFD offset make 42 bytes, so values start at 50
+	b.WriteString("MM\x00*")
+	w(uint32(8))
+	w(uint16(3))
+	w(uint16(315), uint16(typeASCII), uint32(8), uint32(50))
+	w(uint16(tagExifIFD), uint16(typeLong), uint32(1), uint32(58))
+	w(uint16(tagGPSIFD), uint16(typeLong), uint32(1), uint32(96))
+	w(uint32(0))
+	b.WriteString("J. Doe\x00\x00")
+
+	// EXIF IFD at 58, with its date at 76
+	w(uint16(1))
+	w(uint16(36867), uint16(typeASCII), uint32(20), uint32(76))
+	w(uint32(0))
+	b.WriteString("1925:06:01 12:00:00\x00")
+
+	// GPS IFD at 96, with the latitude's rationals at 114
+	w(uint16(1))
+	w(uint16(2), uint16(typeRational), uint32(3), uint32(114))
+	w(uint32(0))
+	w(uint32(44), uint32(1), uint32(3), uint32(1), uint32(15), uint32(2))
+
+	return b.Bytes()
+}
+
+var xmpPacket = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" photoshop:Credit="University Archives">
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li><rdf:li>John Roe</rdf:li></rdf:Seq></dc:creator>
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Main Street</rdf:li></rdf:Alt></dc:title>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func iptcBlob() []byte {
+	var b bytes.Buffer
+	var dataset = func(id byte, val string) {
+		b.Write([]byte{0x1c, 2, id})
+		binary.Write(&b, binary.BigEndian, uint16(len(val)))
+		b.WriteString(val)
+	}
+	dataset(80, "Staff Photographer")
+	dataset(25, "parade")
+	dataset(25, "1920s")
+
+	var ps bytes.Buffer
+	ps.Write(photoshopHeader)
+	ps.WriteString("8BIM")
+	binary.Write(&ps, binary.BigEndian, uint16(iptcResourceID))
+	ps.Write([]byte{0, 0})
+	binary.Write(&ps, binary.BigEndian, uint32(b.Len()))
+	ps.Write(b.Bytes())
+	return ps.Bytes()
+}
+
+func buildJPEG() []byte {
+	var b bytes.Buffer
+	var segment = func(marker byte, data []byte) {
+		b.Write([]byte{0xff, marker})
+		binary.Write(&b, binary.BigEndian, uint16(len(data)+2))
+		b.Write(data)
+	}
+
+	b.Write([]byte{0xff, 0xd8})
+	segment(0xe0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	segment(markerAPP1, append(append([]byte{}, exifHeader...), exifBlob()...))
+	segment(markerAPP1, append(append([]byte{}, xmpHeader...), xmpPacket...))
+	segment(markerAPP13, iptcBlob())
+	b.Write([]byte{0xff, markerSOS, 0, 2})
+	return b.Bytes()
+}
+
+func readBytes(t *testing.T, data []byte) Metadata {
+	var dir, err = ioutil.TempDir("", "rais-metadata")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var fname = filepath.Join(dir, "img")
+	ioutil.WriteFile(fname, data, 0644)
+	var m Metadata
+	m, err = Read(fname)
+	assert.NilError(err, "reading metadata", t)
+	return m
+}
+
+func TestReadJPEG(t *testing.T) {
+	var m = readBytes(t, buildJPEG())
+	assert.Equal("J. Doe", m[GroupEXIF]["Artist"], "EXIF artist", t)
+	assert.Equal("1925:06:01 12:00:00", m[GroupEXIF]["DateTimeOriginal"], "EXIF IFD date", t)
+	assert.Equal("44 3 7.5", m[GroupEXIF]["GPSLatitude"], "GPS latitude", t)
+	assert.Equal("Jane Doe; John Roe", m[GroupXMP]["dc:creator"], "XMP array", t)
+	assert.Equal("Main Street", m[GroupXMP]["dc:title"], "XMP alt", t)
+	assert.Equal("University Archives", m[GroupXMP]["photoshop:Credit"], "XMP attribute property", t)
+	assert.Equal("Staff Photographer", m[GroupIPTC]["By-line"], "IPTC byline", t)
+	assert.Equal("parade; 1920s", m[GroupIPTC]["Keywords"], "repeated IPTC datasets", t)
+}
+
+func TestReadJP2(t *testing.T) {
+	var b bytes.Buffer
+	b.Write(jp2Signature)
+	var box = func(uuid []byte, data []byte) {
+		binary.Write(&b, binary.BigEndian, uint32(8+len(uuid)+len(data)))
+		b.WriteString("uuid")
+		b.Write(uuid)
+		b.Write(data)
+	}
+	box(jp2EXIFUUID, append(append([]byte{}, exifHeader...), exifBlob()...))
+	box(jp2XMPUUID, []byte(xmpPacket))
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	b.WriteString("jp2c")
+
+	var m = readBytes(t, b.Bytes())
+	assert.Equal("J. Doe", m[GroupEXIF]["Artist"], "EXIF artist", t)
+	assert.Equal("Jane Doe; John Roe", m[GroupXMP]["dc:creator"], "XMP array", t)
+}
+
+func TestReadUnknown(t *testing.T) {
+	var m = readBytes(t, []byte("GIF89a nothing to see here"))
+	assert.Equal(0, len(m), "unknown formats have no metadata", t)
+}
+
+func TestFilter(t *testing.T) {
+	var m = readBytes(t, buildJPEG())
+
+	var f = m.Filter(DefaultFields)
+	assert.Equal("J. Doe", f[GroupEXIF]["Artist"], "artist is allowed by default", t)
+	assert.Equal("", f[GroupEXIF]["GPSLatitude"], "GPS isn't allowed by default", t)
+	assert.Equal("", f[GroupIPTC]["Keywords"], "keywords aren't allowed by default", t)
+
+	f = m.Filter([]string{"iptc.*"})
+	assert.Equal(1, len(f), "only one group", t)
+	assert.Equal(2, len(f[GroupIPTC]), "all IPTC fields", t)
+
+	f = m.Filter([]string{"*"})
+	assert.Equal(len(m.Fields()), len(f.Fields()), "everything", t)
+}
+
+func TestReadGIF(t *testing.T) {
+	var b bytes.Buffer
+	// Header and a logical screen descriptor with a two-color global table
+	b.WriteString("GIF89a")
+	b.Write([]byte{1, 0, 1, 0, 0x80, 0, 0})
+	b.Write([]byte{0, 0, 0, 255, 255, 255})
+
+	// Loop forever
+	b.Write([]byte{0x21, 0xff, 11})
+	b.WriteString("NETSCAPE2.0")
+	b.Write([]byte{3, 1, 0, 0, 0})
+
+	// A comment, which is skipped, then three one-pixel frames, the second with
+	// a local color table
+	b.Write([]byte{0x21, 0xfe, 2, 'h', 'i', 0})
+	var frame = func(local bool) {
+		var flags byte
+		if local {
+			flags = 0x80
+		}
+		b.Write([]byte{0x2c, 0, 0, 0, 0, 1, 0, 1, 0, flags})
+		if local {
+			b.Write([]byte{0, 0, 0, 255, 0, 0})
+		}
+		b.Write([]byte{2, 2, 0x4c, 0x01, 0})
+	}
+	frame(false)
+	frame(true)
+	frame(false)
+	b.WriteByte(0x3b)
+
+	var m = readBytes(t, b.Bytes())
+	assert.Equal("3", m[GroupGIF]["FrameCount"], "frame count", t)
+	assert.Equal("0", m[GroupGIF]["LoopCount"], "loop count", t)
+	assert.Equal("3", m.Filter(DefaultFields)[GroupGIF]["FrameCount"], "frame count is allowed by default", t)
+
+	m = readBytes(t, b.Bytes()[:b.Len()-12])
+	assert.Equal("2", m[GroupGIF]["FrameCount"], "frames before a truncation are counted", t)
+}
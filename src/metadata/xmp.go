@@ -0,0 +1,103 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+const rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// readXMP stores the simple properties of an XMP packet's rdf:Description
+// elements, named with the packet's own namespace prefixes, e.g.,
+// "dc:creator".  Arrays (rdf:Seq, rdf:Bag, rdf:Alt) are joined; structured
+// values are skipped.
+func readXMP(data []byte, m Metadata) {
+	var d = xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	var prefixes = make(map[string]string)
+
+	// depth is the current element depth; descDepth and propDepth are the
+	// depths of the current rdf:Description and property, or 0 outside them
+	var depth, descDepth, propDepth int
+	var prop string
+	var text, li strings.Builder
+	var inLI bool
+	var items []string
+
+	for {
+		var tok, err = d.Token()
+		if err != nil {
+			return
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					prefixes[a.Value] = a.Name.Local
+				}
+			}
+
+			switch {
+			case t.Name.Space == rdfNS && t.Name.Local == "Description":
+				descDepth = depth
+				for _, a := range t.Attr {
+					if name := xmpName(prefixes, a.Name); name != "" {
+						m.set(GroupXMP, name, a.Value)
+					}
+				}
+			case descDepth > 0 && depth == descDepth+1:
+				prop = xmpName(prefixes, t.Name)
+				propDepth = depth
+				text.Reset()
+				items = nil
+			case propDepth > 0 && t.Name.Space == rdfNS && t.Name.Local == "li":
+				inLI = true
+				li.Reset()
+			}
+
+		case xml.CharData:
+			if inLI {
+				li.Write(t)
+			} else if propDepth > 0 && depth == propDepth {
+				text.Write(t)
+			}
+
+		case xml.EndElement:
+			switch {
+			case inLI && t.Name.Space == rdfNS && t.Name.Local == "li":
+				inLI = false
+				if v := strings.TrimSpace(li.String()); v != "" {
+					items = append(items, v)
+				}
+			case propDepth > 0 && depth == propDepth:
+				if prop != "" {
+					if len(items) > 0 {
+						m.set(GroupXMP, prop, strings.Join(items, "; "))
+					} else {
+						m.set(GroupXMP, prop, text.String())
+					}
+				}
+				propDepth = 0
+			case depth == descDepth:
+				descDepth = 0
+			}
+			depth--
+		}
+	}
+}
+
+// xmpName returns "prefix:local" for an XMP property, or "" for RDF and
+// namespace declarations, or names whose namespace wasn't declared
+func xmpName(prefixes map[string]string, n xml.Name) string {
+	if n.Space == rdfNS || n.Space == "xmlns" || n.Space == "xml" || n.Space == "" {
+		return ""
+	}
+	var p, ok = prefixes[n.Space]
+	if !ok {
+		return ""
+	}
+	return p + ":" + n.Local
+}
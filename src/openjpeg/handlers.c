@@ -3,15 +3,16 @@
 #include "handlers.h"
 #include "_cgo_export.h"
 
+// client_data is the cgo.Handle of the Go logger for the image being decoded
 static void warning_callback(const char *msg, void *client_data) {
-	GoLogWarning((char *)msg);
+	GoLogWarning((uintptr_t)client_data, (char *)msg);
 }
 
 static void error_callback(const char *msg, void *client_data) {
-	GoLogError((char *)msg);
+	GoLogError((uintptr_t)client_data, (char *)msg);
 }
 
-void set_handlers(opj_codec_t* p_codec) {
-	opj_set_warning_handler(p_codec, warning_callback, 00);
-	opj_set_error_handler(p_codec, error_callback, 00);
+void set_handlers(opj_codec_t* p_codec, uintptr_t logger) {
+	opj_set_warning_handler(p_codec, warning_callback, (void *)logger);
+	opj_set_error_handler(p_codec, error_callback, (void *)logger);
 }
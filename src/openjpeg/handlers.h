@@ -1,5 +1,5 @@
 #include <stdio.h>
+#include <stdint.h>
 #include <openjpeg.h>
 
-extern void set_handlers(opj_codec_t * p_codec);
-extern void GoLog(int level, char *message);
+extern void set_handlers(opj_codec_t * p_codec, uintptr_t logger);
@@ -0,0 +1,68 @@
+package openjpeg
+
+import (
+	"os"
+	"rais/src/jp2info"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// headerCache holds parsed JP2 headers keyed by filename, so a burst of tile
+// requests for one image only scans its header once.  Decoders themselves
+// can't be shared, since each request sets its own crop and size, but the
+// header is read-only once parsed.  It's nil unless CacheHeaders is called.
+var headerCache *lru.Cache
+var headerCacheM sync.RWMutex
+
+// cachedHeader is a parsed header along with the file's size and modification
+// time when it was read.  If either changes, the file is scanned again.
+type cachedHeader struct {
+	size    int64
+	modTime time.Time
+	info    *jp2info.Info
+}
+
+// CacheHeaders keeps the parsed headers of the n most recently opened JP2s.
+// Zero disables the cache.
+func CacheHeaders(n int) {
+	headerCacheM.Lock()
+	defer headerCacheM.Unlock()
+
+	headerCache = nil
+	if n > 0 {
+		headerCache, _ = lru.New(n)
+	}
+}
+
+// scanHeader returns the parsed header for filename, from the cache if the
+// file hasn't changed since it was last scanned
+func scanHeader(filename string) (*jp2info.Info, error) {
+	headerCacheM.RLock()
+	var c = headerCache
+	headerCacheM.RUnlock()
+	if c == nil {
+		return new(jp2info.Scanner).Scan(filename)
+	}
+
+	var fi, err = os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := c.Get(filename); ok {
+		var h = v.(cachedHeader)
+		if h.size == fi.Size() && h.modTime.Equal(fi.ModTime()) {
+			return h.info, nil
+		}
+	}
+
+	var info *jp2info.Info
+	info, err = new(jp2info.Scanner).Scan(filename)
+	if err != nil {
+		c.Remove(filename)
+		return nil, err
+	}
+	c.Add(filename, cachedHeader{size: fi.Size(), modTime: fi.ModTime(), info: info})
+	return info, nil
+}
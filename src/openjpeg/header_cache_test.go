@@ -0,0 +1,45 @@
+package openjpeg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestHeaderCache(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-jp2")
+	assert.NilError(err, "creating a temp dir", t)
+	defer os.RemoveAll(dir)
+
+	var src, _ = os.Getwd()
+	var data []byte
+	data, err = ioutil.ReadFile(src + "/../../docker/images/testfile/test-world.jp2")
+	assert.NilError(err, "reading the test file", t)
+	var fname = filepath.Join(dir, "test.jp2")
+	assert.NilError(ioutil.WriteFile(fname, data, 0644), "copying the test file", t)
+
+	CacheHeaders(10)
+	defer CacheHeaders(0)
+
+	var a, b *JP2Image
+	a, err = NewJP2Image(fname)
+	assert.NilError(err, "first open", t)
+	b, err = NewJP2Image(fname)
+	assert.NilError(err, "second open", t)
+	assert.True(a.info == b.info, "the second open uses the cached header", t)
+
+	var later = time.Now().Add(time.Minute)
+	assert.NilError(os.Chtimes(fname, later, later), "touching the file", t)
+	b, err = NewJP2Image(fname)
+	assert.NilError(err, "open after touching", t)
+	assert.False(a.info == b.info, "changed files are scanned again", t)
+	assert.Equal(800, b.GetWidth(), "width", t)
+
+	assert.NilError(ioutil.WriteFile(fname, []byte("not a jp2"), 0644), "replacing the file", t)
+	_, err = NewJP2Image(fname)
+	assert.True(err != nil, "replaced files aren't served from the cache", t)
+}
@@ -5,12 +5,13 @@
 import "C"
 
 import (
+	"context"
 	"image"
 	"rais/src/jp2info"
+	"rais/src/pixel"
+	"rais/src/transform"
 	"reflect"
 	"unsafe"
-
-	"github.com/nfnt/resize"
 )
 
 // JP2Image is a container for our simple JP2 operations
@@ -21,10 +22,16 @@ type JP2Image struct {
 	decodeHeight int
 	decodeArea   image.Rectangle
 	srcRect      image.Rectangle
+	filter       transform.Filter
+	ctx          context.Context
+	layers       int
+	reduction    int
 }
 
 // NewJP2Image reads basic information about a file and returns a decode-ready
-// JP2Image instance
+// JP2Image instance.  Only the JP2 boxes and the codestream's main header are
+// read, in Go, and no openjpeg structures are set up until DecodeImage is
+// called, so info.json requests never pay for decoder setup.
 func NewJP2Image(filename string) (*JP2Image, error) {
 	i := &JP2Image{filename: filename}
 
@@ -37,7 +44,7 @@ func NewJP2Image(filename string) (*JP2Image, error) {
 
 func (i *JP2Image) readInfo() error {
 	var err error
-	i.info, err = new(jp2info.Scanner).Scan(i.filename)
+	i.info, err = scanHeader(i.filename)
 	return err
 }
 
@@ -49,6 +56,32 @@ func (i *JP2Image) SetResizeWH(width, height int) {
 	i.decodeHeight = height
 }
 
+// SetFilter sets the resampling filter used when the decoded image has to be
+// scaled to the requested size
+func (i *JP2Image) SetFilter(f transform.Filter) {
+	i.filter = f
+}
+
+// SetContext ties decoding to ctx, so that a canceled request stops reading
+// the image rather than finishing a decode nobody will receive
+func (i *JP2Image) SetContext(ctx context.Context) {
+	i.ctx = ctx
+}
+
+// SetLayers limits decoding to the first n quality layers, or all layers if
+// n is zero.  Skipping the later layers saves reading and decoding the data
+// that refines the image, which adds up for thumbnails of huge images.
+func (i *JP2Image) SetLayers(n int) {
+	i.layers = n
+}
+
+// SetReduction forces decoding at the given resolution level or lower, even
+// if the requested size needs more detail.  The decoded image is scaled up to
+// the requested size, trading sharpness for speed on huge images.
+func (i *JP2Image) SetReduction(level int) {
+	i.reduction = level
+}
+
 // SetCrop sets the image crop area for decoding an image
 func (i *JP2Image) SetCrop(r image.Rectangle) {
 	i.decodeArea = r
@@ -90,31 +123,14 @@ func (i *JP2Image) DecodeImage() (img image.Image, err error) {
 		// don't care about the *source* image's alpha.  It's worth noting that
 		// this will almost certainly blow up on any JP2 that isn't using RGB.
 
-		area := width * height
-		bytes := area << 2
-		realData := make([]uint8, bytes)
-
-		red := JP2ComponentData(comps[0])
-		green := JP2ComponentData(comps[1])
-		blue := JP2ComponentData(comps[2])
-
-		offset := 0
-		for i := 0; i < area; i++ {
-			realData[offset] = red[i]
-			offset++
-			realData[offset] = green[i]
-			offset++
-			realData[offset] = blue[i]
-			offset++
-			realData[offset] = 255
-			offset++
-		}
+		realData := make([]uint8, width*height<<2)
+		pixel.InterleaveRGBA(realData, componentInt32s(comps[0]), componentInt32s(comps[1]), componentInt32s(comps[2]))
 
 		img = &image.RGBA{Pix: realData, Stride: width << 2, Rect: bounds}
 	}
 
-	if i.decodeWidth != i.decodeArea.Dx() || i.decodeHeight != i.decodeArea.Dy() {
-		img = resize.Resize(uint(i.decodeWidth), uint(i.decodeHeight), img, resize.Bilinear)
+	if i.decodeWidth != width || i.decodeHeight != height {
+		img = i.filter.Resize(img, i.decodeWidth, i.decodeHeight)
 	}
 
 	return img, nil
@@ -140,6 +156,11 @@ func (i *JP2Image) GetTileHeight() int {
 	return int(i.info.TileHeight())
 }
 
+// untiled returns true if the image is stored as a single tile
+func (i *JP2Image) untiled() bool {
+	return i.info.TileWidth() >= i.info.Width && i.info.TileHeight() >= i.info.Height
+}
+
 // GetLevels returns the number of resolution levels
 func (i *JP2Image) GetLevels() int {
 	return int(i.info.Levels)
@@ -159,35 +180,52 @@ func (i *JP2Image) computeDecodeParameters() {
 }
 
 // computeProgressionLevel gets progression level if we're resizing to specific
-// dimensions (it's zero if there isn't any scaling of the output)
+// dimensions (it's zero if there isn't any scaling of the output) or a
+// reduction was forced
 func (i *JP2Image) computeProgressionLevel() int {
-	if i.decodeWidth == i.decodeArea.Dx() && i.decodeHeight == i.decodeArea.Dy() {
-		return 0
+	level := 0
+	if i.decodeWidth != i.decodeArea.Dx() || i.decodeHeight != i.decodeArea.Dy() {
+		level = desiredProgressionLevel(i.decodeArea, i.decodeWidth, i.decodeHeight)
+	}
+	if i.reduction > level {
+		level = i.reduction
 	}
 
-	level := desiredProgressionLevel(i.decodeArea, i.decodeWidth, i.decodeHeight)
 	if level > i.GetLevels() {
-		Logger.Debugf("Progression level requested (%d) is too high", level)
+		i.logger().Debugf("Progression level requested (%d) is too high", level)
 		level = i.GetLevels()
 	}
 
 	return level
 }
 
+// computeLayers returns the number of quality layers to decode, which is zero
+// (all layers) unless fewer than the image has were requested
+func (i *JP2Image) computeLayers() int {
+	if i.layers <= 0 || i.layers >= int(i.info.QualityLayers()) {
+		return 0
+	}
+	return i.layers
+}
+
 // JP2ComponentData returns a slice of Image-usable uint8s from the JP2 raw
 // data in the given component struct
 func JP2ComponentData(comp C.struct_opj_image_comp) []uint8 {
+	var data = componentInt32s(comp)
+	realData := make([]uint8, len(data))
+	pixel.Narrow(realData, data)
+	return realData
+}
+
+// componentInt32s returns a slice over the given component's raw data.  The
+// slice points into openjpeg's memory, so it's only valid until the image is
+// destroyed.
+func componentInt32s(comp C.struct_opj_image_comp) []int32 {
 	var data []int32
 	dataSlice := (*reflect.SliceHeader)((unsafe.Pointer(&data)))
 	size := int(comp.w) * int(comp.h)
 	dataSlice.Cap = size
 	dataSlice.Len = size
 	dataSlice.Data = uintptr(unsafe.Pointer(comp.data))
-
-	realData := make([]uint8, len(data))
-	for index, point := range data {
-		realData[index] = uint8(point)
-	}
-
-	return realData
+	return data
 }
@@ -82,6 +82,17 @@ func TestResizeWHAndCrop(t *testing.T) {
 	assert.Equal(125, i.Bounds().Max.Y, "Max.Y should be 125", t)
 }
 
+func TestReduction(t *testing.T) {
+	jp2 := jp2i()
+	jp2.SetCrop(image.Rect(200, 100, 500, 400))
+	jp2.SetReduction(1)
+	assert.Equal(1, jp2.computeProgressionLevel(), "reduction forces a lower level at full size", t)
+	i, err := jp2.DecodeImage()
+	assert.Equal(err, nil, "No error decoding jp2", t)
+	assert.Equal(300, i.Bounds().Max.X, "Max.X should be scaled back up to 300", t)
+	assert.Equal(300, i.Bounds().Max.Y, "Max.Y should be scaled back up to 300", t)
+}
+
 // BenchmarkReadAndDecodeImage does a benchmark against every step of the
 // process to simulate the parts of a tile request controlled by the openjpeg
 // package: loading the JP2, setting the crop and resize, and decoding to a raw
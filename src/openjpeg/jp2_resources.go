@@ -4,13 +4,30 @@
 // #include <openjpeg.h>
 // #include <stdlib.h>
 // #include "handlers.h"
+// #include "stream.h"
 import "C"
 
 import (
+	"context"
 	"fmt"
+	"runtime/cgo"
 	"unsafe"
 )
 
+// DecodeThreads is the number of threads openjpeg may use to decode a single
+// request for an untiled JP2.  Such images are decoded as one huge tile, so
+// spreading the code blocks across threads can cut response times for large
+// regions dramatically.  Tiled JP2s are always decoded on one thread, since
+// typical tile requests are small and concurrent requests keep all CPUs busy.
+var DecodeThreads = 1
+
+// MapFiles tells the decoder to read JP2s through a read-only memory map
+// rather than stdio.  The kernel's page cache then serves the codestream
+// directly, without each decode copying it through its own buffers.  Files
+// on network filesystems can be unsafe to map: if one is truncated while a
+// request is decoding it, the server crashes.
+var MapFiles = false
+
 // rawDecode runs the low-level operations necessary to actually get the
 // desired tile/resized image
 func (i *JP2Image) rawDecode() (jp2 *C.opj_image_t, err error) {
@@ -20,32 +37,48 @@ func (i *JP2Image) rawDecode() (jp2 *C.opj_image_t, err error) {
 
 	// Calculate cp_reduce - this seems smarter to put in a parameter than to call an extra function
 	parameters.cp_reduce = C.OPJ_UINT32(i.computeProgressionLevel())
+	parameters.cp_layer = C.OPJ_UINT32(i.computeLayers())
+
+	// Connect our warning/error handlers to this image's logger.  The handle
+	// is deleted last, after the codec and stream are destroyed.
+	var h = cgo.NewHandle(i.logger())
+	defer h.Delete()
 
 	// Setup file stream
-	stream, err := initializeStream(i.filename)
+	stream, stop, err := i.initializeStream()
 	if err != nil {
 		return jp2, err
 	}
 	defer C.opj_stream_destroy(stream)
+	defer stop()
 
 	// Create codec
 	codec := C.opj_create_decompress(C.OPJ_CODEC_JP2)
 	defer C.opj_destroy_codec(codec)
 
-	// Connect our info/warning/error handlers
-	C.set_handlers(codec)
+	C.set_handlers(codec, C.uintptr_t(h))
 
 	// Fill in codec configuration from parameters
 	if C.opj_setup_decoder(codec, &parameters) == C.OPJ_FALSE {
 		return jp2, fmt.Errorf("unable to setup decoder")
 	}
 
+	// Threads have to be set after setup but before the header is read
+	if DecodeThreads > 1 && i.untiled() {
+		if C.opj_codec_set_threads(codec, C.int(DecodeThreads)) == C.OPJ_FALSE {
+			i.logger().Warnf("Unable to decode %q using %d threads", i.filename, DecodeThreads)
+		}
+	}
+
 	// Read the header to set up the image data
 	if C.opj_read_header(stream, codec, &jp2) == C.OPJ_FALSE {
 		return jp2, fmt.Errorf("failed to read the header")
 	}
 
-	// Set the decode area if it isn't the full image
+	// Set the decode area if it isn't the full image.  On openjpeg 2.3 and
+	// later, this limits decoding to the code blocks which intersect the area,
+	// even within a single tile, so small regions of huge untiled images don't
+	// require decoding the full frame.
 	if i.decodeArea != i.srcRect {
 		r := i.decodeArea
 		if C.opj_set_decode_area(codec, jp2, C.OPJ_INT32(r.Min.X), C.OPJ_INT32(r.Min.Y), C.OPJ_INT32(r.Max.X), C.OPJ_INT32(r.Max.Y)) == C.OPJ_FALSE {
@@ -61,13 +94,78 @@ func (i *JP2Image) rawDecode() (jp2 *C.opj_image_t, err error) {
 	return jp2, nil
 }
 
-func initializeStream(filename string) (*C.opj_stream_t, error) {
-	cFilename := C.CString(filename)
+// initializeStream opens the image's file for decoding.  If the image has a
+// context which can be canceled, the stream stops reading as soon as the
+// context is done.  openjpeg reads the codestream a tile at a time, so this
+// stops a decode between tiles; a single huge tile still has to finish.  The
+// returned stop function must be called before the stream is destroyed.
+func (i *JP2Image) initializeStream() (stream *C.opj_stream_t, stop func(), err error) {
+	cFilename := C.CString(i.filename)
 	defer C.free(unsafe.Pointer(cFilename))
 
-	stream := C.opj_stream_create_default_file_stream(cFilename, 1)
+	stop = func() {}
+	if MapFiles {
+		var m, err = mapFile(i.filename)
+		if err == nil {
+			return i.initializeMappedStream(m)
+		}
+		i.logger().Debugf("Unable to map %q; reading it as a file: %s", i.filename, err)
+	}
+
+	if i.ctx == nil || i.ctx.Done() == nil {
+		stream = C.opj_stream_create_default_file_stream(cFilename, 1)
+		if stream == nil {
+			return nil, stop, fmt.Errorf("failed to create stream in %#v", i.filename)
+		}
+		return stream, stop, nil
+	}
+
+	var data *C.stream_data
+	stream = C.create_cancelable_stream(cFilename, &data)
 	if stream == nil {
-		return nil, fmt.Errorf("failed to create stream in %#v", filename)
+		return nil, stop, fmt.Errorf("failed to create stream in %#v", i.filename)
+	}
+	return stream, watchContext(i.ctx, data), nil
+}
+
+// initializeMappedStream creates a stream over the mapped file m, which is
+// unmapped by the returned stop function
+func (i *JP2Image) initializeMappedStream(m *mappedFile) (stream *C.opj_stream_t, stop func(), err error) {
+	var data *C.stream_data
+	stream = C.create_memory_stream((*C.uchar)(unsafe.Pointer(&m.data[0])), C.OPJ_UINT64(len(m.data)), &data)
+	if stream == nil {
+		m.Close()
+		return nil, func() {}, fmt.Errorf("failed to create stream in %#v", i.filename)
+	}
+
+	stop = func() { m.Close() }
+	if i.ctx != nil && i.ctx.Done() != nil {
+		var unwatch = watchContext(i.ctx, data)
+		stop = func() {
+			unwatch()
+			m.Close()
+		}
+	}
+	return stream, stop, nil
+}
+
+// watchContext cancels the stream when ctx is done.  The returned function
+// stops watching, and doesn't return until the watcher is finished with
+// data, so the stream can then be safely destroyed.
+func watchContext(ctx context.Context, data *C.stream_data) func() {
+	var done = make(chan struct{})
+	var finished = make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			C.cancel_stream(data)
+		case <-done:
+		}
+		close(finished)
+	}()
+
+	return func() {
+		close(done)
+		<-finished
 	}
-	return stream, nil
 }
@@ -5,6 +5,8 @@
 import "C"
 
 import (
+	"rais/src/requestid"
+	"runtime/cgo"
 	"strings"
 
 	"github.com/uoregon-libraries/gopkg/logger"
@@ -15,16 +17,23 @@
 // command)
 var Logger = logger.Named("rais/openjpeg", logger.Debug)
 
-// GoLogWarning bridges the openjpeg logging with our internal logger
+// logger returns the logger for this image's messages, which are tagged with
+// the request id if the image's context has one
+func (i *JP2Image) logger() *logger.Logger {
+	return requestid.Logger(i.ctx, Logger)
+}
+
+// GoLogWarning bridges the openjpeg logging with our internal logger.  h is
+// the cgo.Handle of the logger for the image being decoded.
 //export GoLogWarning
-func GoLogWarning(cmessage *C.char) {
-	log(Logger.Warnf, cmessage)
+func GoLogWarning(h C.uintptr_t, cmessage *C.char) {
+	log(cgo.Handle(h).Value().(*logger.Logger).Warnf, cmessage)
 }
 
 // GoLogError bridges the openjpeg logging with our internal logger
 //export GoLogError
-func GoLogError(cmessage *C.char) {
-	log(Logger.Errorf, cmessage)
+func GoLogError(h C.uintptr_t, cmessage *C.char) {
+	log(cgo.Handle(h).Value().(*logger.Logger).Errorf, cmessage)
 }
 
 // Internal go-specific version of logger
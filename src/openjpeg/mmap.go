@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package openjpeg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mappedFile is an io.ReadSeeker over a read-only memory map of a file.  The
+// kernel pages the codestream in as openjpeg reads it, and shares those pages
+// between requests for the same file, rather than each decode copying the
+// data through its own buffers.
+type mappedFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+// mapFile maps the named file into memory.  Empty files can't be mapped, and
+// return an error.
+func mapFile(filename string) (*mappedFile, error) {
+	var f, err = os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var fi os.FileInfo
+	fi, err = f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var size = fi.Size()
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errors.New("file size can't be mapped")
+	}
+
+	var data []byte
+	data, err = syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &mappedFile{Reader: bytes.NewReader(data), data: data}, nil
+}
+
+// Close unmaps the file.  Nothing may read from it afterward, including
+// openjpeg streams created over its data.
+func (m *mappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	var err = syscall.Munmap(m.data)
+	m.data = nil
+	m.Reader = bytes.NewReader(nil)
+	return err
+}
@@ -0,0 +1,50 @@
+package openjpeg
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestMappedFile(t *testing.T) {
+	var dir, _ = os.Getwd()
+	var fname = dir + "/../../docker/images/testfile/test-world.jp2"
+	var want, err = ioutil.ReadFile(fname)
+	assert.NilError(err, "reading the test file", t)
+
+	var m *mappedFile
+	m, err = mapFile(fname)
+	assert.NilError(err, "mapping the test file", t)
+
+	var got []byte
+	got, err = ioutil.ReadAll(m)
+	assert.NilError(err, "reading the map", t)
+	assert.True(bytes.Equal(want, got), "the map holds the file's contents", t)
+
+	var pos int64
+	pos, err = m.Seek(-16, io.SeekEnd)
+	assert.NilError(err, "seeking", t)
+	assert.Equal(int64(len(want)-16), pos, "seek position", t)
+	var buf = make([]byte, 32)
+	var n, _ = m.Read(buf)
+	assert.Equal(16, n, "reads stop at the end of the file", t)
+
+	assert.NilError(m.Close(), "unmapping", t)
+	assert.NilError(m.Close(), "closing twice is harmless", t)
+	n, _ = m.Read(buf)
+	assert.Equal(0, n, "nothing can be read after closing", t)
+}
+
+func TestMapEmptyFile(t *testing.T) {
+	var f, err = ioutil.TempFile("", "rais-mmap")
+	assert.NilError(err, "creating a temp file", t)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, err = mapFile(f.Name())
+	assert.True(err != nil, "empty files can't be mapped", t)
+}
@@ -0,0 +1,22 @@
+package openjpeg
+
+import (
+	"bytes"
+	"errors"
+)
+
+// mappedFile is unused on Windows
+type mappedFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+// mapFile always fails, so images are read through regular file streams
+func mapFile(filename string) (*mappedFile, error) {
+	return nil, errors.New("memory-mapped files aren't supported on Windows")
+}
+
+// Close does nothing
+func (m *mappedFile) Close() error {
+	return nil
+}
@@ -0,0 +1,145 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <openjpeg.h>
+#include "stream.h"
+
+// is_canceled and cancel_stream use atomics since a stream is canceled from
+// a different thread than the one decoding it
+static int is_canceled(stream_data *d) {
+	return __atomic_load_n(&d->canceled, __ATOMIC_SEQ_CST);
+}
+
+void cancel_stream(stream_data *d) {
+	__atomic_store_n(&d->canceled, 1, __ATOMIC_SEQ_CST);
+}
+
+// read_fn reports end-of-stream once the stream is canceled, which openjpeg
+// treats as a truncated file, failing the decode
+static OPJ_SIZE_T read_fn(void *buf, OPJ_SIZE_T n, void *user_data) {
+	stream_data *d = (stream_data *)user_data;
+	if (is_canceled(d)) {
+		return (OPJ_SIZE_T)-1;
+	}
+
+	OPJ_SIZE_T read = fread(buf, 1, n, d->fp);
+	return read ? read : (OPJ_SIZE_T)-1;
+}
+
+static OPJ_OFF_T skip_fn(OPJ_OFF_T n, void *user_data) {
+	stream_data *d = (stream_data *)user_data;
+	if (is_canceled(d) || fseeko(d->fp, n, SEEK_CUR) != 0) {
+		return -1;
+	}
+	return n;
+}
+
+static OPJ_BOOL seek_fn(OPJ_OFF_T n, void *user_data) {
+	stream_data *d = (stream_data *)user_data;
+	if (is_canceled(d) || fseeko(d->fp, n, SEEK_SET) != 0) {
+		return OPJ_FALSE;
+	}
+	return OPJ_TRUE;
+}
+
+static void free_fn(void *user_data) {
+	stream_data *d = (stream_data *)user_data;
+	fclose(d->fp);
+	free(d);
+}
+
+// create_cancelable_stream opens fname as an openjpeg read stream which can
+// be stopped via cancel_stream.  The stream data is freed when the stream is
+// destroyed.
+opj_stream_t *create_cancelable_stream(const char *fname, stream_data **data) {
+	FILE *fp = fopen(fname, "rb");
+	if (fp == NULL) {
+		return NULL;
+	}
+
+	OPJ_UINT64 length = 0;
+	if (fseeko(fp, 0, SEEK_END) == 0) {
+		length = (OPJ_UINT64)ftello(fp);
+	}
+	fseeko(fp, 0, SEEK_SET);
+
+	opj_stream_t *stream = opj_stream_default_create(OPJ_TRUE);
+	if (stream == NULL) {
+		fclose(fp);
+		return NULL;
+	}
+
+	stream_data *d = (stream_data *)calloc(1, sizeof(stream_data));
+	d->fp = fp;
+
+	opj_stream_set_read_function(stream, read_fn);
+	opj_stream_set_skip_function(stream, skip_fn);
+	opj_stream_set_seek_function(stream, seek_fn);
+	opj_stream_set_user_data(stream, d, free_fn);
+	opj_stream_set_user_data_length(stream, length);
+
+	*data = d;
+	return stream;
+}
+
+// The mem_* functions read from a caller-owned buffer, such as a memory-mapped
+// file, so the codestream is never copied into stdio's buffers
+
+static OPJ_SIZE_T mem_read_fn(void *buf, OPJ_SIZE_T n, void *user_data) {
+	stream_data *d = (stream_data *)user_data;
+	if (is_canceled(d) || d->pos >= d->len) {
+		return (OPJ_SIZE_T)-1;
+	}
+
+	if (n > d->len - d->pos) {
+		n = (OPJ_SIZE_T)(d->len - d->pos);
+	}
+	memcpy(buf, d->buf + d->pos, n);
+	d->pos += n;
+	return n;
+}
+
+static OPJ_OFF_T mem_skip_fn(OPJ_OFF_T n, void *user_data) {
+	stream_data *d = (stream_data *)user_data;
+	if (is_canceled(d) || (n < 0 && (OPJ_UINT64)(-n) > d->pos)) {
+		return -1;
+	}
+	d->pos += n;
+	return n;
+}
+
+static OPJ_BOOL mem_seek_fn(OPJ_OFF_T n, void *user_data) {
+	stream_data *d = (stream_data *)user_data;
+	if (is_canceled(d) || n < 0) {
+		return OPJ_FALSE;
+	}
+	d->pos = (OPJ_UINT64)n;
+	return OPJ_TRUE;
+}
+
+static void mem_free_fn(void *user_data) {
+	free(user_data);
+}
+
+// create_memory_stream returns an openjpeg read stream over the len bytes at
+// buf, which can be stopped via cancel_stream.  buf must stay valid until the
+// stream is destroyed, but isn't freed with it.
+opj_stream_t *create_memory_stream(const unsigned char *buf, OPJ_UINT64 len, stream_data **data) {
+	opj_stream_t *stream = opj_stream_default_create(OPJ_TRUE);
+	if (stream == NULL) {
+		return NULL;
+	}
+
+	stream_data *d = (stream_data *)calloc(1, sizeof(stream_data));
+	d->buf = buf;
+	d->len = len;
+
+	opj_stream_set_read_function(stream, mem_read_fn);
+	opj_stream_set_skip_function(stream, mem_skip_fn);
+	opj_stream_set_seek_function(stream, mem_seek_fn);
+	opj_stream_set_user_data(stream, d, mem_free_fn);
+	opj_stream_set_user_data_length(stream, len);
+
+	*data = d;
+	return stream;
+}
@@ -0,0 +1,16 @@
+#include <stdio.h>
+#include <openjpeg.h>
+
+// stream_data is the user data for a cancelable stream.  File streams read
+// from fp; memory streams read len bytes from buf, which the caller owns.
+typedef struct {
+	FILE *fp;
+	const unsigned char *buf;
+	OPJ_UINT64 len;
+	OPJ_UINT64 pos;
+	int canceled;
+} stream_data;
+
+extern opj_stream_t *create_cancelable_stream(const char *fname, stream_data **data);
+extern opj_stream_t *create_memory_stream(const unsigned char *buf, OPJ_UINT64 len, stream_data **data);
+extern void cancel_stream(stream_data *data);
@@ -0,0 +1,38 @@
+package openjpeg
+
+// #cgo pkg-config: libopenjp2
+// #include <openjpeg.h>
+import "C"
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Version returns the version of the openjpeg library RAIS is linked against
+func Version() string {
+	return C.GoString(C.opj_version())
+}
+
+// SupportsRegionDecode returns true if the linked openjpeg only decodes the
+// code blocks intersecting the decode area.  Older versions decode the whole
+// tile, which for untiled JP2s means the whole image, no matter how small the
+// requested region is.
+func SupportsRegionDecode() bool {
+	return versionAtLeast(Version(), 2, 3)
+}
+
+// versionAtLeast returns true if v, a dotted version string such as "2.3.1",
+// is at least major.minor
+func versionAtLeast(v string, major, minor int) bool {
+	var parts = strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	var vmajor, err1 = strconv.Atoi(parts[0])
+	var vminor, err2 = strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return vmajor > major || (vmajor == major && vminor >= minor)
+}
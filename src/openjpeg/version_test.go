@@ -0,0 +1,16 @@
+package openjpeg
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(versionAtLeast("2.3.0", 2, 3), "2.3.0 >= 2.3", t)
+	assert.True(versionAtLeast("2.5.2", 2, 3), "2.5.2 >= 2.3", t)
+	assert.True(versionAtLeast("3.0", 2, 3), "3.0 >= 2.3", t)
+	assert.False(versionAtLeast("2.1.2", 2, 3), "2.1.2 < 2.3", t)
+	assert.False(versionAtLeast("1.5", 2, 3), "1.5 < 2.3", t)
+	assert.False(versionAtLeast("garbage", 2, 3), "unparseable versions fail", t)
+}
@@ -0,0 +1,159 @@
+// Package pdf wraps a JPEG in a minimal single-page PDF.  PDF readers can
+// display JPEG data as-is, so the image is embedded without re-encoding and
+// the page is exactly the size of the image.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// Info is the document information shown in a PDF reader's properties
+// dialog.  Empty fields are left out.
+type Info struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+	Creator  string
+	Producer string
+}
+
+// entries returns the info dictionary's keys and values in a stable order
+func (i Info) entries() [][2]string {
+	var list [][2]string
+	for _, e := range [][2]string{
+		{"Title", i.Title},
+		{"Author", i.Author},
+		{"Subject", i.Subject},
+		{"Keywords", i.Keywords},
+		{"Creator", i.Creator},
+		{"Producer", i.Producer},
+	} {
+		if e[1] != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// writer tracks the byte offset of each object, which the PDF's
+// cross-reference table has to list
+type writer struct {
+	w       io.Writer
+	n       int64
+	offsets []int64
+	err     error
+}
+
+func (pw *writer) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	var n int
+	n, pw.err = fmt.Fprintf(pw.w, format, args...)
+	pw.n += int64(n)
+}
+
+func (pw *writer) write(data []byte) {
+	if pw.err != nil {
+		return
+	}
+	var n int
+	n, pw.err = pw.w.Write(data)
+	pw.n += int64(n)
+}
+
+// object starts the next numbered object
+func (pw *writer) object() {
+	pw.offsets = append(pw.offsets, pw.n)
+	pw.printf("%d 0 obj\n", len(pw.offsets))
+}
+
+// Write sends a PDF to w with a single page showing the JPEG in data.  Each
+// pixel is one point (1/72 inch) on the page.
+func Write(w io.Writer, data []byte, info Info) error {
+	var cfg, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid JPEG: %s", err)
+	}
+	var colorSpace = "/DeviceRGB"
+	switch cfg.ColorModel {
+	case color.GrayModel:
+		colorSpace = "/DeviceGray"
+	case color.CMYKModel:
+		// CMYK JPEGs almost always come from Adobe software, which stores the
+		// values inverted
+		colorSpace = "/DeviceCMYK /Decode [1 0 1 0 1 0 1 0]"
+	}
+
+	var pw = &writer{w: w}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	pw.object()
+	pw.printf("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+
+	pw.object()
+	pw.printf("<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n")
+
+	pw.object()
+	pw.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] ", cfg.Width, cfg.Height)
+	pw.printf("/Resources << /XObject << /Im0 4 0 R >> /ProcSet [/PDF /ImageB /ImageC] >> /Contents 5 0 R >>\nendobj\n")
+
+	pw.object()
+	pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s ", cfg.Width, cfg.Height, colorSpace)
+	pw.printf("/BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n", len(data))
+	pw.write(data)
+	pw.printf("\nendstream\nendobj\n")
+
+	var content = fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", cfg.Width, cfg.Height)
+	pw.object()
+	pw.printf("<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(content), content)
+
+	pw.object()
+	pw.printf("<<")
+	for _, e := range info.entries() {
+		pw.printf(" /%s %s", e[0], textString(e[1]))
+	}
+	pw.printf(" >>\nendobj\n")
+
+	var xref = pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, off := range pw.offsets {
+		pw.printf("%010d 00000 n \n", off)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\n", len(pw.offsets)+1, len(pw.offsets))
+	pw.printf("startxref\n%d\n%%%%EOF\n", xref)
+
+	return pw.err
+}
+
+// textString encodes s as a PDF string.  Printable ASCII is written as a
+// literal string; anything else has to be UTF-16 with a byte order mark.
+func textString(s string) string {
+	var ascii = true
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			ascii = false
+			break
+		}
+	}
+
+	if ascii {
+		var r = strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+		return "(" + r.Replace(s) + ")"
+	}
+
+	var buf strings.Builder
+	buf.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&buf, "%04X", u)
+	}
+	buf.WriteString(">")
+	return buf.String()
+}
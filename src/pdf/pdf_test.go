@@ -0,0 +1,62 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func jpegData(i image.Image, t *testing.T) []byte {
+	var buf bytes.Buffer
+	assert.NilError(jpeg.Encode(&buf, i, nil), "encoding JPEG", t)
+	return buf.Bytes()
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	var data = jpegData(image.NewRGBA(image.Rect(0, 0, 300, 200)), t)
+	var err = Write(&buf, data, Info{Title: "map (1)", Author: "Zoë"})
+	assert.NilError(err, "writing PDF", t)
+
+	var out = buf.Bytes()
+	assert.True(bytes.HasPrefix(out, []byte("%PDF-1.4\n")), "PDF header", t)
+	assert.True(bytes.HasSuffix(out, []byte("%%EOF\n")), "PDF trailer", t)
+	assert.True(bytes.Contains(out, []byte("/MediaBox [0 0 300 200]")), "page is the image's size", t)
+	assert.True(bytes.Contains(out, []byte("/ColorSpace /DeviceRGB")), "color JPEG", t)
+	assert.True(bytes.Contains(out, data), "JPEG is embedded as-is", t)
+	assert.True(bytes.Contains(out, []byte(`/Title (map \(1\))`)), "ASCII title", t)
+	assert.True(bytes.Contains(out, []byte("/Author <FEFF005A006F00EB>")), "non-ASCII author", t)
+
+	// Every cross-reference entry has to point at its object
+	var m = regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	assert.True(m != nil, "startxref is present", t)
+	var xref, _ = strconv.Atoi(string(m[1]))
+	assert.True(bytes.HasPrefix(out[xref:], []byte("xref\n0 7\n")), "startxref points at the xref table", t)
+	var entries = regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	assert.Equal(6, len(entries), "one entry per object", t)
+	for i, e := range entries {
+		var off, _ = strconv.Atoi(string(e[1]))
+		var want = fmt.Sprintf("%d 0 obj\n", i+1)
+		assert.True(bytes.HasPrefix(out[off:], []byte(want)), "offset of "+want, t)
+	}
+}
+
+func TestWriteGray(t *testing.T) {
+	var buf bytes.Buffer
+	var err = Write(&buf, jpegData(image.NewGray(image.Rect(0, 0, 10, 10)), t), Info{})
+	assert.NilError(err, "writing PDF", t)
+	assert.True(bytes.Contains(buf.Bytes(), []byte("/ColorSpace /DeviceGray")), "grayscale JPEG", t)
+}
+
+func TestWriteInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	var err = Write(&buf, []byte("not a jpeg"), Info{})
+	assert.True(err != nil, "non-JPEG data is rejected", t)
+	assert.Equal(0, buf.Len(), "nothing is written", t)
+}
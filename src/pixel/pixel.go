@@ -0,0 +1,45 @@
+// Package pixel holds the hot loops for turning decoder output into Go image
+// data.  The exported functions are plain Go, and dispatch as much of their
+// work as they can to architecture-specific code; the pure-Go versions handle
+// whatever is left over, and everything on architectures without their own
+// implementation.
+package pixel
+
+// Narrow copies src into dst, truncating each value to eight bits.  This is
+// how openjpeg's 32-bit component data becomes a gray image's pixels.  dst
+// must be at least as long as src.
+func Narrow(dst []uint8, src []int32) {
+	dst = dst[:len(src)]
+	var n = narrowBlocks(dst, src)
+	narrowGeneric(dst[n:], src[n:])
+}
+
+// InterleaveRGBA fills dst with RGBA pixels built from the given red, green,
+// and blue components, truncating each to eight bits and setting alpha to
+// 255.  g and b must be at least as long as r, and dst at least four times
+// as long.
+func InterleaveRGBA(dst []uint8, r, g, b []int32) {
+	dst = dst[:len(r)*4]
+	g, b = g[:len(r)], b[:len(r)]
+	var n = interleaveRGBABlocks(dst, r, g, b)
+	interleaveRGBAGeneric(dst[n*4:], r[n:], g[n:], b[n:])
+}
+
+func narrowGeneric(dst []uint8, src []int32) {
+	dst = dst[:len(src)]
+	for i, v := range src {
+		dst[i] = uint8(v)
+	}
+}
+
+func interleaveRGBAGeneric(dst []uint8, r, g, b []int32) {
+	dst = dst[:len(r)*4]
+	g, b = g[:len(r)], b[:len(r)]
+	for i := range r {
+		var p = dst[i*4 : i*4+4 : i*4+4]
+		p[0] = uint8(r[i])
+		p[1] = uint8(g[i])
+		p[2] = uint8(b[i])
+		p[3] = 255
+	}
+}
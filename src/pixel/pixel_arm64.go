@@ -0,0 +1,35 @@
+//go:build arm64
+// +build arm64
+
+package pixel
+
+// blockSize is the number of pixels the NEON routines process per iteration
+const blockSize = 16
+
+//go:noescape
+func narrowNEON(dst *uint8, src *int32, blocks int)
+
+//go:noescape
+func interleaveRGBANEON(dst *uint8, red, green, blue *int32, blocks int)
+
+// narrowBlocks narrows as many whole blocks of src as it can with NEON
+// instructions, returning the number of values it handled
+func narrowBlocks(dst []uint8, src []int32) int {
+	var blocks = len(src) / blockSize
+	if blocks == 0 {
+		return 0
+	}
+	narrowNEON(&dst[0], &src[0], blocks)
+	return blocks * blockSize
+}
+
+// interleaveRGBABlocks interleaves as many whole blocks of pixels as it can
+// with NEON instructions, returning the number of pixels it handled
+func interleaveRGBABlocks(dst []uint8, r, g, b []int32) int {
+	var blocks = len(r) / blockSize
+	if blocks == 0 {
+		return 0
+	}
+	interleaveRGBANEON(&dst[0], &r[0], &g[0], &b[0], blocks)
+	return blocks * blockSize
+}
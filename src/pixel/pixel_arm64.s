@@ -0,0 +1,58 @@
+//go:build arm64
+// +build arm64
+
+#include "textflag.h"
+
+// Each block loads 16 int32s per component and packs their low bytes into
+// one vector register.  UZP1 keeps the even-numbered lanes of its two inputs,
+// so one pass on halfwords leaves each int32's low 16 bits, and a second on
+// bytes leaves the low eight.
+
+// func narrowNEON(dst *uint8, src *int32, blocks int)
+TEXT ·narrowNEON(SB), NOSPLIT, $0-24
+	MOVD dst+0(FP), R0
+	MOVD src+8(FP), R1
+	MOVD blocks+16(FP), R2
+
+narrowLoop:
+	VLD1.P 64(R1), [V0.S4, V1.S4, V2.S4, V3.S4]
+	VUZP1  V1.H8, V0.H8, V4.H8
+	VUZP1  V3.H8, V2.H8, V5.H8
+	VUZP1  V5.B16, V4.B16, V16.B16
+	VST1.P [V16.B16], 16(R0)
+	SUBS   $1, R2, R2
+	BNE    narrowLoop
+	RET
+
+// func interleaveRGBANEON(dst *uint8, red, green, blue *int32, blocks int)
+TEXT ·interleaveRGBANEON(SB), NOSPLIT, $0-40
+	MOVD dst+0(FP), R0
+	MOVD red+8(FP), R1
+	MOVD green+16(FP), R2
+	MOVD blue+24(FP), R3
+	MOVD blocks+32(FP), R4
+
+	// Alpha is always opaque
+	VMOVI $255, V19.B16
+
+interleaveLoop:
+	VLD1.P 64(R1), [V0.S4, V1.S4, V2.S4, V3.S4]
+	VUZP1  V1.H8, V0.H8, V4.H8
+	VUZP1  V3.H8, V2.H8, V5.H8
+	VUZP1  V5.B16, V4.B16, V16.B16
+
+	VLD1.P 64(R2), [V0.S4, V1.S4, V2.S4, V3.S4]
+	VUZP1  V1.H8, V0.H8, V4.H8
+	VUZP1  V3.H8, V2.H8, V5.H8
+	VUZP1  V5.B16, V4.B16, V17.B16
+
+	VLD1.P 64(R3), [V0.S4, V1.S4, V2.S4, V3.S4]
+	VUZP1  V1.H8, V0.H8, V4.H8
+	VUZP1  V3.H8, V2.H8, V5.H8
+	VUZP1  V5.B16, V4.B16, V18.B16
+
+	// ST4 stores the four registers' bytes interleaved: r0 g0 b0 a0 r1 ...
+	VST4.P [V16.B16, V17.B16, V18.B16, V19.B16], 64(R0)
+	SUBS   $1, R4, R4
+	BNE    interleaveLoop
+	RET
@@ -0,0 +1,16 @@
+//go:build !arm64
+// +build !arm64
+
+package pixel
+
+// narrowBlocks has no fast path on this architecture, so everything is left
+// to narrowGeneric
+func narrowBlocks(dst []uint8, src []int32) int {
+	return 0
+}
+
+// interleaveRGBABlocks has no fast path on this architecture, so everything
+// is left to interleaveRGBAGeneric
+func interleaveRGBABlocks(dst []uint8, r, g, b []int32) int {
+	return 0
+}
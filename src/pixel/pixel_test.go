@@ -0,0 +1,55 @@
+package pixel
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// testData returns n int32s with a mix of values that don't fit in a byte, so
+// truncation is exercised along with the simple cases
+func testData(n int, seed int32) []int32 {
+	var data = make([]int32, n)
+	for i := range data {
+		data[i] = (int32(i)*37 + seed) % 300
+		if i%7 == 0 {
+			data[i] = -data[i]
+		}
+	}
+	return data
+}
+
+func TestNarrow(t *testing.T) {
+	// Lengths on either side of the fast paths' block size make sure the
+	// leftovers are handled
+	for _, n := range []int{0, 1, 15, 16, 17, 33, 1000} {
+		var src = testData(n, 5)
+		var dst = make([]uint8, n)
+		Narrow(dst, src)
+		for i, v := range src {
+			assert.Equal(uint8(v), dst[i], "narrowed value", t)
+		}
+	}
+}
+
+func TestInterleaveRGBA(t *testing.T) {
+	for _, n := range []int{0, 1, 15, 16, 17, 33, 1000} {
+		var r, g, b = testData(n, 1), testData(n, 2), testData(n, 3)
+		var dst = make([]uint8, n*4)
+		InterleaveRGBA(dst, r, g, b)
+		for i := 0; i < n; i++ {
+			var expected = []uint8{uint8(r[i]), uint8(g[i]), uint8(b[i]), 255}
+			assert.Equal(string(expected), string(dst[i*4:i*4+4]), "pixel data", t)
+		}
+	}
+}
+
+func BenchmarkInterleaveRGBA(b *testing.B) {
+	var n = 1024 * 1024
+	var r, g, bl = testData(n, 1), testData(n, 2), testData(n, 3)
+	var dst = make([]uint8, n*4)
+	b.SetBytes(int64(n * 4))
+	for i := 0; i < b.N; i++ {
+		InterleaveRGBA(dst, r, g, bl)
+	}
+}
@@ -0,0 +1,127 @@
+// This file creates a plugin which tells a CDN to drop its cached copies of
+// an image's tiles and info.json whenever RAIS expires the image (via the
+// admin purge API, a takedown, or another plugin's expiration), and to drop
+// everything when all caches are purged.  Without this, replacing a master
+// image leaves stale tiles at the edge until they expire on their own.
+//
+// Configuration uses these settings in rais.toml or the environment:
+//
+// - "CDNProvider" / RAIS_CDNPROVIDER: "fastly", "cloudflare", or
+//   "cloudfront"; if this isn't set, the plugin is disabled
+// - "CDNBaseURL" / RAIS_CDNBASEURL: the public URL of RAIS's IIIF endpoint
+//   as served by the CDN, e.g., "https://iiif.example.edu/iiif"
+// - "CDNAPIToken" / RAIS_CDNAPITOKEN: the API token for Fastly or Cloudflare
+// - "CDNServiceID" / RAIS_CDNSERVICEID: the Fastly service ID
+// - "CDNZoneID" / RAIS_CDNZONEID: the Cloudflare zone ID
+// - "CDNDistributionID" / RAIS_CDNDISTRIBUTIONID: the CloudFront distribution
+//   ID; AWS credentials come from the usual places, as with the s3-images
+//   plugin
+//
+// There's no way to list every derivative URL clients have requested for an
+// image, so each provider purges them as a group:
+//
+// - Fastly purges by surrogate key, which requires RAIS to send Surrogate-Key
+//   headers (see SurrogateKeys in cache-policy-example.toml)
+// - Cloudflare purges by URL prefix, which Cloudflare only offers on its
+//   Enterprise plans
+// - CloudFront creates an invalidation for a wildcard path
+//
+// Purges are sent in the background and failures are logged, since the
+// admin API shouldn't wait on (or fail because of) a third-party service.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l *logger.Logger
+
+// purger is implemented by each CDN provider
+type purger interface {
+	purgeID(id iiif.ID) error
+	purgeAll() error
+}
+
+var cdn purger
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapCachePurge}
+
+// Disabled lets the plugin manager know not to add this plugin's functions to
+// the global list unless sanity checks in Initialize() pass
+var Disabled = true
+
+// Initialize reads configuration and sets up the CDN client
+func Initialize() {
+	var c = plugins.NewConfig("CDN")
+	var provider = c.GetString("Provider")
+	if provider == "" {
+		l.Infof("CDN purge plugin will not be enabled: CDNProvider must be set in rais.toml or RAIS_CDNPROVIDER must be set in the environment")
+		return
+	}
+
+	var base, err = url.Parse(strings.TrimRight(c.GetString("BaseURL"), "/"))
+	if err == nil && (base.Scheme == "" || base.Host == "") {
+		err = fmt.Errorf("scheme and host are required")
+	}
+	if err != nil {
+		l.Fatalf("CDN purge plugin failure: invalid CDNBaseURL %q: %s", c.GetString("BaseURL"), err)
+	}
+
+	switch provider {
+	case "fastly":
+		cdn, err = newFastly(c.GetString("APIToken"), c.GetString("ServiceID"))
+	case "cloudflare":
+		cdn, err = newCloudflare(c.GetString("APIToken"), c.GetString("ZoneID"), base)
+	case "cloudfront":
+		cdn, err = newCloudFront(c.GetString("DistributionID"), base)
+	default:
+		err = fmt.Errorf("unknown provider %q", provider)
+	}
+	if err != nil {
+		l.Fatalf("CDN purge plugin failure: %s", err)
+	}
+
+	l.Debugf("Sending %s purges for %q", provider, base)
+	Disabled = false
+}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// ExpireCachedImage asks the CDN to purge all cached responses for id
+func ExpireCachedImage(id iiif.ID) {
+	go func() {
+		var err = cdn.purgeID(id)
+		if err != nil {
+			l.Errorf("cdn-purge plugin: unable to purge %q: %s", id, err)
+			return
+		}
+		l.Infof("cdn-purge plugin: purged %q", id)
+	}()
+}
+
+// PurgeCaches asks the CDN to purge everything RAIS has served
+func PurgeCaches() {
+	go func() {
+		var err = cdn.purgeAll()
+		if err != nil {
+			l.Errorf("cdn-purge plugin: unable to purge all images: %s", err)
+			return
+		}
+		l.Infof("cdn-purge plugin: purged all images")
+	}()
+}
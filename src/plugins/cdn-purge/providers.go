@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"rais/src/iiif"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// do sends an API request and returns an error for any non-2xx response
+func do(method, u string, body io.Reader, header http.Header) error {
+	var req, err = http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	var resp *http.Response
+	resp, err = client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var msg, _ = ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// fastly purges by surrogate key
+type fastly struct {
+	api     string
+	token   string
+	service string
+}
+
+func newFastly(token, service string) (*fastly, error) {
+	if token == "" || service == "" {
+		return nil, errors.New("fastly requires CDNAPIToken and CDNServiceID")
+	}
+	return &fastly{api: "https://api.fastly.com", token: token, service: service}, nil
+}
+
+func (f *fastly) post(path string) error {
+	var h = http.Header{"Fastly-Key": {f.token}, "Accept": {"application/json"}}
+	return do("POST", f.api+"/service/"+url.PathEscape(f.service)+path, nil, h)
+}
+
+func (f *fastly) purgeID(id iiif.ID) error {
+	return f.post("/purge/" + id.SurrogateKey())
+}
+
+func (f *fastly) purgeAll() error {
+	return f.post("/purge/rais")
+}
+
+// cloudflare purges by URL prefix
+type cloudflare struct {
+	api   string
+	token string
+	zone  string
+	base  *url.URL
+}
+
+func newCloudflare(token, zone string, base *url.URL) (*cloudflare, error) {
+	if token == "" || zone == "" {
+		return nil, errors.New("cloudflare requires CDNAPIToken and CDNZoneID")
+	}
+	return &cloudflare{api: "https://api.cloudflare.com/client/v4", token: token, zone: zone, base: base}, nil
+}
+
+func (cf *cloudflare) purge(data interface{}) error {
+	var body, err = json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var h = http.Header{"Authorization": {"Bearer " + cf.token}, "Content-Type": {"application/json"}}
+	return do("POST", cf.api+"/zones/"+url.PathEscape(cf.zone)+"/purge_cache", bytes.NewReader(body), h)
+}
+
+// purgeID purges the image's base URI as well as every URL under it;
+// Cloudflare prefixes don't include the scheme
+func (cf *cloudflare) purgeID(id iiif.ID) error {
+	var prefix = cf.base.Host + cf.base.Path + "/" + id.Escaped()
+	return cf.purge(map[string][]string{"prefixes": {prefix + "/"}})
+}
+
+func (cf *cloudflare) purgeAll() error {
+	return cf.purge(map[string][]string{"prefixes": {cf.base.Host + cf.base.Path + "/"}})
+}
+
+// cloudFront purges by creating invalidations
+type cloudFront struct {
+	svc          *cloudfront.CloudFront
+	distribution string
+	base         *url.URL
+}
+
+func newCloudFront(distribution string, base *url.URL) (*cloudFront, error) {
+	if distribution == "" {
+		return nil, errors.New("cloudfront requires CDNDistributionID")
+	}
+	var sess, err = session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return &cloudFront{svc: cloudfront.New(sess), distribution: distribution, base: base}, nil
+}
+
+func (cf *cloudFront) invalidate(path string) error {
+	var _, err = cf.svc.CreateInvalidation(&cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(cf.distribution),
+		InvalidationBatch: &cloudfront.InvalidationBatch{
+			CallerReference: aws.String("rais-" + strconv.FormatInt(time.Now().UnixNano(), 10)),
+			Paths: &cloudfront.Paths{
+				Quantity: aws.Int64(1),
+				Items:    []*string{aws.String(path)},
+			},
+		},
+	})
+	return err
+}
+
+func (cf *cloudFront) purgeID(id iiif.ID) error {
+	return cf.invalidate(cf.base.Path + "/" + id.Escaped() + "/*")
+}
+
+func (cf *cloudFront) purgeAll() error {
+	return cf.invalidate(cf.base.Path + "/*")
+}
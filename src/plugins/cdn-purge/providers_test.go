@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+type capture struct {
+	path, body string
+	header     http.Header
+}
+
+func server(c *capture, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body, _ = ioutil.ReadAll(r.Body)
+		c.path, c.body, c.header = r.URL.Path, string(body), r.Header
+		w.WriteHeader(status)
+	}))
+}
+
+func TestFastly(t *testing.T) {
+	var c capture
+	var srv = server(&c, 200)
+	defer srv.Close()
+
+	var f, _ = newFastly("tok", "svc")
+	f.api = srv.URL
+	var id = iiif.ID("maps/a b.jp2")
+	assert.NilError(f.purgeID(id), "purge", t)
+	assert.Equal("/service/svc/purge/"+id.SurrogateKey(), c.path, "purges by surrogate key", t)
+	assert.Equal("tok", c.header.Get("Fastly-Key"), "token is sent", t)
+}
+
+func TestCloudflare(t *testing.T) {
+	var c capture
+	var srv = server(&c, 200)
+	defer srv.Close()
+
+	var base, _ = url.Parse("https://iiif.example.edu/iiif")
+	var cf, _ = newCloudflare("tok", "zone", base)
+	cf.api = srv.URL
+	assert.NilError(cf.purgeID("maps/a.jp2"), "purge", t)
+	assert.Equal("/zones/zone/purge_cache", c.path, "zone purge endpoint", t)
+	assert.Equal(`{"prefixes":["iiif.example.edu/iiif/maps%2Fa.jp2/"]}`, c.body, "purges by prefix", t)
+	assert.Equal("Bearer tok", c.header.Get("Authorization"), "token is sent", t)
+}
+
+func TestAPIError(t *testing.T) {
+	var c capture
+	var srv = server(&c, 403)
+	defer srv.Close()
+
+	var f, _ = newFastly("tok", "svc")
+	f.api = srv.URL
+	assert.True(f.purgeAll() != nil, "non-2xx responses are errors", t)
+}
@@ -0,0 +1,109 @@
+package plugins
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var known = make(map[string]string)
+var knownMutex sync.RWMutex
+
+// Config is a namespaced view of the RAIS configuration.  Plugins should use
+// this rather than reading viper directly: a setting's full name is simply the
+// namespace followed by the key, so a Config with namespace "S3" reads "Cache"
+// from the "S3Cache" setting (RAIS_S3CACHE in the environment).  Every key
+// read this way is registered so RAIS can warn about settings nothing uses.
+type Config struct {
+	Namespace string
+}
+
+// NewConfig returns a Config for reading settings under the given namespace
+func NewConfig(namespace string) *Config {
+	return &Config{Namespace: namespace}
+}
+
+// Key returns the full setting name for the given key, registering it as a
+// known setting
+func (c *Config) Key(key string) string {
+	var name = c.Namespace + key
+	RegisterKeys(name)
+	return name
+}
+
+// SetDefault sets the value used when the setting isn't configured anywhere
+func (c *Config) SetDefault(key string, value interface{}) {
+	viper.SetDefault(c.Key(key), value)
+}
+
+// IsSet returns true if the setting has a value, including a default
+func (c *Config) IsSet(key string) bool {
+	return viper.IsSet(c.Key(key))
+}
+
+// GetString returns the setting's value as a string
+func (c *Config) GetString(key string) string {
+	return viper.GetString(c.Key(key))
+}
+
+// GetInt returns the setting's value as an int
+func (c *Config) GetInt(key string) int {
+	return viper.GetInt(c.Key(key))
+}
+
+// GetInt64 returns the setting's value as an int64
+func (c *Config) GetInt64(key string) int64 {
+	return viper.GetInt64(c.Key(key))
+}
+
+// GetFloat64 returns the setting's value as a float64
+func (c *Config) GetFloat64(key string) float64 {
+	return viper.GetFloat64(c.Key(key))
+}
+
+// GetBool returns the setting's value as a bool
+func (c *Config) GetBool(key string) bool {
+	return viper.GetBool(c.Key(key))
+}
+
+// GetDuration returns the setting's value as a time.Duration.  Strings use
+// Go's duration format ("90s", "5m", etc.), while bare integers are treated
+// as nanoseconds.
+func (c *Config) GetDuration(key string) time.Duration {
+	return viper.GetDuration(c.Key(key))
+}
+
+// RegisterKeys marks the given setting names as known.  RAIS registers its
+// core settings this way; plugins get this for free by using a Config.
+func RegisterKeys(names ...string) {
+	knownMutex.Lock()
+	for _, name := range names {
+		known[strings.ToLower(name)] = name
+	}
+	knownMutex.Unlock()
+}
+
+// IsKnownKey returns true if the setting name has been registered.  Setting
+// names are case-insensitive.
+func IsKnownKey(name string) bool {
+	knownMutex.RLock()
+	defer knownMutex.RUnlock()
+	return known[strings.ToLower(name)] != ""
+}
+
+// KnownKeys returns the names of all registered settings, as they were
+// registered, in alphabetical order
+func KnownKeys() []string {
+	knownMutex.RLock()
+	var names = make([]string, 0, len(known))
+	for _, name := range known {
+		names = append(names, name)
+	}
+	knownMutex.RUnlock()
+
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+	return names
+}
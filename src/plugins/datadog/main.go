@@ -22,8 +22,8 @@
 
 import (
 	"net/http"
+	"rais/src/plugins"
 
-	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/logger"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
@@ -32,15 +32,22 @@
 var l *logger.Logger
 var serviceName string
 
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapWrapHandler}
+
 // Disabled lets the plugin manager know not to add this plugin's functions to
 // the global list unless sanity checks in Initialize() pass
 var Disabled = true
 
 // Initialize reads configuration and sets up the datadog agent
 func Initialize() {
-	var ddaddr = viper.GetString("DatadogAddress")
-	viper.SetDefault("DatadogServiceName", "RAIS/datadog")
-	serviceName = viper.GetString("DatadogServiceName")
+	var c = plugins.NewConfig("Datadog")
+	var ddaddr = c.GetString("Address")
+	c.SetDefault("ServiceName", "RAIS/datadog")
+	serviceName = c.GetString("ServiceName")
 
 	if ddaddr == "" {
 		l.Warnf("DatadogAddress must be configured, or RAIS_DATADOGADDRESS must be set in the environment  **DataDog plugin is disabled**")
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Transfer syntaxes we can read: the three uncompressed encodings.  JPEG,
+// JPEG 2000, RLE, and other encapsulated pixel data aren't supported.
+const (
+	tsImplicitLE = "1.2.840.10008.1.2"
+	tsExplicitLE = "1.2.840.10008.1.2.1"
+	tsExplicitBE = "1.2.840.10008.1.2.2"
+)
+
+// undefinedLength marks sequences and items whose end is a delimiter tag
+// rather than a byte count
+const undefinedLength = 0xFFFFFFFF
+
+type tag uint32
+
+func mkTag(group, element uint16) tag {
+	return tag(uint32(group)<<16 | uint32(element))
+}
+
+var (
+	tagTransferSyntax   = mkTag(0x0002, 0x0010)
+	tagSamplesPerPixel  = mkTag(0x0028, 0x0002)
+	tagPhotometric      = mkTag(0x0028, 0x0004)
+	tagPlanarConfig     = mkTag(0x0028, 0x0006)
+	tagNumberOfFrames   = mkTag(0x0028, 0x0008)
+	tagRows             = mkTag(0x0028, 0x0010)
+	tagColumns          = mkTag(0x0028, 0x0011)
+	tagBitsAllocated    = mkTag(0x0028, 0x0100)
+	tagBitsStored       = mkTag(0x0028, 0x0101)
+	tagPixelRep         = mkTag(0x0028, 0x0103)
+	tagWindowCenter     = mkTag(0x0028, 0x1050)
+	tagWindowWidth      = mkTag(0x0028, 0x1051)
+	tagRescaleIntercept = mkTag(0x0028, 0x1052)
+	tagRescaleSlope     = mkTag(0x0028, 0x1053)
+	tagPixelData        = mkTag(0x7FE0, 0x0010)
+	tagItemDelim        = mkTag(0xFFFE, 0xE00D)
+	tagSequenceDelim    = mkTag(0xFFFE, 0xE0DD)
+)
+
+// longVRs are the explicit VRs with two reserved bytes and a 32-bit length
+var longVRs = map[string]bool{
+	"OB": true, "OD": true, "OF": true, "OL": true, "OV": true, "OW": true,
+	"SQ": true, "SV": true, "UC": true, "UN": true, "UR": true, "UT": true, "UV": true,
+}
+
+// header holds the parts of a DICOM file's data set needed to render its
+// pixels
+type header struct {
+	Rows, Columns   int
+	SamplesPerPixel int
+	Photometric     string
+	PlanarConfig    int
+	Frames          int
+	BitsAllocated   int
+	BitsStored      int
+	Signed          bool
+	BigEndian       bool
+
+	// Window is only valid if HasWindow is true
+	HasWindow                      bool
+	WindowCenter, WindowWidth      float64
+	RescaleSlope, RescaleIntercept float64
+
+	// PixelOffset and PixelLength locate the pixel data in the file
+	PixelOffset int64
+	PixelLength int64
+}
+
+// frameLength returns the number of bytes in a single frame
+func (h *header) frameLength() int64 {
+	return int64(h.Rows) * int64(h.Columns) * int64(h.SamplesPerPixel) * int64(h.BitsAllocated/8)
+}
+
+// dataReader reads DICOM elements, keeping track of its position in the file
+type dataReader struct {
+	r        *bufio.Reader
+	pos      int64
+	order    binary.ByteOrder
+	explicit bool
+}
+
+func (dr *dataReader) read(n int) ([]byte, error) {
+	var buf = make([]byte, n)
+	var _, err = io.ReadFull(dr.r, buf)
+	dr.pos += int64(n)
+	return buf, err
+}
+
+func (dr *dataReader) skip(n int64) error {
+	var skipped, err = io.CopyN(ioutil.Discard, dr.r, n)
+	dr.pos += skipped
+	return err
+}
+
+func (dr *dataReader) peek(n int) ([]byte, error) {
+	return dr.r.Peek(n)
+}
+
+// element reads the next element's tag, VR (empty for implicit VR data), and
+// value length
+func (dr *dataReader) element() (t tag, vr string, length uint32, err error) {
+	var buf []byte
+	buf, err = dr.read(4)
+	if err != nil {
+		return
+	}
+	t = mkTag(dr.order.Uint16(buf), dr.order.Uint16(buf[2:]))
+
+	// Item and delimiter tags never have a VR
+	if t>>16 == 0xFFFE || !dr.explicit {
+		buf, err = dr.read(4)
+		if err == nil {
+			length = dr.order.Uint32(buf)
+		}
+		return
+	}
+
+	buf, err = dr.read(2)
+	if err != nil {
+		return
+	}
+	vr = string(buf)
+	if longVRs[vr] {
+		buf, err = dr.read(6)
+		if err == nil {
+			length = dr.order.Uint32(buf[2:])
+		}
+		return
+	}
+	buf, err = dr.read(2)
+	if err == nil {
+		length = uint32(dr.order.Uint16(buf))
+	}
+	return
+}
+
+// skipUndefined skips past the contents of a sequence or item with an
+// undefined length, up to and including its delimiter
+func (dr *dataReader) skipUndefined() error {
+	for {
+		var t, _, length, err = dr.element()
+		if err != nil {
+			return err
+		}
+		if t == tagItemDelim || t == tagSequenceDelim {
+			return nil
+		}
+		if length == undefinedLength {
+			err = dr.skipUndefined()
+		} else {
+			err = dr.skip(int64(length))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readHeader reads a DICOM Part 10 file up to its pixel data
+func readHeader(r io.Reader) (*header, error) {
+	var dr = &dataReader{r: bufio.NewReader(r), order: binary.LittleEndian, explicit: true}
+	var pre, err = dr.read(132)
+	if err != nil || string(pre[128:]) != "DICM" {
+		return nil, errors.New("not a DICOM file")
+	}
+
+	var h = &header{SamplesPerPixel: 1, Frames: 1, RescaleSlope: 1}
+	var ts string
+	var inMeta = true
+	for {
+		// The file meta group is always explicit VR little endian; the data set
+		// which follows it uses the file's transfer syntax
+		if inMeta {
+			var next, err = dr.peek(2)
+			if err != nil {
+				return nil, fmt.Errorf("no pixel data: %s", err)
+			}
+			if binary.LittleEndian.Uint16(next) != 0x0002 {
+				inMeta = false
+				switch ts {
+				case tsImplicitLE:
+					dr.explicit = false
+				case tsExplicitLE:
+				case tsExplicitBE:
+					dr.order = binary.BigEndian
+					h.BigEndian = true
+				default:
+					return nil, fmt.Errorf("unsupported transfer syntax %q", ts)
+				}
+			}
+		}
+
+		var t, vr, length, err = dr.element()
+		if err != nil {
+			return nil, fmt.Errorf("no pixel data: %s", err)
+		}
+
+		if t == tagPixelData {
+			if length == undefinedLength {
+				return nil, errors.New("encapsulated pixel data isn't supported")
+			}
+			h.PixelOffset, h.PixelLength = dr.pos, int64(length)
+			return h, h.validate()
+		}
+
+		if length == undefinedLength {
+			if vr != "" && vr != "SQ" && vr != "UN" {
+				return nil, fmt.Errorf("invalid undefined length for %s element %08x", vr, uint32(t))
+			}
+			err = dr.skipUndefined()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !h.wants(t) {
+			err = dr.skip(int64(length))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Everything we read is a short number or string
+		if length > 1024 {
+			return nil, fmt.Errorf("invalid length %d for element %08x", length, uint32(t))
+		}
+		var val []byte
+		val, err = dr.read(int(length))
+		if err != nil {
+			return nil, err
+		}
+		if t == tagTransferSyntax {
+			ts = strings.TrimRight(string(val), "\x00 ")
+			continue
+		}
+		err = h.set(t, val, dr.order)
+		if err != nil {
+			return nil, fmt.Errorf("invalid element %08x: %s", uint32(t), err)
+		}
+	}
+}
+
+// wants returns true if t is an element the header needs
+func (h *header) wants(t tag) bool {
+	switch t {
+	case tagTransferSyntax, tagSamplesPerPixel, tagPhotometric, tagPlanarConfig,
+		tagNumberOfFrames, tagRows, tagColumns, tagBitsAllocated, tagBitsStored,
+		tagPixelRep, tagWindowCenter, tagWindowWidth, tagRescaleIntercept, tagRescaleSlope:
+		return true
+	}
+	return false
+}
+
+// set stores a single element's value
+func (h *header) set(t tag, val []byte, order binary.ByteOrder) error {
+	var us = func() int {
+		if len(val) < 2 {
+			return 0
+		}
+		return int(order.Uint16(val))
+	}
+
+	var err error
+	switch t {
+	case tagSamplesPerPixel:
+		h.SamplesPerPixel = us()
+	case tagPhotometric:
+		h.Photometric = str(val)
+	case tagPlanarConfig:
+		h.PlanarConfig = us()
+	case tagNumberOfFrames:
+		h.Frames, err = strconv.Atoi(str(val))
+	case tagRows:
+		h.Rows = us()
+	case tagColumns:
+		h.Columns = us()
+	case tagBitsAllocated:
+		h.BitsAllocated = us()
+	case tagBitsStored:
+		h.BitsStored = us()
+	case tagPixelRep:
+		h.Signed = us() == 1
+	case tagWindowCenter:
+		h.WindowCenter, err = decimal(val)
+		h.HasWindow = err == nil
+	case tagWindowWidth:
+		h.WindowWidth, err = decimal(val)
+	case tagRescaleIntercept:
+		h.RescaleIntercept, err = decimal(val)
+	case tagRescaleSlope:
+		h.RescaleSlope, err = decimal(val)
+	}
+	return err
+}
+
+// validate makes sure the image is one we know how to render
+func (h *header) validate() error {
+	if h.Rows <= 0 || h.Columns <= 0 {
+		return errors.New("missing image dimensions")
+	}
+	if h.BitsStored == 0 {
+		h.BitsStored = h.BitsAllocated
+	}
+	h.HasWindow = h.HasWindow && h.WindowWidth >= 1
+
+	switch h.Photometric {
+	case "MONOCHROME1", "MONOCHROME2":
+		if h.SamplesPerPixel != 1 || (h.BitsAllocated != 8 && h.BitsAllocated != 16) {
+			return fmt.Errorf("unsupported grayscale image: %d samples of %d bits", h.SamplesPerPixel, h.BitsAllocated)
+		}
+	case "RGB":
+		if h.SamplesPerPixel != 3 || h.BitsAllocated != 8 {
+			return fmt.Errorf("unsupported RGB image: %d samples of %d bits", h.SamplesPerPixel, h.BitsAllocated)
+		}
+	default:
+		return fmt.Errorf("unsupported photometric interpretation %q", h.Photometric)
+	}
+
+	if h.BitsStored > h.BitsAllocated {
+		return fmt.Errorf("%d bits stored won't fit in %d bits allocated", h.BitsStored, h.BitsAllocated)
+	}
+	if h.PixelLength < h.frameLength() {
+		return fmt.Errorf("pixel data is %d bytes; expected at least %d", h.PixelLength, h.frameLength())
+	}
+	return nil
+}
+
+// str returns a string value without its padding
+func str(val []byte) string {
+	return strings.TrimSpace(string(bytes.TrimRight(val, "\x00")))
+}
+
+// decimal reads a DS value.  DS elements may hold several values separated
+// by backslashes, in which case the first one is the default.
+func decimal(val []byte) (float64, error) {
+	var s = strings.SplitN(str(val), `\`, 2)[0]
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"rais/src/transform"
+)
+
+// Image implements img.Decoder for single-frame DICOM files.  Uncompressed
+// pixel data can't be read in pieces in any useful way, so the whole frame is
+// rendered and then cropped and scaled in Go.  Multi-frame files are served
+// as their first frame.
+type Image struct {
+	filename     string
+	h            *header
+	decodeWidth  int
+	decodeHeight int
+	decodeArea   image.Rectangle
+	filter       transform.Filter
+}
+
+// NewImage reads the given file's data set so its dimensions are known
+func NewImage(filename string) (*Image, error) {
+	var f, err = os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var h *header
+	h, err = readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{filename: filename, h: h}, nil
+}
+
+// SetFilter tells the decoder which filter to scale images with
+func (i *Image) SetFilter(f transform.Filter) {
+	i.filter = f
+}
+
+// SetResizeWH sets the image to scale to the given width and height.  If one
+// dimension is 0, the decoded image will preserve the aspect ratio while
+// scaling to the non-zero dimension.
+func (i *Image) SetResizeWH(width, height int) {
+	i.decodeWidth = width
+	i.decodeHeight = height
+}
+
+// SetCrop sets the image to crop to the given rectangle
+func (i *Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
+}
+
+// GetWidth returns the image's width
+func (i *Image) GetWidth() int {
+	return i.h.Columns
+}
+
+// GetHeight returns the image's height
+func (i *Image) GetHeight() int {
+	return i.h.Rows
+}
+
+// GetTileWidth returns 0, as uncompressed DICOM pixel data isn't tiled
+func (i *Image) GetTileWidth() int {
+	return 0
+}
+
+// GetTileHeight returns 0, as uncompressed DICOM pixel data isn't tiled
+func (i *Image) GetTileHeight() int {
+	return 0
+}
+
+// GetLevels returns 1, as DICOM files don't store reduced resolutions
+func (i *Image) GetLevels() int {
+	return 1
+}
+
+// DecodeImage renders the first frame and returns the requested region at
+// the requested size
+func (i *Image) DecodeImage() (image.Image, error) {
+	w, h := i.GetWidth(), i.GetHeight()
+	if i.decodeArea == image.ZR {
+		i.decodeArea = image.Rect(0, 0, w, h)
+	}
+	if i.decodeWidth == 0 && i.decodeHeight == 0 {
+		i.decodeWidth = i.decodeArea.Dx()
+		i.decodeHeight = i.decodeArea.Dy()
+	}
+	if i.decodeWidth == 0 {
+		i.decodeWidth = i.decodeArea.Dx() * i.decodeHeight / i.decodeArea.Dy()
+	}
+	if i.decodeHeight == 0 {
+		i.decodeHeight = i.decodeArea.Dy() * i.decodeWidth / i.decodeArea.Dx()
+	}
+
+	var f, err = os.Open(i.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var data = make([]byte, i.h.frameLength())
+	_, err = io.ReadFull(io.NewSectionReader(f, i.h.PixelOffset, i.h.PixelLength), data)
+	if err != nil {
+		return nil, err
+	}
+
+	var src = i.h.render(data)
+	var area = i.decodeArea
+	if area.Dx() != i.decodeWidth || area.Dy() != i.decodeHeight {
+		return i.filter.Resize(src.(subImager).SubImage(area), i.decodeWidth, i.decodeHeight), nil
+	}
+	if area == src.Bounds() {
+		return src, nil
+	}
+
+	// The rest of RAIS expects images to start at 0,0, which a SubImage doesn't
+	var out = image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(out, out.Bounds(), src, area.Min, draw.Src)
+	return out, nil
+}
+
+type subImager interface {
+	SubImage(image.Rectangle) image.Image
+}
+
+// render turns a frame's raw pixel data into a displayable image
+func (h *header) render(data []byte) image.Image {
+	var r = image.Rect(0, 0, h.Columns, h.Rows)
+	var n = h.Columns * h.Rows
+
+	if h.Photometric == "RGB" {
+		var out = image.NewRGBA(r)
+		for p := 0; p < n; p++ {
+			if h.PlanarConfig == 1 {
+				out.Pix[p*4], out.Pix[p*4+1], out.Pix[p*4+2] = data[p], data[n+p], data[2*n+p]
+			} else {
+				copy(out.Pix[p*4:p*4+3], data[p*3:p*3+3])
+			}
+			out.Pix[p*4+3] = 0xff
+		}
+		return out
+	}
+
+	var values = h.modalityValues(data)
+	var center, width = h.window(values)
+	var out = image.NewGray(r)
+	for p, v := range values {
+		var y = applyWindow(v, center, width)
+		if h.Photometric == "MONOCHROME1" {
+			y = 255 - y
+		}
+		out.Pix[p] = y
+	}
+	return out
+}
+
+// modalityValues converts grayscale pixel data to real-world values, such as
+// Hounsfield units for CT, using the stored bit depth, sign, and rescale
+// slope and intercept
+func (h *header) modalityValues(data []byte) []float64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if h.BigEndian {
+		order = binary.BigEndian
+	}
+
+	var n = h.Columns * h.Rows
+	var values = make([]float64, n)
+	var mask = uint32(1)<<uint(h.BitsStored) - 1
+	var signBit = uint32(1) << uint(h.BitsStored-1)
+	for p := 0; p < n; p++ {
+		var raw uint32
+		if h.BitsAllocated == 8 {
+			raw = uint32(data[p])
+		} else {
+			raw = uint32(order.Uint16(data[p*2:]))
+		}
+		raw &= mask
+
+		var v = float64(raw)
+		if h.Signed && raw&signBit != 0 {
+			v -= float64(mask) + 1
+		}
+		values[p] = v*h.RescaleSlope + h.RescaleIntercept
+	}
+	return values
+}
+
+// window returns the file's default window center and width, or, if it has
+// none, a window spanning the image's full range of values
+func (h *header) window(values []float64) (center, width float64) {
+	if h.HasWindow {
+		return h.WindowCenter, h.WindowWidth
+	}
+
+	var lo, hi = math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if len(values) == 0 {
+		return 0, 1
+	}
+	return (lo + hi + 1) / 2, hi - lo + 1
+}
+
+// applyWindow maps v to an 8-bit gray level using DICOM's linear VOI LUT
+// function (PS3.3 C.11.2.1.2.1)
+func applyWindow(v, center, width float64) uint8 {
+	var c, w = center - 0.5, width - 1
+	switch {
+	case v <= c-w/2:
+		return 0
+	case v > c+w/2:
+		return 255
+	}
+	return uint8(math.Round(((v-c)/w + 0.5) * 255))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// dcmWriter builds minimal DICOM files for testing
+type dcmWriter struct {
+	bytes.Buffer
+	explicit bool
+}
+
+func newDCM(ts string) *dcmWriter {
+	var w = &dcmWriter{explicit: true}
+	w.Write(make([]byte, 128))
+	w.WriteString("DICM")
+	if len(ts)%2 == 1 {
+		ts += "\x00"
+	}
+	w.elem(0x0002, 0x0010, "UI", []byte(ts))
+	w.explicit = ts != tsImplicitLE+"\x00" && ts != tsImplicitLE
+	return w
+}
+
+func (w *dcmWriter) elem(group, element uint16, vr string, val []byte) {
+	var le = binary.LittleEndian
+	binary.Write(w, le, group)
+	binary.Write(w, le, element)
+	var length = uint32(len(val))
+	// A nil SQ value is the start of an undefined-length sequence or item
+	if vr == "SQ" && val == nil {
+		length = undefinedLength
+	}
+	if !w.explicit {
+		binary.Write(w, le, length)
+	} else if longVRs[vr] {
+		w.WriteString(vr + "\x00\x00")
+		binary.Write(w, le, length)
+	} else {
+		w.WriteString(vr)
+		binary.Write(w, le, uint16(length))
+	}
+	w.Write(val)
+}
+
+func (w *dcmWriter) us(element uint16, v uint16) {
+	var buf = make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, v)
+	w.elem(0x0028, element, "US", buf)
+}
+
+func (w *dcmWriter) str(group, element uint16, vr, s string) {
+	if len(s)%2 == 1 {
+		s += " "
+	}
+	w.elem(group, element, vr, []byte(s))
+}
+
+func writeDCM(t *testing.T, dir, name string, w *dcmWriter) string {
+	var fname = filepath.Join(dir, name)
+	var err = ioutil.WriteFile(fname, w.Bytes(), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write %q: %s", fname, err)
+	}
+	return fname
+}
+
+func TestDICOMGrayscale(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-dicom")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A 4x1 signed 12-bit CT-like image with a rescale and a default window
+	// of -100 to 100 (after the rescale)
+	var w = newDCM(tsExplicitLE)
+	w.str(0x0008, 0x0060, "CS", "CT")
+	w.us(0x0002, 1)
+	w.str(0x0028, 0x0004, "CS", "MONOCHROME2")
+	w.us(0x0010, 1)
+	w.us(0x0011, 4)
+	w.us(0x0100, 16)
+	w.us(0x0101, 12)
+	w.us(0x0103, 1)
+	w.str(0x0028, 0x1050, "DS", `0\40`)
+	w.str(0x0028, 0x1051, "DS", `201\80`)
+	w.str(0x0028, 0x1052, "DS", "-1000")
+	w.str(0x0028, 0x1053, "DS", "1")
+	var px = make([]byte, 8)
+	for i, v := range []int16{800, 1000, 1100, -5} {
+		binary.LittleEndian.PutUint16(px[i*2:], uint16(v)&0x0FFF)
+	}
+	w.elem(0x7FE0, 0x0010, "OW", px)
+
+	var i *Image
+	i, err = NewImage(writeDCM(t, dir, "ct.dcm", w))
+	assert.NilError(err, "reading the header", t)
+	assert.Equal(4, i.GetWidth(), "width", t)
+	assert.Equal(1, i.GetHeight(), "height", t)
+
+	var out image.Image
+	out, err = i.DecodeImage()
+	assert.NilError(err, "decoding", t)
+	var g = out.(*image.Gray)
+	assert.Equal(uint8(0), g.Pix[0], "values below the window are black", t)
+	assert.Equal(uint8(128), g.Pix[1], "the window center is middle gray", t)
+	assert.Equal(uint8(255), g.Pix[2], "values above the window are white", t)
+	assert.Equal(uint8(0), g.Pix[3], "negative stored values are sign-extended", t)
+}
+
+func TestDICOMImplicitAutoWindow(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-dicom")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An inverted 8-bit image with no window, behind an undefined-length
+	// sequence which has to be skipped
+	var w = newDCM(tsImplicitLE)
+	w.elem(0x0008, 0x1140, "SQ", nil)
+	w.elem(0xFFFE, 0xE000, "SQ", nil)
+	w.str(0x0008, 0x1150, "UI", "1.2.3")
+	w.elem(0xFFFE, 0xE00D, "", []byte{})
+	w.elem(0xFFFE, 0xE0DD, "", []byte{})
+	w.str(0x0028, 0x0004, "CS", "MONOCHROME1")
+	w.us(0x0010, 2)
+	w.us(0x0011, 2)
+	w.us(0x0100, 8)
+	w.elem(0x7FE0, 0x0010, "OB", []byte{10, 20, 30, 40})
+
+	var i *Image
+	i, err = NewImage(writeDCM(t, dir, "xray.dcm", w))
+	assert.NilError(err, "reading the header", t)
+
+	var out image.Image
+	out, err = i.DecodeImage()
+	assert.NilError(err, "decoding", t)
+	var g = out.(*image.Gray)
+	assert.Equal(uint8(255), g.Pix[0], "MONOCHROME1's lowest value is white", t)
+	assert.Equal(uint8(0), g.Pix[3], "MONOCHROME1's highest value is black", t)
+
+	i, err = NewImage(i.filename)
+	assert.NilError(err, "rereading the header", t)
+	i.SetCrop(image.Rect(1, 1, 2, 2))
+	out, err = i.DecodeImage()
+	assert.NilError(err, "decoding a crop", t)
+	assert.Equal(image.Rect(0, 0, 1, 1), out.Bounds(), "crops start at 0,0", t)
+}
+
+func TestDICOMRGBPlanar(t *testing.T) {
+	var h = &header{Rows: 1, Columns: 2, SamplesPerPixel: 3, Photometric: "RGB", PlanarConfig: 1, BitsAllocated: 8}
+	var out = h.render([]byte{1, 2, 3, 4, 5, 6}).(*image.RGBA)
+	assert.Equal(string([]byte{1, 3, 5, 255, 2, 4, 6, 255}), string(out.Pix), "planes are interleaved", t)
+}
+
+func TestDICOMUnsupported(t *testing.T) {
+	var _, err = readHeader(bytes.NewReader([]byte("not dicom")))
+	assert.True(err != nil, "non-DICOM files are rejected", t)
+
+	var w = newDCM("1.2.840.10008.1.2.4.50")
+	w.elem(0x7FE0, 0x0010, "OB", []byte{})
+	_, err = readHeader(bytes.NewReader(w.Bytes()))
+	assert.True(err != nil, "compressed transfer syntaxes are rejected", t)
+}
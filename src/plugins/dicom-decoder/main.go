@@ -0,0 +1,47 @@
+// Package main is a decoder plugin for DICOM files, so medical history and
+// other archives can serve their scans through the same IIIF endpoint as
+// everything else.  Grayscale images are rendered with the default window
+// (window center and width) stored in the file, or with a window covering the
+// full range of values if the file doesn't have one.
+//
+// Only uncompressed files are supported: implicit or explicit VR little
+// endian, and explicit VR big endian.  Images must be 8- or 16-bit grayscale
+// (MONOCHROME1 or MONOCHROME2) or 8-bit RGB.  Multi-frame files are served as
+// their first frame.
+package main
+
+import (
+	"rais/src/img"
+	"rais/src/plugins"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l *logger.Logger
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapDecoder}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// NamedImageDecoders returns the "dicom" decoder.  DICOM's "DICM" signature
+// comes after a 128-byte preamble, too far in for content sniffing, so files
+// need a .dcm or .dicom extension, or a DecoderExtensions mapping to "dicom".
+func NamedImageDecoders() []img.NamedDecoder {
+	return []img.NamedDecoder{{
+		Name:       "dicom",
+		Extensions: []string{".dcm", ".dicom"},
+		Decode:     decodeDICOM,
+	}}
+}
+
+func decodeDICOM(path string) (img.Decoder, error) {
+	return NewImage(path)
+}
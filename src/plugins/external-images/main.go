@@ -49,6 +49,12 @@
 
 var l *logger.Logger
 
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapIDToPath}
+
 // SetLogger is called by the RAIS server's plugin manager to let plugins use
 // the central logger
 func SetLogger(raisLogger *logger.Logger) {
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FITS files are made of 2880-byte blocks of 80-character header cards,
+// followed by the data, padded to a whole block
+const (
+	blockSize = 2880
+	cardSize  = 80
+)
+
+// hdu describes a single header/data unit's image
+type hdu struct {
+	Bitpix        int
+	Width, Height int
+	BZero, BScale float64
+	Blank         *int64
+
+	// DataOffset is where the image data starts in the file
+	DataOffset int64
+}
+
+// pixelBytes returns the number of bytes in each pixel value
+func (h *hdu) pixelBytes() int {
+	if h.Bitpix < 0 {
+		return -h.Bitpix / 8
+	}
+	return h.Bitpix / 8
+}
+
+// planeLength returns the number of bytes in the first image plane
+func (h *hdu) planeLength() int64 {
+	return int64(h.Width) * int64(h.Height) * int64(h.pixelBytes())
+}
+
+// readImageHDU finds the first header/data unit with a two-dimensional (or
+// larger) image: the primary HDU, or, for files whose primary HDU is empty as
+// is common with cutout services, the first IMAGE extension
+func readImageHDU(r io.Reader) (*hdu, error) {
+	var br = bufio.NewReader(r)
+	var pos int64
+	for n := 0; ; n++ {
+		var cards, blocks, err = readHeaderCards(br)
+		if err != nil {
+			if n == 0 {
+				return nil, fmt.Errorf("not a FITS file: %s", err)
+			}
+			return nil, errors.New("no image data")
+		}
+		pos += int64(blocks) * blockSize
+
+		if n == 0 && cards["SIMPLE"] != "T" {
+			return nil, errors.New("not a standard FITS file")
+		}
+		if n > 0 && strings.Trim(cards["XTENSION"], "' ") != "IMAGE" {
+			err = skipData(br, cards, &pos)
+			if err != nil {
+				return nil, errors.New("no image data")
+			}
+			continue
+		}
+
+		var h = &hdu{BScale: 1, DataOffset: pos}
+		var naxis int
+		naxis, err = intCard(cards, "NAXIS", 0)
+		if err == nil {
+			h.Bitpix, err = intCard(cards, "BITPIX", 0)
+		}
+		if err == nil && naxis >= 2 {
+			h.Width, err = intCard(cards, "NAXIS1", 0)
+			if err == nil {
+				h.Height, err = intCard(cards, "NAXIS2", 0)
+			}
+		}
+		if err == nil {
+			h.BZero, err = floatCard(cards, "BZERO", 0)
+		}
+		if err == nil {
+			h.BScale, err = floatCard(cards, "BSCALE", 1)
+		}
+		if err == nil && cards["BLANK"] != "" {
+			var b int64
+			b, err = strconv.ParseInt(cards["BLANK"], 10, 64)
+			h.Blank = &b
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if naxis >= 2 && h.Width > 0 && h.Height > 0 {
+			switch h.Bitpix {
+			case 8, 16, 32, 64, -32, -64:
+				return h, nil
+			}
+			return nil, fmt.Errorf("invalid BITPIX %d", h.Bitpix)
+		}
+		err = skipData(br, cards, &pos)
+		if err != nil {
+			return nil, errors.New("no image data")
+		}
+	}
+}
+
+// readHeaderCards reads header blocks up to and including the one with the
+// END card, returning each keyword's value with any comment removed, and the
+// number of blocks read
+func readHeaderCards(br *bufio.Reader) (map[string]string, int, error) {
+	var cards = make(map[string]string)
+	var block = make([]byte, blockSize)
+	for blocks := 1; ; blocks++ {
+		var _, err = io.ReadFull(br, block)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := 0; i < blockSize; i += cardSize {
+			var card = string(block[i : i+cardSize])
+			var key = strings.TrimSpace(card[:8])
+			if key == "END" {
+				return cards, blocks, nil
+			}
+			if card[8:10] == "= " {
+				cards[key] = cardValue(card[10:])
+			}
+		}
+	}
+}
+
+// cardValue strips the comment from a card's value.  Quoted strings can
+// contain slashes, and use doubled quotes as an escape.
+func cardValue(v string) string {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "'") {
+		for i := 1; i < len(v); i++ {
+			if v[i] == '\'' {
+				if i+1 < len(v) && v[i+1] == '\'' {
+					i++
+					continue
+				}
+				return v[:i+1]
+			}
+		}
+		return v
+	}
+	if i := strings.Index(v, "/"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+// skipData skips past an HDU's data, which is the product of all its axes,
+// plus any extension parameters, padded to a whole block
+func skipData(br *bufio.Reader, cards map[string]string, pos *int64) error {
+	var bitpix, err = intCard(cards, "BITPIX", 0)
+	if err != nil {
+		return err
+	}
+	var naxis int
+	naxis, err = intCard(cards, "NAXIS", 0)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	if naxis > 0 {
+		size = 1
+		for i := 1; i <= naxis; i++ {
+			var n int
+			n, err = intCard(cards, "NAXIS"+strconv.Itoa(i), 0)
+			if err != nil {
+				return err
+			}
+			size *= int64(n)
+		}
+	}
+	var pcount, gcount int
+	pcount, err = intCard(cards, "PCOUNT", 0)
+	if err == nil {
+		gcount, err = intCard(cards, "GCOUNT", 1)
+	}
+	if err != nil {
+		return err
+	}
+	if bitpix < 0 {
+		bitpix = -bitpix
+	}
+	size = int64(bitpix/8) * int64(gcount) * (int64(pcount) + size)
+	size = (size + blockSize - 1) / blockSize * blockSize
+
+	var _, skipErr = br.Discard(int(size))
+	*pos += size
+	return skipErr
+}
+
+func intCard(cards map[string]string, key string, def int) (int, error) {
+	var v, ok = cards[key]
+	if !ok {
+		return def, nil
+	}
+	var n, err = strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return n, nil
+}
+
+func floatCard(cards map[string]string, key string, def float64) (float64, error) {
+	var v, ok = cards[key]
+	if !ok {
+		return def, nil
+	}
+	// Fortran-style exponents use "D"
+	var f, err = strconv.ParseFloat(strings.Replace(v, "D", "E", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return f, nil
+}
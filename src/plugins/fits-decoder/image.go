@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"rais/src/transform"
+	"sort"
+)
+
+// maxSamples caps how many pixel values are sorted to find the clipping
+// range, so huge mosaics don't need a full sort
+const maxSamples = 1 << 20
+
+// Image implements img.Decoder for FITS files.  FITS data isn't tiled or
+// compressed, so the whole first image plane is stretched to 8 bits and then
+// cropped and scaled in Go.
+type Image struct {
+	filename     string
+	h            *hdu
+	decodeWidth  int
+	decodeHeight int
+	decodeArea   image.Rectangle
+	filter       transform.Filter
+}
+
+// NewImage reads the given file's headers so its dimensions are known
+func NewImage(filename string) (*Image, error) {
+	var f, err = os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var h *hdu
+	h, err = readImageHDU(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{filename: filename, h: h}, nil
+}
+
+// SetFilter tells the decoder which filter to scale images with
+func (i *Image) SetFilter(f transform.Filter) {
+	i.filter = f
+}
+
+// SetResizeWH sets the image to scale to the given width and height.  If one
+// dimension is 0, the decoded image will preserve the aspect ratio while
+// scaling to the non-zero dimension.
+func (i *Image) SetResizeWH(width, height int) {
+	i.decodeWidth = width
+	i.decodeHeight = height
+}
+
+// SetCrop sets the image to crop to the given rectangle
+func (i *Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
+}
+
+// GetWidth returns the image's width
+func (i *Image) GetWidth() int {
+	return i.h.Width
+}
+
+// GetHeight returns the image's height
+func (i *Image) GetHeight() int {
+	return i.h.Height
+}
+
+// GetTileWidth returns 0, as FITS data isn't tiled
+func (i *Image) GetTileWidth() int {
+	return 0
+}
+
+// GetTileHeight returns 0, as FITS data isn't tiled
+func (i *Image) GetTileHeight() int {
+	return 0
+}
+
+// GetLevels returns 1, as FITS files don't store reduced resolutions
+func (i *Image) GetLevels() int {
+	return 1
+}
+
+// DecodeImage stretches the image data and returns the requested region at
+// the requested size
+func (i *Image) DecodeImage() (image.Image, error) {
+	w, h := i.GetWidth(), i.GetHeight()
+	if i.decodeArea == image.ZR {
+		i.decodeArea = image.Rect(0, 0, w, h)
+	}
+	if i.decodeWidth == 0 && i.decodeHeight == 0 {
+		i.decodeWidth = i.decodeArea.Dx()
+		i.decodeHeight = i.decodeArea.Dy()
+	}
+	if i.decodeWidth == 0 {
+		i.decodeWidth = i.decodeArea.Dx() * i.decodeHeight / i.decodeArea.Dy()
+	}
+	if i.decodeHeight == 0 {
+		i.decodeHeight = i.decodeArea.Dy() * i.decodeWidth / i.decodeArea.Dx()
+	}
+
+	var f, err = os.Open(i.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var data = make([]byte, i.h.planeLength())
+	_, err = io.ReadFull(io.NewSectionReader(f, i.h.DataOffset, i.h.planeLength()), data)
+	if err != nil {
+		return nil, err
+	}
+
+	var src = render(i.h, i.h.values(data), stretchFn, clipPercent)
+	var area = i.decodeArea
+	if area.Dx() != i.decodeWidth || area.Dy() != i.decodeHeight {
+		return i.filter.Resize(src.SubImage(area), i.decodeWidth, i.decodeHeight), nil
+	}
+	if area == src.Bounds() {
+		return src, nil
+	}
+
+	// The rest of RAIS expects images to start at 0,0, which a SubImage doesn't
+	var out = image.NewGray(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(out, out.Bounds(), src, area.Min, draw.Src)
+	return out, nil
+}
+
+// values converts the raw big-endian data to physical values using BZERO and
+// BSCALE.  Blank (undefined) pixels are NaN.
+func (h *hdu) values(data []byte) []float64 {
+	var be = binary.BigEndian
+	var size = h.pixelBytes()
+	var values = make([]float64, h.Width*h.Height)
+	for p := range values {
+		var b = data[p*size:]
+		var raw int64
+		var v float64
+		switch h.Bitpix {
+		case 8:
+			raw = int64(b[0])
+		case 16:
+			raw = int64(int16(be.Uint16(b)))
+		case 32:
+			raw = int64(int32(be.Uint32(b)))
+		case 64:
+			raw = int64(be.Uint64(b))
+		case -32:
+			v = float64(math.Float32frombits(be.Uint32(b)))
+		case -64:
+			v = math.Float64frombits(be.Uint64(b))
+		}
+		if h.Bitpix > 0 {
+			if h.Blank != nil && raw == *h.Blank {
+				values[p] = math.NaN()
+				continue
+			}
+			v = float64(raw)
+		}
+		values[p] = v*h.BScale + h.BZero
+	}
+	return values
+}
+
+// clipRange returns the values at the given percentiles from the bottom and
+// top of the finite values, sampling large images
+func clipRange(values []float64, percent float64) (lo, hi float64) {
+	var step = len(values)/maxSamples + 1
+	var sample = make([]float64, 0, len(values)/step+1)
+	for p := 0; p < len(values); p += step {
+		if !math.IsNaN(values[p]) && !math.IsInf(values[p], 0) {
+			sample = append(sample, values[p])
+		}
+	}
+	if len(sample) == 0 {
+		return 0, 1
+	}
+	sort.Float64s(sample)
+
+	var n = int(float64(len(sample)-1) * percent / 100)
+	return sample[n], sample[len(sample)-1-n]
+}
+
+// render clips and stretches values into a grayscale image.  FITS rows start
+// at the bottom of the image, so they're flipped to put north up.
+func render(h *hdu, values []float64, stretch func(float64) float64, clip float64) *image.Gray {
+	var lo, hi = clipRange(values, clip)
+	var out = image.NewGray(image.Rect(0, 0, h.Width, h.Height))
+	for y := 0; y < h.Height; y++ {
+		var row = values[(h.Height-1-y)*h.Width:]
+		for x := 0; x < h.Width; x++ {
+			var v = row[x]
+			if math.IsNaN(v) {
+				continue
+			}
+			var n = 0.0
+			if hi > lo {
+				n = math.Max(0, math.Min(1, (v-lo)/(hi-lo)))
+			}
+			out.Pix[y*out.Stride+x] = uint8(math.Round(stretch(n) * 255))
+		}
+	}
+	return out
+}
+
+// The stretches map normalized values from 0 to 1 onto 0 to 1, using the
+// same constants as SAOImage DS9
+var stretches = map[string]func(float64) float64{
+	"linear": func(x float64) float64 { return x },
+	"log":    func(x float64) float64 { return math.Log10(1000*x+1) / math.Log10(1001) },
+	"asinh":  func(x float64) float64 { return math.Asinh(10*x) / math.Asinh(10) },
+}
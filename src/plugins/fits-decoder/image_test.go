@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// header returns FITS header blocks holding the given cards
+func header(cards ...string) []byte {
+	var buf bytes.Buffer
+	for _, c := range append(cards, "END") {
+		buf.WriteString(fmt.Sprintf("%-80s", c))
+	}
+	for buf.Len()%blockSize != 0 {
+		buf.WriteByte(' ')
+	}
+	return buf.Bytes()
+}
+
+// pad returns data padded to a whole block
+func pad(data []byte) []byte {
+	for len(data)%blockSize != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+func writeFITS(t *testing.T, parts ...[]byte) string {
+	var dir, err = ioutil.TempDir("", "rais-fits")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	var fname = filepath.Join(dir, "test.fits")
+	err = ioutil.WriteFile(fname, bytes.Join(parts, nil), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write %q: %s", fname, err)
+	}
+	return fname
+}
+
+func TestFITSExtension(t *testing.T) {
+	// An empty primary HDU, a table extension to skip, then a 2x2 16-bit
+	// image with an offset and a blank pixel
+	var px = make([]byte, 8)
+	for i, v := range []int16{-32768, -32767, -1, -999} {
+		binary.BigEndian.PutUint16(px[i*2:], uint16(v))
+	}
+	var fname = writeFITS(t,
+		header("SIMPLE  =                    T", "BITPIX  =                    8", "NAXIS   =                    0", "EXTEND  =                    T"),
+		header("XTENSION= 'BINTABLE'           / a table", "BITPIX  =                    8", "NAXIS   =                    2",
+			"NAXIS1  =                   10", "NAXIS2  =                  300", "PCOUNT  =                    0", "GCOUNT  =                    1"),
+		pad(make([]byte, 3000)),
+		header("XTENSION= 'IMAGE   '", "BITPIX  =                   16", "NAXIS   =                    2",
+			"NAXIS1  =                    2", "NAXIS2  =                    2", "BZERO   =                32768", "BLANK   =                 -999"),
+		pad(px),
+	)
+
+	var i, err = NewImage(fname)
+	assert.NilError(err, "reading the headers", t)
+	assert.Equal(2, i.GetWidth(), "width", t)
+	assert.Equal(2, i.GetHeight(), "height", t)
+
+	var data = make([]byte, 8)
+	copy(data, px)
+	var values = i.h.values(data)
+	assert.Equal(0.0, values[0], "BZERO is applied", t)
+	assert.Equal(32767.0, values[2], "signed values are read", t)
+	assert.True(math.IsNaN(values[3]), "BLANK pixels are NaN", t)
+
+	stretchFn, clipPercent = stretches["linear"], 0
+	var out image.Image
+	out, err = i.DecodeImage()
+	assert.NilError(err, "decoding", t)
+	var g = out.(*image.Gray)
+	assert.Equal(uint8(255), g.Pix[0], "the bottom row is drawn last", t)
+	assert.Equal(uint8(0), g.Pix[1], "blank pixels are black", t)
+	assert.Equal(uint8(0), g.Pix[2], "the lowest value is black", t)
+}
+
+func TestFITSFloat(t *testing.T) {
+	var px = make([]byte, 16)
+	for i, v := range []float32{0, 1, 4, float32(math.NaN())} {
+		binary.BigEndian.PutUint32(px[i*4:], math.Float32bits(v))
+	}
+	var fname = writeFITS(t,
+		header("SIMPLE  =                    T / standard", "BITPIX  =                  -32", "NAXIS   =                    2",
+			"NAXIS1  =                    4", "NAXIS2  =                    1", "BSCALE  =                1.0D0"),
+		pad(px),
+	)
+
+	var i, err = NewImage(fname)
+	assert.NilError(err, "reading the header", t)
+	var values = i.h.values(px)
+	var g = render(i.h, values, stretches["linear"], 0)
+	assert.Equal(uint8(64), g.Pix[1], "linear stretch", t)
+	g = render(i.h, values, stretches["asinh"], 0)
+	assert.True(g.Pix[1] > 64, "asinh brightens faint values", t)
+	g = render(i.h, values, stretches["log"], 0)
+	assert.True(g.Pix[1] > 200, "log brightens faint values even more", t)
+	assert.Equal(uint8(255), g.Pix[2], "the top of the range is white", t)
+}
+
+func TestFITSInvalid(t *testing.T) {
+	var _, err = readImageHDU(bytes.NewReader([]byte("not fits")))
+	assert.True(err != nil, "short files are rejected", t)
+
+	_, err = readImageHDU(bytes.NewReader(header("SIMPLE  =                    T", "BITPIX  =                    8", "NAXIS   =                    0")))
+	assert.True(err != nil, "files without images are rejected", t)
+}
+
+func TestCardValue(t *testing.T) {
+	assert.Equal("'a/b''c'", cardValue(" 'a/b''c'  / comment"), "quoted strings keep slashes and quotes", t)
+	assert.Equal("42", cardValue("                   42 / answer"), "comments are removed", t)
+}
@@ -0,0 +1,72 @@
+// Package main is a decoder plugin for FITS images, so astronomy archives
+// can serve cutouts and survey images over IIIF.  FITS data is usually far
+// deeper than 8 bits and mostly dark sky, so values are clipped to a
+// percentile range and then stretched (linearly, logarithmically, or with an
+// asinh curve) to 8-bit grayscale.  Only the first image plane of the primary
+// HDU, or of the first IMAGE extension if the primary HDU is empty, is
+// served.  Compressed (tile-compressed binary table) images aren't
+// supported.
+package main
+
+import (
+	"rais/src/img"
+	"rais/src/plugins"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l *logger.Logger
+
+// stretchFn maps normalized values onto output levels
+var stretchFn func(float64) float64
+
+// clipPercent is the percentage of values at each end of the range which are
+// clipped to black or white before stretching
+var clipPercent float64
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapDecoder}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// Initialize reads our settings
+func Initialize() {
+	var c = plugins.NewConfig("Fits")
+	c.SetDefault("Stretch", "asinh")
+	c.SetDefault("ClipPercent", 0.5)
+
+	var val = c.GetString("Stretch")
+	var fn, ok = stretches[strings.ToLower(val)]
+	if !ok {
+		l.Fatalf("FITS plugin failure: invalid FitsStretch %q (must be linear, log, or asinh)", val)
+	}
+	stretchFn = fn
+
+	clipPercent = c.GetFloat64("ClipPercent")
+	if clipPercent < 0 || clipPercent >= 50 {
+		l.Fatalf("FITS plugin failure: FitsClipPercent must be at least 0 and less than 50")
+	}
+}
+
+// NamedImageDecoders returns the "fits" decoder.  Every FITS file starts with
+// its SIMPLE card, so files are recognized by content as well as extension.
+func NamedImageDecoders() []img.NamedDecoder {
+	return []img.NamedDecoder{{
+		Name:       "fits",
+		Extensions: []string{".fits", ".fit", ".fts"},
+		Magic:      [][]byte{[]byte("SIMPLE  =")},
+		Decode:     decodeFITS,
+	}}
+}
+
+func decodeFITS(path string) (img.Decoder, error) {
+	return NewImage(path)
+}
@@ -0,0 +1,256 @@
+package main
+
+/*
+#cgo pkg-config: libheif
+#include <stdlib.h>
+#include <libheif/heif.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"rais/src/transform"
+	"runtime"
+	"unsafe"
+)
+
+// Image implements img.Decoder for HEIF files.  libheif always decodes an
+// entire image, so regions are cropped and scaled in Go afterward.  To make
+// small requests cheaper, the thumbnails HEIF files often carry are decoded
+// instead of the full image when they have enough resolution for the
+// request, which is as close as libheif gets to decoding at a lower
+// resolution.
+type Image struct {
+	ctx          *C.struct_heif_context
+	handle       *C.struct_heif_image_handle
+	decodeWidth  int
+	decodeHeight int
+	decodeArea   image.Rectangle
+	filter       transform.Filter
+}
+
+func heifError(err C.struct_heif_error) error {
+	if err.code == C.heif_error_Ok {
+		return nil
+	}
+	return fmt.Errorf("libheif: %s", C.GoString(err.message))
+}
+
+// NewImage reads the given file's headers and sets up the primary image for
+// decoding
+func NewImage(filename string) (*Image, error) {
+	cFilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cFilename))
+
+	i := &Image{ctx: C.heif_context_alloc()}
+	runtime.SetFinalizer(i, finalizer)
+
+	err := heifError(C.heif_context_read_from_file(i.ctx, cFilename, nil))
+	if err == nil {
+		var handle *C.struct_heif_image_handle
+		err = heifError(C.heif_context_get_primary_image_handle(i.ctx, &handle))
+		i.handle = handle
+	}
+	if err != nil {
+		i.CleanupResources()
+		return nil, err
+	}
+	return i, nil
+}
+
+// SetFilter tells the decoder which filter to scale images with
+func (i *Image) SetFilter(f transform.Filter) {
+	i.filter = f
+}
+
+// SetResizeWH sets the image to scale to the given width and height.  If one
+// dimension is 0, the decoded image will preserve the aspect ratio while
+// scaling to the non-zero dimension.
+func (i *Image) SetResizeWH(width, height int) {
+	i.decodeWidth = width
+	i.decodeHeight = height
+}
+
+// SetCrop sets the image to crop to the given rectangle
+func (i *Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
+}
+
+// GetWidth returns the width of the image as displayed, after any rotation
+// stored in the file
+func (i *Image) GetWidth() int {
+	return int(C.heif_image_handle_get_width(i.handle))
+}
+
+// GetHeight returns the height of the image as displayed
+func (i *Image) GetHeight() int {
+	return int(C.heif_image_handle_get_height(i.handle))
+}
+
+// GetTileWidth returns 0; HEIC files are often stored as a grid of tiles,
+// but libheif doesn't let us decode them individually
+func (i *Image) GetTileWidth() int {
+	return 0
+}
+
+// GetTileHeight returns 0, for the same reason as GetTileWidth
+func (i *Image) GetTileHeight() int {
+	return 0
+}
+
+// GetLevels returns 1, as thumbnails aren't guaranteed to be useful sizes,
+// or to exist at all
+func (i *Image) GetLevels() int {
+	return 1
+}
+
+// PageCount returns the number of top-level images in the file, such as the
+// shots of a burst
+func (i *Image) PageCount() int {
+	return int(C.heif_context_get_number_of_top_level_images(i.ctx))
+}
+
+// SetPage switches to the given zero-based top-level image
+func (i *Image) SetPage(n int) error {
+	var count = i.PageCount()
+	if n < 0 || n >= count {
+		return fmt.Errorf("page %d out of range", n)
+	}
+
+	var ids = make([]C.heif_item_id, count)
+	C.heif_context_get_list_of_top_level_image_IDs(i.ctx, &ids[0], C.int(count))
+
+	var handle *C.struct_heif_image_handle
+	var err = heifError(C.heif_context_get_image_handle(i.ctx, ids[n], &handle))
+	if err != nil {
+		return err
+	}
+	C.heif_image_handle_release(i.handle)
+	i.handle = handle
+	return nil
+}
+
+// DecodeImage returns the requested region of the image at the requested
+// size
+func (i *Image) DecodeImage() (image.Image, error) {
+	w, h := i.GetWidth(), i.GetHeight()
+	if i.decodeArea == image.ZR {
+		i.decodeArea = image.Rect(0, 0, w, h)
+	}
+	if i.decodeWidth == 0 && i.decodeHeight == 0 {
+		i.decodeWidth = i.decodeArea.Dx()
+		i.decodeHeight = i.decodeArea.Dy()
+	}
+	if i.decodeWidth == 0 {
+		i.decodeWidth = i.decodeArea.Dx() * i.decodeHeight / i.decodeArea.Dy()
+	}
+	if i.decodeHeight == 0 {
+		i.decodeHeight = i.decodeArea.Dy() * i.decodeWidth / i.decodeArea.Dx()
+	}
+
+	var handle, area = i.handle, i.decodeArea
+	if useThumbnails {
+		var thumb = i.thumbnailFor(w)
+		if thumb != nil {
+			defer C.heif_image_handle_release(thumb)
+			handle = thumb
+			var tw = int(C.heif_image_handle_get_width(thumb))
+			var th = int(C.heif_image_handle_get_height(thumb))
+			area = image.Rect(area.Min.X*tw/w, area.Min.Y*th/h, area.Max.X*tw/w, area.Max.Y*th/h)
+		}
+	}
+
+	var src, err = decodeHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	if area.Dx() != i.decodeWidth || area.Dy() != i.decodeHeight {
+		return i.filter.Resize(src.SubImage(area), i.decodeWidth, i.decodeHeight), nil
+	}
+	if area == src.Bounds() {
+		return src, nil
+	}
+
+	// The rest of RAIS expects images to start at 0,0, which a SubImage doesn't
+	var out = image.NewNRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(out, out.Bounds(), src, area.Min, draw.Src)
+	return out, nil
+}
+
+// thumbnailFor returns the smallest of the image's thumbnails with enough
+// resolution to produce the requested region and size, or nil if none do.
+// The caller must release the returned handle.
+func (i *Image) thumbnailFor(fullWidth int) *C.struct_heif_image_handle {
+	var count = int(C.heif_image_handle_get_number_of_thumbnails(i.handle))
+	if count == 0 {
+		return nil
+	}
+	var ids = make([]C.heif_item_id, count)
+	C.heif_image_handle_get_list_of_thumbnail_IDs(i.handle, &ids[0], C.int(count))
+
+	// The thumbnail's scale has to be at least the scale of the request
+	var need = float64(i.decodeWidth) / float64(i.decodeArea.Dx())
+	var best *C.struct_heif_image_handle
+	var bestWidth int
+	for _, id := range ids {
+		var thumb *C.struct_heif_image_handle
+		if heifError(C.heif_image_handle_get_thumbnail(i.handle, id, &thumb)) != nil {
+			continue
+		}
+		var tw = int(C.heif_image_handle_get_width(thumb))
+		if float64(tw)/float64(fullWidth) < need || (best != nil && tw >= bestWidth) {
+			C.heif_image_handle_release(thumb)
+			continue
+		}
+		if best != nil {
+			C.heif_image_handle_release(best)
+		}
+		best, bestWidth = thumb, tw
+	}
+	return best
+}
+
+// decodeHandle decodes the image behind handle into a Go image
+func decodeHandle(handle *C.struct_heif_image_handle) (*image.NRGBA, error) {
+	var himg *C.struct_heif_image
+	var err = heifError(C.heif_decode_image(handle, &himg, C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, nil))
+	if err != nil {
+		return nil, err
+	}
+	defer C.heif_image_release(himg)
+
+	var w = int(C.heif_image_get_width(himg, C.heif_channel_interleaved))
+	var h = int(C.heif_image_get_height(himg, C.heif_channel_interleaved))
+	var stride C.int
+	var plane = C.heif_image_get_plane_readonly(himg, C.heif_channel_interleaved, &stride)
+	if plane == nil {
+		return nil, errors.New("libheif: no pixel data")
+	}
+
+	var out = image.NewNRGBA(image.Rect(0, 0, w, h))
+	var rowLen = w * 4
+	for y := 0; y < h; y++ {
+		var row = unsafe.Pointer(uintptr(unsafe.Pointer(plane)) + uintptr(y*int(stride)))
+		copy(out.Pix[y*out.Stride:y*out.Stride+rowLen], C.GoBytes(row, C.int(rowLen)))
+	}
+	return out, nil
+}
+
+func finalizer(i *Image) {
+	i.CleanupResources()
+}
+
+// CleanupResources frees the C data allocated by libheif
+func (i *Image) CleanupResources() {
+	if i.handle != nil {
+		C.heif_image_handle_release(i.handle)
+		i.handle = nil
+	}
+	if i.ctx != nil {
+		C.heif_context_free(i.ctx)
+		i.ctx = nil
+	}
+}
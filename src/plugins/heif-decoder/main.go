@@ -0,0 +1,53 @@
+// Package main is a decoder plugin for HEIF images, including the HEIC files
+// phones and many capture apps now produce, built on libheif.  Requires
+// libheif 1.4 or later and its development headers.  Like the ImageMagick
+// plugin, this isn't built by default; use "make bin/plugins/heif-decoder.so".
+package main
+
+import (
+	"rais/src/img"
+	"rais/src/plugins"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l *logger.Logger
+
+// useThumbnails is true if small requests may be served from the thumbnails
+// embedded in many HEIF files rather than decoding the full image
+var useThumbnails bool
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapDecoder}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// Initialize reads our settings
+func Initialize() {
+	var c = plugins.NewConfig("Heif")
+	c.SetDefault("Thumbnails", true)
+	useThumbnails = c.GetBool("Thumbnails")
+}
+
+// NamedImageDecoders returns the "heif" decoder.  HEIF files start with a
+// box size before their "ftyp" brand, so they can't be identified by a fixed
+// signature; files need a ".heic" or ".heif" extension, or a DecoderExtensions
+// mapping to "heif".
+func NamedImageDecoders() []img.NamedDecoder {
+	return []img.NamedDecoder{{
+		Name:       "heif",
+		Extensions: []string{".heic", ".heif"},
+		Decode:     decodeHEIF,
+	}}
+}
+
+func decodeHEIF(path string) (img.Decoder, error) {
+	return NewImage(path)
+}
@@ -13,15 +13,16 @@
 	"unsafe"
 )
 
-// Image returns a native Go image interface.  For now, this is always RGBA for
-// simplicity, but it would be a good idea to use a gray image when it makes
-// sense to improve performance and RAM usage.
+// Image returns a native Go image interface.  For now, this is always NRGBA
+// for simplicity, since ImageMagick exports alpha unpremultiplied, but it
+// would be a good idea to use a gray image when it makes sense to improve
+// performance and RAM usage.
 func (i *Image) Image() (image.Image, error) {
 	// Create and prep-for-freeing the exception
 	exception := C.AcquireExceptionInfo()
 	defer C.DestroyExceptionInfo(exception)
 
-	img := image.NewRGBA(image.Rect(0, 0, i.decodeWidth, i.decodeHeight))
+	img := image.NewNRGBA(image.Rect(0, 0, i.decodeWidth, i.decodeHeight))
 
 	area := i.decodeWidth * i.decodeHeight
 	pixLen := area << 2
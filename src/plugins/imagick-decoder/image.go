@@ -7,7 +7,9 @@
 */
 import "C"
 import (
+	"fmt"
 	"image"
+	"rais/src/transform"
 	"runtime"
 	"unsafe"
 )
@@ -25,6 +27,13 @@ type Image struct {
 	decodeWidth  int
 	decodeHeight int
 	decodeArea   image.Rectangle
+	filter       transform.Filter
+}
+
+// SetFilter tells the decoder to scale images in Go with the given filter
+// rather than using ImageMagick's default resize
+func (i *Image) SetFilter(f transform.Filter) {
+	i.filter = f
 }
 
 // SetResizeWH sets the image to scale to the given width and height.  If one
@@ -60,14 +69,105 @@ func NewImage(filename string) (*Image, error) {
 
 	i := &Image{image: image, imageInfo: info}
 	runtime.SetFinalizer(i, finalizer)
+
+	// Frames of an animated GIF after the first usually hold just the pixels
+	// which changed, often in a smaller rectangle, so the frames are composited
+	// into full images before anybody asks for one
+	if i.PageCount() > 1 && i.format() == "GIF" {
+		err := i.coalesce()
+		if err != nil {
+			i.CleanupResources()
+			return nil, err
+		}
+	}
+
+	// Orienting a multi-page file would leave us with just the first page, so
+	// those are oriented when a page is chosen
+	if i.PageCount() == 1 {
+		err := i.orient()
+		if err != nil {
+			i.CleanupResources()
+			return nil, err
+		}
+	}
 	return i, nil
 }
 
+// orient rotates and flips the image upright if it has an EXIF orientation
+// and auto-orientation isn't disabled.  This has to happen before anything
+// looks at dimensions, so region math applies to the image as people see it.
+func (i *Image) orient() error {
+	var o = i.image.orientation
+	if !autoOrient || o == C.UndefinedOrientation || o == C.TopLeftOrientation {
+		return nil
+	}
+
+	exception := C.AcquireExceptionInfo()
+	defer C.DestroyExceptionInfo(exception)
+
+	newImg := C.AutoOrientImage(i.image, o, exception)
+	if C.HasError(exception) == 1 {
+		return makeError(exception)
+	}
+
+	i.replace(newImg)
+	return nil
+}
+
+// format returns the ImageMagick format name of the file, such as "GIF"
+func (i *Image) format() string {
+	return C.GoString(&i.image.magick[0])
+}
+
+// coalesce replaces the image list with one where each image is the full
+// frame of an animation as it would be displayed
+func (i *Image) coalesce() error {
+	exception := C.AcquireExceptionInfo()
+	defer C.DestroyExceptionInfo(exception)
+
+	newImg := C.CoalesceImages(i.image, exception)
+	if C.HasError(exception) == 1 {
+		return makeError(exception)
+	}
+
+	i.replace(newImg)
+	return nil
+}
+
 func (i *Image) replace(newImg *C.Image) {
 	i.cleanupImage()
 	i.image = newImg
 }
 
+// PageCount returns the number of images in the file, e.g., the number of
+// pages in a multi-page TIFF or frames in an animated GIF
+func (i *Image) PageCount() int {
+	return int(C.GetImageListLength(i.image))
+}
+
+// SetPage replaces the loaded image list with a copy of just the given
+// zero-based page, so all later operations work on that page alone
+func (i *Image) SetPage(n int) error {
+	if n < 0 || n >= i.PageCount() {
+		return fmt.Errorf("page %d out of range", n)
+	}
+	if i.PageCount() == 1 {
+		return nil
+	}
+
+	exception := C.AcquireExceptionInfo()
+	defer C.DestroyExceptionInfo(exception)
+
+	page := C.GetImageFromList(i.image, C.ssize_t(n))
+	newImg := C.CloneImage(page, 0, 0, C.MagickTrue, exception)
+	if C.HasError(exception) == 1 {
+		return makeError(exception)
+	}
+
+	i.replace(newImg)
+	return i.orient()
+}
+
 // GetWidth returns the Width of the loaded image in pixels as an int
 func (i *Image) GetWidth() int {
 	return (int)(i.image.columns)
@@ -161,12 +261,17 @@ func (i *Image) DecodeImage() (image.Image, error) {
 		}
 	}
 
-	if i.decodeWidth != i.decodeArea.Dx() || i.decodeHeight != i.decodeArea.Dy() {
+	var needsResize = i.decodeWidth != i.decodeArea.Dx() || i.decodeHeight != i.decodeArea.Dy()
+	if needsResize && i.filter == "" {
 		err := i.doResize(i.decodeWidth, i.decodeHeight)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return i.Image()
+	img, err := i.Image()
+	if err == nil && needsResize && i.filter != "" {
+		img = i.filter.Resize(img, i.decodeWidth, i.decodeHeight)
+	}
+	return img, err
 }
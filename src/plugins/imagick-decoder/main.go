@@ -11,8 +11,8 @@
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"rais/src/img"
+	"rais/src/plugins"
 	"unsafe"
 
 	"github.com/uoregon-libraries/gopkg/logger"
@@ -20,14 +20,28 @@
 
 var l *logger.Logger
 
+// autoOrient is true if images with an EXIF orientation should be rotated
+// upright as they're read
+var autoOrient bool
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapDecoder}
+
 // SetLogger is called by the RAIS server's plugin manager to let plugins use
 // the central logger
 func SetLogger(raisLogger *logger.Logger) {
 	l = raisLogger
 }
 
-// Initialize sets up the MagickCore stuff
+// Initialize sets up the MagickCore stuff and reads our settings
 func Initialize() {
+	var c = plugins.NewConfig("Imagick")
+	c.SetDefault("AutoOrient", true)
+	autoOrient = c.GetBool("AutoOrient")
+
 	path, _ := os.Getwd()
 	cPath := C.CString(path)
 	defer C.free(unsafe.Pointer(cPath))
@@ -38,17 +52,23 @@ func makeError(exception *C.ExceptionInfo) error {
 	return fmt.Errorf("%v: %v - %v", exception.severity, exception.reason, exception.description)
 }
 
-// ImageDecoders returns our list of one: the magick decoder used for the image
-// types we support
-func ImageDecoders() []img.DecodeFn {
-	return []img.DecodeFn{decodeCommonFile}
+// NamedImageDecoders returns our list of one: the magick decoder used for the
+// image types we support.  Other extensions ImageMagick can read may be mapped
+// to "imagick" via the DecoderExtensions setting.
+func NamedImageDecoders() []img.NamedDecoder {
+	return []img.NamedDecoder{{
+		Name:       "imagick",
+		Extensions: []string{".tif", ".tiff", ".png", ".jpg", ".jpeg", ".gif"},
+		Magic: [][]byte{
+			[]byte("II*\x00"), []byte("MM\x00*"), // TIFF, little- and big-endian
+			[]byte("\x89PNG\r\n\x1a\n"),
+			[]byte("\xff\xd8\xff"),
+			[]byte("GIF87a"), []byte("GIF89a"),
+		},
+		Decode: decodeCommonFile,
+	}}
 }
 
 func decodeCommonFile(path string) (img.Decoder, error) {
-	switch filepath.Ext(path) {
-	case ".tif", ".tiff", ".png", ".jpg", "jpeg", ".gif":
-		return NewImage(path)
-	default:
-		return nil, img.ErrNotHandled
-	}
+	return NewImage(path)
 }
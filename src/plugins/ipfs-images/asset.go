@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"rais/src/iiif"
+	"strings"
+	"sync"
+)
+
+var assets = make(map[iiif.ID]*asset)
+var assetMutex sync.Mutex
+
+// asset represents a single IPFS file: the CID, the path within the CID (if
+// any), and the location of the locally cached copy
+type asset struct {
+	id      iiif.ID
+	cid     string
+	subpath string
+	path    string
+
+	m     sync.Mutex
+	inUse bool
+	fs    sync.Mutex
+}
+
+// parseID splits an "ipfs://" ID into its CID and subpath.  ok is false if
+// the ID isn't an IPFS ID or is malformed.
+func parseID(id iiif.ID) (cid, subpath string, ok bool) {
+	var s = string(id)
+	if !strings.HasPrefix(s, "ipfs://") {
+		return "", "", false
+	}
+
+	s = strings.TrimPrefix(s, "ipfs://")
+	var parts = strings.SplitN(s, "/", 2)
+	cid = parts[0]
+	if len(parts) == 2 {
+		subpath = parts[1]
+	}
+
+	// CIDs are base-encoded hashes, so anything outside of alphanumerics is
+	// bogus; and subpaths mustn't be able to escape the cache directory
+	if cid == "" || strings.IndexFunc(cid, notAlnum) != -1 {
+		return "", "", false
+	}
+	if subpath != "" && path.Clean("/"+subpath) != "/"+subpath {
+		return "", "", false
+	}
+
+	return cid, subpath, true
+}
+
+func notAlnum(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+}
+
+// lookupAsset returns the tracked asset for the given id, creating it if
+// necessary.  If the id isn't a valid IPFS id, nil is returned.
+func lookupAsset(id iiif.ID) *asset {
+	var cid, subpath, ok = parseID(id)
+	if !ok {
+		return nil
+	}
+
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+
+	var a = assets[id]
+	if a == nil {
+		a = &asset{id: id, cid: cid, subpath: subpath}
+		a.path = filepath.Join(ipfsCache, cid, filepath.FromSlash(subpath))
+		if subpath == "" {
+			a.path = filepath.Join(ipfsCache, cid, "content")
+		}
+		assets[id] = a
+	}
+
+	return a
+}
+
+// tryLock attempts to lock the asset for file writing without blocking,
+// returning true if the lock was acquired
+func (a *asset) tryLock() bool {
+	a.m.Lock()
+	var inUse = a.inUse
+	if !inUse {
+		a.fs.Lock()
+		a.inUse = true
+	}
+	a.m.Unlock()
+
+	return !inUse
+}
+
+func (a *asset) lock() {
+	a.m.Lock()
+	a.fs.Lock()
+	a.inUse = true
+	a.m.Unlock()
+}
+
+func (a *asset) unlock() {
+	a.m.Lock()
+	a.inUse = false
+	a.fs.Unlock()
+	a.m.Unlock()
+}
+
+// purge removes the cached file.  Errors are logged rather than returned, as
+// purging is done asynchronously.
+func (a *asset) purge() {
+	var err = os.Remove(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		l.Errorf("ipfs-images plugin: unable to purge cached file at %q: %s", a.path, err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestParseID(t *testing.T) {
+	var cid, subpath, ok = parseID(iiif.ID("ipfs://bafybeigdyrzt/scans/page1.jp2"))
+	assert.True(ok, "valid ID", t)
+	assert.Equal("bafybeigdyrzt", cid, "cid", t)
+	assert.Equal("scans/page1.jp2", subpath, "subpath", t)
+
+	cid, subpath, ok = parseID(iiif.ID("ipfs://QmYwAPJzv5CZsnA"))
+	assert.True(ok, "bare CID is valid", t)
+	assert.Equal("QmYwAPJzv5CZsnA", cid, "cid", t)
+	assert.Equal("", subpath, "no subpath", t)
+
+	_, _, ok = parseID(iiif.ID("s3://bucket/key.jp2"))
+	assert.False(ok, "non-IPFS ID", t)
+	_, _, ok = parseID(iiif.ID("ipfs://bafy/../../etc/passwd"))
+	assert.False(ok, "path traversal", t)
+	_, _, ok = parseID(iiif.ID("ipfs://../foo.jp2"))
+	assert.False(ok, "bogus CID", t)
+}
+
+func TestLookupAsset(t *testing.T) {
+	ipfsCache = "/tmp"
+	ipfsGateway = "http://127.0.0.1:8080/"
+	var id = iiif.ID("ipfs://bafybeigdyrzt/scans/page 1.jp2")
+	var a = lookupAsset(id)
+	assert.Equal("/tmp/bafybeigdyrzt/scans/page 1.jp2", a.path, "path", t)
+	assert.Equal("http://127.0.0.1:8080/ipfs/bafybeigdyrzt/scans/page%201.jp2", a.gatewayURL(), "gateway URL", t)
+	assert.True(a == lookupAsset(id), "second lookup returns the same asset", t)
+	assert.True(lookupAsset(iiif.ID("foo")) == nil, "non-IPFS ID returns nil", t)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/fileutil"
+)
+
+var client = &http.Client{Timeout: time.Minute * 5}
+
+// gatewayURL returns the full URL for requesting the asset from the
+// configured gateway
+func (a *asset) gatewayURL() string {
+	var u = strings.TrimRight(ipfsGateway, "/") + "/ipfs/" + url.PathEscape(a.cid)
+	if a.subpath != "" {
+		var parts = strings.Split(a.subpath, "/")
+		for i, p := range parts {
+			parts[i] = url.PathEscape(p)
+		}
+		u += "/" + strings.Join(parts, "/")
+	}
+	return u
+}
+
+// download pulls the asset from the gateway unless it's already cached
+func (a *asset) download() error {
+	var _, err = os.Stat(a.path)
+	if err == nil {
+		return nil
+	}
+
+	var parentDir = filepath.Dir(a.path)
+	err = os.MkdirAll(parentDir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create cached file path %q: %s", parentDir, err)
+	}
+
+	var u = a.gatewayURL()
+	l.Debugf("ipfs-images plugin: no cached file at %q; downloading from %q", a.path, u)
+	var resp *http.Response
+	resp, err = client.Get(u)
+	if err != nil {
+		return fmt.Errorf("unable to request %q: %s", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to request %q: gateway returned %s", u, resp.Status)
+	}
+
+	var f = fileutil.NewSafeFile(a.path)
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		f.Cancel()
+		return fmt.Errorf("unable to download %q: %s", u, err)
+	}
+
+	return f.Close()
+}
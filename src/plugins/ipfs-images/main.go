@@ -0,0 +1,140 @@
+// This file is an example of a content-addressed source plugin.  When a
+// resource is requested, if its IIIF id begins with "ipfs://", we treat the
+// rest of the id as an IPFS CID, optionally followed by a path within that
+// CID's directory tree, e.g., "ipfs://bafybeigdyr.../scans/page1.jp2".
+//
+// Files are fetched from an IPFS HTTP gateway, which may be a local node
+// ("http://127.0.0.1:8080", the default for go-ipfs) or a public gateway.  The
+// gateway is configured via `IPFSGateway` in the RAIS toml file or by setting
+// `RAIS_IPFSGATEWAY` in the environment; if it isn't set, this plugin is
+// disabled.
+//
+// Downloaded files are cached locally, much like the s3-images plugin does.
+// The cache location is configurable via `IPFSCache` / `RAIS_IPFSCACHE`, and
+// defaults to `/var/local/rais-ipfs`.  Because IPFS content never changes for
+// a given CID, cached files never go stale; they only need purging to reclaim
+// disk space.  The standard cache purge API handles this, or an external job
+// can simply delete old files from the cache directory.
+//
+// As with S3 assets, we assume the file is already a format RAIS can serve,
+// and the cached file keeps the extension of the IPFS path.  A bare CID with
+// no path has no extension, so such IDs need to point to a file path within
+// a directory CID to be useful.
+
+package main
+
+import (
+	"errors"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/fileutil"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l = logger.Named("rais/ipfs-plugin", logger.Debug)
+
+var ipfsCache, ipfsGateway string
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapIDToPath, plugins.CapCachePurge}
+
+// Disabled lets the plugin manager know not to add this plugin's functions to
+// the global list unless sanity checks in Initialize() pass
+var Disabled = true
+
+// Initialize sets up package variables for IPFS pulls and verifies sanity of
+// the configuration
+func Initialize() {
+	var c = plugins.NewConfig("IPFS")
+	c.SetDefault("Cache", "/var/local/rais-ipfs")
+	ipfsCache = c.GetString("Cache")
+	ipfsGateway = c.GetString("Gateway")
+
+	if ipfsGateway == "" {
+		l.Infof("IPFS plugin will not be enabled: IPFSGateway must be set in rais.toml or RAIS_IPFSGATEWAY must be set in the environment")
+		return
+	}
+
+	l.Debugf("Setting IPFS cache location to %q", ipfsCache)
+	l.Debugf("Setting IPFS gateway to %q", ipfsGateway)
+	Disabled = false
+
+	if fileutil.IsDir(ipfsCache) {
+		return
+	}
+	if !fileutil.MustNotExist(ipfsCache) {
+		l.Fatalf("IPFS plugin failure: %q must not exist or else must be a directory", ipfsCache)
+	}
+}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// IDToPath implements the auto-download logic when a IIIF ID starts with
+// "ipfs://"
+func IDToPath(id iiif.ID) (path string, err error) {
+	var a = lookupAsset(id)
+	if a == nil {
+		return "", plugins.ErrSkipped
+	}
+
+	// Only one request can download a given asset; the rest wait briefly
+	var timeout = time.Now().Add(time.Second * 10)
+	for !a.tryLock() {
+		time.Sleep(time.Millisecond * 250)
+		if time.Now().After(timeout) {
+			return "", errors.New("timed out waiting for locked asset (probably very slow download)")
+		}
+	}
+
+	err = a.download()
+	a.unlock()
+
+	return a.path, err
+}
+
+// PurgeCaches deletes all cached files this plugin is tracking.  Deletion
+// happens in the background so the API isn't sitting for potentially many
+// minutes prior to responding to the caller.
+func PurgeCaches() {
+	assetMutex.Lock()
+	var ids []iiif.ID
+	for id := range assets {
+		ids = append(ids, id)
+	}
+	assetMutex.Unlock()
+
+	go func() {
+		for _, id := range ids {
+			ExpireCachedImage(id)
+			time.Sleep(time.Millisecond * 250)
+		}
+		l.Infof("ipfs-images plugin: mass-purged %d assets", len(ids))
+	}()
+}
+
+// ExpireCachedImage removes the cached file for the given id, if one exists
+func ExpireCachedImage(id iiif.ID) {
+	assetMutex.Lock()
+	var a, ok = assets[id]
+	delete(assets, id)
+	assetMutex.Unlock()
+
+	if !ok {
+		l.Debugf("ipfs-images plugin: purging %q: no local asset cached", id)
+		return
+	}
+
+	a.lock()
+	a.purge()
+	a.unlock()
+	l.Infof("ipfs-images plugin: purging %q: success", id)
+}
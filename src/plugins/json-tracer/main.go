@@ -15,9 +15,9 @@
 
 import (
 	"net/http"
+	"rais/src/plugins"
 	"time"
 
-	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/logger"
 )
 
@@ -25,6 +25,12 @@
 var jsonOut string
 var reg *registry
 
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapWrapHandler}
+
 // Disabled lets the plugin manager know not to add this plugin's functions to
 // the global list unless sanity checks in Initialize() pass
 var Disabled = true
@@ -34,9 +40,10 @@
 
 // Initialize reads configuration and sets up the JSON output directory
 func Initialize() {
-	viper.SetDefault("TracerFlushSeconds", 10)
-	flushTime = time.Second * time.Duration(viper.GetInt("TracerFlushSeconds"))
-	jsonOut = viper.GetString("TracerOut")
+	var c = plugins.NewConfig("Tracer")
+	c.SetDefault("FlushSeconds", 10)
+	flushTime = time.Second * time.Duration(c.GetInt("FlushSeconds"))
+	jsonOut = c.GetString("Out")
 
 	if jsonOut == "" {
 		l.Warnf("TracerOut must be configured, or RAIS_TRACEROUT must be set in the environment  **JSON Tracer plugin is disabled**")
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxQueued is the most spans we'll hold onto if the collector is
+// unreachable; beyond this, the oldest spans are dropped
+const maxQueued = 10000
+
+// exporter batches finished spans and periodically sends them to the OTLP
+// collector
+type exporter struct {
+	sync.Mutex
+	url     string
+	service string
+	flush   time.Duration
+	client  *http.Client
+	spans   []*span
+	done    chan bool
+}
+
+func newExporter(endpoint, service string, flush time.Duration) *exporter {
+	return &exporter{
+		url:     strings.TrimRight(endpoint, "/") + "/v1/traces",
+		service: service,
+		flush:   flush,
+		client:  &http.Client{Timeout: time.Second * 10},
+		done:    make(chan bool),
+	}
+}
+
+func (e *exporter) add(s *span) {
+	e.Lock()
+	e.spans = append(e.spans, s)
+	if len(e.spans) > maxQueued {
+		e.spans = e.spans[len(e.spans)-maxQueued:]
+	}
+	e.Unlock()
+}
+
+// loop sends spans on a timer until shutdown is called.  This must run in a
+// background goroutine.
+func (e *exporter) loop() {
+	var t = time.NewTicker(e.flush)
+	defer t.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-t.C:
+			e.send()
+		}
+	}
+}
+
+func (e *exporter) shutdown() {
+	close(e.done)
+	e.send()
+}
+
+// send exports all queued spans.  On failure, spans are put back in the queue
+// so they can be retried on the next flush.
+func (e *exporter) send() {
+	e.Lock()
+	var spans = e.spans
+	e.spans = nil
+	e.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	var err = e.post(spans)
+	if err != nil {
+		l.Errorf("otel-tracer plugin: unable to export %d span(s): %s", len(spans), err)
+		e.Lock()
+		e.spans = append(spans, e.spans...)
+		e.Unlock()
+	}
+}
+
+func (e *exporter) post(spans []*span) error {
+	var body, err = json.Marshal(e.payload(spans))
+	if err != nil {
+		return err
+	}
+
+	var resp *http.Response
+	resp, err = e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below mirror the subset of the OTLP/JSON trace schema we need
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+func attr(key string, val interface{}) otlpAttr {
+	var a = otlpAttr{Key: key}
+	switch v := val.(type) {
+	case int:
+		var s = strconv.Itoa(v)
+		a.Value.IntValue = &s
+	case bool:
+		a.Value.BoolValue = &v
+	default:
+		var s = fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
+
+func (e *exporter) payload(spans []*span) interface{} {
+	var out = make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = otlpSpan{
+			TraceID:      s.traceID,
+			SpanID:       s.spanID,
+			ParentSpanID: s.parentID,
+			Name:         s.name,
+			Kind:         s.kind,
+			Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+			End:          strconv.FormatInt(s.finish.UnixNano(), 10),
+		}
+		for k, v := range s.attrs {
+			out[i].Attributes = append(out[i].Attributes, attr(k, v))
+		}
+		if s.err {
+			out[i].Status.Code = 2
+		}
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttr{attr("service.name", e.service)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "rais"},
+						"spans": out,
+					},
+				},
+			},
+		},
+	}
+}
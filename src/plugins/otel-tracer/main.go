@@ -0,0 +1,118 @@
+// This file creates a plugin for exporting RAIS traces to an OpenTelemetry
+// collector (or any other service which accepts OTLP over HTTP, such as Jaeger
+// or Honeycomb).  Every request gets a root span, and RAIS's core adds child
+// spans for IIIF URL parsing, plugin ID resolution (which includes S3 and
+// other remote downloads), info lookups, decoding, encoding, and cache
+// operations.
+//
+// Usage requires "OTelEndpoint" in rais.toml or RAIS_OTELENDPOINT in the
+// environment.  This is the base URL of the collector's OTLP/HTTP receiver,
+// e.g., "http://localhost:4318"; spans are sent to "<endpoint>/v1/traces"
+// using OTLP's JSON encoding.
+//
+// Optional settings:
+//
+// - "OTelServiceName" / RAIS_OTELSERVICENAME: the service.name resource
+//   attribute reported for all spans; defaults to "rais"
+// - "OTelFlushSeconds" / RAIS_OTELFLUSHSECONDS: how often to send batched
+//   spans; defaults to 5
+//
+// Incoming W3C "traceparent" headers are honored, so RAIS spans can be part
+// of a larger trace started by a proxy or application in front of RAIS.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"rais/src/plugins"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l *logger.Logger
+var exp *exporter
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapWrapHandler, plugins.CapTracer}
+
+// Disabled lets the plugin manager know not to add this plugin's functions to
+// the global list unless sanity checks in Initialize() pass
+var Disabled = true
+
+// Initialize reads configuration and starts the background exporter
+func Initialize() {
+	var c = plugins.NewConfig("OTel")
+	c.SetDefault("ServiceName", "rais")
+	c.SetDefault("FlushSeconds", 5)
+	var endpoint = c.GetString("Endpoint")
+	if endpoint == "" {
+		l.Warnf("OTelEndpoint must be configured, or RAIS_OTELENDPOINT must be set in the environment  **OpenTelemetry plugin is disabled**")
+		return
+	}
+
+	var flush = time.Second * time.Duration(c.GetInt("FlushSeconds"))
+	exp = newExporter(endpoint, c.GetString("ServiceName"), flush)
+	go exp.loop()
+
+	l.Debugf("Sending OpenTelemetry traces to %q", exp.url)
+	Disabled = false
+}
+
+// WrapHandler starts a root span for every request RAIS serves
+func WrapHandler(pattern string, handler http.Handler) (http.Handler, error) {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ctx = req.Context()
+		var parent = parseTraceparent(req.Header.Get("traceparent"))
+		if parent != nil {
+			ctx = context.WithValue(ctx, spanKey{}, parent)
+		}
+
+		var s *span
+		ctx, s = newSpan(ctx, "HTTP "+req.Method+" "+pattern, spanKindServer)
+		s.attr("http.method", req.Method)
+		s.attr("http.target", req.URL.RequestURI())
+		s.attr("http.route", pattern)
+
+		var sr = &statusRecorder{w, http.StatusOK}
+		handler.ServeHTTP(sr, req.WithContext(ctx))
+
+		s.attr("http.status_code", sr.status)
+		if sr.status >= 500 {
+			s.err = true
+		}
+		s.end()
+	}), nil
+}
+
+// StartSpan is called by RAIS for each traced operation within a request
+func StartSpan(ctx context.Context, name string) (context.Context, func()) {
+	var s *span
+	ctx, s = newSpan(ctx, name, spanKindInternal)
+	return ctx, s.end
+}
+
+// Teardown sends any spans which haven't yet been exported
+func Teardown() {
+	exp.shutdown()
+}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// OTLP span kinds we use
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+)
+
+type spanKey struct{}
+
+// span holds the data for a single traced operation
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+	finish   time.Time
+	attrs    map[string]interface{}
+	err      bool
+}
+
+func randomHex(n int) string {
+	var b = make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newSpan starts a span as a child of whatever span is in ctx, if any, and
+// returns a context holding the new span
+func newSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	var s = &span{
+		spanID: randomHex(8),
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  make(map[string]interface{}),
+	}
+
+	var parent, _ = ctx.Value(spanKey{}).(*span)
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomHex(16)
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) attr(key string, val interface{}) {
+	s.attrs[key] = val
+}
+
+// end marks the span complete and queues it for export
+func (s *span) end() {
+	s.finish = time.Now()
+	exp.add(s)
+}
+
+// parseTraceparent reads a W3C traceparent header ("00-traceid-spanid-flags")
+// and returns a placeholder span representing the remote parent, or nil if
+// the header is missing or invalid
+func parseTraceparent(h string) *span {
+	var parts = strings.Split(h, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil
+	}
+	for _, p := range parts[1:3] {
+		if _, err := hex.DecodeString(p); err != nil || strings.Trim(p, "0") == "" {
+			return nil
+		}
+	}
+
+	return &span{traceID: strings.ToLower(parts[1]), spanID: strings.ToLower(parts[2])}
+}
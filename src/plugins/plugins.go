@@ -2,6 +2,18 @@
 // RAIS could be extended.  These plugins are not necessarily fleshed out
 // fully, and are intended more to show what's possible than what may be
 // useful.
+//
+// Plugins should declare which version of the plugin interface they were
+// written for, and which capabilities they provide, by exporting these:
+//
+//	var PluginAPIVersion = 2
+//	var PluginCapabilities = []string{plugins.CapIDToPath, plugins.CapCachePurge}
+//
+// RAIS refuses to load a plugin built for a version it doesn't support, or
+// one whose exported functions don't match its declared capabilities, rather
+// than silently ignoring hooks it doesn't recognize.  Plugins which don't
+// declare a version are treated as version 1, where hooks are found purely
+// by their exported names.
 package plugins
 
 import "errors"
@@ -11,3 +23,47 @@
 // generally be reported, as it's not a situation that's concerning (much like
 // io.EOF when reading a file).
 var ErrSkipped = errors.New("plugin doesn't handle this feature")
+
+// ErrForbidden is an error IDToPath plugins can return to state that they
+// handle the given ID, but the server isn't allowed to serve it.  RAIS
+// responds with a 403 rather than trying other plugins.
+var ErrForbidden = errors.New("access to this resource is forbidden")
+
+// DryRun is true when RAIS is only checking its configuration
+// (rais-server --check-config) and will exit rather than serve anything.
+// Plugins should still read and validate their settings in Initialize, but
+// shouldn't start background work or change anything on disk.
+var DryRun bool
+
+// APIVersion is the newest plugin interface version RAIS supports
+const APIVersion = 2
+
+// Capabilities a plugin may declare in PluginCapabilities.  Each is provided
+// by one or more exported functions:
+//
+//   - CapIDToPath: IDToPath or IDToPathContext
+//   - CapDecoder: ImageDecoders and/or NamedImageDecoders
+//   - CapCachePurge: PurgeCaches and/or ExpireCachedImage
+//   - CapWrapHandler: WrapHandler
+//   - CapTracer: StartSpan
+//   - CapInfoDecorator: DecorateInfo
+//   - CapEncoder: ImageEncoders
+//
+// IDToPathContext works like IDToPath, but takes the request's context as its
+// first argument so that slow work, like downloading an image, can be
+// abandoned when the client disconnects or the request times out.  If a
+// plugin exports both, IDToPathContext is used.  The context also carries the
+// request's id: plugins should log with requestid.Logger(ctx, l) so their
+// messages can be tied to the request which caused them.
+//
+// SetLogger, Initialize, Teardown, and Disabled are available to all
+// plugins, and aren't capabilities.
+const (
+	CapIDToPath      = "IDToPath"
+	CapDecoder       = "Decoder"
+	CapCachePurge    = "CachePurge"
+	CapWrapHandler   = "WrapHandler"
+	CapTracer        = "Tracer"
+	CapInfoDecorator = "InfoDecorator"
+	CapEncoder       = "Encoder"
+)
@@ -0,0 +1,223 @@
+package main
+
+/*
+#cgo pkg-config: libraw_r
+#include <stdlib.h>
+#include <libraw/libraw.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"rais/src/transform"
+	"runtime"
+	"unsafe"
+)
+
+// Image implements img.Decoder for camera RAW files.  LibRaw develops the
+// whole sensor image at once, so regions are cropped and scaled in Go
+// afterward.  When a request needs half the resolution or less, LibRaw's
+// half-size mode is used instead: it skips demosaicing entirely by merging
+// each 2x2 block of sensor pixels, which is several times faster.
+type Image struct {
+	raw          *C.libraw_data_t
+	decodeWidth  int
+	decodeHeight int
+	decodeArea   image.Rectangle
+	filter       transform.Filter
+}
+
+func rawError(code C.int) error {
+	if code == C.LIBRAW_SUCCESS {
+		return nil
+	}
+	return fmt.Errorf("libraw: %s", C.GoString(C.libraw_strerror(code)))
+}
+
+// NewImage reads the given file's headers so its dimensions are known
+func NewImage(filename string) (*Image, error) {
+	cFilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cFilename))
+
+	var raw = C.libraw_init(0)
+	if raw == nil {
+		return nil, errors.New("libraw: unable to initialize")
+	}
+	i := &Image{raw: raw}
+	runtime.SetFinalizer(i, finalizer)
+
+	var err = rawError(C.libraw_open_file(i.raw, cFilename))
+	if err != nil {
+		i.CleanupResources()
+		return nil, err
+	}
+	return i, nil
+}
+
+// SetFilter tells the decoder which filter to scale images with
+func (i *Image) SetFilter(f transform.Filter) {
+	i.filter = f
+}
+
+// SetResizeWH sets the image to scale to the given width and height.  If one
+// dimension is 0, the decoded image will preserve the aspect ratio while
+// scaling to the non-zero dimension.
+func (i *Image) SetResizeWH(width, height int) {
+	i.decodeWidth = width
+	i.decodeHeight = height
+}
+
+// SetCrop sets the image to crop to the given rectangle
+func (i *Image) SetCrop(r image.Rectangle) {
+	i.decodeArea = r
+}
+
+// rotated is true if the camera was held sideways, in which case LibRaw's
+// output swaps the sensor's width and height
+func (i *Image) rotated() bool {
+	return i.raw.sizes.flip&4 != 0
+}
+
+// GetWidth returns the width of the developed image, after any rotation
+// stored in the file
+func (i *Image) GetWidth() int {
+	if i.rotated() {
+		return int(i.raw.sizes.height)
+	}
+	return int(i.raw.sizes.width)
+}
+
+// GetHeight returns the height of the developed image
+func (i *Image) GetHeight() int {
+	if i.rotated() {
+		return int(i.raw.sizes.width)
+	}
+	return int(i.raw.sizes.height)
+}
+
+// GetTileWidth returns 0, as RAW sensor data isn't tiled in any useful way
+func (i *Image) GetTileWidth() int {
+	return 0
+}
+
+// GetTileHeight returns 0, as RAW sensor data isn't tiled in any useful way
+func (i *Image) GetTileHeight() int {
+	return 0
+}
+
+// GetLevels returns 1; half-size decoding is a speedup, not a true
+// resolution level
+func (i *Image) GetLevels() int {
+	return 1
+}
+
+// DecodeImage develops the RAW data and returns the requested region at the
+// requested size
+func (i *Image) DecodeImage() (image.Image, error) {
+	w, h := i.GetWidth(), i.GetHeight()
+	if i.decodeArea == image.ZR {
+		i.decodeArea = image.Rect(0, 0, w, h)
+	}
+	if i.decodeWidth == 0 && i.decodeHeight == 0 {
+		i.decodeWidth = i.decodeArea.Dx()
+		i.decodeHeight = i.decodeArea.Dy()
+	}
+	if i.decodeWidth == 0 {
+		i.decodeWidth = i.decodeArea.Dx() * i.decodeHeight / i.decodeArea.Dy()
+	}
+	if i.decodeHeight == 0 {
+		i.decodeHeight = i.decodeArea.Dy() * i.decodeWidth / i.decodeArea.Dx()
+	}
+
+	var half = i.decodeWidth*2 <= i.decodeArea.Dx() && i.decodeHeight*2 <= i.decodeArea.Dy()
+	i.raw.params.output_bps = 8
+	i.raw.params.use_camera_wb = 1
+	i.raw.params.user_qual = C.int(demosaic)
+	i.raw.params.half_size = 0
+	if half {
+		i.raw.params.half_size = 1
+	}
+
+	var src, err = i.develop()
+	if err != nil {
+		return nil, err
+	}
+
+	// Map the requested area onto whatever LibRaw gave us, which is smaller
+	// than the full image in half-size mode, and may be off by a few pixels
+	// otherwise due to the sensor's margins
+	var sw, sh = src.Bounds().Dx(), src.Bounds().Dy()
+	var area = i.decodeArea
+	if sw != w || sh != h {
+		area = image.Rect(area.Min.X*sw/w, area.Min.Y*sh/h, area.Max.X*sw/w, area.Max.Y*sh/h)
+	}
+
+	if area.Dx() != i.decodeWidth || area.Dy() != i.decodeHeight {
+		return i.filter.Resize(src.(subImager).SubImage(area), i.decodeWidth, i.decodeHeight), nil
+	}
+	if area == src.Bounds() {
+		return src, nil
+	}
+
+	// The rest of RAIS expects images to start at 0,0, which a SubImage doesn't
+	var out = image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(out, out.Bounds(), src, area.Min, draw.Src)
+	return out, nil
+}
+
+type subImager interface {
+	SubImage(image.Rectangle) image.Image
+}
+
+// develop runs LibRaw's processing and copies the result into a Go image
+func (i *Image) develop() (image.Image, error) {
+	var err = rawError(C.libraw_unpack(i.raw))
+	if err == nil {
+		err = rawError(C.libraw_dcraw_process(i.raw))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var code C.int
+	var mem = C.libraw_dcraw_make_mem_image(i.raw, &code)
+	if mem == nil {
+		return nil, rawError(code)
+	}
+	defer C.libraw_dcraw_clear_mem(mem)
+
+	if mem._type != C.LIBRAW_IMAGE_BITMAP || mem.bits != 8 {
+		return nil, errors.New("libraw: unexpected image data")
+	}
+
+	var w, h = int(mem.width), int(mem.height)
+	var data = C.GoBytes(unsafe.Pointer(&mem.data), C.int(mem.data_size))
+	switch mem.colors {
+	case 1:
+		var out = image.NewGray(image.Rect(0, 0, w, h))
+		copy(out.Pix, data)
+		return out, nil
+	case 3:
+		var out = image.NewRGBA(image.Rect(0, 0, w, h))
+		for n := 0; n < w*h; n++ {
+			copy(out.Pix[n*4:n*4+3], data[n*3:n*3+3])
+			out.Pix[n*4+3] = 0xff
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("libraw: unsupported color count %d", mem.colors)
+}
+
+func finalizer(i *Image) {
+	i.CleanupResources()
+}
+
+// CleanupResources frees the C data allocated by LibRaw
+func (i *Image) CleanupResources() {
+	if i.raw != nil {
+		C.libraw_close(i.raw)
+		i.raw = nil
+	}
+}
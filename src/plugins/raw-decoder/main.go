@@ -0,0 +1,71 @@
+// Package main is a decoder plugin for camera RAW images (DNG, CR2, NEF, and
+// the many other formats LibRaw understands), so photo archives can serve
+// their RAW masters without converting them first.  Requires LibRaw 0.18 or
+// later and its development headers.  Like the ImageMagick plugin, this isn't
+// built by default; use "make bin/plugins/raw-decoder.so".
+package main
+
+import (
+	"rais/src/img"
+	"rais/src/plugins"
+	"strings"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l *logger.Logger
+
+// demosaicQualities maps the RawDemosaic setting to LibRaw's user_qual values
+var demosaicQualities = map[string]int{
+	"linear": 0,
+	"vng":    1,
+	"ppg":    2,
+	"ahd":    3,
+	"dcb":    4,
+	"dht":    11,
+	"aahd":   12,
+}
+
+// demosaic is the LibRaw interpolation used when decoding at full resolution
+var demosaic int
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapDecoder}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// Initialize reads our settings
+func Initialize() {
+	var c = plugins.NewConfig("Raw")
+	c.SetDefault("Demosaic", "ahd")
+
+	var val = c.GetString("Demosaic")
+	var q, ok = demosaicQualities[strings.ToLower(val)]
+	if !ok {
+		l.Fatalf("RAW plugin failure: invalid RawDemosaic %q", val)
+	}
+	demosaic = q
+}
+
+// NamedImageDecoders returns the "raw" decoder.  Most RAW formats are TIFF
+// underneath, so they can't be told apart from TIFFs by their first few
+// bytes; files need a known RAW extension, or a DecoderExtensions mapping to
+// "raw".
+func NamedImageDecoders() []img.NamedDecoder {
+	return []img.NamedDecoder{{
+		Name:       "raw",
+		Extensions: []string{".dng", ".cr2", ".cr3", ".nef", ".arw", ".orf", ".rw2", ".raf", ".pef"},
+		Decode:     decodeRAW,
+	}}
+}
+
+func decodeRAW(path string) (img.Decoder, error) {
+	return NewImage(path)
+}
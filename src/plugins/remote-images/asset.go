@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strings"
+	"sync"
+)
+
+var assets = make(map[iiif.ID]*asset)
+var assetMutex sync.Mutex
+
+// asset represents a single remote file: its URL, the allowed host it's on,
+// and the location of the locally cached copy
+type asset struct {
+	id   iiif.ID
+	url  *url.URL
+	host *host
+	path string
+
+	lockreader sync.Mutex
+	inUse      bool
+	fs         sync.Mutex
+}
+
+// parseID validates an HTTP(S) ID.  If the ID isn't a URL at all,
+// plugins.ErrSkipped is returned; if it's a URL we won't fetch,
+// plugins.ErrForbidden is returned.
+func parseID(id iiif.ID) (*url.URL, *host, error) {
+	var s = string(id)
+	if !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "http://") {
+		return nil, nil, plugins.ErrSkipped
+	}
+
+	var u, err = url.Parse(s)
+	if err != nil || u.Host == "" || u.User != nil || u.Fragment != "" {
+		return nil, nil, plugins.ErrForbidden
+	}
+	if u.Scheme == "http" && !allowHTTP {
+		return nil, nil, plugins.ErrForbidden
+	}
+
+	var h = hostFor(u.Host)
+	if h == nil {
+		return nil, nil, plugins.ErrForbidden
+	}
+	return u, h, nil
+}
+
+// cachePath returns where the file at u is cached: under the host's
+// directory, named by a hash of the full URL (so query strings and odd
+// characters can't cause trouble), and keeping the URL's extension so RAIS
+// knows how to decode it
+func cachePath(u *url.URL) string {
+	var sum = sha256.Sum256([]byte(u.String()))
+	var name = hex.EncodeToString(sum[:])
+	var ext = strings.ToLower(path.Ext(u.Path))
+	if ext != "" && strings.IndexFunc(ext[1:], notAlnum) == -1 {
+		name += ext
+	}
+	var hostDir = strings.Replace(strings.ToLower(u.Host), ":", "_", -1)
+	return filepath.Join(remoteCache, hostDir, name[:2], name[2:4], name)
+}
+
+func notAlnum(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+}
+
+// lookupAsset returns the tracked asset for the given id, creating it if
+// necessary
+func lookupAsset(id iiif.ID) (*asset, error) {
+	var u, h, err = parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+
+	var a = assets[id]
+	if a == nil {
+		a = &asset{id: id, url: u, host: h, path: cachePath(u)}
+		assets[id] = a
+	}
+	return a, nil
+}
+
+// tryFLock attempts to lock for file writing in a non-blocking way.  If the
+// lock can be acquired, the return is true, otherwise false.
+func (a *asset) tryFLock() bool {
+	a.lockreader.Lock()
+	var inUse = a.inUse
+	if !inUse {
+		a.fs.Lock()
+		a.inUse = true
+	}
+	a.lockreader.Unlock()
+
+	return !inUse
+}
+
+func (a *asset) fLock() {
+	a.lockreader.Lock()
+	a.fs.Lock()
+	a.inUse = true
+	a.lockreader.Unlock()
+}
+
+func (a *asset) fUnlock() {
+	a.lockreader.Lock()
+	a.inUse = false
+	a.fs.Unlock()
+	a.lockreader.Unlock()
+}
+
+// purge removes the cached file.  Errors are logged rather than returned, as
+// purging is done asynchronously.
+func (a *asset) purge() {
+	var err = os.Remove(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		l.Errorf("remote-images plugin: unable to purge cached file at %q: %s", a.path, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/uoregon-libraries/gopkg/fileutil"
+)
+
+// client is used for all remote requests.  Redirects are only followed to
+// allowed hosts, and each host only sees its own headers.
+var client = &http.Client{CheckRedirect: checkRedirect}
+
+// errTooLarge is returned when a remote file is bigger than RemoteMaxBytes
+var errTooLarge = errors.New("remote file exceeds RemoteMaxBytes")
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 5 {
+		return errors.New("too many redirects")
+	}
+	if req.URL.Scheme != "https" && !(req.URL.Scheme == "http" && allowHTTP) {
+		return fmt.Errorf("redirect to disallowed scheme %q", req.URL.Scheme)
+	}
+
+	var h = hostFor(req.URL.Host)
+	if h == nil {
+		return fmt.Errorf("redirect to disallowed host %q", req.URL.Host)
+	}
+	h.setHeaders(req.Header, hostFor(via[len(via)-1].URL.Host))
+	return nil
+}
+
+// download pulls the asset from its host unless it's already cached
+func (a *asset) download() error {
+	var _, err = os.Stat(a.path)
+	if err == nil {
+		return nil
+	}
+
+	var parentDir = filepath.Dir(a.path)
+	err = os.MkdirAll(parentDir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create cached file path %q: %s", parentDir, err)
+	}
+
+	var u = a.url.String()
+	l.Debugf("remote-images plugin: no cached file at %q; downloading from %q", a.path, u)
+	var req *http.Request
+	req, err = http.NewRequest("GET", u, nil)
+	if err != nil {
+		return fmt.Errorf("unable to request %q: %s", u, err)
+	}
+	a.host.setHeaders(req.Header, nil)
+
+	var resp *http.Response
+	resp, err = client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to request %q: %s", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to request %q: server returned %s", u, resp.Status)
+	}
+	if resp.ContentLength > maxBytes {
+		return fmt.Errorf("unable to download %q: %s", u, errTooLarge)
+	}
+
+	// Read one byte past the limit so we can tell if the file is too big
+	// when the server didn't send a content length
+	var f = fileutil.NewSafeFile(a.path)
+	var n int64
+	n, err = io.Copy(f, io.LimitReader(resp.Body, maxBytes+1))
+	if err == nil && n > maxBytes {
+		err = errTooLarge
+	}
+	if err != nil {
+		f.Cancel()
+		return fmt.Errorf("unable to download %q: %s", u, err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"rais/src/iiif"
+	"strings"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestDownload(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-remote")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	remoteCache = dir
+	allowHTTP = true
+	maxBytes = 10
+	defer func() { allowHTTP, maxBytes = false, 0 }()
+
+	var otherAuth string
+	var other = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		otherAuth = req.Header.Get("Authorization")
+		w.Write([]byte("other"))
+	}))
+	defer other.Close()
+
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer abc" {
+			http.Error(w, "no", http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/small.jp2":
+			w.Write([]byte("small"))
+		case "/big.jp2":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/redirect.jp2":
+			http.Redirect(w, req, other.URL+"/a.jp2", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	var srvHost = strings.TrimPrefix(srv.URL, "http://")
+	var otherHost = strings.TrimPrefix(other.URL, "http://")
+	hosts = map[string]*host{srvHost: {Host: srvHost, Headers: map[string]string{"Authorization": "Bearer abc"}}}
+	defer func() { hosts = nil }()
+
+	var path string
+	path, err = IDToPath(iiif.ID(srv.URL + "/small.jp2"))
+	assert.NilError(err, "downloading small file", t)
+	var data, _ = ioutil.ReadFile(path)
+	assert.Equal("small", string(data), "file contents", t)
+
+	_, err = IDToPath(iiif.ID(srv.URL + "/big.jp2"))
+	assert.True(err != nil && strings.Contains(err.Error(), errTooLarge.Error()), "large file is rejected", t)
+
+	_, err = IDToPath(iiif.ID(srv.URL + "/redirect.jp2"))
+	assert.True(err != nil, "redirect to disallowed host fails", t)
+
+	hosts[otherHost] = &host{Host: otherHost}
+	_, err = IDToPath(iiif.ID(srv.URL + "/redirect.jp2"))
+	assert.NilError(err, "redirect to allowed host", t)
+	assert.Equal("", otherAuth, "headers aren't sent to other hosts", t)
+}
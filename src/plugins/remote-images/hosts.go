@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// host is a remote server images may be pulled from, along with any headers
+// (e.g., Authorization) to send it
+type host struct {
+	Host    string
+	Headers map[string]string
+}
+
+// hosts holds all allowed hosts, keyed by lowercased "hostname" or
+// "hostname:port"
+var hosts = make(map[string]*host)
+
+// parseHostList reads a comma-separated list of hosts
+func parseHostList(val string) (map[string]*host, error) {
+	var list = make(map[string]*host)
+	for _, name := range strings.Split(val, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if strings.ContainsAny(name, "/@") {
+			return nil, fmt.Errorf("%q must be a hostname, optionally with a port", name)
+		}
+		list[name] = &host{Host: name}
+	}
+	return list, nil
+}
+
+// loadHosts reads hosts and their headers from a TOML file:
+//
+//	[[Host]]
+//	Host = "images.example.edu"
+//
+//	[Host.Headers]
+//	Authorization = "Bearer abc123"
+func loadHosts(fname string) (map[string]*host, error) {
+	var data struct {
+		Host []*host
+	}
+	var _, err = toml.DecodeFile(fname, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	var list = make(map[string]*host)
+	for _, h := range data.Host {
+		var parsed map[string]*host
+		parsed, err = parseHostList(h.Host)
+		if err != nil {
+			return nil, err
+		}
+		if len(parsed) != 1 {
+			return nil, fmt.Errorf("each [[Host]] must have exactly one host name")
+		}
+		h.Host = strings.ToLower(strings.TrimSpace(h.Host))
+		if list[h.Host] != nil {
+			return nil, fmt.Errorf("duplicate host %q", h.Host)
+		}
+		list[h.Host] = h
+	}
+	return list, nil
+}
+
+// hostFor returns the allowed host matching the given URL host ("name" or
+// "name:port"), or nil if it isn't allowed
+func hostFor(urlHost string) *host {
+	return hosts[strings.ToLower(urlHost)]
+}
+
+// setHeaders replaces any headers set for another host with the given host's
+// headers
+func (h *host) setHeaders(hdr http.Header, previous *host) {
+	if previous != nil {
+		for k := range previous.Headers {
+			hdr.Del(k)
+		}
+	}
+	for k, v := range h.Headers {
+		hdr.Set(k, v)
+	}
+}
@@ -0,0 +1,179 @@
+// This file is a production-safe alternative to the external-images example
+// plugin.  When a resource is requested, if its IIIF id is an "https://" URL
+// whose host is on a configured allowlist, the file is downloaded, cached
+// locally, and served like any other image.  IDs pointing at any other host
+// get a 403.
+//
+// Hosts are allowed via `RemoteHosts` (a comma-separated list such as
+// "images.example.edu, cdn.example.org:8443") and/or `RemoteHostsFile`, a TOML
+// file which can also give each host HTTP headers to send, e.g., for
+// authorization.  See remote-hosts-example.toml.  If no hosts are allowed,
+// this plugin is disabled.  Plain "http://" IDs are refused unless
+// `RemoteAllowHTTP` is true.
+//
+// Downloads are capped at `RemoteMaxBytes` (1 GB by default), and redirects
+// are only followed to other allowed hosts.  Each host's headers are only
+// ever sent to that host.
+//
+// As with the s3-images plugin, we assume the file is already a format RAIS
+// can serve (preferably JP2), and the cached file keeps the URL's extension.
+// The cache location is configurable via `RemoteCache` / `RAIS_REMOTECACHE`,
+// and defaults to `/var/local/rais-remote`.  Cached files are never
+// refreshed on their own; use the cache purge API, or delete old files from
+// the cache directory, when the remote images change.
+
+package main
+
+import (
+	"errors"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/fileutil"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l = logger.Named("rais/remote-plugin", logger.Debug)
+
+var remoteCache string
+var maxBytes int64
+var allowHTTP bool
+
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapIDToPath, plugins.CapCachePurge}
+
+// Disabled lets the plugin manager know not to add this plugin's functions to
+// the global list unless sanity checks in Initialize() pass
+var Disabled = true
+
+// Initialize sets up package variables for remote pulls and verifies sanity
+// of the configuration
+func Initialize() {
+	var c = plugins.NewConfig("Remote")
+	c.SetDefault("Cache", "/var/local/rais-remote")
+	c.SetDefault("MaxBytes", 1<<30)
+	c.SetDefault("Timeout", "5m")
+	remoteCache = c.GetString("Cache")
+	maxBytes = c.GetInt64("MaxBytes")
+	allowHTTP = c.GetBool("AllowHTTP")
+
+	var err error
+	hosts, err = parseHostList(c.GetString("Hosts"))
+	if err != nil {
+		l.Fatalf("Remote plugin failure: malformed RemoteHosts: %s", err)
+	}
+	var hostsFile = c.GetString("HostsFile")
+	if hostsFile != "" {
+		var fileHosts map[string]*host
+		fileHosts, err = loadHosts(hostsFile)
+		if err != nil {
+			l.Fatalf("Remote plugin failure: invalid RemoteHostsFile %q: %s", hostsFile, err)
+		}
+		for name, h := range fileHosts {
+			hosts[name] = h
+		}
+	}
+
+	if len(hosts) == 0 {
+		l.Infof("Remote plugin will not be enabled: RemoteHosts or RemoteHostsFile must be set in rais.toml " +
+			"or RAIS_REMOTEHOSTS or RAIS_REMOTEHOSTSFILE must be set in the environment")
+		return
+	}
+	if maxBytes <= 0 {
+		l.Fatalf("Remote plugin failure: RemoteMaxBytes must be positive")
+	}
+
+	var timeout = c.GetString("Timeout")
+	client.Timeout, err = time.ParseDuration(timeout)
+	if err == nil && client.Timeout <= 0 {
+		err = errors.New("must be positive")
+	}
+	if err != nil {
+		l.Fatalf("Remote plugin failure: malformed RemoteTimeout (%q): %s", timeout, err)
+	}
+
+	l.Debugf("Setting remote cache location to %q", remoteCache)
+	for name := range hosts {
+		l.Debugf("Allowing remote images from %q", name)
+	}
+	Disabled = false
+
+	if fileutil.IsDir(remoteCache) {
+		return
+	}
+	if !fileutil.MustNotExist(remoteCache) {
+		l.Fatalf("Remote plugin failure: %q must not exist or else must be a directory", remoteCache)
+	}
+}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// IDToPath implements the auto-download logic when a IIIF ID is an HTTP(S)
+// URL
+func IDToPath(id iiif.ID) (path string, err error) {
+	var a *asset
+	a, err = lookupAsset(id)
+	if err != nil {
+		return "", err
+	}
+
+	// Only one request can download a given asset; the rest wait briefly
+	var timeout = time.Now().Add(time.Second * 10)
+	for !a.tryFLock() {
+		time.Sleep(time.Millisecond * 250)
+		if time.Now().After(timeout) {
+			return "", errors.New("timed out waiting for locked asset (probably very slow download)")
+		}
+	}
+
+	err = a.download()
+	a.fUnlock()
+
+	return a.path, err
+}
+
+// PurgeCaches deletes all cached files this plugin is tracking.  Deletion
+// happens in the background so the API isn't sitting for potentially many
+// minutes prior to responding to the caller.
+func PurgeCaches() {
+	assetMutex.Lock()
+	var ids []iiif.ID
+	for id := range assets {
+		ids = append(ids, id)
+	}
+	assetMutex.Unlock()
+
+	go func() {
+		for _, id := range ids {
+			ExpireCachedImage(id)
+			time.Sleep(time.Millisecond * 250)
+		}
+		l.Infof("remote-images plugin: mass-purged %d assets", len(ids))
+	}()
+}
+
+// ExpireCachedImage removes the cached file for the given id, if one exists
+func ExpireCachedImage(id iiif.ID) {
+	assetMutex.Lock()
+	var a, ok = assets[id]
+	delete(assets, id)
+	assetMutex.Unlock()
+
+	if !ok {
+		l.Debugf("remote-images plugin: purging %q: no local asset cached", id)
+		return
+	}
+
+	a.fLock()
+	a.purge()
+	a.fUnlock()
+	l.Infof("remote-images plugin: purging %q: success", id)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// allowRule permits access to keys in a bucket which start with prefix.  An
+// empty prefix allows the whole bucket.
+type allowRule struct {
+	bucket string
+	prefix string
+}
+
+// allowRules restricts which buckets and keys may be requested from the
+// default endpoint.  When it's empty, anything the server's credentials can
+// read is allowed.  Named endpoints have their own rules.
+var allowRules []allowRule
+
+// parseAllowList reads a comma-separated list of "bucket" or "bucket/prefix"
+// entries
+func parseAllowList(val string) ([]allowRule, error) {
+	var rules []allowRule
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var parts = strings.SplitN(entry, "/", 2)
+		var r = allowRule{bucket: parts[0]}
+		if r.bucket == "" {
+			return nil, fmt.Errorf("%q has no bucket name", entry)
+		}
+		if len(parts) == 2 {
+			r.prefix = parts[1]
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// allowed returns true if the given rules permit requesting bucket and key
+func allowed(rules []allowRule, bucket, key string) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	for _, r := range rules {
+		if r.bucket == bucket && strings.HasPrefix(key, r.prefix) {
+			return true
+		}
+	}
+	return false
+}
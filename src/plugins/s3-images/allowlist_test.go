@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"rais/src/plugins"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestAllowList(t *testing.T) {
+	var rules, err = parseAllowList("images, archive/public/ ,")
+	assert.NilError(err, "parsing allow list", t)
+	assert.Equal(2, len(rules), "empty entries are skipped", t)
+
+	allowRules = rules
+	defer func() { allowRules = nil }()
+
+	assert.True(allowed(rules, "images", "any/key.jp2"), "whole bucket is allowed", t)
+	assert.True(allowed(rules, "archive", "public/a.jp2"), "prefix is allowed", t)
+	assert.False(allowed(rules, "archive", "private/a.jp2"), "other prefixes are rejected", t)
+	assert.False(allowed(rules, "other", "a.jp2"), "other buckets are rejected", t)
+
+	var _, e = IDToPathContext(context.Background(), "nil://other/a.jp2")
+	assert.Equal(plugins.ErrForbidden, e, "IDToPath rejects disallowed buckets", t)
+
+	_, err = parseAllowList("/prefix")
+	assert.True(err != nil, "entries need a bucket", t)
+}
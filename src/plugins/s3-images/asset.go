@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"hash/fnv"
 	"net/url"
 	"os"
 	"path/filepath"
 	"rais/src/iiif"
+	"rais/src/requestid"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -21,9 +24,16 @@ func hashBuckets(s3ID string) (string, string) {
 	return strconv.Itoa(val % 100), strconv.Itoa((val / 100) % 100)
 }
 
+// deriveLocalPath sets up the asset's cache path.  Assets from named
+// endpoints are cached under "+<name>", which can't collide with a bucket
+// name, so same-named buckets on different services don't clash.
 func (a *asset) deriveLocalPath() {
 	var hb1, hb2 = hashBuckets(a.key)
-	a.path = filepath.Join(s3cache, a.bucket, hb1, hb2, a.key)
+	var base = s3cache
+	if a.endpoint != nil && a.endpoint.Name != "" {
+		base = filepath.Join(s3cache, "+"+a.endpoint.Name)
+	}
+	a.path = filepath.Join(base, a.bucket, hb1, hb2, a.key)
 }
 
 type asset struct {
@@ -35,21 +45,43 @@ type asset struct {
 	fs         sync.Mutex
 	lockreader sync.Mutex
 	lastAccess time.Time
-	downloader func(*asset) error
+	size       int64
+	endpoint   *endpoint
+	downloader func(context.Context, *asset) error
+	statter    func(*asset) (objectInfo, error)
+
+	// These describe the S3 object the cached file was downloaded from, and
+	// when it was last checked against S3
+	etag         string
+	lastModified time.Time
+	validated    time.Time
 }
 
 var badAsset = &asset{downloader: fetchNil}
-var dlers = map[string]func(*asset) error{
+var dlers = map[string]func(context.Context, *asset) error{
 	"s3":  fetchS3,
 	"nil": fetchNil,
 }
 
 func newAsset(id iiif.ID, assetURL *url.URL) *asset {
+	// "s3+name" schemes use the named endpoint rather than the default
+	var scheme, name = assetURL.Scheme, ""
+	if strings.HasPrefix(scheme, "s3+") {
+		scheme, name = "s3", scheme[3:]
+	}
+
 	var a = &asset{
 		id:         id,
 		key:        assetURL.Path,
 		bucket:     assetURL.Host,
-		downloader: dlers[assetURL.Scheme],
+		endpoint:   endpoints[name],
+		downloader: dlers[scheme],
+		statter:    statters[scheme],
+	}
+
+	// S3 assets can't be fetched without an endpoint
+	if scheme == "s3" && a.endpoint == nil {
+		a.downloader = nil
 	}
 
 	// Asset path is always going to have a leading slash if the URL is valid,
@@ -84,19 +116,41 @@ func lookupAsset(id iiif.ID) (a *asset, ok bool) {
 	return a, ok
 }
 
+// allowed returns true if the asset's endpoint allows requesting it
+func (a *asset) allowed() bool {
+	var rules = allowRules
+	if a.endpoint != nil && a.endpoint.Name != "" {
+		rules = a.endpoint.allow
+	}
+	return allowed(rules, a.bucket, a.key)
+}
+
 func (a *asset) valid() bool {
 	return a.key != "" && a.downloader != nil && a.bucket != ""
 }
 
-func (a *asset) download() error {
+func (a *asset) download(ctx context.Context) error {
 	// If the file has already been cached, we can just return here
 	var _, err = os.Stat(a.path)
 	if err == nil {
 		return nil
 	}
 
-	l.Debugf("s3-images plugin: no cached file at %q; downloading from S3", a.path)
-	return a.downloader(a)
+	requestid.Logger(ctx, l).Debugf("s3-images plugin: no cached file at %q; downloading from S3", a.path)
+	err = a.downloader(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	var info os.FileInfo
+	info, err = os.Stat(a.path)
+	if err == nil {
+		a.size = info.Size()
+	}
+	if cacheMaxBytes > 0 {
+		go evictLRU(a)
+	}
+	return nil
 }
 
 // tryFLock attempts to lock for file writing in a non-blocking way.  If the
@@ -66,3 +66,13 @@ func TestFLock(t *testing.T) {
 	assert.True(a.tryFLock(), "tryFLock call succeeds after fUnlock", t)
 	a.fUnlock()
 }
+
+func TestCachePathToID(t *testing.T) {
+	s3cache = "/tmp"
+	var id = iiif.ID("s3://fakebucket/asset/key.jp2")
+	var a, _ = lookupAsset(id)
+	assert.Equal(id, cachePathToID(a.path), "round-trip from cached path", t)
+	assert.Equal(iiif.ID(""), cachePathToID("/tmp/fakebucket/1/2/asset/key.jp2"), "wrong hash buckets", t)
+	assert.Equal(iiif.ID(""), cachePathToID("/tmp/fakebucket/key.jp2"), "too few path elements", t)
+	assert.Equal(iiif.ID(""), cachePathToID("/var/other/fakebucket/54/50/key.jp2"), "outside cache dir", t)
+}
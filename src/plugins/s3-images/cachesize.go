@@ -0,0 +1,71 @@
+// cachesize.go keeps the cache under S3CacheMaxBytes by purging the least
+// recently used assets whenever a download pushes it over the limit.
+
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// cacheMaxBytes is the most disk space cached files may use; zero means
+// there's no limit
+var cacheMaxBytes int64
+
+// evictMutex keeps evictions from running concurrently, since two at once
+// would each purge enough for the cache to fit
+var evictMutex sync.Mutex
+
+// evictLRU purges the least recently read assets until the cache's total size
+// is no more than cacheMaxBytes.  keep is never purged, as it's the asset
+// which was just downloaded and is about to be used.  Assets which are locked,
+// e.g., being downloaded, are skipped.
+func evictLRU(keep *asset) {
+	if cacheMaxBytes <= 0 {
+		return
+	}
+
+	evictMutex.Lock()
+	defer evictMutex.Unlock()
+
+	var total int64
+	var candidates []*asset
+	assetMutex.Lock()
+	for _, a := range assets {
+		total += a.size
+		if a != keep && a.size > 0 {
+			candidates = append(candidates, a)
+		}
+	}
+	assetMutex.Unlock()
+
+	if total <= cacheMaxBytes {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastAccess.Before(candidates[j].lastAccess)
+	})
+
+	var purged int
+	for _, a := range candidates {
+		if total <= cacheMaxBytes {
+			break
+		}
+		if !a.tryFLock() {
+			continue
+		}
+		var size = a.size
+		a.purge()
+		a.fUnlock()
+
+		assetMutex.Lock()
+		delete(assets, a.id)
+		assetMutex.Unlock()
+		total -= size
+		purged++
+	}
+
+	l.Infof("s3-images plugin: evicted %d asset(s) to keep the cache under %d bytes (now %d bytes)",
+		purged, cacheMaxBytes, total)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestEvictLRU(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-s3-evict")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	s3cache = dir
+	cacheMaxBytes = 250
+	defer func() { cacheMaxBytes = 0 }()
+
+	var now = time.Now()
+	var add = func(key string, age time.Duration) *asset {
+		var a, _ = lookupAsset(iiif.ID("nil://evict/" + key))
+		assert.NilError(fetchNil(context.Background(), a), "setting up "+key, t)
+		assert.NilError(ioutil.WriteFile(a.path, make([]byte, 100), 0644), "writing "+key, t)
+		a.size = 100
+		a.lastAccess = now.Add(-age)
+		return a
+	}
+	var old = add("old", time.Hour)
+	var mid = add("mid", time.Minute)
+	var newest = add("newest", 2*time.Hour)
+
+	// The newest asset is kept no matter how long ago it was read, since
+	// it's the one which was just downloaded
+	evictLRU(newest)
+
+	var _, statErr = os.Stat(old.path)
+	assert.True(os.IsNotExist(statErr), "least recently used file is purged", t)
+	_, statErr = os.Stat(mid.path)
+	assert.NilError(statErr, "more recently used file is kept", t)
+	_, statErr = os.Stat(newest.path)
+	assert.NilError(statErr, "kept file is kept", t)
+
+	var _, ok = assets[old.id]
+	assert.False(ok, "purged asset is untracked", t)
+}
@@ -0,0 +1,145 @@
+// checksum.go verifies downloaded files before they're served, so a corrupt
+// or truncated download gets retried rather than cached until it's purged.
+// Every file's size is checked against the object's.  Its content is checked
+// against a checksum stored in the object's user metadata, when
+// S3ChecksumMetadata names one, or else against the ETag when the ETag is a
+// plain MD5.  Multipart uploads and KMS- or customer-key-encrypted objects
+// don't have MD5 ETags, so without a metadata checksum, only their size can
+// be checked.
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"rais/src/requestid"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// downloadRetries is how many more times a download is attempted when the
+// downloaded file doesn't match the object
+var downloadRetries = 2
+
+// checksumMetadata is the name of the user metadata field, if any, which
+// holds a hex-encoded MD5 or SHA-256 of each object
+var checksumMetadata string
+
+// objectChecksum describes what a downloaded file must look like.  If algo is
+// empty, only the size is checked.
+type objectChecksum struct {
+	size int64
+	algo string
+	sum  string
+}
+
+// expectedChecksum returns the checksum a download of head's object must
+// match
+func expectedChecksum(head *s3.HeadObjectOutput) (objectChecksum, error) {
+	var c = objectChecksum{size: aws.Int64Value(head.ContentLength)}
+
+	if checksumMetadata != "" {
+		for k, v := range head.Metadata {
+			if !strings.EqualFold(k, checksumMetadata) {
+				continue
+			}
+			var sum = strings.ToLower(strings.TrimSpace(aws.StringValue(v)))
+			var _, err = hex.DecodeString(sum)
+			switch {
+			case err == nil && len(sum) == md5.Size*2:
+				c.algo = "md5"
+			case err == nil && len(sum) == sha256.Size*2:
+				c.algo = "sha256"
+			default:
+				return c, fmt.Errorf("metadata %q holds %q, which isn't a hex MD5 or SHA-256", k, sum)
+			}
+			c.sum = sum
+			return c, nil
+		}
+	}
+
+	// ETags of encrypted objects are never MD5s, even if they look like one
+	if aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms || head.SSECustomerAlgorithm != nil {
+		return c, nil
+	}
+	var etag = strings.ToLower(strings.Trim(aws.StringValue(head.ETag), `"`))
+	var _, err = hex.DecodeString(etag)
+	if err == nil && len(etag) == md5.Size*2 {
+		c.algo, c.sum = "md5", etag
+	}
+	return c, nil
+}
+
+// verify returns an error if the file at path doesn't match c
+func (c objectChecksum) verify(path string) error {
+	var f, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var h hash.Hash
+	switch c.algo {
+	case "md5":
+		h = md5.New()
+	case "sha256":
+		h = sha256.New()
+	}
+
+	var n int64
+	if h == nil {
+		var info os.FileInfo
+		info, err = f.Stat()
+		if err != nil {
+			return err
+		}
+		n = info.Size()
+	} else {
+		n, err = io.Copy(h, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	if n != c.size {
+		return fmt.Errorf("size is %d bytes; expected %d", n, c.size)
+	}
+	if h != nil {
+		var sum = hex.EncodeToString(h.Sum(nil))
+		if sum != c.sum {
+			return fmt.Errorf("%s is %s; expected %s", c.algo, sum, c.sum)
+		}
+	}
+	return nil
+}
+
+// fetchVerified calls fetch to download the asset, then checks the file
+// against c.  Files which don't match are removed, and the download is
+// retried up to downloadRetries times.  Errors from fetch itself, including
+// a canceled context, aren't retried.
+func (a *asset) fetchVerified(ctx context.Context, c objectChecksum, fetch func() error) error {
+	for attempt := 0; ; attempt++ {
+		var err = fetch()
+		if err != nil {
+			return err
+		}
+		err = c.verify(a.path)
+		if err == nil {
+			return nil
+		}
+
+		os.Remove(a.path)
+		if attempt >= downloadRetries || ctx.Err() != nil {
+			return fmt.Errorf("download of %q is corrupt after %d attempt(s): %s", a.key, attempt+1, err)
+		}
+		requestid.Logger(ctx, l).Warnf("s3-images plugin: download of %q is corrupt (%s); retrying", a.id, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// md5 and sha256 of "hello"
+const helloMD5 = "5d41402abc4b2a76b9719d911017c592"
+const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestExpectedChecksum(t *testing.T) {
+	var head = &s3.HeadObjectOutput{ContentLength: aws.Int64(5), ETag: aws.String(`"` + helloMD5 + `"`)}
+	var c, err = expectedChecksum(head)
+	assert.NilError(err, "ETag checksum", t)
+	assert.Equal(objectChecksum{size: 5, algo: "md5", sum: helloMD5}, c, "plain ETags are MD5s", t)
+
+	head.ETag = aws.String(`"` + helloMD5 + `-3"`)
+	c, _ = expectedChecksum(head)
+	assert.Equal("", c.algo, "multipart ETags aren't used", t)
+
+	head.ETag = aws.String(`"` + helloMD5 + `"`)
+	head.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+	c, _ = expectedChecksum(head)
+	assert.Equal("", c.algo, "KMS-encrypted ETags aren't used", t)
+	assert.Equal(int64(5), c.size, "size is still checked", t)
+
+	checksumMetadata = "sha256"
+	defer func() { checksumMetadata = "" }()
+	head.Metadata = map[string]*string{"Sha256": aws.String(helloSHA256)}
+	c, err = expectedChecksum(head)
+	assert.NilError(err, "metadata checksum", t)
+	assert.Equal(objectChecksum{size: 5, algo: "sha256", sum: helloSHA256}, c, "metadata is used when present", t)
+
+	head.Metadata["Sha256"] = aws.String("nope")
+	_, err = expectedChecksum(head)
+	assert.True(err != nil, "invalid metadata checksums are an error", t)
+}
+
+func TestFetchVerified(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-s3-checksum")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	s3cache = dir
+
+	var a, _ = lookupAsset(iiif.ID("nil://checksum/a.jp2"))
+	var contents []string
+	var fetches int
+	var fetch = func() error {
+		var f, err = a.setupTempFile()
+		if err != nil {
+			return err
+		}
+		f.Write([]byte(contents[fetches]))
+		fetches++
+		return f.Close()
+	}
+	var sum = objectChecksum{size: 5, algo: "md5", sum: helloMD5}
+
+	contents = []string{"hello"}
+	assert.NilError(a.fetchVerified(context.Background(), sum, fetch), "good download", t)
+	assert.Equal(1, fetches, "good downloads aren't retried", t)
+
+	contents, fetches = []string{"hel", "jello", "hello"}, 0
+	assert.NilError(a.fetchVerified(context.Background(), sum, fetch), "download fixed by retrying", t)
+	assert.Equal(3, fetches, "bad downloads are retried", t)
+
+	contents, fetches = []string{"hel", "hel", "hel", "hello"}, 0
+	err = a.fetchVerified(context.Background(), sum, fetch)
+	assert.True(err != nil, "retries run out", t)
+	assert.Equal(downloadRetries+1, fetches, "downloads are retried downloadRetries times", t)
+	var _, statErr = os.Stat(a.path)
+	assert.True(os.IsNotExist(statErr), "corrupt files aren't left behind", t)
+
+	contents, fetches = []string{"jello"}, 0
+	assert.NilError(a.fetchVerified(context.Background(), objectChecksum{size: 5}, fetch), "size-only check", t)
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"rais/src/requestid"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -12,6 +15,15 @@
 	"github.com/uoregon-libraries/gopkg/fileutil"
 )
 
+// Settings for the S3 download manager, which fetches large objects as
+// ranged, concurrent part downloads
+var downloadPartSize int64 = s3manager.DefaultDownloadPartSize
+var downloadConcurrency = s3manager.DefaultDownloadConcurrency
+
+// progressLogBytes is how often, in bytes, progress is logged for large
+// downloads; zero disables progress logging
+var progressLogBytes int64
+
 func (a *asset) setupTempFile() (*fileutil.SafeFile, error) {
 	var parentDir = filepath.Dir(a.path)
 	var err = os.MkdirAll(parentDir, 0755)
@@ -22,39 +34,69 @@ func (a *asset) setupTempFile() (*fileutil.SafeFile, error) {
 	return fileutil.NewSafeFile(a.path), nil
 }
 
-func fetchS3(a *asset) error {
-	var conf = &aws.Config{
-		Region:           aws.String(s3zone),
-		Endpoint:         aws.String(s3endpoint),
-		S3ForcePathStyle: aws.Bool(true),
-	}
-	var sess, err = session.NewSession(conf)
+func fetchS3(ctx context.Context, a *asset) error {
+	var sess, err = session.NewSession(a.endpoint.awsConfig())
 	if err != nil {
 		return fmt.Errorf("unable to set up AWS session: %s", err)
 	}
 
-	var obj = &s3.GetObjectInput{
+	// Record the object's ETag so the cached file can be revalidated later,
+	// and only download that exact version of the object
+	var head *s3.HeadObjectOutput
+	head, err = s3.New(sess).HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(a.bucket),
 		Key:    aws.String(a.key),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read item %q: %s", a.key, err)
 	}
+	a.etag = aws.StringValue(head.ETag)
+	a.lastModified = aws.TimeValue(head.LastModified)
+	a.validated = time.Now()
 
-	var tmpfile *fileutil.SafeFile
-	tmpfile, err = a.setupTempFile()
+	var sum objectChecksum
+	sum, err = expectedChecksum(head)
 	if err != nil {
-		return err
+		return fmt.Errorf("unable to verify item %q: %s", a.key, err)
 	}
 
-	var dl = s3manager.NewDownloader(sess)
-	_, err = dl.Download(tmpfile, obj)
-	if err != nil {
-		tmpfile.Cancel()
-		return fmt.Errorf("unable to download item %q: %s", a.key, err)
+	var obj = &s3.GetObjectInput{
+		Bucket:  aws.String(a.bucket),
+		Key:     aws.String(a.key),
+		IfMatch: head.ETag,
 	}
+	var dl = s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+		d.PartSize = downloadPartSize
+		d.Concurrency = downloadConcurrency
+	})
 
-	return tmpfile.Close()
+	return a.fetchVerified(ctx, sum, func() error {
+		var tmpfile, err = a.setupTempFile()
+		if err != nil {
+			return err
+		}
+
+		var log = requestid.Logger(ctx, l)
+		var pw = &progressWriter{w: tmpfile, name: string(a.id), logBytes: progressLogBytes, log: log}
+		var start = time.Now()
+		var n int64
+		n, err = dl.DownloadWithContext(ctx, pw, obj)
+		if err != nil {
+			tmpfile.Cancel()
+			return fmt.Errorf("unable to download item %q: %s", a.key, err)
+		}
+
+		if progressLogBytes > 0 && n >= progressLogBytes {
+			var elapsed = time.Since(start)
+			log.Infof("s3-images plugin: downloaded %s (%d MB) in %s (%.1f MB/s)", a.id, n>>20,
+				elapsed, float64(n)/float64(1<<20)/elapsed.Seconds())
+		}
+
+		return tmpfile.Close()
+	})
 }
 
-func fetchNil(a *asset) error {
+func fetchNil(_ context.Context, a *asset) error {
 	var tmpfile, err = a.setupTempFile()
 	if err != nil {
 		return err
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// endpoint describes an S3-compatible service and how to authenticate with
+// it.  The default endpoint, used for "s3://" IDs, comes from the S3Zone and
+// S3Endpoint settings and the standard AWS credential sources.  Named
+// endpoints are read from S3EndpointsFile and used for "s3+<name>://" IDs.
+type endpoint struct {
+	Name            string
+	Endpoint        string
+	Zone            string
+	AccessKeyID     string
+	SecretAccessKey string
+	Profile         string
+	AllowedBuckets  string
+
+	// allow is only used by named endpoints; the default endpoint uses the
+	// global allowRules
+	allow []allowRule
+}
+
+// endpoints holds all configured endpoints, keyed by name.  The default
+// endpoint's name is empty, and it's removed if S3Zone isn't set.
+var endpoints = map[string]*endpoint{"": {}}
+
+// validEndpointName matches names which are valid in a URL scheme
+var validEndpointName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+
+// loadEndpoints reads named endpoints from a TOML file:
+//
+//	[[Endpoint]]
+//	Name = "minio"
+//	Endpoint = "https://minio.example.edu"
+//	Zone = "us-east-1"
+//	Profile = "minio"
+//	AllowedBuckets = "scans"
+func loadEndpoints(fname string) ([]*endpoint, error) {
+	var data struct {
+		Endpoint []*endpoint
+	}
+	var _, err = toml.DecodeFile(fname, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	var seen = make(map[string]bool)
+	for _, e := range data.Endpoint {
+		if !validEndpointName.MatchString(e.Name) {
+			return nil, fmt.Errorf("endpoint name %q must be lowercase letters, numbers, dots, and hyphens", e.Name)
+		}
+		if seen[e.Name] {
+			return nil, fmt.Errorf("duplicate endpoint name %q", e.Name)
+		}
+		seen[e.Name] = true
+
+		if e.Zone == "" {
+			return nil, fmt.Errorf("endpoint %q has no zone", e.Name)
+		}
+		if (e.AccessKeyID == "") != (e.SecretAccessKey == "") {
+			return nil, fmt.Errorf("endpoint %q must set both AccessKeyID and SecretAccessKey, or neither", e.Name)
+		}
+		e.allow, err = parseAllowList(e.AllowedBuckets)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q has invalid AllowedBuckets: %s", e.Name, err)
+		}
+	}
+
+	return data.Endpoint, nil
+}
+
+// awsConfig returns the AWS SDK configuration for talking to e
+func (e *endpoint) awsConfig() *aws.Config {
+	var conf = &aws.Config{
+		Region:           aws.String(e.Zone),
+		Endpoint:         aws.String(e.Endpoint),
+		S3ForcePathStyle: aws.Bool(true),
+	}
+	if e.AccessKeyID != "" {
+		conf.Credentials = credentials.NewStaticCredentials(e.AccessKeyID, e.SecretAccessKey, "")
+	} else if e.Profile != "" {
+		conf.Credentials = credentials.NewSharedCredentials("", e.Profile)
+	}
+	return conf
+}
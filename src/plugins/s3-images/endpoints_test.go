@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestEndpoints(t *testing.T) {
+	var f, err = ioutil.TempFile("", "rais-s3-endpoints")
+	assert.NilError(err, "creating temp file", t)
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[[Endpoint]]
+Name = "minio"
+Endpoint = "https://minio.example.edu"
+Zone = "us-east-1"
+AccessKeyID = "key"
+SecretAccessKey = "secret"
+AllowedBuckets = "scans/public/"
+`)
+	f.Close()
+
+	var list []*endpoint
+	list, err = loadEndpoints(f.Name())
+	assert.NilError(err, "loading endpoints", t)
+	assert.Equal(1, len(list), "one endpoint", t)
+
+	endpoints["minio"] = list[0]
+	defer delete(endpoints, "minio")
+	s3cache = "/tmp"
+
+	var id = iiif.ID("s3+minio://scans/public/a.jp2")
+	var a, _ = lookupAsset(id)
+	assert.True(a.valid(), "named endpoint asset is valid", t)
+	assert.Equal("scans", a.bucket, "bucket", t)
+	assert.Equal("/tmp/+minio/scans/8/33/public/a.jp2", a.path, "named endpoints are cached separately", t)
+	assert.Equal(id, cachePathToID(a.path), "round-trip from cached path", t)
+	assert.True(a.allowed(), "allowed by the endpoint's rules", t)
+
+	a, _ = lookupAsset("s3+minio://scans/private/a.jp2")
+	assert.False(a.allowed(), "rejected by the endpoint's rules", t)
+
+	a, _ = lookupAsset("s3+unknown://scans/public/a.jp2")
+	assert.False(a.valid(), "unknown endpoints are invalid", t)
+
+	var conf = list[0].awsConfig()
+	assert.Equal("https://minio.example.edu", *conf.Endpoint, "endpoint URL", t)
+	assert.True(conf.Credentials != nil, "static credentials", t)
+}
+
+func TestInvalidEndpoints(t *testing.T) {
+	for _, data := range []string{
+		"[[Endpoint]]\nName = \"Bad Name\"\nZone = \"z\"",
+		"[[Endpoint]]\nName = \"a\"",
+		"[[Endpoint]]\nName = \"a\"\nZone = \"z\"\nAccessKeyID = \"k\"",
+		"[[Endpoint]]\nName = \"a\"\nZone = \"z\"\n[[Endpoint]]\nName = \"a\"\nZone = \"z\"",
+	} {
+		var f, _ = ioutil.TempFile("", "rais-s3-endpoints")
+		f.WriteString(data)
+		f.Close()
+		var _, err = loadEndpoints(f.Name())
+		os.Remove(f.Name())
+		assert.True(err != nil, "invalid endpoints file: "+data, t)
+	}
+}
@@ -13,41 +13,70 @@
 // seen in the external images plugin are effectively nullified.
 //
 // We assume the asset is already a format RAIS can serve (preferably JP2), and
-// we cache it locally with the same extension it has in S3.  The IDToPath
-// return is the cached path so that RAIS can use the cached file immediately
-// after download.  The JP2 cache is configurable via `S3Cache` in the RAIS
-// toml file or by setting `RAIS_S3CACHE` in the environment, and defaults to
-// `/var/cache/rais-s3`.
+// we cache it locally with the same extension it has in S3.  The
+// IDToPathContext return is the cached path so that RAIS can use the cached
+// file immediately after download.  The JP2 cache is configurable via
+// `S3Cache` in the RAIS toml file or by setting `RAIS_S3CACHE` in the
+// environment, and defaults to `/var/cache/rais-s3`.
 //
-// Expiration of cached files must be managed externally (to avoid
-// over-complicating this plugin).  A simple approach could be a cron job that
-// wipes out all cached data if it hasn't been accessed in the past 24 hours:
+// Institutions with more than one S3-compatible service (MinIO, Ceph RGW,
+// Wasabi, etc.) can describe each in a TOML file named by `S3EndpointsFile`,
+// with its own URL, zone, credentials, and allowed buckets.  IDs of the form
+// "s3+<name>://bucket/key" are then read from the endpoint with that name.
+// See s3-endpoints-example.toml.
 //
-//     find /var/cache/rais-s3 -type f -atime +1 -exec rm {} \;
+// Files are kept until they're purged, even if the S3 object is replaced.
+// Setting `S3RevalidateInterval` makes RAIS check a cached file against S3
+// (by ETag) when it's requested and hasn't been checked for that long, and
+// download it again if it has changed.  `S3RevalidateHeader` names a request
+// header which forces this check for the requested image.
+//
+// Any bucket the server's credentials can read may be requested unless
+// `S3AllowedBuckets` is set.  This is a comma-separated list of bucket names,
+// optionally followed by a key prefix: "images, archive/public/" allows any
+// key in the "images" bucket, but only keys starting with "public/" in the
+// "archive" bucket.  Requests for anything else get a 403.
+//
+// Files already in the cache when RAIS starts are indexed in the background,
+// so they can be purged via the admin API or the (experimental)
+// S3CacheLifetime setting just like files downloaded after startup.
+//
+// Downloads are checked against the object's size and, where possible, its
+// checksum (see checksum.go) before they're served.  A download which doesn't
+// match is thrown away and retried up to `S3DownloadRetries` times.
+//
+// Setting `S3CacheMaxBytes` caps how much disk space the cache may use: when a
+// download pushes the cache over the limit, the least recently requested
+// files are purged until it fits again.  Without a cap, expiration of cached
+// files must be managed externally, e.g., with a cron job that wipes out all
+// cached data if it hasn't been accessed in the past 24 hours:
 //
-// Depending how fast the cache grows, how much disk space you have available,
-// and how much variety you have in S3, you may want to monitor the cache
-// closely and tweak this cron job example as needed, or come up with something
-// more sophisticated.
+//     find /var/cache/rais-s3 -type f -atime +1 -exec rm {} \;
 
 package main
 
 import (
+	"context"
 	"errors"
 	"rais/src/iiif"
 	"rais/src/plugins"
 	"time"
 
-	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/fileutil"
 	"github.com/uoregon-libraries/gopkg/logger"
 )
 
 var l = logger.Named("rais/s3-plugin", logger.Debug)
 
-var s3cache, s3zone, s3endpoint string
+var s3cache string
 var cacheLifetime time.Duration
 
+// PluginAPIVersion tells RAIS which plugin interface this plugin was built for
+var PluginAPIVersion = 2
+
+// PluginCapabilities lists the hooks this plugin provides
+var PluginCapabilities = []string{plugins.CapIDToPath, plugins.CapCachePurge, plugins.CapWrapHandler}
+
 // Disabled lets the plugin manager know not to add this plugin's functions to
 // the global list unless sanity checks in Initialize() pass
 var Disabled = true
@@ -55,35 +84,110 @@
 // Initialize sets up package variables for the s3 pulls and verifies sanity of
 // some of the configuration
 func Initialize() {
-	viper.SetDefault("S3Cache", "/var/local/rais-s3")
-	s3cache = viper.GetString("S3Cache")
-	s3zone = viper.GetString("S3Zone")
-	s3endpoint = viper.GetString("S3Endpoint")
+	var c = plugins.NewConfig("S3")
+	c.SetDefault("Cache", "/var/local/rais-s3")
+	s3cache = c.GetString("Cache")
+	var zone = c.GetString("Zone")
+	var endpointsFile = c.GetString("EndpointsFile")
 
-	if s3zone == "" {
-		l.Infof("S3 plugin will not be enabled: S3Zone must be set in rais.toml or RAIS_S3ZONE must be set in the environment")
+	if zone == "" && endpointsFile == "" {
+		l.Infof("S3 plugin will not be enabled: S3Zone (or S3EndpointsFile) must be set in rais.toml " +
+			"or RAIS_S3ZONE (or RAIS_S3ENDPOINTSFILE) must be set in the environment")
 		return
 	}
 
+	cacheMaxBytes = c.GetInt64("CacheMaxBytes")
+	if cacheMaxBytes < 0 {
+		l.Fatalf("S3 plugin failure: S3CacheMaxBytes must not be negative")
+	}
+
+	c.SetDefault("DownloadPartSize", downloadPartSize)
+	c.SetDefault("DownloadConcurrency", downloadConcurrency)
+	c.SetDefault("ProgressLogBytes", 256<<20)
+	downloadPartSize = c.GetInt64("DownloadPartSize")
+	downloadConcurrency = c.GetInt("DownloadConcurrency")
+	progressLogBytes = c.GetInt64("ProgressLogBytes")
+	if downloadPartSize < 1<<20 {
+		l.Fatalf("S3 plugin failure: S3DownloadPartSize must be at least 1 MB (1048576 bytes)")
+	}
+	if downloadConcurrency < 1 {
+		l.Fatalf("S3 plugin failure: S3DownloadConcurrency must be at least 1")
+	}
+
+	c.SetDefault("DownloadRetries", downloadRetries)
+	downloadRetries = c.GetInt("DownloadRetries")
+	checksumMetadata = c.GetString("ChecksumMetadata")
+	if downloadRetries < 0 {
+		l.Fatalf("S3 plugin failure: S3DownloadRetries must not be negative")
+	}
+
+	revalidateHeader = c.GetString("RevalidateHeader")
+	c.SetDefault("RevalidateInterval", "0")
+	var interval = c.GetString("RevalidateInterval")
+	var err error
+	revalidateInterval, err = time.ParseDuration(interval)
+	if err == nil && revalidateInterval < 0 {
+		err = errors.New("must not be negative")
+	}
+	if err != nil {
+		l.Fatalf("S3 plugin failure: malformed S3RevalidateInterval (%q): %s", interval, err)
+	}
+
+	allowRules, err = parseAllowList(c.GetString("AllowedBuckets"))
+	if err != nil {
+		l.Fatalf("S3 plugin failure: malformed S3AllowedBuckets: %s", err)
+	}
+	if zone == "" {
+		delete(endpoints, "")
+	} else {
+		endpoints[""] = &endpoint{Zone: zone, Endpoint: c.GetString("Endpoint")}
+		l.Debugf("Setting S3 zone to %q", zone)
+		if len(allowRules) == 0 {
+			l.Warnf("S3AllowedBuckets is not set: any bucket readable with RAIS's credentials may be requested")
+		}
+	}
+
+	if endpointsFile != "" {
+		var list []*endpoint
+		list, err = loadEndpoints(endpointsFile)
+		if err != nil {
+			l.Fatalf("S3 plugin failure: invalid S3EndpointsFile %q: %s", endpointsFile, err)
+		}
+		for _, e := range list {
+			endpoints[e.Name] = e
+			l.Debugf("Setting up S3 endpoint %q (%q, zone %q) for \"s3+%s://\" IDs", e.Name, e.Endpoint, e.Zone, e.Name)
+			if len(e.allow) == 0 {
+				l.Warnf("S3 endpoint %q has no AllowedBuckets: any bucket readable with its credentials may be requested", e.Name)
+			}
+		}
+	}
+
 	// This is an undocumented feature: it's a bit experimental, and really not
 	// something that should be relied upon until it gets some testing.
-	viper.SetDefault("S3CacheLifetime", "0")
-	var lifetimeString = viper.GetString("S3CacheLifetime")
-	var err error
+	c.SetDefault("CacheLifetime", "0")
+	var lifetimeString = c.GetString("CacheLifetime")
 	cacheLifetime, err = time.ParseDuration(lifetimeString)
 	if err != nil {
 		l.Fatalf("S3 plugin failure: malformed S3CacheLifetime (%q): %s", lifetimeString, err)
 	}
 
 	l.Debugf("Setting S3 cache location to %q", s3cache)
-	l.Debugf("Setting S3 zone to %q", s3zone)
+	l.Debugf("Downloading S3 objects in %d-byte parts, %d at a time", downloadPartSize, downloadConcurrency)
+	if cacheMaxBytes > 0 {
+		l.Debugf("Setting S3 cache size limit to %d bytes", cacheMaxBytes)
+	}
 	if cacheLifetime > time.Duration(0) {
 		l.Debugf("Setting S3 cache expiration to %s", cacheLifetime)
-		go purgeLoop()
+		if !plugins.DryRun {
+			go purgeLoop()
+		}
 	}
 	Disabled = false
 
 	if fileutil.IsDir(s3cache) {
+		if !plugins.DryRun {
+			go reconcileCache()
+		}
 		return
 	}
 	if !fileutil.MustNotExist(s3cache) {
@@ -97,18 +201,27 @@ func SetLogger(raisLogger *logger.Logger) {
 	l = raisLogger
 }
 
-// IDToPath implements the auto-download logic when a IIIF ID
-// starts with "s3://"
-func IDToPath(id iiif.ID) (path string, err error) {
+// IDToPathContext implements the auto-download logic when a IIIF ID
+// starts with "s3://" or "s3+<name>://".  If ctx is done while waiting on
+// another request's download or while downloading, the download is abandoned;
+// the next request for the image starts it over.
+func IDToPathContext(ctx context.Context, id iiif.ID) (path string, err error) {
 	var a, _ = lookupAsset(id)
 	if a.key == "" {
 		return "", plugins.ErrSkipped
 	}
+	if !a.allowed() {
+		return "", plugins.ErrForbidden
+	}
 
 	// See if this file is currently being downloaded; if so we need to wait
 	var timeout = time.Now().Add(time.Second * 10)
 	for a.tryFLock() == false {
-		time.Sleep(time.Millisecond * 250)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Millisecond * 250):
+		}
 		if time.Now().After(timeout) {
 			return "", errors.New("timed out waiting for locked asset (probably very slow download)")
 		}
@@ -117,8 +230,12 @@ func IDToPath(id iiif.ID) (path string, err error) {
 	// Let the asset know it's being read
 	a.read()
 
+	if a.needsRevalidation() {
+		a.revalidate()
+	}
+
 	// Attempt to download the asset content
-	err = a.download()
+	err = a.download(ctx)
 	a.fUnlock()
 
 	return a.path, err
@@ -127,16 +244,6 @@ func IDToPath(id iiif.ID) (path string, err error) {
 // PurgeCaches deletes all cached files this plugin is tracking.  Deletion
 // happens in the background so the API isn't sitting for potentially many
 // minutes prior to responding to the caller.
-//
-// TODO: this plugin should index files on the filesystem to see if there are
-// any it should be tracking (this happens if RAIS is ever shut down while
-// tracking files).  We don't want to delay startup, though.  Options:
-//     - On shutdown, write out the assets map - then on startup we can just
-//       read it in again and reset purge times
-//     - On startup fire up a background thread that just instantiates assets
-//       via lookupAsset(basename(file-".ext")).  If the filename is always the
-//       IIIF ID, this should work, and doesn't need to block since it'll only
-//       lock on the lookupAsset call.
 func PurgeCaches() {
 	// lock all assets while indexing them so we can index everything RAIS
 	// *currently* knows about without things getting weird if new stuff is being
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"rais/src/iiif"
 	"sync"
 	"sync/atomic"
@@ -21,7 +22,7 @@ func TestIDToPath(t *testing.T) {
 	var tryit = func() {
 		defer wg.Done()
 
-		var path, err = IDToPath("nil://fakebucket/fakeid")
+		var path, err = IDToPathContext(context.Background(), "nil://fakebucket/fakeid")
 		if err != nil {
 			t.Errorf("Failed trying to get path from ID: %s", err)
 			t.FailNow()
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// progressWriter wraps a download's destination so that large downloads can
+// report their progress.  Every time another logBytes bytes have been
+// written, a message is logged.  The S3 downloader writes parts from several
+// goroutines, so the counter is updated atomically.  Messages go to log,
+// which tags them with the id of the request that started the download.
+type progressWriter struct {
+	w        io.WriterAt
+	name     string
+	logBytes int64
+	log      *logger.Logger
+	written  int64
+}
+
+// WriteAt implements io.WriterAt, logging after each logBytes-sized chunk of
+// data has been written
+func (pw *progressWriter) WriteAt(p []byte, off int64) (int, error) {
+	var n, err = pw.w.WriteAt(p, off)
+	if pw.logBytes <= 0 {
+		return n, err
+	}
+
+	var total = atomic.AddInt64(&pw.written, int64(n))
+	var before = total - int64(n)
+	if total/pw.logBytes > before/pw.logBytes {
+		pw.log.Infof("s3-images plugin: downloaded %d MB of %s so far", total>>20, pw.name)
+	}
+	return n, err
+}
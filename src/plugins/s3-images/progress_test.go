@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+type fakeWriterAt struct {
+	sync.Mutex
+	data []byte
+}
+
+func (w *fakeWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	copy(w.data[off:], p)
+	return len(p), nil
+}
+
+func TestProgressWriter(t *testing.T) {
+	var dst = &fakeWriterAt{data: make([]byte, 1000)}
+	var pw = &progressWriter{w: dst, name: "test", logBytes: 100, log: l}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var part = make([]byte, 100)
+			for j := range part {
+				part[j] = byte(i)
+			}
+			pw.WriteAt(part, int64(i*100))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(int64(1000), pw.written, "all bytes counted", t)
+	for i := 0; i < 10; i++ {
+		assert.Equal(byte(i), dst.data[i*100+99], "parts written at their offsets", t)
+	}
+}
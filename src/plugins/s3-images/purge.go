@@ -19,11 +19,18 @@ func purgeLoop() {
 
 func checkPurge() {
 	var expireBefore = time.Now().Add(-cacheLifetime)
+	var expired []*asset
+	assetMutex.Lock()
 	for _, a := range assets {
 		if a.lastAccess.Before(expireBefore) {
-			go doPurge(a)
+			expired = append(expired, a)
 		}
 	}
+	assetMutex.Unlock()
+
+	for _, a := range expired {
+		go doPurge(a)
+	}
 }
 
 func doPurge(a *asset) {
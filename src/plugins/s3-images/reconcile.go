@@ -0,0 +1,85 @@
+// reconcile.go handles rebuilding the assets map from files already in the
+// cache directory.  Without this, anything downloaded before a restart would
+// be invisible to RAIS: it would never be purged by the cache lifetime logic
+// or by the purge API, and would sit on disk forever.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"strings"
+	"time"
+)
+
+// reconcileCache walks the S3 cache directory and starts tracking any cached
+// files it finds.  This is meant to run in the background at startup, so
+// errors are logged rather than returned.
+func reconcileCache() {
+	var count int
+	var start = time.Now()
+	var err = filepath.Walk(s3cache, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			l.Warnf("s3-images plugin: unable to read %q while indexing cache: %s", path, err)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		var id = cachePathToID(path)
+		if id == "" {
+			l.Debugf("s3-images plugin: ignoring unknown file %q in cache", path)
+			return nil
+		}
+
+		var a, ok = lookupAsset(id)
+		if !ok && a.valid() {
+			a.lastAccess = info.ModTime().Add(cacheLifetime)
+			a.size = info.Size()
+			count++
+		}
+		return nil
+	})
+
+	if err != nil {
+		l.Errorf("s3-images plugin: unable to index cache directory %q: %s", s3cache, err)
+	}
+	l.Infof("s3-images plugin: indexed %d previously cached asset(s) in %s", count, time.Since(start))
+	evictLRU(nil)
+}
+
+// cachePathToID converts a cached file's path back into the IIIF ID which
+// would have produced it, or returns an empty ID if the path doesn't match
+// the layout deriveLocalPath uses (<cache>/<bucket>/<hash1>/<hash2>/<key>,
+// optionally preceded by "+<endpoint name>/")
+func cachePathToID(path string) iiif.ID {
+	var rel, err = filepath.Rel(s3cache, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+
+	rel = filepath.ToSlash(rel)
+	var scheme = "s3"
+	if strings.HasPrefix(rel, "+") {
+		var parts = strings.SplitN(rel[1:], "/", 2)
+		if len(parts) != 2 {
+			return ""
+		}
+		scheme, rel = "s3+"+parts[0], parts[1]
+	}
+
+	var parts = strings.SplitN(rel, "/", 4)
+	if len(parts) != 4 {
+		return ""
+	}
+
+	var bucket, hb1, hb2, key = parts[0], parts[1], parts[2], parts[3]
+	var expected1, expected2 = hashBuckets(key)
+	if hb1 != expected1 || hb2 != expected2 {
+		return ""
+	}
+
+	return iiif.ID(scheme + "://" + bucket + "/" + key)
+}
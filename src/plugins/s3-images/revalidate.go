@@ -0,0 +1,135 @@
+// revalidate.go checks cached files against S3 so that replacing an object
+// in S3 doesn't leave RAIS serving the old file forever.  Each asset
+// remembers the ETag and Last-Modified of the object it was downloaded from,
+// and revalidation issues a HEAD request to compare them with what's in S3
+// now.  If the object has changed, the cached file is removed so the next
+// request downloads it again.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// revalidateInterval is how long a cached file is trusted before it's checked
+// against S3 again; zero means cached files are never revalidated on their own
+var revalidateInterval time.Duration
+
+// revalidateHeader, if set, is a request header which forces revalidation of
+// the requested image
+var revalidateHeader string
+
+// objectInfo is the subset of an S3 object's metadata we use to tell if it
+// has changed
+type objectInfo struct {
+	etag         string
+	lastModified time.Time
+}
+
+var statters = map[string]func(*asset) (objectInfo, error){
+	"s3":  statS3,
+	"nil": statNil,
+}
+
+func statS3(a *asset) (objectInfo, error) {
+	var sess, err = session.NewSession(a.endpoint.awsConfig())
+	if err != nil {
+		return objectInfo{}, err
+	}
+
+	var out *s3.HeadObjectOutput
+	out, err = s3.New(sess).HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(a.key),
+	})
+	if err != nil {
+		return objectInfo{}, err
+	}
+	return objectInfo{etag: aws.StringValue(out.ETag), lastModified: aws.TimeValue(out.LastModified)}, nil
+}
+
+func statNil(a *asset) (objectInfo, error) {
+	return objectInfo{}, nil
+}
+
+// needsRevalidation returns true if the asset was last checked against S3
+// longer ago than revalidateInterval
+func (a *asset) needsRevalidation() bool {
+	return revalidateInterval > 0 && time.Since(a.validated) > revalidateInterval
+}
+
+// revalidate checks the cached file against S3 and removes it if the object
+// has changed.  Assets cached before a restart don't have an ETag, so their
+// file's modification time is compared to the object's instead.  Errors are
+// logged rather than returned: if S3 can't be reached, the cached file is
+// still the best thing we have to serve.  The asset must be locked.
+func (a *asset) revalidate() {
+	var info, statErr = os.Stat(a.path)
+	if statErr != nil || a.statter == nil {
+		return
+	}
+
+	a.validated = time.Now()
+	var obj, err = a.statter(a)
+	if err != nil {
+		l.Warnf("s3-images plugin: unable to revalidate %q: %s", a.id, err)
+		return
+	}
+
+	var changed bool
+	if a.etag != "" {
+		changed = obj.etag != a.etag
+	} else {
+		changed = obj.lastModified.After(info.ModTime())
+	}
+	if !changed {
+		a.etag, a.lastModified = obj.etag, obj.lastModified
+		return
+	}
+
+	l.Infof("s3-images plugin: %q has changed in S3; removing cached copy", a.id)
+	a.purge()
+	a.size = 0
+	a.etag = ""
+}
+
+// WrapHandler forces revalidation of requested images when the request has
+// the header named by S3RevalidateHeader
+func WrapHandler(pattern string, handler http.Handler) (http.Handler, error) {
+	if revalidateHeader == "" {
+		return nil, plugins.ErrSkipped
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(revalidateHeader) != "" {
+			forceRevalidation(strings.TrimPrefix(req.URL.Path, pattern))
+		}
+		handler.ServeHTTP(w, req)
+	}), nil
+}
+
+// forceRevalidation revalidates the image named in the given IIIF path, if
+// it's a cached S3 asset
+func forceRevalidation(path string) {
+	var u, err = iiif.NewURL(path)
+	if err != nil {
+		return
+	}
+	var a, ok = lookupAsset(u.ID)
+	if !ok || !a.valid() {
+		return
+	}
+
+	a.fLock()
+	a.revalidate()
+	a.fUnlock()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestRevalidate(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-s3-revalidate")
+	assert.NilError(err, "creating temp dir", t)
+	defer os.RemoveAll(dir)
+	s3cache = dir
+
+	var current = objectInfo{etag: `"abc"`}
+	var stats int
+	var a, _ = lookupAsset(iiif.ID("nil://revalidate/a.jp2"))
+	a.statter = func(*asset) (objectInfo, error) {
+		stats++
+		return current, nil
+	}
+	var cache = func() {
+		assert.NilError(fetchNil(context.Background(), a), "setting up cached file", t)
+		a.etag = `"abc"`
+		a.validated = time.Now()
+	}
+	var exists = func() bool {
+		var _, err = os.Stat(a.path)
+		return err == nil
+	}
+
+	cache()
+	a.revalidate()
+	assert.True(exists(), "unchanged object is kept", t)
+
+	current.etag = `"def"`
+	a.revalidate()
+	assert.False(exists(), "changed object is removed", t)
+
+	// Files cached before a restart have no ETag, so mtime is used
+	cache()
+	a.etag = ""
+	current.lastModified = time.Now().Add(-time.Hour)
+	a.revalidate()
+	assert.True(exists(), "object older than cached file is kept", t)
+	assert.Equal(`"def"`, a.etag, "ETag is recorded", t)
+
+	a.etag = ""
+	current.lastModified = time.Now().Add(time.Hour)
+	a.revalidate()
+	assert.False(exists(), "object newer than cached file is removed", t)
+
+	revalidateInterval = time.Minute
+	defer func() { revalidateInterval = 0 }()
+	a.validated = time.Now()
+	assert.False(a.needsRevalidation(), "recently validated", t)
+	a.validated = time.Now().Add(-2 * time.Minute)
+	assert.True(a.needsRevalidation(), "validated too long ago", t)
+
+	revalidateHeader = "X-Revalidate"
+	defer func() { revalidateHeader = "" }()
+	cache()
+	current.etag = `"ghi"`
+	stats = 0
+	var h, _ = WrapHandler("/iiif/", http.NotFoundHandler())
+	var req = httptest.NewRequest("GET", "/iiif/nil:%2F%2Frevalidate%2Fa.jp2/info.json", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(0, stats, "no revalidation without the header", t)
+	req.Header.Set("X-Revalidate", "1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(1, stats, "header forces revalidation", t)
+	assert.False(exists(), "changed object is removed", t)
+}
@@ -0,0 +1,51 @@
+// Package requestid carries the id RAIS assigns each request (the id sent
+// back in the X-Request-ID header) in the request's context, so that
+// plugins and decoders can tag their log messages with it.  Every log line
+// for one IIIF request can then be found by searching for its id, even when
+// the work happens far from the HTTP handler, like a slow S3 download.
+package requestid
+
+import (
+	"context"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+type key struct{}
+
+// NewContext returns a copy of ctx carrying id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext returns the request id stored in ctx, or an empty string if
+// there isn't one.  ctx may be nil.
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	var id, _ = ctx.Value(key{}).(string)
+	return id
+}
+
+// Logger returns a logger which writes to l, prefixing each message with
+// the request id in ctx, e.g., "[request 0123abcd] Downloading...".  If ctx
+// has no request id, l is returned as-is.
+func Logger(ctx context.Context, l *logger.Logger) *logger.Logger {
+	var id = FromContext(ctx)
+	if id == "" {
+		return l
+	}
+	return &logger.Logger{Loggable: &tagged{prefix: "[request " + id + "] ", l: l.Loggable}}
+}
+
+// tagged prefixes messages before passing them on
+type tagged struct {
+	prefix string
+	l      logger.Loggable
+}
+
+// Log implements logger.Loggable
+func (t *tagged) Log(level logger.LogLevel, message string) {
+	t.l.Log(level, t.prefix+message)
+}
@@ -0,0 +1,34 @@
+package requestid
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+type fakeLog struct {
+	messages []string
+}
+
+func (fl *fakeLog) Log(level logger.LogLevel, message string) {
+	fl.messages = append(fl.messages, level.String()+" "+message)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal("", FromContext(nil), "nil context", t)
+	assert.Equal("", FromContext(context.Background()), "no id", t)
+	assert.Equal("abc", FromContext(NewContext(context.Background(), "abc")), "id", t)
+}
+
+func TestLogger(t *testing.T) {
+	var fl = &fakeLog{}
+	var l = &logger.Logger{Loggable: fl}
+
+	assert.True(Logger(context.Background(), l) == l, "no id means the same logger", t)
+
+	Logger(NewContext(context.Background(), "abc"), l).Warnf("slow download of %q", "foo")
+	assert.Equal(1, len(fl.messages), "one message", t)
+	assert.Equal(`WARN [request abc] slow download of "foo"`, fl.messages[0], "message is tagged", t)
+}
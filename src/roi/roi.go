@@ -0,0 +1,222 @@
+// Package roi suggests content-aware crops, such as the part of a wide
+// photograph a square thumbnail should show.  Images are scored pixel by
+// pixel, and the crop of the requested shape holding the highest total score
+// wins.  Scoring is meant for small images; callers should analyze a reduced
+// copy of the source rather than the full resolution.
+package roi
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Method names a way of scoring how interesting each part of an image is
+type Method string
+
+// All scoring methods
+const (
+	// Entropy favors busy areas: each small block of the image scores by how
+	// varied its brightness is, so text and detail win out over sky, blank
+	// paper, and backdrops
+	Entropy Method = "entropy"
+
+	// Saliency favors areas which stand out from the image as a whole: each
+	// pixel scores by how far its (slightly blurred) color is from the
+	// image's average color, which picks out subjects against a plain
+	// background even when the subject itself isn't detailed
+	Saliency Method = "saliency"
+)
+
+// ParseMethod returns the method with the given name
+func ParseMethod(name string) (Method, error) {
+	switch m := Method(strings.ToLower(name)); m {
+	case Entropy, Saliency:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown ROI method %q (must be entropy or saliency)", name)
+}
+
+// entropyBlock is the size of the square blocks entropy is measured over
+const entropyBlock = 8
+
+// entropyBins is the number of brightness levels entropy distinguishes.
+// Fewer bins than 256 keep sensor noise from looking like detail.
+const entropyBins = 16
+
+// Suggest returns the region of i with the given aspect ratio (width divided
+// by height) which holds the most interesting content according to m.  The
+// region is as large as the aspect ratio allows, so only its position is
+// chosen; ties go to the region nearest the center.
+func Suggest(i image.Image, aspect float64, m Method) image.Rectangle {
+	var b = i.Bounds()
+	var w, h = b.Dx(), b.Dy()
+	if w == 0 || h == 0 || aspect <= 0 {
+		return b
+	}
+
+	var cw, ch = w, int(math.Round(float64(w) / aspect))
+	if ch > h {
+		cw, ch = int(math.Round(float64(h)*aspect)), h
+	}
+	if cw < 1 {
+		cw = 1
+	}
+	if ch < 1 {
+		ch = 1
+	}
+	if cw == w && ch == h {
+		return b
+	}
+
+	var scores []float64
+	if m == Saliency {
+		scores = saliency(i)
+	} else {
+		scores = entropy(i)
+	}
+
+	var sums = newSummedArea(scores, w, h)
+	var best = math.Inf(-1)
+	var bestDist = math.Inf(1)
+	var bx, by int
+	for y := 0; y+ch <= h; y++ {
+		for x := 0; x+cw <= w; x++ {
+			var s = sums.sum(x, y, x+cw, y+ch)
+			var dx, dy = float64(x - (w-cw)/2), float64(y - (h-ch)/2)
+			var dist = dx*dx + dy*dy
+			if s > best+1e-9 || (s > best-1e-9 && dist < bestDist) {
+				best, bestDist, bx, by = s, dist, x, y
+			}
+		}
+	}
+
+	return image.Rect(bx, by, bx+cw, by+ch).Add(b.Min)
+}
+
+// luma returns the brightness of c from 0 to 255
+func luma(c color.Color) float64 {
+	return float64(color.GrayModel.Convert(c).(color.Gray).Y)
+}
+
+// entropy scores each pixel with the Shannon entropy of the brightness in
+// the block around it
+func entropy(i image.Image) []float64 {
+	var b = i.Bounds()
+	var w, h = b.Dx(), b.Dy()
+	var scores = make([]float64, w*h)
+	for by := 0; by < h; by += entropyBlock {
+		for bx := 0; bx < w; bx += entropyBlock {
+			var x2, y2 = min(bx+entropyBlock, w), min(by+entropyBlock, h)
+			var hist [entropyBins]int
+			for y := by; y < y2; y++ {
+				for x := bx; x < x2; x++ {
+					hist[int(luma(i.At(b.Min.X+x, b.Min.Y+y)))*entropyBins/256]++
+				}
+			}
+
+			var n = float64((x2 - bx) * (y2 - by))
+			var e float64
+			for _, count := range hist {
+				if count > 0 {
+					var p = float64(count) / n
+					e -= p * math.Log2(p)
+				}
+			}
+
+			for y := by; y < y2; y++ {
+				for x := bx; x < x2; x++ {
+					scores[y*w+x] = e
+				}
+			}
+		}
+	}
+	return scores
+}
+
+// saliency scores each pixel with the distance between its color, averaged
+// with its neighbors to ignore fine texture, and the image's mean color
+func saliency(i image.Image) []float64 {
+	var b = i.Bounds()
+	var w, h = b.Dx(), b.Dy()
+	var rgb = make([][3]float64, w*h)
+	var mean [3]float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var c = color.NRGBAModel.Convert(i.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			var px = [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+			rgb[y*w+x] = px
+			for n := range px {
+				mean[n] += px[n]
+			}
+		}
+	}
+	for n := range mean {
+		mean[n] /= float64(w * h)
+	}
+
+	var scores = make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var blur [3]float64
+			var count float64
+			for ny := max(y-1, 0); ny <= min(y+1, h-1); ny++ {
+				for nx := max(x-1, 0); nx <= min(x+1, w-1); nx++ {
+					for n := range blur {
+						blur[n] += rgb[ny*w+nx][n]
+					}
+					count++
+				}
+			}
+
+			var d float64
+			for n := range blur {
+				var diff = blur[n]/count - mean[n]
+				d += diff * diff
+			}
+			scores[y*w+x] = math.Sqrt(d)
+		}
+	}
+	return scores
+}
+
+// summedArea is a summed-area table, which gives the total of any rectangle
+// of scores in constant time
+type summedArea struct {
+	w    int
+	sums []float64
+}
+
+func newSummedArea(scores []float64, w, h int) *summedArea {
+	var sa = &summedArea{w: w + 1, sums: make([]float64, (w+1)*(h+1))}
+	for y := 0; y < h; y++ {
+		var row float64
+		for x := 0; x < w; x++ {
+			row += scores[y*w+x]
+			sa.sums[(y+1)*sa.w+x+1] = sa.sums[y*sa.w+x+1] + row
+		}
+	}
+	return sa
+}
+
+// sum returns the total of the scores from x1,y1 up to but not including
+// x2,y2
+func (sa *summedArea) sum(x1, y1, x2, y2 int) float64 {
+	return sa.sums[y2*sa.w+x2] - sa.sums[y1*sa.w+x2] - sa.sums[y2*sa.w+x1] + sa.sums[y1*sa.w+x1]
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,58 @@
+package roi
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// busyImage returns a flat gray 300x100 image with a checkerboard square
+// starting at x
+func busyImage(x int) *image.RGBA {
+	var i = image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for py := 0; py < 100; py++ {
+		for px := 0; px < 300; px++ {
+			var c = color.RGBA{128, 128, 128, 255}
+			if px >= x && px < x+80 && py >= 10 && py < 90 && (px/4+py/4)%2 == 0 {
+				c = color.RGBA{255, 0, 0, 255}
+			}
+			i.Set(px, py, c)
+		}
+	}
+	return i
+}
+
+func TestSuggest(t *testing.T) {
+	for _, m := range []Method{Entropy, Saliency} {
+		var r = Suggest(busyImage(200), 1, m)
+		assert.Equal(100, r.Dx(), string(m)+": square crop is as large as possible", t)
+		assert.Equal(100, r.Dy(), string(m)+": square crop is as large as possible", t)
+		assert.True(r.Min.X >= 180, string(m)+": crop covers the busy area on the right", t)
+
+		r = Suggest(busyImage(10), 1, m)
+		assert.True(r.Min.X <= 10, string(m)+": crop covers the busy area on the left", t)
+	}
+}
+
+func TestSuggestFlat(t *testing.T) {
+	var i = image.NewGray(image.Rect(0, 0, 300, 100))
+	var r = Suggest(i, 1, Entropy)
+	assert.Equal(image.Rect(100, 0, 200, 100), r, "a featureless image is cropped in the center", t)
+
+	r = Suggest(i, 3, Entropy)
+	assert.Equal(i.Bounds(), r, "matching aspect ratios use the whole image", t)
+
+	i = image.NewGray(image.Rect(50, 50, 150, 350))
+	r = Suggest(i, 2, Saliency)
+	assert.Equal(image.Rect(50, 175, 150, 225), r, "bounds needn't start at zero", t)
+}
+
+func TestParseMethod(t *testing.T) {
+	var m, err = ParseMethod("Saliency")
+	assert.NilError(err, "saliency", t)
+	assert.Equal(Saliency, m, "saliency", t)
+	_, err = ParseMethod("faces")
+	assert.True(err != nil, "unknown methods are an error", t)
+}
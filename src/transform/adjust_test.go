@@ -0,0 +1,38 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestAdjustment(t *testing.T) {
+	var src = edge()
+	assert.Equal(image.Image(src), Adjustment{}.Apply(src), "zero adjustment returns the image", t)
+	assert.Equal(image.Image(src), Adjustment{Contrast: 1, Gamma: 1}.Apply(src), "unit adjustment returns the image", t)
+
+	var out = Adjustment{Brightness: 10}.Apply(src).(*image.Gray)
+	assert.Equal(uint8(74), out.GrayAt(0, 0).Y, "brightness is added", t)
+	assert.Equal(uint8(64), src.GrayAt(0, 0).Y, "source isn't modified", t)
+
+	out = Adjustment{Contrast: 3}.Apply(src).(*image.Gray)
+	assert.Equal(uint8(0), out.GrayAt(0, 0).Y, "contrast pushes dark tones darker, clamped at black", t)
+	assert.Equal(uint8(255), out.GrayAt(9, 0).Y, "contrast pushes light tones lighter, clamped at white", t)
+
+	out = Adjustment{Gamma: 2}.Apply(src).(*image.Gray)
+	assert.Equal(uint8(128), out.GrayAt(0, 0).Y, "gamma above 1 brightens midtones", t)
+
+	var lut = Adjustment{Gamma: 1.4}.table()
+	assert.Equal(uint8(0), lut[0], "gamma leaves black alone", t)
+	assert.Equal(uint8(255), lut[255], "gamma leaves white alone", t)
+}
+
+func TestAdjustmentRGBA(t *testing.T) {
+	var src = image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.NRGBA{100, 50, 200, 255})
+	var out = Adjustment{Brightness: -20}.Apply(src).(*image.RGBA)
+	assert.Equal(color.RGBA{80, 30, 180, 255}, out.RGBAAt(0, 0), "each color channel is adjusted", t)
+	assert.Equal(uint8(0), out.RGBAAt(1, 1).A, "alpha is untouched", t)
+}
@@ -0,0 +1,57 @@
+package transform
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// Filter names a resampling filter used when scaling images
+type Filter string
+
+// All supported filters.  Nearest is fastest and lowest quality; Lanczos3 is
+// slowest, but best avoids aliasing and moiré when heavily downscaling
+// detailed images like newspaper scans.
+const (
+	FilterNearest    Filter = "nearest"
+	FilterBilinear   Filter = "bilinear"
+	FilterCatmullRom Filter = "catmull-rom"
+	FilterLanczos3   Filter = "lanczos3"
+)
+
+var interpolators = map[Filter]resize.InterpolationFunction{
+	FilterNearest:    resize.NearestNeighbor,
+	FilterBilinear:   resize.Bilinear,
+	FilterCatmullRom: resize.Bicubic,
+	FilterLanczos3:   resize.Lanczos3,
+}
+
+// DefaultFilter is used whenever an empty Filter is asked to resize
+var DefaultFilter = FilterBilinear
+
+// ParseFilter returns the Filter with the given name, case-insensitively
+func ParseFilter(name string) (Filter, error) {
+	var f = Filter(strings.ToLower(name))
+	if _, ok := interpolators[f]; !ok {
+		var names []string
+		for f := range interpolators {
+			names = append(names, string(f))
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown filter %q (must be one of %s)", name, strings.Join(names, ", "))
+	}
+	return f, nil
+}
+
+// Resize scales img to width x height using the filter.  If one dimension is
+// 0, the image's aspect ratio is preserved.
+func (f Filter) Resize(img image.Image, width, height int) image.Image {
+	var fn, ok = interpolators[f]
+	if !ok {
+		fn = interpolators[DefaultFilter]
+	}
+	return resize.Resize(uint(width), uint(height), img, fn)
+}
@@ -0,0 +1,26 @@
+package transform
+
+import (
+	"image"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	var f, err = ParseFilter("Lanczos3")
+	assert.NilError(err, "parsing a valid filter", t)
+	assert.Equal(FilterLanczos3, f, "names are case-insensitive", t)
+
+	_, err = ParseFilter("bicubic")
+	assert.True(err != nil, "unknown filter", t)
+}
+
+func TestFilterResize(t *testing.T) {
+	var src = image.NewGray(image.Rect(0, 0, 100, 50))
+	for _, f := range []Filter{FilterNearest, FilterBilinear, FilterCatmullRom, FilterLanczos3, ""} {
+		var b = f.Resize(src, 40, 0).Bounds()
+		assert.Equal(40, b.Dx(), string(f)+" width", t)
+		assert.Equal(20, b.Dy(), string(f)+" height preserves aspect ratio", t)
+	}
+}
@@ -0,0 +1,48 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// edge returns a gray image which is dark on the left and light on the right
+func edge() *image.Gray {
+	var img = image.NewGray(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			var v uint8 = 64
+			if x >= 5 {
+				v = 192
+			}
+			img.SetGray(x, y, color.Gray{v})
+		}
+	}
+	return img
+}
+
+func TestUnsharpMask(t *testing.T) {
+	var src = edge()
+	assert.Equal(image.Image(src), UnsharpMask{}.Apply(src), "disabled mask returns the image", t)
+
+	var out = UnsharpMask{Amount: 1, Radius: 1}.Apply(src).(*image.Gray)
+	assert.True(out.GrayAt(4, 5).Y < 64, "dark side of the edge gets darker", t)
+	assert.True(out.GrayAt(5, 5).Y > 192, "light side of the edge gets lighter", t)
+	assert.Equal(uint8(64), out.GrayAt(0, 5).Y, "flat areas are unchanged", t)
+	assert.Equal(uint8(64), src.GrayAt(4, 5).Y, "source isn't modified", t)
+
+	out = UnsharpMask{Amount: 1, Radius: 1, Threshold: 255}.Apply(src).(*image.Gray)
+	assert.Equal(uint8(64), out.GrayAt(4, 5).Y, "differences under the threshold are ignored", t)
+}
+
+func TestUnsharpMaskRGBA(t *testing.T) {
+	var src = image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range src.Pix {
+		src.Pix[i] = 100
+	}
+	var out = UnsharpMask{Amount: 1, Radius: 1}.Apply(src).(*image.RGBA)
+	assert.Equal(uint8(100), out.Pix[3], "alpha is copied", t)
+	assert.Equal(uint8(100), out.Pix[0], "flat color is unchanged", t)
+}